@@ -0,0 +1,152 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Every change bffnt nudge makes to a glyph is appended to a history next to
+// the adjustments file as it's made, saved or not. Entries are never changed
+// or removed, an undo is one more entry that puts the old adjustment back.
+// Every entry has the adjustment the glyph ended up with, so replaying the
+// history in order gets every glyph to where the last session left it.
+type AdjustmentEdit struct {
+	Op     string          `yaml:"op"` // edit, undo or redo
+	Time   string          `yaml:"time"`
+	Font   string          `yaml:"font"`
+	Scale  float64         `yaml:"scale"`
+	Char   string          `yaml:"char"` // see charKey
+	Before GlyphAdjustment `yaml:"before"`
+	After  GlyphAdjustment `yaml:"after"`
+}
+
+// adjustments.yaml keeps its history in adjustments.history.yaml
+func adjustmentHistoryFile(adjustmentsFile string) string {
+	return strings.TrimSuffix(adjustmentsFile, filepath.Ext(adjustmentsFile)) + ".history.yaml"
+}
+
+// The history is a yaml list, nil if there's none yet
+func readAdjustmentHistory(filename string) []AdjustmentEdit {
+	raw, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	handleErr(err)
+
+	var edits []AdjustmentEdit
+	handleErr(yaml.Unmarshal(raw, &edits))
+	for i, edit := range edits {
+		if _, err := parseCharFlag(edit.Char); err != nil {
+			handleErr(fmt.Errorf("%s: entry %d: %v", filename, i+1, err))
+		}
+	}
+	return edits
+}
+
+// Appends one list item, the file stays a valid yaml list
+func appendAdjustmentEdit(filename string, edit AdjustmentEdit) {
+	raw, err := yaml.Marshal([]AdjustmentEdit{edit})
+	handleErr(err)
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	handleErr(err)
+	_, err = file.Write(raw)
+	handleErr(err)
+	handleErr(file.Close())
+}
+
+// Puts every glyph of the history at its last adjustment. Glyphs the history
+// never touched keep theirs.
+func (file *GlyphAdjustmentsFile) replay(edits []AdjustmentEdit) {
+	for _, edit := range edits {
+		char, err := parseCharFlag(edit.Char)
+		handleErr(err)
+		file.set(edit.Font, edit.Scale).put(char, edit.After)
+	}
+}
+
+// Undo and redo of a session. The stacks are rebuilt from the history so
+// undoing carries on where the last session stopped, only the edits of the
+// session's font and scale count.
+type adjustmentHistory struct {
+	filename string
+	fontName string
+	scale    float64
+
+	undos []AdjustmentEdit // the edits as they were made
+	redos []AdjustmentEdit
+}
+
+func newAdjustmentHistory(filename string, fontName string, scale float64) *adjustmentHistory {
+	history := &adjustmentHistory{filename: filename, fontName: fontName, scale: scale}
+	for _, edit := range readAdjustmentHistory(filename) {
+		if edit.Font == fontName && edit.Scale == scale {
+			history.push(edit)
+		}
+	}
+	return history
+}
+
+func (history *adjustmentHistory) push(edit AdjustmentEdit) {
+	switch edit.Op {
+	case "edit":
+		history.undos = append(history.undos, edit)
+		history.redos = nil
+	case "undo":
+		if last := len(history.undos) - 1; last >= 0 {
+			history.redos = append(history.redos, history.undos[last])
+			history.undos = history.undos[:last]
+		}
+	case "redo":
+		if last := len(history.redos) - 1; last >= 0 {
+			history.undos = append(history.undos, history.redos[last])
+			history.redos = history.redos[:last]
+		}
+	}
+}
+
+func (history *adjustmentHistory) record(op string, char rune, before GlyphAdjustment, after GlyphAdjustment) AdjustmentEdit {
+	edit := AdjustmentEdit{
+		Op:     op,
+		Time:   time.Now().UTC().Format(time.RFC3339),
+		Font:   history.fontName,
+		Scale:  history.scale,
+		Char:   charKey(char),
+		Before: before,
+		After:  after,
+	}
+	appendAdjustmentEdit(history.filename, edit)
+	history.push(edit)
+	return edit
+}
+
+func (history *adjustmentHistory) edit(char rune, before GlyphAdjustment, after GlyphAdjustment) {
+	history.record("edit", char, before, after)
+}
+
+// Returns the undone edit's character and the adjustment it goes back to,
+// false when there's nothing to undo
+func (history *adjustmentHistory) undo() (rune, GlyphAdjustment, bool) {
+	if len(history.undos) == 0 {
+		return 0, GlyphAdjustment{}, false
+	}
+	last := history.undos[len(history.undos)-1]
+	char, _ := parseCharFlag(last.Char)
+	history.record("undo", char, last.After, last.Before)
+	return char, last.Before, true
+}
+
+func (history *adjustmentHistory) redo() (rune, GlyphAdjustment, bool) {
+	if len(history.redos) == 0 {
+		return 0, GlyphAdjustment{}, false
+	}
+	last := history.redos[len(history.redos)-1]
+	char, _ := parseCharFlag(last.Char)
+	history.record("redo", char, last.Before, last.After)
+	return char, last.After, true
+}
@@ -0,0 +1,525 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Width tuning that depends on more than a single character, e.g. every
+// capital 2 pixels tighter at 2x, kept as rules in a yaml file instead of code
+// like adjustBotwCaptionWidth. A rule's when is an expression and its do is
+// statements, both in Go syntax, see rules.yaml:
+//
+//	rules:
+//	  - name: tighter capitals
+//	    when: scale == 2 && upper(char) && is(char, "Latin")
+//	    do: charWidth -= 2
+//	  - when: font == "Caption" && among(char, ".,:;")
+//	    do: |
+//	      leftWidth++
+//	      if charWidth > glyphWidth { charWidth = glyphWidth }
+//
+// The variables are char, index, font, scale, leftWidth, glyphWidth,
+// charWidth, cellWidth, cellHeight and baseline, the widths can be assigned.
+// A glyph several characters map to matches when any of them does, char is
+// the first that does. Rules run in order after the manual adjustments and
+// before the nudges of -adjustments.
+type AdjustmentRule struct {
+	Name string `yaml:"name"` // for errors, defaults to the rule's number
+	When string `yaml:"when"` // empty matches every glyph
+	Do   string `yaml:"do"`
+
+	when ast.Expr
+	do   []ast.Stmt
+}
+
+type AdjustmentRulesFile struct {
+	Rules []AdjustmentRule `yaml:"rules"`
+}
+
+// Variables a rule can assign. What they end up as is rounded and clamped to
+// the width's field, with a warning for the ones that don't fit.
+var ruleWidths = map[string]bool{
+	"leftWidth":  true,
+	"glyphWidth": true,
+	"charWidth":  true,
+}
+
+func readAdjustmentRules(filename string) []AdjustmentRule {
+	raw, err := ioutil.ReadFile(filename)
+	handleErr(err)
+
+	var file AdjustmentRulesFile
+	handleErr(yaml.Unmarshal(raw, &file))
+	for i := range file.Rules {
+		rule := &file.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if err := rule.parse(); err != nil {
+			handleErr(fmt.Errorf("%s: %s: %v", filename, rule.Name, err))
+		}
+	}
+
+	return file.Rules
+}
+
+func (rule *AdjustmentRule) parse() error {
+	if strings.TrimSpace(rule.When) != "" {
+		when, err := parser.ParseExpr(rule.When)
+		if err != nil {
+			return fmt.Errorf("when: %v", err)
+		}
+		rule.when = when
+	}
+	if strings.TrimSpace(rule.Do) == "" {
+		return fmt.Errorf("do is missing")
+	}
+
+	// the statements are parsed as the body of a function
+	source := "package rules\nfunc _() {\n" + rule.Do + "\n}"
+	file, err := parser.ParseFile(token.NewFileSet(), "do", source, 0)
+	if err != nil {
+		return fmt.Errorf("do: %v", err)
+	}
+	rule.do = file.Decls[0].(*ast.FuncDecl).Body.List
+	return nil
+}
+
+// Variables of a glyph while its rules run. Numbers are float64.
+type ruleScope map[string]interface{}
+
+var ruleFunctions = map[string]func(args []interface{}) (interface{}, error){
+	"upper":  charPredicate(unicode.IsUpper),
+	"lower":  charPredicate(unicode.IsLower),
+	"letter": charPredicate(unicode.IsLetter),
+	"digit":  charPredicate(unicode.IsDigit),
+	"punct":  charPredicate(unicode.IsPunct),
+	"space":  charPredicate(unicode.IsSpace),
+	"mark":   charPredicate(unicode.IsMark),
+	// a script like "Latin" or a category like "Lu", see unicode.Scripts
+	"is": func(args []interface{}) (interface{}, error) {
+		char, name, err := charAndString(args)
+		if err != nil {
+			return nil, err
+		}
+		table, found := unicode.Scripts[name]
+		if !found {
+			table, found = unicode.Categories[name]
+		}
+		if !found {
+			return nil, fmt.Errorf("%q is no unicode script or category", name)
+		}
+		return unicode.Is(table, char), nil
+	},
+	"among": func(args []interface{}) (interface{}, error) {
+		char, chars, err := charAndString(args)
+		if err != nil {
+			return nil, err
+		}
+		return strings.ContainsRune(chars, char), nil
+	},
+	"min":   numberFunction(math.Min),
+	"max":   numberFunction(math.Max),
+	"abs":   numberFunction(math.Abs),
+	"round": numberFunction(math.Round),
+	"floor": numberFunction(math.Floor),
+	"ceil":  numberFunction(math.Ceil),
+}
+
+func charPredicate(predicate func(rune) bool) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("takes a character")
+		}
+		char, ok := args[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("takes a character, not %v", args[0])
+		}
+		return predicate(rune(char)), nil
+	}
+}
+
+func charAndString(args []interface{}) (rune, string, error) {
+	if len(args) == 2 {
+		char, isChar := args[0].(float64)
+		value, isString := args[1].(string)
+		if isChar && isString {
+			return rune(char), value, nil
+		}
+	}
+	return 0, "", fmt.Errorf("takes a character and a string")
+}
+
+// Functions of one number, or of two like math.Min
+func numberFunction(function interface{}) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		numbers := make([]float64, len(args))
+		for i, arg := range args {
+			number, ok := arg.(float64)
+			if !ok {
+				return nil, fmt.Errorf("takes numbers, not %v", arg)
+			}
+			numbers[i] = number
+		}
+		switch f := function.(type) {
+		case func(float64) float64:
+			if len(numbers) == 1 {
+				return f(numbers[0]), nil
+			}
+		case func(float64, float64) float64:
+			if len(numbers) >= 2 {
+				res := numbers[0]
+				for _, number := range numbers[1:] {
+					res = f(res, number)
+				}
+				return res, nil
+			}
+		}
+		return nil, fmt.Errorf("wrong number of arguments")
+	}
+}
+
+func (scope ruleScope) eval(expr ast.Expr) (interface{}, error) {
+	switch expr := expr.(type) {
+	case *ast.BasicLit:
+		switch expr.Kind {
+		case token.INT:
+			value, err := strconv.ParseInt(expr.Value, 0, 64)
+			return float64(value), err
+		case token.FLOAT:
+			return strconv.ParseFloat(expr.Value, 64)
+		case token.CHAR:
+			value, _, _, err := strconv.UnquoteChar(expr.Value[1:len(expr.Value)-1], '\'')
+			return float64(value), err
+		case token.STRING:
+			return strconv.Unquote(expr.Value)
+		}
+	case *ast.Ident:
+		switch expr.Name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		if value, found := scope[expr.Name]; found {
+			return value, nil
+		}
+		return nil, fmt.Errorf("unknown variable %s", expr.Name)
+	case *ast.ParenExpr:
+		return scope.eval(expr.X)
+	case *ast.UnaryExpr:
+		value, err := scope.eval(expr.X)
+		if err != nil {
+			return nil, err
+		}
+		number, isNumber := value.(float64)
+		boolean, isBool := value.(bool)
+		switch {
+		case expr.Op == token.SUB && isNumber:
+			return -number, nil
+		case expr.Op == token.ADD && isNumber:
+			return number, nil
+		case expr.Op == token.NOT && isBool:
+			return !boolean, nil
+		}
+		return nil, fmt.Errorf("can't use %s on %v", expr.Op, value)
+	case *ast.BinaryExpr:
+		return scope.evalBinary(expr)
+	case *ast.CallExpr:
+		name, ok := expr.Fun.(*ast.Ident)
+		if !ok || ruleFunctions[name.Name] == nil {
+			return nil, fmt.Errorf("unknown function %s", nodeName(expr.Fun))
+		}
+		args := make([]interface{}, len(expr.Args))
+		for i, arg := range expr.Args {
+			value, err := scope.eval(arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = value
+		}
+		value, err := ruleFunctions[name.Name](args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name.Name, err)
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("%s isn't supported", nodeName(expr))
+}
+
+// Short name of a syntax node for errors
+func nodeName(node ast.Node) string {
+	if ident, ok := node.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
+
+func (scope ruleScope) evalBinary(expr *ast.BinaryExpr) (interface{}, error) {
+	x, err := scope.eval(expr.X)
+	if err != nil {
+		return nil, err
+	}
+	// && and || don't look at the right side when the left decides
+	if expr.Op == token.LAND || expr.Op == token.LOR {
+		left, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs true or false, not %v", expr.Op, x)
+		}
+		if left == (expr.Op == token.LOR) {
+			return left, nil
+		}
+		y, err := scope.eval(expr.Y)
+		if right, ok := y.(bool); ok || err != nil {
+			return right, err
+		}
+		return nil, fmt.Errorf("%s needs true or false, not %v", expr.Op, y)
+	}
+	y, err := scope.eval(expr.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	switch expr.Op {
+	case token.EQL:
+		return x == y, nil
+	case token.NEQ:
+		return x != y, nil
+	}
+	if a, ok := x.(string); ok {
+		if b, ok := y.(string); ok {
+			switch expr.Op {
+			case token.ADD:
+				return a + b, nil
+			case token.LSS:
+				return a < b, nil
+			case token.GTR:
+				return a > b, nil
+			}
+		}
+	}
+	a, isNumber := x.(float64)
+	b, bothNumbers := y.(float64)
+	if !isNumber || !bothNumbers {
+		return nil, fmt.Errorf("can't use %s on %v and %v", expr.Op, x, y)
+	}
+	var result float64
+	switch expr.Op {
+	case token.ADD:
+		result = a + b
+	case token.SUB:
+		result = a - b
+	case token.MUL:
+		result = a * b
+	case token.QUO:
+		result = a / b
+	case token.REM:
+		result = math.Mod(a, b)
+	}
+	switch expr.Op {
+	case token.ADD, token.SUB, token.MUL, token.QUO, token.REM:
+		// a division by zero would end up as a width of 0 or the field's limit
+		if math.IsNaN(result) || math.IsInf(result, 0) {
+			return nil, fmt.Errorf("%v %s %v isn't a number", a, expr.Op, b)
+		}
+		return result, nil
+	case token.LSS:
+		return a < b, nil
+	case token.LEQ:
+		return a <= b, nil
+	case token.GTR:
+		return a > b, nil
+	case token.GEQ:
+		return a >= b, nil
+	}
+	return nil, fmt.Errorf("%s isn't supported", expr.Op)
+}
+
+// Operators of assignments like charWidth -= 2
+var ruleAssignOperators = map[token.Token]token.Token{
+	token.ADD_ASSIGN: token.ADD,
+	token.SUB_ASSIGN: token.SUB,
+	token.MUL_ASSIGN: token.MUL,
+	token.QUO_ASSIGN: token.QUO,
+}
+
+func (scope ruleScope) assign(target ast.Expr, value interface{}) error {
+	ident, ok := target.(*ast.Ident)
+	if !ok {
+		return fmt.Errorf("can't assign to %s", nodeName(target))
+	}
+	if !ruleWidths[ident.Name] {
+		return fmt.Errorf("%s can't be assigned, only leftWidth, glyphWidth and charWidth", ident.Name)
+	}
+	if _, ok := value.(float64); !ok {
+		return fmt.Errorf("%s has to be a number, not %v", ident.Name, value)
+	}
+	scope[ident.Name] = value
+	return nil
+}
+
+func (scope ruleScope) exec(statements []ast.Stmt) error {
+	for _, statement := range statements {
+		switch statement := statement.(type) {
+		case *ast.AssignStmt:
+			if len(statement.Lhs) != 1 || len(statement.Rhs) != 1 {
+				return fmt.Errorf("assign one variable at a time")
+			}
+			value := statement.Rhs[0]
+			if operator, found := ruleAssignOperators[statement.Tok]; found {
+				value = &ast.BinaryExpr{X: statement.Lhs[0], Op: operator, Y: value}
+			} else if statement.Tok != token.ASSIGN {
+				return fmt.Errorf("%s isn't supported", statement.Tok)
+			}
+			result, err := scope.eval(value)
+			if err == nil {
+				err = scope.assign(statement.Lhs[0], result)
+			}
+			if err != nil {
+				return err
+			}
+		case *ast.IncDecStmt:
+			operator := token.ADD
+			if statement.Tok == token.DEC {
+				operator = token.SUB
+			}
+			result, err := scope.eval(&ast.BinaryExpr{X: statement.X, Op: operator, Y: &ast.BasicLit{Kind: token.INT, Value: "1"}})
+			if err == nil {
+				err = scope.assign(statement.X, result)
+			}
+			if err != nil {
+				return err
+			}
+		case *ast.IfStmt:
+			if statement.Init != nil {
+				return fmt.Errorf("if statements can't have an init statement")
+			}
+			value, err := scope.eval(statement.Cond)
+			if err != nil {
+				return err
+			}
+			condition, ok := value.(bool)
+			if !ok {
+				return fmt.Errorf("if needs true or false, not %v", value)
+			}
+			if condition {
+				err = scope.exec(statement.Body.List)
+			} else if statement.Else != nil {
+				err = scope.exec([]ast.Stmt{statement.Else})
+			}
+			if err != nil {
+				return err
+			}
+		case *ast.BlockStmt:
+			if err := scope.exec(statement.List); err != nil {
+				return err
+			}
+		case *ast.EmptyStmt:
+		default:
+			return fmt.Errorf("%s isn't supported", nodeName(statement))
+		}
+	}
+	return nil
+}
+
+// Whether the rule applies to the glyph, char is set to the first of chars it
+// applies to. Rules without a when apply to every glyph with its first char.
+func (rule *AdjustmentRule) matches(scope ruleScope, chars []rune) (bool, error) {
+	if len(chars) == 0 {
+		chars = []rune{-1} // unmapped glyphs have no character
+	}
+	if rule.when == nil {
+		scope["char"] = float64(chars[0])
+		return true, nil
+	}
+	for _, char := range chars {
+		scope["char"] = float64(char)
+		value, err := scope.eval(rule.when)
+		if err != nil {
+			return false, err
+		}
+		matched, ok := value.(bool)
+		if !ok {
+			return false, fmt.Errorf("when has to be true or false, not %v", value)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Runs the rules on every glyph, returns the amount of glyphs they changed.
+// Widths that don't fit their field are clamped with a warning.
+func (b *BFFNT) applyAdjustmentRules(rules []AdjustmentRule, fontName string, scale float64) int {
+	changed, clamped := b.adjustByRules(rules, fontName, scale)
+	warnClampedMetrics("after the adjustment rules", clamped)
+
+	return changed
+}
+
+func (b *BFFNT) adjustByRules(rules []AdjustmentRule, fontName string, scale float64) (changed int, clamped clampedMetrics) {
+	for index := 0; index < b.glyphCount(); index++ {
+		glyph, found := b.glyphInfoAt(index)
+		if !found {
+			continue
+		}
+		before := *glyph
+		for i := range rules {
+			rule := &rules[i]
+			scope := ruleScope{
+				"index":      float64(index),
+				"font":       fontName,
+				"scale":      scale,
+				"leftWidth":  float64(glyph.LeftWidth),
+				"glyphWidth": float64(glyph.GlyphWidth),
+				"charWidth":  float64(glyph.CharWidth),
+				"cellWidth":  float64(b.TGLP.CellWidth),
+				"cellHeight": float64(b.TGLP.CellHeight),
+				"baseline":   float64(b.TGLP.BaselinePosition),
+			}
+			matched, err := rule.matches(scope, b.RunesForIndex(uint16(index)))
+			if err == nil && matched {
+				err = scope.exec(rule.do)
+			}
+			if err != nil {
+				handleErr(fmt.Errorf("%s: %s: %v", rule.Name, b.glyphLabel(index), err))
+			}
+			if !matched {
+				continue
+			}
+
+			// far out of range values are clamped before they become an int
+			width := func(name string) int {
+				return int(math.Max(math.MinInt32, math.Min(math.MaxInt32, math.Round(scope[name].(float64)))))
+			}
+			warnUnless := func(fits bool, name string) {
+				if !fits {
+					clamped.add("%s: %s %s %g", rule.Name, b.glyphLabel(index), name, scope[name])
+				}
+			}
+			var fits bool
+			glyph.LeftWidth, fits = clampToLeftWidth(width("leftWidth"))
+			warnUnless(fits, "leftWidth")
+			glyph.GlyphWidth, fits = clampToGlyphWidth(width("glyphWidth"))
+			warnUnless(fits, "glyphWidth")
+			glyph.CharWidth, fits = clampToCharWidth(width("charWidth"))
+			warnUnless(fits, "charWidth")
+		}
+		if *glyph != before {
+			changed++
+		}
+	}
+
+	return changed, clamped
+}
@@ -0,0 +1,178 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Nintendo's rasterizer has its own edge falloff, rendered glyphs next to
+// untouched ones look a bit softer or harder depending on the font file. A
+// tone curve maps every rendered alpha value to a new one to make up for it.
+// The curve is given as
+//
+//	1.4              gamma, values above 1 make edges fall off faster
+//	64:40,192:200    points between which alpha is interpolated, 0:0 and
+//	                 255:255 are implied
+//	auto             fit to the edges of the original sheets, see fitAlphaCurve
+type alphaCurve [256]uint8
+
+const AUTO_ALPHA_CURVE = "auto"
+
+// Alpha value counts of the edge pixels of a sheet. Fully transparent and
+// fully opaque pixels are left out: how many of them there are depends on the
+// scale, the shape of the falloff in between doesn't.
+type alphaHistogram [256]int
+
+func identityAlphaCurve() alphaCurve {
+	var curve alphaCurve
+	for i := range curve {
+		curve[i] = uint8(i)
+	}
+	return curve
+}
+
+func parseAlphaCurve(spec string) (alphaCurve, error) {
+	if gamma, err := strconv.ParseFloat(spec, 64); err == nil {
+		if gamma <= 0 {
+			return alphaCurve{}, fmt.Errorf("alpha curve gamma has to be above 0, got %g", gamma)
+		}
+		var curve alphaCurve
+		for i := range curve {
+			curve[i] = uint8(math.Round(255 * math.Pow(float64(i)/255, gamma)))
+		}
+		return curve, nil
+	}
+
+	points := [][2]int{{0, 0}, {255, 255}}
+	for _, point := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(point), ":")
+		if len(parts) != 2 {
+			return alphaCurve{}, fmt.Errorf("alpha curve %q has to be a gamma, auto or points like 64:40,192:200", spec)
+		}
+		in, inErr := strconv.Atoi(parts[0])
+		out, outErr := strconv.Atoi(parts[1])
+		if inErr != nil || outErr != nil || in < 0 || in > 255 || out < 0 || out > 255 {
+			return alphaCurve{}, fmt.Errorf("alpha curve point %q has to be two values from 0 to 255", point)
+		}
+		points = append(points, [2]int{in, out})
+	}
+	// later points win over the implied ones
+	sort.SliceStable(points, func(i, j int) bool { return points[i][0] < points[j][0] })
+	deduped := make([][2]int, 0, len(points))
+	for _, point := range points {
+		if last := len(deduped) - 1; last >= 0 && deduped[last][0] == point[0] {
+			deduped[last] = point
+			continue
+		}
+		deduped = append(deduped, point)
+	}
+
+	var curve alphaCurve
+	for i := 1; i < len(deduped); i++ {
+		from, to := deduped[i-1], deduped[i]
+		for in := from[0]; in <= to[0]; in++ {
+			t := float64(in-from[0]) / float64(to[0]-from[0])
+			curve[in] = uint8(math.Round(float64(from[1]) + t*float64(to[1]-from[1])))
+		}
+	}
+
+	return curve, nil
+}
+
+func (curve *alphaCurve) apply(img *image.Alpha) {
+	for i, alpha := range img.Pix {
+		img.Pix[i] = curve[alpha]
+	}
+}
+
+func (histogram *alphaHistogram) addAlpha(alpha uint8) {
+	if alpha > 0 && alpha < 255 {
+		histogram[alpha]++
+	}
+}
+
+func (histogram alphaHistogram) total() int {
+	total := 0
+	for _, count := range histogram {
+		total += count
+	}
+	return total
+}
+
+func alphaHistogramOf(img *image.Alpha) alphaHistogram {
+	var histogram alphaHistogram
+	for _, alpha := range img.Pix {
+		histogram.addAlpha(alpha)
+	}
+	return histogram
+}
+
+// Edge histogram of the original glyphs, decoding the sheets if they aren't
+// already
+func (tglp TGLP) edgeAlphaHistogram() (alphaHistogram, error) {
+	if tglp.SheetImageFormat != IMAGE_FORMAT_A8 && tglp.SheetImageFormat != IMAGE_FORMAT_BC4 {
+		return alphaHistogram{}, fmt.Errorf("sheet image format %d can not be decoded, the alpha curve can't be fit to it", tglp.SheetImageFormat)
+	}
+	if len(tglp.SheetData) != int(tglp.NumOfSheets) {
+		tglp.DecodeSheets()
+	}
+
+	var histogram alphaHistogram
+	for i := range tglp.SheetData {
+		pix := tglp.SheetData[i].Pix
+		for j := 3; j < len(pix); j += 4 {
+			histogram.addAlpha(pix[j])
+		}
+	}
+	return histogram, nil
+}
+
+// Histogram matching: every edge alpha of the rendered glyphs is mapped to
+// the original edge alpha at the same point of the distribution, so the
+// rendered edges fall off like Nintendo's. The curve only ever rises.
+func fitAlphaCurve(original alphaHistogram, rendered alphaHistogram) alphaCurve {
+	curve := identityAlphaCurve()
+	originalTotal, renderedTotal := original.total(), rendered.total()
+	if originalTotal == 0 || renderedTotal == 0 {
+		return curve
+	}
+
+	var originalCDF [256]float64
+	seen := 0
+	for i, count := range original {
+		seen += count
+		originalCDF[i] = float64(seen) / float64(originalTotal)
+	}
+
+	seen = 0
+	matched := 1
+	for in := 1; in < 255; in++ {
+		seen += rendered[in]
+		fraction := float64(seen) / float64(renderedTotal)
+		for matched < 254 && originalCDF[matched] < fraction {
+			matched++
+		}
+		curve[in] = uint8(matched)
+	}
+
+	return curve
+}
+
+// Maps the rendered sheet's alpha through the curve of an upscale run
+func (b *BFFNT) applyAlphaCurve(sheet *image.Alpha, spec string, original *alphaHistogram) {
+	var curve alphaCurve
+	if spec == AUTO_ALPHA_CURVE {
+		curve = fitAlphaCurve(*original, alphaHistogramOf(sheet))
+		b.Log.debugf("fitted alpha curve: %v\n", curve)
+		fmt.Printf("fitted the alpha curve to the original edges, 64 -> %d, 128 -> %d, 192 -> %d\n", curve[64], curve[128], curve[192])
+	} else {
+		var err error
+		curve, err = parseAlphaCurve(spec)
+		handleErr(err)
+	}
+	curve.apply(sheet)
+}
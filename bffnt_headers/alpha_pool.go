@@ -0,0 +1,62 @@
+package bffnt_headers
+
+import (
+	"image"
+	"sync"
+)
+
+// Keeps the alpha images sheets are rendered into once they are written, so
+// the next sheet of the same size, e.g. the next font of a batch or the next
+// bench run, reuses the buffer instead of allocating another few megabytes.
+// Images are keyed by size only, a band in the middle of a sheet reuses the
+// buffer of a band further up. Safe to use from every worker at once.
+type alphaBufferPool struct {
+	mu     sync.Mutex
+	free   map[image.Point][]*image.Alpha
+	allocs int
+	reuses int
+}
+
+var alphaBuffers = newAlphaBufferPool()
+
+func newAlphaBufferPool() *alphaBufferPool {
+	return &alphaBufferPool{free: make(map[image.Point][]*image.Alpha)}
+}
+
+// A fully transparent image with the given bounds, same as image.NewAlpha
+func (pool *alphaBufferPool) get(bounds image.Rectangle) *image.Alpha {
+	size := bounds.Size()
+	pool.mu.Lock()
+	free := pool.free[size]
+	if len(free) == 0 {
+		pool.allocs++
+		pool.mu.Unlock()
+		return image.NewAlpha(bounds)
+	}
+	img := free[len(free)-1]
+	pool.free[size] = free[:len(free)-1]
+	pool.reuses++
+	pool.mu.Unlock()
+
+	// the stride only depends on the width, moving the image is just a
+	// matter of changing its bounds
+	img.Rect = bounds
+	for i := range img.Pix {
+		img.Pix[i] = 0
+	}
+	return img
+}
+
+// Hands images back once nothing reads them anymore. Images not made by get
+// are fine too as long as they own their pixels, sub images share them.
+func (pool *alphaBufferPool) put(imgs ...*image.Alpha) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for _, img := range imgs {
+		if img == nil || len(img.Pix) != img.Rect.Dx()*img.Rect.Dy() || img.Stride != img.Rect.Dx() {
+			continue
+		}
+		size := img.Rect.Size()
+		pool.free[size] = append(pool.free[size], img)
+	}
+}
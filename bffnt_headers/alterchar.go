@@ -0,0 +1,176 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The game draws FINF's alter char glyph for every character the CMAPs don't
+// map. Pointing it at a visible glyph like '?' or '□' makes missing
+// characters easy to spot instead of silently drawing whatever glyph the
+// index happens to be.
+
+// Reads a single character or a U+XXXX code point
+func parseCharFlag(value string) (rune, error) {
+	if strings.HasPrefix(strings.ToUpper(value), "U+") {
+		code, err := strconv.ParseUint(value[2:], 16, 16)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a code point: %v", value, err)
+		}
+		return rune(code), nil
+	}
+	if utf8.RuneCountInString(value) != 1 {
+		return 0, fmt.Errorf("%q has to be a single character or a code point like U+25A1", value)
+	}
+
+	char, _ := utf8.DecodeRuneInString(value)
+	return char, nil
+}
+
+func (b *BFFNT) SetAlterGlyph(index int) error {
+	if index < 0 || index >= b.glyphCount() {
+		return fmt.Errorf("alter char glyph %d doesn't exist, the font has %d glyphs", index, b.glyphCount())
+	}
+	b.FINF.AlterCharIndex = uint16(index)
+
+	return nil
+}
+
+// Uses the glyph char is mapped to as the alter char
+func (b *BFFNT) SetAlterChar(char rune) error {
+	index, found := b.CharIndex(char)
+	if !found {
+		return fmt.Errorf("%s isn't mapped, it has to be added to the font first", formatChar(uint16(char)))
+	}
+
+	return b.SetAlterGlyph(int(index))
+}
+
+// Replaces the artwork of a glyph with the alpha channel of an image, drawn
+// into the top left of its cell. The glyph's widths follow the image. The
+// sheets have to be decoded.
+func (b *BFFNT) drawImageIntoCell(index int, img image.Image) (clipped bool) {
+	sheet, cell := b.TGLP.CellRect(index)
+	if sheet >= len(b.TGLP.SheetData) {
+		handleErr(fmt.Errorf("glyph %d has no cell in the sheets", index))
+	}
+
+	return b.drawImageInto(&b.TGLP.SheetData[sheet], cell, index, img)
+}
+
+// Same for a sheet that isn't in SheetData (yet), like the one an upscale
+// renders
+func (b *BFFNT) drawImageInto(dst draw.Image, cell image.Rectangle, index int, img image.Image) (clipped bool) {
+	size := img.Bounds().Size()
+	clipped = size.X > cell.Dx() || size.Y > cell.Dy()
+
+	draw.Draw(dst, cell, image.Transparent, image.Point{}, draw.Src)
+	draw.DrawMask(dst, cell, image.White, image.Point{}, img, img.Bounds().Min, draw.Over)
+
+	glyph, _ := b.glyphInfoAt(index)
+	width := clampInt(size.X, 0, cell.Dx())
+	glyph.LeftWidth = 0
+	glyph.GlyphWidth = uint8(width)
+	glyph.CharWidth = uint8(width)
+
+	return clipped
+}
+
+func readImageFile(filename string) image.Image {
+	file, err := os.Open(filename)
+	handleErr(err)
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	handleErr(err)
+
+	return img
+}
+
+// Shows or sets the alter char of a bffnt. The alter char can be added to the
+// font if it's missing and its artwork replaced, either drawn from a font file
+// or taken from a png.
+func alterCharCommand(args []string) {
+	flags := newCommandFlagSet("alterchar", "[flags] font.bffnt")
+	charFlag := flags.String("char", "", "character to use for unmapped characters, e.g. ? or U+25A1")
+	index := flags.Int("index", -1, "glyph index to use for unmapped characters instead of a character")
+	fontFile := flags.String("ttf", "", "draw the alter char from this font, adding it to the bffnt if it's missing")
+	fontSize := flags.Float64("size", 0, "font size in pixels for -ttf. 0 puts the font's ascent on the baseline")
+	pngFile := flags.String("png", "", "replace the alter glyph's artwork with the alpha channel of this image, or an svg drawn to fit the cell")
+	output := flags.String("o", "", "output file. Defaults to <name>_alter.bffnt next to the input")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 || (*charFlag != "" && *index >= 0) || (*fontFile != "" && *pngFile != "") {
+		exitWithUsage(flags)
+	}
+
+	bffntFile := flags.Arg(0)
+	bffnt := flags.readBffnt(bffntFile)
+	fmt.Println("alter char:", bffnt.glyphLabel(int(bffnt.FINF.AlterCharIndex)))
+	if *charFlag == "" && *index < 0 && *pngFile == "" {
+		if *fontFile != "" {
+			exitWithUsage(flags)
+		}
+		return
+	}
+
+	redraw := *fontFile != "" || *pngFile != ""
+	if redraw {
+		// the artwork changes so the sheets get encoded again
+		bffnt.TGLP.DecodeSheets()
+	}
+	switch {
+	case *charFlag != "":
+		char, err := parseCharFlag(*charFlag)
+		handleErr(err)
+		if _, found := bffnt.CharIndex(char); !found && *fontFile != "" {
+			bffnt.AddChars([]rune{char})
+			fmt.Println("added", formatChar(uint16(char)))
+		}
+		handleErr(bffnt.SetAlterChar(char))
+	case *index >= 0:
+		handleErr(bffnt.SetAlterGlyph(*index))
+	}
+
+	alterIndex := int(bffnt.FINF.AlterCharIndex)
+	if redraw {
+		if sheetsAdded := bffnt.TGLP.repackSheets(bffnt.glyphCount()); sheetsAdded > 0 {
+			fmt.Println("added", sheetsAdded, "sheet(s)")
+		}
+	}
+	if *fontFile != "" {
+		*fontFile = findFontFile(*fontFile)
+		runes := bffnt.RunesForIndex(uint16(alterIndex))
+		if len(runes) == 0 {
+			handleErr(fmt.Errorf("glyph %d isn't mapped so there is no character to draw, use -png", alterIndex))
+		}
+		if *fontSize == 0 {
+			*fontSize = baselineFontSize(*fontFile, int(bffnt.TGLP.BaselinePosition), int(bffnt.TGLP.CellHeight))
+		}
+		if len(bffnt.drawCharsIntoCells(*fontFile, *fontSize, runes[:1])) > 0 {
+			fmt.Println("warning: the alter char doesn't fit its cell and is cut off, try a smaller -size")
+		}
+	}
+	if *pngFile != "" {
+		if bffnt.drawImageIntoCell(alterIndex, readGlyphImage(*pngFile, int(bffnt.TGLP.CellHeight))) {
+			fmt.Printf("warning: %s is bigger than the %dx%d cell and is cut off\n", *pngFile, bffnt.TGLP.CellWidth, bffnt.TGLP.CellHeight)
+		}
+	}
+	fmt.Println("new alter char:", bffnt.glyphLabel(alterIndex))
+
+	if *output == "" {
+		name := strings.TrimSuffix(bffntFile, filepath.Ext(bffntFile))
+		*output = name + "_alter" + filepath.Ext(bffntFile)
+	}
+	encoded := bffnt.Encode()
+	handleErr(bffnt.VerifyEncoded(encoded))
+	handleErr(os.WriteFile(*output, encoded, 0644))
+	fmt.Println("wrote", *output)
+}
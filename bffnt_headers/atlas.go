@@ -0,0 +1,106 @@
+package bffnt_headers
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// An atlas describes where every glyph lives in the generated sheets so the
+// textures can be used outside of the BFFNT container. e.g. game engines or
+// shaders that want to sample glyphs directly. Pixel coordinates and UVs have
+// their origin at the top left of the sheet, the same orientation as the png
+// files written by generateTexture.
+type Atlas struct {
+	SheetWidth  int          `json:"sheetWidth"`
+	SheetHeight int          `json:"sheetHeight"`
+	NumOfSheets int          `json:"numOfSheets"`
+	CellWidth   int          `json:"cellWidth"`
+	CellHeight  int          `json:"cellHeight"`
+	Baseline    int          `json:"baseline"`
+	LineFeed    int          `json:"lineFeed"`
+	Glyphs      []AtlasGlyph `json:"glyphs"`
+}
+
+type AtlasGlyph struct {
+	Char  string `json:"char"`
+	Code  uint16 `json:"code"`
+	Index uint16 `json:"index"`
+	Sheet int    `json:"sheet"`
+
+	// Pixel rectangle of the glyph's ink area within the sheet
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// Normalized texture coordinates of the pixel rectangle
+	U0 float64 `json:"u0"`
+	V0 float64 `json:"v0"`
+	U1 float64 `json:"u1"`
+	V1 float64 `json:"v1"`
+
+	// BearingX is the distance from the pen position to the left edge of the
+	// glyph (CWDH LeftWidth). BearingY is the distance from the baseline to
+	// the top of the cell.
+	BearingX int `json:"bearingX"`
+	BearingY int `json:"bearingY"`
+	Advance  int `json:"advance"`
+}
+
+func (b *BFFNT) Atlas() Atlas {
+	sheetWidth := int(b.TGLP.SheetWidth)
+	sheetHeight := int(b.TGLP.SheetHeight)
+
+	atlas := Atlas{
+		SheetWidth:  sheetWidth,
+		SheetHeight: sheetHeight,
+		NumOfSheets: int(b.TGLP.NumOfSheets),
+		CellWidth:   int(b.TGLP.CellWidth),
+		CellHeight:  int(b.TGLP.CellHeight),
+		Baseline:    int(b.TGLP.BaselinePosition),
+		LineFeed:    int(b.FINF.LineFeed),
+		Glyphs:      make([]AtlasGlyph, 0),
+	}
+
+	for _, pair := range b.GlyphIndexes() {
+		glyph, found := findGlyphInfo(b.CWDHs, int(pair.CharIndex))
+		if !found {
+			continue
+		}
+
+		sheet, cell := b.TGLP.CellRect(int(pair.CharIndex))
+		width := int(glyph.GlyphWidth)
+		if width > cell.Dx() {
+			width = cell.Dx()
+		}
+
+		atlasGlyph := AtlasGlyph{
+			Char:     string(rune(pair.CharAscii)),
+			Code:     pair.CharAscii,
+			Index:    pair.CharIndex,
+			Sheet:    sheet,
+			X:        cell.Min.X,
+			Y:        cell.Min.Y,
+			Width:    width,
+			Height:   cell.Dy(),
+			U0:       float64(cell.Min.X) / float64(sheetWidth),
+			V0:       float64(cell.Min.Y) / float64(sheetHeight),
+			U1:       float64(cell.Min.X+width) / float64(sheetWidth),
+			V1:       float64(cell.Max.Y) / float64(sheetHeight),
+			BearingX: int(glyph.LeftWidth),
+			BearingY: int(b.TGLP.BaselinePosition),
+			Advance:  int(glyph.CharWidth),
+		}
+		atlas.Glyphs = append(atlas.Glyphs, atlasGlyph)
+	}
+
+	return atlas
+}
+
+func (b *BFFNT) WriteAtlas(filename string) {
+	jsonBytes, err := json.MarshalIndent(b.Atlas(), "", "  ")
+	handleErr(err)
+
+	err = os.WriteFile(filename, jsonBytes, 0644)
+	handleErr(err)
+}
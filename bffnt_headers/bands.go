@@ -0,0 +1,59 @@
+package bffnt_headers
+
+import (
+	"image"
+)
+
+// Bands a sheet is split into. Fixed instead of following the worker count so
+// the bands, and with them the composited sheet, are the same with any
+// -threads or -workers.
+const RENDER_BANDS = 64
+
+// A horizontal strip of cell rows drawn by one worker into its own image.
+// Glyphs are drawn in the same order as they would be on a single sheet.
+type glyphBand struct {
+	firstCell int // glyph indexes [firstCell, endCell)
+	endCell   int
+	img       *image.Alpha
+	overflows []widthOverflow
+}
+
+// Splits the cells into bands of whole rows. There are more bands than there
+// usually are workers so a band full of wide CJK glyphs doesn't leave the
+// other workers waiting. A band's image reaches a row above and below its
+// cells since glyph ink can spill out of its cell, compositing the bands in
+// order then gives the same sheet as drawing it in one go.
+func cellBands(cellCount int, columnCount int, rowHeight int, sheet image.Rectangle) []*glyphBand {
+	bands := make([]*glyphBand, 0)
+	if cellCount == 0 || columnCount == 0 {
+		return bands
+	}
+
+	rowCount := (cellCount + columnCount - 1) / columnCount
+	rowsPerBand := (rowCount + RENDER_BANDS - 1) / RENDER_BANDS
+	for firstRow := 0; firstRow < rowCount; firstRow += rowsPerBand {
+		endRow := firstRow + rowsPerBand
+		if endRow > rowCount {
+			endRow = rowCount
+		}
+		bounds := image.Rect(sheet.Min.X, (firstRow-1)*rowHeight, sheet.Max.X, (endRow+1)*rowHeight).Intersect(sheet)
+		band := &glyphBand{
+			firstCell: firstRow * columnCount,
+			endCell:   endRow * columnCount,
+			img:       alphaBuffers.get(bounds),
+		}
+		if band.endCell > cellCount {
+			band.endCell = cellCount
+		}
+		bands = append(bands, band)
+	}
+
+	return bands
+}
+
+// Runs draw on every band with a pool of workers and waits for all of them
+func renderBands(bands []*glyphBand, workers int, draw func(band *glyphBand)) {
+	parallelEach(len(bands), workers, func(i int) {
+		draw(bands[i])
+	})
+}
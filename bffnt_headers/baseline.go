@@ -0,0 +1,76 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"sort"
+)
+
+// Glyphs that sit flat on the baseline in the fonts' scripts. Round or
+// pointed bottoms like o or v dip below it a little and descenders go below.
+const BASELINE_CHARS = "HIEnmx"
+
+// NormalS draws a 50% outline around its glyphs, only count the glyph itself.
+// The outline's faint edges and BC4 noise stay below this.
+const SOLID_INK_ALPHA = 192
+
+// The row below the lowest solid ink of a cell, relative to its top
+func inkBottom(sheet *image.NRGBA, cell image.Rectangle, minAlpha uint8) (bottom int, hasInk bool) {
+	for y := cell.Max.Y - 1; y >= cell.Min.Y; y-- {
+		for x := cell.Min.X; x < cell.Max.X; x++ {
+			if sheet.NRGBAAt(x, y).A >= minAlpha {
+				return y - cell.Min.Y + 1, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// Where the flat bottomed glyphs of the original sheets sit in their cells,
+// the median of their ink bottoms. That's where Nintendo's generator put the
+// baseline, which isn't always the BaselinePosition of the header. False when
+// the font has none of BASELINE_CHARS or its sheets can't be decoded.
+func (b *BFFNT) opticalBaseline() (int, bool) {
+	if !knownImageFormat(b.TGLP.SheetImageFormat) {
+		return 0, false
+	}
+	tglp := b.TGLP
+	tglp.DecodeSheets()
+
+	bottoms := make([]int, 0, len(BASELINE_CHARS))
+	for _, char := range BASELINE_CHARS {
+		index, exists := b.CWDHIndexMap[char]
+		if !exists {
+			continue
+		}
+		sheet, cell := tglp.CellRect(index)
+		if sheet >= len(tglp.SheetData) {
+			continue
+		}
+		if bottom, hasInk := inkBottom(&tglp.SheetData[sheet], cell, SOLID_INK_ALPHA); hasInk {
+			bottoms = append(bottoms, bottom)
+		}
+	}
+	if len(bottoms) == 0 {
+		return 0, false
+	}
+	sort.Ints(bottoms)
+
+	return bottoms[len(bottoms)/2], true
+}
+
+// The row of the upscaled cells the glyphs are drawn on: the optical baseline
+// scaled like the other metrics, the header's for fonts it can't be measured
+// in. Has to be called before the font is upscaled.
+func (b *BFFNT) glyphBaseline(scale float64) int {
+	baseline, measured := b.opticalBaseline()
+	if !measured {
+		b.Log.debugf("no glyphs to measure the baseline from, drawing on BaselinePosition %d\n", b.TGLP.BaselinePosition)
+		baseline = int(b.TGLP.BaselinePosition)
+	} else if baseline != int(b.TGLP.BaselinePosition) {
+		fmt.Printf("glyphs sit on row %d of the original cells, not on BaselinePosition %d, drawing them on the measured baseline\n", baseline, b.TGLP.BaselinePosition)
+	}
+
+	return int(scaleMetric(float64(baseline), scale))
+}
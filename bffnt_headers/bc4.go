@@ -0,0 +1,153 @@
+package bffnt_headers
+
+import "encoding/binary"
+
+// BC4 (also known as ATI1 or RGTC1) stores a single channel in blocks of 4x4
+// pixels. Each 8 byte block holds two reference values followed by sixteen 3
+// bit indexes into a palette interpolated from the reference values.
+// https://docs.microsoft.com/en-us/windows/win32/direct3d10/d3d10-graphics-programming-guide-resources-block-compression#bc4
+//
+// Takes the blocks in linear (deswizzled) order and returns one byte per
+// pixel.
+func decodeBC4(blocks []byte, blocksWide int, blocksHigh int) []byte {
+	width := blocksWide * 4
+	pixels := make([]byte, width*blocksHigh*4)
+
+	for by := 0; by < blocksHigh; by++ {
+		for bx := 0; bx < blocksWide; bx++ {
+			blockStart := (by*blocksWide + bx) * 8
+			block := blocks[blockStart : blockStart+8]
+			palette := bc4Palette(block[0], block[1])
+
+			// 48 bits of indexes, little endian
+			var indexBits [8]byte
+			copy(indexBits[:], block[2:8])
+			indexes := binary.LittleEndian.Uint64(indexBits[:])
+
+			for p := 0; p < 16; p++ {
+				paletteIndex := (indexes >> (3 * p)) & 7
+				x := bx*4 + p%4
+				y := by*4 + p/4
+				pixels[y*width+x] = palette[paletteIndex]
+			}
+		}
+	}
+
+	return pixels
+}
+
+func bc4Palette(ref0 uint8, ref1 uint8) [8]uint8 {
+	var palette [8]uint8
+	a0 := int(ref0)
+	a1 := int(ref1)
+	palette[0] = ref0
+	palette[1] = ref1
+
+	if a0 > a1 {
+		for i := 1; i < 7; i++ {
+			palette[i+1] = uint8(((7-i)*a0 + i*a1) / 7)
+		}
+	} else {
+		for i := 1; i < 5; i++ {
+			palette[i+1] = uint8(((5-i)*a0 + i*a1) / 5)
+		}
+		palette[6] = 0
+		palette[7] = 255
+	}
+
+	return palette
+}
+
+// Block rows one worker encodes at a time. A 1024 pixel wide sheet has 256
+// blocks per row, so a tile is a few thousand blocks, enough to be worth
+// handing to a goroutine.
+const BC4_TILE_ROWS = 16
+
+// The opposite of decodeBC4. Takes one byte per pixel, blocksWide*4 pixels
+// wide, and returns the blocks in linear order. Sheets are split into tiles
+// of block rows that are encoded on every worker at once, a 2048x2048 sheet
+// is a quarter of a million blocks.
+func encodeBC4(pixels []byte, blocksWide int, blocksHigh int, workers int) []byte {
+	blocks := make([]byte, blocksWide*blocksHigh*8)
+	width := blocksWide * 4
+
+	tiles := (blocksHigh + BC4_TILE_ROWS - 1) / BC4_TILE_ROWS
+	parallelEach(tiles, workers, func(tile int) {
+		var values [16]uint8
+		endRow := (tile + 1) * BC4_TILE_ROWS
+		if endRow > blocksHigh {
+			endRow = blocksHigh
+		}
+		for by := tile * BC4_TILE_ROWS; by < endRow; by++ {
+			for bx := 0; bx < blocksWide; bx++ {
+				for p := 0; p < 16; p++ {
+					values[p] = pixels[(by*4+p/4)*width+bx*4+p%4]
+				}
+				blockStart := (by*blocksWide + bx) * 8
+				encodeBC4Block(blocks[blockStart:blockStart+8], values)
+			}
+		}
+	})
+
+	return blocks
+}
+
+// Tries both palettes and keeps the closer one. The 8 value palette spans
+// the darkest to the brightest pixel, the 6 value one leaves out fully
+// transparent and fully opaque pixels since it has exact 0 and 255 entries.
+// Glyph edges are mostly those two plus a few antialiased pixels, so the 6
+// value palette usually wins there.
+func encodeBC4Block(block []byte, values [16]uint8) {
+	lo, hi := uint8(255), uint8(0)
+	innerLo, innerHi := uint8(255), uint8(0)
+	for _, value := range values {
+		if value < lo {
+			lo = value
+		}
+		if value > hi {
+			hi = value
+		}
+		if value != 0 && value != 255 {
+			if value < innerLo {
+				innerLo = value
+			}
+			if value > innerHi {
+				innerHi = value
+			}
+		}
+	}
+	if innerLo > innerHi {
+		innerLo, innerHi = 0, 0
+	}
+
+	// ref0 > ref1 picks the 8 value palette, ref0 <= ref1 the 6 value one
+	ref0, ref1 := hi, lo
+	indexes, blockError := bc4Indexes(bc4Palette(hi, lo), values)
+	if sixIndexes, sixError := bc4Indexes(bc4Palette(innerLo, innerHi), values); sixError < blockError {
+		ref0, ref1, indexes = innerLo, innerHi, sixIndexes
+	}
+
+	block[0] = ref0
+	block[1] = ref1
+	var indexBits [8]byte
+	binary.LittleEndian.PutUint64(indexBits[:], indexes)
+	copy(block[2:8], indexBits[:6])
+}
+
+// Nearest palette entry of every pixel as packed 3 bit indexes, and the
+// squared error of the block
+func bc4Indexes(palette [8]uint8, values [16]uint8) (indexes uint64, squaredError int) {
+	for p, value := range values {
+		best, bestError := 0, 1<<30
+		for i, entry := range palette {
+			difference := int(entry) - int(value)
+			if difference*difference < bestError {
+				best, bestError = i, difference*difference
+			}
+		}
+		indexes |= uint64(best) << (3 * p)
+		squaredError += bestError
+	}
+
+	return indexes, squaredError
+}
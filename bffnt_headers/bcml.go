@@ -0,0 +1,50 @@
+package bffnt_headers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// BCML merges mods that are laid out like the game's content folder and
+// described by an info.json. The fonts go into the same Font_EU.sbfarc as in
+// a Cemu graphic pack, BCML picks the changed files out of the archive
+// itself so the mod merges with others that touch Font_EU.sbfarc.
+const BCML_INFO = "info.json"
+
+type BcmlInfo struct {
+	Name        string            `json:"name"`
+	Description string            `json:"desc"`
+	Version     string            `json:"version"`
+	Image       string            `json:"image"`
+	Url         string            `json:"url"`
+	Platform    string            `json:"platform"`
+	Depends     []string          `json:"depends"`
+	Options     map[string]string `json:"options"`
+}
+
+func defaultBcmlInfo() BcmlInfo {
+	return BcmlInfo{
+		Name:        "Upscaled Fonts",
+		Description: "Fonts upscaled by bffnt_upscale",
+		Version:     "1.0.0",
+		Platform:    "wiiu",
+		Depends:     []string{},
+		Options:     map[string]string{},
+	}
+}
+
+// Writes the font into a BCML mod folder, which BCML installs as it is. An
+// info.json that's already there is kept, edit it to name the mod.
+func writeBcmlMod(modDir string, sbfarcFile string, destination fontDestination, encoded []byte) {
+	replaceFontInArchive(modDir, sbfarcFile, destination, encoded)
+
+	infoFile := filepath.Join(modDir, BCML_INFO)
+	if _, err := os.Stat(infoFile); os.IsNotExist(err) {
+		jsonBytes, err := json.MarshalIndent(defaultBcmlInfo(), "", "  ")
+		handleErr(err)
+		handleErr(os.WriteFile(infoFile, jsonBytes, 0644))
+		fmt.Println("wrote", infoFile, "- change its name and description to tell mods apart")
+	}
+}
@@ -0,0 +1,257 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"gopkg.in/yaml.v3"
+)
+
+// Stages bench times, in the order they run. Rendering and converting the
+// sheet are only timed for fonts the replacement font is known for.
+var benchStages = []string{"decode", "upscale", "render", "convert", "encode"}
+
+// Milliseconds every stage took, keyed by stage. Saved with -save so a later
+// run can be compared against it with -compare.
+type benchTimes map[string]float64
+
+func (times benchTimes) total() float64 {
+	total := 0.0
+	for _, stage := range benchStages {
+		total += times[stage]
+	}
+	return total
+}
+
+// A row of the bench table, a single font at a single scale
+type benchResult struct {
+	font  string
+	scale float64
+	times benchTimes
+	err   error
+}
+
+func (result benchResult) key() string {
+	return fmt.Sprintf("%s@%.2f", result.font, result.scale)
+}
+
+// The botw font a corpus file is, e.g. botw/Normal/Normal_00.bffnt is Normal.
+// Empty when it isn't one of the botw fonts the renderer has settings for.
+func benchFontName(file string) string {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	name = strings.TrimSuffix(name, "_00")
+	if _, known := botwFontPresets[name]; !known {
+		return ""
+	}
+	return name
+}
+
+// Runs every stage of an upscale once. The png and the template are encoded
+// in memory, writing them to disk would mostly time the disk. settings is an
+// empty font with the leftover policy and threads to decode with.
+func benchFont(settings BFFNT, raw []byte, botwFontName string, fontFile string, scale float64) benchTimes {
+	times := make(benchTimes)
+	timed := func(stage string, work func()) {
+		start := time.Now()
+		work()
+		times[stage] = float64(time.Since(start)) / float64(time.Millisecond)
+	}
+
+	bffnt := settings
+	timed("decode", func() { bffnt.Decode(raw) })
+	timed("upscale", func() {
+		handleErr(checkSheetLimits(bffnt.TGLP.scaledLayout(scale), bffnt.glyphCount(), scale, "wiiu"))
+		bffnt.Upscale(scale)
+	})
+
+	if botwFontName != "" {
+		// every run starts with an empty cache, otherwise only the first
+		// run measures the glyphs
+		glyphMeasurements = newGlyphMeasurementCache()
+		var sheet *image.Alpha
+		timed("render", func() {
+			sheet, _ = bffnt.renderGlyphSheet(botwFontName, fontFile, scale, upscaleOptions{threads: settings.Threads})
+		})
+		timed("convert", func() {
+			handleErr(pngEncoder.Encode(ioutil.Discard, sheet))
+			bffnt.TGLP.SheetData = []image.NRGBA{*imaging.Clone(sheet)}
+			bffnt.TGLP.EncodeSheetData()
+			alphaBuffers.put(sheet)
+			// the upscale writes a template with blank sheets, don't time
+			// converting the sheet again while encoding
+			bffnt.TGLP.SheetData = nil
+		})
+	}
+
+	timed("encode", func() { bffnt.Encode() })
+	return times
+}
+
+// Keeps the fastest time of every stage. The slower runs are the ones other
+// programs or the garbage collector got in the way of.
+func fastestTimes(runs []benchTimes) benchTimes {
+	fastest := make(benchTimes)
+	for _, times := range runs {
+		for stage, ms := range times {
+			if current, exists := fastest[stage]; !exists || ms < current {
+				fastest[stage] = ms
+			}
+		}
+	}
+	return fastest
+}
+
+func formatBenchTime(times benchTimes, baseline benchTimes, stage string) string {
+	ms, exists := times[stage]
+	if stage == "total" {
+		ms, exists = times.total(), true
+	}
+	if !exists {
+		return "-"
+	}
+
+	cell := fmt.Sprintf("%.1fms", ms)
+	if baseline == nil {
+		return cell
+	}
+	before, existed := baseline[stage]
+	if stage == "total" {
+		before, existed = baseline.total(), true
+	}
+	if existed && before > 0 {
+		cell += fmt.Sprintf(" %+.0f%%", (ms-before)/before*100)
+	}
+	return cell
+}
+
+// A table of every font's stage times. With baselines every time is followed
+// by how much faster (negative) or slower it got since then.
+func formatBenchTable(results []benchResult, baselines map[string]benchTimes) string {
+	columns := append(append([]string{}, benchStages...), "total")
+	width := 10
+	if baselines != nil {
+		width = 15
+	}
+
+	fontWidth := len("font")
+	for _, result := range results {
+		if len(result.font) > fontWidth {
+			fontWidth = len(result.font)
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-*s %6s", fontWidth, "font", "scale")
+	for _, column := range columns {
+		fmt.Fprintf(&sb, " %*s", width, column)
+	}
+	sb.WriteString("\n")
+
+	sum := make(benchTimes)
+	sumBaseline := make(benchTimes)
+	for _, result := range results {
+		fmt.Fprintf(&sb, "%-*s %6.2f", fontWidth, result.font, result.scale)
+		if result.err != nil {
+			fmt.Fprintf(&sb, " %v\n", result.err)
+			continue
+		}
+		baseline := baselines[result.key()]
+		if baselines != nil && baseline == nil {
+			baseline = benchTimes{}
+		}
+		for _, column := range columns {
+			fmt.Fprintf(&sb, " %*s", width, formatBenchTime(result.times, baseline, column))
+		}
+		sb.WriteString("\n")
+
+		for stage, ms := range result.times {
+			sum[stage] += ms
+			if before, exists := baseline[stage]; exists {
+				sumBaseline[stage] += before
+			}
+		}
+	}
+
+	if baselines == nil {
+		sumBaseline = nil
+	}
+	fmt.Fprintf(&sb, "%-*s %6s", fontWidth, "all fonts", "")
+	for _, column := range columns {
+		fmt.Fprintf(&sb, " %*s", width, formatBenchTime(sum, sumBaseline, column))
+	}
+
+	return sb.String()
+}
+
+func benchCommand(args []string) {
+	flags := newCommandFlagSet("bench", "[flags] corpus.yaml")
+	runs := flags.Int("runs", 3, "times every font is run, the fastest time of every stage is kept")
+	scale := flags.Float64("scale", 0, "scale every font is upscaled by. 0 uses the scales of the corpus")
+	fontFile := flags.String("ttf", "", "replacement font file. Defaults to the font picked for every botw font")
+	save := flags.String("save", "", "write the times to this file so later runs can -compare against them")
+	compare := flags.String("compare", "", "show how much every time changed since the run saved to this file")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 || *runs < 1 {
+		exitWithUsage(flags)
+	}
+
+	var baselines map[string]benchTimes
+	if *compare != "" {
+		raw, err := ioutil.ReadFile(*compare)
+		handleErr(err)
+		handleErr(yaml.Unmarshal(raw, &baselines))
+	}
+
+	corpus := readCorpus(flags.Arg(0))
+	scales := corpus.Scales
+	if *scale > 0 || len(scales) == 0 {
+		scales = []float64{resolveScale("1440p", *scale)}
+	}
+	initializeGlyphMaps()
+
+	// fonts run one after the other, running them at the same time would
+	// have them fight over the cores their worker pools use
+	results := make([]benchResult, 0)
+	for _, font := range corpus.fontFiles() {
+		raw, err := ioutil.ReadFile(filepath.Join(corpus.Root, font))
+		handleErr(err)
+		botwFontName := benchFontName(font)
+		for _, scale := range scales {
+			result := benchResult{font: font, scale: scale}
+			result.err = catchPanic(func() {
+				timesOfRuns := make([]benchTimes, 0, *runs)
+				for run := 0; run < *runs; run++ {
+					fontFile := *fontFile
+					if botwFontName != "" {
+						fontFile = resolveFontFile(botwFontName, fontFile)
+					}
+					timesOfRuns = append(timesOfRuns, benchFont(flags.newBffnt(), raw, botwFontName, fontFile, scale))
+				}
+				result.times = fastestTimes(timesOfRuns)
+			})
+			results = append(results, result)
+		}
+	}
+
+	fmt.Println(formatBenchTable(results, baselines))
+
+	if *save != "" {
+		saved := make(map[string]benchTimes)
+		for _, result := range results {
+			if result.err == nil {
+				saved[result.key()] = result.times
+			}
+		}
+		raw, err := yaml.Marshal(saved)
+		handleErr(err)
+		handleErr(os.WriteFile(*save, raw, 0644))
+		fmt.Printf("saved the times of %d font(s) to %s\n", len(saved), *save)
+	}
+}
@@ -1,17 +1,24 @@
 package bffnt_headers
 
 import (
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	"image/png"
+	"image/draw"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"unicode"
 
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
 	"golang.org/x/image/math/fixed"
 )
 
@@ -30,21 +37,61 @@ type BFFNT struct {
 	CMAPs []CMAP
 	KRNG  KRNG
 
+	// Sections after the kerning in file order: the ones a registered
+	// decoder decoded, see RegisterSection, and *UnknownSection for those we
+	// can't decode, kept so they survive a re-encode
+	ExtraSections []CustomSection
+
 	// Map of rune to it's index. Used to find a glyph's CWDH faster
 	CWDHIndexMap map[rune]int
-}
+	charIndexes  []charIndexEntry // sorted, see CharIndex
+	runesByIndex map[uint16][]rune
+
+	validation ValidationResult
+
+	// Debug output of this font and its sections, nil uses DefaultLogger
+	Log *Logger
+
+	// What decoding does with non zero leftover bytes, one of the LEFTOVER_
+	// policies. Empty is LEFTOVER_ERROR.
+	LeftoverPolicy string
 
-var bffntRaw []byte
-var err error
+	// Goroutines decoding and encoding the sheets may use, 0 uses GOMAXPROCS
+	Threads int
+}
 
+// Decodes a complete font. Panics with the section and offset where decoding
+// failed, see DecodePartial for damaged files. Inconsistencies that don't
+// stop decoding are collected in Validation.
 func (b *BFFNT) Decode(bffntRaw []byte) {
-	b.FFNT.Decode(bffntRaw)
-	b.FINF.Decode(bffntRaw)
-	b.TGLP.Decode(bffntRaw)
-	b.CWDHs = DecodeCWDHs(bffntRaw, b.FINF.CWDHOffset)
-	b.CMAPs = DecodeCMAPs(bffntRaw, b.FINF.CMAPOffset)
-	b.KRNG.Decode(bffntRaw)
+	report := b.DecodePartial(bffntRaw)
+	handleErr(report.Err)
+	b.validateFileHeader(len(bffntRaw))
+}
 
+func (b *BFFNT) validateFileHeader(fileSize int) {
+	b.validation.assertEqual("FFNT total file size", int(b.FFNT.TotalFileSize), fileSize)
+	b.validation.assertEqual("FINF TGLP offset", int(b.FINF.TGLPOffset), FFNT_HEADER_SIZE+FINF_HEADER_SIZE+8)
+}
+
+// Every inconsistency found while decoding and encoding the font. Lazily
+// decoded sections are decoded first, most checks need their data.
+func (b *BFFNT) Validation() ValidationResult {
+	b.Load()
+	var res ValidationResult
+	res.merge(b.validation)
+	res.merge(b.TGLP.validation)
+	for _, cwdh := range b.CWDHs {
+		res.merge(cwdh.validation)
+	}
+	for _, cmap := range b.CMAPs {
+		res.merge(cmap.validation)
+	}
+
+	return res
+}
+
+func (b *BFFNT) buildCWDHIndexMap() {
 	b.CWDHIndexMap = make(map[rune]int, 0)
 	for i, glyph := range b.GlyphIndexes() {
 		b.CWDHIndexMap[rune(glyph.CharAscii)] = i
@@ -52,37 +99,147 @@ func (b *BFFNT) Decode(bffntRaw []byte) {
 }
 
 func (b *BFFNT) Encode() []byte {
+	sections := b.encodeSections()
+
+	res := make([]byte, 0, sections.fileSize)
+	res = append(res, b.FFNT.Encode(checkedUint32("file size", int64(sections.fileSize)))...)
+	res = append(res, sections.finf...)
+	res = b.TGLP.appendEncoded(res)
+	for _, sectionRaw := range sections.afterTGLP {
+		res = append(res, sectionRaw...)
+	}
+	b.validation.assertEqual("encoded file size", sections.fileSize, len(res))
+
+	return res
+}
+
+// Same as Encode but writes the font to w section by section, so the whole
+// file never has to be in memory. The FFNT file size is written last by
+// seeking back to the header. Returns the amount of bytes written.
+func (b *BFFNT) EncodeTo(w io.WriteSeeker) (int, error) {
+	sections := b.encodeSections()
+
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	write := func(raw []byte) error {
+		n, err := w.Write(raw)
+		written += n
+		return err
+	}
+
+	if err := write(b.FFNT.Encode(0)); err != nil {
+		return written, err
+	}
+	if err := write(sections.finf); err != nil {
+		return written, err
+	}
+	n, err := b.TGLP.writeEncoded(w)
+	written += n
+	if err != nil {
+		return written, err
+	}
+	for _, sectionRaw := range sections.afterTGLP {
+		if err := write(sectionRaw); err != nil {
+			return written, err
+		}
+	}
+	b.validation.assertEqual("encoded file size", sections.fileSize, written)
+
+	// backpatch the file size now that everything is written
+	fileSizeRaw := make([]byte, 4)
+	binary.BigEndian.PutUint32(fileSizeRaw, checkedUint32("file size", int64(written)))
+	if _, err := w.Seek(start+FFNT_TOTAL_FILE_SIZE_POS, io.SeekStart); err != nil {
+		return written, err
+	}
+	if _, err := w.Write(fileSizeRaw); err != nil {
+		return written, err
+	}
+	_, err = w.Seek(start+int64(written), io.SeekStart)
+
+	return written, err
+}
+
+// Every section but the TGLP encoded and ready to be written. The TGLP's sheets
+// are most of the file, it's only encoded once there is somewhere to put it.
+type encodedSections struct {
+	finf      []byte
+	afterTGLP [][]byte // CWDHs, CMAPs and optional sections in file order
+	fileSize  int
+}
+
+// Offsets are added up as int64 and checked before they're encoded, see
+// checkedUint32. The file size is checked before anything is allocated for it.
+func (b *BFFNT) encodeSections() encodedSections {
+	b.Load()
 	tglpOffset := FFNT_HEADER_SIZE + FINF_HEADER_SIZE + 8
-	tglpRaw := b.TGLP.Encode()
+	tglpSize := b.TGLP.encodedSize()
 
-	cwdhOffset := tglpOffset + len(tglpRaw)
+	cwdhOffset := int(checkedUint32("CWDH offset", int64(tglpOffset)+int64(tglpSize)))
 	cwdhsRaw := EncodeCWDHs(b.CWDHs, cwdhOffset)
 
-	cmapOffset := cwdhOffset + len(cwdhsRaw)
+	cmapOffset := int(checkedUint32("CMAP offset", int64(cwdhOffset)+int64(len(cwdhsRaw))))
+	finfCWDHOffset, finfCMAPOffset := cwdhOffset, cmapOffset
+	for i := range b.CMAPs {
+		b.CMAPs[i].validation = b.CMAPs[i].validate()
+	}
 	cmapsRaw := EncodeCMAPs(b.CMAPs, cmapOffset)
 
-	finfRaw := b.FINF.Encode(tglpOffset, cwdhOffset, cmapOffset)
+	// nothing to point to, decoding stops at a 0 offset
+	if len(b.CWDHs) == 0 {
+		finfCWDHOffset = 0
+	}
+	if len(b.CMAPs) == 0 {
+		finfCMAPOffset = 0
+	}
+	sections := encodedSections{
+		finf:      b.FINF.Encode(tglpOffset, finfCWDHOffset, finfCMAPOffset),
+		afterTGLP: [][]byte{cwdhsRaw, cmapsRaw},
+	}
 
-	krngOffset := cmapOffset + len(cmapsRaw)
-	krngRaw := b.KRNG.Encode(uint32(krngOffset))
+	// Optional sections follow the cmaps. Missing ones are skipped entirely,
+	// nothing points to them so no other offsets have to change.
+	optionalOffset := int64(cmapOffset) + int64(len(cmapsRaw))
+	for _, section := range b.optionalSections() {
+		if !section.Present() {
+			continue
+		}
+		sectionRaw := section.Encode(checkedUint32("optional section offset", optionalOffset))
+		sections.afterTGLP = append(sections.afterTGLP, sectionRaw)
+		optionalOffset += int64(len(sectionRaw))
+	}
 
 	// TODO: calculate an appriopriate blockreadnum based on sheetsize?
-	fileSize := uint32(FFNT_HEADER_SIZE + len(finfRaw) + len(tglpRaw) + len(cwdhsRaw) + len(cmapsRaw) + len(krngRaw))
-	ffntRaw := b.FFNT.Encode(fileSize)
+	fileSize := int64(FFNT_HEADER_SIZE) + int64(len(sections.finf)) + int64(tglpSize)
+	for _, sectionRaw := range sections.afterTGLP {
+		fileSize += int64(len(sectionRaw))
+	}
+	sections.fileSize = int(checkedUint32("file size", fileSize))
 
-	res := make([]byte, 0)
-	res = append(res, ffntRaw...)
-	res = append(res, finfRaw...)
-	res = append(res, tglpRaw...)
-	res = append(res, cwdhsRaw...)
-	res = append(res, cmapsRaw...)
-	res = append(res, krngRaw...)
+	return sections
+}
 
-	return res
+// Sections that come after the cmaps and can be missing from a font. They are
+// encoded in this order: kerning, then the extra sections in their order.
+type optionalSection interface {
+	Present() bool
+	Encode(startOffset uint32) []byte
+}
+
+func (b *BFFNT) optionalSections() []optionalSection {
+	sections := []optionalSection{&b.KRNG}
+	for _, section := range b.ExtraSections {
+		sections = append(sections, checkedSection(section))
+	}
+
+	return sections
 }
 
 // Read all valid glyphs and indexes from the CMAPs and sort them
 func (b *BFFNT) GlyphIndexes() []AsciiIndexPair {
+	b.loadCMAPs()
 	pairSlice := make([]AsciiIndexPair, 0)
 	for _, cmap := range b.CMAPs {
 		for j, _ := range cmap.CharAscii {
@@ -117,55 +274,545 @@ func (b *BFFNT) Upscale(scale float64) {
 	b.KRNG.Upscale(scale)
 }
 
+// Tracking (letter spacing) tunes the overall density of text in one place
+// instead of adjusting every character's width. charWidthAmount is added to
+// every CharWidth and kerningAmount to every kerning pair.
+func (b *BFFNT) AdjustTracking(charWidthAmount int, kerningAmount int) {
+	var clamped clampedMetrics
+	skipped := 0
+	for i := range b.CWDHs {
+		cwdhClamped, cwdhSkipped := b.CWDHs[i].AdjustCharWidths(charWidthAmount)
+		clamped = append(clamped, cwdhClamped...)
+		skipped += cwdhSkipped
+	}
+	if charWidthAmount != 0 && skipped > 0 {
+		fmt.Printf("tracking leaves the CharWidth of %d zero width glyph(s) at 0\n", skipped)
+	}
+	warnClampedMetrics(fmt.Sprintf("after adjusting the char widths by %d", charWidthAmount), clamped)
+
+	b.KRNG.AdjustKerning(kerningAmount)
+}
+
+// Settings for a single upscale run that come from the command line
+type upscaleOptions struct {
+	writeAtlas  bool
+	sheetFilter string  // rescale the original sheets instead of rendering a font file
+	italicSlope float64 // horizontal shift per pixel of height, tan of the italic angle
+	boldRadius  int     // synthetic bold. Pixels to dilate the glyph alpha by
+
+	lineFeedPolicy  string // how FINF.LineFeed is scaled, see FINF.ScaleLineFeed
+	heightPolicy    string // how FINF.Height is scaled, see FINF.ScaleHeight
+	tracking        int    // added to every CharWidth
+	kerningTracking int    // added to every kerning value
+
+	spaceWidth       int // advance of the space in pixels, KEEP_SPACE_WIDTH keeps the scaled one
+	ideographicWidth int // same for the ideographic space
+
+	kerningProfiles string // yaml file with named kerning profiles, see KerningProfiles
+	kerningProfile  string // profile merged over the kerning after tracking
+
+	overrides string // directory with artwork replacing rendered glyphs, see drawOverrides
+
+	fontFeatures string // OpenType features the glyphs are drawn with, see parseFontFeatures
+
+	alterChar string // character drawn for unmapped characters, a character or U+XXXX. Empty keeps the font's
+
+	dedupeChars        bool // keep only the first CMAP mapping of every character
+	stripUnmappedKerns bool // remove kerning pairs for characters without a CMAP mapping
+
+	platform string // checks the sheet against the platform's texture limits
+	columns  int    // rearrange the cells into this many columns
+	pow2     string // power of two sheet policy, see applyPowerOfTwoPolicy
+
+	verify bool // re-decode the written file and compare it to the encoded font
+
+	metricsReport    bool    // write a table of every glyph's widths before and after scaling
+	metricsThreshold float64 // pixels a written width may be off from original × scale
+
+	sortCMAPs bool // sort the entries of scan CMAPs before encoding
+
+	autoFit       bool                     // take LeftWidth and CharWidth from the replacement font
+	rules         []AdjustmentRule         // width rules run before the nudges, see AdjustmentRule
+	adjustments   map[rune]GlyphAdjustment // per glyph nudges, see GlyphAdjustment
+	customSpacing map[int]spacingOutlier   // glyphs auto fit leaves alone, filled in by upscaleBffnt
+	baseline      int                      // row of the upscaled cells glyphs are drawn on, 0 uses BaselinePosition. See glyphBaseline
+	outline       glyphOutline             // drawn around every glyph, measured by upscaleBffnt
+
+	leftWidthOverflow string      // clamp or compensate LeftWidths that don't fit a signed byte, see scaledLeftWidths
+	scaledLeftWidths  map[int]int // scaled LeftWidths that don't fit by glyph index, filled in by upscaleBffnt
+
+	workers int // glyph rendering goroutines, 0 uses threads
+	threads int // goroutines every worker pool may use, 0 uses GOMAXPROCS. See workerCount
+
+	leftoverPolicy string // what decoding the font does with leftover bytes, see BFFNT.LeftoverPolicy
+
+	alphaCurve    string          // tone curve the rendered alpha goes through, see alphaCurve
+	originalAlpha *alphaHistogram // edges of the original sheets for the auto curve, filled in by upscaleBffnt
+
+	memStats bool // report the peak memory of every stage, see memoryTracker
+
+	metricsOnly bool // scale and adjust the metrics but keep the original sheets, nothing is rendered
+
+	bffntFile string // font to upscale, empty reads the botw font from WiiU_fonts
+	outputDir string // directory everything is written to, empty is the working directory
+
+	addChars    []rune       // characters to map to new glyphs rendered from the font file, see AddChars
+	addedGlyphs map[int]bool // glyph indexes AddChars made, filled in by upscaleBffnt
+	kernAdded   bool         // take the kerning of the added characters from the font file
+
+	cemuPack   string // graphic pack directory the font is swapped into, see writeCemuPack
+	bcmlMod    string // BCML mod directory the font is swapped into, see writeBcmlMod
+	atmosphere string // SD card directory a Switch LayeredFS mod is written to, see writeAtmosphereMod
+	sbfarcFile string // archive the pack's or mod's Font_EU.sbfarc starts as
+	game       string // game profile naming the font and its archive in packs and mods, see GameProfile
+	variant    string // region or language variant of the game profile, empty is its default
+
+	bps bool // write a BPS patch from the original font to the upscaled one
+
+	log *Logger // debug output of the run, nil uses DefaultLogger
+}
+
+// Whether the font goes into a mod, which needs the glyphs in the font
+// instead of a template with blank sheets
+func (opts upscaleOptions) packsFont() bool {
+	return opts.cemuPack != "" || opts.bcmlMod != "" || opts.atmosphere != ""
+}
+
+// Whether the rendered sheet is encoded into the font instead of leaving the
+// template's sheets blank. A mod needs the glyphs in the font, and so does a
+// patch, applying it would otherwise blank the sheets of the original.
+func (opts upscaleOptions) keepsSheet() bool {
+	return opts.packsFont() || opts.bps
+}
+
+func (opts upscaleOptions) outputPath(filename string) string {
+	return filepath.Join(opts.outputDir, filename)
+}
+
 func Run() {
-	flag.BoolVar(&Debug, "d", false, "enable debug output")
+	defer stopProfiling()
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
+	var opts upscaleOptions
+	var target, botwFontName, fontFile, axes, scales string
+	var scale, italicAngle float64
+	var faceIndex int
+	var glyphMapFile, adjustmentsFile, rulesFile string
+	debugFlag(flag.CommandLine)
+	leftoverPolicyFlag(flag.CommandLine, &opts.leftoverPolicy)
+	sheetCacheFlag(flag.CommandLine)
+	threadsFlag(flag.CommandLine, &opts.threads)
+	pngCompressionFlag(flag.CommandLine)
+	profilingFlags(flag.CommandLine)
+	flag.BoolVar(&opts.writeAtlas, "atlas", false, "write a json atlas describing every glyph's location in the generated sheet")
+	flag.StringVar(&target, "target", "1440p", "target resolution: "+strings.Join(targetNames(), ", "))
+	flag.Float64Var(&scale, "scale", 0, "explicit scale factor. Overrides -target")
+	flag.StringVar(&scales, "scales", "", "comma separated scales or targets to write in one run, e.g. 2,3. Overrides -scale and -target")
+	flag.StringVar(&botwFontName, "font", "External", "botw font to upscale: Ancient, Caption, Normal, NormalS or External. Several are separated by commas")
+	flag.StringVar(&fontFile, "ttf", "", "replacement font file. Defaults to the font picked for the botw font")
+	flag.IntVar(&faceIndex, "ttf-index", 0, "face of a font collection (.ttc) to use, see `bffnt faces`")
+	flag.StringVar(&axes, "axes", "", "axis values of a variable font, e.g. wght=650,wdth=95. Per botw font: Normal:wght=650;NormalS:wght=450")
+	flag.StringVar(&glyphMapFile, "glyph-map", "", "yaml file mapping the font's characters to the replacement font's, see `bffnt match-glyphs`")
+	flag.StringVar(&opts.overrides, "overrides", "", "directory with U+XXXX.png or .svg files replacing the rendered glyphs of those characters")
+	flag.StringVar(&opts.fontFeatures, "features", "", "OpenType features to draw the glyphs with, e.g. ss01,salt=2. Only single and alternate substitutions apply")
+	flag.Float64Var(&italicAngle, "italic", 0, "synthetic italic. Shear glyphs by this many degrees (negative leans left)")
+	flag.IntVar(&opts.boldRadius, "bold", 0, "synthetic bold. Dilate glyphs by this many pixels and widen them to match")
+	flag.StringVar(&opts.lineFeedPolicy, "linefeed", "", "line feed scaling: ceil, floor, round or an explicit line feed in pixels")
+	flag.StringVar(&opts.heightPolicy, "height", "", "font height scaling: ceil, floor, round or an explicit height in pixels")
+	flag.IntVar(&opts.tracking, "tracking", 0, "pixels added to (or removed from) every character's width")
+	flag.IntVar(&opts.kerningTracking, "kerning-tracking", 0, "pixels added to (or removed from) every kerning value")
+	flag.IntVar(&opts.spaceWidth, "space-width", KEEP_SPACE_WIDTH, "advance of the space in pixels, set after tracking. 0 keeps the scaled width")
+	flag.IntVar(&opts.ideographicWidth, "ideographic-space-width", KEEP_SPACE_WIDTH, "advance of the ideographic (full width) space in pixels, set after tracking. 0 keeps the scaled width")
+	flag.StringVar(&opts.kerningProfiles, "kerning-profiles", "kerning.yaml", "yaml file with named kerning profiles")
+	flag.StringVar(&opts.kerningProfile, "kerning-profile", "", "kerning profile from -kerning-profiles to merge over the font's kerning")
+	flag.StringVar(&opts.alterChar, "alter-char", "", "character the game draws for unmapped characters, e.g. ? or U+25A1. Empty keeps the font's")
+	flag.BoolVar(&opts.dedupeChars, "dedupe-cmap", false, "remove characters mapped by more than one CMAP, keeping the first mapping")
+	flag.BoolVar(&opts.sortCMAPs, "sort-cmap", false, "sort the entries of scan CMAPs by character code before encoding")
+	flag.BoolVar(&opts.stripUnmappedKerns, "strip-kerning", false, "remove kerning pairs for characters that aren't mapped by any CMAP")
+	flag.StringVar(&opts.platform, "platform", "wiiu", "platform whose texture size limits the sheet has to fit: "+strings.Join(platformNames(), ", "))
+	flag.IntVar(&opts.columns, "columns", 0, "rearrange the glyph cells into this many columns. Useful when the sheet gets too tall")
+	flag.StringVar(&opts.pow2, "pow2", "", "power of two sheet dimensions: round pads the sheet up, require fails if it isn't")
+	flag.BoolVar(&opts.verify, "verify", false, "re-decode the written bffnt and fail if it doesn't match what was encoded")
+	flag.StringVar(&adjustmentsFile, "adjustments", "", "yaml file with per glyph draw offsets and width changes, see `bffnt nudge`")
+	flag.StringVar(&rulesFile, "rules", "", "yaml file with width rules like charWidth -= 2 for every capital, see rules.yaml")
+	flag.StringVar(&opts.leftWidthOverflow, "left-width-overflow", LEFT_WIDTH_CLAMP, "scaled LeftWidths that don't fit a signed byte: clamp, or compensate by moving the ink and the advance")
+	flag.BoolVar(&opts.autoFit, "autofit", false, "take left and char widths from the replacement font, except for glyphs Nintendo gave custom spacing")
+	flag.BoolVar(&opts.metricsReport, "metrics-report", false, "write a table comparing every glyph's original widths times the scale to the widths written")
+	flag.Float64Var(&opts.metricsThreshold, "metrics-threshold", 2, "pixels a written width may differ from original × scale before the metrics report marks it")
+	flag.StringVar(&opts.alphaCurve, "alpha-curve", "", "tone curve for the rendered alpha: a gamma like 1.4, points like 64:40,192:200 or auto to match the original glyphs' edges")
+	flag.IntVar(&opts.workers, "workers", 0, "goroutines rendering glyphs. 0 uses -threads")
+	flag.BoolVar(&opts.memStats, "mem-stats", false, "report the peak memory used while decoding, rendering, converting and encoding")
+	flag.BoolVar(&opts.metricsOnly, "metrics-only", false, "only scale and adjust widths, kerning and the baseline, the original sheets are kept and nothing is rendered")
+	flag.StringVar(&opts.cemuPack, "cemu-pack", "", "also write a Cemu graphic pack with the upscaled font to this directory")
+	flag.StringVar(&opts.bcmlMod, "bcml-mod", "", "also write a BCML mod with the upscaled font to this directory")
+	flag.StringVar(&opts.atmosphere, "atmosphere", "", "also write a Switch LayeredFS mod with the upscaled font to this directory, laid out like the SD card. Experimental. Needs -platform switch and the Switch's archive with -sbfarc")
+	flag.StringVar(&opts.sbfarcFile, "sbfarc", DEFAULT_BOTW_ARCHIVE, "botw font archive the graphic pack's or mod's archive is made from")
+	flag.StringVar(&opts.game, "game", "botw", "game profile naming the font and its archive in graphic packs and mods: "+strings.Join(gameProfileNames(), ", ")+" or a yaml file")
+	flag.StringVar(&opts.variant, "variant", "", "region or language variant of the game, e.g. USen. Empty uses the profile's default")
+	flag.BoolVar(&opts.bps, "bps", false, "also write a BPS patch turning the original bffnt into the upscaled one, to share it without the font")
+	flag.StringVar(&opts.sheetFilter, "filter", "", "rescale the original sheets with a filter instead of rendering a font file: "+strings.Join(sheetFilterNames(), ", "))
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: bffnt [flags]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       bffnt <command> [flags] ...")
+		fmt.Fprintln(flag.CommandLine.Output(), "commands:", strings.Join(commandNames(), ", "))
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	initializeGlyphMaps()
+	// every font at every scale, the font files and original bffnts are only
+	// read and parsed once
+	fontNames := strings.Split(botwFontName, ",")
+	scaleList := []float64{resolveScale(target, scale)}
+	if scales != "" {
+		var err error
+		scaleList, err = parseScales(scales)
+		handleErr(err)
+	}
+	fontAxes, err := parseFontAxesFlag(axes)
+	handleErr(err)
+	if len(fontNames) > 1 && glyphMapFile != "" {
+		handleErr(fmt.Errorf("-glyph-map maps the characters of a single font, it can't be used with several fonts"))
+	}
+	if len(scaleList) > 1 && opts.packsFont() {
+		handleErr(fmt.Errorf("a graphic pack or mod holds one scale of a font, write one scale at a time"))
+	}
+	if opts.packsFont() {
+		// an unknown game or variant fails before anything is rendered
+		profile := readGameProfile(opts.game)
+		profile.destination(fontNames[0], opts.variant)
+		if opts.atmosphere != "" && !strings.EqualFold(opts.platform, "switch") {
+			handleErr(fmt.Errorf("-atmosphere writes a Switch mod, use it with -platform switch"))
+		}
+		if opts.atmosphere != "" && profile.SwitchTitleID == "" {
+			handleErr(fmt.Errorf("%s has no switch_title_id to put a LayeredFS mod under", profile.Name))
+		}
+	}
 
-	// scale 1 for 1280×720 (original)
-	// scale 2 for 2560 × 1440
-	// scale 3 for 3840 x 2160
-	scale := 2.0
-	scale = scale
+	initializeGlyphMaps()
+	if glyphMapFile != "" {
+		glyphMapFiles[botwFontName] = readGlyphMap(glyphMapFile)
+	}
+	if rulesFile != "" {
+		opts.rules = readAdjustmentRules(rulesFile)
+	}
+	var adjustments GlyphAdjustmentsFile
+	if adjustmentsFile != "" {
+		adjustments = readGlyphAdjustments(adjustmentsFile)
+	}
+	opts.italicSlope = math.Tan(italicAngle * math.Pi / 180)
 
-	// upscaleBffnt("Ancient", "./nintendo_system_ui/botw-sheikah.ttf", scale)
-	// upscaleBffnt("Caption", "./nintendo_system_ui/DSi-Wii-3DS-Wii_U/FOT-RodinBokutoh-Pro-M.otf", scale)
-	// upscaleBffnt("Normal", "./nintendo_system_ui/DSi-Wii-3DS-Wii_U/FOT-RodinBokutoh-Pro-B.otf", scale)
-	// upscaleBffnt("NormalS", "./nintendo_system_ui/DSi-Wii-3DS-Wii_U/CafeStd.ttf", scale)
-	// upscaleBffnt("NormalS", "./nintendo_system_ui/DSi-Wii-3DS-Wii_U/FOT-RodinBokutoh-Pro-B.otf", scale)
-	upscaleBffnt("External", "./nintendo_system_ui/nintendo_ext_003.ttf", scale)
+	for _, fontName := range fontNames {
+		fontName = strings.TrimSpace(fontName)
+		upscaleFontFile := fontFile
+		if opts.sheetFilter == "" && !opts.metricsOnly {
+			upscaleFontFile = withFontAxes(withFaceIndex(resolveFontFile(fontName, fontFile), faceIndex), fontAxesFor(fontAxes, fontName))
+		}
+		for _, scale := range scaleList {
+			if len(fontNames) > 1 || len(scaleList) > 1 {
+				fmt.Printf("== %s at %gx\n", fontName, scale)
+			}
+			scaleOpts := opts
+			if adjustmentsFile != "" {
+				scaleOpts.adjustments = adjustments.forFont(fontName, scale)
+				if scaleOpts.adjustments == nil {
+					fmt.Printf("warning: %s has no adjustments for %s at %gx\n", adjustmentsFile, fontName, scale)
+				}
+			}
+			upscaleBffnt(fontName, upscaleFontFile, scale, scaleOpts)
+		}
+	}
 
 	return
 }
 
-func upscaleBffnt(botwFontName string, fontFile string, scale float64) {
-	bffntFile := fmt.Sprintf("./WiiU_fonts/botw/%[1]s/%[1]s_00.bffnt", botwFontName)
+func upscaleBffnt(botwFontName string, fontFile string, scale float64, opts upscaleOptions) {
+	if opts.memStats {
+		memoryStats = startMemoryStats()
+		defer func() {
+			fmt.Println(memoryStats.finish())
+			memoryStats = nil
+		}()
+	}
+
+	endDecode := memoryStats.stage("decode")
+	bffntFile := opts.bffntFile
+	if bffntFile == "" {
+		bffntFile = fmt.Sprintf("./WiiU_fonts/botw/%[1]s/%[1]s_00.bffnt", botwFontName)
+	}
 	fmt.Println("Reading bffnt file", bffntFile)
-	bffntRaw, err = ioutil.ReadFile(bffntFile)
+	bffnt := readOriginalBffnt(bffntFile, opts)
+	original := bffnt.TGLP
+	originalFINF := bffnt.FINF
+	originalLineFeed, originalHeight := bffnt.FINF.LineFeed, bffnt.FINF.Height
+	originalGlyphs := bffnt.allGlyphInfo()
+	if opts.sheetFilter == "" && !opts.metricsOnly {
+		opts.baseline = bffnt.glyphBaseline(scale)
+		if outline, found := bffnt.detectOutline(); found {
+			opts.outline = outline
+			fmt.Printf("drawing the %s of the original glyphs, %d px at %vx\n", outline, outline.scaledWidth(scale), scale)
+		}
+	}
+	if opts.autoFit && opts.sheetFilter == "" && !opts.metricsOnly {
+		opts.customSpacing = bffnt.customSpacing()
+		fmt.Println("keeping the spacing of", len(opts.customSpacing), "glyph(s) with custom spacing")
+		for _, outlier := range sortedOutliers(opts.customSpacing) {
+			bffnt.Log.debugf("   %s: %s\n", bffnt.glyphLabel(outlier.index), outlier.reason)
+		}
+	}
+	switch opts.leftWidthOverflow {
+	case "", LEFT_WIDTH_CLAMP:
+	case LEFT_WIDTH_COMPENSATE:
+		if opts.metricsOnly || opts.sheetFilter != "" {
+			handleErr(fmt.Errorf("LeftWidths can only be compensated when the sheet is rendered from a font file"))
+		}
+		opts.scaledLeftWidths = overflowingLeftWidths(originalGlyphs, scale)
+	default:
+		handleErr(fmt.Errorf("unknown -left-width-overflow %q, use %s or %s", opts.leftWidthOverflow, LEFT_WIDTH_CLAMP, LEFT_WIDTH_COMPENSATE))
+	}
+	if opts.alphaCurve != "" {
+		if opts.metricsOnly || opts.sheetFilter != "" {
+			handleErr(fmt.Errorf("the alpha curve only applies when the sheet is rendered from a font file"))
+		}
+		if opts.alphaCurve == AUTO_ALPHA_CURVE {
+			histogram, err := bffnt.TGLP.edgeAlphaHistogram()
+			handleErr(err)
+			opts.originalAlpha = &histogram
+		} else {
+			_, err := parseAlphaCurve(opts.alphaCurve)
+			handleErr(err)
+		}
+	}
+	var addedChars []rune
+	if len(opts.addChars) > 0 {
+		if opts.metricsOnly || opts.sheetFilter != "" {
+			handleErr(fmt.Errorf("characters can only be added when the sheet is rendered from a font file"))
+		}
+		glyphCount := bffnt.glyphCount()
+		addedChars = bffnt.AddChars(opts.addChars)
+		opts.addedGlyphs = make(map[int]bool, len(addedChars))
+		for index := glyphCount; index < bffnt.glyphCount(); index++ {
+			opts.addedGlyphs[index] = true
+		}
+		fmt.Printf("added %d character(s), the font has %d glyphs now\n", len(addedChars), bffnt.glyphCount())
+	}
+	endDecode()
+
+	endRender := memoryStats.stage("render")
+	if opts.metricsOnly {
+		fmt.Println("scaling metrics by factor of", scale, "and keeping the original sheets")
+		bffnt.Upscale(scale)
+		bffnt.TGLP.keepSheets(original)
+	} else {
+		// check the layout before anything is rendered. A sheet that is too big
+		// hangs the console instead of failing to load.
+		layout := bffnt.TGLP.scaledLayout(scale)
+		if opts.columns > 0 {
+			layout = layout.withColumns(opts.columns, bffnt.glyphCount())
+		}
+		layout = layout.withRowsFor(bffnt.glyphCount())
+		handleErr(applyPowerOfTwoPolicy(&layout, opts.pow2))
+		handleErr(checkSheetLimits(layout, bffnt.glyphCount(), scale, opts.platform))
+
+		fmt.Println("upscaling image by factor of", scale)
+		bffnt.Upscale(scale)
+		if len(opts.scaledLeftWidths) > 0 {
+			fmt.Printf("the LeftWidth of %d glyph(s) is compensated instead, their ink and advance are moved by what doesn't fit\n", len(opts.scaledLeftWidths))
+		}
+		bffnt.TGLP.applyLayout(layout)
+		// scaled cells keep their 1 pixel border so room to spare is expected
+		for _, issue := range lintCellGrid(&bffnt) {
+			if issue.Severity == LINT_ERROR {
+				handleErr(fmt.Errorf("upscaled cell grid is broken: %s", issue.Message))
+			}
+		}
+	}
+	if opts.lineFeedPolicy != "" {
+		bffnt.FINF.ScaleLineFeed(originalLineFeed, scale, opts.lineFeedPolicy)
+	}
+	if opts.heightPolicy != "" {
+		bffnt.FINF.ScaleHeight(originalHeight, scale, opts.heightPolicy)
+	}
+	if opts.metricsOnly {
+		bffnt.manuallyAdjustWidths(botwFontName, scale)
+	} else if opts.sheetFilter != "" {
+		// metrics were already scaled consistently with the image by Upscale
+		bffnt.upscaleSheets(original, botwFontName, scale, opts.sheetFilter, opts.overrides, opts.keepsSheet())
+	} else {
+		bffnt.generateTexture(botwFontName, fontFile, scale, opts) // This edits the CWDH
+
+		bffnt.manuallyAdjustWidths(botwFontName, scale)
+	}
+	if len(opts.rules) > 0 {
+		fmt.Printf("%d rule(s) changed %d glyph(s)\n", len(opts.rules), bffnt.applyAdjustmentRules(opts.rules, botwFontName, scale))
+	}
+	if len(opts.adjustments) > 0 {
+		unmapped := bffnt.applyGlyphAdjustments(opts.adjustments)
+		if len(unmapped) > 0 {
+			fmt.Println("warning: adjusted characters aren't mapped:", formatCharList(unmapped))
+		}
+		fmt.Println("adjusted", len(opts.adjustments)-len(unmapped), "glyph(s)")
+	}
+	if opts.kernAdded && len(addedChars) > 0 {
+		fmt.Println("added", bffnt.kernAddedChars(botwFontName, fontFile, scale, opts.addChars, addedChars), "kerning pair(s) from", fontFile)
+	}
+	endRender()
+
+	if opts.tracking != 0 || opts.kerningTracking != 0 {
+		bffnt.AdjustTracking(opts.tracking, opts.kerningTracking)
+	}
+	if opts.kerningProfile != "" {
+		bffnt.applyKerningProfile(opts.kerningProfiles, opts.kerningProfile, scale)
+	}
+	bffnt.applySpaceWidths(opts.spaceWidth, opts.ideographicWidth)
+	if opts.alterChar != "" {
+		alterChar, err := parseCharFlag(opts.alterChar)
+		handleErr(err)
+		handleErr(bffnt.SetAlterChar(alterChar))
+		fmt.Println("unmapped characters are drawn as", bffnt.glyphLabel(int(bffnt.FINF.AlterCharIndex)))
+	}
+
+	if opts.writeAtlas {
+		atlasFile := opts.outputPath(fmt.Sprintf("%s_00_%.2fx_atlas.json", botwFontName, scale))
+		bffnt.WriteAtlas(atlasFile)
+		fmt.Println("wrote atlas to", atlasFile)
+	}
+
+	if opts.metricsReport {
+		reportFile := opts.outputPath(fmt.Sprintf("%s_00_%.2fx_metrics.txt", botwFontName, scale))
+		deviating := writeMetricReport(reportFile, metricScalings(originalGlyphs, &bffnt, scale), opts.metricsThreshold)
+		fmt.Printf("wrote metrics report to %s, %d width(s) off by more than %.1f pixels\n", reportFile, deviating, opts.metricsThreshold)
+	}
+
+	if !opts.metricsOnly {
+		for _, issue := range checkUpscaledGeometry(originalFINF, original, bffnt.FINF, bffnt.TGLP, scale) {
+			fmt.Println("warning:", issue)
+		}
+	}
 
-	var bffnt BFFNT
+	if opts.dedupeChars {
+		fmt.Println("removed", bffnt.ResolveDuplicateChars(), "duplicate character mappings")
+	}
+	if opts.sortCMAPs {
+		fmt.Println("sorted", bffnt.SortScanCMAPs(), "scan CMAP(s)")
+	}
+	if opts.stripUnmappedKerns {
+		fmt.Println("removed", bffnt.StripUnmappedKerning(), "kerning pairs for unmapped characters")
+	}
+
+	outputBffntFile := opts.outputPath(fmt.Sprintf("%s_00_%.2fx_template.bffnt", botwFontName, scale))
+	endEncode := memoryStats.stage("encode")
+	outputFile, err := os.Create(outputBffntFile)
+	handleErr(err)
+	encodedSize, err := bffnt.EncodeTo(outputFile)
 	handleErr(err)
-	bffnt.Decode(bffntRaw)
+	handleErr(outputFile.Close())
+	endEncode()
+	fmt.Println("encoded bytes:", encodedSize)
+	if opts.metricsOnly && !bffnt.TGLP.sheetsUntouched() {
+		fmt.Println("warning: the sheets were replaced, the original glyphs are not in", outputBffntFile)
+	}
+	for _, failure := range bffnt.Validation().Failures {
+		fmt.Println("warning:", failure)
+	}
 
-	fmt.Println("upscaling image by factor of", scale)
-	bffnt.Upscale(scale)
-	if botwFontName == "NormalS" {
-		// bffnt.TGLP.BaselinePosition += 6
+	if opts.verify {
+		defer memoryStats.stage("verify")()
+		writtenRaw, err := ioutil.ReadFile(outputBffntFile)
+		handleErr(err)
+		handleErr(bffnt.VerifyEncoded(writtenRaw))
+		fmt.Println("verified", outputBffntFile)
 	}
 
-	bffnt.generateTexture(botwFontName, fontFile, scale) // This edits the CWDH
+	if opts.bps {
+		writeBPS(bffntFile, outputBffntFile, strings.TrimSuffix(outputBffntFile, filepath.Ext(outputBffntFile))+".bps")
+	}
 
-	bffnt.manuallyAdjustWidths(botwFontName, scale)
+	if opts.packsFont() {
+		writtenRaw, err := ioutil.ReadFile(outputBffntFile)
+		handleErr(err)
+		profile := readGameProfile(opts.game)
+		destination := profile.destination(botwFontName, opts.variant)
+		if opts.cemuPack != "" {
+			writeCemuPack(opts.cemuPack, opts.sbfarcFile, destination, writtenRaw)
+		}
+		if opts.bcmlMod != "" {
+			writeBcmlMod(opts.bcmlMod, opts.sbfarcFile, destination, writtenRaw)
+		}
+		if opts.atmosphere != "" {
+			writeAtmosphereMod(opts.atmosphere, opts.sbfarcFile, profile.SwitchTitleID, destination, writtenRaw)
+		}
+	}
+}
 
-	encodedRaw := bffnt.Encode()
-	fmt.Println("encoded bytes:", len(encodedRaw))
+// Fonts read by upscaleBffnt. Every scale of a run starts from the same font,
+// it's decoded once and every scale gets its own copy since the upscale
+// changes the decoded font. The decode settings are the same for the whole run.
+var originalBffnts = make(map[string]*BFFNT)
 
-	outputBffntFile := fmt.Sprintf("%s_00_%.2fx_template.bffnt", botwFontName, scale)
-	err = os.WriteFile(outputBffntFile, encodedRaw, 0644)
+func readOriginalBffnt(filename string, opts upscaleOptions) BFFNT {
+	if original, exists := originalBffnts[filename]; exists {
+		return original.clone()
+	}
+	raw, err := ioutil.ReadFile(filename)
 	handleErr(err)
+	original := &BFFNT{Log: opts.log, LeftoverPolicy: opts.leftoverPolicy, Threads: opts.threads}
+	original.Decode(raw)
+	originalBffnts[filename] = original
+	return original.clone()
+}
 
-	// bffnt.Decode(encodedRaw)
+// A copy of a decoded font that can be changed without changing b. The raw
+// bytes the font was decoded from, including the sheet data, are shared,
+// they're only ever replaced and never written to.
+func (b *BFFNT) clone() BFFNT {
+	c := *b
+	c.validation = b.validation.clone()
+	c.TGLP.validation = b.TGLP.validation.clone()
+	if b.TGLP.SheetData != nil {
+		c.TGLP.SheetData = make([]image.NRGBA, len(b.TGLP.SheetData))
+		for i, sheet := range b.TGLP.SheetData {
+			sheet.Pix = append([]byte(nil), sheet.Pix...)
+			c.TGLP.SheetData[i] = sheet
+		}
+	}
+
+	c.CWDHs = make([]CWDH, len(b.CWDHs))
+	for i, cwdh := range b.CWDHs {
+		cwdh.Glyphs = append([]glyphInfo(nil), cwdh.Glyphs...)
+		cwdh.Leftovers = append([]byte(nil), cwdh.Leftovers...)
+		cwdh.validation = cwdh.validation.clone()
+		c.CWDHs[i] = cwdh
+	}
+	c.CMAPs = make([]CMAP, len(b.CMAPs))
+	for i, cmap := range b.CMAPs {
+		cmap.CharAscii = append([]uint16(nil), cmap.CharAscii...)
+		cmap.CharIndex = append([]uint16(nil), cmap.CharIndex...)
+		cmap.Leftovers = append([]byte(nil), cmap.Leftovers...)
+		cmap.validation = cmap.validation.clone()
+		c.CMAPs[i] = cmap
+	}
+	if b.KRNG.KerningTable != nil {
+		c.KRNG.KerningTable = make(map[uint16][]kerningPair, len(b.KRNG.KerningTable))
+		for firstChar, pairs := range b.KRNG.KerningTable {
+			c.KRNG.KerningTable[firstChar] = append([]kerningPair(nil), pairs...)
+		}
+	}
+	c.KRNG.Leftovers = append([]byte(nil), b.KRNG.Leftovers...)
+
+	// the sections themselves are shared, they're only written again
+	c.ExtraSections = append([]CustomSection(nil), b.ExtraSections...)
+
+	if b.CWDHIndexMap != nil {
+		c.CWDHIndexMap = make(map[rune]int, len(b.CWDHIndexMap))
+		for char, index := range b.CWDHIndexMap {
+			c.CWDHIndexMap[char] = index
+		}
+	}
+	c.invalidateCharIndex()
+
+	return c
 }
 
 func (b *BFFNT) manuallyAdjustWidths(fontName string, scale float64) {
@@ -276,18 +923,65 @@ func adjustBotwCaptionWidth(b *BFFNT) {
 	glyphWidths[b.CWDHIndexMap[':']].LeftWidth -= 0
 }
 
+// Renders the glyph sheet and writes it as a png next to the template
+func (b *BFFNT) generateTexture(fontName string, fontFile string, scale float64, opts upscaleOptions) {
+	filename := opts.outputPath(fmt.Sprintf("%s_00_%.2fx.png", fontName, scale))
+	fmt.Println("Reading font file", fontFile)
+	// drawer.MeasureString can be used to modify kerning table
+	fmt.Println(b.TGLP.SheetWidth, b.TGLP.SheetHeight)
+	dst, overflows := b.renderGlyphSheet(fontName, fontFile, scale, opts)
+	if opts.alphaCurve != "" {
+		b.applyAlphaCurve(dst, opts.alphaCurve, opts.originalAlpha)
+	}
+	if opts.overrides != "" {
+		fmt.Println("replaced", b.drawOverrides(dst, opts.overrides), "glyph(s) with the artwork in", opts.overrides)
+	}
+
+	if b.Log.debugging() {
+		b.Log.debugln(glyphMeasurements)
+
+		// draw grid lines. Good for debugging.
+		realCellWidth, realCellHeight := int(b.TGLP.CellWidth)+1, int(b.TGLP.CellHeight)+1
+		for x := 0; x < int(b.TGLP.SheetWidth); x += realCellWidth {
+			drawVerticalLine(dst, x, 0, int(b.TGLP.SheetHeight)) // draw columns
+		}
+		for y := 0; y < int(b.TGLP.SheetHeight); y += realCellHeight {
+			drawHorizontalLine(dst, 0, y, int(b.TGLP.SheetWidth)) // draw rows
+		}
+		for y := int(b.TGLP.BaselinePosition) + 1; y < int(b.TGLP.SheetHeight); y += realCellHeight {
+			drawHorizontalLine(dst, 0, y, int(b.TGLP.SheetWidth)) // draw baseline
+		}
+	}
+
+	writePng(filename, dst)
+	if opts.keepsSheet() {
+		b.TGLP.keepRenderedSheet(dst)
+	}
+	alphaBuffers.put(dst)
+	fmt.Println("wrote glyphs to", filename)
+
+	if len(overflows) > 0 {
+		fontSize := getBotwFontSettings(fontName, scale)
+		printWidthOverflowReport(overflows, fontSize, scale)
+	}
+}
+
+// Draws every glyph of the replacement font into its cell of a new sheet and
+// updates the CWDH to match. Returns the glyphs that got clamped because they
+// are too wide for it. The sheet comes from alphaBuffers, hand it back once
+// it's written.
 // https://pkg.go.dev/golang.org/x/image/font/sfnt#Font
-func (b *BFFNT) generateTexture(fontName string, fontFile string, scale float64) {
+func (b *BFFNT) renderGlyphSheet(fontName string, fontFile string, scale float64, opts upscaleOptions) (*image.Alpha, []widthOverflow) {
 	glyphIndexes := b.GlyphIndexes()
 
-	fontSize, outlineOffset := getBotwFontSettings(fontName, scale)
+	fontSize := getBotwFontSettings(fontName, scale)
+	outlineWidth := opts.outline.scaledWidth(scale)
 
 	var (
-		filename    = fmt.Sprintf("%s_00_%.2fx.png", fontName, scale)
 		cellWidth   = int(b.TGLP.CellWidth)
 		cellHeight  = int(b.TGLP.CellHeight)
 		columnCount = int(b.TGLP.NumOfColumns)
-		baseline    = int(b.TGLP.BaselinePosition) + int(scale)
+		baseline    = int(b.TGLP.BaselinePosition)
 		sheetHeight = int(b.TGLP.SheetHeight)
 		sheetWidth  = int(b.TGLP.SheetWidth)
 
@@ -297,155 +991,260 @@ func (b *BFFNT) generateTexture(fontName string, fontFile string, scale float64)
 		realCellHeight = cellHeight + 1
 	)
 
-	fmt.Println("Reading font file", fontFile)
-	dat, err := os.ReadFile(fontFile)
-	handleErr(err)
+	if opts.baseline > 0 {
+		baseline = opts.baseline
+		realBaseline = baseline + 1
+	}
 
-	f, err := opentype.Parse(dat)
-	handleErr(err)
+	parsed := loadFontFile(fontFile)
+	f := parsed.font
+	warnVariableFont(fontFile)
+	chars := make([]rune, len(glyphIndexes))
+	for i, pair := range glyphIndexes {
+		chars[i] = rune(pair.CharAscii)
+	}
+	replacements, missing := replacementChars(f, fontName, chars)
+	if len(missing) > 0 {
+		fmt.Printf("warning: %s has no glyph for %d character(s), they're drawn as its missing glyph: %s\n", fontFile, len(missing), formatCharList(missing))
+	}
+	subs := fontFeatureSubstitutions(fontFile, opts.fontFeatures)
+	if len(subs) > 0 {
+		fmt.Printf("font features %s substitute %d glyph(s)\n", opts.fontFeatures, len(subs))
+	}
 
-	face, err := opentype.NewFace(f, &opentype.FaceOptions{
-		Size:    fontSize,
-		DPI:     144,
-		Hinting: font.HintingFull,
-	})
-	handleErr(err)
+	// Faces keep a buffer of the glyph being loaded, every worker needs its
+	// own. The parsed font can be shared.
+	newFace := func() font.Face {
+		face, err := opentype.NewFace(f, &opentype.FaceOptions{
+			Size:    fontSize,
+			DPI:     144,
+			Hinting: font.HintingFull,
+		})
+		handleErr(err)
+		return newSubstitutedFace(face, f, subs, parsed.variation, fontSize, 144, font.HintingFull)
+	}
 
-	// drawer.MeasureString can be used to modify kerning table
-	fmt.Println(sheetWidth, sheetHeight)
-	dst := image.NewAlpha(image.Rect(0, 0, sheetWidth, sheetHeight))
-	glyphDrawer := font.Drawer{
-		Dst:  dst,
-		Src:  image.White,
-		Face: face,
-		Dot:  fixed.P(0, 0),
-	}
-
-	var charIndex, x, y int
-	for rowIndex := 0; ; rowIndex++ {
-		y = realCellHeight*rowIndex + realBaseline
-		for columnIndex := 0; columnIndex < columnCount; columnIndex++ {
-			x = realCellWidth * columnIndex
-			glyphDrawer.Dot = fixed.P(x, y)
-			// fmt.Printf("The dot is at %v\n", glyphDrawer.Dot)
-
-			ascii := glyphIndexes[charIndex].CharAscii
-			glyph := string(rune(asciiToGlyph(fontName, ascii)))
-			// fmt.Println(charIndex, ascii, glyph)
-
-			glyphBoundAtDot, _ := glyphDrawer.BoundString(glyph)
-			// fmt.Println(x, glyphBoundAtDot.Min.X, glyphBoundAtDot.Min.Y, glyphBoundAtDot.Max.X, glyphBoundAtDot.Max.Y)
-
-			// TODO: make this work with multiple CWDHs
-			// calculate glyph x offset in it's cell so that there is only 1
-			// pixel length between the cell and the left most pixel of the
-			// glyph we are abount to draw. Generally the characters are draw
-			// to the right of the Dot but its possible for this to be
-			// negative. e.x. character j's left most pixel falls to the left
-			// of the dot.
-			leftAlignOffset := int(glyphBoundAtDot.Min.X/64) - x
-
-			// Drawing new glyphs means we should update the CWDH. If a glyph's
-			// recorded width is smaller than the one drawn it will get cut off
-			// when rendering in the game.
-			newGlyphWidth := int(glyphBoundAtDot.Max.X/64) - int(glyphBoundAtDot.Min.X/64) + 1
-			newGlyphWidth += 2 * outlineOffset // usually 0 except for botw NormalS, because the font has an outline
-			if newGlyphWidth > 255 {           // MaxUint8
-				panic("BFFNT's maximum glyph width is 255 (MaxUint8)")
+	dst := alphaBuffers.get(image.Rect(0, 0, sheetWidth, sheetHeight))
+
+	// Draws a single glyph into its cell of band and updates its CWDH. Every
+	// call touches a different glyph so calls can run at the same time.
+	drawGlyph := func(glyphDrawer *font.Drawer, band *image.Alpha, charIndex int, rowIndex int, columnIndex int) (overflow *widthOverflow) {
+		x := realCellWidth * columnIndex
+		y := realCellHeight*rowIndex + realBaseline
+		glyphDrawer.Dst = band
+		glyphDrawer.Dot = fixed.P(x, y)
+		// fmt.Printf("The dot is at %v\n", glyphDrawer.Dot)
+
+		ascii := glyphIndexes[charIndex].CharAscii
+		glyph := string(rune(asciiToGlyph(fontName, ascii)))
+		if replacement, exists := replacements[rune(ascii)]; exists {
+			glyph = string(replacement)
+		}
+		// fmt.Println(charIndex, ascii, glyph)
+
+		measurement := glyphMeasurements.measure(faceKey{fontFile, fontSize, opts.fontFeatures}, glyphDrawer.Face, glyph)
+		glyphBoundAtDot := measurement.boundsAt(glyphDrawer.Dot)
+		// fmt.Println(x, glyphBoundAtDot.Min.X, glyphBoundAtDot.Min.Y, glyphBoundAtDot.Max.X, glyphBoundAtDot.Max.Y)
+
+		// TODO: make this work with multiple CWDHs
+		// calculate glyph x offset in it's cell so that there is only 1
+		// pixel length between the cell and the left most pixel of the
+		// glyph we are abount to draw. Generally the characters are draw
+		// to the right of the Dot but its possible for this to be
+		// negative. e.x. character j's left most pixel falls to the left
+		// of the dot.
+		leftAlignOffset := int(glyphBoundAtDot.Min.X/64) - x
+
+		// Drawing new glyphs means we should update the CWDH. If a glyph's
+		// recorded width is smaller than the one drawn it will get cut off
+		// when rendering in the game.
+		newGlyphWidth := int(glyphBoundAtDot.Max.X/64) - int(glyphBoundAtDot.Min.X/64) + 1
+		newGlyphWidth += 2 * outlineWidth // usually 0 except for botw NormalS, because the font has an outline
+		newGlyphWidth += 2 * opts.boldRadius
+
+		// Measure how far the dot would travel if a character is printed
+		// we can use this to dial in the character width.
+		newCharWidth := int(measurement.advance / 64)
+
+		glyphCWDH := &b.CWDHs[0].Glyphs[charIndex]
+		// Nintendo has custom spacing for some glyphs, those keep their
+		// scaled widths. See findSpacingOutliers.
+		// added glyphs have no widths of their own, the font's are the only
+		// ones there are
+		_, custom := opts.customSpacing[charIndex]
+		fitted := (opts.autoFit && !custom) || opts.addedGlyphs[charIndex]
+		zeroWidth := fitted && isCombiningMark(rune(ascii))
+		newLeftWidth := int(glyphCWDH.LeftWidth)
+		if scaled, exists := opts.scaledLeftWidths[charIndex]; exists {
+			newLeftWidth = scaled
+		}
+		if zeroWidth {
+			glyphCWDH.LeftWidth, glyphCWDH.CharWidth = markWidths(leftAlignOffset, newCharWidth)
+			newLeftWidth = int(glyphCWDH.LeftWidth)
+		} else if fitted {
+			newLeftWidth = leftAlignOffset
+			glyphCWDH.CharWidth, _ = clampToCharWidth(newCharWidth)
+		}
+		// fmt.Println("glyph", glyph, newGlyphWidth, glyphCWDH.GlyphWidth)
+		// Move the LeftWidth by the italic shift so the upright parts stay
+		// in place
+		drawShift := 0
+		if opts.italicSlope != 0 {
+			shift, extraWidth := italicShear(y-int(glyphBoundAtDot.Min.Y/64), int(glyphBoundAtDot.Max.Y/64)-y, opts.italicSlope)
+			drawShift = shift
+			newGlyphWidth += extraWidth
+			newLeftWidth -= shift
+		}
+
+		// Dilating grows the ink by boldRadius on both sides. The glyph is
+		// moved right so the ink still starts at the left of the cell and
+		// the advance grows by the added width.
+		newAdvance := int(glyphCWDH.CharWidth)
+		if opts.boldRadius > 0 {
+			drawShift += opts.boldRadius
+			if !zeroWidth {
+				newAdvance += 2 * opts.boldRadius
 			}
+		}
 
-			// Measure how far the dot would travel if a character is printed
-			// we can use this to dial in the character width.
-			newCharWidth := int(glyphDrawer.MeasureString(glyph) / 64)
-			if newCharWidth > 255 { // MaxUint8
-				panic("BFFNT's maximum char width is 255 (MaxUint8)")
+		// With -left-width-overflow compensate a LeftWidth too large for its
+		// byte leaves blank columns before the ink so it still starts where
+		// it should. One too small can't draw the ink any further left, the
+		// advance grows by the difference instead so the next glyph keeps
+		// its distance to the ink.
+		if opts.leftWidthOverflow == LEFT_WIDTH_COMPENSATE {
+			if excess := newLeftWidth - math.MaxInt8; excess > 0 {
+				drawShift += excess
+				newGlyphWidth += excess
+				newLeftWidth = math.MaxInt8
+			} else if excess := math.MinInt8 - newLeftWidth; excess > 0 {
+				if !zeroWidth {
+					newAdvance += excess
+				}
+				newLeftWidth = math.MinInt8
 			}
+		}
 
-			glyphCWDH := b.CWDHs[0].Glyphs[charIndex]
-			// It looks like that nintendo might have custom spacing, if the
-			// difference is too big do not update CWDH
-			// if math.Abs(float64(leftAlignOffset-int(glyphCWDH.LeftWidth))) <= float64(scale+1) {
-			// 	fmt.Println("left ", glyph, leftAlignOffset, glyphCWDH.LeftWidth)
-			// 	glyphCWDH.LeftWidth = int8(leftAlignOffset)
-			// }
-			// if math.Abs(float64(newCharWidth-int(glyphCWDH.CharWidth))) <= float64(scale+1) {
-			// 	fmt.Println("char ", glyph, newCharWidth, glyphCWDH.CharWidth)
-			// 	glyphCWDH.CharWidth = uint8(newCharWidth)
-			// }
-			// fmt.Println("glyph", glyph, newGlyphWidth, glyphCWDH.GlyphWidth)
-			glyphCWDH.GlyphWidth = uint8(newGlyphWidth)
-
-			y_nintendo := y - int(scale) // manual adjust to compensate y difference between nintendo font generator and mine.
-			glyphDrawer.Dot = fixed.P(x-leftAlignOffset+(outlineOffset)+1, y_nintendo)
-			glyphDrawer.DrawString(glyph)
+		// Widths are stored in a single byte. Clamp them and keep going so
+		// every offender ends up in the report instead of just the first.
+		var leftFits, glyphFits, advanceFits bool
+		glyphCWDH.LeftWidth, leftFits = clampToLeftWidth(newLeftWidth)
+		glyphCWDH.GlyphWidth, glyphFits = clampToGlyphWidth(newGlyphWidth)
+		glyphCWDH.CharWidth, advanceFits = clampToCharWidth(newAdvance)
+		if !leftFits || !glyphFits || !advanceFits || newCharWidth > MAX_GLYPH_WIDTH {
+			overflow = &widthOverflow{ascii, newLeftWidth, newGlyphWidth, newCharWidth, newAdvance}
+		}
 
-			charIndex++
+		adjustment := opts.adjustments[rune(ascii)]
+		glyphDrawer.Dot = fixed.P(x-leftAlignOffset+outlineWidth+1+drawShift+adjustment.X, y+adjustment.Y)
+		if opts.italicSlope != 0 || opts.boldRadius > 0 || outlineWidth > 0 {
+			// Draw into a cell sized image first so the effects can't
+			// bleed into neighbouring cells.
+			cellTop := realCellHeight * rowIndex
+			cellRect := image.Rect(x, cellTop, x+realCellWidth, cellTop+realCellHeight)
+			cell := image.NewAlpha(cellRect)
+			glyphDrawer.Dst = cell
+			glyphDrawer.DrawString(glyph)
 
-			// Exit when no more characters
-			// if charIndex == 95 {
-			if charIndex == len(glyphIndexes) {
-				goto writePng
+			if opts.italicSlope != 0 {
+				sheared := image.NewAlpha(cellRect)
+				drawSheared(sheared, cell, y, opts.italicSlope)
+				cell = sheared
 			}
+			if opts.boldRadius > 0 {
+				cell = dilateAlpha(cell, opts.boldRadius)
+			}
+			if outlineWidth > 0 {
+				cell = outlineAlpha(cell, outlineWidth, opts.outline.opacity)
+			}
+			draw.Draw(band, cellRect, cell, cellRect.Min, draw.Over)
+		} else {
+			glyphDrawer.DrawString(glyph)
 		}
+
+		return overflow
 	}
 
-writePng:
-	if Debug {
-		// draw grid lines. Good for debugging.
-		for x := 0; x < int(b.TGLP.SheetWidth); x += realCellWidth {
-			drawVerticalLine(dst, x, 0, int(b.TGLP.SheetHeight)) // draw columns
-		}
-		for y := 0; y < int(b.TGLP.SheetHeight); y += realCellHeight {
-			drawHorizontalLine(dst, 0, y, int(b.TGLP.SheetWidth)) // draw rows
+	bands := cellBands(len(glyphIndexes), columnCount, realCellHeight, dst.Bounds())
+	renderBands(bands, workerCount(opts.workers, opts.threads), func(band *glyphBand) {
+		glyphDrawer := font.Drawer{
+			Src:  image.White,
+			Face: newFace(),
 		}
-		for y := int(b.TGLP.BaselinePosition) + 1; y < int(b.TGLP.SheetHeight); y += realCellHeight {
-			drawHorizontalLine(dst, 0, y, int(b.TGLP.SheetWidth)) // draw baseline
+		for charIndex := band.firstCell; charIndex < band.endCell; charIndex++ {
+			overflow := drawGlyph(&glyphDrawer, band.img, charIndex, charIndex/columnCount, charIndex%columnCount)
+			if overflow != nil {
+				band.overflows = append(band.overflows, *overflow)
+			}
 		}
-	}
+	})
 
-	_ = os.Remove(filename)
+	// Bands overlap by a row so ink that spills out of its cell ends up the
+	// same as when drawing everything into a single image
+	overflows := make([]widthOverflow, 0)
+	for _, band := range bands {
+		draw.Draw(dst, band.img.Bounds(), band.img, band.img.Bounds().Min, draw.Over)
+		overflows = append(overflows, band.overflows...)
+		alphaBuffers.put(band.img)
+	}
 
-	fmt.Println("wrote glyphs to", filename)
-	textureFile, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
-	handleErr(err)
-	err = png.Encode(textureFile, dst)
-	handleErr(err)
+	return dst, overflows
 }
 
-// Manual adjustments for each font to closely resemble the original
-func getBotwFontSettings(fontName string, scale float64) (fontSize float64, outlineOffset int) {
-	switch fontName {
-	case "Ancient":
-		fontSize = 5.5 * scale
+// Glyph and char widths are stored as a uint8
+const MAX_GLYPH_WIDTH = 255
 
-	case "Caption":
-		fontSize = 8 * scale
+// Combining marks are drawn on top of the character before them instead of
+// next to it, the game has no mark positioning so the only way is a glyph that
+// doesn't move the pen
+func isCombiningMark(char rune) bool {
+	return unicode.In(char, unicode.Mn, unicode.Me)
+}
 
-	case "Normal":
-		fontSize = 15 * scale // 2k
+// Widths that put a mark's ink over the previous character. The pen already
+// stopped after the previous character, so a mark the replacement font gives
+// no advance already reaches back over it with a negative left offset. A mark
+// with an advance of its own (a spacing form) is moved back by that advance
+// instead. LeftWidth is a single signed byte, large marks are clamped.
+func markWidths(leftAlignOffset int, advance int) (leftWidth int8, charWidth uint8) {
+	leftWidth, _ = clampToLeftWidth(leftAlignOffset - advance)
+	return leftWidth, 0
+}
 
-	case "NormalS":
-		// This is what should be the proper setting for botw NormalS. However,
-		// there is a bug that stretches the words on the mini map if the
-		// textures are not the same width as the original.
-		fontSize = 10 * scale
-		outlineOffset = 3 // NormalS Characters have a 2px wide outline with 25% opacaity. I use GIMP.
-
-		// Boost the font size and minimize the opacity outline to let
-		// the character fill out the bounds of the texture as much as
-		// possible.
-		// fontSize = 11 * scale
-		// outlineOffset = 1
-		// the baseline will be manually adjusted in tglp
+// A glyph whose rendered widths don't fit in the CWDH
+type widthOverflow struct {
+	char       uint16
+	leftWidth  int
+	glyphWidth int
+	charWidth  int // measured advance of the replacement font
+	advance    int // CWDH char width after bold
+}
 
-	case "External":
-		fontSize = 15 * scale
+func printWidthOverflowReport(overflows []widthOverflow, fontSize float64, scale float64) {
+	fmt.Printf("warning: %d glyph(s) are wider than %d pixels and were clamped:\n", len(overflows), MAX_GLYPH_WIDTH)
+	for _, overflow := range overflows {
+		fmt.Printf("  %s left width %d, glyph width %d, char width %d, advance %d\n", formatChar(overflow.char), overflow.leftWidth, overflow.glyphWidth, overflow.charWidth, overflow.advance)
+	}
 
-	default:
-		panic("file texture generation settings unknown")
+	maxFontSize, maxScale := widthOverflowLimits(overflows, fontSize, scale)
+	fmt.Println("these glyphs will be cut off in game. To fix this either:")
+	fmt.Printf("  use a font size of at most %.1f instead of %.1f\n", maxFontSize, fontSize)
+	fmt.Printf("  use a scale of at most %.2f instead of %.2f\n", maxScale, scale)
+	fmt.Println("  or use a narrower replacement font. Cell widths are capped at 255 as well, a different -columns layout won't help")
+}
+
+// The font size and scale the widest overflow would just fit at, rounded down
+// to what the report prints
+func widthOverflowLimits(overflows []widthOverflow, fontSize float64, scale float64) (maxFontSize float64, maxScale float64) {
+	widest := 0
+	for _, overflow := range overflows {
+		widest = int(math.Max(float64(widest), math.Max(float64(overflow.glyphWidth), math.Max(float64(overflow.charWidth), float64(overflow.advance)))))
+		// LeftWidth is signed, it has half the range
+		widest = int(math.Max(float64(widest), 2*math.Abs(float64(overflow.leftWidth))))
 	}
 
-	return
+	shrink := float64(MAX_GLYPH_WIDTH) / float64(widest)
+	return math.Floor(fontSize*shrink*10) / 10, math.Floor(scale*shrink*100) / 100
 }
 
 // In most cases the ascii code maps to the correct glyph in the font file. For
@@ -457,12 +1256,20 @@ func getBotwFontSettings(fontName string, scale float64) (fontSize float64, outl
 var ancientMap map[uint16]uint16
 var externalMap map[uint16]uint16
 
+// Mappings read from -glyph-map files by botw font, see glyphMatchCommand.
+// They win over the manual mappings.
+var glyphMapFiles = make(map[string]map[uint16]uint16)
+
 func initializeGlyphMaps() {
 	ancientMap = getBotwAncientMapping()
 	externalMap = getBotwExternalMapping()
 }
 
 func asciiToGlyph(fontName string, ascii uint16) uint16 {
+	if glyphIndex, exists := glyphMapFiles[fontName][ascii]; exists {
+		return glyphIndex
+	}
+
 	var asciiToGlyphMap map[uint16]uint16
 	switch fontName {
 	case "Ancient":
@@ -472,8 +1279,6 @@ func asciiToGlyph(fontName string, ascii uint16) uint16 {
 	case "NormalS":
 	case "External":
 		asciiToGlyphMap = externalMap
-	default:
-		panic("unknown font mapping")
 	}
 
 	glyphIndex, manualMappingExists := asciiToGlyphMap[ascii]
@@ -484,6 +1289,37 @@ func asciiToGlyph(fontName string, ascii uint16) uint16 {
 	return ascii
 }
 
+// Characters of the replacement font drawn for characters of a botw font,
+// looked up in the font's character map: the mapped character (see
+// asciiToGlyph) if the font has it, else the character itself. The font has
+// neither for the missing ones, it draws its missing glyph box for them. An
+// empty fontName looks the characters up as they are.
+func replacementChars(f *sfnt.Font, fontName string, chars []rune) (replacements map[rune]rune, missing []rune) {
+	var buf sfnt.Buffer
+	hasGlyph := func(char rune) bool {
+		index, err := f.GlyphIndex(&buf, char)
+		return err == nil && index != 0
+	}
+
+	replacements = make(map[rune]rune, len(chars))
+	for _, char := range chars {
+		mapped := char
+		if fontName != "" {
+			mapped = rune(asciiToGlyph(fontName, uint16(char)))
+		}
+		switch {
+		case hasGlyph(mapped):
+			replacements[char] = mapped
+		case hasGlyph(char):
+			replacements[char] = char
+		default:
+			missing = append(missing, char)
+		}
+	}
+
+	return replacements, missing
+}
+
 // mapping botw external font character indexes to nintendo_ext_003.ttf
 func getBotwAncientMapping() map[uint16]uint16 {
 	botwAncientMapping := make(map[uint16]uint16, 0)
@@ -567,6 +1403,84 @@ func getBotwExternalMapping() map[uint16]uint16 {
 	return botwExternalMapping
 }
 
+// Shearing moves the ink above the baseline one way and the ink below it the
+// other way. Returns how far the glyph has to be drawn to the right so the
+// sheared ink still starts at the left of the cell, and how much wider the ink
+// gets. above and below are the ink's pixels above and below the baseline.
+func italicShear(above int, below int, slope float64) (shift int, extraWidth int) {
+	shift = int(math.Ceil(math.Max(float64(below)*slope, -float64(above)*slope)))
+	if shift < 0 {
+		shift = 0
+	}
+	extraWidth = int(math.Ceil(float64(above+below) * math.Abs(slope)))
+
+	return shift, extraWidth
+}
+
+// Shears src around the baseline and adds it to dst. A pixel h pixels above
+// the baseline moves h*slope pixels to the right. Coverage is split between the
+// two pixels it lands on to keep the edges anti-aliased. Anything sheared
+// outside of src's bounds (the glyph's cell) is dropped.
+func drawSheared(dst *image.Alpha, src *image.Alpha, baselineY int, slope float64) {
+	bounds := src.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		shift := float64(baselineY-y) * slope
+		whole := int(math.Floor(shift))
+		fraction := shift - float64(whole)
+
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			alpha := float64(src.AlphaAt(x, y).A)
+			if alpha == 0 {
+				continue
+			}
+			addAlpha(dst, bounds, x+whole, y, alpha*(1-fraction))
+			addAlpha(dst, bounds, x+whole+1, y, alpha*fraction)
+		}
+	}
+}
+
+// Grows the ink of img by radius pixels in every direction (a square max
+// filter). Done as a horizontal then a vertical pass.
+func dilateAlpha(img *image.Alpha, radius int) *image.Alpha {
+	bounds := img.Bounds()
+	horizontal := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var maxAlpha uint8
+			for dx := -radius; dx <= radius; dx++ {
+				if (image.Point{x + dx, y}).In(bounds) && img.AlphaAt(x+dx, y).A > maxAlpha {
+					maxAlpha = img.AlphaAt(x+dx, y).A
+				}
+			}
+			horizontal.SetAlpha(x, y, color.Alpha{maxAlpha})
+		}
+	}
+
+	res := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var maxAlpha uint8
+			for dy := -radius; dy <= radius; dy++ {
+				if (image.Point{x, y + dy}).In(bounds) && horizontal.AlphaAt(x, y+dy).A > maxAlpha {
+					maxAlpha = horizontal.AlphaAt(x, y+dy).A
+				}
+			}
+			res.SetAlpha(x, y, color.Alpha{maxAlpha})
+		}
+	}
+
+	return res
+}
+
+func addAlpha(img *image.Alpha, clip image.Rectangle, x int, y int, alpha float64) {
+	if !(image.Point{x, y}).In(clip) {
+		return
+	}
+
+	i := img.PixOffset(x, y)
+	img.Pix[i] = uint8(math.Min(float64(img.Pix[i])+alpha, 255))
+}
+
 func drawHorizontalLine(img *image.Alpha, x1, y, x2 int) {
 	for ; x1 <= x2; x1++ {
 		img.Set(x1, y, color.Opaque)
@@ -1,18 +1,37 @@
 package bffnt_headers
 
 import (
+	"bytes"
 	"crypto/md5"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
 	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"testing"
+	"time"
+	"unicode"
 
+	"github.com/disintegration/imaging"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
 )
 
 func TestBFFNT(t *testing.T) {
@@ -98,9 +117,9 @@ func testCase(t *testing.T, bffntFile string, expectedFileHash string) {
 	var encodedKRNG []byte
 	if strings.Index(string(bffntRaw), KRNG_MAGIC_HEADER) != -1 {
 		var krng KRNG
-		krng.Decode(bffntRaw)
 		krngStart := uint32(strings.Index(string(bffntRaw), KRNG_MAGIC_HEADER))
-		encodedKRNG = krng.Encode(krngStart)
+		krng.Decode(bffntRaw, krngStart+8)
+		encodedKRNG = krng.Encode(krngStart + 8)
 		krngEnd := krngStart + krng.SectionSize
 		expectedKRNG := bffntRaw[krngStart:krngEnd]
 		assert.Equal(t, expectedKRNG, encodedKRNG, "KRNG encoding did not produce the correct results")
@@ -162,7 +181,12 @@ func verifyBffnt(t *testing.T, bffntRaw []byte) {
 	tglp.Decode(bffntRaw)
 	cwdhList = DecodeCWDHs(bffntRaw, finf.CWDHOffset)
 	cmapList = DecodeCMAPs(bffntRaw, finf.CMAPOffset)
-	krng.Decode(bffntRaw)
+	// fonts without kerning have nothing at the end of the file
+	krngOffset := uint32(len(bffntRaw) + 8)
+	if krngStart >= 0 {
+		krngOffset = uint32(krngStart + 8)
+	}
+	krng.Decode(bffntRaw, krngOffset)
 
 	assertFail(t, 0, ffntStart, "ffnt should start at the byte 0")
 	assertFail(t, FFNT_MAGIC_HEADER, ffnt.MagicHeader, `ffnt magic header should be "FFNT"`)
@@ -318,6 +342,3753 @@ func verifyBffnt(t *testing.T, bffntRaw []byte) {
 	assertFail(t, 0, int(pos)%4, "bffnt should end on a 4 byte boundary")
 }
 
+func TestDownscale(t *testing.T) {
+	for _, fontName := range []string{"Caption", "Normal", "NormalS", "External"} {
+		bffntRaw, err := ioutil.ReadFile(fmt.Sprintf("../WiiU_fonts/botw/%[1]s/%[1]s_00.bffnt", fontName))
+		handleErr(err)
+
+		var bffnt BFFNT
+		bffnt.Decode(bffntRaw)
+		bffnt.Upscale(0.75)
+		gridWidth := int(bffnt.TGLP.NumOfColumns) * (int(bffnt.TGLP.CellWidth) + 1)
+		gridHeight := int(bffnt.TGLP.NumOfRows) * (int(bffnt.TGLP.CellHeight) + 1)
+		assert.LessOrEqual(t, gridWidth, int(bffnt.TGLP.SheetWidth), "downscaled cell grid should fit in the sheet width")
+		assert.LessOrEqual(t, gridHeight, int(bffnt.TGLP.SheetHeight), "downscaled cell grid should fit in the sheet height")
+
+		var downscaled BFFNT
+		downscaled.Decode(bffnt.Encode())
+		tglp := downscaled.TGLP
+		assertFail(t, int(tglp.SheetSize)*int(tglp.NumOfSheets), len(tglp.AllSheetData), fontName+" sheet data should fill every sheet")
+		if tglp.SheetImageFormat == IMAGE_FORMAT_BC4 {
+			// BC4 rows of 4x4 blocks are padded to a pitch of at least 32
+			// blocks, small sheets end up bigger than SheetWidth*SheetHeight/2
+			blocksWide := math.Max(math.Ceil(float64(tglp.SheetWidth)/4), 32)
+			blocksHigh := math.Ceil(float64(tglp.SheetHeight) / 4)
+			assertFail(t, int(blocksWide*blocksHigh*8), int(tglp.SheetSize), fontName+" SheetSize should be whole padded BC4 blocks")
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	assertFail(t, false, hasLintErrors(bffnt.Lint()), "original font should not have lint errors")
+
+	// point a character past the last glyph
+	bffnt.CMAPs[0].CharIndex[0] = uint16(bffnt.glyphCount())
+	assertFail(t, true, hasLintErrors(bffnt.Lint()), "out of range CMAP index should be a lint error")
+}
+
+func TestResolveDuplicateChars(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	cmapCount := len(bffnt.CMAPs)
+
+	// map 'A' a second time to a different glyph
+	bffnt.CMAPs = append(bffnt.CMAPs, CMAP{
+		MagicHeader:    "CMAP",
+		CodeBegin:      'A',
+		CodeEnd:        'A',
+		MappingMethod:  2,
+		CharacterCount: 1,
+		CharAscii:      []uint16{'A'},
+		CharIndex:      []uint16{0},
+	})
+	assertFail(t, true, hasLintErrors(bffnt.Lint()), "duplicate character should be a lint error")
+	assertFail(t, 1, bffnt.ResolveDuplicateChars(), "one duplicate should be removed")
+
+	var decoded BFFNT
+	decoded.Decode(bffnt.Encode())
+	assertFail(t, cmapCount+1, len(decoded.CMAPs), "empty scan map should still be encoded")
+	assertFail(t, 0, len(decoded.Lint()), "resolved font should not have lint issues")
+}
+
+func TestStripUnmappedKerning(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	if bffnt.KRNG.KerningTable == nil {
+		bffnt.KRNG.KerningTable = make(map[uint16][]kerningPair)
+	}
+
+	// private use characters aren't mapped by the botw fonts
+	bffnt.KRNG.KerningTable['A'] = append(bffnt.KRNG.KerningTable['A'], kerningPair{0xE000, -1})
+	bffnt.KRNG.KerningTable[0xE000] = []kerningPair{{'A', -1}, {'B', -1}}
+	assertFail(t, 2, len(lintKerningChars(&bffnt)), "both unmapped characters should be reported")
+	assertFail(t, 3, bffnt.StripUnmappedKerning(), "every pair with an unmapped character should be removed")
+	assertFail(t, 0, len(lintKerningChars(&bffnt)), "stripped kerning table should not have issues")
+}
+
+func TestSheetLimits(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	glyphCount := bffnt.glyphCount()
+
+	assertFail(t, nil, checkSheetLimits(bffnt.TGLP.scaledLayout(2), glyphCount, 2, "wiiu"), "2x Normal should fit on the Wii U")
+	layout := bffnt.TGLP.scaledLayout(5)
+	assertFail(t, true, checkSheetLimits(layout, glyphCount, 5, "wiiu") != nil, "5x Normal should be too tall for the Wii U")
+	assertFail(t, nil, checkSheetLimits(layout, glyphCount, 5, "switch"), "5x Normal should fit on the Switch")
+
+	columns, fits := columnsThatFit(layout, glyphCount, 8192)
+	assertFail(t, true, fits, "5x Normal should fit with fewer rows")
+	assertFail(t, nil, checkSheetLimits(layout.withColumns(columns, glyphCount), glyphCount, 5, "wiiu"), "suggested columns should fit")
+	assertFail(t, true, checkSheetLimits(layout, glyphCount, 5, "ps5") != nil, "unknown platforms should fail")
+
+	layout = bffnt.TGLP.scaledLayout(1.5)
+	assertFail(t, true, applyPowerOfTwoPolicy(&layout, "require") != nil, "1.5x Normal is not a power of two")
+	assertFail(t, nil, applyPowerOfTwoPolicy(&layout, "round"), "rounding should not fail")
+	assertFail(t, [2]int{2048, 4096}, [2]int{layout.sheetWidth, layout.sheetHeight}, "sheet should be padded to the next power of two")
+	assertFail(t, nil, applyPowerOfTwoPolicy(&layout, "require"), "rounded sheet is a power of two")
+}
+
+func TestVerifyEncoded(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.Upscale(2)
+	encodedRaw := bffnt.Encode()
+	assertFail(t, nil, bffnt.VerifyEncoded(encodedRaw), "freshly encoded font should verify")
+
+	bffnt.CWDHs[0].Glyphs[0].CharWidth++
+	assertFail(t, true, bffnt.VerifyEncoded(encodedRaw) != nil, "changed char width should not verify")
+	assertFail(t, true, bffnt.VerifyEncoded(encodedRaw[:100]) != nil, "truncated font should not verify")
+}
+
+func TestEqualFonts(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var a, b BFFNT
+	a.Decode(bffntRaw)
+	b.Decode(bffntRaw)
+
+	// the mapping method doesn't change which glyph a character maps to
+	for i := range b.CMAPs {
+		if b.CMAPs[i].MappingMethod == 0 {
+			b.CMAPs[i].MappingMethod = 1
+		}
+	}
+	equal, differences := EqualFonts(&a, &b)
+	assertFail(t, true, equal, fmt.Sprint("mapping method should be ignored ", differences))
+
+	b.FINF.LineFeed++
+	b.CWDHs[0].Glyphs[3].CharWidth++
+	equal, differences = EqualFonts(&a, &b)
+	assertFail(t, false, equal, "changed fonts should not be equal")
+	assertFail(t, 2, len(differences), "line feed and glyph 3 should differ")
+	assertFail(t, "CWDH glyph 3", differences[1].Section+" "+differences[1].Field, "glyph difference should name the glyph")
+}
+
+func TestReadOriginalBffnt(t *testing.T) {
+	const bffntFile = "../WiiU_fonts/botw/Normal/Normal_00.bffnt"
+	defer delete(originalBffnts, bffntFile)
+	bffntRaw, err := ioutil.ReadFile(bffntFile)
+	handleErr(err)
+
+	// every scale starts from the same decoded font, changing one copy
+	// can't leak into the next
+	first := readOriginalBffnt(bffntFile, upscaleOptions{})
+	first.Upscale(2)
+	first.CMAPs[0].CharIndex[0] = 1234
+	first.CWDHs[0].Glyphs[0].CharWidth = 99
+	for firstChar := range first.KRNG.KerningTable {
+		first.KRNG.KerningTable[firstChar][0].KerningValue = 42
+	}
+	assertFail(t, 1, len(originalBffnts), "the font should be decoded once")
+
+	second := readOriginalBffnt(bffntFile, upscaleOptions{})
+	assertFail(t, true, bytes.Equal(bffntRaw, second.Encode()), "a copy should encode like the original file")
+}
+
+func TestLeftoverPolicy(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	// put garbage in the padding after the first CWDH's glyph data
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	cwdh := bffnt.CWDHs[0]
+	leftoverStart := int(bffnt.FINF.CWDHOffset) - 8 + CWDH_HEADER_SIZE + 3*len(cwdh.Glyphs)
+	leftoverEnd := int(bffnt.FINF.CWDHOffset) - 8 + int(cwdh.SectionSize)
+	if leftoverStart == leftoverEnd {
+		t.Skip("first CWDH has no padding")
+	}
+	corruptRaw := append([]byte{}, bffntRaw...)
+	corruptRaw[leftoverStart] = 0xAB
+
+	assert.Panics(t, func() {
+		var corrupt BFFNT
+		corrupt.Decode(corruptRaw)
+	}, "non zero leftovers should be fatal by default")
+
+	warned := BFFNT{LeftoverPolicy: LEFTOVER_WARN}
+	warned.Decode(corruptRaw)
+	assertFail(t, 0, len(warned.CWDHs[0].Leftovers), "warn should drop the leftovers")
+
+	preserved := BFFNT{LeftoverPolicy: LEFTOVER_PRESERVE}
+	preserved.Decode(corruptRaw)
+	assertFail(t, corruptRaw[leftoverStart:leftoverEnd], preserved.CWDHs[0].Leftovers, "preserve should keep the leftovers")
+
+	reencoded := BFFNT{LeftoverPolicy: LEFTOVER_PRESERVE}
+	reencoded.Decode(preserved.Encode())
+	assertFail(t, preserved.CWDHs[0].Leftovers, reencoded.CWDHs[0].Leftovers, "preserved leftovers should be encoded again")
+}
+
+func TestEncodeWithoutKerning(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	assertFail(t, true, bffnt.KRNG.Present(), "Normal should have kerning")
+
+	bffnt.KRNG.KerningTable = nil
+	encodedRaw := bffnt.Encode()
+	assertFail(t, false, strings.Contains(string(encodedRaw[bffnt.FINF.CMAPOffset:]), KRNG_MAGIC_HEADER), "empty KRNG should not be written")
+
+	var decoded BFFNT
+	decoded.Decode(encodedRaw)
+	assertFail(t, false, decoded.KRNG.Present(), "decoded font should not have kerning")
+	assertFail(t, len(encodedRaw), int(decoded.FFNT.TotalFileSize), "file size should not count the missing KRNG")
+}
+
+func TestDecodePartial(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	var complete BFFNT
+	assertFail(t, true, complete.DecodePartial(bffntRaw).Complete(), "original font should decode completely")
+
+	// cut the file in the middle of the cmaps
+	cutAt := int(complete.FINF.CMAPOffset) + 100
+	var partial BFFNT
+	report := partial.DecodePartial(bffntRaw[:cutAt])
+	assertFail(t, false, report.Complete(), "truncated font should not decode completely")
+	assertFail(t, true, report.StoppedAt >= int(complete.FINF.CMAPOffset)-8, "decoding should stop in the cmaps")
+	assertFail(t, len(complete.CWDHs), len(partial.CWDHs), "cwdhs before the cut should be decoded")
+	assertFail(t, "KRNG", report.Missing[len(report.Missing)-1], "kerning should be missing")
+	assert.Panics(t, func() {
+		var strict BFFNT
+		strict.Decode(bffntRaw[:cutAt])
+	}, "strict decode should fail on truncated files")
+}
+
+func TestRepair(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	_, fixes, err := Repair(bffntRaw)
+	assertFail(t, nil, err, "original font should be repairable")
+	assertFail(t, 0, len(fixes), "original font should not need fixes")
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	cwdhStart := int(bffnt.FINF.CWDHOffset) - 8
+	cmapStart := int(bffnt.FINF.CMAPOffset) - 8
+
+	// the kind of damage hex editing leaves behind
+	damaged := append([]byte{}, bffntRaw...)
+	binary.BigEndian.PutUint32(damaged[FFNT_TOTAL_FILE_SIZE_POS:], 1234)
+	binary.BigEndian.PutUint32(damaged[cwdhStart+4:], 16)
+	binary.BigEndian.PutUint32(damaged[cmapStart+16:], 0)
+	binary.BigEndian.PutUint32(damaged[FINF_CMAP_OFFSET_POS:], 8)
+	damaged = append(damaged, 0, 0, 0, 0)
+
+	repaired, fixes, err := Repair(damaged)
+	assertFail(t, nil, err, "damaged font should be repairable")
+	assertFail(t, 5, len(fixes), "every damaged field and the trailing bytes should be fixed")
+	assertFail(t, bffntRaw, repaired, "repaired font should match the original")
+}
+
+func TestGeometry(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	original := bffnt
+	assertFail(t, 0, len(lintGeometry(&bffnt)), "original geometry should be consistent")
+
+	bffnt.Upscale(2)
+	assertFail(t, 0, len(checkUpscaledGeometry(original.FINF, original.TGLP, bffnt.FINF, bffnt.TGLP, 2)), "upscaled geometry should be consistent")
+
+	bffnt.TGLP.BaselinePosition += 20
+	assertFail(t, 1, len(checkUpscaledGeometry(original.FINF, original.TGLP, bffnt.FINF, bffnt.TGLP, 2)), "moved baseline should diverge from the ascent")
+	assertFail(t, 1, len(lintGeometry(&bffnt)), "moved baseline should be far from the ascent")
+}
+
+func TestValidation(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	assertFail(t, nil, bffnt.Validation().Err(), "original font should validate")
+
+	damaged := append([]byte{}, bffntRaw...)
+	binary.BigEndian.PutUint32(damaged[FFNT_TOTAL_FILE_SIZE_POS:], 1234)
+	binary.BigEndian.PutUint32(damaged[TGLP_START+4:], 5678)
+
+	var decoded BFFNT
+	decoded.Decode(damaged)
+	assertFail(t, 2, len(decoded.Validation().Failures), "both inconsistencies should be reported")
+	assertFail(t, true, hasLintErrors(decoded.Lint()), "validation failures should be lint errors")
+}
+
+func TestTrace(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	original, err := Trace(bffntRaw)
+	assertFail(t, nil, err, "original font should trace")
+	encoded, err := Trace(bffnt.Encode())
+	assertFail(t, nil, err, "re-encoded font should trace")
+	assertFail(t, true, DiffTraces(original, encoded).Empty(), "re-encoded trace should match")
+
+	// change the char width of the first glyph
+	damaged := append([]byte{}, bffntRaw...)
+	damaged[int(bffnt.FINF.CWDHOffset)-8+CWDH_HEADER_SIZE+2]++
+	changed, err := Trace(damaged)
+	assertFail(t, nil, err, "damaged font should trace")
+	diff := DiffTraces(original, changed)
+	assertFail(t, 1, len(diff.Changed), "only one field should change")
+	assertFail(t, true, strings.HasPrefix(diff.Changed[0], "CWDH 0.glyph 0.CharWidth"), "the char width should change")
+	assertFail(t, "", diff.Shift, "nothing should move")
+}
+
+func TestCompareSheets(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.TGLP.DecodeSheets()
+	sheets := bffnt.TGLP.SheetData
+
+	differences := CompareSheets(sheets, sheets)
+	assertFail(t, len(sheets), len(differences), "every sheet should be compared")
+	assertFail(t, true, math.IsInf(differences[0].PSNR, 1), "identical sheets should have infinite PSNR")
+	assertFail(t, 1.0, differences[0].SSIM, "identical sheets should have an SSIM of 1")
+
+	changed := []image.NRGBA{*imaging.Clone(&sheets[0])}
+	pixel := changed[0].NRGBAAt(0, 0)
+	pixel.A = 255 - pixel.A
+	changed[0].SetNRGBA(0, 0, pixel)
+	differences = CompareSheets(sheets[:1], changed)
+	assertFail(t, 1, differences[0].ChangedPixels, "one pixel should differ")
+	assertFail(t, true, differences[0].SSIM < 1, "SSIM should drop")
+	assertFail(t, uint8(0), differences[0].Heatmap.NRGBAAt(1, 1).R, "unchanged pixels shouldn't be marked")
+}
+
+func TestMetricScalings(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	originalGlyphs := bffnt.allGlyphInfo()
+	bffnt.Upscale(2)
+
+	rows := metricScalings(originalGlyphs, &bffnt, 2)
+	assertFail(t, len(originalGlyphs)*3, len(rows), "every glyph should have three metrics")
+	for _, row := range rows {
+		if math.Abs(row.deviation()) > 1 {
+			t.Errorf("%s %s of glyph %d should only be off by rounding: %+.2f", row.char, row.metric, row.index, row.deviation())
+		}
+	}
+
+	bffnt.CWDHs[0].Glyphs[0].CharWidth += 5
+	rows = metricScalings(originalGlyphs, &bffnt, 2)
+	assertFail(t, "CharWidth", rows[2].metric, "third row should be the first glyph's char width")
+	assertFail(t, 5.0, rows[2].deviation(), "adjusted char width should deviate")
+}
+
+func TestSpacingOutliers(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	outliers := bffnt.customSpacing()
+	assertFail(t, true, len(outliers) > 0, "Caption has glyphs with custom spacing")
+	assertFail(t, true, len(outliers) < bffnt.glyphCount()/4, "most glyphs should have regular spacing")
+
+	index := int(bffnt.CWDHIndexMap['A'])
+	_, custom := outliers[index]
+	assertFail(t, false, custom, "A should have regular spacing")
+
+	bffnt.CWDHs[0].Glyphs[index].CharWidth += 10
+	_, custom = bffnt.customSpacing()[index]
+	assertFail(t, true, custom, "A with a much wider advance should have custom spacing")
+}
+
+func TestRegress(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Special/Special_00.bffnt")
+	handleErr(err)
+
+	result := regressFont(BFFNT{}, bffntRaw, []float64{2, 3})
+	assertFail(t, 0, len(compareRegressResults("Special", result, regressFont(BFFNT{}, bffntRaw, []float64{2, 3}))), "results should be stable")
+	assertFail(t, true, strings.HasPrefix(result.Upscaled["3.00"], "error:"), "Special at 3x exceeds the cell size limit")
+
+	damaged := append([]byte{}, bffntRaw...)
+	binary.BigEndian.PutUint32(damaged[FFNT_TOTAL_FILE_SIZE_POS:], 1234)
+	changes := compareRegressResults("Special", result, regressFont(BFFNT{}, damaged, []float64{2, 3}))
+	assertFail(t, 2, len(changes), "file hash and validation should change")
+
+	corpus := Corpus{Root: "../WiiU_fonts", Fonts: []string{"botw/*/*_00.bffnt", "botw/Normal/*.bffnt"}}
+	assertFail(t, 6, len(corpus.fontFiles()), "every botw font should be found once")
+}
+
+func TestCMAPValidation(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	assertFail(t, nil, bffnt.Validation().Err(), "original cmaps should validate")
+
+	for i := range bffnt.CMAPs {
+		cmap := &bffnt.CMAPs[i]
+		switch cmap.MappingMethod {
+		case 1:
+			charIndex := cmap.CharIndex
+			cmap.CharIndex = charIndex[1:]
+			assertFail(t, 1, len(cmap.validate().Failures), "short table should fail")
+			cmap.CharIndex = charIndex
+		case 2:
+			cmap.CharAscii[0], cmap.CharAscii[1] = cmap.CharAscii[1], cmap.CharAscii[0]
+			cmap.CharIndex[0], cmap.CharIndex[1] = cmap.CharIndex[1], cmap.CharIndex[0]
+			assertFail(t, 1, len(cmap.validate().Failures), "unsorted scan entries should fail")
+		}
+	}
+
+	assertFail(t, true, bffnt.SortScanCMAPs() > 0, "Normal has scan cmaps to sort")
+	bffnt.Encode()
+	assertFail(t, nil, bffnt.Validation().Err(), "sorted cmaps should validate")
+	original, _ := Trace(bffntRaw)
+	encoded, _ := Trace(bffnt.Encode())
+	assertFail(t, true, DiffTraces(original, encoded).Empty(), "sorting should restore the original")
+}
+
+func TestCharIndex(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	mappings := bffnt.charIndexMap()
+	for char, expected := range mappings {
+		index, found := bffnt.CharIndex(rune(char))
+		if !found || index != expected {
+			t.Errorf("%s should map to %d, got %d (found %v)", formatChar(char), expected, index, found)
+		}
+	}
+	_, found := bffnt.CharIndex(0x10000)
+	assertFail(t, false, found, "characters outside the BMP can't be mapped")
+
+	// unsorted scan maps still have to be found once the index is rebuilt
+	for i := range bffnt.CMAPs {
+		cmap := &bffnt.CMAPs[i]
+		if cmap.MappingMethod == 2 {
+			last := len(cmap.CharAscii) - 1
+			cmap.CharAscii[0], cmap.CharAscii[last] = cmap.CharAscii[last], cmap.CharAscii[0]
+			cmap.CharIndex[0], cmap.CharIndex[last] = cmap.CharIndex[last], cmap.CharIndex[0]
+			bffnt.invalidateCharIndex()
+			index, found := bffnt.CharIndex(rune(cmap.CharAscii[0]))
+			assertFail(t, true, found, "swapped character should be found")
+			assertFail(t, mappings[cmap.CharAscii[0]], index, "swapped character should keep its index")
+		}
+	}
+
+	// duplicates are dropped from the index along with their mappings
+	bffnt.Decode(bffntRaw)
+	bffnt.CMAPs = append(bffnt.CMAPs, CMAP{MagicHeader: CMAP_MAGIC_HEADER, MappingMethod: 2, CodeBegin: 'A', CodeEnd: 'A',
+		CharAscii: []uint16{'A'}, CharIndex: []uint16{0}})
+	index, _ := bffnt.CharIndex('A')
+	assertFail(t, mappings['A'], index, "the first CMAP should win")
+	bffnt.ResolveDuplicateChars()
+	index, found = bffnt.CharIndex('A')
+	assertFail(t, true, found, "resolving duplicates should keep the first mapping")
+	assertFail(t, mappings['A'], index, "resolving duplicates should keep the first index")
+}
+
+func TestCharset(t *testing.T) {
+	charset := newCharset([]string{"e\u0301a", "\u00e9q\u0303\n"})
+	assertFail(t, []rune{'a', 'q', '\u00e9', '\u0303'}, charset.Chars, "composed and decomposed é should be one character")
+	assertFail(t, 2, len(charset.Warnings), "normalization and the unreachable q + tilde should be reported")
+
+	// a big endian UTF-16 msbt with a single message containing a control tag
+	message := []uint16{'H', 'i', 0x0E, 0, 3, 2, 0xABCD, '!', 0}
+	txt2 := make([]byte, 8)
+	binary.BigEndian.PutUint32(txt2[0:4], 1)
+	binary.BigEndian.PutUint32(txt2[4:8], 8)
+	for _, unit := range message {
+		txt2 = append(txt2, byte(unit>>8), byte(unit))
+	}
+	msbt := make([]byte, 0x20)
+	copy(msbt, "MsgStdBn")
+	msbt[8], msbt[9] = 0xFE, 0xFF
+	msbt[0x0C] = 1
+	binary.BigEndian.PutUint16(msbt[0x0E:], 1)
+	section := make([]byte, 16)
+	copy(section, "TXT2")
+	binary.BigEndian.PutUint32(section[4:], uint32(len(txt2)))
+	msbt = append(append(msbt, section...), txt2...)
+
+	messages, err := decodeMSBTMessages(msbt)
+	assertFail(t, nil, err, "msbt should decode")
+	assertFail(t, []string{"Hi!"}, messages, "control tags should be skipped")
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	assertFail(t, []rune{'\u0303'}, bffnt.missingChars(charset), "only the combining tilde should be missing")
+}
+
+func TestCellGrid(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	assertFail(t, 0, len(lintCellGrid(&bffnt)), "original grid should tile the sheets")
+
+	tglp := bffnt.TGLP
+	tglp.NumOfSheets = 1
+	issues := cellGridIssues(&tglp, bffnt.highestGlyphIndex())
+	assertFail(t, 1, len(issues), "one sheet is too small for every glyph")
+	assertFail(t, LINT_ERROR, issues[0].Severity, "missing cells should be an error")
+
+	tglp = bffnt.TGLP
+	tglp.NumOfColumns++
+	tglp.NumOfRows -= 2
+	issues = cellGridIssues(&tglp, bffnt.highestGlyphIndex())
+	assertFail(t, 2, len(issues), "columns overflow and rows leave room")
+	assertFail(t, LINT_ERROR, issues[0].Severity, "columns outside the sheet should be an error")
+	assertFail(t, LINT_WARNING, issues[1].Severity, "unused rows should be a warning")
+}
+
+func TestDegenerateFonts(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	// a single glyph in a single cell without kerning
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.KRNG = KRNG{}
+	bffnt.CWDHs = bffnt.CWDHs[:1]
+	bffnt.CWDHs[0].Glyphs = bffnt.CWDHs[0].Glyphs[:1]
+	bffnt.CWDHs[0].EndIndex = 0
+	bffnt.CMAPs = []CMAP{{MagicHeader: CMAP_MAGIC_HEADER, CodeBegin: 'A', CodeEnd: 'A', CharAscii: []uint16{'A'}, CharIndex: []uint16{0}}}
+	bffnt.TGLP.applyLayout(bffnt.TGLP.scaledLayout(1).withColumns(1, 1))
+
+	var decoded BFFNT
+	report := decoded.DecodePartial(bffnt.Encode())
+	assertFail(t, nil, report.Err, "single glyph font should decode")
+	assertFail(t, 0, len(decoded.Lint()), "single glyph font should lint clean")
+
+	original := decoded.TGLP
+	decoded.TGLP.AllSheetData = decoded.TGLP.EncodeBlankSheets()
+	decoded.Upscale(2)
+	assertFail(t, 0, len(findSpacingOutliers(&original, decoded.allGlyphInfo())), "blank sheets have no outliers")
+	encodedRaw := decoded.Encode()
+	assertFail(t, nil, decoded.VerifyEncoded(encodedRaw), "upscaled single glyph font should encode")
+
+	// no glyphs and no characters at all
+	bffnt.CWDHs = nil
+	bffnt.CMAPs = nil
+	var empty BFFNT
+	report = empty.DecodePartial(bffnt.Encode())
+	assertFail(t, nil, report.Err, "empty font should decode")
+	assertFail(t, 0, empty.glyphCount(), "empty font should have no glyphs")
+	assertFail(t, 1, bffnt.TGLP.scaledLayout(1).withColumns(4, 0).rows, "empty font still needs a cell")
+
+	// a KRNG header without any data
+	krngRaw := append(make([]byte, 8), []byte(KRNG_MAGIC_HEADER)...)
+	krngRaw = append(krngRaw, 0, 0, 0, 8)
+	var krng KRNG
+	krng.Decode(krngRaw, 16)
+	assertFail(t, false, krng.Present(), "empty KRNG should have no kerning")
+}
+
+func TestKRNGOffsets(t *testing.T) {
+	// a KRNG whose only first character is A, with its pairs at pairsAt
+	// bytes into the data
+	krngWithPairs := func(pairsAt int, pairCount uint16, dataSize int) []byte {
+		raw := make([]byte, 8+KRNG_HEADER_SIZE+dataSize)
+		section := raw[8:]
+		copy(section, KRNG_MAGIC_HEADER)
+		binary.BigEndian.PutUint32(section[4:8], uint32(KRNG_HEADER_SIZE+dataSize))
+		data := section[KRNG_HEADER_SIZE:]
+		binary.BigEndian.PutUint16(data[0:2], 1)
+		binary.BigEndian.PutUint16(data[2:4], 'A')
+		binary.BigEndian.PutUint16(data[4:6], uint16(pairsAt/2))
+		if pairsAt+6 <= len(data) {
+			binary.BigEndian.PutUint16(data[pairsAt:pairsAt+2], pairCount)
+			binary.BigEndian.PutUint16(data[pairsAt+2:pairsAt+4], 'V')
+			binary.BigEndian.PutUint16(data[pairsAt+4:pairsAt+6], 0xFFFE)
+		}
+		return raw
+	}
+
+	// past 64 KB the doubled offset used to wrap around in a uint16: the
+	// pairs of B start 65548 bytes into the data
+	large := KRNG{KerningTable: map[uint16][]kerningPair{'A': make([]kerningPair, 16384), 'B': {{'V', -2}}}}
+	for i := range large.KerningTable['A'] {
+		large.KerningTable['A'][i] = kerningPair{uint16(0x4E00 + i), -1}
+	}
+	largeRaw := append(make([]byte, 8), large.Encode(16)...)
+	var decodedLarge KRNG
+	decodedLarge.Decode(largeRaw, 16)
+	assert.Equal(t, large.KerningTable, decodedLarge.KerningTable)
+
+	for _, tc := range []struct {
+		raw      []byte
+		expected string
+	}{
+		{krngWithPairs(64, 1, 16), "the pairs of U+0041 'A' would be at offset 80"},
+		{krngWithPairs(8, 100, 16), "the 100 pairs of U+0041 'A' at offset 26 end at offset 426"},
+	} {
+		var krng KRNG
+		assert.PanicsWithValue(t, "KRNG: "+tc.expected+", past the end of the kerning data at offset 32", func() { krng.Decode(tc.raw, 16) })
+	}
+	var truncated KRNG
+	assert.Panics(t, func() { truncated.Decode(krngWithPairs(8, 1, 16)[:30], 16) }, "a section that runs past the file")
+}
+
+func TestSectionWalker(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	magics := make([]string, 0)
+	sections := newSectionIterator(bffntRaw)
+	for sections.Next() {
+		header := sections.Section()
+		if len(magics) == 0 || magics[len(magics)-1] != header.MagicHeader {
+			magics = append(magics, header.MagicHeader)
+		}
+	}
+	assertFail(t, nil, sections.Err(), "walking the original font should not fail")
+	assertFail(t, fmt.Sprint(sectionOrder), fmt.Sprint(magics), "sections should be walked in file order")
+	assertFail(t, 0, len(bffnt.Validation().Failures), "offsets in FINF and the chains should match the walk")
+
+	// a section from the future is carried along
+	unknownRaw := []byte{'Z', 'Z', 'Z', 'Z', 0, 0, 0, 12, 1, 2, 3, 4}
+	withUnknown := append(append([]byte{}, bffntRaw...), unknownRaw...)
+	binary.BigEndian.PutUint32(withUnknown[12:16], uint32(len(withUnknown)))
+	var unknown BFFNT
+	unknown.Decode(withUnknown)
+	assertFail(t, 1, len(unknown.ExtraSections), "unknown section should be kept")
+	assert.IsType(t, &UnknownSection{}, unknown.ExtraSections[0])
+	encodedRaw := unknown.Encode()
+	assert.Equal(t, unknownRaw, encodedRaw[len(encodedRaw)-len(unknownRaw):], "unknown section should be written back unchanged")
+	assertFail(t, unknown.KRNG.SectionSize, bffnt.KRNG.SectionSize, "kerning should still be found before the unknown section")
+
+	// cut off right between the last CMAP and KRNG, only the chain notices
+	cmapCount := len(bffnt.CMAPs)
+	lastCMAPEnd := int(bffnt.CMAPs[cmapCount-2].NextCMAPOffset) - 8 + int(bffnt.CMAPs[cmapCount-1].SectionSize)
+	var truncated BFFNT
+	report := truncated.DecodePartial(bffntRaw[:int(bffnt.CMAPs[cmapCount-2].NextCMAPOffset)-8])
+	assertFail(t, false, report.Complete(), "missing CMAP should be noticed")
+	report = truncated.DecodePartial(bffntRaw[:lastCMAPEnd])
+	assertFail(t, true, report.Complete(), "font without kerning is complete")
+
+	// sizes that would never move forward
+	broken := append([]byte{}, bffntRaw...)
+	binary.BigEndian.PutUint32(broken[int(bffnt.FINF.CMAPOffset)-4:], 0)
+	report = truncated.DecodePartial(broken)
+	assertFail(t, int(bffnt.FINF.CMAPOffset)-8, report.StoppedAt, "walk should stop at the broken size")
+}
+
+func TestDecodeLazy(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var complete BFFNT
+	complete.Decode(bffntRaw)
+
+	var lazy BFFNT
+	assertFail(t, nil, lazy.DecodeLazy(bffntRaw), "lazy decode should not fail")
+	assertFail(t, len(complete.CMAPs), len(lazy.CMAPs), "every CMAP header should be decoded")
+	assertFail(t, 0, len(lazy.CWDHs[0].Glyphs), "widths should not be decoded yet")
+	assertFail(t, 0, len(lazy.CMAPs[4].CharAscii), "character maps should not be decoded yet")
+	assertFail(t, &bffntRaw[lazy.TGLP.SheetDataOffset], &lazy.TGLP.AllSheetData[0], "sheet data should not be copied")
+	printInfo("Normal_00.bffnt", &lazy, bffntRaw, false)
+	for i := range lazy.CMAPs {
+		assertFail(t, true, lazy.CMAPs[i].lazy.pending(), "info should leave the character maps pending")
+	}
+
+	// a lookup builds the character index out of every CMAP
+	index, found := lazy.CharIndex('ア')
+	expectedIndex, _ := complete.CharIndex('ア')
+	assertFail(t, true, found, "character should be found")
+	assertFail(t, expectedIndex, index, "lazy lookup should match")
+	for i := range lazy.CMAPs {
+		assertFail(t, false, lazy.CMAPs[i].lazy.pending(), "every CMAP should be decoded for the index")
+	}
+	assertFail(t, true, lazy.CWDHs[0].lazy.pending(), "widths should still not be decoded")
+	assertFail(t, complete.KRNG.Kern('A', 'V'), lazy.KRNG.Kern('A', 'V'), "kerning should be decoded on lookup")
+
+	lazy.Load()
+	assert.Equal(t, complete.CWDHs, lazy.CWDHs, "loaded widths should match")
+	assert.Equal(t, complete.CMAPs, lazy.CMAPs, "loaded character maps should match")
+	assert.Equal(t, complete.CWDHIndexMap, lazy.CWDHIndexMap, "index map should be built on load")
+	assert.Equal(t, complete.Encode(), lazy.Encode(), "lazily decoded font should encode the same")
+}
+
+func TestCellBands(t *testing.T) {
+	sheet := image.Rect(0, 0, 320, 330)
+	bands := cellBands(95, 10, 33, sheet)
+	assertFail(t, 10, len(bands), "every row should get its own band when there are more bands than rows")
+
+	drawn := make([]int, 95)
+	renderBands(bands, 3, func(band *glyphBand) {
+		for cell := band.firstCell; cell < band.endCell; cell++ {
+			drawn[cell]++
+		}
+	})
+	for cell, count := range drawn {
+		assertFail(t, 1, count, fmt.Sprintf("cell %d should be drawn exactly once", cell))
+	}
+
+	assertFail(t, image.Rect(0, 0, 320, 66), bands[0].img.Bounds(), "first band should stop at the sheet")
+	assertFail(t, image.Rect(0, 99, 320, 198), bands[4].img.Bounds(), "bands should reach a row above and below")
+	assertFail(t, 0, len(cellBands(0, 10, 33, sheet)), "no glyphs means no bands")
+}
+
+func TestEncodeTo(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.Upscale(2)
+	expected := bffnt.Encode()
+
+	file, err := os.CreateTemp(t.TempDir(), "*.bffnt")
+	handleErr(err)
+	defer file.Close()
+
+	// something before the font, the file size is patched relative to where the font starts
+	prefix := []byte("prefix")
+	_, err = file.Write(prefix)
+	handleErr(err)
+	written, err := bffnt.EncodeTo(file)
+	assertFail(t, nil, err, "streaming encode should not fail")
+	assertFail(t, len(expected), written, "streaming encode should write the whole font")
+
+	actual, err := ioutil.ReadFile(file.Name())
+	handleErr(err)
+	assert.Equal(t, append(prefix, expected...), actual, "streamed font should match the encoded one")
+	assertFail(t, 0, len(bffnt.Validation().Failures), "streaming encode should validate")
+}
+
+func TestGlyphMeasurementCache(t *testing.T) {
+	fontFile := "../nintendo_system_ui/nintendo_ext_003.ttf"
+	dat, err := os.ReadFile(fontFile)
+	handleErr(err)
+	f, err := opentype.Parse(dat)
+	handleErr(err)
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: 30, DPI: 144, Hinting: font.HintingFull})
+	handleErr(err)
+
+	cache := newGlyphMeasurementCache()
+	key := faceKey{fontFile, 30, ""}
+	drawer := font.Drawer{Face: face, Dot: fixed.P(37, 51)}
+	for _, glyph := range []string{"A", "j", "A"} {
+		measurement := cache.measure(key, face, glyph)
+		expectedBounds, _ := drawer.BoundString(glyph)
+		assertFail(t, expectedBounds, measurement.boundsAt(drawer.Dot), "cached bounds of "+glyph+" should match")
+		assertFail(t, drawer.MeasureString(glyph), measurement.advance, "cached advance of "+glyph+" should match")
+	}
+	assertFail(t, 1, cache.hits, "second A should come from the cache")
+	assertFail(t, 2, cache.misses, "A and j should be measured once each")
+
+	cache.measure(faceKey{fontFile, 15, ""}, face, "A")
+	assertFail(t, 3, cache.misses, "other sizes should be measured separately")
+}
+
+func TestRunesForIndex(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	assertFail(t, false, bffnt.charIndexes == nil, "reverse map should be built while decoding")
+
+	mappings := bffnt.charIndexMap()
+	counted := 0
+	for index := 0; index < bffnt.glyphCount(); index++ {
+		runes := bffnt.RunesForIndex(uint16(index))
+		for i, char := range runes {
+			if mappings[uint16(char)] != uint16(index) {
+				t.Errorf("%s is listed for glyph %d but maps to %d", formatChar(uint16(char)), index, mappings[uint16(char)])
+			}
+			if i > 0 && runes[i-1] >= char {
+				t.Errorf("runes of glyph %d should be sorted", index)
+			}
+		}
+		counted += len(runes)
+	}
+	assertFail(t, len(mappings), counted, "every mapped character should be listed once")
+	assertFail(t, 0, len(bffnt.RunesForIndex(65534)), "unused glyph index should have no runes")
+
+	// a glyph shared by two characters, the shadowed mapping of a later CMAP doesn't count
+	index, _ := bffnt.CharIndex('A')
+	bffnt.CMAPs = append(bffnt.CMAPs, CMAP{MagicHeader: CMAP_MAGIC_HEADER, MappingMethod: 2, CodeBegin: 'B', CodeEnd: 0xE000,
+		CharAscii: []uint16{'B', 0xE000}, CharIndex: []uint16{index, index}})
+	bffnt.invalidateCharIndex()
+	assert.Equal(t, []rune{'A', 0xE000}, bffnt.RunesForIndex(index), "both characters should be listed")
+	assertFail(t, "glyph "+fmt.Sprint(index)+" (U+0041 'A', U+E000)", bffnt.glyphLabel(int(index)), "label should list every character")
+}
+
+func TestMemoryStats(t *testing.T) {
+	// a disabled tracker does nothing
+	var disabled *memoryTracker
+	disabled.stage("decode")()
+	assertFail(t, "", disabled.finish(), "disabled tracker should have no report")
+
+	tracker := startMemoryStats()
+	endOuter := tracker.stage("render")
+	endInner := tracker.stage("format conversion")
+	buffer := make([]byte, 8<<20)
+	for i := range buffer {
+		buffer[i] = byte(i)
+	}
+	endInner()
+	endOuter()
+	report := tracker.finish()
+
+	assertFail(t, 2, len(tracker.stages), "both stages should be tracked")
+	assertFail(t, 1, tracker.stages[1].depth, "nested stage should be indented")
+	for _, stage := range tracker.stages {
+		if stage.allocated < uint64(len(buffer)) || stage.peakHeap < uint64(len(buffer)) {
+			t.Errorf("%s should have seen the %d byte buffer: peak %d, allocated %d", stage.name, len(buffer), stage.peakHeap, stage.allocated)
+		}
+	}
+	assertFail(t, true, strings.Contains(report, "    format conversion"), "report should list the nested stage")
+	assertFail(t, byte(255), buffer[255], "buffer should still be alive")
+}
+
+func TestIncrementalEncode(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+	sheetsStart := func(b *BFFNT) int { return int(b.TGLP.SheetDataOffset) }
+
+	// tuning widths and kerning keeps the original sheets
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.CWDHs[0].Glyphs[0].CharWidth++
+	bffnt.AdjustTracking(0, 1)
+	assertFail(t, true, bffnt.TGLP.sheetsUntouched(), "metric changes should leave the sheets untouched")
+	encoded := bffnt.Encode()
+	sheets := bffntRaw[sheetsStart(&bffnt) : sheetsStart(&bffnt)+bffnt.TGLP.sheetsSize()]
+	assertFail(t, true, bytes.Equal(sheets, encoded[sheetsStart(&bffnt):sheetsStart(&bffnt)+len(sheets)]), "original sheets should be copied")
+	assertFail(t, nil, bffnt.VerifyEncoded(encoded), "tuned font should verify")
+
+	var streamed bytes.Buffer
+	_, err = bffnt.TGLP.writeEncoded(&streamed)
+	handleErr(err)
+	assertFail(t, true, bytes.Equal(bffnt.TGLP.Encode(), streamed.Bytes()), "streamed TGLP should match")
+
+	// replaced sheet data or a changed header gets blank template sheets again
+	bffnt.TGLP.AllSheetData = append([]byte(nil), bffnt.TGLP.AllSheetData...)
+	assertFail(t, false, bffnt.TGLP.sheetsUntouched(), "copied sheet data should not count as untouched")
+	bffnt.Decode(bffntRaw)
+	bffnt.TGLP.BaselinePosition++
+	assertFail(t, true, bffnt.TGLP.sheetsUntouched(), "a new baseline should keep the sheets")
+	encoded = bffnt.Encode()
+	assertFail(t, true, bytes.Equal(sheets, encoded[sheetsStart(&bffnt):sheetsStart(&bffnt)+len(sheets)]), "sheets should be kept with a new baseline")
+	assertFail(t, nil, bffnt.VerifyEncoded(encoded), "new baseline should verify")
+	bffnt.TGLP.CellWidth++
+	assertFail(t, false, bffnt.TGLP.sheetsUntouched(), "changed sheet layout should not count as untouched")
+	encoded = bffnt.Encode()
+	assertFail(t, true, bytes.Equal(make([]byte, len(sheets)), encoded[sheetsStart(&bffnt):sheetsStart(&bffnt)+len(sheets)]), "sheets should be blank")
+	assertFail(t, nil, bffnt.VerifyEncoded(encoded), "changed font should verify")
+}
+
+func TestSheetCache(t *testing.T) {
+	for _, fontName := range []string{"Ancient", "Normal"} {
+		bffntRaw, err := ioutil.ReadFile(fmt.Sprintf("../WiiU_fonts/botw/%[1]s/%[1]s_00.bffnt", fontName))
+		handleErr(err)
+		var bffnt BFFNT
+		bffnt.Decode(bffntRaw)
+
+		SheetCacheDir = ""
+		bffnt.TGLP.DecodeSheets()
+		uncached := bffnt.TGLP.SheetData
+
+		SheetCacheDir = t.TempDir()
+		bffnt.TGLP.DecodeSheets()
+		key := bffnt.TGLP.sheetCacheKey(bffnt.TGLP.AllSheetData[:bffnt.TGLP.SheetSize], 0)
+		assertFail(t, false, bffnt.TGLP.cachedSheet(key) == nil, fontName+" sheet should be cached")
+		bffnt.TGLP.DecodeSheets()
+		assert.Equal(t, uncached, bffnt.TGLP.SheetData, fontName+" cached sheets should match")
+
+		// a broken entry is decoded again and replaced
+		handleErr(os.WriteFile(sheetCachePath(key), []byte{1, 2, 3}, 0644))
+		bffnt.TGLP.DecodeSheets()
+		assert.Equal(t, uncached, bffnt.TGLP.SheetData, fontName+" broken cache entry should be ignored")
+		assertFail(t, false, bffnt.TGLP.cachedSheet(key) == nil, fontName+" broken cache entry should be replaced")
+
+		// a different layout of the same bytes is a different entry
+		bffnt.TGLP.SheetImageFormat++
+		assertFail(t, false, key == bffnt.TGLP.sheetCacheKey(bffnt.TGLP.AllSheetData[:bffnt.TGLP.SheetSize], 0), "format should be part of the key")
+	}
+	SheetCacheDir = ""
+}
+
+func TestParallelEach(t *testing.T) {
+	assertFail(t, 3, workerCount(0, 3), "-threads should be used by default")
+	assertFail(t, 5, workerCount(5, 3), "a pool's own setting should win")
+	assertFail(t, runtime.GOMAXPROCS(0), workerCount(0, 0), "GOMAXPROCS should be the default")
+
+	// nested pools share the budget
+	for _, split := range [][4]int{{8, 2, 2, 4}, {8, 20, 8, 1}, {3, 2, 2, 1}, {4, 0, 1, 4}} {
+		outer, inner := splitWorkers(split[0], split[1])
+		assertFail(t, [2]int{split[2], split[3]}, [2]int{outer, inner}, fmt.Sprintf("%d threads for %d items", split[0], split[1]))
+		assertFail(t, true, outer*inner <= split[0], "nested pools should stay within the budget")
+	}
+
+	calls := make([]int, 100)
+	parallelEach(len(calls), 0, func(i int) { calls[i]++ })
+	for i, count := range calls {
+		if count != 1 {
+			t.Errorf("index %d should be worked on once, was %d times", i, count)
+		}
+	}
+	parallelEach(0, 0, func(i int) { t.Error("nothing should be worked on") })
+
+	err := catchPanic(func() {
+		parallelEach(10, 0, func(i int) {
+			if i == 7 {
+				panic("broken sheet")
+			}
+		})
+	})
+	assertFail(t, "error: broken sheet", fmt.Sprint(err), "panics should reach the caller")
+
+	// with several panics the lowest index wins, like in a plain loop
+	for run := 0; run < 20; run++ {
+		err = catchPanic(func() {
+			parallelEach(50, 8, func(i int) {
+				if i%10 == 3 {
+					panic(fmt.Sprint("broken sheet ", i))
+				}
+			})
+		})
+		assertFail(t, "error: broken sheet 3", fmt.Sprint(err), "the lowest panicking index should be reported")
+	}
+}
+
+func TestPngCompression(t *testing.T) {
+	defer func(level png.CompressionLevel) { pngEncoder.CompressionLevel = level }(pngEncoder.CompressionLevel)
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	pngCompressionFlag(flags)
+	assertFail(t, false, flags.Parse([]string{"-png-compression", "fastest"}) == nil, "unknown level should be rejected")
+
+	img := image.NewAlpha(image.Rect(0, 0, 64, 32))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	for _, name := range []string{"default", "fast", "best", "none"} {
+		handleErr(flags.Parse([]string{"-png-compression", name}))
+		assertFail(t, pngCompressionLevels[name], pngEncoder.CompressionLevel, name+" should set the level")
+
+		filename := filepath.Join(t.TempDir(), name+".png")
+		writePng(filename, img)
+		file, err := os.Open(filename)
+		handleErr(err)
+		decoded, err := png.Decode(file)
+		handleErr(err)
+		file.Close()
+		for i, alpha := range img.Pix {
+			x, y := i%64, i/64
+			if _, _, _, a := decoded.At(x, y).RGBA(); uint8(a>>8) != alpha {
+				t.Fatalf("%s compression changed pixel (%d, %d)", name, x, y)
+			}
+		}
+	}
+}
+
+func TestEncodeBC4(t *testing.T) {
+	// a block that fits either palette exactly comes back unchanged
+	for _, values := range [][16]uint8{
+		{0, 0, 255, 255, 0, 255, 0, 255, 0, 0, 0, 0, 255, 255, 255, 255},
+		{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10},
+		{0, 255, 40, 80, 0, 255, 40, 80, 0, 255, 40, 80, 0, 255, 40, 80},
+	} {
+		decoded := decodeBC4(encodeBC4(values[:], 1, 1, 0), 1, 1)
+		assert.Equal(t, values[:], decoded, "block should survive encoding")
+	}
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.TGLP.DecodeSheets()
+	original := bffnt.TGLP.SheetData
+
+	bffnt.TGLP.threads = 1
+	single := bffnt.TGLP.EncodeSheetData()
+	bffnt.TGLP.threads = 4
+	encoded := bffnt.TGLP.EncodeSheetData()
+	assertFail(t, true, bytes.Equal(single, encoded), "tiles should encode the same on any amount of workers")
+	assertFail(t, len(bffnt.TGLP.AllSheetData), len(encoded), "encoded sheets should fill the sheet size")
+
+	// BC4 is lossy, a pixel can be off by up to half of the widest palette
+	// step, 255/7/2, plus the rounding of the palette
+	bffnt.TGLP.AllSheetData = encoded
+	bffnt.TGLP.DecodeSheets()
+	worst, total := 0, 0
+	for i := 3; i < len(original[0].Pix); i += 4 {
+		difference := int(math.Abs(float64(original[0].Pix[i]) - float64(bffnt.TGLP.SheetData[0].Pix[i])))
+		worst = int(math.Max(float64(worst), float64(difference)))
+		total += difference
+	}
+	if pixels := len(original[0].Pix) / 4; worst > 20 || float64(total)/float64(pixels) > 0.1 {
+		t.Errorf("re-encoded sheet is too far off, worst pixel is %d off, %.3f on average", worst, float64(total)/float64(pixels))
+	}
+}
+
+func TestFormatLimits(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+	expectLimit := func(field string, encode func()) {
+		err := catchPanic(encode)
+		if err == nil || !strings.Contains(err.Error(), field+" would be") {
+			t.Errorf("%s overflow should fail with an explicit error, got %v", field, err)
+		}
+	}
+
+	// sheets past 4 GB fail before anything is allocated for them
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.TGLP.SheetSize = 1 << 31
+	bffnt.TGLP.NumOfSheets = 3
+	expectLimit("TGLP sheet data size", func() { bffnt.Encode() })
+
+	// more glyphs than a uint16 glyph index can reach
+	cwdh := CWDH{MagicHeader: CWDH_MAGIC_HEADER, Glyphs: make([]glyphInfo, 70000)}
+	expectLimit("CWDH end index", func() { cwdh.Encode(0, true) })
+
+	// the kerning data offsets are stored halved in a uint16, so kerning data
+	// past 128 KB can't be pointed to
+	krng := KRNG{KerningTable: make(map[uint16][]kerningPair)}
+	for firstChar := uint16(0); firstChar < 1000; firstChar++ {
+		krng.KerningTable[firstChar] = make([]kerningPair, 40)
+	}
+	expectLimit("KRNG kerning data offset / 2", func() { krng.Encode(0) })
+
+	var layout TGLP
+	expectLimit("TGLP sheet height", func() {
+		layout.applyLayout(sheetLayout{cellWidth: 10, cellHeight: 10, columns: 1, rows: 7000, sheetWidth: 11, sheetHeight: 77000})
+	})
+
+	// a header pointing past the end of the file fails to decode with the
+	// offsets instead of a slice bounds panic
+	truncated := append([]byte(nil), bffntRaw[:int(bffnt.TGLP.SheetDataOffset)+100]...)
+	err = catchPanic(func() {
+		var decoded BFFNT
+		decoded.TGLP.Decode(truncated)
+	})
+	assertFail(t, true, err != nil && strings.Contains(err.Error(), "TGLP sheet data would be at offsets"), "truncated sheets should fail with their offsets")
+}
+
+func TestMetricsOnly(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	original := bffnt.TGLP
+	originalWidth := bffnt.CWDHs[0].Glyphs[0].CharWidth
+	bffnt.Upscale(2)
+	bffnt.TGLP.keepSheets(original)
+
+	assertFail(t, true, bffnt.TGLP.sheetsUntouched(), "metrics only should keep the sheets")
+	assertFail(t, original.CellWidth, bffnt.TGLP.CellWidth, "cell width should stay")
+	assertFail(t, original.SheetHeight, bffnt.TGLP.SheetHeight, "sheet height should stay")
+	assertFail(t, uint16(scaleMetric(float64(original.BaselinePosition), 2)), bffnt.TGLP.BaselinePosition, "baseline should be scaled")
+	assertFail(t, int(originalWidth)*2, int(bffnt.CWDHs[0].Glyphs[0].CharWidth), "widths should be scaled")
+
+	encoded := bffnt.Encode()
+	assertFail(t, len(bffntRaw), len(encoded), "size should stay the same")
+	sheetsStart := int(bffnt.TGLP.SheetDataOffset)
+	sheetsEnd := sheetsStart + bffnt.TGLP.sheetsSize()
+	assertFail(t, true, bytes.Equal(bffntRaw[sheetsStart:sheetsEnd], encoded[sheetsStart:sheetsEnd]), "original sheets should be written")
+	assertFail(t, nil, bffnt.VerifyEncoded(encoded), "metrics only font should verify")
+}
+
+func TestLogger(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	// two fonts with different settings decoded side by side
+	var debugOut, quietOut bytes.Buffer
+	debugFont := BFFNT{Log: &Logger{Debug: true, Out: &debugOut}}
+	quietFont := BFFNT{Log: &Logger{Out: &quietOut}}
+	parallelEach(2, 2, func(i int) {
+		if i == 0 {
+			debugFont.Decode(bffntRaw)
+		} else {
+			quietFont.Decode(bffntRaw)
+		}
+	})
+
+	assertFail(t, true, strings.Contains(debugOut.String(), `"MagicHeader": "FINF"`), "sections should log through the font's logger")
+	assertFail(t, true, strings.Contains(debugOut.String(), "image data"), "TGLP should log through the font's logger")
+	assertFail(t, 0, quietOut.Len(), "a font without debug shouldn't log anything")
+	assertFail(t, false, DefaultLogger.Debug, "the default logger shouldn't be touched")
+
+	// a nil logger falls back to the default one
+	var nilLogger *Logger
+	assertFail(t, DefaultLogger.Debug, nilLogger.debugging(), "nil logger should use the default")
+}
+
+func TestDeterministicRender(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/External/External_00.bffnt")
+	handleErr(err)
+	initializeGlyphMaps()
+	fontFile := filepath.Join("..", resolveFontFile("External", ""))
+	sheetFile := "External_00_2.00x.png"
+	defer os.Remove(sheetFile)
+
+	// the sheet and the widths written have to come out the same no matter
+	// how many workers render them or in what order the bands finish
+	render := func(threads int, workers int) ([]byte, []byte) {
+		bffnt := BFFNT{Threads: threads}
+		bffnt.Decode(bffntRaw)
+		bffnt.Upscale(2)
+		bffnt.generateTexture("External", fontFile, 2, upscaleOptions{workers: workers, threads: threads})
+		sheet, err := ioutil.ReadFile(sheetFile)
+		handleErr(err)
+		return sheet, bffnt.Encode()
+	}
+
+	expectedSheet, expectedFont := render(1, 0)
+	for _, pool := range [][2]int{{3, 0}, {0, 7}, {16, 0}} {
+		sheet, font := render(pool[0], pool[1])
+		assertFail(t, true, bytes.Equal(expectedSheet, sheet), fmt.Sprintf("sheet rendered with %v should match", pool))
+		assertFail(t, true, bytes.Equal(expectedFont, font), fmt.Sprintf("font rendered with %v should match", pool))
+	}
+}
+
+func TestBench(t *testing.T) {
+	assertFail(t, "Normal", benchFontName("botw/Normal/Normal_00.bffnt"), "botw font should be found by file name")
+	assertFail(t, "", benchFontName("botw/Special/Special_00.bffnt"), "fonts without render settings shouldn't be rendered")
+
+	fastest := fastestTimes([]benchTimes{{"decode": 3, "render": 10}, {"decode": 2, "render": 12}})
+	assertFail(t, benchTimes{"decode": 2, "render": 10}, fastest, "fastest time of every stage should be kept")
+	assertFail(t, 12.0, fastest.total(), "total should add up the stages")
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/External/External_00.bffnt")
+	handleErr(err)
+	initializeGlyphMaps()
+	times := benchFont(BFFNT{}, bffntRaw, "External", filepath.Join("..", resolveFontFile("External", "")), 2)
+	for _, stage := range benchStages {
+		_, timed := times[stage]
+		assertFail(t, true, timed, stage+" should be timed")
+	}
+	unrendered := benchFont(BFFNT{}, bffntRaw, "", "", 2)
+	_, rendered := unrendered["render"]
+	assertFail(t, false, rendered, "unknown fonts shouldn't be rendered")
+
+	results := []benchResult{
+		{font: "a.bffnt", scale: 2, times: benchTimes{"decode": 2, "encode": 4}},
+		{font: "b.bffnt", scale: 2, err: fmt.Errorf("error: too big")},
+	}
+	table := formatBenchTable(results, nil)
+	assertFail(t, true, strings.Contains(table, "6.0ms"), "rows should have a total")
+	assertFail(t, true, strings.Contains(table, "error: too big"), "failed fonts should show their error")
+	compared := formatBenchTable(results, map[string]benchTimes{"a.bffnt@2.00": {"decode": 4, "encode": 4}})
+	assertFail(t, true, strings.Contains(compared, "2.0ms -50%"), "times should be compared against the baseline")
+	assertFail(t, true, strings.Contains(compared, "6.0ms -25%"), "totals should be compared against the baseline")
+}
+
+func TestDecodeSections(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var complete BFFNT
+	complete.Decode(bffntRaw)
+
+	var widths BFFNT
+	assertFail(t, nil, widths.DecodeSections(bffntRaw, SectionCWDH|SectionKRNG), "selective decode should not fail")
+	assert.Equal(t, complete.CWDHs, widths.CWDHs, "widths should be decoded")
+	assert.Equal(t, complete.KRNG.KerningTable, widths.KRNG.KerningTable, "kerning should be decoded")
+	assertFail(t, complete.FINF, widths.FINF, "FINF should always be decoded")
+	for i := range widths.CMAPs {
+		assertFail(t, true, widths.CMAPs[i].lazy.pending(), "character maps should not be decoded")
+	}
+	assertFail(t, true, widths.CWDHIndexMap == nil, "index map needs the character maps")
+
+	var chars BFFNT
+	assertFail(t, nil, chars.DecodeSections(bffntRaw, SectionCMAP), "selective decode should not fail")
+	assertFail(t, true, chars.CWDHs[0].lazy.pending(), "widths should not be decoded")
+	assertFail(t, true, chars.KRNG.lazy.pending(), "kerning should not be decoded")
+	assert.Equal(t, complete.CWDHIndexMap, chars.CWDHIndexMap, "index map should be built with the character maps")
+
+	// whatever was left out still gets decoded when it's needed
+	widths.CWDHs[0].Glyphs[0].CharWidth++
+	complete.CWDHs[0].Glyphs[0].CharWidth++
+	assert.Equal(t, complete.Encode(), widths.Encode(), "selectively decoded font should encode the same")
+}
+
+// Records the biggest single write
+type chunkRecorder struct {
+	bytes.Buffer
+	largestWrite int
+}
+
+func (w *chunkRecorder) Write(p []byte) (int, error) {
+	if len(p) > w.largestWrite {
+		w.largestWrite = len(p)
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestInjectedSheets(t *testing.T) {
+	// A8 sheets convert back to the exact same bytes
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.TGLP.DecodeSheets()
+	bffnt.TGLP.AllSheetData = append([]byte(nil), bffnt.TGLP.AllSheetData...)
+	assertFail(t, false, bffnt.TGLP.sheetsUntouched(), "copied sheet data should not be untouched")
+	assertFail(t, true, bffnt.TGLP.sheetsInjected(), "decoded sheets fit the layout")
+
+	encoded := bffnt.Encode()
+	assertFail(t, true, bytes.Equal(bffntRaw, encoded), "injected sheets should be written")
+	assertFail(t, nil, bffnt.VerifyEncoded(encoded), "injected sheets should verify")
+
+	var streamed chunkRecorder
+	_, err = bffnt.TGLP.writeEncoded(&streamed)
+	handleErr(err)
+	assertFail(t, true, bytes.Equal(bffnt.TGLP.Encode(), streamed.Bytes()), "streamed TGLP should match")
+	assertFail(t, int(bffnt.TGLP.SheetSize), streamed.largestWrite, "sheets should be written one at a time")
+
+	// sheets that don't fit the layout anymore are left out
+	bffnt.TGLP.applyLayout(bffnt.TGLP.scaledLayout(2))
+	assertFail(t, false, bffnt.TGLP.sheetsInjected(), "sheets of the old layout should not be injected")
+	encoded = bffnt.Encode()
+	assertFail(t, true, allZeroBytes(encoded[bffnt.TGLP.SheetDataOffset:int(bffnt.TGLP.SheetDataOffset)+bffnt.TGLP.sheetsSize()]), "sheets should be blank")
+}
+
+func TestTiledSwizzle(t *testing.T) {
+	// a few tiles and a half one at the bottom
+	const width, height = 512, 1056
+	pixels := make([]byte, width*height)
+	for i := range pixels {
+		pixels[i] = byte(i*7 + i/width)
+	}
+	swizzleWith := func(threads int) []byte {
+		return swizzle(width, height, 1, height, 1, 0, 2, 4, 0, width, 8, 0, 0, pixels, threads)
+	}
+
+	serial := swizzleWith(1)
+	for _, threads := range []int{2, 5, 16} {
+		assertFail(t, true, bytes.Equal(serial, swizzleWith(threads)), fmt.Sprintf("swizzling with %d threads should match", threads))
+	}
+	assertFail(t, true, bytes.Equal(pixels, deswizzle(width, height, 1, height, 1, 0, 2, 4, 0, width, 8, 0, 0, serial, 0)), "deswizzling should give back the pixels")
+}
+
+func TestSectionWriter(t *testing.T) {
+	w := newSectionWriter("TEST", 12)
+	w.magic("TEST")
+	w.u16(0x0102)
+	w.i8(-1)
+	w.u8(7)
+	w.i16(-2)
+	w.zeros(2)
+	raw, err := w.bytes()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{'T', 'E', 'S', 'T', 1, 2, 0xFF, 7, 0xFF, 0xFE, 0, 0}, raw)
+
+	// the first error sticks, the writes after it are skipped
+	w = newSectionWriter("TEST", 8)
+	w.magic("TOOLONG")
+	w.u32(1)
+	_, err = w.bytes()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "magic header")
+
+	w = newSectionWriter("TEST", 4)
+	w.u32(1)
+	w.u8(1)
+	_, err = w.bytes()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "overflows")
+
+	w = newSectionWriter("TEST", 8)
+	w.u32(1)
+	_, err = w.bytes()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "wrote 4 byte(s) of the 8 byte section")
+
+	// an unset magic header used to make a short section silently
+	var tglp TGLP
+	err = catchPanic(func() { tglp.EncodeHeader() })
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "encoding TGLP")
+}
+
+func TestAlphaBufferPool(t *testing.T) {
+	pool := newAlphaBufferPool()
+	first := pool.get(image.Rect(0, 0, 64, 32))
+	first.SetAlpha(5, 5, color.Alpha{255})
+	pool.put(first)
+
+	// same size somewhere else gets the same pixels back, cleared
+	second := pool.get(image.Rect(0, 32, 64, 64))
+	assert.Equal(t, &first.Pix[0], &second.Pix[0])
+	assert.Equal(t, image.Rect(0, 32, 64, 64), second.Bounds())
+	assert.Equal(t, make([]uint8, 64*32), second.Pix)
+	second.SetAlpha(0, 63, color.Alpha{255})
+	assert.Equal(t, uint8(255), second.Pix[second.PixOffset(0, 63)])
+
+	// a different size allocates
+	pool.get(image.Rect(0, 0, 32, 32))
+	assertFail(t, 2, pool.allocs, "allocations")
+	assertFail(t, 1, pool.reuses, "reuses")
+
+	// sub images share their pixels with the parent and can't be kept
+	pool.put(second.SubImage(image.Rect(0, 32, 16, 48)).(*image.Alpha))
+	assertFail(t, 0, len(pool.free[image.Pt(16, 16)]), "sub image kept")
+}
+
+func TestExtend(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	glyphCount := bffnt.glyphCount()
+	cmapCount := len(bffnt.CMAPs)
+	_, hadYo := bffnt.CharIndex('Ё')
+	assert.True(t, hadYo, "Normal already has Ё")
+
+	greek, err := scriptChars("greek")
+	assert.Nil(t, err)
+	cyrillic, _ := scriptChars("Cyrillic")
+	added := bffnt.AddChars(append(append([]rune{'A', 'Ё'}, cyrillic...), greek...))
+	assert.NotContains(t, added, 'A')
+	assert.NotContains(t, added, 'Ё')
+	assertFail(t, glyphCount+len(added), bffnt.glyphCount(), "a glyph for every added char")
+	assertFail(t, cmapCount, len(bffnt.CMAPs), "added to the existing CMAPs")
+
+	// Є is in the range of the Cyrillic table so it fills the hole there,
+	// Greek goes into the catch-all scan map
+	index, found := bffnt.CharIndex('Є')
+	assert.True(t, found)
+	assert.Equal(t, uint16(index), bffnt.CMAPs[2].CharIndex['Є'-0x0401])
+	index, found = bffnt.CharIndex('Ω')
+	assert.True(t, found)
+	assert.GreaterOrEqual(t, int(index), glyphCount)
+	assert.Empty(t, bffnt.Validation().Failures)
+
+	// survives encoding
+	var decoded BFFNT
+	decoded.Decode(bffnt.Encode())
+	assert.Empty(t, decoded.missingChars(newCharset([]string{string(added)})))
+	assertFail(t, bffnt.glyphCount(), decoded.glyphCount(), "decoded glyph count")
+
+	// nothing left to add
+	assert.Empty(t, bffnt.AddChars(greek))
+
+	_, err = scriptChars("klingon")
+	assert.NotNil(t, err)
+
+	// rows are only added when the grid runs out of cells
+	layout := sheetLayout{cellWidth: 10, cellHeight: 10, columns: 4, rows: 2, sheetWidth: 44, sheetHeight: 22}
+	assert.Equal(t, layout, layout.withRowsFor(8))
+	grown := layout.withRowsFor(9)
+	assertFail(t, 3, grown.rows, "rows")
+	assertFail(t, 33, grown.sheetHeight, "sheet height")
+	assertFail(t, 44, grown.sheetWidth, "sheet width")
+
+	initializeGlyphMaps()
+	covered, missing := fontCoverage("../nintendo_system_ui/nintendo_udsg-r_std_003.ttf", "Normal", []rune{'Ж', 'Ω', 'ỹ'})
+	assert.Equal(t, []rune{'Ж', 'Ω'}, covered)
+	assert.Equal(t, []rune{'ỹ'}, missing)
+
+	// paths in the config are relative to it
+	config := readExtendConfig("../extend.yaml")
+	assert.Equal(t, "Normal", config.Font)
+	assert.Equal(t, filepath.Join("..", "extended"), config.Output)
+	charset, err := config.chars()
+	assert.Nil(t, err)
+	assert.Contains(t, charset.Chars, 'ж')
+	assert.Contains(t, charset.Chars, 'ỹ')
+}
+
+func TestCombiningMarks(t *testing.T) {
+	assert.True(t, isCombiningMark(0x0301))
+	assert.False(t, isCombiningMark('e'))
+	assert.False(t, isCombiningMark(0x00B4)) // the spacing acute accent
+
+	// a mark without an advance keeps reaching back, a spacing one is moved
+	// back by its advance
+	left, width := markWidths(-12, 0)
+	assertFail(t, int8(-12), left, "left width of a mark without advance")
+	assertFail(t, uint8(0), width, "char width")
+	left, _ = markWidths(2, 10)
+	assertFail(t, int8(-8), left, "left width of a spacing mark")
+	left, _ = markWidths(-100, 100)
+	assertFail(t, int8(math.MinInt8), left, "clamped left width")
+
+	// tracking leaves zero width glyphs alone
+	cwdh := CWDH{Glyphs: []glyphInfo{{0, 10, 12}, {-6, 5, 0}}}
+	cwdh.AdjustCharWidths(2)
+	assertFail(t, uint8(14), cwdh.Glyphs[0].CharWidth, "tracked char width")
+	assertFail(t, uint8(0), cwdh.Glyphs[1].CharWidth, "mark char width")
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	glyphCount := bffnt.glyphCount()
+	added := bffnt.AddChars([]rune{0x0301, 0x0323})
+	assertFail(t, 2, len(added), "added marks")
+
+	initializeGlyphMaps()
+	bffnt.Upscale(1)
+	opts := upscaleOptions{addedGlyphs: map[int]bool{glyphCount: true, glyphCount + 1: true}, boldRadius: 1}
+	glyphMeasurements = newGlyphMeasurementCache()
+	sheet, _ := bffnt.renderGlyphSheet("Normal", "../nintendo_system_ui/nintendo_udsg-r_std_003.ttf", 1, opts)
+	defer alphaBuffers.put(sheet)
+	for _, mark := range added {
+		index, _ := bffnt.CharIndex(mark)
+		glyph := bffnt.CWDHs[0].Glyphs[index]
+		assertFail(t, uint8(0), glyph.CharWidth, formatChar(uint16(mark))+" char width")
+		assert.Less(t, int(glyph.LeftWidth), 0, formatChar(uint16(mark))+" reaches back over the previous character")
+		assert.Greater(t, int(glyph.GlyphWidth), 0)
+
+		_, cell := bffnt.TGLP.CellRect(int(index))
+		_, _, hasInk := inkColumns(imaging.Clone(sheet), cell)
+		assert.True(t, hasInk, formatChar(uint16(mark))+" is drawn in its cell")
+	}
+}
+
+func TestCharsetFromDump(t *testing.T) {
+	dir := t.TempDir()
+	handleErr(os.MkdirAll(filepath.Join(dir, "Message", "EUru"), 0755))
+	handleErr(os.WriteFile(filepath.Join(dir, "Message", "EUru", "Npc.txt"), []byte("Привет, Link!"), 0644))
+	handleErr(os.WriteFile(filepath.Join(dir, "Message", "Quest.TXT"), []byte("Ωμέγα"), 0644))
+	handleErr(os.WriteFile(filepath.Join(dir, "Message", "Layout.bflyt"), []byte("skipped"), 0644))
+	loose := filepath.Join(t.TempDir(), "extra.dat")
+	handleErr(os.WriteFile(loose, []byte("z"), 0644))
+
+	files := charsetFiles([]string{dir, loose})
+	assert.Equal(t, []string{
+		filepath.Join(dir, "Message", "EUru", "Npc.txt"),
+		filepath.Join(dir, "Message", "Quest.TXT"),
+		loose,
+	}, files)
+
+	charset := readCharset(files)
+	assert.Contains(t, charset.Chars, 'П')
+	assert.Contains(t, charset.Chars, 'έ')
+	assert.Contains(t, charset.Chars, 'z')
+	assert.NotContains(t, charset.Chars, 'q')
+
+	// the text output reads back to the same characters
+	text := formatCharsetText(charset.Chars)
+	assert.Equal(t, charset.Chars, newCharset([]string{text}).Chars)
+	long := make([]rune, 130)
+	for i := range long {
+		long[i] = rune(0x4E00 + i)
+	}
+	assertFail(t, 3, strings.Count(formatCharsetText(long), "\n"), "64 characters to a line")
+	assert.Equal(t, "U+0041 'A'\nU+03A9 'Ω'\n", formatCharsetCodepoints([]rune{'A', 'Ω'}))
+
+	assert.Equal(t, []string{"Cyrillic 2", "Common 1", "Greek 1"}, charsetScripts([]rune{'П', 'р', 'Ω', '!'}))
+}
+
+func TestAddCharsRepack(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.TGLP.DecodeSheets()
+	_, aCell := bffnt.TGLP.CellRect(int(mustCharIndex(&bffnt, 'A')))
+	original := bffnt.TGLP.SheetData[0].SubImage(aCell).(*image.NRGBA)
+
+	greek, _ := scriptChars("greek")
+	added := bffnt.AddChars(greek)
+	assert.NotEmpty(t, added)
+	// NormalS only has 50 free cells left
+	assertFail(t, 1, bffnt.TGLP.repackSheets(bffnt.glyphCount()), "sheets added")
+	assertFail(t, 0, bffnt.TGLP.repackSheets(bffnt.glyphCount()), "sheets added while there are free cells")
+	fontFile := "../nintendo_system_ui/nintendo_udsg-r_std_003.ttf"
+	fontSize := baselineFontSize(fontFile, int(bffnt.TGLP.BaselinePosition), int(bffnt.TGLP.CellHeight))
+	bffnt.drawCharsIntoCells(fontFile, fontSize, added)
+
+	// rebuilding keeps every mapping and merges the scattered ones
+	bffnt.buildCharIndex()
+	mappings := append([]charIndexEntry(nil), bffnt.charIndexes...)
+	before, after := bffnt.RebuildCMAPs()
+	assertFail(t, len(bffnt.CMAPs), after, "CMAP count")
+	assert.NotEqual(t, 0, before)
+	bffnt.buildCharIndex()
+	assert.Equal(t, mappings, bffnt.charIndexes)
+	for _, cmap := range bffnt.CMAPs[:len(bffnt.CMAPs)-1] {
+		assert.NotEqual(t, uint16(2), cmap.MappingMethod, "only the last CMAP scans")
+	}
+	assert.Empty(t, bffnt.Validation().Failures)
+
+	var decoded BFFNT
+	decoded.Decode(bffnt.Encode())
+	assert.Empty(t, decoded.missingChars(newCharset([]string{string(added)})))
+	decoded.TGLP.DecodeSheets()
+	// the original glyphs keep their pixels and the added ones have ink
+	_, cell := decoded.TGLP.CellRect(int(mustCharIndex(&decoded, 'A')))
+	assert.Equal(t, original.Pix, decoded.TGLP.SheetData[0].SubImage(cell).(*image.NRGBA).Pix)
+	sheet, cell := decoded.TGLP.CellRect(int(mustCharIndex(&decoded, 'Ω')))
+	ink := 0
+	omega := decoded.TGLP.SheetData[sheet]
+	for y := cell.Min.Y; y < cell.Max.Y; y++ {
+		for x := cell.Min.X; x < cell.Max.X; x++ {
+			if omega.NRGBAAt(x, y).A > 0 {
+				ink++
+			}
+		}
+	}
+	assert.Greater(t, ink, 20, "Ω is drawn into its cell")
+	width := decoded.CWDHs[0].Glyphs[mustCharIndex(&decoded, 'Ω')]
+	assert.Greater(t, int(width.CharWidth), 0)
+
+	assertFail(t, uint8(2), decoded.TGLP.NumOfSheets, "decoded sheet count")
+	sheet, _ = decoded.TGLP.CellRect(int(mustCharIndex(&decoded, 'ω')))
+	assertFail(t, 1, sheet, "ω is on the new sheet")
+}
+
+func mustCharIndex(b *BFFNT, char rune) uint16 {
+	index, found := b.CharIndex(char)
+	if !found {
+		panic(fmt.Sprintf("%s isn't mapped", formatChar(uint16(char))))
+	}
+	return index
+}
+
+func TestSpaceWidth(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	aWidth := bffnt.allGlyphInfo()[mustCharIndex(&bffnt, 'A')]
+
+	changed, err := bffnt.SetSpaceWidth("space", 17)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, changed, 1)
+	width, found := bffnt.spaceWidth("space")
+	assert.True(t, found)
+	assertFail(t, 17, width, "space width")
+	assert.Equal(t, aWidth, bffnt.allGlyphInfo()[mustCharIndex(&bffnt, 'A')], "other glyphs are left alone")
+
+	if _, mapped := bffnt.CharIndex(0x3000); mapped {
+		_, err = bffnt.SetSpaceWidth("ideographic", 40)
+		assert.Nil(t, err)
+		width, _ = bffnt.spaceWidth("ideographic")
+		assertFail(t, 40, width, "ideographic space width")
+	}
+
+	// survives encoding
+	var decoded BFFNT
+	decoded.Decode(bffnt.Encode())
+	width, _ = decoded.spaceWidth("space")
+	assertFail(t, 17, width, "decoded space width")
+
+	_, err = bffnt.SetSpaceWidth("space", MAX_GLYPH_WIDTH+1)
+	assert.NotNil(t, err)
+	_, err = bffnt.SetSpaceWidth("tab", 4)
+	assert.NotNil(t, err)
+
+	// the default of an upscale run keeps the widths
+	bffnt.applySpaceWidths(KEEP_SPACE_WIDTH, KEEP_SPACE_WIDTH)
+	width, _ = bffnt.spaceWidth("space")
+	assertFail(t, 17, width, "kept space width")
+}
+
+func TestAlterChar(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	assertFail(t, uint16(0), bffnt.FINF.AlterCharIndex, "botw draws unmapped characters as a space")
+
+	char, err := parseCharFlag("U+003F")
+	assert.Nil(t, err)
+	assertFail(t, '?', char, "code point")
+	char, _ = parseCharFlag("□")
+	assertFail(t, rune(0x25A1), char, "character")
+	_, err = parseCharFlag("ab")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, bffnt.SetAlterChar('?'))
+	assertFail(t, mustCharIndex(&bffnt, '?'), bffnt.FINF.AlterCharIndex, "alter char index")
+	assert.NotNil(t, bffnt.SetAlterChar(0x2603))
+	assert.NotNil(t, bffnt.SetAlterGlyph(bffnt.glyphCount()))
+
+	// new artwork from an image, the widths follow it
+	bffnt.TGLP.DecodeSheets()
+	art := image.NewAlpha(image.Rect(0, 0, 10, 12))
+	for y := 2; y < 10; y++ {
+		art.SetAlpha(4, y, color.Alpha{255})
+	}
+	assert.False(t, bffnt.drawImageIntoCell(int(bffnt.FINF.AlterCharIndex), art))
+	bffnt.TGLP.repackSheets(bffnt.glyphCount())
+
+	var decoded BFFNT
+	decoded.Decode(bffnt.Encode())
+	assertFail(t, bffnt.FINF.AlterCharIndex, decoded.FINF.AlterCharIndex, "decoded alter char index")
+	widths, _ := decoded.glyphInfoAt(int(decoded.FINF.AlterCharIndex))
+	assertFail(t, uint8(10), widths.GlyphWidth, "glyph width")
+	decoded.TGLP.DecodeSheets()
+	sheet, cell := decoded.TGLP.CellRect(int(decoded.FINF.AlterCharIndex))
+	ink := 0
+	for y := cell.Min.Y; y < cell.Max.Y; y++ {
+		for x := cell.Min.X; x < cell.Max.X; x++ {
+			if decoded.TGLP.SheetData[sheet].NRGBAAt(x, y).A > 0 {
+				ink++
+				assertFail(t, cell.Min.X+4, x, "ink column")
+			}
+		}
+	}
+	assertFail(t, 8, ink, "the old glyph is cleared and the image drawn")
+
+	// the alter glyph needs no mapping but has to exist
+	decoded.FINF.AlterCharIndex = uint16(decoded.glyphCount())
+	assert.True(t, hasLintErrors(lintCMAPIndexes(&decoded)))
+}
+
+func TestKerningProfiles(t *testing.T) {
+	profiles := readKerningProfiles("../kerning.yaml")
+	assert.Equal(t, []string{"cyrillic", "latin-tight"}, profiles.names())
+
+	pairs, err := profiles.resolve("cyrillic")
+	assert.Nil(t, err)
+	assertFail(t, -2, pairs[[2]rune{'A', 'V'}], "pair from the extended profile")
+	assertFail(t, 0, pairs[[2]rune{'L', 'T'}], "overridden pair")
+	assertFail(t, -1, pairs[[2]rune{'Г', 'А'}], "own pair")
+
+	_, err = profiles.resolve("klingon")
+	assert.NotNil(t, err)
+	looped := KerningProfiles{Profiles: map[string]KerningProfile{
+		"a": {Extends: []string{"b"}},
+		"b": {Extends: []string{"a"}},
+	}}
+	_, err = looped.resolve("a")
+	assert.NotNil(t, err)
+
+	first, second, err := parseKerningPair("U+0410 U+0412")
+	assert.Nil(t, err)
+	assert.Equal(t, []rune{'А', 'В'}, []rune{first, second})
+	_, _, err = parseKerningPair("ABC")
+	assert.NotNil(t, err)
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	untouched := bffnt.KRNG.Kern('P', 'd')
+
+	applied, skipped := bffnt.ApplyKerningProfile(map[[2]rune]int{
+		{'A', 'V'}:    -2,
+		{'Z', 'Z'}:    3,
+		{'A', 0x2603}: -1,
+	}, 2)
+	assertFail(t, 2, applied, "applied pairs")
+	assertFail(t, 1, skipped, "pairs with unmapped characters")
+	assertFail(t, int16(-4), bffnt.KRNG.Kern('A', 'V'), "scaled pair")
+	assertFail(t, int16(6), bffnt.KRNG.Kern('Z', 'Z'), "added pair")
+	assertFail(t, untouched, bffnt.KRNG.Kern('P', 'd'), "other pairs are kept")
+	seconds := bffnt.KRNG.KerningTable['A']
+	assert.True(t, sort.SliceIsSorted(seconds, func(i, j int) bool { return seconds[i].SecondChar < seconds[j].SecondChar }))
+
+	bffnt.KRNG.SetPair('Z', 'Z', 0)
+	assertFail(t, int16(0), bffnt.KRNG.Kern('Z', 'Z'), "0 removes the pair")
+	for _, pair := range bffnt.KRNG.KerningTable['Z'] {
+		assert.NotEqual(t, uint16('Z'), pair.SecondChar)
+	}
+
+	var decoded BFFNT
+	decoded.Decode(bffnt.Encode())
+	assertFail(t, int16(-4), decoded.KRNG.Kern('A', 'V'), "decoded pair")
+}
+
+func TestMeasureText(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	glyphs := bffnt.allGlyphInfo()
+	a := glyphs[mustCharIndex(&bffnt, 'A')]
+	v := glyphs[mustCharIndex(&bffnt, 'V')]
+	kerning := int(bffnt.KRNG.Kern('A', 'V'))
+	assert.NotEqual(t, 0, kerning, "Normal kerns AV")
+
+	measurement := bffnt.MeasureText("AV")
+	assertFail(t, int(a.CharWidth)+int(v.CharWidth)+kerning, measurement.width, "width")
+	assertFail(t, int(a.CharWidth)+int(v.CharWidth), measurement.unkernedWidth, "width without kerning")
+	assertFail(t, int(a.CharWidth)+kerning, measurement.chars[1].pen, "pen position of V")
+	inkRight := measurement.chars[1].pen + int(v.LeftWidth) + int(v.GlyphWidth)
+	assertFail(t, inkRight-int(a.LeftWidth), measurement.inkWidth, "ink width")
+
+	// unmapped characters take the alter char's widths
+	measurement = bffnt.MeasureText("☃")
+	assert.False(t, measurement.chars[0].mapped)
+	assertFail(t, int(glyphs[bffnt.FINF.AlterCharIndex].CharWidth), measurement.width, "alter char width")
+	assertFail(t, 0, bffnt.MeasureText("").width, "empty string")
+}
+
+func TestFitScale(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	original := bffnt.MeasureText("Adventure Log").width
+
+	// scaling a copy leaves the font alone
+	scaled := bffnt.scaledMetrics(2)
+	assert.Greater(t, scaled.MeasureText("Adventure Log").width, original*2-10)
+	assertFail(t, original, bffnt.MeasureText("Adventure Log").width, "original width")
+
+	constraints := readFitConfig("../fit.yaml").Strings
+	result, found := bffnt.FitScale(constraints, 1, 4, 0.05, -3, 0)
+	assert.True(t, found)
+	assert.Greater(t, result.scale, 1.0)
+	assert.LessOrEqual(t, result.tracking, 0)
+	assert.GreaterOrEqual(t, result.tracking, -3)
+
+	// everything fits at the result and something doesn't one step up
+	fits := func(scale float64, tracking int) bool {
+		scaled := bffnt.scaledMetrics(scale)
+		for i := range scaled.CWDHs {
+			scaled.CWDHs[i].AdjustCharWidths(tracking)
+		}
+		for _, constraint := range constraints {
+			if scaled.MeasureText(constraint.Text).width > constraint.MaxWidth {
+				return false
+			}
+		}
+		return true
+	}
+	assert.True(t, fits(result.scale, result.tracking))
+	assert.False(t, fits(result.scale+0.05, -3))
+	assert.False(t, fits(result.scale, result.tracking+1))
+
+	_, found = bffnt.FitScale([]FitConstraint{{"Adventure Log", 10}}, 1, 2, 0.5, -3, 0)
+	assert.False(t, found)
+}
+
+func TestAlphaCurve(t *testing.T) {
+	curve, err := parseAlphaCurve("1")
+	assert.Nil(t, err)
+	assert.Equal(t, identityAlphaCurve(), curve)
+	curve, _ = parseAlphaCurve("2")
+	assertFail(t, uint8(64), curve[128], "gamma 2")
+	assertFail(t, uint8(255), curve[255], "opaque stays opaque")
+
+	curve, err = parseAlphaCurve("128:64")
+	assert.Nil(t, err)
+	assertFail(t, uint8(0), curve[0], "implied 0:0")
+	assertFail(t, uint8(32), curve[64], "interpolated")
+	assertFail(t, uint8(64), curve[128], "point")
+	assertFail(t, uint8(160), curve[192], "interpolated")
+	curve, _ = parseAlphaCurve("255:200")
+	assertFail(t, uint8(200), curve[255], "points replace the implied ones")
+	for _, spec := range []string{"0", "soft", "300:10"} {
+		_, err = parseAlphaCurve(spec)
+		assert.NotNil(t, err, spec)
+	}
+
+	img := image.NewAlpha(image.Rect(0, 0, 2, 1))
+	img.Pix[0], img.Pix[1] = 128, 255
+	curve, _ = parseAlphaCurve("2")
+	curve.apply(img)
+	assert.Equal(t, []uint8{64, 255}, img.Pix)
+
+	// rendered edges that are darker than the original ones get lighter
+	var original, rendered alphaHistogram
+	for alpha := 1; alpha < 255; alpha++ {
+		original[alpha] = 1
+	}
+	rendered[200] = 100
+	assert.Equal(t, identityAlphaCurve(), fitAlphaCurve(original, original))
+	fitted := fitAlphaCurve(original, rendered)
+	assertFail(t, uint8(254), fitted[200], "all edges at 200 match the top of the original")
+	for i := 1; i < 256; i++ {
+		assert.GreaterOrEqual(t, fitted[i], fitted[i-1], "the curve only rises")
+	}
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	histogram, err := bffnt.TGLP.edgeAlphaHistogram()
+	assert.Nil(t, err)
+	assert.Greater(t, histogram.total(), 0)
+	assertFail(t, 0, histogram[0]+histogram[255], "only edge pixels are counted")
+}
+
+func TestSetImageFormat(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.TGLP.DecodeSheets()
+	original := make([][]uint8, len(bffnt.TGLP.SheetData))
+	for i := range bffnt.TGLP.SheetData {
+		original[i] = append([]uint8(nil), bffnt.TGLP.SheetData[i].Pix...)
+	}
+
+	// A8 keeps the decoded BC4 pixels as they are
+	assert.Nil(t, bffnt.TGLP.SetImageFormat(IMAGE_FORMAT_A8))
+	assertFail(t, uint32(1024*1024), bffnt.TGLP.SheetSize, "A8 sheet size")
+	var a8 BFFNT
+	a8.Decode(bffnt.Encode())
+	assertFail(t, uint16(IMAGE_FORMAT_A8), a8.TGLP.SheetImageFormat, "decoded format")
+	assert.Empty(t, a8.Validation().Failures)
+	a8.TGLP.DecodeSheets()
+	for i := range original {
+		assert.Equal(t, original[i], a8.TGLP.SheetData[i].Pix, "sheet %d", i)
+	}
+
+	// and back, BC4 is lossy but not by much
+	assert.Nil(t, a8.TGLP.SetImageFormat(IMAGE_FORMAT_BC4))
+	var bc4 BFFNT
+	bc4.Decode(a8.Encode())
+	assertFail(t, uint32(len(bffntRaw)), bc4.FFNT.TotalFileSize, "same size as the original")
+	bc4.TGLP.DecodeSheets()
+	for i := range original {
+		maxDiff := 0
+		for j := 3; j < len(original[i]); j += 4 {
+			diff := int(original[i][j]) - int(bc4.TGLP.SheetData[i].Pix[j])
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff > maxDiff {
+				maxDiff = diff
+			}
+		}
+		assert.LessOrEqual(t, maxDiff, 40, "sheet %d", i)
+	}
+
+	assert.NotNil(t, bc4.TGLP.SetImageFormat(7))
+	assertFail(t, "BC4", imageFormatName(IMAGE_FORMAT_BC4), "format name")
+}
+
+func TestCemuPack(t *testing.T) {
+	compressed, err := ioutil.ReadFile("../WiiU_fonts/botw/Font_EU.sbfarc")
+	handleErr(err)
+	raw, err := decodeYaz0(compressed)
+	handleErr(err)
+	original, err := DecodeSARC(raw)
+	handleErr(err)
+	assert.Equal(t, 6, len(original.Files))
+	normal := original.file("Normal_00.bffnt")
+	assert.NotNil(t, normal)
+	expected, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+	assert.True(t, bytes.Equal(expected, normal.Data), "Normal_00.bffnt in the archive differs from the extracted one")
+
+	// stored Yaz0 and re-encoding an unchanged archive change nothing
+	stored, err := decodeYaz0(encodeYaz0Stored(raw))
+	handleErr(err)
+	assert.True(t, bytes.Equal(raw, stored), "stored Yaz0 roundtrip")
+	assert.True(t, bytes.Equal(raw, original.Encode()), "unchanged SARC roundtrip")
+
+	packDir := t.TempDir()
+	botw := readGameProfile("botw")
+	writeCemuPack(packDir, "../WiiU_fonts/botw/Font_EU.sbfarc", botw.destination("Caption", ""), []byte("caption"))
+	// the second font goes into the archive of the first
+	writeCemuPack(packDir, "../WiiU_fonts/botw/Font_EU.sbfarc", botw.destination("Ancient", "USen"), bytes.Repeat([]byte{1}, 0x3001))
+	_, err = os.Stat(filepath.Join(packDir, CEMU_PACK_RULES))
+	assert.Nil(t, err)
+
+	compressed, err = ioutil.ReadFile(filepath.Join(packDir, CEMU_PACK_ARCHIVE))
+	handleErr(err)
+	raw, err = decodeYaz0(compressed)
+	handleErr(err)
+	pack, err := DecodeSARC(raw)
+	handleErr(err)
+	for i, file := range pack.Files {
+		assert.Equal(t, original.Files[i].Name, file.Name)
+		switch file.Name {
+		case "Caption_00.bffnt":
+			assert.Equal(t, "caption", string(file.Data))
+		case "Ancient_00.bffnt":
+			assert.Equal(t, 0x3001, len(file.Data))
+		default:
+			assert.True(t, bytes.Equal(original.Files[i].Data, file.Data), file.Name+" changed")
+		}
+	}
+
+	assert.NotNil(t, pack.Replace("Missing_00.bffnt", nil))
+}
+
+func TestBcmlMod(t *testing.T) {
+	modDir := t.TempDir()
+	writeBcmlMod(modDir, "../WiiU_fonts/botw/Font_EU.sbfarc", readGameProfile("botw").destination("Normal", ""), []byte("normal"))
+
+	var info BcmlInfo
+	infoRaw, err := ioutil.ReadFile(filepath.Join(modDir, BCML_INFO))
+	handleErr(err)
+	handleErr(json.Unmarshal(infoRaw, &info))
+	assert.Equal(t, "wiiu", info.Platform)
+	assert.NotEmpty(t, info.Name)
+
+	compressed, err := ioutil.ReadFile(filepath.Join(modDir, CEMU_PACK_ARCHIVE))
+	handleErr(err)
+	raw, err := decodeYaz0(compressed)
+	handleErr(err)
+	mod, err := DecodeSARC(raw)
+	handleErr(err)
+	assert.Equal(t, "normal", string(mod.file("Normal_00.bffnt").Data))
+}
+
+func TestBPS(t *testing.T) {
+	source, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	// moved, repeated and new bytes, and a size change
+	target := append([]byte(nil), source[:0x400]...)
+	target = append(target, source[0x2000:0x3000]...)
+	target = append(target, bytes.Repeat([]byte("glyph"), 300)...)
+	target = append(target, source[0x400:]...)
+	target[10] ^= 0xFF
+
+	patch := CreateBPS(source, target, "")
+	assert.Less(t, len(patch), 4096)
+	patched, err := ApplyBPS(source, patch)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(target, patched), "patched file differs from the target")
+
+	_, err = ApplyBPS(target, patch)
+	assert.NotNil(t, err, "patch applied to the wrong source")
+	damaged := append([]byte(nil), patch...)
+	damaged[20] ^= 1
+	_, err = ApplyBPS(source, damaged)
+	assert.NotNil(t, err, "damaged patch applied")
+
+	empty, err := ApplyBPS(source, CreateBPS(source, nil, ""))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(empty))
+
+	// a patch claiming a huge target fails before anything is allocated
+	var huge bytes.Buffer
+	huge.WriteString("BPS1")
+	bpsWriteNumber(&huge, uint64(len(source)))
+	bpsWriteNumber(&huge, 1<<40)
+	bpsWriteNumber(&huge, 0)
+	crcs := make([]byte, 8)
+	binary.LittleEndian.PutUint32(crcs, crc32.ChecksumIEEE(source))
+	huge.Write(crcs)
+	binary.LittleEndian.PutUint32(crcs, crc32.ChecksumIEEE(huge.Bytes()))
+	huge.Write(crcs[:4])
+	_, err = ApplyBPS(source, huge.Bytes())
+	assert.Error(t, err, "targets bigger than a bffnt should fail")
+}
+
+func TestFontCollection(t *testing.T) {
+	ttc := "../nintendo_system_ui/Super Smash Bros. for Nintendo 3DS _ Wii U/DFHeiE.ttc"
+	path, faceIndex := splitFaceIndex(withFaceIndex(ttc, 2))
+	assert.Equal(t, ttc, path)
+	assert.Equal(t, 2, faceIndex)
+	assert.Equal(t, ttc, withFaceIndex(ttc, 0))
+	path, faceIndex = splitFaceIndex("fonts/#1 font.ttf")
+	assert.Equal(t, "fonts/#1 font.ttf", path)
+	assert.Equal(t, 0, faceIndex)
+
+	first, err := parseFontFile(ttc).Name(nil, sfnt.NameIDPostScript)
+	handleErr(err)
+	third, err := parseFontFile(withFaceIndex(ttc, 2)).Name(nil, sfnt.NameIDPostScript)
+	handleErr(err)
+	assert.NotEqual(t, first, third)
+
+	// single fonts still parse
+	assert.Greater(t, parseFontFile("../nintendo_system_ui/CafeStd.ttf").NumGlyphs(), 0)
+	assert.Panics(t, func() { parseFontFile("../nintendo_system_ui/CafeStd.ttf#1") })
+}
+
+func TestFontAxes(t *testing.T) {
+	dat, err := ioutil.ReadFile("../nintendo_system_ui/CafeStd.ttf")
+	handleErr(err)
+	assert.Nil(t, fontTable(dat, 0, "fvar"))
+	assert.Equal(t, 54, len(fontTable(dat, 0, "head")))
+	ttc, err := ioutil.ReadFile("../nintendo_system_ui/Super Smash Bros. for Nintendo 3DS _ Wii U/DFHeiE.ttc")
+	handleErr(err)
+	assert.Equal(t, 54, len(fontTable(ttc, 2, "head")))
+
+	// fvar with a wght axis from 100 to 900 and a wdth axis from 75 to 100
+	fvar := []byte{0, 1, 0, 0, 0, 16, 0, 2, 0, 2, 0, 20, 0, 0, 0, 0}
+	for _, axis := range []struct {
+		tag           string
+		min, def, max uint32
+	}{{"wght", 100, 400, 900}, {"wdth", 75, 100, 100}} {
+		fvar = append(fvar, axis.tag...)
+		for _, value := range []uint32{axis.min, axis.def, axis.max} {
+			var fixed [4]byte
+			binary.BigEndian.PutUint32(fixed[:], value<<16)
+			fvar = append(fvar, fixed[:]...)
+		}
+		// flags and name id
+		fvar = append(fvar, 0, 0, 1, 0)
+	}
+	axes := parseFontAxes(fvar)
+	assert.Equal(t, []fontAxis{{"wght", 100, 400, 900}, {"wdth", 75, 100, 100}}, axes)
+	assert.Equal(t, "wght 100 to 900, default 400", axes[0].String())
+	assert.Nil(t, parseFontAxes(fvar[:10]))
+
+	// the axes are read along with the font and the warning printed once
+	static := loadFontFile("../nintendo_system_ui/CafeStd.ttf")
+	assert.True(t, static == loadFontFile("../nintendo_system_ui/CafeStd.ttf"), "font file parsed again")
+	assert.Nil(t, static.axes)
+	warnVariableFont("../nintendo_system_ui/CafeStd.ttf")
+	assert.False(t, static.warned)
+	variable := &parsedFont{font: static.font, axes: axes}
+	parsedFonts.Lock()
+	parsedFonts.fonts["variable.ttf"] = variable
+	parsedFonts.Unlock()
+	warnVariableFont("variable.ttf")
+	assert.True(t, variable.warned)
+}
+
+// Rebuilds a font with extra tables. Checksums are left at 0, nothing reading
+// the fonts checks them.
+func addFontTables(dat []byte, extra map[string][]byte) []byte {
+	tables := make(map[string][]byte)
+	for i := 0; i < int(binary.BigEndian.Uint16(dat[4:])); i++ {
+		record := dat[12+16*i:]
+		start := binary.BigEndian.Uint32(record[8:])
+		tables[string(record[:4])] = dat[start : start+binary.BigEndian.Uint32(record[12:])]
+	}
+	for tag, table := range extra {
+		tables[tag] = table
+	}
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	font := make([]byte, 12+16*len(tags))
+	copy(font, dat[:4])
+	binary.BigEndian.PutUint16(font[4:], uint16(len(tags)))
+	for i, tag := range tags {
+		for len(font)%4 != 0 {
+			font = append(font, 0)
+		}
+		record := font[12+16*i:]
+		copy(record, tag)
+		binary.BigEndian.PutUint32(record[8:], uint32(len(font)))
+		binary.BigEndian.PutUint32(record[12:], uint32(len(tables[tag])))
+		font = append(font, tables[tag]...)
+	}
+	return font
+}
+
+// gvar varying a single glyph, tuples are already serialized with their
+// headers
+func singleGlyphGvar(glyph int, tupleHeaders []byte, tupleData []byte) []byte {
+	offsets := 20 + 4*(glyph+2)
+	gvar := make([]byte, offsets)
+	binary.BigEndian.PutUint16(gvar[0:], 1)
+	binary.BigEndian.PutUint16(gvar[4:], 1)
+	binary.BigEndian.PutUint32(gvar[8:], uint32(offsets))
+	binary.BigEndian.PutUint16(gvar[12:], uint16(glyph+1))
+	binary.BigEndian.PutUint16(gvar[14:], 1)
+	binary.BigEndian.PutUint32(gvar[16:], uint32(offsets))
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint16(data[0:], uint16(len(tupleHeaders)/6))
+	binary.BigEndian.PutUint16(data[2:], uint16(4+len(tupleHeaders)))
+	data = append(append(data, tupleHeaders...), tupleData...)
+	binary.BigEndian.PutUint32(gvar[20+4*(glyph+1):], uint32(len(data)))
+	return append(gvar, data...)
+}
+
+// Deltas packed as runs of words
+func packDeltas(deltas []int) []byte {
+	var packed []byte
+	for len(deltas) > 0 {
+		run := deltas
+		if len(run) > 64 {
+			run = run[:64]
+		}
+		packed = append(packed, 0x40|byte(len(run)-1))
+		for _, delta := range run {
+			packed = append(packed, byte(uint16(delta)>>8), byte(delta))
+		}
+		deltas = deltas[len(run):]
+	}
+	return packed
+}
+
+func TestFontVariations(t *testing.T) {
+	path, faceIndex := splitFaceIndex("fonts/font.ttc#2@wght=650,wdth=95")
+	assert.Equal(t, "fonts/font.ttc", path)
+	assert.Equal(t, 2, faceIndex)
+	path, axes := splitFontAxes("fonts/@home font.ttf")
+	assert.Equal(t, "fonts/@home font.ttf", path)
+	assert.Equal(t, "", axes)
+	assert.Equal(t, "font.ttf", withFontAxes("font.ttf", ""))
+
+	values, err := parseAxisValues("wght=650, wdth = 95")
+	assert.Nil(t, err)
+	assert.Equal(t, []axisValue{{"wght", 650}, {"wdth", 95}}, values)
+	for _, spec := range []string{"wght", "weight=650", "wght=bold"} {
+		_, err = parseAxisValues(spec)
+		assert.NotNil(t, err, spec)
+	}
+	flagAxes, err := parseFontAxesFlag("Normal:wght=650,wdth=95;wght=400")
+	assert.Nil(t, err)
+	assert.Equal(t, "wght=650,wdth=95", fontAxesFor(flagAxes, "Normal"))
+	assert.Equal(t, "wght=400", fontAxesFor(flagAxes, "NormalS"))
+	_, err = parseFontAxesFlag("Bold:wght=650")
+	assert.NotNil(t, err)
+
+	weight := fontAxis{"wght", 100, 400, 900}
+	assert.Equal(t, 0.5, normalizeAxisValue(weight, 650, nil))
+	assert.Equal(t, -1.0, normalizeAxisValue(weight, 100, nil))
+	assert.Equal(t, 0.8, math.Round(normalizeAxisValue(weight, 650, [][2]float64{{-1, -1}, {0, 0}, {0.5, 0.8}, {1, 1}})*100)/100)
+
+	// points between the moved ones are interpolated, the ones past them
+	// take the delta of the nearer one
+	square := []glyfPoint{{x: 0}, {x: 50}, {x: 100}, {x: 150}}
+	deltas := []pointDelta{{x: 0}, {}, {x: 10}, {}}
+	interpolateUntouched(square, []int{3}, deltas, []bool{true, false, true, false})
+	assert.Equal(t, []pointDelta{{x: 0}, {x: 5}, {x: 10}, {x: 10}}, deltas)
+
+	// the sheikah font with a wght axis. At the maximum weight the glyph of A
+	// moves 50 units to the right and gets 100 wider, at the minimum the
+	// first contour moves 20 to the right.
+	dat, err := ioutil.ReadFile("../nintendo_system_ui/botw-sheikah.ttf")
+	handleErr(err)
+	fvar := []byte{0, 1, 0, 0, 0, 16, 0, 2, 0, 1, 0, 20, 0, 0, 0, 0, 'w', 'g', 'h', 't', 0, 100, 0, 0, 1, 144, 0, 0, 3, 132, 0, 0, 0, 0, 1, 0}
+	dir := t.TempDir()
+	defaultFile := filepath.Join(dir, "default.ttf")
+	handleErr(ioutil.WriteFile(defaultFile, addFontTables(dat, map[string][]byte{"fvar": fvar, "gvar": singleGlyphGvar(0, nil, nil)}), 0644))
+	glyph, err := parseFontFile(defaultFile).GlyphIndex(nil, 'A')
+	handleErr(err)
+	defaultInstance := loadFontFile(withFontAxes(defaultFile, "wght=400")).variation
+	assert.Equal(t, []float64{0}, defaultInstance.coords)
+	points, ends := defaultInstance.glyphPoints(int(glyph), 0)
+	require.NotEmpty(t, ends)
+
+	heavy := make([]int, 2*len(points))
+	for i := 0; i < len(points)-4; i++ {
+		heavy[i] = 50
+	}
+	heavy[len(points)-3] = 100
+	tupleData := append([]byte{0}, packDeltas(heavy)...)
+	// embedded peaks at wght 1 and -1 with their own points
+	tupleHeaders := []byte{byte(len(tupleData) >> 8), byte(len(tupleData)), 0xa0, 0, 0x40, 0}
+	light := append([]byte{1, 0, 0}, packDeltas([]int{20, 0})...)
+	tupleHeaders = append(tupleHeaders, 0, byte(len(light)), 0xa0, 0, 0xc0, 0)
+	tupleData = append(tupleData, light...)
+	variableFile := filepath.Join(dir, "variable.ttf")
+	handleErr(ioutil.WriteFile(variableFile, addFontTables(dat, map[string][]byte{"fvar": fvar, "gvar": singleGlyphGvar(int(glyph), tupleHeaders, tupleData)}), 0644))
+
+	lightPoints, _ := loadFontFile(withFontAxes(variableFile, "wght=100")).variation.glyphPoints(int(glyph), 0)
+	for i := range points {
+		moved := 0.0
+		if i <= ends[0] {
+			moved = 20
+		}
+		assert.Equal(t, points[i].x+moved, lightPoints[i].x, "point %d", i)
+		assert.Equal(t, points[i].y, lightPoints[i].y, "point %d", i)
+	}
+
+	// the default instance draws the same as the font without variations
+	size := 32.0
+	newFace := func(fontFile string) font.Face {
+		parsed := loadFontFile(fontFile)
+		face, err := opentype.NewFace(parsed.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+		handleErr(err)
+		return newSubstitutedFace(face, parsed.font, nil, parsed.variation, size, 72, font.HintingNone)
+	}
+	static := newFace(variableFile)
+	assert.IsType(t, &substitutedFace{}, newFace(withFontAxes(variableFile, "wght=400")))
+	staticBounds, staticAdvance, _ := static.GlyphBounds('A')
+	bounds, advance, ok := newFace(withFontAxes(variableFile, "wght=400")).GlyphBounds('A')
+	assert.True(t, ok)
+	assert.Equal(t, staticAdvance, advance)
+	assert.InDelta(t, float64(staticBounds.Min.X), float64(bounds.Min.X), 1)
+	assert.InDelta(t, float64(staticBounds.Max.Y), float64(bounds.Max.Y), 1)
+
+	// halfway to the maximum half of the deltas apply
+	unitsPerEm := float64(parseFontFile(variableFile).UnitsPerEm())
+	units := func(value fixed.Int26_6) float64 {
+		return float64(value) / 64 / size * unitsPerEm
+	}
+	for _, instance := range []struct {
+		axes  string
+		moved float64
+	}{{"wght=900", 50}, {"wght=650", 25}} {
+		face := newFace(withFontAxes(variableFile, instance.axes))
+		bounds, advance, _ := face.GlyphBounds('A')
+		assert.InDelta(t, units(staticAdvance)+2*instance.moved, units(advance), 1, instance.axes)
+		assert.InDelta(t, units(staticBounds.Min.X)+instance.moved, units(bounds.Min.X), 1, instance.axes)
+		assert.InDelta(t, units(staticBounds.Max.X)+instance.moved, units(bounds.Max.X), 1, instance.axes)
+		glyphAdvance, _ := face.GlyphAdvance('A')
+		assert.Equal(t, advance, glyphAdvance)
+		_, mask, _, _, ok := face.Glyph(fixed.P(0, 32), 'A')
+		assert.True(t, ok)
+		assert.NotNil(t, mask)
+	}
+
+	assert.Panics(t, func() { loadFontFile(withFontAxes(variableFile, "wdth=90")) }, "unknown axis")
+	assert.Panics(t, func() { loadFontFile(withFontAxes(variableFile, "wght=1000")) }, "axis value out of range")
+	assert.Panics(t, func() { loadFontFile(withFontAxes("../nintendo_system_ui/botw-sheikah.ttf", "wght=400")) }, "static font varied")
+}
+
+func TestFontFeatures(t *testing.T) {
+	features, err := parseFontFeatures("zero, salt=2")
+	assert.Nil(t, err)
+	assert.Equal(t, []fontFeature{{"zero", 1}, {"salt", 2}}, features)
+	_, err = parseFontFeatures("smallcaps")
+	assert.NotNil(t, err)
+	_, err = parseFontFeatures("salt=0")
+	assert.NotNil(t, err)
+
+	fontFile := "../nintendo_system_ui/DSi-Wii-3DS-Wii_U/FOT-RodinBokutoh-Pro-B.otf"
+	subs := fontFeatureSubstitutions(fontFile, "zero,smcp")
+	f := parseFontFile(fontFile)
+	var buf sfnt.Buffer
+	zero, err := f.GlyphIndex(&buf, '0')
+	handleErr(err)
+	a, err := f.GlyphIndex(&buf, 'a')
+	handleErr(err)
+	assert.Contains(t, subs, zero)
+	assert.NotContains(t, subs, a)
+
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: 30, DPI: 144, Hinting: font.HintingFull})
+	handleErr(err)
+	plain, err := opentype.NewFace(f, &opentype.FaceOptions{Size: 30, DPI: 144, Hinting: font.HintingFull})
+	handleErr(err)
+	substituted := newSubstitutedFace(face, f, subs, nil, 30, 144, font.HintingFull)
+	assert.Equal(t, plain, newSubstitutedFace(plain, f, nil, nil, 30, 144, font.HintingFull))
+
+	render := func(face font.Face, r rune) []byte {
+		dst := image.NewAlpha(image.Rect(0, 0, 100, 100))
+		drawer := font.Drawer{Dst: dst, Src: image.White, Face: face, Dot: fixed.P(20, 80)}
+		drawer.DrawString(string(r))
+		return dst.Pix
+	}
+	assert.True(t, bytes.Equal(render(plain, 'a'), render(substituted, 'a')), "a has no substitution and should be drawn as it is")
+	assert.False(t, bytes.Equal(render(plain, '0'), render(substituted, '0')), "0 should be drawn slashed")
+}
+
+func TestRasterizeSVG(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 24 12">
+		<defs><rect width="24" height="12"/></defs>
+		<g transform="translate(6 6)"><circle r="5"/><path fill="none" d="M-5 0h10"/></g>
+		<path style="fill:none" d="M0 0L24 12"/>
+		<path d="M14,2 h8 v8 h-8 z M16 4 H20 V8 H16 Z" transform="scale(1)"/>
+		<polygon points="0,0 2,0 0,2"/>
+	</svg>`
+	img, err := rasterizeSVG(strings.NewReader(svg), 48)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 96, 48), img.Bounds())
+
+	// the circle is filled, scaled 4 times
+	assert.Equal(t, uint8(255), img.AlphaAt(24, 24).A)
+	assert.Equal(t, uint8(0), img.AlphaAt(24+22, 24).A)
+	// the second subpath of the square winds the same way, nonzero fills it
+	assert.Equal(t, uint8(255), img.AlphaAt(4*18, 4*6).A)
+	assert.Equal(t, uint8(255), img.AlphaAt(4*15, 4*3).A)
+	// the unfilled line and the defs aren't drawn
+	assert.Equal(t, uint8(0), img.AlphaAt(4*12, 4*6-1).A)
+	assert.Equal(t, uint8(255), img.AlphaAt(1, 1).A)
+
+	// arcs end where they should: a half circle closes into a half disc
+	half, err := rasterizeSVG(strings.NewReader(`<svg width="20" height="20"><path d="M0 10 a10 10 0 0 1 20 0z"/></svg>`), 20)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(255), half.AlphaAt(10, 5).A)
+	assert.Equal(t, uint8(0), half.AlphaAt(10, 15).A)
+
+	// evenodd leaves the inner square of the same outline empty, and inherits
+	evenOdd, err := rasterizeSVG(strings.NewReader(`<svg viewBox="0 0 24 12"><g style="fill-rule:evenodd">
+		<path d="M14,2 h8 v8 h-8 z M16 4 H20 V8 H16 Z"/></g></svg>`), 48)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(0), evenOdd.AlphaAt(4*18, 4*6).A)
+	assert.Equal(t, uint8(255), evenOdd.AlphaAt(4*15, 4*3).A)
+	assert.Equal(t, uint8(0), evenOdd.AlphaAt(4*12, 4*6).A)
+	// antialiased: an edge half way through a pixel, and a circle of about
+	// the right area
+	edge, err := rasterizeSVG(strings.NewReader(`<svg viewBox="0 0 12 12" fill-rule="evenodd"><rect x="2.125" y="2" width="4" height="4"/></svg>`), 48)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(128), edge.AlphaAt(8, 12).A)
+	assert.Equal(t, uint8(255), edge.AlphaAt(9, 12).A)
+	circle, err := rasterizeSVG(strings.NewReader(`<svg viewBox="0 0 12 12"><circle fill-rule="evenodd" cx="6" cy="6" r="5"/></svg>`), 48)
+	assert.Nil(t, err)
+	area := 0.0
+	for _, alpha := range circle.Pix {
+		area += float64(alpha) / 255
+	}
+	assert.InDelta(t, math.Pi*20*20, area, 0.01*math.Pi*20*20)
+
+	for _, broken := range []string{`<html/>`, `<svg/>`, `<svg viewBox="0 0 1 1"><path d="10 10"/></svg>`, `<svg viewBox="0 0 1 1"><path d="M0 0Z 1 1"/></svg>`,
+		`<svg viewBox="0 0 1 1"><g stroke="#000"><path fill="none" d="M0 0h1"/></g></svg>`, `<svg viewBox="0 0 1 1"><path fill-rule="odd" d="M0 0h1v1z"/></svg>`,
+		// clones and text would draw nothing
+		`<svg viewBox="0 0 1 1"><defs><path id="a" d="M0 0h1v1z"/></defs><use href="#a"/></svg>`,
+		`<svg viewBox="0 0 1 1"><symbol id="b"><path d="M0 0h1v1z"/></symbol></svg>`, `<svg viewBox="0 0 1 1"><text>A</text></svg>`} {
+		_, err := rasterizeSVG(strings.NewReader(broken), 10)
+		assert.NotNil(t, err, broken)
+	}
+}
+
+func TestDrawOverrides(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/External/External_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+
+	dir := t.TempDir()
+	square := `<svg viewBox="0 0 10 10"><rect width="10" height="10"/></svg>`
+	handleErr(os.WriteFile(filepath.Join(dir, "U+E041.svg"), []byte(square), 0644))
+	handleErr(os.WriteFile(filepath.Join(dir, "U+0041.svg"), []byte(square), 0644))
+	handleErr(os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644))
+
+	dst := image.NewAlpha(image.Rect(0, 0, int(bffnt.TGLP.SheetWidth), int(bffnt.TGLP.SheetHeight)))
+	assert.Equal(t, 1, bffnt.drawOverrides(dst, dir), "A isn't mapped by External and is skipped")
+
+	index := mustCharIndex(&bffnt, 0xE041)
+	_, cell := bffnt.TGLP.CellRect(int(index))
+	glyph, _ := bffnt.glyphInfoAt(int(index))
+	// drawn as tall as the cell, so as wide too
+	assert.Equal(t, uint8(cell.Dy()), glyph.CharWidth)
+	assert.Equal(t, uint8(255), dst.AlphaAt(cell.Min.X, cell.Max.Y-1).A)
+	assert.Equal(t, uint8(0), dst.AlphaAt(cell.Min.X+cell.Dy(), cell.Min.Y).A)
+
+	pngFile, err := os.Create(filepath.Join(dir, "U+E041.png"))
+	handleErr(err)
+	handleErr(png.Encode(pngFile, image.NewAlpha(image.Rect(0, 0, 4, 4))))
+	handleErr(pngFile.Close())
+	assert.Panics(t, func() { bffnt.drawOverrides(dst, dir) }, "two overrides for one character")
+}
+
+func TestKerningCompare(t *testing.T) {
+	initializeGlyphMaps()
+	bffnt := readBffnt("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	size := getBotwFontSettings("Normal", 1)
+	shared := []rune("AVTaoy.,")
+
+	// CafeStd's GPOS pairs match Nintendo's at the size the font is drawn at
+	cafe := newFontKerning("../nintendo_system_ui/DSi-Wii-3DS-Wii_U/CafeStd.ttf", "Normal", size*2)
+	assert.NotEmpty(t, cafe.pairTables)
+	differences, originalPairs, candidatePairs := bffnt.compareKerning(cafe, shared)
+	assert.Greater(t, originalPairs, 0)
+	assert.Equal(t, originalPairs, candidatePairs)
+	for _, diff := range differences {
+		assert.Less(t, absInt(diff.delta()), 2, "%c%c", diff.first, diff.second)
+	}
+	assert.Equal(t, int(bffnt.KRNG.Kern('A', 'V')), cafe.kern('A', 'V'))
+	assert.Less(t, cafe.kern('A', 'V'), 0)
+
+	// sorted by how far apart the fonts are
+	rodin := newFontKerning("../nintendo_system_ui/DSi-Wii-3DS-Wii_U/FOT-RodinBokutoh-Pro-B.otf", "Normal", size*2)
+	differences, _, _ = bffnt.compareKerning(rodin, shared)
+	assert.NotEmpty(t, differences)
+	for i := 1; i < len(differences); i++ {
+		assert.GreaterOrEqual(t, absInt(differences[i-1].delta()), absInt(differences[i].delta()))
+	}
+}
+
+func TestGlyphMatch(t *testing.T) {
+	initializeGlyphMaps()
+	bffnt := readBffnt("../WiiU_fonts/botw/Ancient/Ancient_00.bffnt")
+	fontFile := "../nintendo_system_ui/botw-sheikah.ttf"
+	matches := bffnt.matchGlyphs(fontFile, fontChars(parseFontFile(fontFile)))
+	assert.Equal(t, 92, len(matches))
+
+	// the letters agree with the manual Ancient mapping. It blanks the digits
+	// though the bffnt has glyphs for them.
+	letters := 0
+	for _, match := range matches {
+		if unicode.IsLetter(match.char) {
+			assert.Equal(t, asciiToGlyph("Ancient", uint16(match.char)), uint16(match.best), "%c", match.char)
+			letters++
+		}
+		if match.char == 'Q' {
+			assert.Equal(t, 'q', match.best)
+		}
+		if match.char == '"' {
+			assert.True(t, match.blank)
+		}
+	}
+	assert.Equal(t, 52, letters)
+
+	filename := filepath.Join(t.TempDir(), "glyph_map.yaml")
+	file, err := os.Create(filename)
+	handleErr(err)
+	handleErr(writeGlyphMap(file, matches, "Ancient_00.bffnt", fontFile))
+	file.Close()
+	glyphMap := readGlyphMap(filename)
+	assert.Equal(t, uint16('q'), glyphMap['Q'])
+	assert.Equal(t, uint16(' '), glyphMap['"'])
+	assert.NotContains(t, glyphMap, uint16('q'))
+
+	glyphMapFiles["Ancient"] = map[uint16]uint16{'Q': 'z'}
+	defer delete(glyphMapFiles, "Ancient")
+	assert.Equal(t, uint16('z'), asciiToGlyph("Ancient", 'Q'))
+	assert.Equal(t, uint16('r'), asciiToGlyph("Ancient", 'R'))
+}
+
+func TestReplacementChars(t *testing.T) {
+	initializeGlyphMaps()
+	f := parseFontFile("../nintendo_system_ui/botw-sheikah.ttf")
+
+	// mapped characters the font has, characters as they are otherwise
+	replacements, missing := replacementChars(f, "Ancient", []rune("Aa!\"|"))
+	assert.Equal(t, map[rune]rune{'A': 'a', 'a': 'a', '!': '!', '"': ' '}, replacements)
+	assert.Equal(t, []rune("|"), missing)
+
+	glyphMapFiles["Ancient"] = map[uint16]uint16{'!': 0x3042}
+	defer delete(glyphMapFiles, "Ancient")
+	replacements, _ = replacementChars(f, "Ancient", []rune("!"))
+	assert.Equal(t, '!', replacements['!'])
+
+	// fonts without a built in mapping look characters up as they are
+	assert.Equal(t, uint16('A'), asciiToGlyph("Custom", 'A'))
+	covered, missing := fontCoverage("../nintendo_system_ui/botw-sheikah.ttf", "", []rune("A&"))
+	assert.Equal(t, []rune("A"), covered)
+	assert.Equal(t, []rune("&"), missing)
+	assert.Equal(t, "U+0041 'A', U+0042 'B'", formatCharList([]rune("AB")))
+	assert.Equal(t, "U+0030 '0', U+0031 '1', U+0032 '2', U+0033 '3', U+0034 '4', U+0035 '5', U+0036 '6', U+0037 '7', U+0038 '8', U+0039 '9' and 2 more", formatCharList([]rune("0123456789ab")))
+}
+
+func TestFindFontFile(t *testing.T) {
+	assert.True(t, isFontFile("font.TTF"))
+	assert.True(t, isFontFile("fonts.ttc#2"))
+	assert.False(t, isFontFile("Rodin Bokutoh Pro B"))
+	assert.Equal(t, "missing.otf", findFontFile("missing.otf"))
+	assert.Equal(t, "fotrodinbokutohprob", normalizeFontName("FOT-RodinBokutoh Pro B"))
+
+	dirs := []string{"../nintendo_system_ui"}
+	// PostScript name
+	fontFile, found := findFontIn("Rodin Bokutoh Pro B", dirs)
+	assert.True(t, found)
+	assert.Equal(t, "FOT-RodinBokutoh-Pro-B.otf", filepath.Base(fontFile))
+	// family and style
+	fontFile, _ = findFontIn("fot-newrodin pro db", dirs)
+	assert.Equal(t, "FOT-NewRodin Pro DB.otf", filepath.Base(fontFile))
+	// a family alone is its regular face
+	fontFile, _ = findFontIn("BOTW Hylian", dirs)
+	assert.Equal(t, "botw-sheikah.ttf", filepath.Base(fontFile))
+	_, found = findFontIn("FOT-RodinBokutoh Pro", dirs)
+	assert.False(t, found, "the family has no regular face")
+
+	// the directory is read once, later lookups use the names it found
+	faces := facesIn(dirs[0])
+	require.NotEmpty(t, faces)
+	fontDirFaces.Lock()
+	fontDirFaces.dirs[dirs[0]] = faces[:1]
+	fontDirFaces.Unlock()
+	_, found = findFontIn("fot-newrodin pro db", dirs)
+	assert.False(t, found, "the directory was read again")
+	fontDirFaces.Lock()
+	delete(fontDirFaces.dirs, dirs[0])
+	fontDirFaces.Unlock()
+
+	// symlinked font directories are followed, a link loop is read once
+	linked := t.TempDir()
+	systemUI, err := filepath.Abs(dirs[0])
+	handleErr(err)
+	if os.Symlink(systemUI, filepath.Join(linked, "fonts")) == nil {
+		handleErr(os.Mkdir(filepath.Join(linked, "user"), 0755))
+		handleErr(os.Symlink(systemUI, filepath.Join(linked, "user", "nintendo")))
+		handleErr(os.Symlink(linked, filepath.Join(linked, "user", "loop")))
+		fontFile, found = findFontIn("Rodin Bokutoh Pro B", []string{filepath.Join(linked, "fonts")})
+		assert.True(t, found, "a symlinked font directory")
+		assert.Equal(t, "FOT-RodinBokutoh-Pro-B.otf", filepath.Base(fontFile))
+		_, found = findFontIn("Rodin Bokutoh Pro B", []string{filepath.Join(linked, "user")})
+		assert.True(t, found, "a symlinked subdirectory")
+		assert.Equal(t, len(faces), len(facesIn(linked)), "the faces of both links to the same directory are read once")
+	}
+
+	// fc-match's answers, and no fontconfig at all
+	fontFile, found = parseFontconfigMatch("/usr/share/fonts/noto/NotoSansCJK.ttc\t2\n")
+	assert.True(t, found)
+	assert.Equal(t, "/usr/share/fonts/noto/NotoSansCJK.ttc#2", fontFile)
+	fontFile, _ = parseFontconfigMatch("DejaVuSans.ttf\t0")
+	assert.Equal(t, "DejaVuSans.ttf", fontFile)
+	_, found = parseFontconfigMatch("")
+	assert.False(t, found)
+	assert.Equal(t, `FOT\-RodinBokutoh Pro\:B\,\\`, escapeFontconfig(`FOT-RodinBokutoh Pro:B,\`))
+	t.Setenv("PATH", "")
+	_, found = fontconfigMatch("DejaVu Sans")
+	assert.False(t, found)
+}
+
+func TestGlyphAdjustments(t *testing.T) {
+	raw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	filename := filepath.Join(t.TempDir(), "adjustments.yaml")
+	session := &nudgeSession{
+		fontName: "Caption",
+		fontFile: "../nintendo_system_ui/DSi-Wii-3DS-Wii_U/FOT-RodinBokutoh-Pro-M.otf",
+		scale:    2,
+		raw:      raw,
+		filename: filename,
+		history:  newAdjustmentHistory(adjustmentHistoryFile(filename), "Caption", 2),
+		char:     'A',
+	}
+	session.render()
+
+	// B is nudged and reset, so only A is saved
+	session.run(strings.NewReader("ll]]+\nc B\nj0\ns\nq\n"))
+	adjustments := readGlyphAdjustments(filename).forFont("Caption", 2)
+	assert.Equal(t, map[rune]GlyphAdjustment{'A': {X: 2, Left: 2, Width: 1}}, adjustments)
+	assert.Nil(t, readGlyphAdjustments(filename).forFont("Caption", 3))
+
+	bffnt := BFFNT{}
+	bffnt.Decode(raw)
+	index := mustCharIndex(&bffnt, 'A')
+	original, _ := bffnt.glyphInfoAt(int(index))
+	before := *original
+	unmapped := bffnt.applyGlyphAdjustments(map[rune]GlyphAdjustment{'A': {Left: -2, Width: 3}, 0xFFFF: {Left: 1}})
+	assert.Equal(t, []rune{0xFFFF}, unmapped)
+	adjusted, _ := bffnt.glyphInfoAt(int(index))
+	assert.Equal(t, int(before.LeftWidth)-2, int(adjusted.LeftWidth))
+	assert.Equal(t, int(before.CharWidth)+3, int(adjusted.CharWidth))
+	assert.Equal(t, before.GlyphWidth, adjusted.GlyphWidth)
+}
+
+func TestExplodeImplode(t *testing.T) {
+	raw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	bffnt := BFFNT{}
+	bffnt.Decode(raw)
+	dir := t.TempDir()
+	exploded := bffnt.explode(dir, true)
+
+	// untouched, the font comes back byte for byte
+	imploded := implodeFont(dir)
+	assert.Equal(t, raw, imploded.Encode())
+
+	// a wider A that's also drawn for U+E000, with its artwork erased
+	indexA := mustCharIndex(&bffnt, 'A')
+	glyph := &exploded.Glyphs[indexA]
+	glyph.CharWidth += 3
+	glyph.Chars = append(glyph.Chars, "U+E000")
+	writePng(filepath.Join(dir, glyph.Image), image.NewNRGBA(image.Rect(0, 0, int(bffnt.TGLP.CellWidth), int(bffnt.TGLP.CellHeight))))
+	jsonRaw, err := json.Marshal(exploded)
+	handleErr(err)
+	handleErr(os.WriteFile(filepath.Join(dir, EXPLODED_FONT_FILE), jsonRaw, 0644))
+
+	imploded = implodeFont(dir)
+	encoded := imploded.Encode()
+	handleErr(imploded.VerifyEncoded(encoded))
+	edited := BFFNT{}
+	edited.Decode(encoded)
+	assert.Equal(t, indexA, mustCharIndex(&edited, 0xE000))
+	original, _ := bffnt.glyphInfoAt(int(indexA))
+	widths, _ := edited.glyphInfoAt(int(indexA))
+	assert.Equal(t, original.CharWidth+3, widths.CharWidth)
+	assert.Equal(t, bffnt.KRNG.Kern('A', 'V'), edited.KRNG.Kern('A', 'V'))
+
+	edited.TGLP.DecodeSheets()
+	sheet, cell := edited.TGLP.CellRect(int(indexA))
+	for y := cell.Min.Y; y < cell.Max.Y; y++ {
+		for x := cell.Min.X; x < cell.Max.X; x++ {
+			if edited.TGLP.SheetData[sheet].NRGBAAt(x, y).A != 0 {
+				t.Fatalf("A's cell still has ink at %d,%d", x, y)
+			}
+		}
+	}
+}
+
+func TestAdjustmentRules(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	handleErr(os.WriteFile(rulesFile, []byte(`rules:
+  - when: scale == 2 && upper(char) && is(char, "Latin")
+    do: charWidth -= 2
+  - name: dots
+    when: among(char, ".,")
+    do: |
+      leftWidth++
+      if charWidth > 100 { charWidth = 0 } else { charWidth = max(charWidth * 2, 300) }
+  - when: font == "Normal"
+    do: glyphWidth = 0
+`), 0644))
+	rules := readAdjustmentRules(rulesFile)
+	assert.Equal(t, "rule 1", rules[0].Name)
+
+	bffnt := readBffnt("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	widths := func(char rune) glyphInfo {
+		glyph, _ := bffnt.glyphInfoAt(int(mustCharIndex(&bffnt, char)))
+		return *glyph
+	}
+	a, e, dot, one := widths('A'), widths('é'), widths('.'), widths('1')
+
+	changed, clamped := bffnt.adjustByRules(rules, "Caption", 2)
+	assert.Equal(t, a.CharWidth-2, widths('A').CharWidth)
+	assert.Equal(t, e.CharWidth, widths('é').CharWidth)
+	assert.Equal(t, dot.LeftWidth+1, widths('.').LeftWidth)
+	assert.Equal(t, uint8(MAX_GLYPH_WIDTH), widths('.').CharWidth)
+	assert.Equal(t, one, widths('1'))
+	assert.Greater(t, changed, 26+2)
+	// the dots' 300 didn't fit and is listed, not silently clamped
+	assert.Equal(t, 2, len(clamped))
+	assert.Contains(t, clamped[0], "dots: ")
+	assert.Contains(t, clamped[0], "charWidth 300")
+
+	panicMessage := func(f func()) (message string) {
+		defer func() { message = fmt.Sprint(recover()) }()
+		f()
+		return
+	}
+
+	// errors name the rule and the glyph
+	for source, message := range map[string]string{
+		"when: charWidth\n    do: charWidth = 1":         "when has to be true or false",
+		"do: index = 1":                                  "index can't be assigned",
+		"do: charWidth = width":                          "unknown variable width",
+		"do: charWidth = is(char, \"Klingon\")":          "no unicode script",
+		"do: charWidth /= 0":                             "isn't a number",
+		"do: charWidth = charWidth % 0":                  "isn't a number",
+		"when: charWidth / 0 > 1\n    do: charWidth = 1": "isn't a number",
+	} {
+		handleErr(os.WriteFile(rulesFile, []byte("rules:\n  - "+source+"\n"), 0644))
+		rules := readAdjustmentRules(rulesFile)
+		err := panicMessage(func() { bffnt.applyAdjustmentRules(rules, "Caption", 2) })
+		assert.Contains(t, err, message)
+		assert.Contains(t, err, "rule 1: glyph 0")
+	}
+	handleErr(os.WriteFile(rulesFile, []byte("rules:\n  - do: charWidth -=\n"), 0644))
+	assert.Contains(t, panicMessage(func() { readAdjustmentRules(rulesFile) }), "rule 1: do:")
+}
+
+func TestExtendLock(t *testing.T) {
+	dir := t.TempDir()
+	handleErr(os.WriteFile(filepath.Join(dir, "chars.txt"), []byte("Ωμέγα"), 0644))
+	handleErr(os.Mkdir(filepath.Join(dir, "overrides"), 0755))
+	handleErr(os.WriteFile(filepath.Join(dir, "overrides", "U+E0A0.svg"), []byte("<svg/>"), 0644))
+
+	config := ExtendConfig{
+		Font:      "Normal",
+		Charsets:  []string{filepath.Join(dir, "chars.txt")},
+		Overrides: filepath.Join(dir, "overrides"),
+	}
+	fontFile := "../nintendo_system_ui/nintendo_udsg-r_std_003.ttf"
+	config.Bffnt = "../WiiU_fonts/botw/Normal/Normal_00.bffnt"
+	inputs := config.inputHashes(fontFile+"#0", dir)
+	assert.Equal(t, []string{"bffnt", "charsets/chars.txt", "overrides/U+E0A0.svg", "ttf"}, changedHashes(nil, inputs))
+
+	lockFile := extendLockFile(filepath.Join(dir, "extend.yaml"))
+	assert.Equal(t, filepath.Join(dir, "extend.lock.yaml"), lockFile)
+	_, locked := readExtendLock(lockFile)
+	assert.False(t, locked)
+	ExtendLock{Inputs: inputs, Outputs: map[string]string{"Normal_00_2.00x.png": "0"}}.write(lockFile)
+	lock, locked := readExtendLock(lockFile)
+	assert.True(t, locked)
+	assert.Empty(t, changedHashes(lock.Inputs, config.inputHashes(fontFile, dir)))
+
+	// edited, added and removed inputs all count as changed
+	handleErr(os.WriteFile(filepath.Join(dir, "chars.txt"), []byte("Ωμέγαx"), 0644))
+	handleErr(os.Remove(filepath.Join(dir, "overrides", "U+E0A0.svg")))
+	config.Rules = "../rules.yaml"
+	assert.Equal(t, []string{"charsets/chars.txt", "overrides/U+E0A0.svg", "rules"},
+		changedHashes(lock.Inputs, config.inputHashes(fontFile, dir)))
+}
+
+func TestAdjustmentHistory(t *testing.T) {
+	filename := adjustmentHistoryFile(filepath.Join(t.TempDir(), "adjustments.yaml"))
+	assert.Equal(t, "adjustments.history.yaml", filepath.Base(filename))
+	assert.Nil(t, readAdjustmentHistory(filename))
+
+	history := newAdjustmentHistory(filename, "Caption", 2)
+	history.edit('A', GlyphAdjustment{}, GlyphAdjustment{X: 1})
+	history.edit('A', GlyphAdjustment{X: 1}, GlyphAdjustment{X: 1, Width: -2})
+	history.edit(' ', GlyphAdjustment{}, GlyphAdjustment{Width: 3})
+	char, adjustment, ok := history.undo()
+	assert.True(t, ok)
+	assert.Equal(t, ' ', char)
+	assert.Equal(t, GlyphAdjustment{}, adjustment)
+	char, adjustment, _ = history.undo()
+	assert.Equal(t, 'A', char)
+	assert.Equal(t, GlyphAdjustment{X: 1}, adjustment)
+
+	// a new session carries on with the same stacks, other fonts and scales
+	// have their own
+	assert.Empty(t, newAdjustmentHistory(filename, "Caption", 3).undos)
+	history = newAdjustmentHistory(filename, "Caption", 2)
+	char, adjustment, ok = history.redo()
+	assert.True(t, ok)
+	assert.Equal(t, 'A', char)
+	assert.Equal(t, GlyphAdjustment{X: 1, Width: -2}, adjustment)
+	history.edit('B', GlyphAdjustment{}, GlyphAdjustment{Y: 1})
+	_, _, ok = history.redo()
+	assert.False(t, ok, "an edit clears the redos")
+
+	// replaying puts every glyph where the history left it
+	edits := readAdjustmentHistory(filename)
+	assert.Len(t, edits, 7)
+	adjustments := GlyphAdjustmentsFile{}
+	adjustments.set("Caption", 2).put('C', GlyphAdjustment{Left: 1})
+	adjustments.replay(edits)
+	assert.Equal(t, map[rune]GlyphAdjustment{
+		'A': {X: 1, Width: -2},
+		'B': {Y: 1},
+		'C': {Left: 1},
+	}, adjustments.forFont("Caption", 2))
+}
+
+func TestParseScales(t *testing.T) {
+	scales, err := parseScales("2, 3,1080p,4K")
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{2, 3, 1.5, 3}, scales)
+	for _, spec := range []string{"", "2,", "8k", "-1"} {
+		_, err := parseScales(spec)
+		assert.Error(t, err, spec)
+	}
+
+	// every upscale of a run shares the parsed font
+	fontFile := "../nintendo_system_ui/CafeStd.ttf"
+	assert.Same(t, parseFontFile(fontFile), parseFontFile(fontFile))
+}
+
+func TestComposeSheets(t *testing.T) {
+	filename := "../WiiU_fonts/botw/NormalS/NormalS_00.bffnt"
+	raw, err := ioutil.ReadFile(filename)
+	handleErr(err)
+	bffnt := readBffnt(filename)
+	bffnt.TGLP.DecodeSheets()
+	dir := t.TempDir()
+	sheetFiles := make([]string, len(bffnt.TGLP.SheetData))
+	for i := range bffnt.TGLP.SheetData {
+		sheetFiles[i] = filepath.Join(dir, exportSheetName("NormalS_00", i))
+		writePng(sheetFiles[i], &bffnt.TGLP.SheetData[i])
+	}
+
+	// A8 sheets keep every alpha value, composing the font's own sheets
+	// writes the same font
+	composed := readBffnt(filename)
+	composed.TGLP.composeSheets(sheetFiles)
+	assert.Nil(t, composed.TGLP.raw)
+	assert.Equal(t, raw, composed.Encode())
+
+	panicMessage := func(f func()) (message string) {
+		defer func() { message = fmt.Sprint(recover()) }()
+		f()
+		return
+	}
+	assert.Contains(t, panicMessage(func() { composed.TGLP.composeSheets(append(sheetFiles, sheetFiles[0])) }), "got 2 image(s)")
+	small := filepath.Join(dir, "small.png")
+	writePng(small, image.NewAlpha(image.Rect(0, 0, 16, 16)))
+	assert.Contains(t, panicMessage(func() { composed.TGLP.composeSheets([]string{small}) }), "small.png is 16x16")
+}
+
+func TestGameProfile(t *testing.T) {
+	botw := readGameProfile("BotW")
+	assert.Equal(t, fontDestination{archive: CEMU_PACK_ARCHIVE, name: "Normal_00.bffnt"}, botw.destination("Normal", ""))
+	assert.Equal(t, botw.destination("Normal", ""), botw.destination("Normal", "usen"))
+
+	panicMessage := func(f func()) (message string) {
+		defer func() { message = fmt.Sprint(recover()) }()
+		f()
+		return
+	}
+	assert.Contains(t, panicMessage(func() { botw.destination("Normal", "XXxx") }), "no variant \"XXxx\"")
+	assert.Contains(t, panicMessage(func() { readGameProfile("kirby") }), "unknown game")
+
+	filename := filepath.Join(t.TempDir(), "kirby.yaml")
+	handleErr(os.WriteFile(filename, []byte("name: kirby\nfont_file: \"%s.bffnt\"\ndefault: US\narchives:\n  US: content/font/US/font.sarc\n"), 0644))
+	kirby := readGameProfile(filename)
+	assert.Equal(t, fontDestination{archive: "content/font/US/font.sarc", name: "kirbysans.bffnt"}, kirby.destination("kirbysans", ""))
+
+	fontName, found := templateFontName("out/NormalS_00_1.50x_template.bffnt")
+	assert.True(t, found)
+	assert.Equal(t, "NormalS", fontName)
+	_, found = templateFontName("NormalS_00.bffnt")
+	assert.False(t, found)
+}
+
+func TestInkWidths(t *testing.T) {
+	caption := readBffnt("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	caption.TGLP.DecodeSheets()
+	for _, ink := range measureInkWidths(&caption.TGLP, caption.allGlyphInfo()) {
+		assert.Empty(t, ink.mismatches(1), caption.glyphLabel(ink.index))
+	}
+
+	normal := readBffnt("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	normal.TGLP.DecodeSheets()
+	widths := measureInkWidths(&normal.TGLP, normal.allGlyphInfo())
+	j := widths[normal.CWDHIndexMap['j']]
+	assert.True(t, j.hasInk)
+	assert.Contains(t, strings.Join(j.mismatches(1), ", "), "before the pen")
+
+	// ！ is on the second sheet, which only decodes with its slice's swizzle
+	exclamation := widths[normal.CWDHIndexMap['！']]
+	sheet, _ := normal.TGLP.CellRect(exclamation.index)
+	assert.Equal(t, 1, sheet)
+	assert.True(t, exclamation.hasInk)
+	assert.Empty(t, exclamation.mismatches(1))
+}
+
+func TestOpticalBaseline(t *testing.T) {
+	for _, name := range []string{"Ancient", "Caption", "Normal", "NormalS"} {
+		bffnt := readBffnt(fmt.Sprintf("../WiiU_fonts/botw/%[1]s/%[1]s_00.bffnt", name))
+		baseline, measured := bffnt.opticalBaseline()
+		assert.True(t, measured, name)
+		assert.Equal(t, int(bffnt.TGLP.BaselinePosition), baseline, name+" glyphs sit on the header's baseline")
+	}
+
+	// External only has icons, it keeps the header's baseline
+	external := readBffnt("../WiiU_fonts/botw/External/External_00.bffnt")
+	_, measured := external.opticalBaseline()
+	assert.False(t, measured)
+	assert.Equal(t, int(scaleMetric(float64(external.TGLP.BaselinePosition), 2)), external.glyphBaseline(2))
+
+	// Special's glyphs sit 5 rows below its BaselinePosition
+	special := readBffnt("../WiiU_fonts/botw/Special/Special_00.bffnt")
+	baseline, _ := special.opticalBaseline()
+	assert.Equal(t, int(special.TGLP.BaselinePosition)+5, baseline)
+}
+
+func TestDetectOutline(t *testing.T) {
+	normalS := readBffnt("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	outline, found := normalS.detectOutline()
+	assert.True(t, found)
+	assert.Equal(t, glyphOutline{width: 2, opacity: 128}, outline)
+	assert.Equal(t, 4, outline.scaledWidth(2))
+
+	// antialiased edges aren't an outline
+	for _, name := range []string{"Caption", "Normal"} {
+		bffnt := readBffnt(fmt.Sprintf("../WiiU_fonts/botw/%[1]s/%[1]s_00.bffnt", name))
+		_, found := bffnt.detectOutline()
+		assert.False(t, found, name)
+	}
+
+	img := image.NewAlpha(image.Rect(0, 0, 9, 9))
+	img.SetAlpha(4, 4, color.Alpha{255})
+	outlined := outlineAlpha(img, 2, 128)
+	assert.Equal(t, uint8(255), outlined.AlphaAt(4, 4).A, "the ink stays on top")
+	assert.Equal(t, uint8(128), outlined.AlphaAt(5, 4).A)
+	assert.Equal(t, uint8(64), outlined.AlphaAt(6, 4).A, "the outer edge is antialiased")
+	assert.Equal(t, uint8(0), outlined.AlphaAt(7, 4).A)
+	assert.Equal(t, uint8(0), outlined.AlphaAt(6, 6).A, "the corners are round")
+}
+
+func TestKerningImport(t *testing.T) {
+	dir := t.TempDir()
+	afm := "StartFontMetrics 4.1\nStartKernPairs 4\nKPX A V -80\nKPX T o -120\nKPX Aacute uni0422 -40\nKPX f_i a -10\nEndKernPairs\n"
+	handleErr(ioutil.WriteFile(filepath.Join(dir, "tight.afm"), []byte(afm), 0644))
+	fea := `# corrections
+@Round = [o e \c];
+feature kern {
+	pos T @Round -100;
+	enum pos [A Agrave] V <0 0 -60 0>;
+	pos T o -150; # the glyph pair wins over the class
+	pos T' o -1;
+} kern;
+`
+	handleErr(ioutil.WriteFile(filepath.Join(dir, "tight.fea"), []byte(fea), 0644))
+	profilesFile := filepath.Join(dir, "kerning.yaml")
+	handleErr(ioutil.WriteFile(profilesFile, []byte(`profiles:
+  afm:
+    import: tight.afm
+    import_size: 20
+    pairs:
+      "AV": -3
+  fea:
+    import: tight.fea
+    import_size: 20
+    units_per_em: 2000
+  sizeless:
+    import: tight.afm
+`), 0644))
+
+	profiles := readKerningProfiles(profilesFile)
+	pairs, err := profiles.resolve("afm")
+	assert.Nil(t, err)
+	assert.Equal(t, map[[2]rune]int{{'A', 'V'}: -3, {'T', 'o'}: -2, {'Á', 'Т'}: -1}, pairs, "own pairs win over imported ones")
+
+	pairs, err = profiles.resolve("fea")
+	assert.Nil(t, err)
+	assert.Equal(t, map[[2]rune]int{{'T', 'o'}: -2, {'T', 'e'}: -1, {'T', 'c'}: -1, {'A', 'V'}: -1, {'À', 'V'}: -1}, pairs)
+
+	_, err = profiles.resolve("sizeless")
+	assert.NotNil(t, err)
+
+	for name, char := range map[string]rune{"A": 'A', "a.sc": 'a', "uni0410": 'А', "u1F600": '😀', "quoteright": '’', "Ccedilla": 'Ç', "uacute": 'ú'} {
+		found, exists := glyphNameChar(name)
+		assert.True(t, exists, name)
+		assert.Equal(t, char, found, name)
+	}
+	_, exists := glyphNameChar("f_i")
+	assert.False(t, exists)
+
+	// names of the same character: the plain name wins over uniXXXX and
+	// alternates, whatever order the pairs are in
+	handleErr(ioutil.WriteFile(filepath.Join(dir, "alternates.afm"), []byte("KPX A.sc V -20\nKPX A V -80\nKPX uni0041 W -5\nKPX A.sc W -15\nKPX A W -30\nKPX uni0041 X -7\nKPX A.sc X -9\n"), 0644))
+	for i := 0; i < 20; i++ {
+		imported, unknown, err := readKerningImport(filepath.Join(dir, "alternates.afm"))
+		assert.Nil(t, err)
+		assert.Empty(t, unknown)
+		assert.Equal(t, map[[2]rune]int{{'A', 'V'}: -80, {'A', 'W'}: -30, {'A', 'X'}: -7}, imported)
+	}
+}
+
+func TestExportFeaKerning(t *testing.T) {
+	bffnt := readBffnt("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	dir := t.TempDir()
+	handleErr(ioutil.WriteFile(filepath.Join(dir, "Normal_00.fea"), []byte(exportFeaKerning(&bffnt, "Normal_00")), 0644))
+	profilesFile := filepath.Join(dir, "kerning.yaml")
+	profile := fmt.Sprintf("profiles:\n  game:\n    import: Normal_00.fea\n    import_size: %d\n    units_per_em: %d\n", bffnt.FINF.Height, FEA_UNITS_PER_EM)
+	handleErr(ioutil.WriteFile(profilesFile, []byte(profile), 0644))
+
+	// the pairs come back as the same pixels
+	pairs, err := readKerningProfiles(profilesFile).resolve("game")
+	assert.Nil(t, err)
+	assert.Equal(t, len(bffnt.kerningPairs()), len(pairs))
+	for _, pair := range bffnt.kerningPairs() {
+		assert.Equal(t, pair[2], pairs[[2]rune{rune(pair[0]), rune(pair[1])}], "%c%c", pair[0], pair[1])
+	}
+
+	for _, char := range []rune{'A', '(', '7', 'Ý', 'Б'} {
+		found, exists := glyphNameChar(charGlyphName(char))
+		assert.True(t, exists)
+		assert.Equal(t, char, found)
+	}
+}
+
+func TestMetricClamps(t *testing.T) {
+	leftWidth, fits := clampToLeftWidth(130)
+	assert.Equal(t, int8(127), leftWidth)
+	assert.False(t, fits)
+	charWidth, fits := clampToCharWidth(-3)
+	assert.Equal(t, uint8(0), charWidth)
+	assert.False(t, fits)
+	glyphWidth, fits := clampToGlyphWidth(40)
+	assert.Equal(t, uint8(40), glyphWidth)
+	assert.True(t, fits)
+	kerning, _ := clampToKerning(40000)
+	assert.Equal(t, int16(32767), kerning)
+
+	// scaling used to wrap around
+	cwdh := CWDH{Glyphs: []glyphInfo{{100, 200, 150}, {-3, 10, 12}}}
+	cwdh.Upscale(2)
+	assert.Equal(t, glyphInfo{127, 255, 255}, cwdh.Glyphs[0])
+	assert.Equal(t, glyphInfo{-6, 20, 24}, cwdh.Glyphs[1])
+
+	finf := FINF{DefaultLeftWidth: uint8(0xFF), DefaultGlyphWidth: 10, DefaultCharWidth: 200}
+	finf.Upscale(2)
+	assert.Equal(t, int8(-2), int8(finf.DefaultLeftWidth), "the default LeftWidth is signed")
+	assert.Equal(t, uint8(255), finf.DefaultCharWidth)
+
+	krng := KRNG{KerningTable: map[uint16][]kerningPair{'A': {{'V', -20000}, {'W', -2}}}}
+	krng.Upscale(2)
+	assert.Equal(t, []kerningPair{{'V', -32768}, {'W', -4}}, krng.KerningTable['A'])
+}
+
+func TestLeftWidthOverflow(t *testing.T) {
+	initializeGlyphMaps()
+	render := func(mode string) glyphInfo {
+		bffnt := readBffnt("../WiiU_fonts/kirbyscript/Normal_00.bffnt")
+		original := bffnt.allGlyphInfo()
+		assert.Equal(t, int8(64), original[205].LeftWidth, "doubles to 128, one past a signed byte")
+
+		opts := upscaleOptions{leftWidthOverflow: mode, workers: 1}
+		if mode == LEFT_WIDTH_COMPENSATE {
+			opts.scaledLeftWidths = overflowingLeftWidths(original, 2)
+			assert.Equal(t, map[int]int{205: 128}, opts.scaledLeftWidths)
+		}
+		layout := bffnt.TGLP.scaledLayout(2).withRowsFor(bffnt.glyphCount())
+		bffnt.Upscale(2)
+		bffnt.TGLP.applyLayout(layout)
+		glyphMeasurements = newGlyphMeasurementCache()
+		sheet, _ := bffnt.renderGlyphSheet("Normal", "../nintendo_system_ui/nintendo_udsg-r_std_003.ttf", 2, opts)
+		alphaBuffers.put(sheet)
+		glyph, _ := bffnt.glyphInfoAt(205)
+		return *glyph
+	}
+
+	clamped := render(LEFT_WIDTH_CLAMP)
+	compensated := render(LEFT_WIDTH_COMPENSATE)
+	assert.Equal(t, int8(127), clamped.LeftWidth)
+	assert.Equal(t, int8(127), compensated.LeftWidth)
+	assert.Equal(t, clamped.GlyphWidth+1, compensated.GlyphWidth, "a blank column before the ink")
+	assert.Equal(t, clamped.CharWidth, compensated.CharWidth)
+
+	bffnt := readBffnt("../WiiU_fonts/kirbyscript/Normal_00.bffnt")
+	bffnt.Upscale(2)
+	issues := lintLeftWidths(&bffnt)
+	assert.Equal(t, 1, len(issues))
+	assert.Contains(t, issues[0].Message, "LeftWidth 127")
+}
+
+// A section holding a list of bytes and the offset its data was decoded from
+type testCustomSection struct {
+	values      []byte
+	startOffset uint32
+}
+
+func (section *testCustomSection) Magic() string { return "TEST" }
+func (section *testCustomSection) Present() bool { return len(section.values) > 0 }
+func (section *testCustomSection) Encode(startOffset uint32) []byte {
+	section.startOffset = startOffset
+	size := 8 + len(section.values) + paddingToNext4ByteBoundary(len(section.values))
+	raw := make([]byte, size)
+	copy(raw, "TEST")
+	binary.BigEndian.PutUint32(raw[4:8], uint32(size))
+	copy(raw[8:], section.values)
+	return raw
+}
+
+func TestCustomSections(t *testing.T) {
+	RegisterSection("TEST", func(raw []byte, startOffset uint32) (CustomSection, error) {
+		values := make([]byte, 0)
+		for _, value := range raw[8:] {
+			if value != 0 {
+				values = append(values, value)
+			}
+		}
+		return &testCustomSection{values: values, startOffset: startOffset}, nil
+	})
+	defer delete(customSectionDecoders, "TEST")
+	assert.Panics(t, func() { RegisterSection("KRNG", nil) }, "built-in sections can't be replaced")
+	assert.Panics(t, func() { RegisterSection("TEST", nil) }, "a magic only gets one decoder")
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.ExtraSections = append(bffnt.ExtraSections, &testCustomSection{values: []byte{1, 2, 3}})
+	encodedRaw := bffnt.Encode()
+	assertFail(t, len(bffntRaw)+12, len(encodedRaw), "custom section should be added to the file size")
+	assertFail(t, uint32(len(bffntRaw)+8), bffnt.ExtraSections[0].(*testCustomSection).startOffset, "custom section should come after the kerning")
+
+	var decoded BFFNT
+	decoded.Decode(encodedRaw)
+	assertFail(t, 1, len(decoded.ExtraSections), "registered section should be decoded")
+	section, decodedCustom := decoded.ExtraSections[0].(*testCustomSection)
+	assert.True(t, decodedCustom, "registered section shouldn't be unknown")
+	assert.Equal(t, []byte{1, 2, 3}, section.values)
+	assertFail(t, uint32(len(bffntRaw)+8), section.startOffset, "decoder should get the offset of the data")
+	assertFail(t, "TEST", sectionName("TEST", 0), "registered section should be named by its magic")
+	assert.Equal(t, encodedRaw, decoded.Encode(), "custom section should survive a re-encode")
+
+	// an unknown section before a registered one stays before it, through a
+	// re-encode and explode and implode
+	unknownRaw := []byte{'Z', 'Z', 'Z', 'Z', 0, 0, 0, 12, 1, 2, 3, 4}
+	testRaw := []byte{'T', 'E', 'S', 'T', 0, 0, 0, 12, 5, 6, 0, 0}
+	mixedRaw := append(append(append([]byte{}, bffntRaw...), unknownRaw...), testRaw...)
+	binary.BigEndian.PutUint32(mixedRaw[12:16], uint32(len(mixedRaw)))
+	var mixed BFFNT
+	mixed.Decode(mixedRaw)
+	assertFail(t, 2, len(mixed.ExtraSections), "both sections should be kept")
+	assert.IsType(t, &UnknownSection{}, mixed.ExtraSections[0])
+	assert.IsType(t, &testCustomSection{}, mixed.ExtraSections[1])
+	assert.Equal(t, mixedRaw, mixed.Encode(), "sections should be written back in file order")
+	dir := t.TempDir()
+	mixed.explode(dir, true)
+	imploded := implodeFont(dir)
+	assert.Equal(t, mixedRaw, imploded.Encode(), "implode should keep the order of the sections")
+
+	// sections that lie about their size are caught before they break the walk
+	assert.Panics(t, func() { checkedCustomSection{&lyingCustomSection{}}.Encode(0) })
+}
+
+type lyingCustomSection struct{ testCustomSection }
+
+func (section *lyingCustomSection) Present() bool { return true }
+func (section *lyingCustomSection) Encode(startOffset uint32) []byte {
+	return []byte{'T', 'E', 'S', 'T', 0, 0, 0, 16}
+}
+
+func TestAtlas(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Normal/Normal_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+
+	atlas := bffnt.Atlas()
+	assertFail(t, 1024, atlas.SheetWidth, "atlas sheet width")
+	assertFail(t, 2, atlas.NumOfSheets, "atlas sheet count")
+	assertFail(t, 846, len(atlas.Glyphs), "every mapped glyph should be in the atlas")
+
+	glyphs := make(map[string]AtlasGlyph)
+	for _, glyph := range atlas.Glyphs {
+		glyphs[glyph.Char] = glyph
+	}
+	// 32 cells in a row, every cell has a pixel of padding before it
+	a := glyphs["A"]
+	assertFail(t, AtlasGlyph{Char: "A", Code: 'A', Index: 33, Sheet: 0, X: 33, Y: 41, Width: 22, Height: 39,
+		U0: 33.0 / 1024, V0: 41.0 / 1024, U1: 55.0 / 1024, V1: 80.0 / 1024, BearingX: 0, BearingY: 32, Advance: 22}, a, "rect, UVs and bearings of A")
+	g := glyphs["g"]
+	assertFail(t, [3]int{1, 32, 20}, [3]int{g.BearingX, g.BearingY, g.Advance}, "bearings of g")
+	assertFail(t, 16.0/1024, g.U1-g.U0, "the UVs should only cover the glyph's width")
+
+	// the second sheet starts over at the top left
+	second := glyphs["鉱"]
+	assertFail(t, [4]int{1, 1, 1, 800}, [4]int{second.Sheet, second.X, second.Y, int(second.Index)}, "first glyph of the second sheet")
+}
+
+func TestExporters(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+
+	bmfont := exportBMFont(&bffnt, "Caption")
+	assert.Contains(t, bmfont, "common lineHeight=22 base=18 scaleW=512 scaleH=1024 pages=1 packed=0\n")
+	assert.Contains(t, bmfont, "page id=0 file=\"Caption_0.png\"\n")
+	assert.Contains(t, bmfont, "chars count=671\n")
+	assert.Contains(t, bmfont, "char id=65 x=39 y=24 width=13 height=22 xoffset=0 yoffset=0 xadvance=12 page=0 chnl=15\n")
+	assert.Contains(t, bmfont, "kernings count=363\nkerning first=40 second=89 amount=1\n")
+	assert.Contains(t, bmfont, "kerning first=65 second=84 amount=-1\n")
+
+	// godot subtracts its kerning
+	godot := exportGodotTres(&bffnt, "Caption")
+	assert.Contains(t, godot, "[ext_resource path=\"res://Caption_0.png\" type=\"Texture\" id=1]\n")
+	assert.Contains(t, godot, ", 65, 0, 39, 24, 13, 22, 0, 0, 12, ")
+	assert.Contains(t, godot, "kernings = PoolIntArray( 40, 89, -1, 40, 105, -1, ")
+	assert.Contains(t, godot, ", 65, 84, 1, ")
+	assert.Contains(t, godot, "height = 22.0\nascent = 18.0\n")
+
+	// unity's uvs start at the bottom left
+	unity := exportUnityFont(&bffnt, "Caption")
+	assert.Contains(t, unity, "    index: 65\n    uv:\n      serializedVersion: 2\n      x: 0.076171875\n      y: 0.955078125\n      width: 0.025390625\n      height: 0.021484375\n")
+	assert.Contains(t, unity, "    vert:\n      serializedVersion: 2\n      x: 0\n      y: 18\n      width: 13\n      height: -22\n    advance: 12\n")
+	assert.Contains(t, unity, "  m_KerningValues:\n  - first:\n      first: 40\n      second: 89\n    second: 1\n")
+	assert.Contains(t, unity, "  - first:\n      first: 65\n      second: 84\n    second: -1\n")
+	assertFail(t, 363, strings.Count(unity, "  - first:\n"), "every kerning pair should be exported to unity")
+
+	var ancient BFFNT
+	ancientRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Ancient/Ancient_00.bffnt")
+	handleErr(err)
+	ancient.Decode(ancientRaw)
+	assert.Contains(t, exportUnityFont(&ancient, "Ancient"), "  m_KerningValues: []\n", "fonts without kerning should have an empty list")
+}
+
+func TestResolveScale(t *testing.T) {
+	testCases := []struct {
+		target   string
+		scale    float64
+		expected float64
+	}{
+		{"720p", 0, 1},
+		{"1080p", 0, 1.5},
+		{"1440p", 0, 2},
+		{"4k", 0, 3},
+		{"4K", 0, 3},
+		{"1080p", 2.5, 2.5}, // an explicit scale wins
+		{"4k", 1, 1},
+		{"unknown", 2, 2}, // the target isn't even looked at
+	}
+	for _, tc := range testCases {
+		assertFail(t, tc.expected, resolveScale(tc.target, tc.scale), fmt.Sprintf("scale of -target %s -scale %g", tc.target, tc.scale))
+	}
+	assert.Panics(t, func() { resolveScale("8k", 0) }, "unknown targets without a scale should fail")
+
+	scales, err := parseScales("2, 1080p,4k")
+	assertFail(t, nil, err, "scales and targets can be mixed")
+	assertFail(t, []float64{2, 1.5, 3}, scales, "scales should keep their order")
+	_, err = parseScales("2,8k")
+	assert.Error(t, err, "unknown targets should fail")
+	assertFail(t, []string{"720p", "1080p", "1440p", "4k"}, targetNames(), "targets should be listed by scale")
+}
+
+func TestFontPresets(t *testing.T) {
+	// every botw font gets its preset's font file and size at the target's scale
+	scale := resolveScale("1440p", 0)
+	for fontName, preset := range botwFontPresets {
+		assert.FileExists(t, filepath.Join("..", preset.fontFile), fontName)
+		assertFail(t, preset.fontFile, resolveFontFile(fontName, ""), fontName+" should use its preset's font file")
+		assertFail(t, preset.fontSize*scale, getBotwFontSettings(fontName, scale), fontName+" should be drawn at its preset's size")
+	}
+	assertFail(t, 30.0, getBotwFontSettings("Normal", resolveScale("4k", 2)), "an explicit scale should win over the target")
+
+	fontFile := filepath.Join("..", botwFontPresets["Caption"].fontFile)
+	assertFail(t, fontFile, resolveFontFile("Normal", fontFile), "a given font file should win over the preset")
+	assert.Panics(t, func() { resolveFontFile("Unknown", "") }, "fonts without a preset need a font file")
+	assert.Panics(t, func() { getBotwFontSettings("Unknown", scale) }, "fonts without a preset have no size")
+	assert.Panics(t, func() { resolveScale("8k", 0) }, "unknown targets without a scale should fail")
+}
+
+func TestUpscaleSheets(t *testing.T) {
+	white := color.NRGBA{255, 255, 255, 255}
+	alphas := func(img *image.NRGBA) [][]uint8 {
+		rows := make([][]uint8, img.Rect.Dy())
+		for y := range rows {
+			for x := 0; x < img.Rect.Dx(); x++ {
+				rows[y] = append(rows[y], img.NRGBAAt(x, y).A)
+			}
+		}
+		return rows
+	}
+
+	// a straight edge stays sharp
+	edge := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for x := 0; x < 3; x++ {
+		edge.SetNRGBA(x, 0, white)
+	}
+	assert.Equal(t, [][]uint8{{255, 255, 255, 255, 255, 255}, {255, 255, 255, 255, 255, 255}, {0, 0, 0, 0, 0, 0}, {0, 0, 0, 0, 0, 0}}, alphas(hq2x(edge)))
+
+	// a diagonal turns into a smooth line instead of a staircase
+	diagonal := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	diagonal.SetNRGBA(0, 0, white)
+	diagonal.SetNRGBA(1, 1, white)
+	scaled := hq2x(diagonal)
+	assert.Equal(t, [][]uint8{
+		{255, 255, 0, 0},
+		{255, 128, 128, 0},
+		{0, 128, 128, 255},
+		{0, 0, 255, 255},
+	}, alphas(scaled))
+	assert.Equal(t, color.NRGBA{255, 255, 255, 128}, scaled.NRGBAAt(2, 1), "transparent pixels shouldn't darken the blend")
+
+	// a lone pixel keeps most of its alpha
+	lone := image.NewNRGBA(image.Rect(0, 0, 3, 3))
+	lone.SetNRGBA(1, 1, white)
+	assert.Equal(t, uint8(223), hq2x(lone).NRGBAAt(2, 2).A)
+	assertFail(t, image.Rect(0, 0, 6, 6), sheetFilters["hqx"].rescale(diagonal, 6, 6).Bounds(), "hqx should be resampled to the exact size")
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Ancient/Ancient_00.bffnt")
+	handleErr(err)
+	var original BFFNT
+	original.Decode(bffntRaw)
+	originalGlyph, _ := original.glyphInfoAt(40)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.Upscale(2)
+	defer os.Remove("TestAncient_00_2.00x.png")
+	bffnt.upscaleSheets(original.TGLP, "TestAncient", 2, "nearest", "", true)
+
+	assertFail(t, [3]int{int(original.TGLP.CellWidth) * 2, int(original.TGLP.CellHeight) * 2, int(original.TGLP.BaselinePosition) * 2},
+		[3]int{int(bffnt.TGLP.CellWidth), int(bffnt.TGLP.CellHeight), int(bffnt.TGLP.BaselinePosition)}, "cells should be scaled with the sheets")
+	glyph, _ := bffnt.glyphInfoAt(40)
+	assertFail(t, [3]int{int(originalGlyph.LeftWidth) * 2, int(originalGlyph.GlyphWidth) * 2, int(originalGlyph.CharWidth) * 2},
+		[3]int{int(glyph.LeftWidth), int(glyph.GlyphWidth), int(glyph.CharWidth)}, "widths should be scaled like the cells")
+
+	// at 2x nearest every pixel of the cell turns into a 2x2 block
+	original.TGLP.DecodeSheets()
+	_, srcRect := original.TGLP.CellRect(40)
+	_, dstRect := bffnt.TGLP.CellRect(40)
+	assertFail(t, srcRect.Dx()*2, dstRect.Dx(), "cell width")
+	inked := 0
+	for y := 0; y < dstRect.Dy(); y++ {
+		for x := 0; x < dstRect.Dx(); x++ {
+			srcAlpha := original.TGLP.SheetData[0].NRGBAAt(srcRect.Min.X+x/2, srcRect.Min.Y+y/2).A
+			assertFail(t, srcAlpha, bffnt.TGLP.SheetData[0].NRGBAAt(dstRect.Min.X+x, dstRect.Min.Y+y).A, fmt.Sprintf("pixel %d,%d of glyph 40", x, y))
+			if srcAlpha > INK_MIN_ALPHA {
+				inked++
+			}
+		}
+	}
+	assert.Greater(t, inked, 0, "glyph 40 should have ink")
+}
+
+func TestUpscaleAlterCharIndex(t *testing.T) {
+	// the alter char is a glyph index, scaling it pointed unmapped characters
+	// at some other glyph
+	for _, scale := range []float64{0.5, 2, 3} {
+		finf := FINF{AlterCharIndex: 31, LineFeed: 39, Height: 39}
+		finf.Upscale(scale)
+		assertFail(t, uint16(31), finf.AlterCharIndex, fmt.Sprintf("alter char index at %gx", scale))
+	}
+}
+
+func TestItalic(t *testing.T) {
+	// slope 1 moves every row one pixel further right per row above the
+	// baseline at y 4
+	cell := image.NewAlpha(image.Rect(0, 0, 6, 6))
+	for y := 0; y <= 4; y++ {
+		cell.SetAlpha(1, y, color.Alpha{255})
+	}
+	sheared := image.NewAlpha(cell.Bounds())
+	drawSheared(sheared, cell, 4, 1)
+	for y := 0; y <= 4; y++ {
+		assertFail(t, uint8(255), sheared.AlphaAt(1+4-y, y).A, fmt.Sprintf("row %d should move %d pixel(s) right", y, 4-y))
+	}
+	assertFail(t, uint8(0), sheared.AlphaAt(1, 0).A, "the top of the line shouldn't stay upright")
+
+	// half a pixel splits the coverage, what's sheared out of the cell is dropped
+	sheared = image.NewAlpha(cell.Bounds())
+	drawSheared(sheared, cell, 4, 0.5)
+	assertFail(t, [2]uint8{127, 127}, [2]uint8{sheared.AlphaAt(1, 3).A, sheared.AlphaAt(2, 3).A}, "half a pixel of shear")
+	sheared = image.NewAlpha(cell.Bounds())
+	drawSheared(sheared, cell, 4, 2)
+	assertFail(t, uint8(0), sheared.AlphaAt(5, 1).A, "ink sheared out of the cell should be dropped")
+
+	// 12 degrees, the ink below the baseline leans left and has to be moved right
+	slope := math.Tan(12 * math.Pi / 180)
+	shift, extraWidth := italicShear(20, 5, slope)
+	assertFail(t, [2]int{2, 6}, [2]int{shift, extraWidth}, "shift and extra width of a descender")
+	shift, extraWidth = italicShear(20, 0, slope)
+	assertFail(t, [2]int{0, 5}, [2]int{shift, extraWidth}, "ink on the baseline doesn't need a shift")
+	shift, extraWidth = italicShear(20, 5, -slope)
+	assertFail(t, [2]int{5, 6}, [2]int{shift, extraWidth}, "leaning left shifts by the ink above the baseline")
+
+	// every rendered glyph gets wider by the shear and its LeftWidth moves
+	// by the shift
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/External/External_00.bffnt")
+	handleErr(err)
+	initializeGlyphMaps()
+	fontFile := filepath.Join("..", resolveFontFile("External", ""))
+	render := func(slope float64) BFFNT {
+		var bffnt BFFNT
+		bffnt.Decode(bffntRaw)
+		bffnt.Upscale(2)
+		dst, _ := bffnt.renderGlyphSheet("External", fontFile, 2, upscaleOptions{italicSlope: slope})
+		alphaBuffers.put(dst)
+		return bffnt
+	}
+	upright, italic := render(0), render(slope)
+	key := faceKey{fontFile, getBotwFontSettings("External", 2), ""}
+	checked := 0
+	for i, pair := range upright.GlyphIndexes() {
+		measurement, measured := glyphMeasurements.entries[glyphMeasurementKey{key, string(rune(asciiToGlyph("External", pair.CharAscii)))}]
+		if !measured || measurement.bounds.Empty() {
+			continue
+		}
+		above := -int(math.Floor(float64(measurement.bounds.Min.Y) / 64))
+		below := int(math.Floor(float64(measurement.bounds.Max.Y) / 64))
+		shift, extraWidth := italicShear(above, below, slope)
+		uprightGlyph, italicGlyph := upright.CWDHs[0].Glyphs[i], italic.CWDHs[0].Glyphs[i]
+		assertFail(t, int(uprightGlyph.GlyphWidth)+extraWidth, int(italicGlyph.GlyphWidth), fmt.Sprintf("italic GlyphWidth of glyph %d", i))
+		assertFail(t, int(uprightGlyph.LeftWidth)-shift, int(italicGlyph.LeftWidth), fmt.Sprintf("italic LeftWidth of glyph %d", i))
+		assertFail(t, uprightGlyph.CharWidth, italicGlyph.CharWidth, fmt.Sprintf("italic shouldn't change the advance of glyph %d", i))
+		checked++
+	}
+	assert.Greater(t, checked, 10, "most glyphs should have been measured")
+}
+
+func TestRenderWritesGlyphWidths(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/External/External_00.bffnt")
+	handleErr(err)
+	initializeGlyphMaps()
+	fontFile := filepath.Join("..", resolveFontFile("External", ""))
+	var scaled BFFNT
+	scaled.Decode(bffntRaw)
+	scaled.Upscale(2)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	bffnt.Upscale(2)
+	dst, _ := bffnt.renderGlyphSheet("External", fontFile, 2, upscaleOptions{})
+	alphaBuffers.put(dst)
+
+	// the GlyphWidth is the width of the ink that was drawn, not the scaled
+	// one of the original glyph
+	key := faceKey{fontFile, getBotwFontSettings("External", 2), ""}
+	changed := 0
+	for i, pair := range bffnt.GlyphIndexes() {
+		measurement, measured := glyphMeasurements.entries[glyphMeasurementKey{key, string(rune(asciiToGlyph("External", pair.CharAscii)))}]
+		if !measured {
+			continue
+		}
+		inkWidth := int(math.Floor(float64(measurement.bounds.Max.X)/64)) - int(math.Floor(float64(measurement.bounds.Min.X)/64)) + 1
+		assertFail(t, inkWidth, int(bffnt.CWDHs[0].Glyphs[i].GlyphWidth), fmt.Sprintf("GlyphWidth of glyph %d", i))
+		if bffnt.CWDHs[0].Glyphs[i].GlyphWidth != scaled.CWDHs[0].Glyphs[i].GlyphWidth {
+			changed++
+		}
+	}
+	assert.Greater(t, changed, 0, "rendering should change GlyphWidths")
+}
+
+func TestBold(t *testing.T) {
+	// a single pixel grows into a square of 2*radius+1
+	img := image.NewAlpha(image.Rect(0, 0, 9, 9))
+	img.SetAlpha(4, 4, color.Alpha{200})
+	img.SetAlpha(0, 8, color.Alpha{100})
+	dilated := dilateAlpha(img, 2)
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 9; x++ {
+			expected := uint8(0)
+			if x >= 2 && x <= 6 && y >= 2 && y <= 6 {
+				expected = 200
+			} else if x <= 2 && y >= 6 {
+				expected = 100 // clipped at the corner of the image
+			}
+			assertFail(t, expected, dilated.AlphaAt(x, y).A, fmt.Sprintf("dilated pixel %d,%d", x, y))
+		}
+	}
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/External/External_00.bffnt")
+	handleErr(err)
+	initializeGlyphMaps()
+	fontFile := filepath.Join("..", resolveFontFile("External", ""))
+	render := func(radius int) BFFNT {
+		var bffnt BFFNT
+		bffnt.Decode(bffntRaw)
+		bffnt.Upscale(2)
+		dst, _ := bffnt.renderGlyphSheet("External", fontFile, 2, upscaleOptions{boldRadius: radius})
+		alphaBuffers.put(dst)
+		return bffnt
+	}
+	regular, bold := render(0), render(2)
+	for i := range regular.CWDHs[0].Glyphs {
+		regularGlyph, boldGlyph := regular.CWDHs[0].Glyphs[i], bold.CWDHs[0].Glyphs[i]
+		assertFail(t, int(regularGlyph.GlyphWidth)+4, int(boldGlyph.GlyphWidth), fmt.Sprintf("bold GlyphWidth of glyph %d", i))
+		assertFail(t, int(regularGlyph.CharWidth)+4, int(boldGlyph.CharWidth), fmt.Sprintf("bold CharWidth of glyph %d", i))
+		assertFail(t, regularGlyph.LeftWidth, boldGlyph.LeftWidth, fmt.Sprintf("bold LeftWidth of glyph %d", i))
+	}
+}
+
+func TestAdjustTracking(t *testing.T) {
+	cwdh := CWDH{StartIndex: 10, Glyphs: []glyphInfo{{0, 10, 12}, {-6, 5, 0}, {0, 10, 250}, {1, 3, 4}}}
+	clamped, skipped := cwdh.AdjustCharWidths(10)
+	assertFail(t, []uint8{22, 0, 255, 14}, []uint8{cwdh.Glyphs[0].CharWidth, cwdh.Glyphs[1].CharWidth, cwdh.Glyphs[2].CharWidth, cwdh.Glyphs[3].CharWidth}, "char widths tracked by 10")
+	assertFail(t, clampedMetrics{"glyph 12 CharWidth 260"}, clamped, "too wide char width should be clamped")
+	assertFail(t, 1, skipped, "zero width glyph should be skipped")
+	clamped, _ = cwdh.AdjustCharWidths(-20)
+	assertFail(t, []uint8{2, 0, 235, 0}, []uint8{cwdh.Glyphs[0].CharWidth, cwdh.Glyphs[1].CharWidth, cwdh.Glyphs[2].CharWidth, cwdh.Glyphs[3].CharWidth}, "char widths tracked by -20")
+	assertFail(t, clampedMetrics{"glyph 13 CharWidth -6"}, clamped, "negative char width should be clamped")
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	var original, bffnt BFFNT
+	original.Decode(bffntRaw)
+	bffnt.Decode(bffntRaw)
+	bffnt.AdjustTracking(3, -2)
+	for i, glyph := range original.allGlyphInfo() {
+		expected := glyph.CharWidth
+		if expected != 0 {
+			expected += 3
+		}
+		assertFail(t, expected, bffnt.allGlyphInfo()[i].CharWidth, fmt.Sprintf("tracked char width of glyph %d", i))
+		assertFail(t, glyph.LeftWidth, bffnt.allGlyphInfo()[i].LeftWidth, "tracking shouldn't move the ink")
+	}
+	originalPairs, pairs := original.kerningPairs(), bffnt.kerningPairs()
+	assertFail(t, len(originalPairs), len(pairs), "kerning pairs")
+	for i := range pairs {
+		assertFail(t, originalPairs[i][2]-2, pairs[i][2], "tracked kerning")
+	}
+}
+
+func TestScaleLineFeed(t *testing.T) {
+	// Normal's line feed and height are 39, 39 * 1.5 = 58.5
+	testCases := []struct {
+		policy   string
+		expected int
+	}{
+		{"ceil", 59},
+		{"floor", 58},
+		{"round", 59},
+		{"50", 50},
+		{"0", 0},
+	}
+	for _, tc := range testCases {
+		finf := FINF{LineFeed: 1, Height: 1}
+		finf.ScaleLineFeed(39, 1.5, tc.policy)
+		finf.ScaleHeight(39, 1.5, tc.policy)
+		assertFail(t, uint16(tc.expected), finf.LineFeed, fmt.Sprintf("line feed with policy %s", tc.policy))
+		assertFail(t, uint8(tc.expected), finf.Height, fmt.Sprintf("height with policy %s", tc.policy))
+	}
+
+	// the two are independent
+	finf := FINF{LineFeed: 39, Height: 39}
+	finf.Upscale(1.5)
+	finf.ScaleLineFeed(39, 1.5, "floor")
+	assertFail(t, [2]int{58, 59}, [2]int{int(finf.LineFeed), int(finf.Height)}, "only the line feed should be floored")
+
+	for _, policy := range []string{"nearest", "-3", "2.5", ""} {
+		assert.Panics(t, func() { finf.ScaleLineFeed(39, 1.5, policy) }, "policy %q should fail", policy)
+	}
+	assert.Panics(t, func() { finf.ScaleHeight(39, 1.5, "300") }, "a height over 255 should fail")
+	assert.Panics(t, func() { finf.ScaleHeight(200, 1.5, "ceil") }, "a scaled height over 255 should fail")
+	assert.NotPanics(t, func() { finf.ScaleLineFeed(39, 1.5, "300") }, "line feeds are 2 bytes")
+}
+
+func TestWidthOverflowReport(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/External/External_00.bffnt")
+	handleErr(err)
+	initializeGlyphMaps()
+	fontFile := filepath.Join("..", resolveFontFile("External", ""))
+	// the cells are 2x, the glyphs are drawn at the font size of 10x
+	render := func(scale float64) (BFFNT, []widthOverflow) {
+		var bffnt BFFNT
+		bffnt.Decode(bffntRaw)
+		bffnt.Upscale(2)
+		dst, overflows := bffnt.renderGlyphSheet("External", fontFile, scale, upscaleOptions{})
+		alphaBuffers.put(dst)
+		return bffnt, overflows
+	}
+	bffnt, overflows := render(10)
+
+	// every glyph whose ink or advance doesn't fit a byte is reported, not
+	// only the first one
+	key := faceKey{fontFile, getBotwFontSettings("External", 10), ""}
+	expected := make([]uint16, 0)
+	widest := 0
+	for i, pair := range bffnt.GlyphIndexes() {
+		measurement := glyphMeasurements.entries[glyphMeasurementKey{key, string(rune(asciiToGlyph("External", pair.CharAscii)))}]
+		glyphWidth := int(math.Floor(float64(measurement.bounds.Max.X)/64)) - int(math.Floor(float64(measurement.bounds.Min.X)/64)) + 1
+		advance := int(measurement.advance / 64)
+		if glyphWidth > MAX_GLYPH_WIDTH || advance > MAX_GLYPH_WIDTH {
+			expected = append(expected, pair.CharAscii)
+		}
+		if glyphWidth > MAX_GLYPH_WIDTH {
+			assertFail(t, uint8(MAX_GLYPH_WIDTH), bffnt.CWDHs[0].Glyphs[i].GlyphWidth, "too wide glyph should be clamped")
+		}
+		widest = int(math.Max(float64(widest), math.Max(float64(glyphWidth), float64(advance))))
+	}
+	reported := make([]uint16, 0)
+	for _, overflow := range overflows {
+		reported = append(reported, overflow.char)
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+	sort.Slice(reported, func(i, j int) bool { return reported[i] < reported[j] })
+	assert.Greater(t, len(expected), 10, "most glyphs should be too wide at 10x")
+	assertFail(t, expected, reported, "every glyph that doesn't fit should be reported")
+
+	// the limits are the current ones shrunk by the widest glyph, and the
+	// glyphs fit at them
+	fontSize := getBotwFontSettings("External", 10)
+	maxFontSize, maxScale := widthOverflowLimits(overflows, fontSize, 10)
+	assert.InDelta(t, fontSize*MAX_GLYPH_WIDTH/float64(widest), maxFontSize, 0.1, "suggested font size")
+	assert.InDelta(t, 10*MAX_GLYPH_WIDTH/float64(widest), maxScale, 0.01, "suggested scale")
+	_, overflows = render(maxScale)
+	assertFail(t, 0, len(overflows), fmt.Sprintf("nothing should overflow at the suggested scale %.2f", maxScale))
+	assertFail(t, maxFontSize, math.Floor(getBotwFontSettings("External", maxScale)*10)/10, "the suggested font size is the size of the suggested scale")
+}
+
+func TestPruneSheetCache(t *testing.T) {
+	defer func(dir string, maxSize int64) { SheetCacheDir, SheetCacheMaxSize = dir, maxSize }(SheetCacheDir, SheetCacheMaxSize)
+	SheetCacheDir = t.TempDir()
+	write := func(key string, size int, age time.Duration) string {
+		path := sheetCachePath(key)
+		handleErr(os.MkdirAll(filepath.Dir(path), 0755))
+		handleErr(os.WriteFile(path, make([]byte, size), 0644))
+		used := time.Now().Add(-age)
+		handleErr(os.Chtimes(path, used, used))
+		return path
+	}
+	oldest := write("aa01", 400, 3*time.Hour)
+	older := write("bb02", 400, 2*time.Hour)
+	newest := write("cc03", 400, time.Hour)
+	handleErr(pruneSheetCache(1000))
+	assert.NoFileExists(t, oldest, "least recently used sheet should be removed")
+	assert.FileExists(t, older)
+	assert.FileExists(t, newest)
+	handleErr(pruneSheetCache(2000))
+	assert.FileExists(t, older, "a cache under the limit shouldn't be pruned")
+
+	// reading a sheet counts as using it, writing one prunes the cache
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Ancient/Ancient_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	bffnt.Decode(bffntRaw)
+	sheetSize := int64(bffnt.TGLP.SheetWidth) * int64(bffnt.TGLP.SheetHeight)
+	SheetCacheMaxSize = sheetSize + 400
+	bffnt.TGLP.DecodeSheets()
+	key := bffnt.TGLP.sheetCacheKey(bffnt.TGLP.AllSheetData[:bffnt.TGLP.SheetSize], 0)
+	assertFail(t, false, bffnt.TGLP.cachedSheet(key) == nil, "decoded sheet should be cached")
+	assert.NoFileExists(t, older, "writing a sheet should make room for it")
+	assert.FileExists(t, newest)
+	handleErr(os.Chtimes(sheetCachePath(key), time.Now().Add(-4*time.Hour), time.Now().Add(-4*time.Hour)))
+	bffnt.TGLP.cachedSheet(key)
+	handleErr(pruneSheetCache(sheetSize))
+	assert.FileExists(t, sheetCachePath(key), "a sheet that was just read should be kept")
+	assert.NoFileExists(t, newest)
+}
+
+func TestSwitchFont(t *testing.T) {
+	// the pieces of a GOB and the GOBs of a block
+	assert.Equal(t, 0, tegraAddress(0, 0, 1, 1))
+	assert.Equal(t, 16, tegraAddress(0, 1, 1, 1))
+	assert.Equal(t, 32, tegraAddress(16, 0, 1, 1))
+	assert.Equal(t, 64, tegraAddress(0, 2, 1, 1))
+	assert.Equal(t, 256, tegraAddress(32, 0, 1, 1))
+	assert.Equal(t, 512, tegraAddress(0, 8, 1, 2))
+	assert.Equal(t, 1024, tegraAddress(64, 0, 2, 2))
+	assert.Equal(t, 2048, tegraAddress(0, 16, 2, 2))
+	assert.Equal(t, 16, tegraBlockHeight(1024))
+	assert.Equal(t, 4, tegraBlockHeight(25))
+	assert.Equal(t, 1024*1024, tegraSurfaceSize(1024, 1024, 1))
+	assert.Equal(t, 2*64*32, tegraSurfaceSize(13, 25, 8))
+
+	for _, size := range [][3]int{{100, 37, 1}, {13, 25, 8}, {256, 256, 1}} {
+		linear := make([]byte, size[0]*size[1]*size[2])
+		for i := range linear {
+			linear[i] = byte(i*7 + i/251)
+		}
+		swizzled := tegraSwizzle(linear, size[0], size[1], size[2], true)
+		assert.Equal(t, tegraSurfaceSize(size[0], size[1], size[2]), len(swizzled))
+		assert.Equal(t, linear, tegraSwizzle(swizzled, size[0], size[1], size[2], false), "%v roundtrip", size)
+	}
+
+	// Normal has two BC4 sheets, Caption kerning
+	for _, name := range []string{"Normal", "Caption"} {
+		raw, err := ioutil.ReadFile("../WiiU_fonts/botw/" + name + "/" + name + "_00.bffnt")
+		handleErr(err)
+		wiiu := BFFNT{}
+		wiiu.Decode(raw)
+		converted, err := encodeSwitchFont(raw)
+		handleErr(err)
+
+		le := binary.LittleEndian
+		assert.Equal(t, []byte{0xFF, 0xFE}, converted[4:6], name)
+		assert.Equal(t, len(converted), int(le.Uint32(converted[12:16])), name)
+		assert.Equal(t, wiiu.FFNT.Version, le.Uint32(converted[8:12]), name)
+
+		// same sections in the same order, only TGLP changes size
+		var magics []string
+		for pos := FFNT_HEADER_SIZE; pos < len(converted); pos += int(le.Uint32(converted[pos+4 : pos+8])) {
+			magics = append(magics, string(converted[pos:pos+4]))
+		}
+		var wiiuMagics []string
+		sections := newSectionIterator(raw)
+		for sections.Next() {
+			wiiuMagics = append(wiiuMagics, sections.Section().MagicHeader)
+		}
+		assert.Equal(t, wiiuMagics, magics, name)
+
+		finf := converted[FFNT_HEADER_SIZE:]
+		assert.Equal(t, wiiu.FINF.LineFeed, le.Uint16(finf[12:14]), name)
+		tglp := converted[le.Uint32(finf[20:24])-8:]
+		assert.Equal(t, wiiu.TGLP.SheetWidth, le.Uint16(tglp[24:26]), name)
+		assert.Equal(t, wiiu.TGLP.SheetImageFormat, le.Uint16(tglp[18:20]), name)
+
+		// the moved offsets still lead from section to section
+		cwdhs := 0
+		for offset := le.Uint32(finf[24:28]); offset != 0; offset = le.Uint32(converted[offset+4 : offset+8]) {
+			cwdh := converted[offset-8:]
+			assert.Equal(t, CWDH_MAGIC_HEADER, string(cwdh[:4]), name)
+			assert.Equal(t, wiiu.CWDHs[cwdhs].EndIndex, le.Uint16(cwdh[10:12]), name)
+			assert.Equal(t, uint8(wiiu.CWDHs[cwdhs].Glyphs[0].CharWidth), cwdh[18], name)
+			cwdhs++
+		}
+		assert.Equal(t, len(wiiu.CWDHs), cwdhs, name)
+		cmaps := 0
+		for offset := le.Uint32(finf[28:32]); offset != 0; offset = le.Uint32(converted[offset+8 : offset+12]) {
+			cmap := converted[offset-8:]
+			assert.Equal(t, CMAP_MAGIC_HEADER, string(cmap[:4]), name)
+			assert.Equal(t, wiiu.CMAPs[cmaps].CodeBegin, le.Uint16(cmap[8:10]), name)
+			assert.Equal(t, wiiu.CMAPs[cmaps].MappingMethod, le.Uint16(cmap[12:14]), name)
+			cmaps++
+		}
+		assert.Equal(t, len(wiiu.CMAPs), cmaps, name)
+
+		// every sheet swizzles back to the same elements as the Wii U's
+		width, height, _, bpp := wiiu.TGLP.sheetSurface()
+		sheetSize := int(le.Uint32(tglp[12:16]))
+		sheetData := converted[le.Uint32(tglp[28:32]):]
+		for i := 0; i < int(wiiu.TGLP.NumOfSheets); i++ {
+			wiiuSheet := wiiu.TGLP.AllSheetData[i*int(wiiu.TGLP.SheetSize) : (i+1)*int(wiiu.TGLP.SheetSize)]
+			switchSheet := sheetData[i*sheetSize : (i+1)*sheetSize]
+			assert.True(t, bytes.Equal(wiiu.TGLP.untileSheet(wiiuSheet, i, 0), tegraSwizzle(switchSheet, int(width), int(height), int(bpp/8), false)), "%s sheet %d", name, i)
+		}
+
+		if name == "Caption" {
+			krng := converted[len(converted)-int(wiiu.KRNG.SectionSize):]
+			assert.Equal(t, KRNG_MAGIC_HEADER, string(krng[:4]), name)
+			assert.Equal(t, len(wiiu.KRNG.KerningTable), int(le.Uint16(krng[8:10])), name)
+		}
+
+		_, err = encodeSwitchFont(converted)
+		assert.NotNil(t, err, "converting twice")
+		unknown := append(append([]byte(nil), raw...), 'T', 'E', 'S', 'T', 0, 0, 0, 8)
+		_, err = encodeSwitchFont(unknown)
+		assert.NotNil(t, err, "unknown sections can't be converted")
+	}
+
+	// a little endian archive with a single font
+	le := binary.LittleEndian
+	names := []byte("Caption_00.bffnt\x00\x00\x00\x00")
+	dataOffset := SARC_HEADER_SIZE + SFAT_HEADER_SIZE + SFAT_NODE_SIZE + 8 + len(names)
+	font := []byte("FFNT\xFF\xFEswitch font")
+	archive := make([]byte, dataOffset, dataOffset+len(font))
+	copy(archive, "SARC")
+	le.PutUint16(archive[4:6], SARC_HEADER_SIZE)
+	le.PutUint16(archive[6:8], 0xFEFF)
+	le.PutUint32(archive[8:12], uint32(dataOffset+len(font)))
+	le.PutUint32(archive[12:16], uint32(dataOffset))
+	le.PutUint16(archive[16:18], 0x0100)
+	sfat := archive[SARC_HEADER_SIZE:]
+	copy(sfat, "SFAT")
+	le.PutUint16(sfat[4:6], SFAT_HEADER_SIZE)
+	le.PutUint16(sfat[6:8], 1)
+	le.PutUint32(sfat[8:12], 0x65)
+	node := sfat[SFAT_HEADER_SIZE:]
+	le.PutUint32(node[4:8], 0x01000000)
+	le.PutUint32(node[12:16], uint32(len(font)))
+	sfnt := node[SFAT_NODE_SIZE:]
+	copy(sfnt, "SFNT")
+	le.PutUint16(sfnt[4:6], 8)
+	copy(sfnt[8:], names)
+	archive = append(archive, font...)
+
+	sarc, err := DecodeSARC(archive)
+	handleErr(err)
+	assert.Equal(t, string(font), string(sarc.file("Caption_00.bffnt").Data))
+	assert.True(t, bytes.Equal(archive, sarc.Encode()), "unchanged little endian SARC roundtrip")
+
+	sbfarcFile := filepath.Join(t.TempDir(), "Font_EU.sbfarc")
+	handleErr(os.WriteFile(sbfarcFile, encodeYaz0Stored(archive), 0644))
+	raw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	botw := readGameProfile("botw")
+	modDir := t.TempDir()
+	writeAtmosphereMod(modDir, sbfarcFile, botw.SwitchTitleID, botw.destination("Caption", ""), raw)
+
+	compressed, err := ioutil.ReadFile(filepath.Join(modDir, "atmosphere/contents/01007EF00011E000/romfs/Font/Font_EU.sbfarc"))
+	handleErr(err)
+	modArchive, err := decodeYaz0(compressed)
+	handleErr(err)
+	mod, err := DecodeSARC(modArchive)
+	handleErr(err)
+	converted, err := encodeSwitchFont(raw)
+	handleErr(err)
+	assert.True(t, bytes.Equal(converted, mod.file("Caption_00.bffnt").Data), "the mod's font is the converted one")
+
+	// a Switch font doesn't go into the Wii U's archive
+	assert.Panics(t, func() {
+		writeAtmosphereMod(t.TempDir(), "../WiiU_fonts/botw/Font_EU.sbfarc", botw.SwitchTitleID, botw.destination("Caption", ""), raw)
+	})
+}
+
+func TestBPSKeepsSheet(t *testing.T) {
+	bffntFile := "../WiiU_fonts/botw/Ancient/Ancient_00.bffnt"
+	outputDir := t.TempDir()
+	opts := upscaleOptions{bffntFile: bffntFile, outputDir: outputDir, platform: "wiiu", bps: true, workers: 1}
+	assert.True(t, opts.keepsSheet())
+	upscaleBffnt("Ancient", "../nintendo_system_ui/botw-sheikah.ttf", 2, opts)
+
+	original, err := ioutil.ReadFile(bffntFile)
+	handleErr(err)
+	patch, err := ioutil.ReadFile(filepath.Join(outputDir, "Ancient_00_2.00x_template.bps"))
+	handleErr(err)
+	patched, err := ApplyBPS(original, patch)
+	handleErr(err)
+	written, err := ioutil.ReadFile(filepath.Join(outputDir, "Ancient_00_2.00x_template.bffnt"))
+	handleErr(err)
+	assert.True(t, bytes.Equal(written, patched), "the patch makes the upscaled font")
+
+	// the rendered glyphs are in the patched font, not blank sheets
+	bffnt := BFFNT{}
+	bffnt.Decode(patched)
+	assert.NotEqual(t, len(bffnt.TGLP.AllSheetData), bytes.Count(bffnt.TGLP.AllSheetData, []byte{0}), "the sheets are blank")
+}
+
 // used to check if all padded bytes are zero
 func allZero(s []byte) bool {
 	for _, v := range s {
@@ -329,6 +4100,8 @@ func allZero(s []byte) bool {
 }
 
 func TestMain(m *testing.M) {
+	// tests that need the sheet cache point it at a temporary directory
+	SheetCacheDir = ""
 	code := m.Run()
 	os.Exit(code)
 }
@@ -0,0 +1,320 @@
+package bffnt_headers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BPS patches describe the upscaled font in terms of the original one, so a
+// mod can be shared without the fonts themselves. Everyone applies it to
+// their own dump. https://github.com/blakesmith/beat/blob/master/doc/bps.txt
+//
+// A patch is "BPS1", the source, target and metadata sizes, actions that
+// build the target, then the CRC32s of the source, target and patch. Every
+// action is a number holding the length and one of the commands below.
+const (
+	BPS_SOURCE_READ = 0 // copy from the source at the current target offset
+	BPS_TARGET_READ = 1 // bytes stored in the patch
+	BPS_SOURCE_COPY = 2 // copy from anywhere in the source
+	BPS_TARGET_COPY = 3 // copy from the target written so far
+
+	BPS_MIN_MATCH  = 8 // shorter matches cost about as much as storing the bytes
+	BPS_CANDIDATES = 16
+
+	// A bffnt's file size is 32 bit, see FFNT.TotalFileSize. Bigger targets
+	// can only come from a broken or malicious patch.
+	BPS_MAX_TARGET_SIZE = math.MaxUint32
+)
+
+func bpsWriteNumber(buf *bytes.Buffer, n uint64) {
+	for {
+		x := byte(n & 0x7F)
+		n >>= 7
+		if n == 0 {
+			buf.WriteByte(0x80 | x)
+			return
+		}
+		buf.WriteByte(x)
+		n--
+	}
+}
+
+func bpsReadNumber(patch []byte, pos *int) (uint64, error) {
+	var n uint64
+	shift := uint64(1)
+	for {
+		if *pos >= len(patch) {
+			return 0, fmt.Errorf("BPS patch ends in the middle of a number")
+		}
+		x := patch[*pos]
+		*pos++
+		n += uint64(x&0x7F) * shift
+		if x&0x80 != 0 {
+			return n, nil
+		}
+		shift <<= 7
+		n += shift
+	}
+}
+
+func bpsWriteOffset(buf *bytes.Buffer, offset int) {
+	if offset < 0 {
+		bpsWriteNumber(buf, uint64(-offset)<<1|1)
+	} else {
+		bpsWriteNumber(buf, uint64(offset)<<1)
+	}
+}
+
+// Positions of every 8 byte sequence, the last BPS_CANDIDATES of each
+type bpsIndex map[uint64][]int
+
+func (index bpsIndex) add(data []byte, pos int) {
+	key := binary.BigEndian.Uint64(data[pos:])
+	positions := index[key]
+	if len(positions) == BPS_CANDIDATES {
+		positions = positions[1:]
+	}
+	index[key] = append(positions, pos)
+}
+
+func matchLength(a []byte, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// Longest match for target[pos:] among the indexed positions of data. limit
+// keeps target copies from reading bytes that aren't written yet.
+func (index bpsIndex) longest(data []byte, limit int, target []byte, pos int) (int, int) {
+	bestPos, bestLength := 0, 0
+	if pos+BPS_MIN_MATCH > len(target) {
+		return 0, 0
+	}
+	for _, candidate := range index[binary.BigEndian.Uint64(target[pos:])] {
+		if length := matchLength(data[candidate:limit], target[pos:]); length > bestLength {
+			bestPos, bestLength = candidate, length
+		}
+	}
+	return bestPos, bestLength
+}
+
+// Greedy encoder: at every offset the longest of reading the source in place,
+// copying from the source or copying from the target wins, bytes nothing
+// matches are stored
+func CreateBPS(source []byte, target []byte, metadata string) []byte {
+	var patch bytes.Buffer
+	patch.WriteString("BPS1")
+	bpsWriteNumber(&patch, uint64(len(source)))
+	bpsWriteNumber(&patch, uint64(len(target)))
+	bpsWriteNumber(&patch, uint64(len(metadata)))
+	patch.WriteString(metadata)
+
+	sourceIndex := bpsIndex{}
+	for pos := 0; pos+BPS_MIN_MATCH <= len(source); pos++ {
+		sourceIndex.add(source, pos)
+	}
+	targetIndex := bpsIndex{}
+	targetIndexed := 0
+
+	sourceRelative, targetRelative := 0, 0
+	literalStart := -1
+	flushLiterals := func(end int) {
+		if literalStart < 0 {
+			return
+		}
+		bpsWriteNumber(&patch, uint64(end-literalStart-1)<<2|BPS_TARGET_READ)
+		patch.Write(target[literalStart:end])
+		literalStart = -1
+	}
+
+	for pos := 0; pos < len(target); {
+		for ; targetIndexed+BPS_MIN_MATCH <= pos; targetIndexed++ {
+			targetIndex.add(target, targetIndexed)
+		}
+
+		command, length, from := BPS_SOURCE_READ, 0, 0
+		if pos < len(source) {
+			length = matchLength(source[pos:], target[pos:])
+		}
+		if sourcePos, sourceLength := sourceIndex.longest(source, len(source), target, pos); sourceLength > length {
+			command, length, from = BPS_SOURCE_COPY, sourceLength, sourcePos
+		}
+		if targetPos, targetLength := targetIndex.longest(target, pos, target, pos); targetLength > length {
+			command, length, from = BPS_TARGET_COPY, targetLength, targetPos
+		}
+
+		if length < BPS_MIN_MATCH {
+			if literalStart < 0 {
+				literalStart = pos
+			}
+			pos++
+			continue
+		}
+
+		flushLiterals(pos)
+		bpsWriteNumber(&patch, uint64(length-1)<<2|uint64(command))
+		switch command {
+		case BPS_SOURCE_COPY:
+			bpsWriteOffset(&patch, from-sourceRelative)
+			sourceRelative = from + length
+		case BPS_TARGET_COPY:
+			bpsWriteOffset(&patch, from-targetRelative)
+			targetRelative = from + length
+		}
+		pos += length
+	}
+	flushLiterals(len(target))
+
+	var crcs [8]byte
+	binary.LittleEndian.PutUint32(crcs[0:4], crc32.ChecksumIEEE(source))
+	binary.LittleEndian.PutUint32(crcs[4:8], crc32.ChecksumIEEE(target))
+	patch.Write(crcs[:])
+	binary.LittleEndian.PutUint32(crcs[0:4], crc32.ChecksumIEEE(patch.Bytes()))
+	patch.Write(crcs[0:4])
+
+	return patch.Bytes()
+}
+
+func ApplyBPS(source []byte, patch []byte) ([]byte, error) {
+	if len(patch) < 4+12 || string(patch[0:4]) != "BPS1" {
+		return nil, fmt.Errorf("not a BPS patch")
+	}
+	footer := len(patch) - 12
+	if crc32.ChecksumIEEE(patch[:footer+8]) != binary.LittleEndian.Uint32(patch[footer+8:]) {
+		return nil, fmt.Errorf("the BPS patch is damaged, its checksum doesn't match")
+	}
+	if crc32.ChecksumIEEE(source) != binary.LittleEndian.Uint32(patch[footer:]) {
+		return nil, fmt.Errorf("the BPS patch was made for another file, the source checksum doesn't match")
+	}
+
+	pos := 4
+	sourceSize, err := bpsReadNumber(patch, &pos)
+	if err != nil {
+		return nil, err
+	}
+	targetSize, err := bpsReadNumber(patch, &pos)
+	if err != nil {
+		return nil, err
+	}
+	metadataSize, err := bpsReadNumber(patch, &pos)
+	if err != nil {
+		return nil, err
+	}
+	if sourceSize != uint64(len(source)) || metadataSize > uint64(footer-pos) {
+		return nil, fmt.Errorf("the BPS patch expects a %d byte source, got %d bytes", sourceSize, len(source))
+	}
+	if targetSize > BPS_MAX_TARGET_SIZE {
+		return nil, fmt.Errorf("the BPS patch makes a %d byte file, a bffnt is at most %d bytes", targetSize, uint64(BPS_MAX_TARGET_SIZE))
+	}
+	pos += int(metadataSize)
+
+	target := make([]byte, 0, targetSize)
+	sourceRelative, targetRelative := 0, 0
+	readOffset := func(relative int) (int, error) {
+		n, err := bpsReadNumber(patch, &pos)
+		if n&1 != 0 {
+			return relative - int(n>>1), err
+		}
+		return relative + int(n>>1), err
+	}
+	for pos < footer {
+		action, err := bpsReadNumber(patch, &pos)
+		if err != nil {
+			return nil, err
+		}
+		length := int(action>>2) + 1
+		if uint64(len(target)+length) > targetSize {
+			return nil, fmt.Errorf("the BPS patch writes past the %d byte target", targetSize)
+		}
+
+		switch action & 3 {
+		case BPS_SOURCE_READ:
+			if len(target)+length > len(source) {
+				return nil, fmt.Errorf("the BPS patch reads past the end of the source")
+			}
+			target = append(target, source[len(target):len(target)+length]...)
+		case BPS_TARGET_READ:
+			if pos+length > footer {
+				return nil, fmt.Errorf("the BPS patch ends in the middle of stored bytes")
+			}
+			target = append(target, patch[pos:pos+length]...)
+			pos += length
+		case BPS_SOURCE_COPY:
+			if sourceRelative, err = readOffset(sourceRelative); err != nil {
+				return nil, err
+			}
+			if sourceRelative < 0 || sourceRelative+length > len(source) {
+				return nil, fmt.Errorf("the BPS patch copies from outside the source")
+			}
+			target = append(target, source[sourceRelative:sourceRelative+length]...)
+			sourceRelative += length
+		case BPS_TARGET_COPY:
+			if targetRelative, err = readOffset(targetRelative); err != nil {
+				return nil, err
+			}
+			if targetRelative < 0 || targetRelative >= len(target) {
+				return nil, fmt.Errorf("the BPS patch copies from outside the target")
+			}
+			// byte by byte, the copy may overlap what it writes
+			for i := 0; i < length; i++ {
+				target = append(target, target[targetRelative])
+				targetRelative++
+			}
+		}
+	}
+
+	if uint64(len(target)) != targetSize {
+		return nil, fmt.Errorf("the BPS patch made %d of %d bytes", len(target), targetSize)
+	}
+	if crc32.ChecksumIEEE(target) != binary.LittleEndian.Uint32(patch[footer+4:]) {
+		return nil, fmt.Errorf("the patched file's checksum doesn't match the BPS patch")
+	}
+
+	return target, nil
+}
+
+// Writes a BPS patch turning the original font into the one just written
+func writeBPS(originalFile string, modifiedFile string, patchFile string) {
+	original, err := ioutil.ReadFile(originalFile)
+	handleErr(err)
+	modified, err := ioutil.ReadFile(modifiedFile)
+	handleErr(err)
+
+	patch := CreateBPS(original, modified, "")
+	handleErr(os.WriteFile(patchFile, patch, 0644))
+	fmt.Printf("wrote %s, %d bytes for a %d byte font\n", patchFile, len(patch), len(modified))
+}
+
+// Applies a BPS patch, e.g. one written by -bps, to an original font
+func applyPatchCommand(args []string) {
+	flags := newCommandFlagSet("apply-patch", "[flags] original.bffnt patch.bps")
+	output := flags.String("o", "", "output file. Defaults to <patch name>.bffnt next to the patch")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		exitWithUsage(flags)
+	}
+
+	original, err := ioutil.ReadFile(flags.Arg(0))
+	handleErr(err)
+	patch, err := ioutil.ReadFile(flags.Arg(1))
+	handleErr(err)
+	patched, err := ApplyBPS(original, patch)
+	handleErr(err)
+
+	if *output == "" {
+		patchFile := flags.Arg(1)
+		*output = strings.TrimSuffix(patchFile, filepath.Ext(patchFile)) + ".bffnt"
+	}
+	handleErr(os.WriteFile(*output, patched, 0644))
+	fmt.Println("wrote", *output)
+}
@@ -0,0 +1,70 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/disintegration/imaging"
+)
+
+// A Cemu graphic pack replaces game files with the ones in its content
+// folder. BotW reads its fonts from content/Font/Font_EU.sbfarc, so the pack
+// gets a copy of the archive with the upscaled font swapped in and a
+// rules.txt telling Cemu which game the pack is for.
+const (
+	CEMU_PACK_ARCHIVE    = "content/Font/Font_EU.sbfarc"
+	DEFAULT_BOTW_ARCHIVE = "./WiiU_fonts/botw/Font_EU.sbfarc"
+	CEMU_PACK_RULES      = "rules.txt"
+	CEMU_PACK_RULES_STUB = `[Definition]
+titleIds = 00050000101C9300,00050000101C9400,00050000101C9500
+name = Upscaled Fonts
+path = "The Legend of Zelda: Breath of the Wild/Mods/Upscaled Fonts"
+description = Fonts upscaled by bffnt_upscale
+version = 7
+`
+)
+
+// The upscale writes a template with blank sheets for switch toolbox. A
+// graphic pack or a patch needs the glyphs in the font itself, so the rendered
+// sheet is kept to encode it into the template, see keepsSheet.
+func (tglp *TGLP) keepRenderedSheet(sheet *image.Alpha) {
+	tglp.SheetData = []image.NRGBA{*imaging.Clone(sheet)}
+}
+
+// Swaps the font in the destination's archive under modDir for the upscaled
+// one. The archive of an earlier run is reused so the mod collects every font
+// that was upscaled into it, the first run copies sbfarcFile.
+func replaceFontInArchive(modDir string, sbfarcFile string, destination fontDestination, encoded []byte) {
+	archiveFile := filepath.Join(modDir, filepath.FromSlash(destination.archive))
+	if _, err := os.Stat(archiveFile); err == nil {
+		sbfarcFile = archiveFile
+	}
+	compressed, err := ioutil.ReadFile(sbfarcFile)
+	handleErr(err)
+	raw, err := decodeYaz0(compressed)
+	handleErr(err)
+	sarc, err := DecodeSARC(raw)
+	handleErr(err)
+	if fontOrder := fontByteOrder(encoded); sarc.order != fontOrder {
+		handleErr(fmt.Errorf("%s is a %s archive but the font is a %s font, pass the %s archive with -sbfarc",
+			sbfarcFile, byteOrderPlatform(sarc.order), byteOrderPlatform(fontOrder), byteOrderPlatform(fontOrder)))
+	}
+	handleErr(sarc.Replace(destination.name, encoded))
+
+	handleErr(os.MkdirAll(filepath.Dir(archiveFile), 0755))
+	handleErr(os.WriteFile(archiveFile, encodeYaz0Stored(sarc.Encode()), 0644))
+	fmt.Printf("replaced %s in %s\n", destination.name, archiveFile)
+}
+
+func writeCemuPack(packDir string, sbfarcFile string, destination fontDestination, encoded []byte) {
+	replaceFontInArchive(packDir, sbfarcFile, destination, encoded)
+
+	rulesFile := filepath.Join(packDir, CEMU_PACK_RULES)
+	if _, err := os.Stat(rulesFile); os.IsNotExist(err) {
+		handleErr(os.WriteFile(rulesFile, []byte(CEMU_PACK_RULES_STUB), 0644))
+		fmt.Println("wrote", rulesFile, "- change its name and path to tell packs apart")
+	}
+}
@@ -0,0 +1,339 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The characters a font has to be able to draw, e.g. every character used by
+// the game's text. Charsets are read from plain UTF-8 text files or from MSBT
+// message files dumped from the game.
+type Charset struct {
+	Chars    []rune   // sorted, without duplicates
+	Warnings []string // characters that can't be drawn as a single glyph
+}
+
+func readCharset(filenames []string) Charset {
+	texts := make([]string, 0)
+	for _, filename := range filenames {
+		raw, err := ioutil.ReadFile(filename)
+		handleErr(err)
+
+		if strings.EqualFold(filepath.Ext(filename), ".msbt") {
+			messages, err := decodeMSBTMessages(raw)
+			if err != nil {
+				handleErr(fmt.Errorf("%s: %v", filename, err))
+			}
+			texts = append(texts, messages...)
+		} else {
+			texts = append(texts, string(raw))
+		}
+	}
+
+	return newCharset(texts)
+}
+
+// Text is NFC normalized first so an e followed by a combining accent and a
+// precomposed é end up as the same character. Combining marks that are left
+// over have no precomposed form, the game can only draw them as a zero width
+// glyph over the base character, which is an approximation at best since the
+// mark can't move with the width or height of the base.
+func newCharset(texts []string) Charset {
+	var charset Charset
+	seen := make(map[rune]bool)
+	unreachable := make(map[string]int)
+	denormalized := 0
+
+	for _, text := range texts {
+		normalized := norm.NFC.String(text)
+		if normalized != text {
+			denormalized++
+		}
+
+		previous := rune(-1)
+		for _, char := range normalized {
+			if unicode.Is(unicode.Mn, char) && previous >= 0 {
+				unreachable[fmt.Sprintf("%s + %s", formatChar(uint16(previous)), formatChar(uint16(char)))]++
+			}
+			previous = char
+			if unicode.IsControl(char) || seen[char] {
+				continue
+			}
+			seen[char] = true
+			charset.Chars = append(charset.Chars, char)
+		}
+	}
+	sort.Slice(charset.Chars, func(i, j int) bool { return charset.Chars[i] < charset.Chars[j] })
+
+	if denormalized > 0 {
+		charset.Warnings = append(charset.Warnings, fmt.Sprintf("%d text(s) were not NFC normalized, composed characters are used instead", denormalized))
+	}
+	sequences := make([]string, 0, len(unreachable))
+	for sequence := range unreachable {
+		sequences = append(sequences, sequence)
+	}
+	sort.Strings(sequences)
+	for _, sequence := range sequences {
+		charset.Warnings = append(charset.Warnings, fmt.Sprintf("%s has no precomposed character, the mark can only be drawn as a zero width glyph over the base (%d time(s))", sequence, unreachable[sequence]))
+	}
+
+	return charset
+}
+
+// MSBT files start with a 32 byte header followed by 16 byte aligned sections.
+// The messages are in the TXT2 section as null terminated strings with inline
+// control tags (0x0E group type size params, 0x0F group type) that are not
+// part of the text.
+func decodeMSBTMessages(raw []byte) (messages []string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("can't read msbt: %v", r)
+		}
+	}()
+
+	if len(raw) < 0x20 || string(raw[0:8]) != "MsgStdBn" {
+		return nil, fmt.Errorf("not an msbt file")
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if raw[8] == 0xFF && raw[9] == 0xFE {
+		order = binary.LittleEndian
+	}
+	utf16Text := raw[0x0C] == 1
+	sectionCount := int(order.Uint16(raw[0x0E:0x10]))
+
+	pos := 0x20
+	for i := 0; i < sectionCount; i++ {
+		magic := string(raw[pos : pos+4])
+		size := int(order.Uint32(raw[pos+4 : pos+8]))
+		data := raw[pos+16 : pos+16+size]
+		if magic == "TXT2" {
+			count := int(order.Uint32(data[0:4]))
+			for j := 0; j < count; j++ {
+				start := int(order.Uint32(data[4+j*4 : 8+j*4]))
+				end := size
+				if j+1 < count {
+					end = int(order.Uint32(data[8+j*4 : 12+j*4]))
+				}
+				messages = append(messages, decodeMSBTMessage(data[start:end], order, utf16Text))
+			}
+		}
+		pos += 16 + size
+		pos += paddingToNext16ByteBoundary(pos)
+	}
+
+	return messages, nil
+}
+
+func decodeMSBTMessage(raw []byte, order binary.ByteOrder, utf16Text bool) string {
+	if !utf16Text {
+		return strings.TrimRight(string(raw), "\x00")
+	}
+
+	units := make([]uint16, 0, len(raw)/2)
+	for i := 0; i+1 < len(raw); i += 2 {
+		unit := order.Uint16(raw[i : i+2])
+		switch unit {
+		case 0x0E:
+			// group, type, parameter size and the parameters
+			paramSize := int(order.Uint16(raw[i+6 : i+8]))
+			i += 6 + paramSize
+			continue
+		case 0x0F:
+			i += 4
+			continue
+		case 0x00:
+			return string(utf16.Decode(units))
+		}
+		units = append(units, unit)
+	}
+
+	return string(utf16.Decode(units))
+}
+
+func paddingToNext16ByteBoundary(pos int) int {
+	return (16 - pos%16) % 16
+}
+
+// Lists the characters of a charset that the font can't draw
+func (b *BFFNT) missingChars(charset Charset) []rune {
+	missing := make([]rune, 0)
+	for _, char := range charset.Chars {
+		if _, found := b.CharIndex(char); !found {
+			missing = append(missing, char)
+		}
+	}
+
+	return missing
+}
+
+func coverageCommand(args []string) {
+	flags := newCommandFlagSet("coverage", "[flags] font.bffnt charset.txt|messages.msbt ...")
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		exitWithUsage(flags)
+	}
+
+	bffnt := flags.readBffnt(flags.Arg(0))
+	charset := readCharset(flags.Args()[1:])
+	for _, warning := range charset.Warnings {
+		fmt.Println("warning:", warning)
+	}
+
+	missing := bffnt.missingChars(charset)
+	for _, char := range missing {
+		fmt.Println("missing", formatChar(uint16(char)))
+	}
+	fmt.Printf("%d of %d character(s) are mapped\n", len(charset.Chars)-len(missing), len(charset.Chars))
+	if len(missing) > 0 {
+		exit(1)
+	}
+}
+
+// Text a dump of the game's text comes as, everything else in an extracted
+// archive (layouts, textures, ...) is skipped
+var charsetFileExts = map[string]bool{".msbt": true, ".txt": true}
+
+// Expands directories into every msbt and txt file below them. Files given
+// directly are always read. Sorted so the output doesn't depend on the order
+// the file system lists them in.
+func charsetFiles(paths []string) []string {
+	files := make([]string, 0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		handleErr(err)
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && charsetFileExts[strings.ToLower(filepath.Ext(file))] {
+				files = append(files, file)
+			}
+			return nil
+		})
+		handleErr(err)
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+// The characters as a text file readCharset reads back to the same charset,
+// 64 characters to a line so it stays readable
+func formatCharsetText(chars []rune) string {
+	var sb strings.Builder
+	for i, char := range chars {
+		if i > 0 && i%64 == 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteRune(char)
+	}
+	sb.WriteString("\n")
+
+	return sb.String()
+}
+
+// One code point per line, for reading and diffing rather than feeding back in
+func formatCharsetCodepoints(chars []rune) string {
+	var sb strings.Builder
+	for _, char := range chars {
+		fmt.Fprintf(&sb, "%#U\n", char)
+	}
+
+	return sb.String()
+}
+
+// How many of the characters belong to every script, most used first. Marks
+// and punctuation shared by several scripts count as Common or Inherited.
+func charsetScripts(chars []rune) []string {
+	names := make([]string, 0, len(unicode.Scripts))
+	for name := range unicode.Scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	counts := make(map[string]int)
+	for _, char := range chars {
+		script := "Unknown"
+		for _, name := range names {
+			if unicode.Is(unicode.Scripts[name], char) {
+				script = name
+				break
+			}
+		}
+		counts[script]++
+	}
+
+	scripts := make([]string, 0, len(counts))
+	for script := range counts {
+		scripts = append(scripts, script)
+	}
+	sort.Slice(scripts, func(i, j int) bool {
+		if counts[scripts[i]] != counts[scripts[j]] {
+			return counts[scripts[i]] > counts[scripts[j]]
+		}
+		return scripts[i] < scripts[j]
+	})
+	for i, script := range scripts {
+		scripts[i] = fmt.Sprintf("%s %d", script, counts[script])
+	}
+
+	return scripts
+}
+
+func charsetCommand(args []string) {
+	flags := newCommandFlagSet("charset", "[flags] text-dump-dir|messages.msbt|text.txt ...")
+	output := flags.String("o", "", "write the characters to this file instead of stdout. The file works as a charset for coverage and extend configs")
+	codepoints := flags.Bool("codepoints", false, "write one U+XXXX code point per line instead of the characters themselves")
+	missingFrom := flags.String("missing", "", "only write the characters this bffnt can't draw yet")
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		exitWithUsage(flags)
+	}
+
+	files := charsetFiles(flags.Args())
+	if len(files) == 0 {
+		handleErr(fmt.Errorf("no msbt or txt files in %s", strings.Join(flags.Args(), ", ")))
+	}
+	charset := readCharset(files)
+	for _, warning := range charset.Warnings {
+		fmt.Fprintln(os.Stderr, "warning:", warning)
+	}
+
+	chars := charset.Chars
+	if *missingFrom != "" {
+		bffnt := flags.readBffnt(*missingFrom)
+		chars = bffnt.missingChars(charset)
+	}
+
+	formatted := formatCharsetText(chars)
+	if *codepoints {
+		formatted = formatCharsetCodepoints(chars)
+	}
+	// the summary goes to stderr so stdout can be redirected into a charset
+	summary := fmt.Sprintf("%d character(s) in %d file(s): %s", len(chars), len(files), strings.Join(charsetScripts(chars), ", "))
+	if *output == "" {
+		fmt.Print(formatted)
+		fmt.Fprintln(os.Stderr, summary)
+		return
+	}
+
+	handleErr(os.WriteFile(*output, []byte(formatted), 0644))
+	fmt.Println(summary)
+	fmt.Println("wrote the characters to", *output)
+}
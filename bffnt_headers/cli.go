@@ -0,0 +1,127 @@
+package bffnt_headers
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// Subcommands are picked by the first command line argument, e.g.
+// `bffnt export -format godot-fnt Normal_00.bffnt`. When the first argument is
+// not a known subcommand the default upscale run is used.
+var commands = map[string]func(args []string){
+	"addchars":     addCharsCommand,
+	"alterchar":    alterCharCommand,
+	"apply-patch":  applyPatchCommand,
+	"bench":        benchCommand,
+	"charset":      charsetCommand,
+	"compose":      composeCommand,
+	"coverage":     coverageCommand,
+	"diff":         diffCommand,
+	"explode":      explodeCommand,
+	"export":       exportCommand,
+	"extend":       extendCommand,
+	"faces":        fontFacesCommand,
+	"fit":          fitCommand,
+	"glyph":        glyphImageCommand,
+	"implode":      implodeCommand,
+	"ink-widths":   inkWidthsCommand,
+	"info":         infoCommand,
+	"kerncompare":  kerningCompareCommand,
+	"kerning":      kerningCommand,
+	"lint":         lintCommand,
+	"match-glyphs": glyphMatchCommand,
+	"measure":      measureCommand,
+	"nudge":        nudgeCommand,
+	"regress":      regressCommand,
+	"repair":       repairCommand,
+	"roundtrip":    roundtripCommand,
+	"set-format":   setFormatCommand,
+	"sheetdiff":    sheetDiffCommand,
+	"spaces":       spacesCommand,
+}
+
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	command, exists := commands[args[0]]
+	if !exists {
+		return false
+	}
+
+	command(args[1:])
+	return true
+}
+
+// A subcommand's flag set. The leftover and threads flags end up here
+// instead of in globals, the fonts the command decodes get them from newBffnt.
+type commandFlagSet struct {
+	*flag.FlagSet
+	leftoverPolicy string
+	threads        int
+}
+
+// Every subcommand gets its own flag set with the shared debug, leftover,
+// sheet cache, threads, png and profiling flags already registered.
+func newCommandFlagSet(name string, usage string) *commandFlagSet {
+	flags := &commandFlagSet{FlagSet: flag.NewFlagSet(name, flag.ExitOnError)}
+	debugFlag(flags.FlagSet)
+	leftoverPolicyFlag(flags.FlagSet, &flags.leftoverPolicy)
+	sheetCacheFlag(flags.FlagSet)
+	threadsFlag(flags.FlagSet, &flags.threads)
+	pngCompressionFlag(flags.FlagSet)
+	profilingFlags(flags.FlagSet)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: bffnt %s %s\n", name, usage)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+// An empty font decoding with the command's leftover policy and threads
+func (flags *commandFlagSet) newBffnt() BFFNT {
+	return BFFNT{LeftoverPolicy: flags.leftoverPolicy, Threads: flags.threads}
+}
+
+// Reads and decodes a bffnt file from disk
+func readBffnt(filename string) BFFNT {
+	var bffnt BFFNT
+	bffnt.readFile(filename)
+	return bffnt
+}
+
+// readBffnt with the command's leftover policy and threads
+func (flags *commandFlagSet) readBffnt(filename string) BFFNT {
+	bffnt := flags.newBffnt()
+	bffnt.readFile(filename)
+	return bffnt
+}
+
+func (b *BFFNT) readFile(filename string) {
+	raw, err := ioutil.ReadFile(filename)
+	handleErr(err)
+
+	b.Decode(raw)
+	for _, failure := range b.Validation().Failures {
+		fmt.Printf("warning: %s: %s\n", filename, failure)
+	}
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func exitWithUsage(flags *commandFlagSet) {
+	flags.Usage()
+	exit(2)
+}
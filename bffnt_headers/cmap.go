@@ -1,11 +1,11 @@
 package bffnt_headers
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 )
 
 // A single cmap contains information about a character's texture location in
@@ -26,6 +26,13 @@ type CMAP struct { //         Offset  Size  Description
 	// texture. Characters that have an index of MaxUint16 (65535) are to be ignored.
 	CharAscii []uint16
 	CharIndex []uint16
+
+	Leftovers []byte // non zero bytes after the map data, only kept with LEFTOVER_PRESERVE
+
+	validation     ValidationResult
+	lazy           lazySection
+	log            *Logger // the font's logger, see BFFNT.Log
+	leftoverPolicy string  // see BFFNT.LeftoverPolicy
 }
 
 type AsciiIndexPair struct {
@@ -38,19 +45,7 @@ func (cmap *CMAP) Decode(allRaw []byte, cmapOffset uint32) {
 	headerEnd := headerStart + CMAP_HEADER_SIZE
 	headerRaw := allRaw[headerStart:headerEnd]
 
-	assertEqual(CMAP_HEADER_SIZE, len(headerRaw))
-
-	cmap.MagicHeader = string(headerRaw[0:4])
-	cmap.SectionSize = binary.BigEndian.Uint32(headerRaw[4:8])
-	cmap.CodeBegin = binary.BigEndian.Uint16(headerRaw[8:10])
-	cmap.CodeEnd = binary.BigEndian.Uint16(headerRaw[10:12])
-	cmap.MappingMethod = binary.BigEndian.Uint16(headerRaw[12:14])
-	cmap.Reserved = binary.BigEndian.Uint16(headerRaw[14:16])
-	cmap.NextCMAPOffset = binary.BigEndian.Uint32(headerRaw[16:CMAP_HEADER_SIZE])
-
-	if Debug {
-		pprint(cmap)
-	}
+	cmap.DecodeHeader(headerRaw)
 
 	dataEnd := headerStart + int(cmap.SectionSize)
 	data := allRaw[headerEnd:dataEnd]
@@ -87,8 +82,14 @@ func (cmap *CMAP) Decode(allRaw []byte, cmapOffset uint32) {
 	// (CodeEnd - CodeStart + 1) amount of bytes after the header. Unused
 	// characters will have an index of MaxUint16 (65535).
 	case 1:
-		for i := cmap.CodeBegin; i <= cmap.CodeEnd; i++ {
-			charAsciiCode := i
+		// a table that is cut short is read as far as it goes, validate
+		// reports the missing entries
+		codeEnd := int(cmap.CodeEnd)
+		if tableSize := len(data) / 2; codeEnd-int(cmap.CodeBegin)+1 > tableSize {
+			codeEnd = int(cmap.CodeBegin) + tableSize - 1
+		}
+		for i := int(cmap.CodeBegin); i <= codeEnd; i++ {
+			charAsciiCode := uint16(i)
 			charIndex := binary.BigEndian.Uint16(data[dataPos : dataPos+2])
 			asciiSlice = append(asciiSlice, charAsciiCode)
 			indexSlice = append(indexSlice, charIndex)
@@ -129,22 +130,37 @@ func (cmap *CMAP) Decode(allRaw []byte, cmapOffset uint32) {
 	cmap.CharAscii = asciiSlice
 	cmap.CharIndex = indexSlice
 	assertEqual(len(cmap.CharAscii), len(cmap.CharIndex))
+	cmap.validation = cmap.validate()
 
 	leftoverData := data[dataPos:]
-	verifyLeftoverBytes(leftoverData)
+	cmap.Leftovers = verifyLeftoverBytes(cmap.log, cmap.leftoverPolicy, "CMAP", headerEnd+dataPos, leftoverData)
 
-	if Debug {
+	if cmap.log.debugging() {
 		dataPosEnd := headerEnd + dataPos
-		fmt.Println(dataPosEnd, headerEnd)
-		fmt.Printf("Read section total of %d bytes\n", dataPosEnd-headerStart)
-		fmt.Println("Byte offsets start(inclusive) to end(exclusive)================")
-		fmt.Printf("header           %-8d to  %d\n", headerStart, headerEnd)
-		fmt.Printf("data calculated  %-8d to  %d\n", headerEnd, dataPosEnd)
-		fmt.Printf("leftover bytes   %-8d to  %d\n", dataPosEnd, dataPosEnd+len(leftoverData))
-		fmt.Println()
+		cmap.log.debugln(dataPosEnd, headerEnd)
+		cmap.log.debugf("Read section total of %d bytes\n", dataPosEnd-headerStart)
+		cmap.log.debugln("Byte offsets start(inclusive) to end(exclusive)================")
+		cmap.log.debugf("header           %-8d to  %d\n", headerStart, headerEnd)
+		cmap.log.debugf("data calculated  %-8d to  %d\n", headerEnd, dataPosEnd)
+		cmap.log.debugf("leftover bytes   %-8d to  %d\n", dataPosEnd, dataPosEnd+len(leftoverData))
+		cmap.log.debugln()
 	}
 }
 
+func (cmap *CMAP) DecodeHeader(headerRaw []byte) {
+	assertEqual(CMAP_HEADER_SIZE, len(headerRaw))
+
+	cmap.MagicHeader = string(headerRaw[0:4])
+	cmap.SectionSize = binary.BigEndian.Uint32(headerRaw[4:8])
+	cmap.CodeBegin = binary.BigEndian.Uint16(headerRaw[8:10])
+	cmap.CodeEnd = binary.BigEndian.Uint16(headerRaw[10:12])
+	cmap.MappingMethod = binary.BigEndian.Uint16(headerRaw[12:14])
+	cmap.Reserved = binary.BigEndian.Uint16(headerRaw[14:16])
+	cmap.NextCMAPOffset = binary.BigEndian.Uint32(headerRaw[16:CMAP_HEADER_SIZE])
+
+	cmap.log.debugJSON(cmap)
+}
+
 func DecodeCMAPs(allRaw []byte, startingOffset uint32) []CMAP {
 	res := make([]CMAP, 0)
 
@@ -163,63 +179,66 @@ func DecodeCMAPs(allRaw []byte, startingOffset uint32) []CMAP {
 // Encodes a single cmap.
 // The start offset is either FINF.CMAPOffset or the last cmap's NextCMAPOffset
 func (cmap *CMAP) Encode(startOffset uint32, isLastCMAP bool) []byte {
-	var cmapDataBuf bytes.Buffer
-	dataWriter := bufio.NewWriter(&cmapDataBuf)
-
-	// encode cmap data. We need to know the length of the raw glyph data to
-	// know the section size
+	// The size of the map data only depends on the mapping method, that's
+	// all that's needed to know the section size up front
+	dataSize := len(cmap.Leftovers)
 	switch cmap.MappingMethod {
 	case 0:
-		binaryWrite(dataWriter, cmap.CharacterOffset)
+		dataSize += 2
 	case 1:
-		for i, _ := range cmap.CharIndex {
-			binaryWrite(dataWriter, cmap.CharIndex[i])
-		}
+		dataSize += 2 * len(cmap.CharIndex)
 	case 2:
 		// first uint16 is amount of (charAscii, charIndex) pairs
-		binaryWrite(dataWriter, cmap.CharacterCount)
-		for i, _ := range cmap.CharIndex {
-			binaryWrite(dataWriter, cmap.CharAscii[i])
-			binaryWrite(dataWriter, cmap.CharIndex[i])
-		}
+		checkedUint16("CMAP scan entry count", int64(len(cmap.CharIndex)))
+		dataSize += 2 + 4*len(cmap.CharIndex)
 	}
-	dataWriter.Flush()
-	padToNext4ByteBoundary(dataWriter, &cmapDataBuf, int(startOffset))
+	padding := paddingToNext4ByteBoundary(int(startOffset) - 8 + dataSize)
 
-	cmapData := cmapDataBuf.Bytes()
 	// Calculate and edit the header information
-	cmap.SectionSize = uint32(CMAP_HEADER_SIZE + len(cmapData))
+	cmap.SectionSize = checkedUint32("CMAP section size", int64(CMAP_HEADER_SIZE)+int64(dataSize+padding))
 	// Assume the startOffset already had +8 added to it to skip the magic header
-	cmap.NextCMAPOffset = startOffset + cmap.SectionSize
+	cmap.NextCMAPOffset = checkedUint32("next CMAP offset", int64(startOffset)+int64(cmap.SectionSize))
 
 	if isLastCMAP {
 		// terminate cmap list by setting offset to 0
 		cmap.NextCMAPOffset = 0
 	}
 
-	var buf bytes.Buffer
-	w := bufio.NewWriter(&buf)
-
-	// Write raw data of the header and data
-	_, _ = w.Write([]byte(cmap.MagicHeader))
-	binaryWrite(w, cmap.SectionSize)
-	binaryWrite(w, cmap.CodeBegin)
-	binaryWrite(w, cmap.CodeEnd)
-	binaryWrite(w, cmap.MappingMethod)
-	binaryWrite(w, cmap.Reserved)
-	binaryWrite(w, cmap.NextCMAPOffset)
-	_, _ = w.Write(cmapData)
-	w.Flush()
+	w := newSectionWriter("CMAP", int(cmap.SectionSize))
+	w.magic(cmap.MagicHeader)
+	w.u32(cmap.SectionSize)
+	w.u16(cmap.CodeBegin)
+	w.u16(cmap.CodeEnd)
+	w.u16(cmap.MappingMethod)
+	w.u16(cmap.Reserved)
+	w.u32(cmap.NextCMAPOffset)
+	switch cmap.MappingMethod {
+	case 0:
+		w.u16(cmap.CharacterOffset)
+	case 1:
+		for i := range cmap.CharIndex {
+			w.u16(cmap.CharIndex[i])
+		}
+	case 2:
+		w.u16(cmap.CharacterCount)
+		for i := range cmap.CharIndex {
+			w.u16(cmap.CharAscii[i])
+			w.u16(cmap.CharIndex[i])
+		}
+	}
+	w.raw(cmap.Leftovers)
+	w.zeros(padding)
+	cmapBytes := w.mustBytes()
 
-	totalBytesWithPadding := int(startOffset) + len(buf.Bytes())
+	totalBytesWithPadding := int(startOffset) + len(cmapBytes)
 	check4ByteBoundary(totalBytesWithPadding)
-	return buf.Bytes()
+	return cmapBytes
 }
 
 func EncodeCMAPs(CMAPs []CMAP, finfCMAPOffset int) []byte {
 	res := make([]byte, 0)
 
-	offset := uint32(finfCMAPOffset)
+	offset := checkedUint32("CMAP offset", int64(finfCMAPOffset))
 	for i, currentCMAP := range CMAPs {
 		isLast := false
 		if i == len(CMAPs)-1 {
@@ -246,3 +265,172 @@ func totalCmapSectionSize(cmapList []CMAP) (totalSectionSize int) {
 
 	return totalSectionSize
 }
+
+// The game looks characters up by their code. Table maps have an entry for
+// every code in the range and scan maps are binary searched, so their codes
+// have to be sorted and within the range.
+func (cmap *CMAP) validate() ValidationResult {
+	var res ValidationResult
+	name := fmt.Sprintf("CMAP %s..%s", formatChar(cmap.CodeBegin), formatChar(cmap.CodeEnd))
+	if cmap.CodeEnd < cmap.CodeBegin {
+		res.Failures = append(res.Failures, fmt.Sprintf("%s: code range ends before it begins", name))
+		return res
+	}
+
+	switch cmap.MappingMethod {
+	case 1:
+		res.assertEqual(name+" table entries", int(cmap.CodeEnd)-int(cmap.CodeBegin)+1, len(cmap.CharIndex))
+	case 2:
+		res.assertEqual(name+" character count", int(cmap.CharacterCount), len(cmap.CharAscii))
+		for i, code := range cmap.CharAscii {
+			if code < cmap.CodeBegin || code > cmap.CodeEnd {
+				res.Failures = append(res.Failures, fmt.Sprintf("%s: %s is outside the code range", name, formatChar(code)))
+			}
+			if i > 0 && code <= cmap.CharAscii[i-1] {
+				res.Failures = append(res.Failures, fmt.Sprintf("%s: %s comes after %s, scan entries have to be sorted", name, formatChar(code), formatChar(cmap.CharAscii[i-1])))
+			}
+		}
+	}
+
+	return res
+}
+
+// Sorts the entries of scan maps by code. Returns true if they weren't sorted.
+func (cmap *CMAP) sortScanEntries() bool {
+	if cmap.MappingMethod != 2 || sort.SliceIsSorted(cmap.CharAscii, func(i, j int) bool { return cmap.CharAscii[i] < cmap.CharAscii[j] }) {
+		return false
+	}
+
+	pairs := make([]AsciiIndexPair, len(cmap.CharAscii))
+	for i := range cmap.CharAscii {
+		pairs[i] = AsciiIndexPair{cmap.CharAscii[i], cmap.CharIndex[i]}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].CharAscii < pairs[j].CharAscii })
+	for i, pair := range pairs {
+		cmap.CharAscii[i] = pair.CharAscii
+		cmap.CharIndex[i] = pair.CharIndex
+	}
+
+	return true
+}
+
+// One mapped character, see buildCharIndex
+type charIndexEntry struct {
+	char  uint16
+	index uint16
+}
+
+// Every mapped character of every CMAP sorted by character, so a lookup is a
+// single binary search no matter how many CMAPs there are or how they map.
+// The first CMAP that maps a character wins like it does in game. Built on
+// the first lookup together with the reverse map of RunesForIndex. Anything
+// that changes the CMAPs afterwards has to call invalidateCharIndex.
+func (b *BFFNT) buildCharIndex() {
+	b.loadCMAPs()
+	entries := make([]charIndexEntry, 0)
+	for _, cmap := range b.CMAPs {
+		for i, char := range cmap.CharAscii {
+			if i < len(cmap.CharIndex) && cmap.CharIndex[i] != 65535 {
+				entries = append(entries, charIndexEntry{char, cmap.CharIndex[i]})
+			}
+		}
+	}
+	// stable so the first CMAP's mapping comes first
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].char < entries[j].char })
+
+	b.charIndexes = make([]charIndexEntry, 0, len(entries))
+	for _, entry := range entries {
+		if last := len(b.charIndexes) - 1; last >= 0 && b.charIndexes[last].char == entry.char {
+			continue
+		}
+		b.charIndexes = append(b.charIndexes, entry)
+	}
+
+	// the index is sorted by character so every glyph's runes are sorted too
+	b.runesByIndex = make(map[uint16][]rune)
+	for _, entry := range b.charIndexes {
+		b.runesByIndex[entry.index] = append(b.runesByIndex[entry.index], rune(entry.char))
+	}
+}
+
+func (b *BFFNT) invalidateCharIndex() {
+	b.charIndexes = nil
+	b.runesByIndex = nil
+}
+
+// Every character that maps to a glyph index in ascending order, nil for
+// glyphs no character maps to. A glyph can be shared, e.g. full width and
+// half width forms of the same character. Characters shadowed by an earlier
+// CMAP don't count since the game never draws them with this glyph. The
+// returned slice belongs to the font, don't modify it.
+func (b *BFFNT) RunesForIndex(index uint16) []rune {
+	if b.charIndexes == nil {
+		b.buildCharIndex()
+	}
+	return b.runesByIndex[index]
+}
+
+// e.g. "glyph 34 (U+0041 'A')", for labelling cells in reports
+func (b *BFFNT) glyphLabel(index int) string {
+	runes := b.RunesForIndex(uint16(index))
+	if len(runes) == 0 {
+		return fmt.Sprintf("glyph %d (unmapped)", index)
+	}
+	chars := make([]string, 0, len(runes))
+	for _, char := range runes {
+		chars = append(chars, formatChar(uint16(char)))
+	}
+	return fmt.Sprintf("glyph %d (%s)", index, strings.Join(chars, ", "))
+}
+
+// Glyph index of a character. The first CMAP that maps it wins like it does
+// in game.
+func (b *BFFNT) CharIndex(char rune) (index uint16, found bool) {
+	if char < 0 || char > 0xFFFF {
+		return 0, false
+	}
+	if b.charIndexes == nil {
+		b.buildCharIndex()
+	}
+
+	code := uint16(char)
+	position := sort.Search(len(b.charIndexes), func(i int) bool { return b.charIndexes[i].char >= code })
+	if position == len(b.charIndexes) || b.charIndexes[position].char != code {
+		return 0, false
+	}
+	return b.charIndexes[position].index, true
+}
+
+func (b *BFFNT) isMapped(code uint16) bool {
+	_, found := b.CharIndex(rune(code))
+	return found
+}
+
+// Returns how many scan CMAPs had to be sorted
+func (b *BFFNT) SortScanCMAPs() int {
+	sorted := 0
+	for i := range b.CMAPs {
+		if b.CMAPs[i].sortScanEntries() {
+			sorted++
+		}
+	}
+
+	return sorted
+}
+
+// Removes the character at position from the cmap. Direct maps can't skip a
+// character so they are turned into a table map first.
+func (cmap *CMAP) unmapChar(position int) {
+	switch cmap.MappingMethod {
+	case 0:
+		cmap.MappingMethod = 1
+		cmap.CharacterOffset = 0
+		cmap.CharIndex[position] = 65535
+	case 1:
+		cmap.CharIndex[position] = 65535
+	case 2:
+		cmap.CharAscii = append(cmap.CharAscii[:position], cmap.CharAscii[position+1:]...)
+		cmap.CharIndex = append(cmap.CharIndex[:position], cmap.CharIndex[position+1:]...)
+		cmap.CharacterCount--
+	}
+}
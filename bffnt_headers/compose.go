@@ -0,0 +1,76 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Puts sheet images into a font, e.g. the blank template an upscale writes
+// together with a sheet that was painted over or upscaled by another tool.
+// There has to be an image for every sheet of the font, at the sheet size.
+// The glyphs are read from the alpha channel and encoded in the font's sheet
+// format when the font is written.
+func (tglp *TGLP) composeSheets(sheetFiles []string) {
+	if !knownImageFormat(tglp.SheetImageFormat) {
+		handleErr(fmt.Errorf("sheets in %s can't be encoded, only %v", imageFormatName(tglp.SheetImageFormat), imageFormatNames()))
+	}
+	if len(sheetFiles) != int(tglp.NumOfSheets) {
+		handleErr(fmt.Errorf("the font has %d sheet(s), got %d image(s)", tglp.NumOfSheets, len(sheetFiles)))
+	}
+
+	sheets := make([]image.NRGBA, 0, len(sheetFiles))
+	for _, sheetFile := range sheetFiles {
+		img, err := imaging.Open(sheetFile)
+		handleErr(err)
+		if img.Bounds().Dx() != int(tglp.SheetWidth) || img.Bounds().Dy() != int(tglp.SheetHeight) {
+			handleErr(fmt.Errorf("%s is %dx%d, the sheets are %dx%d (%d columns and %d rows of %dx%d cells)",
+				sheetFile, img.Bounds().Dx(), img.Bounds().Dy(), tglp.SheetWidth, tglp.SheetHeight,
+				tglp.NumOfColumns, tglp.NumOfRows, tglp.CellWidth+1, tglp.CellHeight+1))
+		}
+		sheet := imaging.Clone(img)
+		if opaque(sheet) {
+			fmt.Printf("warning: %s has no transparency, the glyphs are read from its alpha channel\n", sheetFile)
+		}
+		sheets = append(sheets, *sheet)
+	}
+	tglp.SheetData = sheets
+	// the template's blank sheets would be copied otherwise
+	tglp.AllSheetData = nil
+	tglp.raw = nil
+}
+
+// The last step of the template workflow: the upscaled sheet goes into the
+// template without having to open it in switch toolbox
+func composeCommand(args []string) {
+	flags := newCommandFlagSet("compose", "[flags] template.bffnt sheet.png [sheet.png ...]")
+	output := flags.String("o", "", "font to write, defaults to the game's name for the font next to the template, e.g. Normal_00.bffnt")
+	game := flags.String("game", "botw", "game profile naming the font: "+strings.Join(gameProfileNames(), ", ")+" or a yaml file")
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		exitWithUsage(flags)
+	}
+	if *output == "" {
+		template := flags.Arg(0)
+		if fontName, found := templateFontName(template); found {
+			*output = filepath.Join(filepath.Dir(template), readGameProfile(*game).fontFileName(fontName))
+		} else {
+			*output = strings.TrimSuffix(template, filepath.Ext(template)) + "_composed" + filepath.Ext(template)
+		}
+	}
+
+	bffnt := flags.readBffnt(flags.Arg(0))
+	bffnt.TGLP.composeSheets(flags.Args()[1:])
+	encoded := bffnt.Encode()
+	for _, failure := range bffnt.Validation().Failures {
+		fmt.Println("warning:", failure)
+	}
+	handleErr(bffnt.VerifyEncoded(encoded))
+	handleErr(os.WriteFile(*output, encoded, 0644))
+	fmt.Printf("wrote %s with %d %s sheet(s)\n", *output, bffnt.TGLP.NumOfSheets, imageFormatName(bffnt.TGLP.SheetImageFormat))
+}
@@ -0,0 +1,113 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Other games' font variants add sections of their own. Code that knows such
+// a section can register a decoder for its magic, the section is then decoded
+// by the walk like the built-in ones and encoded after the kerning table, at
+// whatever offset it ends up at. Sections nobody registered are still kept as
+// an UnknownSection, both in the order they were in.
+//
+//	bffnt_headers.RegisterSection("GLGR", func(raw []byte, startOffset uint32) (bffnt_headers.CustomSection, error) {
+//		return decodeGlyphGroups(raw)
+//	})
+
+// A decoded section. Encode gets the offset of the section's data, 8 bytes
+// after its magic like the offsets in FINF, and returns the whole section
+// including its header. The size in the header has to be the size of what's
+// returned or the walk wouldn't find the next section.
+type CustomSection interface {
+	Magic() string
+	Present() bool
+	Encode(startOffset uint32) []byte
+}
+
+// raw is the whole section including its header, startOffset where its data
+// starts in the file
+type SectionDecoder func(raw []byte, startOffset uint32) (CustomSection, error)
+
+var customSectionDecoders = make(map[string]SectionDecoder)
+
+// Registers the decoder of sections with magic. Panics for magics that aren't
+// 4 bytes, that are built in or that already have a decoder, two decoders for
+// the same magic would silently replace each other.
+func RegisterSection(magic string, decode SectionDecoder) {
+	if len(magic) != 4 {
+		panic(fmt.Sprintf("section magic %q has to be 4 bytes", magic))
+	}
+	if _, builtIn := sectionParsers[magic]; builtIn || magic == FFNT_MAGIC_HEADER {
+		panic(fmt.Sprintf("%s is a built-in section", magic))
+	}
+	if _, exists := customSectionDecoders[magic]; exists {
+		panic(fmt.Sprintf("%s section already has a decoder", magic))
+	}
+	customSectionDecoders[magic] = decode
+}
+
+func isCustomSection(magic string) bool {
+	_, registered := customSectionDecoders[magic]
+	return registered
+}
+
+// Custom sections are always decoded completely, there's no lazy decoding
+// for them
+func parseCustomSection(b *BFFNT, raw []byte, header sectionHeader, lazy bool) int {
+	section, err := customSectionDecoders[header.MagicHeader](raw[header.Start:header.end()], uint32(header.Start+8))
+	handleErr(err)
+	b.ExtraSections = append(b.ExtraSections, section)
+	return header.Size
+}
+
+// Decodes a section on its own, e.g. one that was exploded into a file.
+// Sections without a registered decoder are kept as they are.
+func (b *BFFNT) addRawSection(raw []byte, startOffset uint32) error {
+	if len(raw) < 8 {
+		return fmt.Errorf("%d bytes are too short to be a section", len(raw))
+	}
+	magic := string(raw[:4])
+	if decode, registered := customSectionDecoders[magic]; registered {
+		section, err := decode(raw, startOffset)
+		if err != nil {
+			return err
+		}
+		b.ExtraSections = append(b.ExtraSections, section)
+		return nil
+	}
+	b.ExtraSections = append(b.ExtraSections, &UnknownSection{MagicHeader: magic, Raw: raw})
+
+	return nil
+}
+
+// Wraps a custom section so what it encodes is checked, a wrong magic or size
+// would break the walk of the file it's written to
+type checkedCustomSection struct {
+	CustomSection
+}
+
+func (section checkedCustomSection) Encode(startOffset uint32) []byte {
+	raw := section.CustomSection.Encode(startOffset)
+	magic := section.Magic()
+	if len(raw) < 8 {
+		handleErr(fmt.Errorf("%s section encoded to %d bytes, it needs at least its header", magic, len(raw)))
+	}
+	if string(raw[:4]) != magic {
+		handleErr(fmt.Errorf("%s section encoded with the magic %q", magic, raw[:4]))
+	}
+	if size := int(binary.BigEndian.Uint32(raw[4:8])); size != len(raw) {
+		handleErr(fmt.Errorf("%s section has a size of %d in its header but encoded to %d bytes", magic, size, len(raw)))
+	}
+
+	return raw
+}
+
+// Custom sections are checked when they're encoded, unknown ones are written
+// back as they were decoded
+func checkedSection(section CustomSection) CustomSection {
+	if _, unknown := section.(*UnknownSection); unknown {
+		return section
+	}
+	return checkedCustomSection{section}
+}
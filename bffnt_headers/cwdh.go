@@ -1,8 +1,6 @@
 package bffnt_headers
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"math"
@@ -16,6 +14,13 @@ type CWDH struct { //        Offset  Size  Description
 	NextCWDHOffset uint32 // 0x0C    0x04  Next CWDH Offset
 	Glyphs         []glyphInfo
 
+	Leftovers []byte // non zero bytes after the glyph data, only kept with LEFTOVER_PRESERVE
+
+	validation     ValidationResult
+	lazy           lazySection
+	log            *Logger // the font's logger, see BFFNT.Log
+	leftoverPolicy string  // see BFFNT.LeftoverPolicy
+
 	// Data until the end of the section comes in tuples of 3 bytes
 	// LeftWidth   uint8  // 0x10    0x04  Char Widths (3 bytes: Left, Glyph Width, Char Width)
 	// GlyphWidth  uint8
@@ -29,11 +34,46 @@ type glyphInfo struct {
 }
 
 func (cwdh *CWDH) Upscale(scale float64) {
-	for i, _ := range cwdh.Glyphs {
-		cwdh.Glyphs[i].LeftWidth = int8(math.Ceil(float64(cwdh.Glyphs[i].LeftWidth) * scale))
-		cwdh.Glyphs[i].GlyphWidth = uint8(math.Ceil(float64(cwdh.Glyphs[i].GlyphWidth) * scale))
-		cwdh.Glyphs[i].CharWidth = uint8(math.Ceil(float64(cwdh.Glyphs[i].CharWidth) * scale))
+	var clamped clampedMetrics
+	for i := range cwdh.Glyphs {
+		glyph := &cwdh.Glyphs[i]
+		index := int(cwdh.StartIndex) + i
+		leftWidth := int(scaleMetric(float64(glyph.LeftWidth), scale))
+		glyphWidth := int(math.Ceil(float64(glyph.GlyphWidth) * scale))
+		charWidth := int(scaleMetric(float64(glyph.CharWidth), scale))
+
+		var fits bool
+		if glyph.LeftWidth, fits = clampToLeftWidth(leftWidth); !fits {
+			clamped.add("glyph %d LeftWidth %d", index, leftWidth)
+		}
+		if glyph.GlyphWidth, fits = clampToGlyphWidth(glyphWidth); !fits {
+			clamped.add("glyph %d GlyphWidth %d", index, glyphWidth)
+		}
+		if glyph.CharWidth, fits = clampToCharWidth(charWidth); !fits {
+			clamped.add("glyph %d CharWidth %d", index, charWidth)
+		}
+	}
+	warnClampedMetrics(fmt.Sprintf("after scaling the widths by %v", scale), clamped)
+}
+
+// Adds amount to every glyph's CharWidth. CharWidth can't go below 0 or above
+// 255 (MaxUint8) so the result is clamped, the clamped ones are returned.
+// Zero width glyphs are combining marks drawn over the previous character,
+// they stay zero width and are counted in skipped.
+func (cwdh *CWDH) AdjustCharWidths(amount int) (clamped clampedMetrics, skipped int) {
+	for i := range cwdh.Glyphs {
+		if cwdh.Glyphs[i].CharWidth == 0 {
+			skipped++
+			continue
+		}
+		charWidth := int(cwdh.Glyphs[i].CharWidth) + amount
+		var fits bool
+		if cwdh.Glyphs[i].CharWidth, fits = clampToCharWidth(charWidth); !fits {
+			clamped.add("glyph %d CharWidth %d", int(cwdh.StartIndex)+i, charWidth)
+		}
 	}
+
+	return clamped, skipped
 }
 
 func (cwdh *CWDH) Decode(raw []byte, cwdhOffset uint32) {
@@ -63,18 +103,18 @@ func (cwdh *CWDH) Decode(raw []byte, cwdhOffset uint32) {
 	cwdh.Glyphs = resultGlyphs
 
 	leftoverData := data[dataPos:]
-	verifyLeftoverBytes(leftoverData)
+	cwdh.Leftovers = verifyLeftoverBytes(cwdh.log, cwdh.leftoverPolicy, "CWDH", dataStart+dataPos, leftoverData)
 
-	assertEqual(int(cwdh.EndIndex+1), len(cwdh.Glyphs))
+	cwdh.validation.assertEqual("CWDH glyph count", int(cwdh.EndIndex+1), len(cwdh.Glyphs))
 
-	if Debug {
+	if cwdh.log.debugging() {
 		dataEnd := dataStart + dataPos
-		fmt.Printf("Read section total of %d bytes\n", dataEnd-headerStart)
-		fmt.Println("Byte offsets start(inclusive) to end(exclusive)================")
-		fmt.Printf("header           %-8d to  %d\n", headerStart, headerEnd)
-		fmt.Printf("data calculated  %-8d to  %d\n", dataStart, dataEnd)
-		fmt.Printf("leftover bytes   %-8d to  %d\n", dataEnd, dataEnd+len(leftoverData))
-		fmt.Println()
+		cwdh.log.debugf("Read section total of %d bytes\n", dataEnd-headerStart)
+		cwdh.log.debugln("Byte offsets start(inclusive) to end(exclusive)================")
+		cwdh.log.debugf("header           %-8d to  %d\n", headerStart, headerEnd)
+		cwdh.log.debugf("data calculated  %-8d to  %d\n", dataStart, dataEnd)
+		cwdh.log.debugf("leftover bytes   %-8d to  %d\n", dataEnd, dataEnd+len(leftoverData))
+		cwdh.log.debugln()
 	}
 }
 
@@ -87,9 +127,7 @@ func (cwdh *CWDH) DecodeHeader(raw []byte) {
 	cwdh.EndIndex = binary.BigEndian.Uint16(raw[10:12])
 	cwdh.NextCWDHOffset = binary.BigEndian.Uint32(raw[12:CWDH_HEADER_SIZE])
 
-	if Debug {
-		pprint(cwdh)
-	}
+	cwdh.log.debugJSON(cwdh)
 }
 
 func DecodeCWDHs(allRaw []byte, startingOffset uint32) []CWDH {
@@ -110,52 +148,47 @@ func DecodeCWDHs(allRaw []byte, startingOffset uint32) []CWDH {
 // Encodes a single cwdh.
 // The start offset passed is either the starting finf.cwdhOffset or the last cwdh's NextCWDHOffset
 func (cwdh *CWDH) Encode(startOffset uint32, isLastCWDH bool) []byte {
-	var dataBuf bytes.Buffer
-	dataWriter := bufio.NewWriter(&dataBuf)
+	// every glyph is 3 bytes, that's all that's needed to know the section
+	// size up front
+	dataSize := len(cwdh.Glyphs)*3 + len(cwdh.Leftovers)
+	padding := paddingToNext4ByteBoundary(int(startOffset) - 8 + dataSize)
 
-	// encode cwdh data. We need to know the length of the raw glyph data to
-	// know the section size
-	for _, glyph := range cwdh.Glyphs {
-		binaryWrite(dataWriter, glyph.LeftWidth)
-		binaryWrite(dataWriter, glyph.GlyphWidth)
-		binaryWrite(dataWriter, glyph.CharWidth)
-	}
-	dataWriter.Flush()
-
-	padToNext4ByteBoundary(dataWriter, &dataBuf, int(startOffset))
-
-	glyphData := dataBuf.Bytes()
 	// Calculate and edit the header information
-	cwdh.SectionSize = uint32(CWDH_HEADER_SIZE + len(glyphData))
+	cwdh.SectionSize = checkedUint32("CWDH section size", int64(CWDH_HEADER_SIZE)+int64(dataSize+padding))
 	cwdh.StartIndex = uint16(0)
-	cwdh.EndIndex = uint16(len(cwdh.Glyphs) - 1)
+	if len(cwdh.Glyphs) > 0 {
+		cwdh.EndIndex = checkedUint16("CWDH end index", int64(len(cwdh.Glyphs))-1)
+	} else {
+		cwdh.EndIndex = uint16(len(cwdh.Glyphs) - 1)
+	}
 	if isLastCWDH {
 		cwdh.NextCWDHOffset = 0
 	} else {
 		// CMAP is a recursive structure, the +8 bytes should have been added
 		// already to make calculations easier
-		cwdh.NextCWDHOffset = uint32(int(startOffset) + CWDH_HEADER_SIZE + len(glyphData))
+		cwdh.NextCWDHOffset = checkedUint32("next CWDH offset", int64(startOffset)+int64(cwdh.SectionSize))
 	}
 
-	var buf bytes.Buffer
-	w := bufio.NewWriter(&buf)
-
-	// Write raw data of the header and data
-	_, _ = w.Write([]byte(cwdh.MagicHeader))
-	binaryWrite(w, cwdh.SectionSize)
-	binaryWrite(w, cwdh.StartIndex)
-	binaryWrite(w, cwdh.EndIndex)
-	binaryWrite(w, cwdh.NextCWDHOffset)
-	_, _ = w.Write(glyphData)
-	w.Flush()
-
-	return buf.Bytes()
+	w := newSectionWriter("CWDH", int(cwdh.SectionSize))
+	w.magic(cwdh.MagicHeader)
+	w.u32(cwdh.SectionSize)
+	w.u16(cwdh.StartIndex)
+	w.u16(cwdh.EndIndex)
+	w.u32(cwdh.NextCWDHOffset)
+	for _, glyph := range cwdh.Glyphs {
+		w.i8(glyph.LeftWidth)
+		w.u8(glyph.GlyphWidth)
+		w.u8(glyph.CharWidth)
+	}
+	w.raw(cwdh.Leftovers)
+	w.zeros(padding)
+	return w.mustBytes()
 }
 
 func EncodeCWDHs(CWDHs []CWDH, finfCWDHOffset int) []byte {
 	res := make([]byte, 0)
 
-	offset := uint32(finfCWDHOffset)
+	offset := checkedUint32("CWDH offset", int64(finfCWDHOffset))
 	for i, currentCWDH := range CWDHs {
 		isLast := false
 		if i == len(CWDHs)-1 {
@@ -181,3 +214,15 @@ func totalCwdhSectionSize(cwdhList []CWDH) (totalSectionSize int) {
 
 	return totalSectionSize
 }
+
+// Finds the width information of a glyph index by looking through every CWDH's
+// index range.
+func findGlyphInfo(cwdhList []CWDH, glyphIndex int) (glyphInfo, bool) {
+	for _, cwdh := range cwdhList {
+		if glyphIndex >= int(cwdh.StartIndex) && glyphIndex <= int(cwdh.EndIndex) {
+			return cwdh.Glyphs[glyphIndex-int(cwdh.StartIndex)], true
+		}
+	}
+
+	return glyphInfo{}, false
+}
@@ -0,0 +1,229 @@
+package bffnt_headers
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// A single field that differs between two fonts. A and B hold the values from
+// the first and second font, nil when the entry only exists in one of them.
+type FontDifference struct {
+	Section string
+	Field   string
+	A       interface{}
+	B       interface{}
+}
+
+func (d FontDifference) String() string {
+	return fmt.Sprintf("%s %s: %s != %s", d.Section, d.Field, formatDiffValue(d.A), formatDiffValue(d.B))
+}
+
+func formatDiffValue(value interface{}) string {
+	if value == nil {
+		return "missing"
+	}
+
+	return fmt.Sprintf("%+v", value)
+}
+
+// Compares two decoded fonts field by field. Section sizes, offsets and
+// padding are ignored, they only describe where things are in the file. How
+// glyphs are split between CWDHs and how characters are split between CMAPs
+// (and which mapping method is used) doesn't matter either, only the
+// resulting widths and mappings are compared.
+func EqualFonts(a *BFFNT, b *BFFNT) (bool, []FontDifference) {
+	differences := make([]FontDifference, 0)
+	compare := func(section string, field string, aValue interface{}, bValue interface{}) {
+		if !reflect.DeepEqual(aValue, bValue) {
+			differences = append(differences, FontDifference{section, field, aValue, bValue})
+		}
+	}
+
+	compare("FFNT", "magic", a.FFNT.MagicHeader, b.FFNT.MagicHeader)
+	compare("FFNT", "version", a.FFNT.Version, b.FFNT.Version)
+
+	af, bf := a.FINF, b.FINF
+	compare("FINF", "font type", af.FontType, bf.FontType)
+	compare("FINF", "height", af.Height, bf.Height)
+	compare("FINF", "width", af.Width, bf.Width)
+	compare("FINF", "ascent", af.Ascent, bf.Ascent)
+	compare("FINF", "line feed", af.LineFeed, bf.LineFeed)
+	compare("FINF", "alter char index", af.AlterCharIndex, bf.AlterCharIndex)
+	compare("FINF", "default widths",
+		glyphInfo{int8(af.DefaultLeftWidth), af.DefaultGlyphWidth, af.DefaultCharWidth},
+		glyphInfo{int8(bf.DefaultLeftWidth), bf.DefaultGlyphWidth, bf.DefaultCharWidth})
+	compare("FINF", "encoding", af.Encoding, bf.Encoding)
+
+	at, bt := a.TGLP, b.TGLP
+	compare("TGLP", "cell width", at.CellWidth, bt.CellWidth)
+	compare("TGLP", "cell height", at.CellHeight, bt.CellHeight)
+	compare("TGLP", "sheets", at.NumOfSheets, bt.NumOfSheets)
+	compare("TGLP", "max char width", at.MaxCharWidth, bt.MaxCharWidth)
+	compare("TGLP", "sheet size", at.SheetSize, bt.SheetSize)
+	compare("TGLP", "baseline", at.BaselinePosition, bt.BaselinePosition)
+	compare("TGLP", "image format", at.SheetImageFormat, bt.SheetImageFormat)
+	compare("TGLP", "columns", at.NumOfColumns, bt.NumOfColumns)
+	compare("TGLP", "rows", at.NumOfRows, bt.NumOfRows)
+	compare("TGLP", "sheet width", at.SheetWidth, bt.SheetWidth)
+	compare("TGLP", "sheet height", at.SheetHeight, bt.SheetHeight)
+	if !bytes.Equal(at.AllSheetData, bt.AllSheetData) {
+		differences = append(differences, FontDifference{"TGLP", "sheet data",
+			fmt.Sprintf("%d bytes", len(at.AllSheetData)), fmt.Sprintf("%d bytes (%d differ)", len(bt.AllSheetData), countByteDifferences(at.AllSheetData, bt.AllSheetData))})
+	}
+
+	aGlyphs, bGlyphs := a.allGlyphInfo(), b.allGlyphInfo()
+	for i := 0; i < len(aGlyphs) || i < len(bGlyphs); i++ {
+		compare("CWDH", fmt.Sprintf("glyph %d", i), indexOrNil(aGlyphs, i), indexOrNil(bGlyphs, i))
+	}
+
+	aMappings, bMappings := a.charIndexMap(), b.charIndexMap()
+	for _, char := range sortedKeys(aMappings, bMappings) {
+		compare("CMAP", formatChar(char), valueOrNil(aMappings, char), valueOrNil(bMappings, char))
+	}
+
+	aKerning, bKerning := a.kerningMap(), b.kerningMap()
+	for _, pair := range sortedPairKeys(aKerning, bKerning) {
+		field := fmt.Sprintf("%s %s", formatChar(pair[0]), formatChar(pair[1]))
+		compare("KRNG", field, pairValueOrNil(aKerning, pair), pairValueOrNil(bKerning, pair))
+	}
+
+	return len(differences) == 0, differences
+}
+
+func diffCommand(args []string) {
+	flags := newCommandFlagSet("diff", "[flags] a.bffnt b.bffnt")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		exitWithUsage(flags)
+	}
+
+	a := flags.readBffnt(flags.Arg(0))
+	b := flags.readBffnt(flags.Arg(1))
+	equal, differences := EqualFonts(&a, &b)
+	for _, difference := range differences {
+		fmt.Println(difference)
+	}
+
+	if !equal {
+		fmt.Printf("%d difference(s)\n", len(differences))
+		exit(1)
+	}
+	fmt.Println("fonts are equal")
+}
+
+// Every glyph's widths in glyph index order, no matter which CWDH they are in
+func (b *BFFNT) allGlyphInfo() []glyphInfo {
+	b.loadCWDHs()
+	res := make([]glyphInfo, 0, b.glyphCount())
+	for _, cwdh := range b.CWDHs {
+		res = append(res, cwdh.Glyphs...)
+	}
+
+	return res
+}
+
+// Character to glyph index. The first CMAP wins like it does in game.
+func (b *BFFNT) charIndexMap() map[uint16]uint16 {
+	b.loadCMAPs()
+	res := make(map[uint16]uint16)
+	for _, cmap := range b.CMAPs {
+		for i, char := range cmap.CharAscii {
+			if _, exists := res[char]; exists || cmap.CharIndex[i] == 65535 {
+				continue
+			}
+			res[char] = cmap.CharIndex[i]
+		}
+	}
+
+	return res
+}
+
+func (b *BFFNT) kerningMap() map[[2]uint16]int16 {
+	b.KRNG.load()
+	res := make(map[[2]uint16]int16)
+	for firstChar, kPairs := range b.KRNG.KerningTable {
+		for _, pair := range kPairs {
+			res[[2]uint16{firstChar, pair.SecondChar}] = pair.KerningValue
+		}
+	}
+
+	return res
+}
+
+func countByteDifferences(a []byte, b []byte) int {
+	count := 0
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i >= len(a) || i >= len(b) || a[i] != b[i] {
+			count++
+		}
+	}
+
+	return count
+}
+
+// The helpers below return an untyped nil for missing entries so they show up
+// as "missing" in a FontDifference.
+
+func indexOrNil(glyphs []glyphInfo, i int) interface{} {
+	if i >= len(glyphs) {
+		return nil
+	}
+
+	return glyphs[i]
+}
+
+func valueOrNil(m map[uint16]uint16, key uint16) interface{} {
+	value, exists := m[key]
+	if !exists {
+		return nil
+	}
+
+	return value
+}
+
+func pairValueOrNil(m map[[2]uint16]int16, key [2]uint16) interface{} {
+	value, exists := m[key]
+	if !exists {
+		return nil
+	}
+
+	return value
+}
+
+func sortedKeys(a map[uint16]uint16, b map[uint16]uint16) []uint16 {
+	keys := make([]uint16, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, exists := a[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	return keys
+}
+
+func sortedPairKeys(a map[[2]uint16]int16, b map[[2]uint16]int16) [][2]uint16 {
+	keys := make([][2]uint16, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, exists := a[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	return keys
+}
@@ -0,0 +1,492 @@
+package bffnt_headers
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// An exploded font is a directory an artist can edit without any bffnt tools:
+// font.json has the metrics, glyph widths, character maps and kerning, the
+// sheets are pngs whose alpha channel is the glyph artwork. With -glyphs every
+// glyph gets a png of its cell too, which replace the cell in the sheet when
+// the font is imploded. Everything the encoder computes itself (offsets,
+// section sizes) is left out. Fonts with several CWDHs get a single one.
+//
+// Encoding BC4 sheets again isn't lossless, so the sheets are also kept as
+// they were encoded in sheets.bin. They're used as they are when the sheet
+// and glyph pngs still have the same alpha as when they were exploded.
+const (
+	EXPLODED_FONT_FILE   = "font.json"
+	EXPLODED_GLYPH_DIR   = "glyphs"
+	EXPLODED_SHEETS_FILE = "sheets.bin"
+)
+
+var explodedMappingMethods = []string{"direct", "table", "scan"}
+
+type ExplodedFont struct {
+	Magic        string                `json:"magic"` // FFNT, ffnt or CFNU
+	Endianness   uint16                `json:"endianness"`
+	Version      uint32                `json:"version"`
+	BlockReadNum uint32                `json:"blockReadNum"`
+	Font         ExplodedFontInfo      `json:"font"`
+	Sheets       ExplodedSheets        `json:"sheets"`
+	Glyphs       []ExplodedGlyph       `json:"glyphs"`
+	CMAPs        []ExplodedCMAP        `json:"cmaps"`
+	Kerning      []ExplodedKerningPair `json:"kerning"`
+	Sections     []string              `json:"sections,omitempty"` // files with the sections after the kerning in file order, see RegisterSection
+}
+
+type ExplodedFontInfo struct {
+	Type              uint8  `json:"type"`
+	Height            uint8  `json:"height"`
+	Width             uint8  `json:"width"`
+	Ascent            uint8  `json:"ascent"`
+	LineFeed          uint16 `json:"lineFeed"`
+	AlterCharIndex    uint16 `json:"alterCharIndex"`
+	DefaultLeftWidth  uint8  `json:"defaultLeftWidth"`
+	DefaultGlyphWidth uint8  `json:"defaultGlyphWidth"`
+	DefaultCharWidth  uint8  `json:"defaultCharWidth"`
+	Encoding          uint8  `json:"encoding"`
+}
+
+type ExplodedSheets struct {
+	CellWidth    uint8    `json:"cellWidth"`
+	CellHeight   uint8    `json:"cellHeight"`
+	Columns      uint16   `json:"columns"`
+	Rows         uint16   `json:"rows"`
+	Width        uint16   `json:"width"`
+	Height       uint16   `json:"height"`
+	Baseline     uint16   `json:"baseline"`
+	MaxCharWidth uint8    `json:"maxCharWidth"`
+	ImageFormat  uint16   `json:"imageFormat"` // see IMAGE_FORMAT_*
+	DataOffset   uint32   `json:"dataOffset"`  // where the sheets start in the file, they're aligned
+	Files        []string `json:"files"`
+	Encoded      string   `json:"encoded,omitempty"`     // the sheets as they were encoded
+	AlphaHashes  []string `json:"alphaHashes,omitempty"` // of every sheet's alpha when it was exploded
+}
+
+type ExplodedGlyph struct {
+	Index      uint16   `json:"index"`
+	Chars      []string `json:"chars"` // characters mapped to the glyph, see charKey
+	LeftWidth  int8     `json:"leftWidth"`
+	GlyphWidth uint8    `json:"glyphWidth"`
+	CharWidth  uint8    `json:"charWidth"`
+	Image      string   `json:"image,omitempty"` // png of the cell, drawn over the sheet
+}
+
+// Which characters a CMAP maps is taken from the glyphs, only the layout of
+// the maps is kept. Scan maps get the characters of their range no other map
+// has.
+type ExplodedCMAP struct {
+	Method string `json:"method"` // direct, table or scan
+	First  string `json:"first"`
+	Last   string `json:"last"`
+}
+
+type ExplodedKerningPair struct {
+	First  string `json:"first"`
+	Second string `json:"second"`
+	Value  int16  `json:"value"`
+}
+
+func explodedGlyphImage(index uint16, chars []rune) string {
+	name := fmt.Sprintf("%05d", index)
+	if len(chars) > 0 {
+		name += fmt.Sprintf("_U+%04X", chars[0])
+	}
+	return filepath.Join(EXPLODED_GLYPH_DIR, name+".png")
+}
+
+// Writes the font into dir, glyphImages adds a png for every glyph
+func (b *BFFNT) explode(dir string, glyphImages bool) ExplodedFont {
+	b.Load()
+	exploded := ExplodedFont{
+		Magic:        b.FFNT.MagicHeader,
+		Endianness:   b.FFNT.Endianness,
+		Version:      b.FFNT.Version,
+		BlockReadNum: b.FFNT.BlockReadNum,
+		Font: ExplodedFontInfo{
+			Type:              b.FINF.FontType,
+			Height:            b.FINF.Height,
+			Width:             b.FINF.Width,
+			Ascent:            b.FINF.Ascent,
+			LineFeed:          b.FINF.LineFeed,
+			AlterCharIndex:    b.FINF.AlterCharIndex,
+			DefaultLeftWidth:  b.FINF.DefaultLeftWidth,
+			DefaultGlyphWidth: b.FINF.DefaultGlyphWidth,
+			DefaultCharWidth:  b.FINF.DefaultCharWidth,
+			Encoding:          b.FINF.Encoding,
+		},
+		Sheets: ExplodedSheets{
+			CellWidth:    b.TGLP.CellWidth,
+			CellHeight:   b.TGLP.CellHeight,
+			Columns:      b.TGLP.NumOfColumns,
+			Rows:         b.TGLP.NumOfRows,
+			Width:        b.TGLP.SheetWidth,
+			Height:       b.TGLP.SheetHeight,
+			Baseline:     b.TGLP.BaselinePosition,
+			MaxCharWidth: b.TGLP.MaxCharWidth,
+			ImageFormat:  b.TGLP.SheetImageFormat,
+			DataOffset:   b.TGLP.SheetDataOffset,
+		},
+		Glyphs:  make([]ExplodedGlyph, 0, b.glyphCount()),
+		CMAPs:   make([]ExplodedCMAP, 0, len(b.CMAPs)),
+		Kerning: make([]ExplodedKerningPair, 0),
+	}
+	if len(b.CWDHs) > 1 {
+		fmt.Printf("warning: the font has %d CWDHs, imploding it puts all glyphs into one\n", len(b.CWDHs))
+	}
+
+	handleErr(os.MkdirAll(dir, 0755))
+	b.TGLP.DecodeSheets()
+	for i := range b.TGLP.SheetData {
+		sheetFile := exportSheetName("sheet", i)
+		writePng(filepath.Join(dir, sheetFile), &b.TGLP.SheetData[i])
+		exploded.Sheets.Files = append(exploded.Sheets.Files, sheetFile)
+		exploded.Sheets.AlphaHashes = append(exploded.Sheets.AlphaHashes, alphaHash(&b.TGLP.SheetData[i]))
+	}
+	exploded.Sheets.Encoded = EXPLODED_SHEETS_FILE
+	handleErr(os.WriteFile(filepath.Join(dir, EXPLODED_SHEETS_FILE), b.TGLP.AllSheetData, 0644))
+	if glyphImages {
+		handleErr(os.MkdirAll(filepath.Join(dir, EXPLODED_GLYPH_DIR), 0755))
+	}
+
+	for index := 0; index < b.glyphCount(); index++ {
+		info, _ := b.glyphInfoAt(index)
+		chars := b.RunesForIndex(uint16(index))
+		glyph := ExplodedGlyph{
+			Index:      uint16(index),
+			Chars:      make([]string, 0, len(chars)),
+			LeftWidth:  info.LeftWidth,
+			GlyphWidth: info.GlyphWidth,
+			CharWidth:  info.CharWidth,
+		}
+		for _, char := range chars {
+			glyph.Chars = append(glyph.Chars, charKey(char))
+		}
+
+		sheet, cell := b.TGLP.CellRect(index)
+		if glyphImages && sheet < len(b.TGLP.SheetData) {
+			glyph.Image = explodedGlyphImage(uint16(index), chars)
+			writePng(filepath.Join(dir, glyph.Image), imaging.Crop(&b.TGLP.SheetData[sheet], cell))
+		}
+		exploded.Glyphs = append(exploded.Glyphs, glyph)
+	}
+
+	for _, cmap := range b.CMAPs {
+		method := fmt.Sprint(cmap.MappingMethod)
+		if int(cmap.MappingMethod) < len(explodedMappingMethods) {
+			method = explodedMappingMethods[cmap.MappingMethod]
+		}
+		exploded.CMAPs = append(exploded.CMAPs, ExplodedCMAP{method, charKey(rune(cmap.CodeBegin)), charKey(rune(cmap.CodeEnd))})
+	}
+	for _, pair := range b.kerningPairs() {
+		exploded.Kerning = append(exploded.Kerning, ExplodedKerningPair{charKey(rune(pair[0])), charKey(rune(pair[1])), int16(pair[2])})
+	}
+
+	// where they end up isn't known until the font is put back together, they
+	// are encoded as if their data started right after their header
+	for i, section := range b.ExtraSections {
+		if !section.Present() {
+			continue
+		}
+		sectionFile := fmt.Sprintf("section_%d_%s.bin", i, section.Magic())
+		handleErr(os.WriteFile(filepath.Join(dir, sectionFile), checkedSection(section).Encode(8), 0644))
+		exploded.Sections = append(exploded.Sections, sectionFile)
+	}
+
+	raw, err := json.MarshalIndent(exploded, "", "  ")
+	handleErr(err)
+	handleErr(os.WriteFile(filepath.Join(dir, EXPLODED_FONT_FILE), append(raw, '\n'), 0644))
+
+	return exploded
+}
+
+func readExplodedFont(dir string) ExplodedFont {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, EXPLODED_FONT_FILE))
+	handleErr(err)
+
+	var exploded ExplodedFont
+	handleErr(json.Unmarshal(raw, &exploded))
+	return exploded
+}
+
+func parseExplodedChar(key string) uint16 {
+	char, err := parseCharFlag(key)
+	handleErr(err)
+	if char > 0xFFFF {
+		handleErr(fmt.Errorf("%s: %#U is outside the basic multilingual plane and can't be mapped", EXPLODED_FONT_FILE, char))
+	}
+	return uint16(char)
+}
+
+// Builds the CMAPs the exploded font lists from the characters of the glyphs.
+// Returns false when the characters don't fit them anymore, e.g. a direct map
+// whose glyphs aren't in order after characters were added.
+func (exploded ExplodedFont) cmaps(indexes map[uint16]uint16) ([]CMAP, bool) {
+	cmaps := make([]CMAP, len(exploded.CMAPs))
+	claimed := make(map[uint16]bool)
+	for _, scanPass := range []bool{false, true} {
+		for i, explodedCMAP := range exploded.CMAPs {
+			method := -1
+			for m, name := range explodedMappingMethods {
+				if name == explodedCMAP.Method {
+					method = m
+				}
+			}
+			if method < 0 {
+				handleErr(fmt.Errorf("%s: unknown CMAP mapping method %q, use direct, table or scan", EXPLODED_FONT_FILE, explodedCMAP.Method))
+			}
+			if (method == 2) != scanPass {
+				continue
+			}
+
+			cmap := CMAP{
+				MagicHeader:   CMAP_MAGIC_HEADER,
+				CodeBegin:     parseExplodedChar(explodedCMAP.First),
+				CodeEnd:       parseExplodedChar(explodedCMAP.Last),
+				MappingMethod: uint16(method),
+			}
+			if cmap.CodeEnd < cmap.CodeBegin {
+				handleErr(fmt.Errorf("%s: CMAP %s..%s ends before it begins", EXPLODED_FONT_FILE, explodedCMAP.First, explodedCMAP.Last))
+			}
+			for code := int(cmap.CodeBegin); code <= int(cmap.CodeEnd); code++ {
+				index, mapped := indexes[uint16(code)]
+				mapped = mapped && !claimed[uint16(code)]
+				switch method {
+				case 0:
+					if code == int(cmap.CodeBegin) {
+						cmap.CharacterOffset = index
+					}
+					if !mapped || int(index) != int(cmap.CharacterOffset)+code-int(cmap.CodeBegin) {
+						return nil, false
+					}
+				case 1:
+					if !mapped {
+						index = 0xFFFF
+					}
+				case 2:
+					if !mapped {
+						continue
+					}
+				}
+				cmap.CharAscii = append(cmap.CharAscii, uint16(code))
+				cmap.CharIndex = append(cmap.CharIndex, index)
+				if mapped {
+					claimed[uint16(code)] = true
+				}
+			}
+			if method == 2 {
+				cmap.CharacterCount = checkedUint16("CMAP scan entry count", int64(len(cmap.CharAscii)))
+			}
+			cmaps[i] = cmap
+		}
+	}
+
+	return cmaps, len(claimed) == len(indexes)
+}
+
+// Builds the font back from an exploded directory
+func implodeFont(dir string) BFFNT {
+	exploded := readExplodedFont(dir)
+	b := BFFNT{
+		FFNT: FFNT{
+			MagicHeader:  exploded.Magic,
+			Endianness:   exploded.Endianness,
+			SectionSize:  FFNT_HEADER_SIZE,
+			Version:      exploded.Version,
+			BlockReadNum: exploded.BlockReadNum,
+		},
+		FINF: FINF{
+			MagicHeader:       FINF_MAGIC_HEADER,
+			SectionSize:       FINF_HEADER_SIZE,
+			FontType:          exploded.Font.Type,
+			Height:            exploded.Font.Height,
+			Width:             exploded.Font.Width,
+			Ascent:            exploded.Font.Ascent,
+			LineFeed:          exploded.Font.LineFeed,
+			AlterCharIndex:    exploded.Font.AlterCharIndex,
+			DefaultLeftWidth:  exploded.Font.DefaultLeftWidth,
+			DefaultGlyphWidth: exploded.Font.DefaultGlyphWidth,
+			DefaultCharWidth:  exploded.Font.DefaultCharWidth,
+			Encoding:          exploded.Font.Encoding,
+		},
+		TGLP: TGLP{
+			MagicHeader:      TGLP_MAGIC_HEADER,
+			CellWidth:        exploded.Sheets.CellWidth,
+			CellHeight:       exploded.Sheets.CellHeight,
+			NumOfSheets:      checkedUint8("TGLP sheet count", int64(len(exploded.Sheets.Files))),
+			MaxCharWidth:     exploded.Sheets.MaxCharWidth,
+			BaselinePosition: exploded.Sheets.Baseline,
+			SheetImageFormat: exploded.Sheets.ImageFormat,
+			NumOfColumns:     exploded.Sheets.Columns,
+			NumOfRows:        exploded.Sheets.Rows,
+			SheetWidth:       exploded.Sheets.Width,
+			SheetHeight:      exploded.Sheets.Height,
+			SheetDataOffset:  exploded.Sheets.DataOffset,
+		},
+		CWDHs: []CWDH{{MagicHeader: CWDH_MAGIC_HEADER}},
+		KRNG:  KRNG{MagicHeader: KRNG_MAGIC_HEADER, KerningTable: make(map[uint16][]kerningPair)},
+	}
+	if int(b.TGLP.SheetDataOffset) < FFNT_HEADER_SIZE+FINF_HEADER_SIZE+TGLP_HEADER_SIZE {
+		handleErr(fmt.Errorf("%s: the sheet data offset %d is inside the headers", EXPLODED_FONT_FILE, b.TGLP.SheetDataOffset))
+	}
+	b.TGLP.SheetSize = b.TGLP.computeSheetSize()
+	b.TGLP.SectionSize = checkedUint32("TGLP section size", int64(TGLP_HEADER_SIZE)+int64(b.TGLP.computePredataPadding())+int64(b.TGLP.sheetsSize()))
+
+	for _, sheetFile := range exploded.Sheets.Files {
+		img, err := imaging.Open(filepath.Join(dir, sheetFile))
+		handleErr(err)
+		if img.Bounds().Dx() != int(b.TGLP.SheetWidth) || img.Bounds().Dy() != int(b.TGLP.SheetHeight) {
+			handleErr(fmt.Errorf("%s is %dx%d, the sheets are %dx%d", sheetFile, img.Bounds().Dx(), img.Bounds().Dy(), b.TGLP.SheetWidth, b.TGLP.SheetHeight))
+		}
+		sheet := imaging.Clone(img)
+		if opaque(sheet) {
+			fmt.Printf("warning: %s has no transparency, the glyphs are read from its alpha channel\n", sheetFile)
+		}
+		b.TGLP.SheetData = append(b.TGLP.SheetData, *sheet)
+	}
+
+	cells := int(b.TGLP.NumOfColumns) * int(b.TGLP.NumOfRows) * int(b.TGLP.NumOfSheets)
+	if len(exploded.Glyphs) > cells {
+		handleErr(fmt.Errorf("%s: %d glyphs don't fit the %d cells of the sheets", EXPLODED_FONT_FILE, len(exploded.Glyphs), cells))
+	}
+	indexes := make(map[uint16]uint16)
+	for i, glyph := range exploded.Glyphs {
+		if int(glyph.Index) != i {
+			handleErr(fmt.Errorf("%s: glyph %d is listed as glyph %d, glyphs have to be in order", EXPLODED_FONT_FILE, i, glyph.Index))
+		}
+		b.CWDHs[0].Glyphs = append(b.CWDHs[0].Glyphs, glyphInfo{glyph.LeftWidth, glyph.GlyphWidth, glyph.CharWidth})
+		for _, key := range glyph.Chars {
+			char := parseExplodedChar(key)
+			if other, exists := indexes[char]; exists {
+				handleErr(fmt.Errorf("%s: %s is mapped to glyph %d and %d", EXPLODED_FONT_FILE, key, other, glyph.Index))
+			}
+			indexes[char] = glyph.Index
+		}
+
+		if glyph.Image != "" {
+			img, err := imaging.Open(filepath.Join(dir, glyph.Image))
+			handleErr(err)
+			sheet, cell := b.TGLP.CellRect(i)
+			if img.Bounds().Dx() != cell.Dx() || img.Bounds().Dy() != cell.Dy() {
+				fmt.Printf("warning: %s is %dx%d, the cells are %dx%d\n", glyph.Image, img.Bounds().Dx(), img.Bounds().Dy(), cell.Dx(), cell.Dy())
+			}
+			draw.Draw(&b.TGLP.SheetData[sheet], cell, img, img.Bounds().Min, draw.Src)
+		}
+	}
+
+	b.TGLP.keepEncodedSheets(dir, exploded.Sheets)
+
+	cmaps, fits := exploded.cmaps(indexes)
+	b.CMAPs = cmaps
+	if !fits {
+		// RebuildCMAPs only needs the characters, a scan map has them all
+		scan := CMAP{MagicHeader: CMAP_MAGIC_HEADER, MappingMethod: 2}
+		for char := range indexes {
+			scan.CharAscii = append(scan.CharAscii, char)
+		}
+		sort.Slice(scan.CharAscii, func(i, j int) bool { return scan.CharAscii[i] < scan.CharAscii[j] })
+		for _, char := range scan.CharAscii {
+			scan.CharIndex = append(scan.CharIndex, indexes[char])
+		}
+		b.CMAPs = []CMAP{scan}
+		_, after := b.RebuildCMAPs()
+		fmt.Printf("the characters don't fit the CMAPs of %s anymore, rebuilt them into %d\n", EXPLODED_FONT_FILE, after)
+	}
+
+	for _, pair := range exploded.Kerning {
+		first := parseExplodedChar(pair.First)
+		b.KRNG.KerningTable[first] = append(b.KRNG.KerningTable[first], kerningPair{parseExplodedChar(pair.Second), pair.Value})
+	}
+	// a custom section whose decoder isn't registered anymore is kept as an
+	// unknown one, and the other way around
+	for _, sectionFile := range exploded.Sections {
+		raw, err := ioutil.ReadFile(filepath.Join(dir, sectionFile))
+		handleErr(err)
+		if err := b.addRawSection(raw, 8); err != nil {
+			handleErr(fmt.Errorf("%s: %v", sectionFile, err))
+		}
+	}
+	b.buildCWDHIndexMap()
+
+	return b
+}
+
+func alphaHash(img *image.NRGBA) string {
+	alpha := make([]byte, 0, len(img.Pix)/4)
+	for i := 3; i < len(img.Pix); i += 4 {
+		alpha = append(alpha, img.Pix[i])
+	}
+	return md5String(alpha)
+}
+
+// Puts back the encoded sheets of sheets.bin when none of the artwork
+// changed, the TGLP then writes them as they were decoded, see sheetsUntouched
+func (tglp *TGLP) keepEncodedSheets(dir string, sheets ExplodedSheets) {
+	if sheets.Encoded == "" || len(sheets.AlphaHashes) != len(tglp.SheetData) {
+		return
+	}
+	for i := range tglp.SheetData {
+		if alphaHash(&tglp.SheetData[i]) != sheets.AlphaHashes[i] {
+			return
+		}
+	}
+	encoded, err := ioutil.ReadFile(filepath.Join(dir, sheets.Encoded))
+	if err != nil || len(encoded) != tglp.sheetsSize() {
+		return
+	}
+
+	tglp.raw = append(tglp.appendPredata(nil), encoded...)
+	tglp.AllSheetData = tglp.raw[len(tglp.raw)-len(encoded):]
+	tglp.SheetData = nil
+}
+
+func opaque(img *image.NRGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0xFF {
+			return false
+		}
+	}
+	return true
+}
+
+func explodeCommand(args []string) {
+	flags := newCommandFlagSet("explode", "[flags] font.bffnt dir")
+	glyphImages := flags.Bool("glyphs", false, "also write a png of every glyph's cell, implode draws them over the sheets")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		exitWithUsage(flags)
+	}
+
+	bffnt := flags.readBffnt(flags.Arg(0))
+	exploded := bffnt.explode(flags.Arg(1), *glyphImages)
+	fmt.Printf("wrote %d glyph(s), %d sheet(s) and %d kerning pair(s) to %s\n", len(exploded.Glyphs), len(exploded.Sheets.Files), len(exploded.Kerning), flags.Arg(1))
+}
+
+func implodeCommand(args []string) {
+	flags := newCommandFlagSet("implode", "[flags] dir font.bffnt")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		exitWithUsage(flags)
+	}
+
+	bffnt := implodeFont(flags.Arg(0))
+	encoded := bffnt.Encode()
+	for _, failure := range bffnt.Validation().Failures {
+		fmt.Println("warning:", failure)
+	}
+	handleErr(bffnt.VerifyEncoded(encoded))
+	handleErr(os.WriteFile(flags.Arg(1), encoded, 0644))
+	fmt.Println("wrote", flags.Arg(1))
+}
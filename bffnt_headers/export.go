@@ -0,0 +1,249 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Exporters turn a decoded bffnt into bitmap font formats that other game
+// engines understand. The texture files are referenced by name, they are
+// written next to the font description as <name>_<sheet index>.png.
+//
+// godot-fnt is the AngelCode BMFont text format which Godot imports as a
+// BitmapFont. godot-tres is a Godot 3 BitmapFont resource. unity is a Unity
+// custom font asset (.fontsettings). Unity custom fonts only use a single
+// texture and it has to be assigned in the editor. fea is only the kerning, as
+// an OpenType kern feature for font editors.
+var exporters = map[string]func(b *BFFNT, name string) string{
+	"fea":        exportFeaKerning,
+	"godot-fnt":  exportBMFont,
+	"godot-tres": exportGodotTres,
+	"unity":      exportUnityFont,
+}
+
+var exporterExtensions = map[string]string{
+	"fea":        ".fea",
+	"godot-fnt":  ".fnt",
+	"godot-tres": ".tres",
+	"unity":      ".fontsettings",
+}
+
+// Formats without textures
+var sheetlessExporters = map[string]bool{
+	"fea": true,
+}
+
+func exportCommand(args []string) {
+	flags := newCommandFlagSet("export", "[flags] font.bffnt [sheet_0.png ...]")
+	format := flags.String("format", "godot-fnt", "export format: godot-fnt, godot-tres, unity or fea")
+	outputDir := flags.String("o", ".", "output directory")
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		exitWithUsage(flags)
+	}
+
+	exporter, exists := exporters[*format]
+	if !exists {
+		handleErr(fmt.Errorf("unknown export format %q", *format))
+	}
+
+	bffntFile := flags.Arg(0)
+	sheetFiles := flags.Args()[1:]
+	bffnt := flags.readBffnt(bffntFile)
+	name := strings.TrimSuffix(filepath.Base(bffntFile), filepath.Ext(bffntFile))
+
+	handleErr(os.MkdirAll(*outputDir, 0755))
+	if !sheetlessExporters[*format] {
+		bffnt.writeExportSheets(*outputDir, name, sheetFiles)
+	}
+
+	outputFile := filepath.Join(*outputDir, name+exporterExtensions[*format])
+	err := os.WriteFile(outputFile, []byte(exporter(&bffnt, name)), 0644)
+	handleErr(err)
+	fmt.Println("wrote", *format, "font to", outputFile)
+}
+
+func exportSheetName(name string, sheet int) string {
+	return fmt.Sprintf("%s_%d.png", name, sheet)
+}
+
+// The sheet textures either come from png files given by the user (e.g. the
+// output of generateTexture or a Switch Toolbox export) or are decoded from the
+// bffnt itself when the image format is supported.
+func (b *BFFNT) writeExportSheets(outputDir string, name string, sheetFiles []string) {
+	if len(sheetFiles) > 0 {
+		for i, sheetFile := range sheetFiles {
+			sheetRaw, err := ioutil.ReadFile(sheetFile)
+			handleErr(err)
+			err = os.WriteFile(filepath.Join(outputDir, exportSheetName(name, i)), sheetRaw, 0644)
+			handleErr(err)
+		}
+		return
+	}
+
+	if b.TGLP.SheetImageFormat != 8 {
+		fmt.Printf("sheet image format %d can not be decoded yet. Pass the sheet pngs after the bffnt file.\n", b.TGLP.SheetImageFormat)
+		return
+	}
+
+	b.TGLP.DecodeSheets()
+	for i := range b.TGLP.SheetData {
+		writePng(filepath.Join(outputDir, exportSheetName(name, i)), &b.TGLP.SheetData[i])
+	}
+}
+
+// Every kerning pair in the same order as the KRNG section
+func (b *BFFNT) kerningPairs() [][3]int {
+	res := make([][3]int, 0)
+	for _, firstChar := range getFirstCharsOrdered(b.KRNG.KerningTable) {
+		for _, pair := range b.KRNG.KerningTable[firstChar] {
+			res = append(res, [3]int{int(firstChar), int(pair.SecondChar), int(pair.KerningValue)})
+		}
+	}
+
+	return res
+}
+
+// https://www.angelcode.com/products/bmfont/doc/file_format.html
+func exportBMFont(b *BFFNT, name string) string {
+	atlas := b.Atlas()
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "info face=\"%s\" size=%d bold=0 italic=0 charset=\"\" unicode=1 stretchH=100 smooth=1 aa=1 padding=0,0,0,0 spacing=1,1\n", name, b.FINF.Height)
+	fmt.Fprintf(&sb, "common lineHeight=%d base=%d scaleW=%d scaleH=%d pages=%d packed=0\n", atlas.LineFeed, atlas.Baseline, atlas.SheetWidth, atlas.SheetHeight, atlas.NumOfSheets)
+	for i := 0; i < atlas.NumOfSheets; i++ {
+		fmt.Fprintf(&sb, "page id=%d file=\"%s\"\n", i, exportSheetName(name, i))
+	}
+
+	fmt.Fprintf(&sb, "chars count=%d\n", len(atlas.Glyphs))
+	for _, g := range atlas.Glyphs {
+		fmt.Fprintf(&sb, "char id=%d x=%d y=%d width=%d height=%d xoffset=%d yoffset=0 xadvance=%d page=%d chnl=15\n",
+			g.Code, g.X, g.Y, g.Width, g.Height, g.BearingX, g.Advance, g.Sheet)
+	}
+
+	kerningPairs := b.kerningPairs()
+	fmt.Fprintf(&sb, "kernings count=%d\n", len(kerningPairs))
+	for _, pair := range kerningPairs {
+		fmt.Fprintf(&sb, "kerning first=%d second=%d amount=%d\n", pair[0], pair[1], pair[2])
+	}
+
+	return sb.String()
+}
+
+// Godot 3 BitmapFont. chars are stored as 9 ints:
+// char, texture index, x, y, width, height, x offset, y offset, advance
+// Godot subtracts kerning values so they are negated.
+func exportGodotTres(b *BFFNT, name string) string {
+	atlas := b.Atlas()
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "[gd_resource type=\"BitmapFont\" load_steps=%d format=2]\n\n", atlas.NumOfSheets+1)
+	for i := 0; i < atlas.NumOfSheets; i++ {
+		fmt.Fprintf(&sb, "[ext_resource path=\"res://%s\" type=\"Texture\" id=%d]\n", exportSheetName(name, i), i+1)
+	}
+	sb.WriteString("\n[resource]\n")
+
+	textures := make([]string, 0)
+	for i := 0; i < atlas.NumOfSheets; i++ {
+		textures = append(textures, fmt.Sprintf("ExtResource( %d )", i+1))
+	}
+	fmt.Fprintf(&sb, "textures = [ %s ]\n", strings.Join(textures, ", "))
+
+	chars := make([]string, 0)
+	for _, g := range atlas.Glyphs {
+		chars = append(chars, fmt.Sprintf("%d, %d, %d, %d, %d, %d, %d, %d, %d",
+			g.Code, g.Sheet, g.X, g.Y, g.Width, g.Height, g.BearingX, 0, g.Advance))
+	}
+	fmt.Fprintf(&sb, "chars = PoolIntArray( %s )\n", strings.Join(chars, ", "))
+
+	kernings := make([]string, 0)
+	for _, pair := range b.kerningPairs() {
+		kernings = append(kernings, fmt.Sprintf("%d, %d, %d", pair[0], pair[1], -pair[2]))
+	}
+	fmt.Fprintf(&sb, "kernings = PoolIntArray( %s )\n", strings.Join(kernings, ", "))
+	fmt.Fprintf(&sb, "height = %d.0\n", atlas.LineFeed)
+	fmt.Fprintf(&sb, "ascent = %d.0\n", atlas.Baseline)
+
+	return sb.String()
+}
+
+// Unity's uv origin is the bottom left of the texture and the vertex rect is
+// relative to the baseline with y pointing up.
+func exportUnityFont(b *BFFNT, name string) string {
+	atlas := b.Atlas()
+	if atlas.NumOfSheets > 1 {
+		fmt.Println("unity custom fonts only support one texture. Only glyphs on sheet 0 are exported.")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("%YAML 1.1\n%TAG !u! tag:unity3d.com,2011:\n--- !u!128 &12800000\nFont:\n")
+	sb.WriteString("  m_ObjectHideFlags: 0\n")
+	fmt.Fprintf(&sb, "  m_Name: %s\n", name)
+	sb.WriteString("  serializedVersion: 5\n")
+	fmt.Fprintf(&sb, "  m_LineSpacing: %d\n", atlas.LineFeed)
+	sb.WriteString("  m_DefaultMaterial: {fileID: 0}\n")
+	fmt.Fprintf(&sb, "  m_FontSize: %d\n", b.FINF.Height)
+	sb.WriteString("  m_Texture: {fileID: 0}\n")
+	sb.WriteString("  m_AsciiStartOffset: 0\n  m_Tracking: 1\n  m_CharacterSpacing: 0\n  m_CharacterPadding: 1\n  m_ConvertCase: 0\n")
+	sb.WriteString("  m_CharacterRects:\n")
+	for _, g := range atlas.Glyphs {
+		if g.Sheet != 0 {
+			continue
+		}
+		sb.WriteString("  - serializedVersion: 2\n")
+		fmt.Fprintf(&sb, "    index: %d\n", g.Code)
+		sb.WriteString("    uv:\n      serializedVersion: 2\n")
+		fmt.Fprintf(&sb, "      x: %g\n      y: %g\n      width: %g\n      height: %g\n", g.U0, 1-g.V1, g.U1-g.U0, g.V1-g.V0)
+		sb.WriteString("    vert:\n      serializedVersion: 2\n")
+		fmt.Fprintf(&sb, "      x: %d\n      y: %d\n      width: %d\n      height: %d\n", g.BearingX, g.BearingY, g.Width, -g.Height)
+		fmt.Fprintf(&sb, "    advance: %d\n", g.Advance)
+		sb.WriteString("    flipped: 0\n")
+	}
+
+	// pairs of characters with the amount, unity adds it like BFFNT does
+	kerningPairs := b.kerningPairs()
+	if len(kerningPairs) == 0 {
+		sb.WriteString("  m_KerningValues: []\n")
+	} else {
+		sb.WriteString("  m_KerningValues:\n")
+	}
+	for _, pair := range kerningPairs {
+		fmt.Fprintf(&sb, "  - first:\n      first: %d\n      second: %d\n    second: %d\n", pair[0], pair[1], pair[2])
+	}
+	sb.WriteString("  m_PixelScale: 1\n  m_FontData: []\n")
+	fmt.Fprintf(&sb, "  m_Ascent: %d\n", atlas.Baseline)
+	fmt.Fprintf(&sb, "  m_Descent: %d\n", int(b.FINF.Height)-atlas.Baseline)
+	sb.WriteString("  m_DefaultStyle: 0\n  m_FontNames: []\n  m_FallbackFonts: []\n  m_FontRenderingMode: 0\n")
+
+	return sb.String()
+}
+
+// The em the kerning is exported in. Font editors work in font units, the
+// pixels are scaled so an em of the font's height is this many units.
+const FEA_UNITS_PER_EM = 1000
+
+// A kern feature with every pair of the KRNG, in the same order. Font editors
+// like FontForge and Glyphs load it into a font whose glyphs have the Adobe
+// glyph list names, see charGlyphName. The header has the kerning profile
+// settings that import it back to the same pixels.
+func exportFeaKerning(b *BFFNT, name string) string {
+	em := int(b.FINF.Height)
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# Kerning of %s, 1 em is its height of %d px in %d units.\n", name, em, FEA_UNITS_PER_EM)
+	sb.WriteString("# Import it back in a kerning profile with\n")
+	fmt.Fprintf(&sb, "#   import: %s.fea\n#   import_size: %d\n#   units_per_em: %d\n\n", name, em, FEA_UNITS_PER_EM)
+	sb.WriteString("languagesystem DFLT dflt;\nlanguagesystem latn dflt;\n\nfeature kern {\n")
+	for _, pair := range b.kerningPairs() {
+		units := int(math.Round(float64(pair[2]) * FEA_UNITS_PER_EM / float64(em)))
+		fmt.Fprintf(&sb, "\tpos %s %s %d;\n", charGlyphName(rune(pair[0])), charGlyphName(rune(pair[1])), units)
+	}
+	sb.WriteString("} kern;\n")
+
+	return sb.String()
+}
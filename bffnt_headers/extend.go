@@ -0,0 +1,421 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"gopkg.in/yaml.v3"
+)
+
+// Character ranges translation teams ask for the most, picked by name in an
+// extend config. Precomposed characters are listed where they exist, the game
+// can't stack combining marks on top of a glyph. The combining marks are there
+// for languages without precomposed forms, they're drawn as zero width glyphs
+// over the previous character, see markWidths.
+var scriptRanges = map[string][][2]rune{
+	// Russian, Ukrainian, Belarusian, Serbian, Macedonian and Bulgarian
+	// Cyrillic plus the numero sign
+	"cyrillic": {{0x0400, 0x045F}, {0x0490, 0x0491}, {0x2116, 0x2116}},
+	// Monotonic Greek with the accented and dialytika forms
+	"greek": {{0x0386, 0x0386}, {0x0388, 0x038A}, {0x038C, 0x038C}, {0x038E, 0x03A1}, {0x03A3, 0x03CE}},
+	// Vietnamese needs the Latin-1 vowels with grave, acute, circumflex and
+	// tilde, a handful of Latin Extended letters and the whole Latin Extended
+	// Additional block of stacked tone marks
+	"vietnamese": {
+		{0x00C0, 0x00C3}, {0x00C8, 0x00CA}, {0x00CC, 0x00CD}, {0x00D2, 0x00D5}, {0x00D9, 0x00DA}, {0x00DD, 0x00DD},
+		{0x00E0, 0x00E3}, {0x00E8, 0x00EA}, {0x00EC, 0x00ED}, {0x00F2, 0x00F5}, {0x00F9, 0x00FA}, {0x00FD, 0x00FD},
+		{0x0102, 0x0103}, {0x0110, 0x0111}, {0x0128, 0x0129}, {0x0168, 0x0169}, {0x01A0, 0x01A1}, {0x01AF, 0x01B0},
+		{0x1EA0, 0x1EF9}, {0x20AB, 0x20AB},
+	},
+	// Combining Diacritical Marks
+	"combining": {{0x0300, 0x036F}},
+}
+
+func scriptNames() []string {
+	names := make([]string, 0, len(scriptRanges))
+	for name := range scriptRanges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func scriptChars(name string) ([]rune, error) {
+	ranges, exists := scriptRanges[strings.ToLower(name)]
+	if !exists {
+		return nil, fmt.Errorf("unknown script %q. Known scripts: %s", name, strings.Join(scriptNames(), ", "))
+	}
+
+	chars := make([]rune, 0)
+	for _, r := range ranges {
+		for char := r[0]; char <= r[1]; char++ {
+			chars = append(chars, char)
+		}
+	}
+
+	return chars, nil
+}
+
+// Everything `bffnt extend` needs to turn a botw font into one that can draw
+// another language, see extend.yaml for an example. Paths are relative to the
+// config file.
+type ExtendConfig struct {
+	Font      string   `yaml:"font"`      // botw font to start from: Ancient, Caption, Normal, NormalS or External
+	Bffnt     string   `yaml:"bffnt"`     // font file, defaults to the botw font in WiiU_fonts
+	TTF       string   `yaml:"ttf"`       // replacement font drawing every glyph, defaults to the one picked for the botw font
+	TTFIndex  int      `yaml:"ttf_index"` // face of a font collection (.ttc) to use
+	Axes      string   `yaml:"axes"`      // axis values of a variable font, see FONT_AXES_SEPARATOR
+	Features  string   `yaml:"features"`  // OpenType features to draw with, see parseFontFeatures
+	Overrides string   `yaml:"overrides"` // directory with artwork replacing rendered glyphs, see drawOverrides
+	GlyphMap  string   `yaml:"glyph_map"` // characters drawn with other characters of the replacement font, see glyphMatchCommand
+	Target    string   `yaml:"target"`    // target resolution, see -target
+	Scale     float64  `yaml:"scale"`     // explicit scale, overrides target
+	Scripts   []string `yaml:"scripts"`   // named character ranges, see scriptRanges
+	Charsets  []string `yaml:"charsets"`  // text or msbt files whose characters are added too
+	Kerning   bool     `yaml:"kerning"`   // take the kerning of the added characters from the replacement font
+	AutoFit   bool     `yaml:"autofit"`   // take the widths of the original characters from the replacement font too
+	Platform  string   `yaml:"platform"`  // texture limits the sheet has to fit, see -platform
+	Output    string   `yaml:"output"`    // directory the template and sheet are written to
+
+	KerningProfiles string `yaml:"kerning_profiles"` // yaml file with named kerning profiles, see KerningProfiles
+	KerningProfile  string `yaml:"kerning_profile"`  // profile merged over the kerning
+	Adjustments     string `yaml:"adjustments"`      // per glyph nudges, see GlyphAdjustment
+	Rules           string `yaml:"rules"`            // width rules, see AdjustmentRule
+}
+
+func readExtendConfig(filename string) ExtendConfig {
+	raw, err := ioutil.ReadFile(filename)
+	handleErr(err)
+
+	config := ExtendConfig{Target: "1440p", Platform: "wiiu"}
+	handleErr(yaml.Unmarshal(raw, &config))
+	if config.Font == "" {
+		handleErr(fmt.Errorf("%s: font is missing", filename))
+	}
+
+	// make paths relative to the config file
+	dir := filepath.Dir(filename)
+	relative := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+	config.Bffnt = relative(config.Bffnt)
+	if isFontFile(config.TTF) {
+		// font names are looked up, see findFontFile
+		config.TTF = relative(config.TTF)
+	}
+	config.Output = relative(config.Output)
+	config.KerningProfiles = relative(config.KerningProfiles)
+	config.Overrides = relative(config.Overrides)
+	config.GlyphMap = relative(config.GlyphMap)
+	config.Adjustments = relative(config.Adjustments)
+	config.Rules = relative(config.Rules)
+	for i := range config.Charsets {
+		config.Charsets[i] = relative(config.Charsets[i])
+	}
+
+	return config
+}
+
+// Every character the config asks for, sorted and without duplicates
+func (config ExtendConfig) chars() (Charset, error) {
+	texts := make([]string, 0)
+	for _, script := range config.Scripts {
+		chars, err := scriptChars(script)
+		if err != nil {
+			return Charset{}, err
+		}
+		texts = append(texts, string(chars))
+	}
+
+	charset := newCharset(texts)
+	if len(config.Charsets) > 0 {
+		fromFiles := readCharset(config.Charsets)
+		merged := newCharset([]string{string(charset.Chars), string(fromFiles.Chars)})
+		merged.Warnings = append(charset.Warnings, fromFiles.Warnings...)
+		charset = merged
+	}
+
+	return charset, nil
+}
+
+// Maps every character the font can't draw yet to a new glyph after the last
+// one. The new glyphs start with the font's default widths, they're only
+// usable once a sheet with them is rendered. A character in the range of a
+// table CMAP fills its hole there, the game stops looking at the first CMAP
+// whose range has the character. The others go into the last scan CMAP, a
+// font's catch-all map usually is one, or a new scan CMAP. Returns the
+// characters that were added.
+func (b *BFFNT) AddChars(chars []rune) []rune {
+	b.Load()
+	if len(b.CWDHs) != 1 {
+		handleErr(fmt.Errorf("characters can only be added to fonts with a single CWDH, this one has %d", len(b.CWDHs)))
+	}
+
+	added := make([]rune, 0)
+	seen := make(map[rune]bool)
+	for _, char := range chars {
+		if char > 0xFFFF {
+			fmt.Printf("warning: %#U is outside the basic multilingual plane and can't be mapped\n", char)
+			continue
+		}
+		if _, found := b.CharIndex(char); found || seen[char] {
+			continue
+		}
+		seen[char] = true
+		added = append(added, char)
+	}
+	if len(added) == 0 {
+		return added
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+
+	cwdh := &b.CWDHs[0]
+	nextIndex := b.highestGlyphIndex() + 1
+	checkedUint16("glyph index", int64(nextIndex+len(added)-1))
+	defaultGlyph := glyphInfo{int8(b.FINF.DefaultLeftWidth), b.FINF.DefaultGlyphWidth, b.FINF.DefaultCharWidth}
+	for len(cwdh.Glyphs) < nextIndex+len(added) {
+		cwdh.Glyphs = append(cwdh.Glyphs, defaultGlyph)
+	}
+
+	scanned := make([]int, 0)
+	for i, char := range added {
+		if !b.fillTableHole(char, uint16(nextIndex+i)) {
+			scanned = append(scanned, i)
+		}
+	}
+	if len(scanned) == 0 {
+		b.invalidateCharIndex()
+		b.buildCWDHIndexMap()
+		return added
+	}
+
+	scanMap := -1
+	for i := range b.CMAPs {
+		if b.CMAPs[i].MappingMethod == 2 {
+			scanMap = i
+		}
+	}
+	if scanMap < 0 {
+		b.CMAPs = append(b.CMAPs, CMAP{
+			MagicHeader:   CMAP_MAGIC_HEADER,
+			CodeBegin:     uint16(added[scanned[0]]),
+			CodeEnd:       uint16(added[scanned[len(scanned)-1]]),
+			MappingMethod: 2,
+			log:           b.Log,
+		})
+		scanMap = len(b.CMAPs) - 1
+	}
+
+	cmap := &b.CMAPs[scanMap]
+	for _, i := range scanned {
+		char := added[i]
+		cmap.CharAscii = append(cmap.CharAscii, uint16(char))
+		cmap.CharIndex = append(cmap.CharIndex, uint16(nextIndex+i))
+		if uint16(char) < cmap.CodeBegin {
+			cmap.CodeBegin = uint16(char)
+		}
+		if uint16(char) > cmap.CodeEnd {
+			cmap.CodeEnd = uint16(char)
+		}
+	}
+	cmap.CharacterCount = checkedUint16("CMAP scan entry count", int64(len(cmap.CharAscii)))
+	cmap.sortScanEntries()
+
+	b.invalidateCharIndex()
+	b.buildCWDHIndexMap()
+
+	return added
+}
+
+// Maps char to index in the first table CMAP whose range has it. False when no
+// table CMAP has it in its range.
+func (b *BFFNT) fillTableHole(char rune, index uint16) bool {
+	for i := range b.CMAPs {
+		cmap := &b.CMAPs[i]
+		if cmap.MappingMethod != 1 || uint16(char) < cmap.CodeBegin || uint16(char) > cmap.CodeEnd {
+			continue
+		}
+		position := int(uint16(char) - cmap.CodeBegin)
+		if position >= len(cmap.CharIndex) {
+			return false
+		}
+		cmap.CharIndex[position] = index
+		return true
+	}
+
+	return false
+}
+
+// Splits chars into the ones the replacement font has a glyph for and the ones
+// it would draw as the missing glyph box. An empty fontName looks the
+// characters up as they are, without a BotW font's glyph mapping.
+func fontCoverage(fontFile string, fontName string, chars []rune) (covered []rune, missing []rune) {
+	replacements, missing := replacementChars(parseFontFile(fontFile), fontName, chars)
+	for _, char := range chars {
+		if _, exists := replacements[char]; exists {
+			covered = append(covered, char)
+		}
+	}
+
+	return covered, missing
+}
+
+// Takes the kerning between the characters of chars from the replacement
+// font, rendered the same size as the sheet. Only pairs with an added
+// character are touched, the original pairs were tuned by Nintendo. Returns
+// the amount of pairs added.
+func (b *BFFNT) kernAddedChars(fontName string, fontFile string, scale float64, chars []rune, added []rune) int {
+	fontSize := getBotwFontSettings(fontName, scale)
+	face, err := opentype.NewFace(parseFontFile(fontFile), &opentype.FaceOptions{
+		Size:    fontSize,
+		DPI:     144,
+		Hinting: font.HintingFull,
+	})
+	handleErr(err)
+	defer face.Close()
+
+	isAdded := make(map[rune]bool, len(added))
+	for _, char := range added {
+		isAdded[char] = true
+	}
+
+	b.KRNG.load()
+	if b.KRNG.KerningTable == nil {
+		b.KRNG.KerningTable = make(map[uint16][]kerningPair)
+	}
+	var clamped clampedMetrics
+	pairCount := 0
+	for _, first := range chars {
+		if _, found := b.CharIndex(first); !found {
+			continue
+		}
+		for _, second := range chars {
+			if !isAdded[first] && !isAdded[second] {
+				continue
+			}
+			if _, found := b.CharIndex(second); !found {
+				continue
+			}
+			kern := face.Kern(rune(asciiToGlyph(fontName, uint16(first))), rune(asciiToGlyph(fontName, uint16(second))))
+			value := kern.Round()
+			if value == 0 {
+				continue
+			}
+			kerning, fits := clampToKerning(value)
+			if !fits {
+				clamped.add("kerning %s %s %d", formatChar(uint16(first)), formatChar(uint16(second)), value)
+			}
+			b.KRNG.KerningTable[uint16(first)] = append(b.KRNG.KerningTable[uint16(first)], kerningPair{uint16(second), kerning})
+			pairCount++
+		}
+	}
+	warnClampedMetrics("in the kerning of the added characters", clamped)
+
+	// the game looks the second character up with a binary search
+	for first, pairs := range b.KRNG.KerningTable {
+		sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].SecondChar < pairs[j].SecondChar })
+		b.KRNG.KerningTable[first] = pairs
+	}
+
+	return pairCount
+}
+
+func extendCommand(args []string) {
+	flags := newCommandFlagSet("extend", "[flags] extend.yaml")
+	verify := flags.Bool("verify", true, "re-decode the written bffnt and fail if it doesn't match what was encoded")
+	writeLock := flags.Bool("lock", false, "write the hashes of the build's inputs and outputs to the lock file next to the config, e.g. extend.lock.yaml")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		exitWithUsage(flags)
+	}
+
+	config := readExtendConfig(flags.Arg(0))
+	initializeGlyphMaps()
+	if config.GlyphMap != "" {
+		glyphMapFiles[config.Font] = readGlyphMap(config.GlyphMap)
+	}
+	scale := resolveScale(config.Target, config.Scale)
+	fontFile := withFontAxes(withFaceIndex(resolveFontFile(config.Font, config.TTF), config.TTFIndex), config.Axes)
+
+	charset, err := config.chars()
+	handleErr(err)
+	for _, warning := range charset.Warnings {
+		fmt.Println("warning:", warning)
+	}
+	if len(charset.Chars) == 0 {
+		handleErr(fmt.Errorf("%s: no scripts or charsets to add", flags.Arg(0)))
+	}
+
+	covered, missing := fontCoverage(fontFile, config.Font, charset.Chars)
+	if len(missing) > 0 {
+		fmt.Printf("warning: %s has no glyph for %d character(s), they are skipped: %s\n", fontFile, len(missing), string(missing))
+	}
+
+	// a locked build only runs with the inputs it was locked with
+	lockFile := extendLockFile(flags.Arg(0))
+	lock, locked := readExtendLock(lockFile)
+	inputs := config.inputHashes(fontFile, filepath.Dir(flags.Arg(0)))
+	if locked && !*writeLock {
+		if changed := changedHashes(lock.Inputs, inputs); len(changed) > 0 {
+			handleErr(fmt.Errorf("%s: %d input(s) changed since the build was locked: %s. Run with -lock to accept them",
+				lockFile, len(changed), strings.Join(changed, ", ")))
+		}
+	}
+
+	var rules []AdjustmentRule
+	if config.Rules != "" {
+		rules = readAdjustmentRules(config.Rules)
+	}
+	var adjustments map[rune]GlyphAdjustment
+	if config.Adjustments != "" {
+		adjustments = readGlyphAdjustments(config.Adjustments).forFont(config.Font, scale)
+	}
+
+	if config.Output != "" {
+		handleErr(os.MkdirAll(config.Output, 0755))
+	}
+	upscaleBffnt(config.Font, fontFile, scale, upscaleOptions{
+		bffntFile:   config.Bffnt,
+		outputDir:   config.Output,
+		addChars:    covered,
+		kernAdded:   config.Kerning,
+		autoFit:     config.AutoFit,
+		rules:       rules,
+		adjustments: adjustments,
+		platform:    config.Platform,
+
+		leftoverPolicy: flags.leftoverPolicy,
+		threads:        flags.threads,
+
+		fontFeatures: config.Features,
+		overrides:    config.Overrides,
+		verify:       *verify,
+
+		kerningProfiles: config.KerningProfiles,
+		kerningProfile:  config.KerningProfile,
+	})
+
+	outputs := config.outputHashes(scale)
+	switch {
+	case *writeLock:
+		ExtendLock{Inputs: inputs, Outputs: outputs}.write(lockFile)
+		fmt.Println("wrote", lockFile)
+	case locked:
+		if changed := changedHashes(lock.Outputs, outputs); len(changed) > 0 {
+			handleErr(fmt.Errorf("%s: the same inputs built different output: %s. The tool or its dependencies changed",
+				lockFile, strings.Join(changed, ", ")))
+		}
+		fmt.Println("the build matches", lockFile)
+	}
+}
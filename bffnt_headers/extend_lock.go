@@ -0,0 +1,136 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A lock file sits next to an extend config like go.sum next to go.mod. It
+// has the md5 of every file a build reads and of the files it writes, so a run
+// months later or on another machine can tell whether it built the same
+// bytes, and if not whether an input or the tool changed. Inputs are keyed by
+// what they are for rather than their path, an installed font found by name
+// lives somewhere else on every machine.
+type ExtendLock struct {
+	Inputs  map[string]string `yaml:"inputs"`
+	Outputs map[string]string `yaml:"outputs"` // file name in the output directory -> hash
+}
+
+// extend.yaml is locked by extend.lock.yaml
+func extendLockFile(configFile string) string {
+	return strings.TrimSuffix(configFile, filepath.Ext(configFile)) + ".lock.yaml"
+}
+
+func readExtendLock(filename string) (ExtendLock, bool) {
+	raw, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return ExtendLock{}, false
+	}
+	handleErr(err)
+
+	var lock ExtendLock
+	handleErr(yaml.Unmarshal(raw, &lock))
+	return lock, true
+}
+
+func (lock ExtendLock) write(filename string) {
+	raw, err := yaml.Marshal(lock)
+	handleErr(err)
+	header := "# written by `bffnt extend -lock`, the md5 of every input and output of the build\n"
+	handleErr(ioutil.WriteFile(filename, append([]byte(header), raw...), 0644))
+}
+
+func fileMD5(filename string) string {
+	raw, err := ioutil.ReadFile(filename)
+	handleErr(err)
+	return md5String(raw)
+}
+
+// Hashes every file the config makes a build read. fontFile is the resolved
+// replacement font, configDir the directory paths in the lock are relative to.
+func (config ExtendConfig) inputHashes(fontFile string, configDir string) map[string]string {
+	hashes := make(map[string]string)
+	bffntFile := config.Bffnt
+	if bffntFile == "" {
+		bffntFile = fmt.Sprintf("./WiiU_fonts/botw/%[1]s/%[1]s_00.bffnt", config.Font)
+	}
+	hashes["bffnt"] = fileMD5(bffntFile)
+	fontFile, _ = splitFaceIndex(fontFile)
+	hashes["ttf"] = fileMD5(fontFile)
+
+	for key, filename := range map[string]string{
+		"glyph_map":        config.GlyphMap,
+		"kerning_profiles": config.KerningProfiles,
+		"adjustments":      config.Adjustments,
+		"rules":            config.Rules,
+	} {
+		if filename != "" {
+			hashes[key] = fileMD5(filename)
+		}
+	}
+
+	relative := func(path string) string {
+		if rel, err := filepath.Rel(configDir, path); err == nil {
+			path = rel
+		}
+		return filepath.ToSlash(path)
+	}
+	for _, filename := range config.Charsets {
+		hashes["charsets/"+relative(filename)] = fileMD5(filename)
+	}
+	if config.KerningProfiles != "" {
+		profiles := readKerningProfiles(config.KerningProfiles)
+		for _, name := range profiles.names() {
+			if profile := profiles.Profiles[name]; profile.Import != "" {
+				filename := profiles.importFile(profile)
+				hashes["kerning_imports/"+relative(filename)] = fileMD5(filename)
+			}
+		}
+	}
+	if config.Overrides != "" {
+		entries, err := ioutil.ReadDir(config.Overrides)
+		handleErr(err)
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				hashes["overrides/"+entry.Name()] = fileMD5(filepath.Join(config.Overrides, entry.Name()))
+			}
+		}
+	}
+
+	return hashes
+}
+
+// The files upscaleBffnt writes for the config
+func (config ExtendConfig) outputHashes(scale float64) map[string]string {
+	hashes := make(map[string]string)
+	for _, name := range []string{
+		fmt.Sprintf("%s_00_%.2fx_template.bffnt", config.Font, scale),
+		fmt.Sprintf("%s_00_%.2fx.png", config.Font, scale),
+	} {
+		hashes[name] = fileMD5(filepath.Join(config.Output, name))
+	}
+	return hashes
+}
+
+// Keys whose hashes differ, including ones only one side has
+func changedHashes(locked map[string]string, current map[string]string) []string {
+	changed := make([]string, 0)
+	for key, hash := range current {
+		if locked[key] != hash {
+			changed = append(changed, key)
+		}
+	}
+	for key := range locked {
+		if _, exists := current[key]; !exists {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -1,10 +1,7 @@
 package bffnt_headers
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/binary"
-	"fmt"
 )
 
 type FFNT struct { //       Offset  Size  Description
@@ -21,6 +18,8 @@ type FFNT struct { //       Offset  Size  Description
 	// This means that a small block read size might result in slower font
 	// being printed to the screen. Perhaps it is ok to change this number
 	// around. Change this bit and see if botw crashes.
+
+	log *Logger // the font's logger, see BFFNT.Log
 }
 
 func (ffnt *FFNT) Decode(raw []byte) {
@@ -36,27 +35,22 @@ func (ffnt *FFNT) Decode(raw []byte) {
 	ffnt.TotalFileSize = binary.BigEndian.Uint32(headerRaw[12:16])
 	ffnt.BlockReadNum = binary.BigEndian.Uint32(headerRaw[16:FFNT_HEADER_SIZE])
 
-	if Debug {
-		pprint(ffnt)
-		fmt.Printf("Read section total of %d bytes\n", headerEnd-headerStart)
-		fmt.Println("Byte offsets start(inclusive) to end(exclusive)================")
-		fmt.Printf("header %d(inclusive) to %d(exclusive)\n", headerStart, headerEnd)
-		fmt.Println()
+	if ffnt.log.debugging() {
+		ffnt.log.debugJSON(ffnt)
+		ffnt.log.debugf("Read section total of %d bytes\n", headerEnd-headerStart)
+		ffnt.log.debugln("Byte offsets start(inclusive) to end(exclusive)================")
+		ffnt.log.debugf("header %d(inclusive) to %d(exclusive)\n", headerStart, headerEnd)
+		ffnt.log.debugln()
 	}
 }
 
 func (ffnt *FFNT) Encode(totalFileSize uint32) []byte {
-	var buf bytes.Buffer
-	w := bufio.NewWriter(&buf)
-
-	_, _ = w.Write([]byte(ffnt.MagicHeader))
-	binaryWrite(w, ffnt.Endianness)
-	binaryWrite(w, ffnt.SectionSize)
-	binaryWrite(w, ffnt.Version)
-	binaryWrite(w, totalFileSize)
-	binaryWrite(w, ffnt.BlockReadNum)
-	w.Flush()
-
-	assertEqual(FFNT_HEADER_SIZE, len(buf.Bytes()))
-	return buf.Bytes()
+	w := newSectionWriter("FFNT", FFNT_HEADER_SIZE)
+	w.magic(ffnt.MagicHeader)
+	w.u16(ffnt.Endianness)
+	w.u16(ffnt.SectionSize)
+	w.u32(ffnt.Version)
+	w.u32(totalFileSize)
+	w.u32(ffnt.BlockReadNum)
+	return w.mustBytes()
 }
@@ -1,11 +1,10 @@
 package bffnt_headers
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"math"
+	"strconv"
 )
 
 type FINF struct { //  Offset  Size  Description
@@ -24,6 +23,8 @@ type FINF struct { //  Offset  Size  Description
 	TGLPOffset        uint32 // 0x14    0x04  TGLP Offset
 	CWDHOffset        uint32 // 0x18    0x04  CWDH Offset
 	CMAPOffset        uint32 // 0x1C    0x04  CMAP Offset
+
+	log *Logger // the font's logger, see BFFNT.Log
 }
 
 // Version 4 (BFFNT)
@@ -49,53 +50,110 @@ func (finf *FINF) Decode(raw []byte) {
 	finf.CWDHOffset = binary.BigEndian.Uint32(headerRaw[24:28])
 	finf.CMAPOffset = binary.BigEndian.Uint32(headerRaw[28:FINF_HEADER_SIZE])
 
-	if Debug {
-		pprint(finf)
-		fmt.Printf("Read section total of %d bytes\n", headerEnd-headerStart)
-		fmt.Println("Byte offsets start(inclusive) to end(exclusive)================")
-		fmt.Printf("header %d(inclusive) to %d(exclusive)\n", headerStart, headerEnd)
-		fmt.Println()
+	if finf.log.debugging() {
+		finf.log.debugJSON(finf)
+		finf.log.debugf("Read section total of %d bytes\n", headerEnd-headerStart)
+		finf.log.debugln("Byte offsets start(inclusive) to end(exclusive)================")
+		finf.log.debugf("header %d(inclusive) to %d(exclusive)\n", headerStart, headerEnd)
+		finf.log.debugln()
 	}
 }
 
 func (finf *FINF) Encode(tglpOffset int, cwdhOffset int, cmapOffset int) []byte {
-	var buf bytes.Buffer
-	w := bufio.NewWriter(&buf)
-
-	finf.TGLPOffset = uint32(tglpOffset)
-	finf.CWDHOffset = uint32(cwdhOffset)
-	finf.CMAPOffset = uint32(cmapOffset)
-
-	_, _ = w.Write([]byte(finf.MagicHeader))
-	binaryWrite(w, finf.SectionSize)
-	binaryWrite(w, finf.FontType)
-	binaryWrite(w, finf.Height)
-	binaryWrite(w, finf.Width)
-	binaryWrite(w, finf.Ascent)
-	binaryWrite(w, finf.LineFeed)
-	binaryWrite(w, finf.AlterCharIndex)
-	binaryWrite(w, finf.DefaultLeftWidth)
-	binaryWrite(w, finf.DefaultGlyphWidth)
-	binaryWrite(w, finf.DefaultCharWidth)
-	binaryWrite(w, finf.Encoding)
-	binaryWrite(w, finf.TGLPOffset)
-	binaryWrite(w, finf.CWDHOffset)
-	binaryWrite(w, finf.CMAPOffset)
-	w.Flush()
+	finf.TGLPOffset = checkedUint32("FINF TGLP offset", int64(tglpOffset))
+	finf.CWDHOffset = checkedUint32("FINF CWDH offset", int64(cwdhOffset))
+	finf.CMAPOffset = checkedUint32("FINF CMAP offset", int64(cmapOffset))
 
-	assertEqual(FINF_HEADER_SIZE, len(buf.Bytes()))
-	return buf.Bytes()
+	w := newSectionWriter("FINF", FINF_HEADER_SIZE)
+	w.magic(finf.MagicHeader)
+	w.u32(finf.SectionSize)
+	w.u8(finf.FontType)
+	w.u8(finf.Height)
+	w.u8(finf.Width)
+	w.u8(finf.Ascent)
+	w.u16(finf.LineFeed)
+	w.u16(finf.AlterCharIndex)
+	w.u8(finf.DefaultLeftWidth)
+	w.u8(finf.DefaultGlyphWidth)
+	w.u8(finf.DefaultCharWidth)
+	w.u8(finf.Encoding)
+	w.u32(finf.TGLPOffset)
+	w.u32(finf.CWDHOffset)
+	w.u32(finf.CMAPOffset)
+	return w.mustBytes()
 }
 
 // Characters have a theorical maximum size of 256 pixels becuase some
 // attributes are defined with a uint8. A uint8's maxmum size is 256.
+// AlterCharIndex is a glyph index, not a metric, so it is left alone.
 func (finf *FINF) Upscale(scale float64) {
 	finf.Height = uint8(math.Ceil(float64(finf.Height) * scale))
 	finf.Width = uint8(math.Ceil(float64(finf.Width) * scale))
-	finf.Ascent = uint8(math.Ceil(float64(finf.Ascent) * scale))
-	finf.LineFeed = uint16(math.Ceil(float64(finf.LineFeed) * scale))
-	finf.AlterCharIndex = uint16(math.Ceil(float64(finf.AlterCharIndex) * scale))
-	finf.DefaultLeftWidth = uint8(math.Ceil(float64(finf.DefaultLeftWidth) * scale))
-	finf.DefaultGlyphWidth = uint8(math.Ceil(float64(finf.DefaultGlyphWidth) * scale))
-	finf.DefaultCharWidth = uint8(math.Ceil(float64(finf.DefaultCharWidth) * scale))
+	finf.Ascent = uint8(scaleMetric(float64(finf.Ascent), scale))
+	finf.LineFeed = uint16(scaleMetric(float64(finf.LineFeed), scale))
+	// the default LeftWidth is signed like every other one
+	var clamped clampedMetrics
+	leftWidth := int(scaleMetric(float64(int8(finf.DefaultLeftWidth)), scale))
+	glyphWidth := int(math.Ceil(float64(finf.DefaultGlyphWidth) * scale))
+	charWidth := int(scaleMetric(float64(finf.DefaultCharWidth), scale))
+	defaultLeftWidth, fits := clampToLeftWidth(leftWidth)
+	if !fits {
+		clamped.add("default LeftWidth %d", leftWidth)
+	}
+	finf.DefaultLeftWidth = uint8(defaultLeftWidth)
+	if finf.DefaultGlyphWidth, fits = clampToGlyphWidth(glyphWidth); !fits {
+		clamped.add("default GlyphWidth %d", glyphWidth)
+	}
+	if finf.DefaultCharWidth, fits = clampToCharWidth(charWidth); !fits {
+		clamped.add("default CharWidth %d", charWidth)
+	}
+	warnClampedMetrics(fmt.Sprintf("after scaling the font info by %v", scale), clamped)
+}
+
+// Vertical spacing in menus is sensitive to the line feed so it can be scaled
+// with its own policy: "ceil", "floor", "round" or an explicit line feed in
+// pixels. originalLineFeed is the line feed before scaling.
+func (finf *FINF) ScaleLineFeed(originalLineFeed uint16, scale float64, policy string) {
+	lineFeed, err := scaleByPolicy(int(originalLineFeed), scale, policy, math.MaxUint16)
+	if err != nil {
+		handleErr(fmt.Errorf("line feed: %v", err))
+	}
+	finf.LineFeed = uint16(lineFeed)
+}
+
+// Same for the font height, which is what the game sizes text boxes with.
+// Upscale always rounds it up like the other metrics.
+func (finf *FINF) ScaleHeight(originalHeight uint8, scale float64, policy string) {
+	height, err := scaleByPolicy(int(originalHeight), scale, policy, math.MaxUint8)
+	if err != nil {
+		handleErr(fmt.Errorf("height: %v", err))
+	}
+	finf.Height = uint8(height)
+}
+
+// original*scale rounded as the policy says, or the explicit value it is.
+// Results that don't fit under max are an error, there's no sensible value to
+// clamp an explicit one to.
+func scaleByPolicy(original int, scale float64, policy string, max int) (int, error) {
+	scaled := float64(original) * scale
+	var value int
+	switch policy {
+	case "ceil":
+		value = int(math.Ceil(scaled))
+	case "floor":
+		value = int(math.Floor(scaled))
+	case "round":
+		value = int(math.Round(scaled))
+	default:
+		explicit, err := strconv.Atoi(policy)
+		if err != nil || explicit < 0 {
+			return 0, fmt.Errorf("unknown policy %q. Use ceil, floor, round or a number", policy)
+		}
+		value = explicit
+	}
+	if value > max {
+		return 0, fmt.Errorf("%d is more than the maximum of %d", value, max)
+	}
+
+	return value, nil
 }
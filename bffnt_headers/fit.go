@@ -0,0 +1,167 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Strings that have to fit their text box, e.g. menu labels, with the widest
+// they may get in the pixels `bffnt measure` reports for the upscaled font
+type FitConstraint struct {
+	Text     string `yaml:"text"`
+	MaxWidth int    `yaml:"max_width"`
+}
+
+type FitConfig struct {
+	Strings []FitConstraint `yaml:"strings"`
+}
+
+func readFitConfig(filename string) FitConfig {
+	raw, err := ioutil.ReadFile(filename)
+	handleErr(err)
+
+	var config FitConfig
+	handleErr(yaml.Unmarshal(raw, &config))
+	if len(config.Strings) == 0 {
+		handleErr(fmt.Errorf("%s: no strings to fit", filename))
+	}
+	for _, constraint := range config.Strings {
+		if constraint.MaxWidth <= 0 {
+			handleErr(fmt.Errorf("%s: %s needs a max_width", filename, strconv.Quote(constraint.Text)))
+		}
+	}
+
+	return config
+}
+
+// A copy of the font with its widths and kerning scaled the way an upscale
+// run scales them before any glyph is rendered. The CMAPs are shared.
+func (b *BFFNT) scaledMetrics(scale float64) *BFFNT {
+	b.loadCWDHs()
+	b.KRNG.load()
+	scaled := &BFFNT{FINF: b.FINF, CMAPs: b.CMAPs, charIndexes: b.charIndexes, runesByIndex: b.runesByIndex}
+	for _, cwdh := range b.CWDHs {
+		cwdh.Glyphs = append([]glyphInfo(nil), cwdh.Glyphs...)
+		cwdh.Upscale(scale)
+		scaled.CWDHs = append(scaled.CWDHs, cwdh)
+	}
+	scaled.KRNG.KerningTable = make(map[uint16][]kerningPair, len(b.KRNG.KerningTable))
+	for first, pairs := range b.KRNG.KerningTable {
+		scaled.KRNG.KerningTable[first] = append([]kerningPair(nil), pairs...)
+	}
+	scaled.KRNG.Upscale(scale)
+
+	return scaled
+}
+
+// The most tracking (up to maxTracking) every string still fits with, and
+// the string that leaves the least room. Tracking is added to every character
+// with an advance, see CWDH.AdjustCharWidths.
+func fitTracking(b *BFFNT, constraints []FitConstraint, maxTracking int) (tracking int, tightest int) {
+	tightestFits := math.MaxInt32
+	for i, constraint := range constraints {
+		measurement := b.MeasureText(constraint.Text)
+		advancing := 0
+		for _, char := range measurement.chars {
+			if char.glyph.CharWidth > 0 {
+				advancing++
+			}
+		}
+
+		// the tracking this string fits with, any if nothing advances
+		room := constraint.MaxWidth - measurement.width
+		fits := math.MaxInt32 - 1
+		if advancing > 0 {
+			fits = int(math.Floor(float64(room) / float64(advancing)))
+		} else if room < 0 {
+			fits = math.MinInt32
+		}
+		if fits < tightestFits {
+			tightestFits, tightest = fits, i
+		}
+	}
+
+	if tightestFits > maxTracking {
+		return maxTracking, tightest
+	}
+	return tightestFits, tightest
+}
+
+type fitResult struct {
+	scale    float64
+	tracking int
+	tightest int // index of the string with the least room
+}
+
+// Tries scales from maxScale down in steps and returns the first one every
+// string fits at with tracking no tighter than minTracking
+func (b *BFFNT) FitScale(constraints []FitConstraint, minScale float64, maxScale float64, step float64, minTracking int, maxTracking int) (fitResult, bool) {
+	if step <= 0 || minScale <= 0 || minScale > maxScale || minTracking > maxTracking {
+		handleErr(fmt.Errorf("scale range %g to %g in steps of %g with tracking %d to %d is empty", minScale, maxScale, step, minTracking, maxTracking))
+	}
+
+	steps := int(math.Floor((maxScale-minScale)/step + 1e-9))
+	for i := 0; i <= steps; i++ {
+		// rounded so the steps don't pick up float noise, 2.35 instead of 2.3499999
+		scale := math.Round((maxScale-float64(i)*step)*1000) / 1000
+		tracking, tightest := fitTracking(b.scaledMetrics(scale), constraints, maxTracking)
+		if tracking >= minTracking {
+			return fitResult{scale, tracking, tightest}, true
+		}
+	}
+
+	return fitResult{}, false
+}
+
+// Finds the largest scale (and the tracking it needs) at which every string
+// of a fit.yaml fits its maximum width
+func fitCommand(args []string) {
+	flags := newCommandFlagSet("fit", "[flags] font.bffnt fit.yaml")
+	minScale := flags.Float64("min-scale", 1, "smallest scale to try")
+	maxScale := flags.Float64("max-scale", 4, "largest scale to try")
+	step := flags.Float64("step", 0.05, "steps the scale is lowered in")
+	minTracking := flags.Int("min-tracking", -3, "tightest tracking to allow, in upscaled pixels")
+	maxTracking := flags.Int("max-tracking", 0, "loosest tracking to suggest")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		exitWithUsage(flags)
+	}
+
+	bffnt := flags.readBffnt(flags.Arg(0))
+	config := readFitConfig(flags.Arg(1))
+
+	result, found := bffnt.FitScale(config.Strings, *minScale, *maxScale, *step, *minTracking, *maxTracking)
+	if !found {
+		// show how far off the strings are at the smallest scale
+		scaled := bffnt.scaledMetrics(*minScale)
+		fmt.Printf("no scale from %g to %g fits every string with tracking down to %d. At scale %g without tracking:\n", *minScale, *maxScale, *minTracking, *minScale)
+		for _, constraint := range config.Strings {
+			width := scaled.MeasureText(constraint.Text).width
+			if width > constraint.MaxWidth {
+				fmt.Printf("  %s: %d px, %d over its %d px\n", strconv.Quote(constraint.Text), width, width-constraint.MaxWidth, constraint.MaxWidth)
+			}
+		}
+		exit(1)
+		return
+	}
+
+	scaled := bffnt.scaledMetrics(result.scale)
+	for i := range scaled.CWDHs {
+		scaled.CWDHs[i].AdjustCharWidths(result.tracking)
+	}
+	fmt.Printf("largest scale: %g with tracking %d\n", result.scale, result.tracking)
+	for i, constraint := range config.Strings {
+		marker := ""
+		if i == result.tightest {
+			marker = "  <- tightest"
+		}
+		width := scaled.MeasureText(constraint.Text).width
+		fmt.Printf("  %s: %d of %d px%s\n", strconv.Quote(constraint.Text), width, constraint.MaxWidth, marker)
+	}
+	fmt.Printf("upscale with -scale %g -tracking %d. Widths are the scaled original ones, -autofit and -bold change them\n", result.scale, result.tracking)
+}
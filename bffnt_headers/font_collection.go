@@ -0,0 +1,208 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+)
+
+// TrueType and OpenType collections (.ttc, .otc) hold several faces, e.g.
+// the weights of a system font. The face is picked by its index, written
+// after the file as font.ttc#2 so the font file stays a single string
+// everywhere it's passed around and cached. Without an index the first face
+// is used.
+const FONT_FACE_SEPARATOR = "#"
+
+func withFaceIndex(fontFile string, faceIndex int) string {
+	if faceIndex == 0 {
+		return fontFile
+	}
+	return fontFile + FONT_FACE_SEPARATOR + strconv.Itoa(faceIndex)
+}
+
+func splitFaceIndex(fontFile string) (string, int) {
+	fontFile, _ = splitFontAxes(fontFile)
+	separator := strings.LastIndex(fontFile, FONT_FACE_SEPARATOR)
+	if separator < 0 {
+		return fontFile, 0
+	}
+	faceIndex, err := strconv.Atoi(fontFile[separator+1:])
+	if err != nil || faceIndex < 0 {
+		// a # that's part of the file name
+		return fontFile, 0
+	}
+	return fontFile[:separator], faceIndex
+}
+
+// An axis of a variable font from its fvar table, e.g. wght from 100 to 900
+type fontAxis struct {
+	tag                    string
+	min, defaultValue, max float64
+}
+
+// Raw table of a face, nil if the face doesn't have it. sfnt.Font doesn't
+// hand out tables it doesn't use itself.
+func fontTable(dat []byte, faceIndex int, tag string) []byte {
+	offset := 0
+	if len(dat) >= 12 && string(dat[0:4]) == "ttcf" {
+		if 12+4*faceIndex+4 > len(dat) {
+			return nil
+		}
+		offset = int(binary.BigEndian.Uint32(dat[12+4*faceIndex:]))
+	}
+	if offset+12 > len(dat) {
+		return nil
+	}
+	numTables := int(binary.BigEndian.Uint16(dat[offset+4:]))
+	for i := 0; i < numTables; i++ {
+		record := offset + 12 + 16*i
+		if record+16 > len(dat) {
+			return nil
+		}
+		if string(dat[record:record+4]) != tag {
+			continue
+		}
+		start := int(binary.BigEndian.Uint32(dat[record+8:]))
+		end := start + int(binary.BigEndian.Uint32(dat[record+12:]))
+		if start > end || end > len(dat) {
+			return nil
+		}
+		return dat[start:end]
+	}
+	return nil
+}
+
+func parseFontAxes(fvar []byte) []fontAxis {
+	if len(fvar) < 16 {
+		return nil
+	}
+	axesOffset := int(binary.BigEndian.Uint16(fvar[4:]))
+	axisCount := int(binary.BigEndian.Uint16(fvar[8:]))
+	axisSize := int(binary.BigEndian.Uint16(fvar[10:]))
+	fixed := func(raw []byte) float64 {
+		return float64(int32(binary.BigEndian.Uint32(raw))) / 65536
+	}
+
+	axes := make([]fontAxis, 0, axisCount)
+	for i := 0; i < axisCount; i++ {
+		record := axesOffset + i*axisSize
+		if axisSize < 16 || record+16 > len(fvar) {
+			break
+		}
+		axes = append(axes, fontAxis{
+			tag:          string(fvar[record : record+4]),
+			min:          fixed(fvar[record+4:]),
+			defaultValue: fixed(fvar[record+8:]),
+			max:          fixed(fvar[record+12:]),
+		})
+	}
+	return axes
+}
+
+func (axis fontAxis) String() string {
+	return fmt.Sprintf("%s %g to %g, default %g", axis.tag, axis.min, axis.max, axis.defaultValue)
+}
+
+// Variable fonts without axis values are drawn at their default instance,
+// which is easy to miss when another weight was meant. The axes come with the
+// parsed font, the warning is printed once per font file.
+func warnVariableFont(fontFile string) {
+	parsed := loadFontFile(fontFile)
+	parsedFonts.Lock()
+	defer parsedFonts.Unlock()
+	if len(parsed.axes) > 0 && parsed.variation == nil && !parsed.warned {
+		parsed.warned = true
+		fmt.Printf("warning: %s is a variable font, it's drawn at its default instance (%v). Pick axis values with -axes\n", fontFile, parsed.axes)
+	}
+}
+
+// A parsed face and the axes of its fvar table, the table is read while the
+// file is at hand since sfnt.Font doesn't keep it. Axis values after the file
+// come with the variation to draw the glyphs with, see FONT_AXES_SEPARATOR.
+type parsedFont struct {
+	font      *sfnt.Font
+	axes      []fontAxis
+	variation *fontVariation // nil draws the default instance
+	warned    bool           // warnVariableFont was printed
+}
+
+// Parsed fonts by file and face. A run writing several fonts or scales parses
+// each font file once, the parsed font is read only so every worker and every
+// upscale can share it.
+var parsedFonts = struct {
+	sync.Mutex
+	fonts map[string]*parsedFont
+}{fonts: make(map[string]*parsedFont)}
+
+func parseFontFile(fontFile string) *sfnt.Font {
+	return loadFontFile(fontFile).font
+}
+
+func loadFontFile(fontFile string) *parsedFont {
+	parsedFonts.Lock()
+	defer parsedFonts.Unlock()
+	if parsed, exists := parsedFonts.fonts[fontFile]; exists {
+		return parsed
+	}
+
+	path, faceIndex := splitFaceIndex(fontFile)
+	dat, err := os.ReadFile(path)
+	handleErr(err)
+
+	// single fonts parse as a collection of one
+	collection, err := opentype.ParseCollection(dat)
+	handleErr(err)
+	if faceIndex >= collection.NumFonts() {
+		handleErr(fmt.Errorf("%s has %d face(s), there's no face %d", path, collection.NumFonts(), faceIndex))
+	}
+	f, err := collection.Font(faceIndex)
+	handleErr(err)
+	parsed := &parsedFont{font: f, axes: parseFontAxes(fontTable(dat, faceIndex, "fvar"))}
+	if _, axes := splitFontAxes(fontFile); axes != "" {
+		parsed.variation, err = newFontVariation(dat, faceIndex, parsed.axes, axes)
+		if err != nil {
+			handleErr(fmt.Errorf("%s: %v", fontFile, err))
+		}
+	}
+	parsedFonts.fonts[fontFile] = parsed
+
+	return parsed
+}
+
+// Lists the faces of a font file with their names, to find the index to use
+func fontFacesCommand(args []string) {
+	flags := newCommandFlagSet("faces", "font.ttc")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		exitWithUsage(flags)
+	}
+
+	dat, err := os.ReadFile(flags.Arg(0))
+	handleErr(err)
+	collection, err := opentype.ParseCollection(dat)
+	handleErr(err)
+	for i := 0; i < collection.NumFonts(); i++ {
+		f, err := collection.Font(i)
+		handleErr(err)
+		// PostScript names are plain ASCII, full names of Japanese fonts
+		// often aren't decoded right
+		name, err := f.Name(nil, sfnt.NameIDPostScript)
+		if err != nil {
+			name, err = f.Name(nil, sfnt.NameIDFull)
+		}
+		if err != nil {
+			name = "(no name)"
+		}
+		fmt.Printf("%d: %s, %d glyphs\n", i, name, f.NumGlyphs())
+		for _, axis := range parseFontAxes(fontTable(dat, i, "fvar")) {
+			fmt.Println("   variable axis", axis)
+		}
+	}
+}
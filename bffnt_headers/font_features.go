@@ -0,0 +1,340 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// OpenType features swap glyphs for stylistic variants, e.g. the single
+// story a of a stylistic set to match Nintendo's. Features are given as
+//
+//	smcp,ss01,salt=2    tags of the font's GSUB features, a number picks
+//	                    the alternate of features with several (1 is the
+//	                    first)
+//
+// Every character is drawn on its own so only the substitutions that don't
+// depend on the neighbours apply: single (type 1) and alternate (type 3)
+// lookups. Features of every script and language are used.
+const (
+	GSUB_SINGLE    = 1
+	GSUB_ALTERNATE = 3
+	GSUB_EXTENSION = 7
+)
+
+type fontFeature struct {
+	tag       string
+	alternate int // 1 based, see the format above
+}
+
+func parseFontFeatures(spec string) ([]fontFeature, error) {
+	var features []fontFeature
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		feature := fontFeature{tag: part, alternate: 1}
+		if separator := strings.Index(part, "="); separator >= 0 {
+			alternate, err := strconv.Atoi(part[separator+1:])
+			if err != nil || alternate < 1 {
+				return nil, fmt.Errorf("font feature %q: the alternate has to be a number from 1", part)
+			}
+			feature = fontFeature{tag: part[:separator], alternate: alternate}
+		}
+		if len(feature.tag) != 4 {
+			return nil, fmt.Errorf("font feature %q: tags have 4 characters, like smcp or ss01", part)
+		}
+		features = append(features, feature)
+	}
+	return features, nil
+}
+
+// Offsets are bounds checked once here so a broken table can't panic
+type otReader []byte
+
+func (table otReader) u8(offset int) int {
+	if offset < 0 || offset >= len(table) {
+		return 0
+	}
+	return int(table[offset])
+}
+
+func (table otReader) u16(offset int) int {
+	if offset < 0 || offset+2 > len(table) {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(table[offset:]))
+}
+
+func (table otReader) u32(offset int) int {
+	if offset < 0 || offset+4 > len(table) {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(table[offset:]))
+}
+
+func (table otReader) from(offset int) otReader {
+	if offset < 0 || offset > len(table) {
+		return nil
+	}
+	return table[offset:]
+}
+
+// Glyphs of a coverage table in coverage index order
+func (table otReader) coverage() []sfnt.GlyphIndex {
+	var glyphs []sfnt.GlyphIndex
+	switch table.u16(0) {
+	case 1:
+		for i := 0; i < table.u16(2); i++ {
+			glyphs = append(glyphs, sfnt.GlyphIndex(table.u16(4+2*i)))
+		}
+	case 2:
+		for i := 0; i < table.u16(2); i++ {
+			record := 4 + 6*i
+			for glyph := table.u16(record); glyph <= table.u16(record+2); glyph++ {
+				glyphs = append(glyphs, sfnt.GlyphIndex(glyph))
+			}
+		}
+	}
+	return glyphs
+}
+
+// Adds the substitutions of a lookup subtable to subs
+func (table otReader) substitutions(lookupType int, alternate int, subs map[sfnt.GlyphIndex]sfnt.GlyphIndex) {
+	if lookupType == GSUB_EXTENSION {
+		table.from(table.u32(4)).substitutions(table.u16(2), alternate, subs)
+		return
+	}
+
+	covered := table.from(table.u16(2)).coverage()
+	for i, glyph := range covered {
+		switch {
+		case lookupType == GSUB_SINGLE && table.u16(0) == 1:
+			subs[glyph] = sfnt.GlyphIndex(uint16(int(glyph) + int(int16(table.u16(4)))))
+		case lookupType == GSUB_SINGLE && table.u16(0) == 2:
+			if i < table.u16(4) {
+				subs[glyph] = sfnt.GlyphIndex(table.u16(6 + 2*i))
+			}
+		case lookupType == GSUB_ALTERNATE && i < table.u16(4):
+			set := table.from(table.u16(6 + 2*i))
+			if alternate <= set.u16(0) {
+				subs[glyph] = sfnt.GlyphIndex(set.u16(2 + 2*(alternate-1)))
+			}
+		}
+	}
+}
+
+// Glyph substitutions of the features, applied in order: a feature can
+// substitute the glyphs an earlier one substituted. Returns the features the
+// font doesn't have and the ones it has without single or alternate lookups.
+func gsubSubstitutions(gsub []byte, features []fontFeature) (subs map[sfnt.GlyphIndex]sfnt.GlyphIndex, unknown []string) {
+	table := otReader(gsub)
+	featureList := table.from(table.u16(6))
+	lookupList := table.from(table.u16(8))
+
+	subs = make(map[sfnt.GlyphIndex]sfnt.GlyphIndex)
+	for _, feature := range features {
+		featureSubs := make(map[sfnt.GlyphIndex]sfnt.GlyphIndex)
+		found := false
+		for i := 0; i < featureList.u16(0); i++ {
+			record := 2 + 6*i
+			if record+4 > len(featureList) || string(featureList[record:record+4]) != feature.tag {
+				continue
+			}
+			found = true
+			featureTable := featureList.from(featureList.u16(record + 4))
+			for j := 0; j < featureTable.u16(2); j++ {
+				lookup := lookupList.from(lookupList.u16(2 + 2*featureTable.u16(4+2*j)))
+				for k := 0; k < lookup.u16(4); k++ {
+					lookup.from(lookup.u16(6+2*k)).substitutions(lookup.u16(0), feature.alternate, featureSubs)
+				}
+			}
+		}
+		if !found || len(featureSubs) == 0 {
+			unknown = append(unknown, feature.tag)
+			continue
+		}
+
+		for from, to := range subs {
+			if next, exists := featureSubs[to]; exists {
+				subs[from] = next
+			}
+		}
+		for from, to := range featureSubs {
+			if _, exists := subs[from]; !exists {
+				subs[from] = to
+			}
+		}
+	}
+
+	return subs, unknown
+}
+
+// Reads the substitutions of the features from a font file, warning about
+// features the font doesn't have
+func fontFeatureSubstitutions(fontFile string, spec string) map[sfnt.GlyphIndex]sfnt.GlyphIndex {
+	features, err := parseFontFeatures(spec)
+	handleErr(err)
+	if len(features) == 0 {
+		return nil
+	}
+
+	path, faceIndex := splitFaceIndex(fontFile)
+	dat, err := os.ReadFile(path)
+	handleErr(err)
+	subs, unknown := gsubSubstitutions(fontTable(dat, faceIndex, "GSUB"), features)
+	if len(unknown) > 0 {
+		fmt.Printf("warning: %s has no single glyph substitutions for %s\n", fontFile, strings.Join(unknown, ", "))
+	}
+	return subs
+}
+
+// A face drawing substituted glyphs in place of the ones their characters
+// map to. Characters without a substitution are left to the wrapped face,
+// substituted ones are drawn the way opentype.Face draws glyphs. With a
+// variation every glyph is drawn here, with the deltas of the axis values
+// applied.
+type substitutedFace struct {
+	font.Face
+	f         *sfnt.Font
+	subs      map[sfnt.GlyphIndex]sfnt.GlyphIndex
+	variation *fontVariation
+	scale     fixed.Int26_6
+	hinting   font.Hinting
+
+	buf  sfnt.Buffer
+	rast vector.Rasterizer
+	mask image.Alpha
+}
+
+func newSubstitutedFace(face font.Face, f *sfnt.Font, subs map[sfnt.GlyphIndex]sfnt.GlyphIndex, variation *fontVariation, size float64, dpi float64, hinting font.Hinting) font.Face {
+	if len(subs) == 0 && variation == nil {
+		return face
+	}
+	return &substitutedFace{
+		Face:      face,
+		f:         f,
+		subs:      subs,
+		variation: variation,
+		scale:     fixed.Int26_6(0.5 + (size * dpi * 64 / 72)),
+		hinting:   hinting,
+	}
+}
+
+func (face *substitutedFace) substitute(r rune) (sfnt.GlyphIndex, bool) {
+	glyph, err := face.f.GlyphIndex(&face.buf, r)
+	if err != nil {
+		return 0, false
+	}
+	if substitute, exists := face.subs[glyph]; exists && glyph != 0 {
+		return substitute, true
+	}
+	// a variation draws the missing glyph too
+	return glyph, face.variation != nil
+}
+
+// Outline and advance of a glyph drawn here
+func (face *substitutedFace) loadGlyph(glyph sfnt.GlyphIndex) (sfnt.Segments, fixed.Int26_6, error) {
+	if face.variation != nil {
+		segments, advance := face.variation.loadGlyph(glyph, face.scale)
+		if face.hinting == font.HintingFull {
+			advance = (advance + 32) &^ 63
+		}
+		return segments, advance, nil
+	}
+
+	advance, err := face.f.GlyphAdvance(&face.buf, glyph, face.scale, face.hinting)
+	if err != nil {
+		return nil, 0, err
+	}
+	segments, err := face.f.LoadGlyph(&face.buf, glyph, face.scale, nil)
+	return segments, advance, err
+}
+
+func (face *substitutedFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	glyph, substituted := face.substitute(r)
+	if !substituted {
+		return face.Face.Glyph(dot, r)
+	}
+
+	segments, advance, err := face.loadGlyph(glyph)
+	if err != nil {
+		return image.Rectangle{}, nil, image.Point{}, 0, false
+	}
+
+	bounds := segments.Bounds().Add(dot)
+	dr := image.Rect(bounds.Min.X.Floor(), bounds.Min.Y.Floor(), bounds.Max.X.Ceil(), bounds.Max.Y.Ceil())
+	if dr.Dx() < 0 || dr.Dy() < 0 {
+		return image.Rectangle{}, nil, image.Point{}, 0, false
+	}
+	biasX := dot.X - fixed.Int26_6(dr.Min.X<<6)
+	biasY := dot.Y - fixed.Int26_6(dr.Min.Y<<6)
+
+	pixels := dr.Dx() * dr.Dy()
+	if cap(face.mask.Pix) < pixels {
+		face.mask.Pix = make([]uint8, 2*pixels)
+	}
+	face.mask.Pix = face.mask.Pix[:pixels]
+	face.mask.Stride = dr.Dx()
+	face.mask.Rect = image.Rect(0, 0, dr.Dx(), dr.Dy())
+
+	point := func(p fixed.Point26_6) (float32, float32) {
+		return float32(p.X+biasX) / 64, float32(p.Y+biasY) / 64
+	}
+	face.rast.Reset(dr.Dx(), dr.Dy())
+	face.rast.DrawOp = draw.Src
+	for _, segment := range segments {
+		x0, y0 := point(segment.Args[0])
+		x1, y1 := point(segment.Args[1])
+		x2, y2 := point(segment.Args[2])
+		switch segment.Op {
+		case sfnt.SegmentOpMoveTo:
+			face.rast.MoveTo(x0, y0)
+		case sfnt.SegmentOpLineTo:
+			face.rast.LineTo(x0, y0)
+		case sfnt.SegmentOpQuadTo:
+			face.rast.QuadTo(x0, y0, x1, y1)
+		case sfnt.SegmentOpCubeTo:
+			face.rast.CubeTo(x0, y0, x1, y1, x2, y2)
+		}
+	}
+	face.rast.Draw(&face.mask, face.mask.Bounds(), image.Opaque, image.Point{})
+
+	return dr, &face.mask, image.Point{}, advance, true
+}
+
+func (face *substitutedFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	glyph, substituted := face.substitute(r)
+	if !substituted {
+		return face.Face.GlyphBounds(r)
+	}
+	if face.variation != nil {
+		segments, advance, _ := face.loadGlyph(glyph)
+		return segments.Bounds(), advance, true
+	}
+	bounds, advance, err := face.f.GlyphBounds(&face.buf, glyph, face.scale, face.hinting)
+	return bounds, advance, err == nil
+}
+
+func (face *substitutedFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	glyph, substituted := face.substitute(r)
+	if !substituted {
+		return face.Face.GlyphAdvance(r)
+	}
+	if face.variation != nil {
+		_, advance, _ := face.loadGlyph(glyph)
+		return advance, true
+	}
+	advance, err := face.f.GlyphAdvance(&face.buf, glyph, face.scale, face.hinting)
+	return advance, err == nil
+}
@@ -0,0 +1,788 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// Variable fonts hold every weight and width of a design in one file. Their
+// glyf outlines are the default instance, gvar has deltas moving the points
+// for other axis values. The axis values are picked after the font file (and
+// its face) the same way as the face of a collection, as in
+//
+//	font.ttf@wght=650,wdth=95
+//
+// so one file can draw Normal in one weight and NormalS in another. The
+// deltas move the phantom points too, which gives the varied advances. Only
+// TrueType outlines are varied, fonts with CFF2 outlines need a static
+// instance.
+const FONT_AXES_SEPARATOR = "@"
+
+type axisValue struct {
+	tag   string
+	value float64
+}
+
+func parseAxisValues(spec string) ([]axisValue, error) {
+	var values []axisValue
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		separator := strings.Index(part, "=")
+		if separator < 0 {
+			return nil, fmt.Errorf("axis value %q: axes are set as tag=value, like wght=650", part)
+		}
+		tag := strings.TrimSpace(part[:separator])
+		if len(tag) != 4 {
+			return nil, fmt.Errorf("axis value %q: tags have 4 characters, like wght or wdth", part)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(part[separator+1:]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("axis value %q: the value has to be a number", part)
+		}
+		values = append(values, axisValue{tag, value})
+	}
+	return values, nil
+}
+
+func withFontAxes(fontFile string, axes string) string {
+	if axes == "" {
+		return fontFile
+	}
+	return fontFile + FONT_AXES_SEPARATOR + axes
+}
+
+func splitFontAxes(fontFile string) (string, string) {
+	separator := strings.LastIndex(fontFile, FONT_AXES_SEPARATOR)
+	if separator < 0 {
+		return fontFile, ""
+	}
+	values, err := parseAxisValues(fontFile[separator+1:])
+	if err != nil || len(values) == 0 {
+		// an @ that's part of the file name
+		return fontFile, ""
+	}
+	return fontFile[:separator], fontFile[separator+1:]
+}
+
+// -axes sets the axes of every font, as in wght=650, or per botw font:
+//
+//	Normal:wght=650,wdth=95;NormalS:wght=450
+func parseFontAxesFlag(spec string) (map[string]string, error) {
+	axes := make(map[string]string)
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fontName := ""
+		if separator := strings.Index(part, ":"); separator >= 0 {
+			fontName = strings.TrimSpace(part[:separator])
+			part = strings.TrimSpace(part[separator+1:])
+			if _, exists := botwFontPresets[fontName]; !exists {
+				return nil, fmt.Errorf("axes %q: %s isn't a botw font: Ancient, Caption, Normal, NormalS or External", part, fontName)
+			}
+		}
+		if _, err := parseAxisValues(part); err != nil {
+			return nil, err
+		}
+		axes[fontName] = part
+	}
+	return axes, nil
+}
+
+// Axes given for the botw font, or the ones given for every font
+func fontAxesFor(axes map[string]string, fontName string) string {
+	if fontAxes, exists := axes[fontName]; exists {
+		return fontAxes
+	}
+	return axes[""]
+}
+
+// The tables a variable TrueType font is drawn from at one set of axis
+// values. sfnt.Font doesn't hand out glyf and gvar so the points are read
+// here.
+type fontVariation struct {
+	coords     []float64 // normalized axis values from -1 to 1, 0 is the default
+	unitsPerEm float64
+	numGlyphs  int
+	glyf       otReader
+	loca       otReader
+	longLoca   bool
+	hmtx       otReader
+	hMetrics   int
+	gvar       otReader
+}
+
+// A point of a glyph in font units, y goes up
+type glyfPoint struct {
+	x, y float64
+	on   bool
+}
+
+type pointDelta struct {
+	x, y float64
+}
+
+func newFontVariation(dat []byte, faceIndex int, axes []fontAxis, spec string) (*fontVariation, error) {
+	values, err := parseAxisValues(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(axes) == 0 {
+		return nil, fmt.Errorf("it's not a variable font, there are no axes to set")
+	}
+
+	coords := make([]float64, len(axes))
+	avar := otReader(fontTable(dat, faceIndex, "avar"))
+	for _, value := range values {
+		axisIndex := -1
+		for i, axis := range axes {
+			if axis.tag == value.tag {
+				axisIndex = i
+			}
+		}
+		if axisIndex < 0 {
+			return nil, fmt.Errorf("it has no %s axis, its axes are %v", value.tag, axes)
+		}
+		axis := axes[axisIndex]
+		if value.value < axis.min || value.value > axis.max {
+			return nil, fmt.Errorf("%s=%g is outside of the axis, %v", value.tag, value.value, axis)
+		}
+		coords[axisIndex] = normalizeAxisValue(axis, value.value, avar.axisSegmentMap(axisIndex))
+	}
+
+	glyf := fontTable(dat, faceIndex, "glyf")
+	gvar := fontTable(dat, faceIndex, "gvar")
+	if glyf == nil {
+		if fontTable(dat, faceIndex, "CFF2") != nil {
+			return nil, fmt.Errorf("only TrueType outlines can be varied, CFF2 fonts need a static instance")
+		}
+		return nil, fmt.Errorf("it has no glyf outlines to vary")
+	}
+	if gvar == nil {
+		return nil, fmt.Errorf("it has no glyph variations (gvar)")
+	}
+	head := otReader(fontTable(dat, faceIndex, "head"))
+	return &fontVariation{
+		coords:     coords,
+		unitsPerEm: float64(head.u16(18)),
+		numGlyphs:  otReader(fontTable(dat, faceIndex, "maxp")).u16(4),
+		glyf:       glyf,
+		loca:       fontTable(dat, faceIndex, "loca"),
+		longLoca:   head.u16(50) == 1,
+		hmtx:       fontTable(dat, faceIndex, "hmtx"),
+		hMetrics:   otReader(fontTable(dat, faceIndex, "hhea")).u16(34),
+		gvar:       gvar,
+	}, nil
+}
+
+func f2dot14(value int) float64 {
+	return float64(int16(value)) / 16384
+}
+
+// The avar segment map of an axis, pairs of from and to values. Empty without
+// avar.
+func (avar otReader) axisSegmentMap(axisIndex int) [][2]float64 {
+	if len(avar) == 0 {
+		return nil
+	}
+	offset := 8
+	for i := 0; i < axisIndex; i++ {
+		offset += 2 + 4*avar.u16(offset)
+	}
+	segments := make([][2]float64, avar.u16(offset))
+	for i := range segments {
+		segments[i] = [2]float64{f2dot14(avar.u16(offset + 2 + 4*i)), f2dot14(avar.u16(offset + 4 + 4*i))}
+	}
+	return segments
+}
+
+// Maps an axis value to -1 at the axis' minimum, 0 at its default and 1 at
+// its maximum, bent by the avar segments and rounded like an F2DOT14
+func normalizeAxisValue(axis fontAxis, value float64, segments [][2]float64) float64 {
+	normalized := 0.0
+	switch {
+	case value < axis.defaultValue && axis.defaultValue > axis.min:
+		normalized = (value - axis.defaultValue) / (axis.defaultValue - axis.min)
+	case value > axis.defaultValue && axis.max > axis.defaultValue:
+		normalized = (value - axis.defaultValue) / (axis.max - axis.defaultValue)
+	}
+
+	for i := 1; i < len(segments); i++ {
+		from, to := segments[i-1], segments[i]
+		if normalized > to[0] {
+			continue
+		}
+		if to[0] > from[0] {
+			normalized = from[1] + (to[1]-from[1])*(normalized-from[0])/(to[0]-from[0])
+		} else {
+			normalized = to[1]
+		}
+		break
+	}
+
+	return math.Round(normalized*16384) / 16384
+}
+
+// How much of a tuple's deltas apply at coords. Axes the tuple doesn't peak on
+// don't matter, on the others it fades out from the peak to the ends of its
+// region.
+func tupleScalar(coords []float64, peak []float64, start []float64, end []float64) float64 {
+	scalar := 1.0
+	for i, peakValue := range peak {
+		if peakValue == 0 || i >= len(coords) {
+			continue
+		}
+		value := coords[i]
+		if value == peakValue {
+			continue
+		}
+		lower, upper := math.Min(peakValue, 0), math.Max(peakValue, 0)
+		if start != nil {
+			lower, upper = start[i], end[i]
+		}
+		if lower > peakValue || peakValue > upper || (lower < 0 && upper > 0) {
+			// a broken region doesn't limit the tuple
+			continue
+		}
+		if value < lower || value > upper {
+			return 0
+		}
+		if value < peakValue {
+			scalar *= (value - lower) / (peakValue - lower)
+		} else {
+			scalar *= (upper - value) / (upper - peakValue)
+		}
+	}
+	return scalar
+}
+
+// Packed point numbers of gvar and how many bytes they take. nil means every
+// point of the glyph.
+func readPackedPoints(data otReader) ([]int, int) {
+	if len(data) == 0 {
+		return nil, 0
+	}
+	count := data.u8(0)
+	offset := 1
+	if count == 0 {
+		return nil, offset
+	}
+	if count&0x80 != 0 {
+		count = (count&0x7f)<<8 | data.u8(offset)
+		offset++
+	}
+
+	points := make([]int, 0, count)
+	point := 0
+	for len(points) < count && offset < len(data) {
+		control := data.u8(offset)
+		offset++
+		for i := 0; i <= control&0x7f && len(points) < count; i++ {
+			if control&0x80 != 0 {
+				point += data.u16(offset)
+				offset += 2
+			} else {
+				point += data.u8(offset)
+				offset++
+			}
+			points = append(points, point)
+		}
+	}
+	return points, offset
+}
+
+// count packed deltas of gvar and how many bytes they take
+func readPackedDeltas(data otReader, count int) ([]float64, int) {
+	deltas := make([]float64, 0, count)
+	offset := 0
+	for len(deltas) < count && offset < len(data) {
+		control := data.u8(offset)
+		offset++
+		for i := 0; i <= control&0x3f && len(deltas) < count; i++ {
+			switch {
+			case control&0x80 != 0:
+				deltas = append(deltas, 0)
+			case control&0x40 != 0:
+				deltas = append(deltas, float64(int16(data.u16(offset))))
+				offset += 2
+			default:
+				deltas = append(deltas, float64(int8(data.u8(offset))))
+				offset++
+			}
+		}
+	}
+	for len(deltas) < count {
+		deltas = append(deltas, 0)
+	}
+	return deltas, offset
+}
+
+// Delta of a point between two moved points of its contour. Points outside of
+// the two take the delta of the nearer one, the ones between are
+// interpolated.
+func interpolateDelta(value, ref1, ref2, delta1, delta2 float64) float64 {
+	if ref1 == ref2 {
+		if delta1 == delta2 {
+			return delta1
+		}
+		return 0
+	}
+	if ref1 > ref2 {
+		ref1, ref2 = ref2, ref1
+		delta1, delta2 = delta2, delta1
+	}
+	switch {
+	case value <= ref1:
+		return delta1
+	case value >= ref2:
+		return delta2
+	}
+	return delta1 + (value-ref1)*(delta2-delta1)/(ref2-ref1)
+}
+
+// Fills in the deltas of the points a tuple doesn't move from the nearest
+// moved points before and after them on their contour
+func interpolateUntouched(points []glyfPoint, ends []int, deltas []pointDelta, touched []bool) {
+	start := 0
+	for _, end := range ends {
+		if end >= len(points) {
+			return
+		}
+		var moved []int
+		for i := start; i <= end; i++ {
+			if touched[i] {
+				moved = append(moved, i)
+			}
+		}
+		for k, ref1 := range moved {
+			ref2 := moved[(k+1)%len(moved)]
+			for i := ref1 + 1; ; i++ {
+				if i > end {
+					i = start
+				}
+				if i == ref2 {
+					break
+				}
+				deltas[i] = pointDelta{
+					x: interpolateDelta(points[i].x, points[ref1].x, points[ref2].x, deltas[ref1].x, deltas[ref2].x),
+					y: interpolateDelta(points[i].y, points[ref1].y, points[ref2].y, deltas[ref1].y, deltas[ref2].y),
+				}
+			}
+		}
+		start = end + 1
+	}
+}
+
+// Sum of the deltas of every tuple of the glyph, each scaled by how much it
+// applies at the axis values. ends are the contour ends of a simple glyph,
+// components of composite glyphs aren't interpolated.
+func (v *fontVariation) glyphDeltas(glyph int, points []glyfPoint, ends []int) []pointDelta {
+	deltas := make([]pointDelta, len(points))
+	gvar := v.gvar
+	axisCount := gvar.u16(4)
+	if glyph >= gvar.u16(12) {
+		return deltas
+	}
+	var start, end int
+	if gvar.u16(14)&1 != 0 {
+		start, end = gvar.u32(20+4*glyph), gvar.u32(24+4*glyph)
+	} else {
+		start, end = 2*gvar.u16(20+2*glyph), 2*gvar.u16(22+2*glyph)
+	}
+	data := gvar.from(gvar.u32(16) + start)
+	if start >= end || end-start > len(data) {
+		return deltas
+	}
+	data = data[:end-start]
+
+	tupleCount := data.u16(0)
+	serialized := data.from(data.u16(2))
+	var sharedPoints []int
+	if tupleCount&0x8000 != 0 {
+		var size int
+		sharedPoints, size = readPackedPoints(serialized)
+		serialized = serialized.from(size)
+	}
+
+	header := 4
+	for i := 0; i < tupleCount&0x0fff; i++ {
+		size := data.u16(header)
+		index := data.u16(header + 2)
+		header += 4
+		peak := make([]float64, axisCount)
+		sharedTuple := index&0x0fff < gvar.u16(6)
+		if index&0x8000 != 0 {
+			for axis := range peak {
+				peak[axis] = f2dot14(data.u16(header))
+				header += 2
+			}
+		} else if sharedTuple {
+			tuple := gvar.u32(8) + 2*axisCount*(index&0x0fff)
+			for axis := range peak {
+				peak[axis] = f2dot14(gvar.u16(tuple + 2*axis))
+			}
+		}
+		var regionStart, regionEnd []float64
+		if index&0x4000 != 0 {
+			regionStart, regionEnd = make([]float64, axisCount), make([]float64, axisCount)
+			for axis := range regionStart {
+				regionStart[axis] = f2dot14(data.u16(header))
+				regionEnd[axis] = f2dot14(data.u16(header + 2*axisCount))
+				header += 2
+			}
+			header += 2 * axisCount
+		}
+
+		if size > len(serialized) {
+			break
+		}
+		tupleData := serialized[:size]
+		serialized = serialized.from(size)
+		scalar := tupleScalar(v.coords, peak, regionStart, regionEnd)
+		if scalar == 0 || (index&0x8000 == 0 && !sharedTuple) {
+			continue
+		}
+
+		tuplePoints := sharedPoints
+		if index&0x2000 != 0 {
+			var pointsSize int
+			tuplePoints, pointsSize = readPackedPoints(tupleData)
+			tupleData = tupleData.from(pointsSize)
+		}
+		count := len(tuplePoints)
+		if tuplePoints == nil {
+			count = len(points)
+		}
+		// the y deltas follow the x ones in the same runs
+		packed, _ := readPackedDeltas(tupleData, 2*count)
+		xs, ys := packed[:count], packed[count:]
+
+		if tuplePoints == nil {
+			for j := range deltas {
+				deltas[j].x += scalar * xs[j]
+				deltas[j].y += scalar * ys[j]
+			}
+			continue
+		}
+		tupleDeltas := make([]pointDelta, len(points))
+		touched := make([]bool, len(points))
+		for j, point := range tuplePoints {
+			if point < len(points) {
+				tupleDeltas[point] = pointDelta{xs[j], ys[j]}
+				touched[point] = true
+			}
+		}
+		if ends != nil {
+			interpolateUntouched(points, ends, tupleDeltas, touched)
+		}
+		for j, delta := range tupleDeltas {
+			deltas[j].x += scalar * delta.x
+			deltas[j].y += scalar * delta.y
+		}
+	}
+
+	return deltas
+}
+
+func (v *fontVariation) glyphData(glyph int) otReader {
+	var start, end int
+	if v.longLoca {
+		start, end = v.loca.u32(4*glyph), v.loca.u32(4*glyph+4)
+	} else {
+		start, end = 2*v.loca.u16(2*glyph), 2*v.loca.u16(2*glyph+2)
+	}
+	if start >= end || end > len(v.glyf) {
+		return nil
+	}
+	return v.glyf[start:end]
+}
+
+// Advance and left side bearing from hmtx
+func (v *fontVariation) horizontalMetrics(glyph int) (float64, float64) {
+	if glyph < v.hMetrics {
+		return float64(v.hmtx.u16(4 * glyph)), float64(int16(v.hmtx.u16(4*glyph + 2)))
+	}
+	return float64(v.hmtx.u16(4 * (v.hMetrics - 1))), float64(int16(v.hmtx.u16(4*v.hMetrics + 2*(glyph-v.hMetrics))))
+}
+
+func parseSimpleGlyph(data otReader, contours int) ([]glyfPoint, []int) {
+	ends := make([]int, contours)
+	for i := range ends {
+		ends[i] = data.u16(10 + 2*i)
+	}
+	count := 0
+	if contours > 0 {
+		count = ends[contours-1] + 1
+	}
+
+	offset := 10 + 2*contours
+	offset += 2 + data.u16(offset)
+	flags := make([]int, 0, count)
+	for len(flags) < count && offset < len(data) {
+		flag := data.u8(offset)
+		offset++
+		repeat := 0
+		if flag&0x08 != 0 {
+			repeat = data.u8(offset)
+			offset++
+		}
+		for i := 0; i <= repeat && len(flags) < count; i++ {
+			flags = append(flags, flag)
+		}
+	}
+	if len(flags) < count {
+		return nil, nil
+	}
+
+	points := make([]glyfPoint, count)
+	x, y := 0, 0
+	for i, flag := range flags {
+		switch {
+		case flag&0x02 != 0:
+			if flag&0x10 != 0 {
+				x += data.u8(offset)
+			} else {
+				x -= data.u8(offset)
+			}
+			offset++
+		case flag&0x10 == 0:
+			x += int(int16(data.u16(offset)))
+			offset += 2
+		}
+		points[i] = glyfPoint{x: float64(x), on: flag&0x01 != 0}
+	}
+	for i, flag := range flags {
+		switch {
+		case flag&0x04 != 0:
+			if flag&0x20 != 0 {
+				y += data.u8(offset)
+			} else {
+				y -= data.u8(offset)
+			}
+			offset++
+		case flag&0x20 == 0:
+			y += int(int16(data.u16(offset)))
+			offset += 2
+		}
+		points[i].y = float64(y)
+	}
+	return points, ends
+}
+
+// A component of a composite glyph, a glyph drawn transformed and moved
+type glyfComponent struct {
+	glyph          int
+	dx, dy         float64 // offset, or without xyValues the points to line up
+	xyValues       bool
+	scaledOffset   bool // the offset is transformed too
+	useMyMetrics   bool // the composite takes the advance of this component
+	xx, xy, yx, yy float64
+}
+
+func parseComponents(data otReader) []glyfComponent {
+	var components []glyfComponent
+	offset := 10
+	for {
+		flags := data.u16(offset)
+		component := glyfComponent{
+			glyph:        data.u16(offset + 2),
+			xyValues:     flags&0x0002 != 0,
+			scaledOffset: flags&0x0800 != 0,
+			useMyMetrics: flags&0x0200 != 0,
+			xx:           1,
+			yy:           1,
+		}
+		offset += 4
+		if flags&0x0001 != 0 {
+			component.dx, component.dy = float64(data.u16(offset)), float64(data.u16(offset+2))
+			if component.xyValues {
+				component.dx, component.dy = float64(int16(data.u16(offset))), float64(int16(data.u16(offset+2)))
+			}
+			offset += 4
+		} else {
+			component.dx, component.dy = float64(data.u8(offset)), float64(data.u8(offset+1))
+			if component.xyValues {
+				component.dx, component.dy = float64(int8(data.u8(offset))), float64(int8(data.u8(offset+1)))
+			}
+			offset += 2
+		}
+		switch {
+		case flags&0x0008 != 0:
+			component.xx = f2dot14(data.u16(offset))
+			component.yy = component.xx
+			offset += 2
+		case flags&0x0040 != 0:
+			component.xx, component.yy = f2dot14(data.u16(offset)), f2dot14(data.u16(offset+2))
+			offset += 4
+		case flags&0x0080 != 0:
+			component.xx, component.xy = f2dot14(data.u16(offset)), f2dot14(data.u16(offset+2))
+			component.yx, component.yy = f2dot14(data.u16(offset+4)), f2dot14(data.u16(offset+6))
+			offset += 8
+		}
+		components = append(components, component)
+		if flags&0x0020 == 0 || offset >= len(data) {
+			return components
+		}
+	}
+}
+
+func (component glyfComponent) transform(point glyfPoint) glyfPoint {
+	return glyfPoint{
+		x:  component.xx*point.x + component.yx*point.y,
+		y:  component.xy*point.x + component.yy*point.y,
+		on: point.on,
+	}
+}
+
+// Points of a glyph with the deltas applied and the contour ends. The last 4
+// points are the phantom points, the first two are the origin and the advance.
+func (v *fontVariation) glyphPoints(glyph int, depth int) ([]glyfPoint, []int) {
+	data := v.glyphData(glyph)
+	advance, leftSideBearing := v.horizontalMetrics(glyph)
+	origin := float64(int16(data.u16(2))) - leftSideBearing
+	phantom := []glyfPoint{{x: origin}, {x: origin + advance}, {}, {}}
+
+	contours := int(int16(data.u16(0)))
+	if contours >= 0 {
+		points, ends := parseSimpleGlyph(data, contours)
+		points = append(points, phantom...)
+		for i, delta := range v.glyphDeltas(glyph, points, ends) {
+			points[i].x += delta.x
+			points[i].y += delta.y
+		}
+		return points, ends
+	}
+
+	// the points gvar moves for a composite glyph are the offsets of its
+	// components
+	components := parseComponents(data)
+	offsets := make([]glyfPoint, 0, len(components)+4)
+	for _, component := range components {
+		offsets = append(offsets, glyfPoint{x: component.dx, y: component.dy})
+	}
+	offsets = append(offsets, phantom...)
+	for i, delta := range v.glyphDeltas(glyph, offsets, nil) {
+		if i < len(components) && !components[i].xyValues {
+			continue
+		}
+		offsets[i].x += delta.x
+		offsets[i].y += delta.y
+	}
+	phantom = offsets[len(components):]
+
+	var points []glyfPoint
+	var ends []int
+	for i, component := range components {
+		// components nested this deep are a loop in a broken font
+		if depth > 8 || component.glyph >= v.numGlyphs {
+			break
+		}
+		componentPoints, componentEnds := v.glyphPoints(component.glyph, depth+1)
+		componentPhantom := componentPoints[len(componentPoints)-4:]
+		componentPoints = componentPoints[:len(componentPoints)-4]
+		for j := range componentPoints {
+			componentPoints[j] = component.transform(componentPoints[j])
+		}
+
+		offset := offsets[i]
+		if component.scaledOffset {
+			offset = component.transform(offset)
+		}
+		if !component.xyValues {
+			// the offset lines up a point of the glyph so far with one of
+			// the component
+			parent, child := int(component.dx), int(component.dy)
+			offset = glyfPoint{}
+			if parent < len(points) && child < len(componentPoints) {
+				offset = glyfPoint{x: points[parent].x - componentPoints[child].x, y: points[parent].y - componentPoints[child].y}
+			}
+		}
+		for _, end := range componentEnds {
+			ends = append(ends, len(points)+end)
+		}
+		for _, point := range componentPoints {
+			points = append(points, glyfPoint{x: point.x + offset.x, y: point.y + offset.y, on: point.on})
+		}
+		if component.useMyMetrics {
+			phantom = componentPhantom
+		}
+	}
+
+	return append(points, phantom...), ends
+}
+
+// The varied outline of a glyph scaled to ppem and its advance, the way
+// sfnt.Font.LoadGlyph and GlyphAdvance give them for the default instance.
+// The origin is the varied first phantom point.
+func (v *fontVariation) loadGlyph(glyph sfnt.GlyphIndex, ppem fixed.Int26_6) (sfnt.Segments, fixed.Int26_6) {
+	points, ends := v.glyphPoints(int(glyph), 0)
+	phantom := points[len(points)-4:]
+	scale := float64(ppem) / v.unitsPerEm
+	at := func(point glyfPoint) fixed.Point26_6 {
+		return fixed.Point26_6{
+			X: fixed.Int26_6(math.Round((point.x - phantom[0].x) * scale)),
+			Y: fixed.Int26_6(math.Round(-point.y * scale)),
+		}
+	}
+	mid := func(a glyfPoint, b glyfPoint) glyfPoint {
+		return glyfPoint{x: (a.x + b.x) / 2, y: (a.y + b.y) / 2, on: true}
+	}
+
+	var segments sfnt.Segments
+	start := 0
+	for _, end := range ends {
+		if end < start || end >= len(points)-4 {
+			break
+		}
+		contour := points[start : end+1]
+		start = end + 1
+
+		// start on a point on the curve, or between two control points if
+		// there isn't any
+		first := -1
+		for i, point := range contour {
+			if point.on {
+				first = i
+				break
+			}
+		}
+		var startPoint glyfPoint
+		var order []glyfPoint
+		if first >= 0 {
+			startPoint = contour[first]
+			order = append(append(order, contour[first+1:]...), contour[:first+1]...)
+		} else {
+			startPoint = mid(contour[len(contour)-1], contour[0])
+			order = append(append(order, contour...), startPoint)
+		}
+
+		segments = append(segments, sfnt.Segment{Op: sfnt.SegmentOpMoveTo, Args: [3]fixed.Point26_6{at(startPoint)}})
+		var control *glyfPoint
+		for i := range order {
+			point := order[i]
+			switch {
+			case point.on && control == nil:
+				segments = append(segments, sfnt.Segment{Op: sfnt.SegmentOpLineTo, Args: [3]fixed.Point26_6{at(point)}})
+			case point.on:
+				segments = append(segments, sfnt.Segment{Op: sfnt.SegmentOpQuadTo, Args: [3]fixed.Point26_6{at(*control), at(point)}})
+				control = nil
+			case control != nil:
+				segments = append(segments, sfnt.Segment{Op: sfnt.SegmentOpQuadTo, Args: [3]fixed.Point26_6{at(*control), at(mid(*control, point))}})
+				control = &order[i]
+			default:
+				control = &order[i]
+			}
+		}
+	}
+
+	return segments, fixed.Int26_6(math.Round((phantom[1].x - phantom[0].x) * scale))
+}
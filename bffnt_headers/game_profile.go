@@ -0,0 +1,125 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// How a game names its fonts and which archive they live in, so packs, mods
+// and composed fonts come out named the way the game loads them. A game can
+// have variants, e.g. regions or languages with their own archive.
+type GameProfile struct {
+	Name     string            `yaml:"name"`
+	FontFile string            `yaml:"font_file"` // name of a font in the archive, %s is the font's name
+	Archives map[string]string `yaml:"archives"`  // variant -> archive path in the game's files
+	Default  string            `yaml:"default"`   // variant used when none is picked
+
+	SwitchTitleID string `yaml:"switch_title_id"` // where a LayeredFS mod goes, see writeAtmosphereMod
+}
+
+// BotW loads the fonts of every European and American language, and so of
+// all three regions the graphic pack's title ids are for, from the one
+// Font_EU.sbfarc. Games and variants that aren't built in can be described
+// in a yaml file with the same fields.
+var gameProfiles = map[string]GameProfile{
+	"botw": {
+		Name:          "botw",
+		FontFile:      "%s_00.bffnt",
+		Default:       "EU",
+		SwitchTitleID: "01007EF00011E000",
+		Archives: map[string]string{
+			"EU":   CEMU_PACK_ARCHIVE,
+			"USen": CEMU_PACK_ARCHIVE, "USes": CEMU_PACK_ARCHIVE, "USfr": CEMU_PACK_ARCHIVE,
+			"EUen": CEMU_PACK_ARCHIVE, "EUde": CEMU_PACK_ARCHIVE, "EUes": CEMU_PACK_ARCHIVE,
+			"EUfr": CEMU_PACK_ARCHIVE, "EUit": CEMU_PACK_ARCHIVE, "EUnl": CEMU_PACK_ARCHIVE,
+			"EUru": CEMU_PACK_ARCHIVE,
+		},
+	},
+}
+
+func gameProfileNames() []string {
+	names := make([]string, 0, len(gameProfiles))
+	for name := range gameProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// A built in profile by name, or a profile file
+func readGameProfile(nameOrFile string) GameProfile {
+	if profile, exists := gameProfiles[strings.ToLower(nameOrFile)]; exists {
+		return profile
+	}
+	extension := strings.ToLower(filepath.Ext(nameOrFile))
+	if extension != ".yaml" && extension != ".yml" {
+		handleErr(fmt.Errorf("unknown game %q. Known games: %s, or a yaml profile", nameOrFile, strings.Join(gameProfileNames(), ", ")))
+	}
+
+	raw, err := ioutil.ReadFile(nameOrFile)
+	handleErr(err)
+	var profile GameProfile
+	handleErr(yaml.Unmarshal(raw, &profile))
+	if !strings.Contains(profile.FontFile, "%s") {
+		handleErr(fmt.Errorf("%s: font_file needs a %%s for the font's name", nameOrFile))
+	}
+	if _, exists := profile.Archives[profile.Default]; !exists {
+		handleErr(fmt.Errorf("%s: the default variant %q has no archive", nameOrFile, profile.Default))
+	}
+
+	return profile
+}
+
+func (profile GameProfile) variantNames() []string {
+	names := make([]string, 0, len(profile.Archives))
+	for name := range profile.Archives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Where a font goes in a pack or mod: the archive, relative to the pack, and
+// the font's name in it
+type fontDestination struct {
+	archive string
+	name    string
+}
+
+// Variants are matched ignoring case, empty is the default one
+func (profile GameProfile) destination(fontName string, variant string) fontDestination {
+	if variant == "" {
+		variant = profile.Default
+	}
+	for name, archive := range profile.Archives {
+		if strings.EqualFold(name, variant) {
+			return fontDestination{archive: archive, name: profile.fontFileName(fontName)}
+		}
+	}
+	handleErr(fmt.Errorf("%s has no variant %q. Known variants: %s", profile.Name, variant, strings.Join(profile.variantNames(), ", ")))
+	return fontDestination{}
+}
+
+func (profile GameProfile) fontFileName(fontName string) string {
+	return fmt.Sprintf(profile.FontFile, fontName)
+}
+
+// Upscales write <font>_00_<scale>x_template.bffnt
+var templateFileName = regexp.MustCompile(`^(.+)_00_[0-9.]+x_template\.bffnt$`)
+
+// The font a template was upscaled from, false for files named otherwise
+func templateFontName(templateFile string) (string, bool) {
+	match := templateFileName.FindStringSubmatch(filepath.Base(templateFile))
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
@@ -0,0 +1,130 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"math"
+)
+
+// FINF describes the font's line metrics and TGLP the cells the glyphs are
+// drawn in. The game positions text with FINF and samples glyphs with TGLP,
+// when they disagree text gets clipped or floats above the line.
+type geometryPair struct {
+	name      string
+	finfName  string
+	finfValue int
+	tglpName  string
+	tglpValue int
+}
+
+func geometryPairs(finf FINF, tglp TGLP) []geometryPair {
+	return []geometryPair{
+		{"height", "height", int(finf.Height), "cell height", int(tglp.CellHeight)},
+		{"width", "width", int(finf.Width), "cell width", int(tglp.CellWidth)},
+		{"ascent", "ascent", int(finf.Ascent), "baseline", int(tglp.BaselinePosition)},
+	}
+}
+
+// Nintendo's fonts keep the FINF values within a few pixels of the TGLP ones.
+// More than a third of the cell apart is almost certainly a mistake.
+func lintGeometry(b *BFFNT) []LintIssue {
+	issues := make([]LintIssue, 0)
+
+	if b.TGLP.BaselinePosition > uint16(b.TGLP.CellHeight) {
+		issues = append(issues, LintIssue{LINT_ERROR, "TGLP",
+			fmt.Sprintf("baseline %d is below the bottom of the %d pixel high cells", b.TGLP.BaselinePosition, b.TGLP.CellHeight)})
+	}
+	if b.FINF.Ascent > b.FINF.Height {
+		issues = append(issues, LintIssue{LINT_WARNING, "FINF",
+			fmt.Sprintf("ascent %d is larger than the font height %d", b.FINF.Ascent, b.FINF.Height)})
+	}
+
+	for _, pair := range geometryPairs(b.FINF, b.TGLP) {
+		difference := pair.finfValue - pair.tglpValue
+		if math.Abs(float64(difference)) > float64(b.TGLP.CellHeight)/3 {
+			issues = append(issues, LintIssue{LINT_WARNING, "FINF",
+				fmt.Sprintf("%s %d is %d pixels away from the TGLP %s %d", pair.finfName, pair.finfValue, difference, pair.tglpName, pair.tglpValue)})
+		}
+	}
+
+	return issues
+}
+
+// After upscaling the difference between the FINF and TGLP values should have
+// grown with the scale. Rounding can move things by a pixel or so, anything
+// more means one side was adjusted without the other.
+func checkUpscaledGeometry(originalFINF FINF, originalTGLP TGLP, finf FINF, tglp TGLP, scale float64) []LintIssue {
+	issues := make([]LintIssue, 0)
+	tolerance := math.Ceil(scale) + 1
+	originalPairs := geometryPairs(originalFINF, originalTGLP)
+
+	for i, pair := range geometryPairs(finf, tglp) {
+		difference := pair.finfValue - pair.tglpValue
+		expected := float64(originalPairs[i].finfValue-originalPairs[i].tglpValue) * scale
+		if math.Abs(float64(difference)-expected) > tolerance {
+			issues = append(issues, LintIssue{LINT_WARNING, "FINF",
+				fmt.Sprintf("%s %d and TGLP %s %d differ by %d, expected about %.0f from the original font",
+					pair.finfName, pair.finfValue, pair.tglpName, pair.tglpValue, difference, expected)})
+		}
+	}
+
+	return issues
+}
+
+// The game finds a glyph's cell by its index, so the grid needs a cell for
+// every glyph and all cells have to be inside the sheet. Every cell has a 1
+// pixel border on the left and top. A grid that leaves room for another column
+// or row doesn't break anything but usually means the columns or rows were
+// set by hand.
+func cellGridIssues(tglp *TGLP, highestIndex int) []LintIssue {
+	issues := make([]LintIssue, 0)
+	columns, rows := int(tglp.NumOfColumns), int(tglp.NumOfRows)
+	realCellWidth, realCellHeight := int(tglp.CellWidth)+1, int(tglp.CellHeight)+1
+	sheetWidth, sheetHeight := int(tglp.SheetWidth), int(tglp.SheetHeight)
+
+	if columns == 0 || rows == 0 || tglp.NumOfSheets == 0 {
+		return append(issues, LintIssue{LINT_ERROR, "TGLP",
+			fmt.Sprintf("grid of %d sheet(s) with %dx%d cells has no cells", tglp.NumOfSheets, columns, rows)})
+	}
+
+	cellCount := int(tglp.NumOfSheets) * columns * rows
+	if highestIndex >= cellCount {
+		issues = append(issues, LintIssue{LINT_ERROR, "TGLP",
+			fmt.Sprintf("glyph index %d needs %d cells but %d sheet(s) of %dx%d cells only have %d", highestIndex, highestIndex+1, tglp.NumOfSheets, columns, rows, cellCount)})
+	}
+
+	if columns*realCellWidth > sheetWidth {
+		issues = append(issues, LintIssue{LINT_ERROR, "TGLP",
+			fmt.Sprintf("%d columns of %d pixels need %d pixels but the sheet is %d wide", columns, realCellWidth, columns*realCellWidth, sheetWidth)})
+	} else if (columns+1)*realCellWidth <= sheetWidth {
+		issues = append(issues, LintIssue{LINT_WARNING, "TGLP",
+			fmt.Sprintf("%d columns of %d pixels leave room for %d more in the %d pixel wide sheet", columns, realCellWidth, sheetWidth/realCellWidth-columns, sheetWidth)})
+	}
+
+	if rows*realCellHeight > sheetHeight {
+		issues = append(issues, LintIssue{LINT_ERROR, "TGLP",
+			fmt.Sprintf("%d rows of %d pixels need %d pixels but the sheet is %d high", rows, realCellHeight, rows*realCellHeight, sheetHeight)})
+	} else if (rows+1)*realCellHeight <= sheetHeight {
+		issues = append(issues, LintIssue{LINT_WARNING, "TGLP",
+			fmt.Sprintf("%d rows of %d pixels leave room for %d more in the %d pixel high sheet", rows, realCellHeight, sheetHeight/realCellHeight-rows, sheetHeight)})
+	}
+
+	return issues
+}
+
+// Highest glyph index used by the CWDHs or the CMAPs
+func (b *BFFNT) highestGlyphIndex() int {
+	highest := b.glyphCount() - 1
+	for _, cmap := range b.CMAPs {
+		for _, charIndex := range cmap.CharIndex {
+			if charIndex != 65535 && int(charIndex) > highest {
+				highest = int(charIndex)
+			}
+		}
+	}
+
+	return highest
+}
+
+func lintCellGrid(b *BFFNT) []LintIssue {
+	return cellGridIssues(&b.TGLP, b.highestGlyphIndex())
+}
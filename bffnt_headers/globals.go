@@ -1,15 +1,18 @@
 package bffnt_headers
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/binary"
-	"encoding/json"
+	"flag"
 	"fmt"
+	"math"
+	"strings"
 )
 
-var (
-	Debug bool
+// What to do with non zero bytes left over after a section's data, see
+// verifyLeftoverBytes. Every BFFNT has its own, empty is LEFTOVER_ERROR.
+const (
+	LEFTOVER_ERROR    = "error"    // stop decoding
+	LEFTOVER_WARN     = "warn"     // report them and drop them
+	LEFTOVER_PRESERVE = "preserve" // report them and write them back when encoding
 )
 
 const (
@@ -29,6 +32,28 @@ const (
 	KRNG_MAGIC_HEADER = "KRNG"
 )
 
+// Sheet image formats (TGLP.SheetImageFormat). Wii U fonts use the GX2 format
+// list, which differs from the 3DS (BCFNT) one after format 8. e.g. 12 is ETC1
+// on 3DS but BC4 on Wii U.
+const (
+	IMAGE_FORMAT_A8  = 8
+	IMAGE_FORMAT_BC4 = 12
+)
+
+// Upscaling rounds metrics up so glyphs never get cut off. When downscaling,
+// rounding every advance and offset up makes text noticeably looser than the
+// original so they are rounded to the nearest value instead. Sizes that have to
+// contain a glyph (cells, glyph widths) are always rounded up.
+func scaleMetric(value float64, scale float64) float64 {
+	if scale < 1 {
+		return math.Round(value * scale)
+	}
+
+	return math.Ceil(value * scale)
+}
+
+// Used for checks that can't fail without the data being unreadable, e.g. the
+// length of a header slice. Everything else goes through ValidationResult.
 func assertEqual(expected int, actual int) {
 	if expected != actual {
 		err := fmt.Errorf("%d(actual) does not equal %d(expected)\n", actual, expected)
@@ -36,46 +61,95 @@ func assertEqual(expected int, actual int) {
 	}
 }
 
-func handleErr(err error) {
-	if err != nil {
-		panic(err)
+// Mismatches found while decoding or encoding. They are collected instead of
+// panicking on the first one so a single report shows every inconsistency in
+// a problematic font.
+type ValidationResult struct {
+	Failures []string
+}
+
+func (v ValidationResult) clone() ValidationResult {
+	return ValidationResult{append([]string(nil), v.Failures...)}
+}
+
+func (v *ValidationResult) assertEqual(what string, expected int, actual int) {
+	if expected != actual {
+		v.Failures = append(v.Failures, fmt.Sprintf("%s: %d(actual) does not equal %d(expected)", what, actual, expected))
 	}
 }
 
-// Just a wrapper around binary.Write
-func binaryWrite(w *bufio.Writer, data interface{}) {
-	err := binary.Write(w, binary.BigEndian, data)
-	handleErr(err)
+func (v *ValidationResult) merge(other ValidationResult) {
+	v.Failures = append(v.Failures, other.Failures...)
+}
 
-	// just call every time. its easy to forget and end up with missing bytes
-	w.Flush()
+func (v ValidationResult) Ok() bool {
+	return len(v.Failures) == 0
 }
 
-func pprint(s interface{}) {
-	jsonBytes, err := json.MarshalIndent(s, "", "  ")
-	// jsonBytes, err := json.Marshal(s)
-	handleErr(err)
+// nil when nothing failed
+func (v ValidationResult) Err() error {
+	if v.Ok() {
+		return nil
+	}
 
-	fmt.Printf("%s\n", string(jsonBytes))
+	return fmt.Errorf("%d validation failure(s):\n  %s", len(v.Failures), strings.Join(v.Failures, "\n  "))
+}
+
+func handleErr(err error) {
+	if err != nil {
+		panic(err)
+	}
 }
 
 // It looks like in some cases there can be left over bytes from a section
 // after decoding is done. Not a significant amount. Usually 2, 4, or 6 bytes.
 // If these bytes are really unused we should expect them to be zero'd out.
-func verifyLeftoverBytes(leftovers []byte) {
+// Hex edited fonts sometimes have garbage in there though, the font's
+// LeftoverPolicy decides if that is fatal. Returns the bytes the section should
+// keep.
+func verifyLeftoverBytes(log *Logger, policy string, section string, offset int, leftovers []byte) []byte {
 	if len(leftovers) > 0 {
-		if Debug {
-			fmt.Printf("%d bytes left over\n", len(leftovers))
-		}
+		log.debugf("%d bytes left over\n", len(leftovers))
+	}
+	if allZeroBytes(leftovers) {
+		return nil
+	}
+
+	report := fmt.Sprintf("%s at offset %d has %d left over bytes that are not zero'd: % x", section, offset, len(leftovers), leftovers)
+	switch policy {
+	case LEFTOVER_WARN:
+		fmt.Println("warning:", report, "(dropped)")
+		return nil
+	case LEFTOVER_PRESERVE:
+		fmt.Println("warning:", report, "(preserved)")
+		return append([]byte{}, leftovers...)
+	}
 
-		for _, singleByte := range leftovers {
-			if singleByte != 0 {
-				fmt.Println("left over bytes:", leftovers)
-				err := fmt.Errorf("There are left over bytes that are not zero'd")
-				handleErr(err)
-			}
+	handleErr(fmt.Errorf("%s. Use -leftovers warn or preserve to decode anyway", report))
+	return nil
+}
+
+func allZeroBytes(raw []byte) bool {
+	for _, singleByte := range raw {
+		if singleByte != 0 {
+			return false
 		}
 	}
+
+	return true
+}
+
+// Registers the -leftovers flag which sets policy
+func leftoverPolicyFlag(flags *flag.FlagSet, policy *string) {
+	usage := fmt.Sprintf("what to do with non zero bytes left over after a section: %s, %s or %s", LEFTOVER_ERROR, LEFTOVER_WARN, LEFTOVER_PRESERVE)
+	flags.Func("leftovers", usage, func(value string) error {
+		switch value {
+		case LEFTOVER_ERROR, LEFTOVER_WARN, LEFTOVER_PRESERVE:
+			*policy = value
+			return nil
+		}
+		return fmt.Errorf("unknown leftover policy %q", value)
+	})
 }
 
 // After every CWDH, CMAP, and KRNG section and its data is encoded. There is padding
@@ -91,24 +165,49 @@ func paddingToNext4ByteBoundary(offset int) int {
 	return 4 - remainder
 }
 
+// Offsets and sizes are stored as uint32 and glyph indexes and counts as
+// uint16, so a bffnt can't be larger than 4 GB and can't have more than 65536
+// glyphs. Sizes and offsets are added up as int64 and checked on their way
+// into a header field, a huge merged CJK font then fails with the field that
+// doesn't fit instead of silently wrapping around into a broken file.
+func checkedUint32(field string, value int64) uint32 {
+	if value < 0 || value > math.MaxUint32 {
+		handleErr(fmt.Errorf("%s would be %d but the format stores it in 32 bits, it can be at most %d", field, value, int64(math.MaxUint32)))
+	}
+	return uint32(value)
+}
+
+func checkedUint16(field string, value int64) uint16 {
+	if value < 0 || value > math.MaxUint16 {
+		handleErr(fmt.Errorf("%s would be %d but the format stores it in 16 bits, it can be at most %d", field, value, math.MaxUint16))
+	}
+	return uint16(value)
+}
+
+func checkedUint8(field string, value int64) uint8 {
+	if value < 0 || value > math.MaxUint8 {
+		handleErr(fmt.Errorf("%s would be %d but the format stores it in 8 bits, it can be at most %d", field, value, math.MaxUint8))
+	}
+	return uint8(value)
+}
+
 func check4ByteBoundary(offset int) {
 	if paddingToNext4ByteBoundary(offset) != 0 {
 		panic(fmt.Sprintf("%d not at 4 byte boundary", offset))
 	}
 }
 
-func padToNext4ByteBoundary(w *bufio.Writer, buf *bytes.Buffer, startOffset int) {
-	w.Flush()
-	totalBytesSoFar := startOffset - 8 + len(buf.Bytes())
-
-	paddingAmount := paddingToNext4ByteBoundary(totalBytesSoFar)
-	for i := 0; i < paddingAmount; i++ {
-		binaryWrite(w, byte(0))
+// Appends n zero bytes. Uses the spare capacity of s when there is enough,
+// without allocating a slice of zeros first.
+func appendZeros(s []byte, n int) []byte {
+	if len(s)+n > cap(s) {
+		return append(s, make([]byte, n)...)
 	}
-	w.Flush()
 
-	totalBytesWithPadding := totalBytesSoFar + paddingAmount
-	if paddingToNext4ByteBoundary(totalBytesWithPadding) != 0 {
-		panic(fmt.Sprintf("%d not at 4 byte boundary", totalBytesWithPadding))
+	s = s[:len(s)+n]
+	zeros := s[len(s)-n:]
+	for i := range zeros {
+		zeros[i] = 0
 	}
+	return s
 }
@@ -0,0 +1,141 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Per glyph nudges of an upscale, kept in a yaml file instead of code like
+// adjustBotwCaptionWidth, see adjustments.yaml. They're in the pixels of the
+// upscaled font so every set belongs to a botw font and a scale. `bffnt
+// nudge` edits them while showing the re-rendered glyph.
+type GlyphAdjustment struct {
+	X     int `yaml:"x,omitempty"`     // draw offset in the cell, right is positive
+	Y     int `yaml:"y,omitempty"`     // draw offset in the cell, down is positive
+	Left  int `yaml:"left,omitempty"`  // added to the LeftWidth
+	Width int `yaml:"width,omitempty"` // added to the CharWidth
+}
+
+type GlyphAdjustmentSet struct {
+	Font   string                     `yaml:"font"`
+	Scale  float64                    `yaml:"scale"`
+	Glyphs map[string]GlyphAdjustment `yaml:"glyphs"` // "A" or "U+0041" -> adjustment
+}
+
+type GlyphAdjustmentsFile struct {
+	Sets []GlyphAdjustmentSet `yaml:"adjustments"`
+}
+
+const GLYPH_ADJUSTMENTS_HEADER = `# Per glyph adjustments in pixels of the upscaled font, see
+# GlyphAdjustment. Written by bffnt nudge, used with -adjustments.
+`
+
+func readGlyphAdjustments(filename string) GlyphAdjustmentsFile {
+	raw, err := ioutil.ReadFile(filename)
+	handleErr(err)
+
+	var file GlyphAdjustmentsFile
+	handleErr(yaml.Unmarshal(raw, &file))
+	for _, set := range file.Sets {
+		if _, err := set.byChar(); err != nil {
+			handleErr(fmt.Errorf("%s: %s at %gx: %v", filename, set.Font, set.Scale, err))
+		}
+	}
+
+	return file
+}
+
+func (file GlyphAdjustmentsFile) write(filename string) {
+	raw, err := yaml.Marshal(file)
+	handleErr(err)
+	handleErr(os.WriteFile(filename, append([]byte(GLYPH_ADJUSTMENTS_HEADER), raw...), 0644))
+}
+
+// The set of a font at a scale, added if there's none yet
+func (file *GlyphAdjustmentsFile) set(fontName string, scale float64) *GlyphAdjustmentSet {
+	for i := range file.Sets {
+		if file.Sets[i].Font == fontName && file.Sets[i].Scale == scale {
+			return &file.Sets[i]
+		}
+	}
+	file.Sets = append(file.Sets, GlyphAdjustmentSet{Font: fontName, Scale: scale, Glyphs: map[string]GlyphAdjustment{}})
+	return &file.Sets[len(file.Sets)-1]
+}
+
+// The adjustments of a font at a scale, nil if the file has none for it
+func (file GlyphAdjustmentsFile) forFont(fontName string, scale float64) map[rune]GlyphAdjustment {
+	for _, set := range file.Sets {
+		if set.Font == fontName && set.Scale == scale {
+			adjustments, err := set.byChar()
+			handleErr(err)
+			return adjustments
+		}
+	}
+	return nil
+}
+
+func (set GlyphAdjustmentSet) byChar() (map[rune]GlyphAdjustment, error) {
+	adjustments := make(map[rune]GlyphAdjustment, len(set.Glyphs))
+	for key, adjustment := range set.Glyphs {
+		char, err := parseCharFlag(key)
+		if err != nil {
+			return nil, err
+		}
+		adjustments[char] = adjustment
+	}
+	return adjustments, nil
+}
+
+// Stores the adjustment of a character, removing it when it's all zero
+func (set *GlyphAdjustmentSet) put(char rune, adjustment GlyphAdjustment) {
+	for key := range set.Glyphs {
+		if existing, err := parseCharFlag(key); err == nil && existing == char {
+			delete(set.Glyphs, key)
+		}
+	}
+	if adjustment != (GlyphAdjustment{}) {
+		set.Glyphs[charKey(char)] = adjustment
+	}
+}
+
+// Printable characters are written as they are, the rest as code points
+func charKey(char rune) string {
+	if unicode.IsGraphic(char) && !unicode.IsSpace(char) {
+		return string(char)
+	}
+	return fmt.Sprintf("U+%04X", char)
+}
+
+// Adds the LeftWidth and CharWidth adjustments to the font's widths. The draw
+// offsets are applied by renderGlyphSheet. Returns the characters the font
+// doesn't map.
+func (b *BFFNT) applyGlyphAdjustments(adjustments map[rune]GlyphAdjustment) []rune {
+	var clamped clampedMetrics
+	unmapped := make([]rune, 0)
+	for char, adjustment := range adjustments {
+		index, found := b.CharIndex(char)
+		if !found {
+			unmapped = append(unmapped, char)
+			continue
+		}
+		glyph, _ := b.glyphInfoAt(int(index))
+		leftWidth := int(glyph.LeftWidth) + adjustment.Left
+		charWidth := int(glyph.CharWidth) + adjustment.Width
+		var fits bool
+		if glyph.LeftWidth, fits = clampToLeftWidth(leftWidth); !fits {
+			clamped.add("%s LeftWidth %d", charKey(char), leftWidth)
+		}
+		if glyph.CharWidth, fits = clampToCharWidth(charWidth); !fits {
+			clamped.add("%s CharWidth %d", charKey(char), charWidth)
+		}
+	}
+	warnClampedMetrics("after the adjustments", clamped)
+	sort.Slice(unmapped, func(i, j int) bool { return unmapped[i] < unmapped[j] })
+
+	return unmapped
+}
@@ -0,0 +1,85 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Faces are identified by the font file, size and font features they were
+// created with, the other face options are the same for every render
+type faceKey struct {
+	fontFile string
+	size     float64
+	features string
+}
+
+type glyphMeasurementKey struct {
+	face  faceKey
+	glyph string
+}
+
+// Where the ink of a glyph drawn at dot (0, 0) ends up and how far the dot
+// moves. Drawing at another dot just shifts the bounds, see boundsAt.
+type glyphMeasurement struct {
+	bounds  fixed.Rectangle26_6
+	advance fixed.Int26_6
+}
+
+func (measurement glyphMeasurement) boundsAt(dot fixed.Point26_6) fixed.Rectangle26_6 {
+	return fixed.Rectangle26_6{
+		Min: measurement.bounds.Min.Add(dot),
+		Max: measurement.bounds.Max.Add(dot),
+	}
+}
+
+// Measuring a glyph loads and shapes its outline, which is most of the work
+// for anything but drawing. Measurements are kept for the whole run, so
+// rendering the same face again, e.g. for several fonts sharing a font file
+// and size, or the same glyph mapped by several characters, is free. Safe to
+// use from every render worker at once.
+type glyphMeasurementCache struct {
+	mu      sync.Mutex
+	entries map[glyphMeasurementKey]glyphMeasurement
+	hits    int
+	misses  int
+}
+
+var glyphMeasurements = newGlyphMeasurementCache()
+
+func newGlyphMeasurementCache() *glyphMeasurementCache {
+	return &glyphMeasurementCache{entries: make(map[glyphMeasurementKey]glyphMeasurement)}
+}
+
+// face has to be the face key describes. The face is only used on a miss and
+// isn't shared between goroutines, every worker passes its own.
+func (cache *glyphMeasurementCache) measure(key faceKey, face font.Face, glyph string) glyphMeasurement {
+	entryKey := glyphMeasurementKey{key, glyph}
+	cache.mu.Lock()
+	measurement, cached := cache.entries[entryKey]
+	if cached {
+		cache.hits++
+	}
+	cache.mu.Unlock()
+	if cached {
+		return measurement
+	}
+
+	measurement.bounds, _ = font.BoundString(face, glyph)
+	measurement.advance = font.MeasureString(face, glyph)
+
+	cache.mu.Lock()
+	cache.entries[entryKey] = measurement
+	cache.misses++
+	cache.mu.Unlock()
+
+	return measurement
+}
+
+func (cache *glyphMeasurementCache) String() string {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return fmt.Sprintf("%d glyph measurement(s) cached, %d hit(s), %d miss(es)", len(cache.entries), cache.hits, cache.misses)
+}
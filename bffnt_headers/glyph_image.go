@@ -0,0 +1,113 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Reads a png (or another decodable image), or draws an svg height pixels
+// tall, see rasterizeSVG
+func readGlyphImage(filename string, height int) image.Image {
+	if !strings.EqualFold(filepath.Ext(filename), ".svg") {
+		return readImageFile(filename)
+	}
+
+	file, err := os.Open(filename)
+	handleErr(err)
+	defer file.Close()
+	img, err := rasterizeSVG(file, height)
+	handleErr(err)
+
+	return img
+}
+
+// Artwork replacing the rendered glyphs of an upscale, one file per
+// character named like U+E0A0.png or U+E0A0.svg. Pngs are drawn as they are
+// so they fit the scale they were made for, svgs are drawn as tall as the
+// cell at any scale. Returns how many glyphs were replaced.
+func (b *BFFNT) drawOverrides(dst draw.Image, dir string) int {
+	entries, err := ioutil.ReadDir(dir)
+	handleErr(err)
+
+	drawn := make(map[rune]string)
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".png" && ext != ".svg") {
+			continue
+		}
+		filename := filepath.Join(dir, entry.Name())
+		char, err := parseCharFlag(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())))
+		if err != nil {
+			fmt.Printf("warning: %s isn't named after a character like U+E0A0.png, it's skipped\n", filename)
+			continue
+		}
+		if other, exists := drawn[char]; exists {
+			handleErr(fmt.Errorf("%s and %s both override %s", other, filename, formatChar(uint16(char))))
+		}
+		index, found := b.CharIndex(char)
+		if !found {
+			fmt.Printf("warning: %s isn't mapped, %s is skipped\n", formatChar(uint16(char)), filename)
+			continue
+		}
+
+		_, cell := b.TGLP.CellRect(int(index))
+		if b.drawImageInto(dst, cell, int(index), readGlyphImage(filename, int(b.TGLP.CellHeight))) {
+			fmt.Printf("warning: %s is bigger than the %dx%d cell and is cut off\n", filename, b.TGLP.CellWidth, b.TGLP.CellHeight)
+		}
+		drawn[char] = filename
+	}
+
+	return len(drawn)
+}
+
+// Replaces the artwork of a character with an image, adding the character if
+// the font doesn't have it. Meant for icons that no font file has, like the
+// button symbols of the External font.
+func glyphImageCommand(args []string) {
+	flags := newCommandFlagSet("glyph", "[flags] font.bffnt")
+	charFlag := flags.String("char", "", "character to draw, e.g. U+E000")
+	imageFile := flags.String("image", "", "png or svg with the glyph's artwork, svgs are drawn to fit the cell")
+	height := flags.Int("height", 0, "height in pixels svgs are drawn at. 0 uses the cell height")
+	output := flags.String("o", "", "output file. Defaults to <name>_glyph.bffnt next to the input")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 || *charFlag == "" || *imageFile == "" {
+		exitWithUsage(flags)
+	}
+	char, err := parseCharFlag(*charFlag)
+	handleErr(err)
+
+	bffntFile := flags.Arg(0)
+	bffnt := flags.readBffnt(bffntFile)
+	bffnt.TGLP.DecodeSheets()
+	if _, found := bffnt.CharIndex(char); !found {
+		bffnt.AddChars([]rune{char})
+		fmt.Println("added", formatChar(uint16(char)))
+		if sheetsAdded := bffnt.TGLP.repackSheets(bffnt.glyphCount()); sheetsAdded > 0 {
+			fmt.Println("added", sheetsAdded, "sheet(s)")
+		}
+	}
+	index, _ := bffnt.CharIndex(char)
+
+	if *height == 0 {
+		*height = int(bffnt.TGLP.CellHeight)
+	}
+	if bffnt.drawImageIntoCell(int(index), readGlyphImage(*imageFile, *height)) {
+		fmt.Printf("warning: %s is bigger than the %dx%d cell and is cut off\n", *imageFile, bffnt.TGLP.CellWidth, bffnt.TGLP.CellHeight)
+	}
+	fmt.Println("drew", *imageFile, "into", bffnt.glyphLabel(int(index)))
+
+	if *output == "" {
+		name := strings.TrimSuffix(bffntFile, filepath.Ext(bffntFile))
+		*output = name + "_glyph" + filepath.Ext(bffntFile)
+	}
+	encoded := bffnt.Encode()
+	handleErr(bffnt.VerifyEncoded(encoded))
+	handleErr(os.WriteFile(*output, encoded, 0644))
+	fmt.Println("wrote", *output)
+}
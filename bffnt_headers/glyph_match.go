@@ -0,0 +1,295 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"gopkg.in/yaml.v3"
+)
+
+// Font files that don't line up character for character with a botw font,
+// like botw-sheikah.ttf and the Ancient font, need a glyph mapping, see
+// asciiToGlyph. match-glyphs proposes one by comparing the glyphs of the
+// bffnt with the font file's: both are cropped to their ink, scaled to the
+// same square and every bffnt glyph is paired with the most similar one. The
+// proposal is a yaml file to review and fix by hand before an upscale reads
+// it with -glyph-map.
+const (
+	GLYPH_MATCH_SIZE      = 24  // glyphs are compared as squares this many pixels wide
+	GLYPH_MATCH_INK       = 32  // alpha a pixel needs to count as ink when cropping
+	GLYPH_MATCH_CONFIDENT = 0.6 // matches less similar than this are marked for review
+	GLYPH_MATCH_CLOSE     = 0.05
+)
+
+// A glyph cropped to its ink and scaled to a GLYPH_MATCH_SIZE square
+type glyphShape struct {
+	pix    []float64
+	aspect float64 // width / height of the ink
+}
+
+// nil for glyphs without ink
+func newGlyphShape(img image.Image) *glyphShape {
+	bounds := img.Bounds()
+	ink := image.Rectangle{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if color.AlphaModel.Convert(img.At(x, y)).(color.Alpha).A >= GLYPH_MATCH_INK {
+				ink = ink.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if ink.Empty() {
+		return nil
+	}
+
+	// scaled to fit the square keeping the aspect ratio, then centered
+	width, height := GLYPH_MATCH_SIZE, GLYPH_MATCH_SIZE
+	if ink.Dx() > ink.Dy() {
+		height = int(math.Max(1, math.Round(float64(GLYPH_MATCH_SIZE*ink.Dy())/float64(ink.Dx()))))
+	} else {
+		width = int(math.Max(1, math.Round(float64(GLYPH_MATCH_SIZE*ink.Dx())/float64(ink.Dy()))))
+	}
+	scaled := imaging.Resize(imaging.Crop(img, ink), width, height, imaging.Linear)
+
+	shape := &glyphShape{pix: make([]float64, GLYPH_MATCH_SIZE*GLYPH_MATCH_SIZE), aspect: float64(ink.Dx()) / float64(ink.Dy())}
+	left, top := (GLYPH_MATCH_SIZE-width)/2, (GLYPH_MATCH_SIZE-height)/2
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			shape.pix[(top+y)*GLYPH_MATCH_SIZE+left+x] = float64(scaled.NRGBAAt(x, y).A) / 255
+		}
+	}
+	return shape
+}
+
+// 1 for the same shape, 0 for shapes that don't overlap at all. The overlap
+// of the ink (weighted Jaccard) is lowered by how different the proportions
+// are, so a dash doesn't match a square that happens to cover it.
+func (shape *glyphShape) similarity(other *glyphShape) float64 {
+	overlap, union := 0.0, 0.0
+	for i := range shape.pix {
+		overlap += math.Min(shape.pix[i], other.pix[i])
+		union += math.Max(shape.pix[i], other.pix[i])
+	}
+	if union == 0 {
+		return 0
+	}
+	proportions := math.Min(shape.aspect, other.aspect) / math.Max(shape.aspect, other.aspect)
+	return overlap / union * proportions
+}
+
+// Draws a character of the font big enough to keep its details once scaled
+// down to a shape
+func fontGlyphShape(face font.Face, char rune) *glyphShape {
+	bounds, _, ok := face.GlyphBounds(char)
+	if !ok {
+		return nil
+	}
+	rect := image.Rect(bounds.Min.X.Floor(), bounds.Min.Y.Floor(), bounds.Max.X.Ceil(), bounds.Max.Y.Ceil())
+	if rect.Empty() {
+		return nil
+	}
+	dst := image.NewAlpha(rect)
+	drawer := font.Drawer{Dst: dst, Src: image.Opaque, Face: face, Dot: fixed.P(0, 0)}
+	drawer.DrawString(string(char))
+
+	return newGlyphShape(dst)
+}
+
+type glyphMatch struct {
+	char      rune // character of the bffnt
+	best      rune // most similar character of the font file
+	score     float64
+	next      rune // runner up, 0 if there's none
+	nextScore float64
+	blank     bool // the bffnt glyph has no ink, it's mapped to the space
+}
+
+// Whether a human should look at the match before it's used
+func (match glyphMatch) doubtful() bool {
+	if match.blank {
+		return false
+	}
+	return match.score < GLYPH_MATCH_CONFIDENT || (match.next != 0 && match.score-match.nextScore < GLYPH_MATCH_CLOSE)
+}
+
+// Every character the font maps in the basic multilingual plane, which is all
+// a bffnt can map
+func fontChars(f *sfnt.Font) []rune {
+	var buf sfnt.Buffer
+	chars := make([]rune, 0)
+	for char := rune(0x21); char <= 0xFFFF; char++ {
+		if char >= 0xD800 && char <= 0xDFFF {
+			continue
+		}
+		if index, err := f.GlyphIndex(&buf, char); err == nil && index != 0 {
+			chars = append(chars, char)
+		}
+	}
+	return chars
+}
+
+// Pairs every mapped character of the font with the most similar glyph among
+// candidates, characters of the font file. Blank glyphs are paired with the
+// space.
+func (b *BFFNT) matchGlyphs(fontFile string, candidates []rune) []glyphMatch {
+	f := parseFontFile(fontFile)
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: float64(4 * b.TGLP.CellHeight), DPI: 72, Hinting: font.HintingNone})
+	handleErr(err)
+	defer face.Close()
+
+	type candidateShape struct {
+		char  rune
+		shape *glyphShape
+	}
+	shapes := make([]candidateShape, 0, len(candidates))
+	for _, char := range candidates {
+		if shape := fontGlyphShape(face, char); shape != nil {
+			shapes = append(shapes, candidateShape{char, shape})
+		}
+	}
+	if len(shapes) == 0 {
+		handleErr(fmt.Errorf("%s has no glyphs with ink to match against", fontFile))
+	}
+
+	b.TGLP.DecodeSheets()
+	b.buildCharIndex()
+	matches := make([]glyphMatch, 0, len(b.charIndexes))
+	for _, entry := range b.charIndexes {
+		sheet, cell := b.TGLP.CellRect(int(entry.index))
+		if sheet >= len(b.TGLP.SheetData) {
+			continue
+		}
+		match := glyphMatch{char: rune(entry.char)}
+		shape := newGlyphShape(imaging.Crop(&b.TGLP.SheetData[sheet], cell))
+		if shape == nil {
+			match.best, match.blank = ' ', true
+			matches = append(matches, match)
+			continue
+		}
+
+		for _, candidate := range shapes {
+			score := shape.similarity(candidate.shape)
+			if score > match.score {
+				match.next, match.nextScore = match.best, match.score
+				match.best, match.score = candidate.char, score
+			} else if score > match.nextScore {
+				match.next, match.nextScore = candidate.char, score
+			}
+		}
+		matches = append(matches, match)
+	}
+
+	return matches
+}
+
+// Writes the matches as a glyph map with the similarities as comments
+func writeGlyphMap(w io.Writer, matches []glyphMatch, bffntFile string, fontFile string) error {
+	lines := []string{
+		fmt.Sprintf("# Glyph mapping proposed by bffnt match-glyphs for %s and %s.", filepath.Base(bffntFile), filepath.Base(fontFile)),
+		"# Review it (lines marked check especially), then pass it to an upscale",
+		"# with -glyph-map. Keys are characters of the bffnt, values the characters",
+		"# of the font file drawn for them. Characters that confidently match the",
+		"# font file's character at the same code aren't listed.",
+		"glyphs:",
+	}
+	for _, match := range matches {
+		if match.best == match.char && !match.doubtful() {
+			continue
+		}
+		line := fmt.Sprintf("  U+%04X: U+%04X # %s", match.char, match.best, formatChar(uint16(match.best)))
+		switch {
+		case match.blank:
+			line += ", the glyph is blank"
+		case match.next != 0:
+			line += fmt.Sprintf(" %.2f, next %s %.2f", match.score, formatChar(uint16(match.next)), match.nextScore)
+		default:
+			line += fmt.Sprintf(" %.2f", match.score)
+		}
+		if match.doubtful() {
+			line += ", check"
+		}
+		lines = append(lines, line)
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+type GlyphMapFile struct {
+	Glyphs map[string]string `yaml:"glyphs"` // bffnt character -> font file character, e.g. U+0041: U+0061
+}
+
+func readGlyphMap(filename string) map[uint16]uint16 {
+	raw, err := ioutil.ReadFile(filename)
+	handleErr(err)
+
+	var file GlyphMapFile
+	handleErr(yaml.Unmarshal(raw, &file))
+	glyphMap := make(map[uint16]uint16, len(file.Glyphs))
+	for key, value := range file.Glyphs {
+		char, err := parseCharFlag(key)
+		if err != nil {
+			handleErr(fmt.Errorf("%s: %v", filename, err))
+		}
+		glyph, err := parseCharFlag(value)
+		if err != nil {
+			handleErr(fmt.Errorf("%s: %s: %v", filename, key, err))
+		}
+		glyphMap[uint16(char)] = uint16(glyph)
+	}
+
+	return glyphMap
+}
+
+// Proposes a glyph mapping between a bffnt and a font file that doesn't line
+// up with it, see writeGlyphMap
+func glyphMatchCommand(args []string) {
+	flags := newCommandFlagSet("match-glyphs", "[flags] font.bffnt")
+	botwFontName := flags.String("font", "", "botw font the bffnt is, picks the default font file")
+	fontFile := flags.String("ttf", "", "font file to match against. Defaults to the one picked for -font")
+	candidates := flags.String("chars", "", "characters of the font file to consider. Empty considers every character it maps")
+	output := flags.String("o", "", "glyph map to write. Defaults to <name>_glyph_map.yaml next to the bffnt")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 || (*fontFile == "" && *botwFontName == "") {
+		exitWithUsage(flags)
+	}
+	*fontFile = resolveFontFile(*botwFontName, *fontFile)
+
+	bffntFile := flags.Arg(0)
+	bffnt := flags.readBffnt(bffntFile)
+	chars := []rune(*candidates)
+	if len(chars) == 0 {
+		chars = fontChars(parseFontFile(*fontFile))
+	}
+	matches := bffnt.matchGlyphs(*fontFile, chars)
+
+	doubtful := 0
+	for _, match := range matches {
+		if match.doubtful() {
+			doubtful++
+		}
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(bffntFile, filepath.Ext(bffntFile)) + "_glyph_map.yaml"
+	}
+	file, err := os.Create(*output)
+	handleErr(err)
+	defer file.Close()
+	handleErr(writeGlyphMap(file, matches, bffntFile, *fontFile))
+	fmt.Printf("matched %d character(s) against %d of %s, %d to check\n", len(matches), len(chars), *fontFile, doubtful)
+	fmt.Println("wrote", *output)
+}
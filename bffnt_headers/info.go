@@ -0,0 +1,88 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+var mappingMethodNames = map[uint16]string{0: "direct", 1: "table", 2: "scan"}
+
+// Prints what's in a font without decoding the widths, character maps or
+// kerning, only their headers. Fast even for huge fonts.
+func infoCommand(args []string) {
+	flags := newCommandFlagSet("info", "[flags] font.bffnt ...")
+	strict := flags.Bool("strict", false, "decode every section and report validation failures")
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		exitWithUsage(flags)
+	}
+
+	for _, bffntFile := range flags.Args() {
+		raw, err := ioutil.ReadFile(bffntFile)
+		handleErr(err)
+
+		bffnt := flags.newBffnt()
+		if err := bffnt.DecodeLazy(raw); err != nil {
+			handleErr(fmt.Errorf("%s: %v", bffntFile, err))
+		}
+		printInfo(filepath.Base(bffntFile), &bffnt, raw, *strict)
+
+		if *strict {
+			failures := bffnt.Validation().Failures
+			for _, failure := range failures {
+				fmt.Println("  validation:", failure)
+			}
+			fmt.Printf("  %d validation failure(s)\n", len(failures))
+		}
+	}
+}
+
+// The characters of the alter char are only looked up with strict, that
+// decodes every CMAP
+func printInfo(name string, b *BFFNT, raw []byte, strict bool) {
+	finf, tglp := b.FINF, b.TGLP
+	fmt.Printf("%s: %s version 0x%08X, %d bytes\n", name, b.FFNT.MagicHeader, b.FFNT.Version, b.FFNT.TotalFileSize)
+	fmt.Printf("  font: type %d, height %d, width %d, ascent %d, line feed %d, encoding %d\n",
+		finf.FontType, finf.Height, finf.Width, finf.Ascent, finf.LineFeed, finf.Encoding)
+	if strict {
+		fmt.Println("  alter char:", b.glyphLabel(int(finf.AlterCharIndex)))
+	} else {
+		fmt.Println("  alter char: glyph", finf.AlterCharIndex)
+	}
+	fmt.Printf("  sheets: %d of %dx%d, format %d, %d bytes each\n",
+		tglp.NumOfSheets, tglp.SheetWidth, tglp.SheetHeight, tglp.SheetImageFormat, tglp.SheetSize)
+	fmt.Printf("  cells: %dx%d, %d columns, %d rows, baseline %d\n",
+		tglp.CellWidth, tglp.CellHeight, tglp.NumOfColumns, tglp.NumOfRows, tglp.BaselinePosition)
+
+	// counts come from the section headers, nothing else is decoded
+	glyphs := 0
+	for _, cwdh := range b.CWDHs {
+		glyphs += int(cwdh.EndIndex) - int(cwdh.StartIndex) + 1
+	}
+	fmt.Printf("  glyphs: %d in %d CWDH(s)\n", glyphs, len(b.CWDHs))
+	fmt.Printf("  character maps: %d CMAP(s)\n", len(b.CMAPs))
+	for i, cmap := range b.CMAPs {
+		fmt.Printf("    CMAP %d: %-6s %s to %s\n", i, mappingMethodNames[cmap.MappingMethod], formatChar(cmap.CodeBegin), formatChar(cmap.CodeEnd))
+	}
+	if b.KRNG.SectionSize > 0 {
+		fmt.Printf("  kerning: %d bytes\n", b.KRNG.SectionSize)
+	} else {
+		fmt.Println("  kerning: none")
+	}
+	for _, section := range b.ExtraSections {
+		if unknown, isUnknown := section.(*UnknownSection); isUnknown {
+			fmt.Printf("  unknown section %q: %d bytes\n", unknown.MagicHeader, len(unknown.Raw))
+		} else {
+			fmt.Printf("  custom section %q\n", section.Magic())
+		}
+	}
+
+	fmt.Println("  sections:")
+	sections := newSectionIterator(raw)
+	for sections.Next() {
+		header := sections.Section()
+		fmt.Printf("    0x%06X  %s  %d bytes\n", header.Start, header.MagicHeader, header.Size)
+	}
+}
@@ -0,0 +1,100 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Where the ink of a glyph actually is in the original sheets. Nintendo's
+// CWDH mostly follows it: the ink starts at the left of the cell, GlyphWidth
+// covers it and it stays between the pen and the advance. Glyphs that don't
+// were made that way on purpose, like a j hanging under the previous
+// character. Auto fit finds the glyphs it leaves alone from the same
+// measurements, see findSpacingOutliers.
+type inkWidth struct {
+	index  int
+	glyph  glyphInfo
+	left   int // first column with ink in the cell
+	width  int // columns from the first to the last one with ink
+	hasInk bool
+}
+
+// BC4 blocks smear faint alpha over the whole block, anything fainter than
+// this isn't counted as ink
+const INK_MIN_ALPHA = 64
+
+// Measures every glyph of the decoded sheets
+func measureInkWidths(tglp *TGLP, glyphs []glyphInfo) []inkWidth {
+	widths := make([]inkWidth, 0, len(glyphs))
+	for i, glyph := range glyphs {
+		sheet, cell := tglp.CellRect(i)
+		if sheet >= len(tglp.SheetData) {
+			break
+		}
+		left, width, hasInk := inkColumnsAbove(&tglp.SheetData[sheet], cell, INK_MIN_ALPHA)
+		widths = append(widths, inkWidth{index: i, glyph: glyph, left: left, width: width, hasInk: hasInk})
+	}
+
+	return widths
+}
+
+// How the glyph's widths differ from its ink by more than threshold pixels,
+// nothing for glyphs whose widths match or that have no ink
+func (ink inkWidth) mismatches(threshold int) []string {
+	if !ink.hasInk {
+		return nil
+	}
+	mismatches := make([]string, 0)
+	inkRight := ink.left + ink.width
+	if ink.left > threshold {
+		mismatches = append(mismatches, fmt.Sprintf("ink starts %d px into the cell", ink.left))
+	}
+	if past := inkRight - int(ink.glyph.GlyphWidth); past > threshold {
+		mismatches = append(mismatches, fmt.Sprintf("ink runs %d px past GlyphWidth %d, the game cuts it off", past, ink.glyph.GlyphWidth))
+	}
+	if blank := int(ink.glyph.GlyphWidth) - inkRight; blank > threshold {
+		mismatches = append(mismatches, fmt.Sprintf("GlyphWidth %d has %d blank px after the ink", ink.glyph.GlyphWidth, blank))
+	}
+	if before := -(int(ink.glyph.LeftWidth) + ink.left); before > threshold {
+		mismatches = append(mismatches, fmt.Sprintf("ink starts %d px before the pen", before))
+	}
+	if past := int(ink.glyph.LeftWidth) + inkRight - int(ink.glyph.CharWidth); past > threshold {
+		mismatches = append(mismatches, fmt.Sprintf("ink ends %d px past the advance %d", past, ink.glyph.CharWidth))
+	}
+
+	return mismatches
+}
+
+func inkWidthsCommand(args []string) {
+	flags := newCommandFlagSet("ink-widths", "[flags] font.bffnt")
+	threshold := flags.Int("threshold", 1, "pixels the widths may differ from the ink before a glyph is listed")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		exitWithUsage(flags)
+	}
+
+	bffnt := flags.readBffnt(flags.Arg(0))
+	if !knownImageFormat(bffnt.TGLP.SheetImageFormat) {
+		handleErr(fmt.Errorf("sheets in %s can't be decoded", imageFormatName(bffnt.TGLP.SheetImageFormat)))
+	}
+	bffnt.TGLP.DecodeSheets()
+
+	listed, blank := 0, 0
+	widths := measureInkWidths(&bffnt.TGLP, bffnt.allGlyphInfo())
+	for _, ink := range widths {
+		if !ink.hasInk {
+			blank++
+			continue
+		}
+		mismatches := ink.mismatches(*threshold)
+		if len(mismatches) == 0 {
+			continue
+		}
+		listed++
+		fmt.Printf("%s: ink %d-%d, LeftWidth %d GlyphWidth %d CharWidth %d: %s\n",
+			bffnt.glyphLabel(ink.index), ink.left, ink.left+ink.width, ink.glyph.LeftWidth, ink.glyph.GlyphWidth, ink.glyph.CharWidth,
+			strings.Join(mismatches, ", "))
+	}
+	fmt.Printf("%d of %d glyph(s) have widths that don't follow their ink, %d have no ink\n", listed, len(widths), blank)
+}
@@ -0,0 +1,322 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// Most OpenType fonts keep their kerning in the GPOS table rather than the old
+// kern table, which is all sfnt reads. Pair adjustments (lookup type 2) of
+// the kern feature are read here, the x advance of the first glyph is the
+// kerning. Device tables, used for hinting at small sizes, are left out.
+const (
+	GPOS_PAIR      = 2
+	GPOS_EXTENSION = 9
+
+	VALUE_X_ADVANCE = 0x0004
+)
+
+// A pair adjustment subtable with its coverage and class definitions looked
+// up once
+type gposPairTable struct {
+	table     otReader
+	format    int
+	coverage  map[sfnt.GlyphIndex]int
+	valueSize int // of the first value record and the second one
+	xAdvance  int // offset of the x advance in the first value record, -1 without one
+	classes1  map[sfnt.GlyphIndex]int
+	classes2  map[sfnt.GlyphIndex]int
+}
+
+func valueRecordSize(valueFormat int) int {
+	size := 0
+	for bit := valueFormat; bit != 0; bit &= bit - 1 {
+		size += 2
+	}
+	return size
+}
+
+// Glyph classes of a class definition table, glyphs it doesn't list are class 0
+func (table otReader) classDef() map[sfnt.GlyphIndex]int {
+	classes := make(map[sfnt.GlyphIndex]int)
+	switch table.u16(0) {
+	case 1:
+		start := table.u16(2)
+		for i := 0; i < table.u16(4); i++ {
+			classes[sfnt.GlyphIndex(start+i)] = table.u16(6 + 2*i)
+		}
+	case 2:
+		for i := 0; i < table.u16(2); i++ {
+			record := 4 + 6*i
+			for glyph := table.u16(record); glyph <= table.u16(record+2); glyph++ {
+				classes[sfnt.GlyphIndex(glyph)] = table.u16(record + 4)
+			}
+		}
+	}
+	return classes
+}
+
+func newGposPairTable(table otReader) gposPairTable {
+	pairTable := gposPairTable{table: table, format: table.u16(0), coverage: make(map[sfnt.GlyphIndex]int), xAdvance: -1}
+	for i, glyph := range table.from(table.u16(2)).coverage() {
+		pairTable.coverage[glyph] = i
+	}
+	valueFormat1, valueFormat2 := table.u16(4), table.u16(6)
+	pairTable.valueSize = valueRecordSize(valueFormat1) + valueRecordSize(valueFormat2)
+	if valueFormat1&VALUE_X_ADVANCE != 0 {
+		pairTable.xAdvance = valueRecordSize(valueFormat1 & (VALUE_X_ADVANCE - 1))
+	}
+	if pairTable.format == 2 {
+		pairTable.classes1 = table.from(table.u16(8)).classDef()
+		pairTable.classes2 = table.from(table.u16(10)).classDef()
+	}
+	return pairTable
+}
+
+// Kerning in font units and whether the subtable has the pair at all
+func (pairTable gposPairTable) kern(first sfnt.GlyphIndex, second sfnt.GlyphIndex) (int, bool) {
+	coverageIndex, covered := pairTable.coverage[first]
+	if !covered || pairTable.xAdvance < 0 {
+		return 0, false
+	}
+	table := pairTable.table
+
+	switch pairTable.format {
+	case 1:
+		if coverageIndex >= table.u16(8) {
+			return 0, false
+		}
+		pairSet := table.from(table.u16(10 + 2*coverageIndex))
+		recordSize := 2 + pairTable.valueSize
+		// the records are sorted by the second glyph
+		count := pairSet.u16(0)
+		i := sort.Search(count, func(i int) bool { return pairSet.u16(2+i*recordSize) >= int(second) })
+		if i < count && pairSet.u16(2+i*recordSize) == int(second) {
+			return int(int16(pairSet.u16(2 + i*recordSize + 2 + pairTable.xAdvance))), true
+		}
+	case 2:
+		class1, class2 := pairTable.classes1[first], pairTable.classes2[second]
+		class1Count, class2Count := table.u16(12), table.u16(14)
+		if class1 >= class1Count || class2 >= class2Count {
+			return 0, false
+		}
+		record := 16 + (class1*class2Count+class2)*pairTable.valueSize
+		return int(int16(table.u16(record + pairTable.xAdvance))), true
+	}
+	return 0, false
+}
+
+// Pair adjustment subtables of the kern feature, in lookup order. The first
+// subtable that has a pair decides it.
+func gposKerning(gpos []byte) []gposPairTable {
+	table := otReader(gpos)
+	featureList := table.from(table.u16(6))
+	lookupList := table.from(table.u16(8))
+
+	var pairTables []gposPairTable
+	seen := make(map[int]bool)
+	for i := 0; i < featureList.u16(0); i++ {
+		record := 2 + 6*i
+		if record+4 > len(featureList) || string(featureList[record:record+4]) != "kern" {
+			continue
+		}
+		featureTable := featureList.from(featureList.u16(record + 4))
+		for j := 0; j < featureTable.u16(2); j++ {
+			lookupIndex := featureTable.u16(4 + 2*j)
+			// every script has its own kern feature pointing at the same lookups
+			if seen[lookupIndex] {
+				continue
+			}
+			seen[lookupIndex] = true
+			lookup := lookupList.from(lookupList.u16(2 + 2*lookupIndex))
+			for k := 0; k < lookup.u16(4); k++ {
+				subtable, lookupType := lookup.from(lookup.u16(6+2*k)), lookup.u16(0)
+				if lookupType == GPOS_EXTENSION {
+					lookupType = subtable.u16(2)
+					subtable = subtable.from(subtable.u32(4))
+				}
+				if lookupType == GPOS_PAIR {
+					pairTables = append(pairTables, newGposPairTable(subtable))
+				}
+			}
+		}
+	}
+	return pairTables
+}
+
+// Kerning of a replacement font in pixels at a font size, from GPOS if the
+// font has kern pairs there and from the kern table otherwise
+type fontKerning struct {
+	f          *sfnt.Font
+	buf        sfnt.Buffer
+	pairTables []gposPairTable
+	size       float64 // pixels per em
+	fontName   string  // botw font whose glyph mapping applies, see asciiToGlyph
+}
+
+func newFontKerning(fontFile string, fontName string, size float64) *fontKerning {
+	path, faceIndex := splitFaceIndex(fontFile)
+	dat, err := os.ReadFile(path)
+	handleErr(err)
+
+	return &fontKerning{
+		f:          parseFontFile(fontFile),
+		pairTables: gposKerning(fontTable(dat, faceIndex, "GPOS")),
+		size:       size,
+		fontName:   fontName,
+	}
+}
+
+func (k *fontKerning) glyph(char rune) sfnt.GlyphIndex {
+	if k.fontName != "" {
+		char = rune(asciiToGlyph(k.fontName, uint16(char)))
+	}
+	index, _ := k.f.GlyphIndex(&k.buf, char)
+	return index
+}
+
+func (k *fontKerning) kern(first rune, second rune) int {
+	firstGlyph, secondGlyph := k.glyph(first), k.glyph(second)
+	if firstGlyph == 0 || secondGlyph == 0 {
+		return 0
+	}
+
+	units := 0
+	if len(k.pairTables) > 0 {
+		for _, pairTable := range k.pairTables {
+			if value, found := pairTable.kern(firstGlyph, secondGlyph); found {
+				units = value
+				break
+			}
+		}
+	} else {
+		// the kern table, at one unit per em to get font units back
+		value, err := k.f.Kern(&k.buf, firstGlyph, secondGlyph, fixed.Int26_6(k.f.UnitsPerEm()), font.HintingNone)
+		if err != nil {
+			return 0
+		}
+		units = int(value)
+	}
+
+	return int(math.Round(float64(units) * k.size / float64(k.f.UnitsPerEm())))
+}
+
+type kerningDifference struct {
+	first, second rune
+	original      int
+	candidate     int
+}
+
+func (diff kerningDifference) delta() int {
+	return diff.candidate - diff.original
+}
+
+// Compares the font's kerning with a replacement font's over the characters
+// both have. Every pair either of them kerns is compared.
+func (b *BFFNT) compareKerning(candidate *fontKerning, shared []rune) (differences []kerningDifference, originalPairs int, candidatePairs int) {
+	b.KRNG.load()
+	for _, first := range shared {
+		for _, second := range shared {
+			original := int(b.KRNG.Kern(first, second))
+			kern := candidate.kern(first, second)
+			if original != 0 {
+				originalPairs++
+			}
+			if kern != 0 {
+				candidatePairs++
+			}
+			if original != 0 || kern != 0 {
+				differences = append(differences, kerningDifference{first, second, original, kern})
+			}
+		}
+	}
+
+	sort.SliceStable(differences, func(i, j int) bool {
+		return absInt(differences[i].delta()) > absInt(differences[j].delta())
+	})
+	return differences, originalPairs, candidatePairs
+}
+
+func absInt(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+// Prints how a candidate replacement font's kerning compares to Nintendo's,
+// to see which pairs would change before committing to the font
+func kerningCompareCommand(args []string) {
+	flags := newCommandFlagSet("kerncompare", "[flags] font.bffnt")
+	botwFontName := flags.String("font", "", "botw font the bffnt is, for its glyph mapping and the default replacement font")
+	fontFile := flags.String("ttf", "", "candidate replacement font. Defaults to the one picked for -font")
+	fontSize := flags.Float64("size", 0, "candidate font size in pixels. 0 uses the size an upscale draws at, scaled back to the original")
+	threshold := flags.Int("threshold", 2, "pixels a pair may differ by before it's listed")
+	limit := flags.Int("limit", 40, "most pairs to list, 0 lists all")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 || (*fontFile == "" && *botwFontName == "") {
+		exitWithUsage(flags)
+	}
+	initializeGlyphMaps()
+	*fontFile = resolveFontFile(*botwFontName, *fontFile)
+
+	bffnt := flags.readBffnt(flags.Arg(0))
+	if *fontSize == 0 {
+		if *botwFontName != "" {
+			// upscales draw at 144 dpi, two pixels per point
+			size := getBotwFontSettings(*botwFontName, 1)
+			*fontSize = size * 2
+		} else {
+			*fontSize = baselineFontSize(*fontFile, int(bffnt.TGLP.BaselinePosition), int(bffnt.TGLP.CellHeight))
+		}
+	}
+
+	bffnt.buildCharIndex()
+	mapped := make([]rune, 0, len(bffnt.charIndexes))
+	for _, entry := range bffnt.charIndexes {
+		mapped = append(mapped, rune(entry.char))
+	}
+	shared, _ := fontCoverage(*fontFile, *botwFontName, mapped)
+	candidate := newFontKerning(*fontFile, *botwFontName, *fontSize)
+	source := "kern table"
+	if len(candidate.pairTables) > 0 {
+		source = "GPOS"
+	}
+
+	differences, originalPairs, candidatePairs := bffnt.compareKerning(candidate, shared)
+	fmt.Printf("%d shared character(s), %d pair(s) kerned by the bffnt, %d by %s (%s at %.1f px)\n",
+		len(shared), originalPairs, candidatePairs, *fontFile, source, *fontSize)
+
+	onlyOriginal, onlyCandidate := 0, 0
+	for _, diff := range differences {
+		if diff.candidate == 0 {
+			onlyOriginal++
+		} else if diff.original == 0 {
+			onlyCandidate++
+		}
+	}
+	fmt.Printf("%d pair(s) only kerned by the bffnt, %d only by the candidate\n", onlyOriginal, onlyCandidate)
+
+	noticeable := 0
+	for _, diff := range differences {
+		if absInt(diff.delta()) < *threshold {
+			break
+		}
+		noticeable++
+		if *limit > 0 && noticeable > *limit {
+			continue
+		}
+		fmt.Printf("  %-14s %-14s nintendo %3d  candidate %3d  %+d\n", formatChar(uint16(diff.first)), formatChar(uint16(diff.second)), diff.original, diff.candidate, diff.delta())
+	}
+	if *limit > 0 && noticeable > *limit {
+		fmt.Printf("  ... and %d more\n", noticeable-*limit)
+	}
+	fmt.Printf("%d pair(s) differ by %d pixel(s) or more\n", noticeable, *threshold)
+}
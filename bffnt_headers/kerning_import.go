@@ -0,0 +1,361 @@
+package bffnt_headers
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Kerning corrections maintained for other tools can be imported by a kerning
+// profile: Adobe AFM metrics (KPX lines) or OpenType feature files (pos
+// statements of a kern feature). Both name glyphs instead of characters and
+// are in font units, see glyphNameChar and KerningProfile.ImportSize.
+
+// AFM files always have 1000 units per em
+const AFM_UNITS_PER_EM = 1000
+
+// Pairs of an AFM or .fea file in font units, by glyph name
+func readKerningImportNames(filename string) (map[[2]string]int, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".afm":
+		return parseAFMKerning(string(raw))
+	case ".fea":
+		return parseFeaKerning(string(raw))
+	default:
+		return nil, fmt.Errorf("%s: kerning can be imported from .afm or .fea files", filename)
+	}
+}
+
+// The pairs of an import by character and the glyph names that aren't one,
+// those pairs are left out. Names of the same character (A, uni0041, A.sc)
+// collide, the pair of the most canonical names wins and between equally
+// canonical ones the first by name, so an import always gives the same pairs.
+func readKerningImport(filename string) (pairs map[[2]rune]int, unknown []string, err error) {
+	names, err := readKerningImportNames(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	namePairs := make([][2]string, 0, len(names))
+	for pair := range names {
+		namePairs = append(namePairs, pair)
+	}
+	sort.Slice(namePairs, func(i, j int) bool {
+		if namePairs[i][0] != namePairs[j][0] {
+			return namePairs[i][0] < namePairs[j][0]
+		}
+		return namePairs[i][1] < namePairs[j][1]
+	})
+
+	pairs = make(map[[2]rune]int, len(names))
+	ranks := make(map[[2]rune]int, len(names))
+	unknownNames := make(map[string]bool)
+	for _, pair := range namePairs {
+		first, firstFound := glyphNameChar(pair[0])
+		second, secondFound := glyphNameChar(pair[1])
+		if !firstFound {
+			unknownNames[pair[0]] = true
+		}
+		if !secondFound {
+			unknownNames[pair[1]] = true
+		}
+		if !firstFound || !secondFound {
+			continue
+		}
+		chars := [2]rune{first, second}
+		rank := glyphNameRank(pair[0], first) + glyphNameRank(pair[1], second)
+		if best, exists := ranks[chars]; exists && best <= rank {
+			continue
+		}
+		pairs[chars] = names[pair]
+		ranks[chars] = rank
+	}
+	for name := range unknownNames {
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+
+	return pairs, unknown, nil
+}
+
+// How far a glyph name is from the character's own name: 0 for the name
+// charGlyphName gives, 1 for another name of it and 2 for an alternate with
+// a dot suffix
+func glyphNameRank(name string, char rune) int {
+	switch {
+	case strings.Index(name, ".") > 0:
+		return 2
+	case name == charGlyphName(char):
+		return 0
+	default:
+		return 1
+	}
+}
+
+// KPX A V -80 and KP A V -80 0, the vertical part is ignored
+func parseAFMKerning(raw string) (map[[2]string]int, error) {
+	pairs := make(map[[2]string]int)
+	scanner := bufio.NewScanner(strings.NewReader(raw))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || (fields[0] != "KPX" && fields[0] != "KP") {
+			continue
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("line %d: %s needs two glyph names and a value", line, fields[0])
+		}
+		value, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		pairs[[2]string{fields[1], fields[2]}] = int(math.Round(value))
+	}
+
+	return pairs, scanner.Err()
+}
+
+// Splits a feature file into statements of tokens. Brackets and the
+// characters ending a statement are tokens of their own.
+func feaStatements(raw string) [][]string {
+	statements := make([][]string, 0)
+	statement := make([]string, 0)
+	for _, line := range strings.Split(raw, "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		for _, special := range []string{";", "[", "]", "<", ">", "=", "{", "}"} {
+			line = strings.ReplaceAll(line, special, " "+special+" ")
+		}
+		for _, token := range strings.Fields(line) {
+			switch token {
+			case ";", "{", "}":
+				if len(statement) > 0 {
+					statements = append(statements, statement)
+				}
+				statement = make([]string, 0)
+			default:
+				statement = append(statement, token)
+			}
+		}
+	}
+
+	return statements
+}
+
+// Glyph classes (@UC = [A B C];) and pair positioning (pos A V -80;,
+// pos @UC @LC <0 0 -80 0>;, enum pos ...). Class pairs are expanded to every
+// pair of their glyphs. Like in a font the glyph pairs win over class pairs
+// and the first class pair wins over later ones. Contextual and other lookups
+// are skipped.
+func parseFeaKerning(raw string) (map[[2]string]int, error) {
+	classes := make(map[string][]string)
+	glyphPairs := make(map[[2]string]int)
+	classPairs := make(map[[2]string]int)
+
+	// A glyph, a @class or [a list], returns the glyphs and the tokens read
+	item := func(tokens []string) ([]string, int, error) {
+		if len(tokens) == 0 {
+			return nil, 0, fmt.Errorf("missing glyph")
+		}
+		if strings.HasPrefix(tokens[0], "@") {
+			glyphs, exists := classes[tokens[0]]
+			if !exists {
+				return nil, 0, fmt.Errorf("unknown glyph class %s", tokens[0])
+			}
+			return glyphs, 1, nil
+		}
+		if tokens[0] != "[" {
+			return []string{strings.TrimPrefix(tokens[0], `\`)}, 1, nil
+		}
+		glyphs := make([]string, 0)
+		for i := 1; i < len(tokens); i++ {
+			switch {
+			case tokens[i] == "]":
+				return glyphs, i + 1, nil
+			case strings.HasPrefix(tokens[i], "@"):
+				class, exists := classes[tokens[i]]
+				if !exists {
+					return nil, 0, fmt.Errorf("unknown glyph class %s", tokens[i])
+				}
+				glyphs = append(glyphs, class...)
+			default:
+				glyphs = append(glyphs, strings.TrimPrefix(tokens[i], `\`))
+			}
+		}
+		return nil, 0, fmt.Errorf("unclosed [")
+	}
+
+	for _, statement := range feaStatements(raw) {
+		if len(statement) >= 3 && strings.HasPrefix(statement[0], "@") && statement[1] == "=" {
+			glyphs, _, err := item(statement[2:])
+			if err != nil {
+				return nil, fmt.Errorf("class %s: %v", statement[0], err)
+			}
+			classes[statement[0]] = glyphs
+			continue
+		}
+
+		tokens := statement
+		if tokens[0] == "enum" || tokens[0] == "enumerate" {
+			tokens = tokens[1:]
+		}
+		if len(tokens) == 0 || (tokens[0] != "pos" && tokens[0] != "position") {
+			continue
+		}
+		if strings.Contains(strings.Join(tokens, " "), "'") {
+			continue // contextual
+		}
+		tokens = tokens[1:]
+		firsts, read, err := item(tokens)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", strings.Join(statement, " "), err)
+		}
+		firstIsClass := read > 1 || strings.HasPrefix(tokens[0], "@")
+		tokens = tokens[read:]
+		seconds, read, err := item(tokens)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", strings.Join(statement, " "), err)
+		}
+		secondIsClass := read > 1 || strings.HasPrefix(tokens[0], "@")
+		tokens = tokens[read:]
+
+		// a single number is the x advance, <x y xAdvance yAdvance> a full record
+		var valueToken string
+		switch {
+		case len(tokens) == 1:
+			valueToken = tokens[0]
+		case len(tokens) == 6 && tokens[0] == "<" && tokens[5] == ">":
+			valueToken = tokens[3]
+		default:
+			continue // single glyph positioning or a named value record
+		}
+		value, err := strconv.ParseFloat(valueToken, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", strings.Join(statement, " "), err)
+		}
+
+		for _, first := range firsts {
+			for _, second := range seconds {
+				pair := [2]string{first, second}
+				if !firstIsClass && !secondIsClass {
+					glyphPairs[pair] = int(math.Round(value))
+				} else if _, exists := classPairs[pair]; !exists {
+					classPairs[pair] = int(math.Round(value))
+				}
+			}
+		}
+	}
+
+	for pair, value := range glyphPairs {
+		classPairs[pair] = value
+	}
+	return classPairs, nil
+}
+
+// Adobe glyph list names of the punctuation, symbols and digits
+var glyphNames = map[string]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#', "dollar": '$', "percent": '%',
+	"ampersand": '&', "quotesingle": '\'', "parenleft": '(', "parenright": ')', "asterisk": '*',
+	"plus": '+', "comma": ',', "hyphen": '-', "period": '.', "slash": '/',
+	"zero": '0', "one": '1', "two": '2', "three": '3', "four": '4',
+	"five": '5', "six": '6', "seven": '7', "eight": '8', "nine": '9',
+	"colon": ':', "semicolon": ';', "less": '<', "equal": '=', "greater": '>', "question": '?',
+	"at": '@', "bracketleft": '[', "backslash": '\\', "bracketright": ']', "asciicircum": '^',
+	"underscore": '_', "grave": '`', "braceleft": '{', "bar": '|', "braceright": '}', "asciitilde": '~',
+	"exclamdown": '¡', "questiondown": '¿', "guillemotleft": '«', "guillemotright": '»',
+	"quoteleft": '‘', "quoteright": '’', "quotedblleft": '“', "quotedblright": '”',
+	"quotesinglbase": '‚', "quotedblbase": '„', "guilsinglleft": '‹', "guilsinglright": '›',
+	"endash": '–', "emdash": '—', "ellipsis": '…', "periodcentered": '·', "bullet": '•',
+	"AE": 'Æ', "ae": 'æ', "OE": 'Œ', "oe": 'œ', "Oslash": 'Ø', "oslash": 'ø',
+	"germandbls": 'ß', "dotlessi": 'ı', "Lslash": 'Ł', "lslash": 'ł', "Eth": 'Ð', "eth": 'ð',
+	"Thorn": 'Þ', "thorn": 'þ',
+}
+
+// Names of characters for exports, the other way around
+var charGlyphNames = func() map[rune]string {
+	names := make(map[rune]string, len(glyphNames))
+	for name, char := range glyphNames {
+		names[char] = name
+	}
+	return names
+}()
+
+// The Adobe glyph list name of a character: ASCII letters are themselves,
+// punctuation and digits have names and everything else is uniXXXX. Every
+// name turns back into the character with glyphNameChar.
+func charGlyphName(char rune) string {
+	if name, exists := charGlyphNames[char]; exists {
+		return name
+	}
+	if (char >= 'A' && char <= 'Z') || (char >= 'a' && char <= 'z') {
+		return string(char)
+	}
+	if char > 0xFFFF {
+		return fmt.Sprintf("u%X", char)
+	}
+	return fmt.Sprintf("uni%04X", char)
+}
+
+// Accents of composed glyph names like Aacute, put on the base letter
+var glyphNameAccents = []struct {
+	suffix string
+	mark   rune
+}{
+	{"circumflex", '̂'}, {"hungarumlaut", '̋'}, {"dotaccent", '̇'},
+	{"dieresis", '̈'}, {"cedilla", '̧'}, {"macron", '̄'},
+	{"ogonek", '̨'}, {"acute", '́'}, {"grave", '̀'}, {"tilde", '̃'},
+	{"caron", '̌'}, {"breve", '̆'}, {"ring", '̊'},
+}
+
+// The character of a glyph name following the Adobe glyph list rules: a
+// suffix after a dot is dropped, uniXXXX and uXXXX[XX] are code points, a
+// single character is itself and the common names and accented letters are
+// looked up. Ligatures and names of alternates without a base aren't a single
+// character.
+func glyphNameChar(name string) (rune, bool) {
+	if dot := strings.Index(name, "."); dot > 0 {
+		name = name[:dot]
+	}
+	if utf8.RuneCountInString(name) == 1 {
+		char, _ := utf8.DecodeRuneInString(name)
+		return char, true
+	}
+	if char, exists := glyphNames[name]; exists {
+		return char, true
+	}
+	hex := ""
+	switch {
+	case strings.HasPrefix(name, "uni") && len(name) == 7:
+		hex = name[3:]
+	case strings.HasPrefix(name, "u") && len(name) >= 5 && len(name) <= 7:
+		hex = name[1:]
+	}
+	if hex != "" {
+		if code, err := strconv.ParseUint(hex, 16, 32); err == nil && code <= utf8.MaxRune {
+			return rune(code), true
+		}
+	}
+	for _, accent := range glyphNameAccents {
+		if base := strings.TrimSuffix(name, accent.suffix); base != name && utf8.RuneCountInString(base) == 1 {
+			composed := []rune(norm.NFC.String(base + string(accent.mark)))
+			if len(composed) == 1 {
+				return composed[0], true
+			}
+		}
+	}
+
+	return 0, false
+}
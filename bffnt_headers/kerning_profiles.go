@@ -0,0 +1,268 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Kerning tweaks are kept in named profiles so one file can serve several
+// localization mods, see kerning.yaml for an example. A profile is merged over
+// the font's KRNG: its pairs replace the font's value for the same pair, a 0
+// removes the pair, every other pair is left alone.
+type KerningProfile struct {
+	Extends []string       `yaml:"extends"` // profiles merged first, later ones win
+	Pairs   map[string]int `yaml:"pairs"`   // "AV" or "U+0410 U+0412" -> kerning in 720p pixels
+
+	// Kerning of an AFM or .fea file merged over the extended profiles and
+	// under Pairs, relative to the profiles file. Its font units are scaled
+	// to ImportSize 720p pixels per em, pairs that round to 0 are left out.
+	Import     string  `yaml:"import"`
+	ImportSize float64 `yaml:"import_size"`
+	UnitsPerEm int     `yaml:"units_per_em"` // of a .fea's font, defaults to 1000 like AFM
+}
+
+type KerningProfiles struct {
+	Profiles map[string]KerningProfile `yaml:"profiles"`
+
+	dir string // imports are relative to it
+}
+
+func readKerningProfiles(filename string) KerningProfiles {
+	raw, err := ioutil.ReadFile(filename)
+	handleErr(err)
+
+	var profiles KerningProfiles
+	handleErr(yaml.Unmarshal(raw, &profiles))
+	if len(profiles.Profiles) == 0 {
+		handleErr(fmt.Errorf("%s: no profiles", filename))
+	}
+	profiles.dir = filepath.Dir(filename)
+
+	return profiles
+}
+
+func (profiles KerningProfiles) names() []string {
+	names := make([]string, 0, len(profiles.Profiles))
+	for name := range profiles.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Reads "AV" or two characters or code points separated by a space
+func parseKerningPair(key string) (first rune, second rune, err error) {
+	parts := strings.Fields(key)
+	if len(parts) == 1 && len([]rune(parts[0])) == 2 {
+		chars := []rune(parts[0])
+		return chars[0], chars[1], nil
+	}
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("kerning pair %q has to be two characters, e.g. AV or U+0410 U+0412", key)
+	}
+	if first, err = parseCharFlag(parts[0]); err != nil {
+		return 0, 0, err
+	}
+	second, err = parseCharFlag(parts[1])
+
+	return first, second, err
+}
+
+// The pairs of a profile with the ones it extends merged under them
+func (profiles KerningProfiles) resolve(name string) (map[[2]rune]int, error) {
+	return profiles.resolveFrom(name, nil)
+}
+
+func (profiles KerningProfiles) resolveFrom(name string, seen []string) (map[[2]rune]int, error) {
+	for _, parent := range seen {
+		if parent == name {
+			return nil, fmt.Errorf("kerning profiles extend each other in a loop: %s -> %s", strings.Join(seen, " -> "), name)
+		}
+	}
+	profile, exists := profiles.Profiles[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown kerning profile %q. Known profiles: %s", name, strings.Join(profiles.names(), ", "))
+	}
+
+	pairs := make(map[[2]rune]int)
+	for _, parent := range profile.Extends {
+		parentPairs, err := profiles.resolveFrom(parent, append(seen, name))
+		if err != nil {
+			return nil, err
+		}
+		for pair, value := range parentPairs {
+			pairs[pair] = value
+		}
+	}
+	if profile.Import != "" {
+		imported, err := profiles.importKerning(profile)
+		if err != nil {
+			return nil, fmt.Errorf("kerning profile %q: %v", name, err)
+		}
+		for pair, value := range imported {
+			pairs[pair] = value
+		}
+	}
+	for key, value := range profile.Pairs {
+		first, second, err := parseKerningPair(key)
+		if err != nil {
+			return nil, fmt.Errorf("kerning profile %q: %v", name, err)
+		}
+		if value < -32768 || value > 32767 {
+			return nil, fmt.Errorf("kerning profile %q: %q kerning %d is out of range", name, key, value)
+		}
+		pairs[[2]rune{first, second}] = value
+	}
+
+	return pairs, nil
+}
+
+func (profiles KerningProfiles) importFile(profile KerningProfile) string {
+	return filepath.Join(profiles.dir, profile.Import)
+}
+
+// The pairs of a profile's import in 720p pixels
+func (profiles KerningProfiles) importKerning(profile KerningProfile) (map[[2]rune]int, error) {
+	if profile.ImportSize <= 0 {
+		return nil, fmt.Errorf("import needs an import_size, the 720p pixels per em its kerning is scaled to")
+	}
+	unitsPerEm := profile.UnitsPerEm
+	if unitsPerEm == 0 || strings.EqualFold(filepath.Ext(profile.Import), ".afm") {
+		unitsPerEm = AFM_UNITS_PER_EM
+	}
+
+	filename := profiles.importFile(profile)
+	imported, unknown, err := readKerningImport(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(unknown) > 0 {
+		fmt.Printf("warning: %s: skipped the pairs of %d glyph name(s) that aren't a character: %s\n", filename, len(unknown), strings.Join(unknown, " "))
+	}
+
+	pairs := make(map[[2]rune]int, len(imported))
+	for pair, units := range imported {
+		value := int(math.Round(float64(units) * profile.ImportSize / float64(unitsPerEm)))
+		if value < -32768 || value > 32767 {
+			return nil, fmt.Errorf("%s: %c%c kerning %d is out of range", filename, pair[0], pair[1], value)
+		}
+		if value != 0 {
+			pairs[pair] = value
+		}
+	}
+
+	return pairs, nil
+}
+
+// Sets the kerning of a pair, 0 removes it. Second characters stay sorted,
+// the game looks them up with a binary search.
+func (krng *KRNG) SetPair(first uint16, second uint16, value int16) {
+	krng.load()
+	if krng.KerningTable == nil {
+		krng.KerningTable = make(map[uint16][]kerningPair)
+	}
+
+	pairs := krng.KerningTable[first]
+	position := sort.Search(len(pairs), func(i int) bool { return pairs[i].SecondChar >= second })
+	exists := position < len(pairs) && pairs[position].SecondChar == second
+	switch {
+	case value == 0 && exists:
+		pairs = append(pairs[:position], pairs[position+1:]...)
+	case value == 0:
+		return
+	case exists:
+		pairs[position].KerningValue = value
+	default:
+		pairs = append(pairs, kerningPair{})
+		copy(pairs[position+1:], pairs[position:])
+		pairs[position] = kerningPair{second, value}
+	}
+
+	if len(pairs) == 0 {
+		delete(krng.KerningTable, first)
+	} else {
+		krng.KerningTable[first] = pairs
+	}
+}
+
+// Merges a profile's pairs over the kerning, scaled like the rest of the font.
+// Pairs with a character the font doesn't map are skipped so a profile can be
+// used with fonts that only have some of its characters. Returns the amount of
+// pairs set and skipped.
+func (b *BFFNT) ApplyKerningProfile(pairs map[[2]rune]int, scale float64) (applied int, skipped int) {
+	var clamped clampedMetrics
+	for pair, value := range pairs {
+		_, firstFound := b.CharIndex(pair[0])
+		_, secondFound := b.CharIndex(pair[1])
+		if !firstFound || !secondFound {
+			skipped++
+			continue
+		}
+		scaled := int(scaleMetric(float64(value), scale))
+		kerning, fits := clampToKerning(scaled)
+		if !fits {
+			clamped.add("kerning %s %s %d", formatChar(uint16(pair[0])), formatChar(uint16(pair[1])), scaled)
+		}
+		b.KRNG.SetPair(uint16(pair[0]), uint16(pair[1]), kerning)
+		applied++
+	}
+	warnClampedMetrics(fmt.Sprintf("in the kerning profile scaled by %v", scale), clamped)
+
+	return applied, skipped
+}
+
+// Applies the named profile of a profiles file, used by upscale runs
+func (b *BFFNT) applyKerningProfile(profilesFile string, name string, scale float64) {
+	pairs, err := readKerningProfiles(profilesFile).resolve(name)
+	handleErr(err)
+	applied, skipped := b.ApplyKerningProfile(pairs, scale)
+	fmt.Printf("applied %d kerning pair(s) of profile %s", applied, name)
+	if skipped > 0 {
+		fmt.Printf(", skipped %d with characters the font doesn't map", skipped)
+	}
+	fmt.Println()
+}
+
+// Lists the profiles of a file or merges one over an existing bffnt's kerning
+func kerningCommand(args []string) {
+	flags := newCommandFlagSet("kerning", "[flags] kerning.yaml [font.bffnt]")
+	profile := flags.String("profile", "", "profile to merge over the font's kerning")
+	scale := flags.Float64("scale", 1, "scale the font was upscaled by, profile values are 720p pixels")
+	output := flags.String("o", "", "output file. Defaults to <name>_<profile>.bffnt next to the input")
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 1 || flags.NArg() > 2 || (flags.NArg() == 2) != (*profile != "") {
+		exitWithUsage(flags)
+	}
+
+	profiles := readKerningProfiles(flags.Arg(0))
+	if *profile == "" {
+		for _, name := range profiles.names() {
+			pairs, err := profiles.resolve(name)
+			handleErr(err)
+			fmt.Printf("%s: %d pair(s)\n", name, len(pairs))
+		}
+		return
+	}
+
+	bffntFile := flags.Arg(1)
+	bffnt := flags.readBffnt(bffntFile)
+	bffnt.applyKerningProfile(flags.Arg(0), *profile, *scale)
+
+	if *output == "" {
+		name := strings.TrimSuffix(bffntFile, filepath.Ext(bffntFile))
+		*output = name + "_" + *profile + filepath.Ext(bffntFile)
+	}
+	encoded := bffnt.Encode()
+	handleErr(bffnt.VerifyEncoded(encoded))
+	handleErr(os.WriteFile(*output, encoded, 0644))
+	fmt.Println("wrote", *output)
+}
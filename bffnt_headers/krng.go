@@ -1,13 +1,9 @@
 package bffnt_headers
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/binary"
 	"fmt"
-	"math"
 	"sort"
-	"strings"
 )
 
 type kerningPair struct {
@@ -29,6 +25,7 @@ type KRNG struct { // Offset  Size  Description
 	// KerningValue       0x12    0x02  Kerning value
 
 	KerningTable map[uint16][]kerningPair
+	Leftovers    []byte // non zero bytes after the kerning data, only kept with LEFTOVER_PRESERVE
 	// Key = First character of a pair
 	// In order to save space, Nintendo represents the kerning pairs as a map of
 	// pair arrays. The key of the map is the first character of the pair. The
@@ -43,14 +40,21 @@ type KRNG struct { // Offset  Size  Description
 	// [ A ] | [( V, -1 ), ( W, -1 ), ( Y, -1 )]
 	// [ L ] | [( V, -1 ), ( T, -1 ), ( W, -1 )]
 	// [ P ] | [( d, -2 ), ( g, -2 ), ( y, -1 )]
+
+	lazy           lazySection
+	log            *Logger // the font's logger, see BFFNT.Log
+	leftoverPolicy string  // see BFFNT.LeftoverPolicy
 }
 
-// The kerning index table doesn't seem to be recorded in any headers. It is
-// most likely usually the last section.
-func (krng *KRNG) Decode(bffntRaw []byte) {
-	// Since the kerning offset is not recorded we need to find it first.
-	headerStart := strings.Index(string(bffntRaw), KRNG_MAGIC_HEADER)
-	if headerStart == -1 {
+// The kerning table is optional and isn't referenced by FINF. When present
+// it's found by walking the sections, it usually follows the last CMAP. Like
+// the other sections krngOffset points 8 bytes past the start of the section.
+// A missing KRNG leaves the kerning table empty. Offsets are added up as int
+// and checked against the section, a table that points outside of it panics
+// with the first character and offset like the other sections' decoders.
+func (krng *KRNG) Decode(bffntRaw []byte, krngOffset uint32) {
+	headerStart := int(krngOffset) - 8
+	if headerStart+KRNG_HEADER_SIZE > len(bffntRaw) || string(bffntRaw[headerStart:headerStart+4]) != KRNG_MAGIC_HEADER {
 		// fmt.Println("no kerning table")
 		return
 	}
@@ -62,17 +66,23 @@ func (krng *KRNG) Decode(bffntRaw []byte) {
 	krng.MagicHeader = string(headerRaw[0:4])
 	krng.SectionSize = binary.BigEndian.Uint32(headerRaw[4:8])
 
-	// if Debug {
-	// 	pprint(krng)
-	// }
+	// krng.log.debugJSON(krng)
 
 	totalDataBytesRead := 0
 
 	// fmt.Println(krng.MagicHeader)
 	// fmt.Println(krng.SectionSize)
 
-	dataEnd := headerStart + int(krng.SectionSize)
+	dataEnd := int64(headerStart) + int64(krng.SectionSize)
+	if dataEnd < int64(headerEnd) || dataEnd > int64(len(bffntRaw)) {
+		panic(fmt.Sprintf("KRNG at offset %d has a size of %d but the file ends at offset %d", headerStart, krng.SectionSize, len(bffntRaw)))
+	}
 	data := bffntRaw[headerEnd:dataEnd]
+	if len(data) < 2 {
+		// header only, not even room for the first char count
+		krng.KerningTable = make(map[uint16][]kerningPair)
+		return
+	}
 
 	// fmt.Println(dataEnd - headerStart)
 
@@ -85,9 +95,12 @@ func (krng *KRNG) Decode(bffntRaw []byte) {
 
 	kerningMap := make(map[uint16][]kerningPair, 0)
 	// loop through first chars and their offset to the array of kerning pairs
+	if tableEnd := dataPos + 4*int(firstCharCount); tableEnd > len(data) {
+		panic(fmt.Sprintf("KRNG: %d first characters need %d bytes but the kerning data at offset %d is %d bytes", firstCharCount, tableEnd, headerEnd, len(data)))
+	}
 	for i := 0; i < int(firstCharCount); i++ {
 		firstChar := binary.BigEndian.Uint16(data[dataPos : dataPos+2])
-		secondCharOffset := binary.BigEndian.Uint16(data[dataPos+2 : dataPos+4])
+		secondCharOffset := int(binary.BigEndian.Uint16(data[dataPos+2 : dataPos+4]))
 		dataPos += 4
 		totalDataBytesRead += 4
 
@@ -99,20 +112,26 @@ func (krng *KRNG) Decode(bffntRaw []byte) {
 		// because a single uint16 might not be big enough for an offset if the
 		// kerning table is too large
 		realSecondCharOffset := secondCharOffset * 2
-		secondCharCount := binary.BigEndian.Uint16(data[realSecondCharOffset : realSecondCharOffset+2])
+		if realSecondCharOffset+2 > len(data) {
+			panic(fmt.Sprintf("KRNG: the pairs of %s would be at offset %d, past the end of the kerning data at offset %d", formatChar(firstChar), headerEnd+realSecondCharOffset, headerEnd+len(data)))
+		}
+		secondCharCount := int(binary.BigEndian.Uint16(data[realSecondCharOffset : realSecondCharOffset+2]))
 		totalDataBytesRead += 2
 
 		// fmt.Println("real char offset:", realSecondCharOffset)
 		// fmt.Println("second char count:", secondCharCount)
 
 		pairDataStart := realSecondCharOffset + 2
-		pairDataEnd := realSecondCharOffset + 2 + secondCharCount*4
+		pairDataEnd := pairDataStart + secondCharCount*4
+		if pairDataEnd > len(data) {
+			panic(fmt.Sprintf("KRNG: the %d pairs of %s at offset %d end at offset %d, past the end of the kerning data at offset %d", secondCharCount, formatChar(firstChar), headerEnd+pairDataStart, headerEnd+pairDataEnd, headerEnd+len(data)))
+		}
 		pairData := data[pairDataStart:pairDataEnd]
 
 		// Go to offset and record kerning pairs for this char
 		pairPos := 0
 		kerningPairSlice := make([]kerningPair, 0)
-		for j := 0; j < int(secondCharCount); j++ {
+		for j := 0; j < secondCharCount; j++ {
 			secondChar := binary.BigEndian.Uint16(pairData[pairPos : pairPos+2])
 			kerningValue := int16(binary.BigEndian.Uint16(pairData[pairPos+2 : pairPos+4]))
 
@@ -129,38 +148,58 @@ func (krng *KRNG) Decode(bffntRaw []byte) {
 
 	krng.KerningTable = kerningMap
 
+	if totalDataBytesRead > len(data) {
+		panic(fmt.Sprintf("KRNG: the kerning data at offset %d adds up to %d bytes but the section only has %d", headerEnd, totalDataBytesRead, len(data)))
+	}
 	padding := data[totalDataBytesRead:]
-	verifyLeftoverBytes(padding)
+	krng.Leftovers = verifyLeftoverBytes(krng.log, krng.leftoverPolicy, "KRNG", headerEnd+totalDataBytesRead, padding)
 
-	if Debug {
+	if krng.log.debugging() {
 		dataPosEnd := headerEnd + totalDataBytesRead
-		fmt.Printf("Read section total of %d bytes\n", totalDataBytesRead)
-		fmt.Println("Byte offsets start(inclusive) to end(exclusive)================")
-		fmt.Printf("header           %-8d to  %d\n", headerStart, headerEnd)
-		fmt.Printf("data calculated  %-8d to  %d\n", headerEnd, dataPosEnd)
-		fmt.Printf("padding          %-8d to  %d\n", dataPosEnd, dataPosEnd+len(padding))
-		fmt.Println()
+		krng.log.debugf("Read section total of %d bytes\n", totalDataBytesRead)
+		krng.log.debugln("Byte offsets start(inclusive) to end(exclusive)================")
+		krng.log.debugf("header           %-8d to  %d\n", headerStart, headerEnd)
+		krng.log.debugf("data calculated  %-8d to  %d\n", headerEnd, dataPosEnd)
+		krng.log.debugf("padding          %-8d to  %d\n", dataPosEnd, dataPosEnd+len(padding))
+		krng.log.debugln()
 	}
 
 }
 
+// Same as the other sections the start offset already has the +8 added to it.
+// Fonts without kerning don't have a KRNG section at all so nothing is
+// written.
 func (krng *KRNG) Encode(startOffset uint32) []byte {
-	if len(krng.KerningTable) == 0 {
+	if !krng.Present() {
 		return []byte{}
 	}
 
-	var dataBuf bytes.Buffer
-	dataWriter := bufio.NewWriter(&dataBuf)
-
 	firstChars := getFirstCharsOrdered(krng.KerningTable)
+	firstCharCount := checkedUint16("KRNG first character count", int64(len(firstChars)))
+
+	// amount of first chars, then a first char and offset for every first
+	// char, then every first char's pairs with their count in front
+	dataSize := 2 + len(firstChars)*4 + len(krng.Leftovers)
+	for _, firstChar := range firstChars {
+		dataSize += 2 + 4*len(krng.KerningTable[firstChar])
+	}
+	padding := paddingToNext4ByteBoundary(int(startOffset) - 8 + dataSize)
+
+	// Edit krng header
+	krng.MagicHeader = KRNG_MAGIC_HEADER
+	krng.SectionSize = checkedUint32("KRNG section size", int64(KRNG_HEADER_SIZE)+int64(dataSize+padding))
+
+	w := newSectionWriter("KRNG", int(krng.SectionSize))
+	w.magic(krng.MagicHeader)
+	w.u32(krng.SectionSize)
 
 	// Write amount of first chars
-	binaryWrite(dataWriter, uint16(len(firstChars)))
+	w.u16(firstCharCount)
 
 	secondCharDataOffset := len(firstChars)*4 + 2 // +2 for amount of first chars
 	for _, firstChar := range firstChars {
-		binaryWrite(dataWriter, firstChar)
-		binaryWrite(dataWriter, uint16(secondCharDataOffset/2))
+		w.u16(firstChar)
+		w.u16(checkedUint16("KRNG kerning data offset / 2", int64(secondCharDataOffset/2)))
 		// Nintendo divides the actual second character data offset by 2 before
 		// recording it. This is because the kerning table consist of only uint16s
 		// and int16s which means bytes are written in pairs (2 bytes).  By
@@ -174,32 +213,23 @@ func (krng *KRNG) Encode(startOffset uint32) []byte {
 
 	// Write kerning Data
 	for _, firstChar := range firstChars {
-		secondCharCount := uint16(len(krng.KerningTable[firstChar]))
-		binaryWrite(dataWriter, secondCharCount)
+		secondCharCount := checkedUint16("KRNG second character count", int64(len(krng.KerningTable[firstChar])))
+		w.u16(secondCharCount)
 
 		for _, kerningPair := range krng.KerningTable[firstChar] {
-			binaryWrite(dataWriter, kerningPair.SecondChar)
-			binaryWrite(dataWriter, kerningPair.KerningValue)
+			w.u16(kerningPair.SecondChar)
+			w.i16(kerningPair.KerningValue)
 		}
 	}
-	dataWriter.Flush()
-
-	padToNext4ByteBoundary(dataWriter, &dataBuf, int(startOffset))
-
-	krngData := dataBuf.Bytes()
-	// Edit krng header
-	krng.SectionSize = uint32(KRNG_HEADER_SIZE + len(krngData))
+	w.raw(krng.Leftovers)
+	w.zeros(padding)
 
-	var buf bytes.Buffer
-	w := bufio.NewWriter(&buf)
-	// Write raw data of the header and data
-	_, _ = w.Write([]byte(KRNG_MAGIC_HEADER))
-	binaryWrite(w, krng.SectionSize)
-	_, _ = w.Write(krngData)
-
-	w.Flush()
+	return w.mustBytes()
+}
 
-	return buf.Bytes()
+// Optional sections are only written when they have something in them
+func (krng *KRNG) Present() bool {
+	return len(krng.KerningTable) > 0
 }
 
 // takes the kerning table and returns the inputs in order.  Not functionally
@@ -223,14 +253,36 @@ func getFirstCharsOrdered(kerningTable map[uint16][]kerningPair) []uint16 {
 }
 
 func (krng *KRNG) Upscale(scale float64) {
-	for _, kPairs := range krng.KerningTable {
+	var clamped clampedMetrics
+	for first, kPairs := range krng.KerningTable {
 		for i, pair := range kPairs {
-			kPairs[i].KerningValue = int16(math.Ceil(float64(pair.KerningValue) * scale))
+			value := int(scaleMetric(float64(pair.KerningValue), scale))
+			var fits bool
+			if kPairs[i].KerningValue, fits = clampToKerning(value); !fits {
+				clamped.add("kerning %s %s %d", formatChar(first), formatChar(pair.SecondChar), value)
+			}
+		}
+	}
+	warnClampedMetrics(fmt.Sprintf("after scaling the kerning by %v", scale), clamped)
+}
+
+// Adds amount to every kerning value
+func (krng *KRNG) AdjustKerning(amount int) {
+	var clamped clampedMetrics
+	for first, kPairs := range krng.KerningTable {
+		for i, pair := range kPairs {
+			value := int(pair.KerningValue) + amount
+			var fits bool
+			if kPairs[i].KerningValue, fits = clampToKerning(value); !fits {
+				clamped.add("kerning %s %s %d", formatChar(first), formatChar(pair.SecondChar), value)
+			}
 		}
 	}
+	warnClampedMetrics(fmt.Sprintf("after adjusting the kerning by %d", amount), clamped)
 }
 
 func (krng *KRNG) Kern(r1 rune, r2 rune) int16 {
+	krng.load()
 	pairs, hasEntry := krng.KerningTable[uint16(r1)]
 	if hasEntry {
 		for _, s := range pairs {
@@ -242,3 +294,26 @@ func (krng *KRNG) Kern(r1 rune, r2 rune) int16 {
 
 	return 0
 }
+
+// Removes every pair that keep returns false for. First characters that end
+// up without any pairs are removed too. Returns the amount of pairs removed.
+func (krng *KRNG) RemovePairs(keep func(firstChar uint16, secondChar uint16) bool) int {
+	removed := 0
+	for firstChar, kPairs := range krng.KerningTable {
+		kept := make([]kerningPair, 0, len(kPairs))
+		for _, pair := range kPairs {
+			if keep(firstChar, pair.SecondChar) {
+				kept = append(kept, pair)
+			}
+		}
+		removed += len(kPairs) - len(kept)
+
+		if len(kept) == 0 {
+			delete(krng.KerningTable, firstChar)
+		} else {
+			krng.KerningTable[firstChar] = kept
+		}
+	}
+
+	return removed
+}
@@ -0,0 +1,96 @@
+package bffnt_headers
+
+// A section whose header was decoded but whose data wasn't yet. raw is the
+// whole file, it's shared and not copied. Like every other section offset,
+// offset points 8 bytes past the start of the section.
+type lazySection struct {
+	raw    []byte
+	offset uint32
+}
+
+func (section lazySection) pending() bool {
+	return section.raw != nil
+}
+
+// Hands out the file and offset to decode from, a section is only decoded once
+func (section *lazySection) take() ([]byte, uint32) {
+	raw, offset := section.raw, section.offset
+	*section = lazySection{}
+
+	return raw, offset
+}
+
+// Decodes only what's needed to describe a font: the FINF and TGLP headers and
+// the headers of every other section. Glyph widths, character maps and kerning
+// are decoded the first time they're looked up, or all at once by Load. Sheet
+// data stays a slice of raw like it does in Decode, so raw must not change
+// while the font is in use.
+//
+// A 40 MB CJK font has tens of thousands of width and character entries, most
+// commands only need a handful of them or none at all.
+func (b *BFFNT) DecodeLazy(raw []byte) error {
+	return b.DecodeSections(raw, 0)
+}
+
+// Decodes only the wanted sections, e.g. SectionCWDH|SectionKRNG for a tool
+// that edits widths and kerning and never looks at characters. The FINF and
+// TGLP headers are always decoded since everything else hangs off them, the
+// other sections are left for later like in DecodeLazy and still get decoded
+// when something needs them. CWDHIndexMap is only built right away when
+// SectionCMAP is wanted, Load builds it otherwise.
+func (b *BFFNT) DecodeSections(raw []byte, sections SectionMask) error {
+	b.CWDHIndexMap = nil
+	report := b.decodeSections(raw, sections)
+	if report.Err != nil {
+		return report.Err
+	}
+	if sections&SectionCMAP != 0 {
+		b.buildCWDHIndexMap()
+		b.buildCharIndex()
+	}
+
+	b.validateFileHeader(len(raw))
+	return nil
+}
+
+// Decodes everything DecodeLazy left for later. Has to be called before
+// changing a lazily decoded font or reading Glyphs, CharAscii, CharIndex or
+// KerningTable directly. Does nothing for fonts that were decoded completely.
+func (b *BFFNT) Load() {
+	b.loadCWDHs()
+	b.loadCMAPs()
+	b.KRNG.load()
+	if b.CWDHIndexMap == nil {
+		b.buildCWDHIndexMap()
+	}
+}
+
+func (b *BFFNT) loadCWDHs() {
+	for i := range b.CWDHs {
+		b.CWDHs[i].load()
+	}
+}
+
+func (b *BFFNT) loadCMAPs() {
+	for i := range b.CMAPs {
+		b.CMAPs[i].load()
+	}
+}
+
+func (cwdh *CWDH) load() {
+	if cwdh.lazy.pending() {
+		cwdh.Decode(cwdh.lazy.take())
+	}
+}
+
+func (cmap *CMAP) load() {
+	if cmap.lazy.pending() {
+		cmap.Decode(cmap.lazy.take())
+	}
+}
+
+func (krng *KRNG) load() {
+	if krng.lazy.pending() {
+		krng.Decode(krng.lazy.take())
+	}
+}
@@ -0,0 +1,120 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Largest texture dimension the GPU of each platform can sample. GX2 on the
+// Wii U tops out at 8192, the Switch at 16384. A sheet past the limit doesn't
+// fail to load, the console just hangs while drawing text.
+var platformTextureLimits = map[string]int{
+	"wiiu":   8192,
+	"switch": 16384,
+}
+
+// Cell sizes are stored as a single byte in the TGLP header
+const MAX_CELL_SIZE = 255
+
+// Makes sure a layout can be stored in the header and drawn on the platform.
+// The error suggests a column count or scale that would fit.
+func checkSheetLimits(layout sheetLayout, glyphCount int, scale float64, platform string) error {
+	maxSize, exists := platformTextureLimits[strings.ToLower(platform)]
+	if !exists {
+		return fmt.Errorf("unknown platform %q. Known platforms: %s", platform, strings.Join(platformNames(), ", "))
+	}
+
+	if layout.cellWidth > MAX_CELL_SIZE || layout.cellHeight > MAX_CELL_SIZE {
+		largestCell := math.Max(float64(layout.cellWidth), float64(layout.cellHeight))
+		return fmt.Errorf("cells would be %dx%d but cell sizes can't be larger than %d. Use a scale of at most %.2f",
+			layout.cellWidth, layout.cellHeight, MAX_CELL_SIZE, maxScaleFor(scale, largestCell, MAX_CELL_SIZE))
+	}
+
+	if layout.sheetWidth <= maxSize && layout.sheetHeight <= maxSize {
+		return nil
+	}
+
+	message := fmt.Sprintf("sheet would be %dx%d but %s textures can't be larger than %dx%d.",
+		layout.sheetWidth, layout.sheetHeight, platform, maxSize, maxSize)
+	if columns, fits := columnsThatFit(layout, glyphCount, maxSize); fits {
+		relayout := layout.withColumns(columns, glyphCount)
+		message += fmt.Sprintf(" Use -columns %d for a %dx%d sheet or", columns, relayout.sheetWidth, relayout.sheetHeight)
+	}
+	largestSheet := math.Max(float64(layout.sheetWidth), float64(layout.sheetHeight))
+	message += fmt.Sprintf(" use a scale of at most %.2f", maxScaleFor(scale, largestSheet, maxSize))
+
+	return fmt.Errorf("%s", message)
+}
+
+// Some texture paths only accept power of two dimensions. "round" pads the
+// sheet up to the next power of two, "require" fails when a dimension isn't
+// one already. An empty policy leaves the layout alone.
+func applyPowerOfTwoPolicy(layout *sheetLayout, policy string) error {
+	switch policy {
+	case "":
+		return nil
+	case "round":
+		layout.sheetWidth = nextPowerOfTwo(layout.sheetWidth)
+		layout.sheetHeight = nextPowerOfTwo(layout.sheetHeight)
+		return nil
+	case "require":
+		if !isPowerOfTwo(layout.sheetWidth) || !isPowerOfTwo(layout.sheetHeight) {
+			return fmt.Errorf("sheet would be %dx%d which is not a power of two. Use -pow2 round to pad it to %dx%d",
+				layout.sheetWidth, layout.sheetHeight, nextPowerOfTwo(layout.sheetWidth), nextPowerOfTwo(layout.sheetHeight))
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown power of two policy %q. Use round or require", policy)
+}
+
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+func nextPowerOfTwo(n int) int {
+	res := 1
+	for res < n {
+		res *= 2
+	}
+
+	return res
+}
+
+// The column count whose sheet is closest to a square while still fitting in
+// maxSize
+func columnsThatFit(layout sheetLayout, glyphCount int, maxSize int) (int, bool) {
+	bestColumns := 0
+	bestSize := math.MaxInt32
+	for columns := 1; columns*(layout.cellWidth+1) <= maxSize; columns++ {
+		relayout := layout.withColumns(columns, glyphCount)
+		size := relayout.sheetWidth
+		if relayout.sheetHeight > size {
+			size = relayout.sheetHeight
+		}
+
+		if size <= maxSize && size < bestSize {
+			bestColumns = columns
+			bestSize = size
+		}
+	}
+
+	return bestColumns, bestColumns > 0
+}
+
+// Scale that brings size down to limit, rounded down to 2 decimals
+func maxScaleFor(scale float64, size float64, limit int) float64 {
+	return math.Floor(scale*float64(limit)/size*100) / 100
+}
+
+func platformNames() []string {
+	names := make([]string, 0, len(platformTextureLimits))
+	for name := range platformTextureLimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
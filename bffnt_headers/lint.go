@@ -0,0 +1,299 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	LINT_ERROR   = "error"
+	LINT_WARNING = "warning"
+)
+
+// A single problem found in a decoded font. Errors are things known to break
+// the font in game, warnings are suspicious but might be intentional.
+type LintIssue struct {
+	Severity string
+	Section  string
+	Message  string
+}
+
+func (issue LintIssue) String() string {
+	return fmt.Sprintf("%-7s %s: %s", issue.Severity, issue.Section, issue.Message)
+}
+
+// Every check run by Lint. Checks only look at the decoded font and never
+// modify it.
+var lintChecks = []func(b *BFFNT) []LintIssue{
+	lintCMAPIndexes,
+	lintDuplicateChars,
+	lintKerningChars,
+	lintGeometry,
+	lintLeftWidths,
+	lintCellGrid,
+	lintValidation,
+}
+
+func (b *BFFNT) Lint() []LintIssue {
+	// every check needs the section data
+	b.Load()
+	issues := make([]LintIssue, 0)
+	for _, check := range lintChecks {
+		issues = append(issues, check(b)...)
+	}
+
+	return issues
+}
+
+func hasLintErrors(issues []LintIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == LINT_ERROR {
+			return true
+		}
+	}
+
+	return false
+}
+
+func lintCommand(args []string) {
+	flags := newCommandFlagSet("lint", "[flags] font.bffnt ...")
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		exitWithUsage(flags)
+	}
+
+	failed := false
+	for _, bffntFile := range flags.Args() {
+		raw, err := ioutil.ReadFile(bffntFile)
+		handleErr(err)
+
+		// damaged files can't be linted, report how far decoding got instead
+		bffnt := flags.newBffnt()
+		report := bffnt.DecodePartial(raw)
+		if !report.Complete() {
+			fmt.Printf("%s: %s\n", bffntFile, report)
+			failed = true
+			continue
+		}
+
+		issues := bffnt.Lint()
+
+		fmt.Printf("%s: %d issue(s)\n", bffntFile, len(issues))
+		for _, issue := range issues {
+			fmt.Println(" ", issue)
+		}
+
+		if hasLintErrors(issues) {
+			failed = true
+		}
+	}
+
+	if failed {
+		exit(1)
+	}
+}
+
+// Every CMAP index has to point at a glyph that has width information and a
+// cell in the sheets. The game crashes or draws garbage otherwise. Glyphs that
+// no CMAP points to (orphans) can never be drawn and only waste space, unless
+// it's FINF's alter char.
+func lintCMAPIndexes(b *BFFNT) []LintIssue {
+	issues := make([]LintIssue, 0)
+	glyphCount := b.glyphCount()
+	cellCount := int(b.TGLP.NumOfSheets) * int(b.TGLP.NumOfColumns) * int(b.TGLP.NumOfRows)
+	mapped := make([]bool, glyphCount)
+
+	for i, cmap := range b.CMAPs {
+		for j, charIndex := range cmap.CharIndex {
+			if charIndex == 65535 {
+				continue
+			}
+
+			char := formatChar(cmap.CharAscii[j])
+			if int(charIndex) >= glyphCount {
+				issues = append(issues, LintIssue{LINT_ERROR, fmt.Sprintf("CMAP %d", i),
+					fmt.Sprintf("%s maps to glyph %d but the CWDHs only describe %d glyphs", char, charIndex, glyphCount)})
+				continue
+			}
+			if int(charIndex) >= cellCount {
+				issues = append(issues, LintIssue{LINT_ERROR, fmt.Sprintf("CMAP %d", i),
+					fmt.Sprintf("%s maps to glyph %d but the sheets only have %d cells", char, charIndex, cellCount)})
+			}
+			mapped[charIndex] = true
+		}
+	}
+
+	// unmapped text is drawn with the alter char, it doesn't need a mapping
+	alterIndex := int(b.FINF.AlterCharIndex)
+	if alterIndex >= glyphCount {
+		issues = append(issues, LintIssue{LINT_ERROR, "FINF",
+			fmt.Sprintf("the alter char is glyph %d but the CWDHs only describe %d glyphs", alterIndex, glyphCount)})
+	} else {
+		mapped[alterIndex] = true
+	}
+
+	orphans := make([]int, 0)
+	for glyphIndex, isMapped := range mapped {
+		if !isMapped {
+			orphans = append(orphans, glyphIndex)
+		}
+	}
+	if len(orphans) > 0 {
+		issues = append(issues, LintIssue{LINT_WARNING, "CWDH",
+			fmt.Sprintf("%d glyph(s) are not mapped by any CMAP: %s", len(orphans), formatRanges(orphans))})
+	}
+
+	return issues
+}
+
+// Inconsistencies the decoder noticed but could read past
+func lintValidation(b *BFFNT) []LintIssue {
+	issues := make([]LintIssue, 0)
+	for _, failure := range b.Validation().Failures {
+		issues = append(issues, LintIssue{LINT_ERROR, "decode", failure})
+	}
+
+	return issues
+}
+
+// Location of a character inside the CMAP list
+type charMapping struct {
+	cmap     int
+	position int
+}
+
+// Every mapping of a character that was already mapped by an earlier CMAP.
+// The game uses the first mapping it finds so the later ones are dead weight
+// at best and confusing when they point at a different glyph.
+func (b *BFFNT) duplicateChars() (firsts []charMapping, duplicates []charMapping) {
+	seen := make(map[uint16]charMapping)
+	for i, cmap := range b.CMAPs {
+		for j, code := range cmap.CharAscii {
+			if cmap.CharIndex[j] == 65535 {
+				continue
+			}
+
+			first, exists := seen[code]
+			if !exists {
+				seen[code] = charMapping{i, j}
+				continue
+			}
+			firsts = append(firsts, first)
+			duplicates = append(duplicates, charMapping{i, j})
+		}
+	}
+
+	return firsts, duplicates
+}
+
+func lintDuplicateChars(b *BFFNT) []LintIssue {
+	issues := make([]LintIssue, 0)
+	firsts, duplicates := b.duplicateChars()
+	for i, duplicate := range duplicates {
+		first := firsts[i]
+		cmap := b.CMAPs[duplicate.cmap]
+		firstIndex := b.CMAPs[first.cmap].CharIndex[first.position]
+		duplicateIndex := cmap.CharIndex[duplicate.position]
+
+		severity := LINT_WARNING
+		if firstIndex != duplicateIndex {
+			severity = LINT_ERROR
+		}
+		issues = append(issues, LintIssue{severity, fmt.Sprintf("CMAP %d", duplicate.cmap),
+			fmt.Sprintf("%s maps to glyph %d but CMAP %d already maps it to glyph %d",
+				formatChar(cmap.CharAscii[duplicate.position]), duplicateIndex, first.cmap, firstIndex)})
+	}
+
+	return issues
+}
+
+// Removes every duplicate character mapping so only the first one is left.
+// Returns the amount of mappings removed.
+func (b *BFFNT) ResolveDuplicateChars() int {
+	_, duplicates := b.duplicateChars()
+
+	// go backwards so removing from scan maps doesn't shift later positions
+	for i := len(duplicates) - 1; i >= 0; i-- {
+		duplicate := duplicates[i]
+		b.CMAPs[duplicate.cmap].unmapChar(duplicate.position)
+	}
+	b.invalidateCharIndex()
+
+	return len(duplicates)
+}
+
+// Kerning pairs for characters the font can't draw are never used by the game.
+func lintKerningChars(b *BFFNT) []LintIssue {
+	issues := make([]LintIssue, 0)
+
+	for _, firstChar := range getFirstCharsOrdered(b.KRNG.KerningTable) {
+		kPairs := b.KRNG.KerningTable[firstChar]
+		if !b.isMapped(firstChar) {
+			issues = append(issues, LintIssue{LINT_WARNING, "KRNG",
+				fmt.Sprintf("%s has %d kerning pair(s) but is not mapped by any CMAP", formatChar(firstChar), len(kPairs))})
+			continue
+		}
+
+		unmapped := make([]string, 0)
+		for _, pair := range kPairs {
+			if !b.isMapped(pair.SecondChar) {
+				unmapped = append(unmapped, formatChar(pair.SecondChar))
+			}
+		}
+		if len(unmapped) > 0 {
+			issues = append(issues, LintIssue{LINT_WARNING, "KRNG",
+				fmt.Sprintf("%s is kerned with characters not mapped by any CMAP: %s", formatChar(firstChar), strings.Join(unmapped, ", "))})
+		}
+	}
+
+	return issues
+}
+
+// Removes kerning pairs where either character isn't mapped by any CMAP.
+// Returns the amount of pairs removed.
+func (b *BFFNT) StripUnmappedKerning() int {
+	return b.KRNG.RemovePairs(func(firstChar uint16, secondChar uint16) bool {
+		return b.isMapped(firstChar) && b.isMapped(secondChar)
+	})
+}
+
+// U+0041 'A'
+func formatChar(code uint16) string {
+	return fmt.Sprintf("%#U", rune(code))
+}
+
+// e.g. U+0041 'A', U+0042 'B' and 3 more. Long lists are cut off at 10.
+func formatCharList(chars []rune) string {
+	const shown = 10
+	formatted := make([]string, 0, shown)
+	for i, char := range chars {
+		if i == shown {
+			return fmt.Sprintf("%s and %d more", strings.Join(formatted, ", "), len(chars)-shown)
+		}
+		formatted = append(formatted, formatChar(uint16(char)))
+	}
+
+	return strings.Join(formatted, ", ")
+}
+
+// Formats a sorted list of numbers as compact ranges. e.g. 1-3, 7, 9-10
+func formatRanges(numbers []int) string {
+	ranges := make([]string, 0)
+	for i := 0; i < len(numbers); {
+		j := i
+		for j+1 < len(numbers) && numbers[j+1] == numbers[j]+1 {
+			j++
+		}
+
+		if i == j {
+			ranges = append(ranges, fmt.Sprint(numbers[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", numbers[i], numbers[j]))
+		}
+		i = j + 1
+	}
+
+	return strings.Join(ranges, ", ")
+}
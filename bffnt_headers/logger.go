@@ -0,0 +1,67 @@
+package bffnt_headers
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Debug settings of a single operation. Every BFFNT carries its own so two
+// fonts can be decoded or upscaled with different settings in the same
+// process, the sections of a font log through the font's logger. A nil
+// Logger uses DefaultLogger.
+type Logger struct {
+	Debug bool
+	Out   io.Writer // where debug output goes, nil writes to stdout
+}
+
+// Used by every BFFNT without a logger of its own. -d turns debug output on
+// for the whole command.
+var DefaultLogger = &Logger{}
+
+// Registers the -d flag which turns on debug output of DefaultLogger
+func debugFlag(flags *flag.FlagSet) {
+	flags.BoolVar(&DefaultLogger.Debug, "d", DefaultLogger.Debug, "enable debug output")
+}
+
+func (log *Logger) resolve() *Logger {
+	if log == nil {
+		return DefaultLogger
+	}
+	return log
+}
+
+func (log *Logger) debugging() bool {
+	return log.resolve().Debug
+}
+
+func (log *Logger) out() io.Writer {
+	if out := log.resolve().Out; out != nil {
+		return out
+	}
+	return os.Stdout
+}
+
+// Like fmt.Printf and fmt.Println but only while debugging
+func (log *Logger) debugf(format string, args ...interface{}) {
+	if log.debugging() {
+		fmt.Fprintf(log.out(), format, args...)
+	}
+}
+
+func (log *Logger) debugln(args ...interface{}) {
+	if log.debugging() {
+		fmt.Fprintln(log.out(), args...)
+	}
+}
+
+// Pretty prints a section as json while debugging
+func (log *Logger) debugJSON(s interface{}) {
+	if log.debugging() {
+		jsonBytes, err := json.MarshalIndent(s, "", "  ")
+		handleErr(err)
+		fmt.Fprintf(log.out(), "%s\n", string(jsonBytes))
+	}
+}
@@ -0,0 +1,133 @@
+package bffnt_headers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// How a single character of a measured string is laid out. Characters the
+// font doesn't map are drawn with the alter char glyph like in game.
+type measuredChar struct {
+	char    rune
+	index   uint16
+	mapped  bool
+	glyph   glyphInfo
+	kerning int // kerning with the previous character
+	pen     int // pen position the character is drawn at, kerning included
+}
+
+type textMeasurement struct {
+	chars         []measuredChar
+	width         int // advance of the whole string
+	unkernedWidth int
+	inkWidth      int // from the leftmost to the rightmost drawn pixel
+}
+
+// Lays text out on a single line with the font's current widths and kerning
+func (b *BFFNT) MeasureText(text string) textMeasurement {
+	var res textMeasurement
+	pen := 0
+	inkLeft, inkRight, inked := 0, 0, false
+	var previous rune
+	for i, char := range []rune(text) {
+		measured := measuredChar{char: char, index: b.FINF.AlterCharIndex}
+		if index, found := b.CharIndex(char); found {
+			measured.index = index
+			measured.mapped = true
+		}
+		if glyph, found := b.glyphInfoAt(int(measured.index)); found {
+			measured.glyph = *glyph
+		}
+		if i > 0 {
+			measured.kerning = int(b.KRNG.Kern(previous, char))
+		}
+
+		pen += measured.kerning
+		measured.pen = pen
+		if measured.glyph.GlyphWidth > 0 {
+			left := pen + int(measured.glyph.LeftWidth)
+			right := left + int(measured.glyph.GlyphWidth)
+			if !inked || left < inkLeft {
+				inkLeft = left
+			}
+			if !inked || right > inkRight {
+				inkRight = right
+			}
+			inked = true
+			res.inkWidth = inkRight - inkLeft
+		}
+		pen += int(measured.glyph.CharWidth)
+		res.unkernedWidth += int(measured.glyph.CharWidth)
+
+		res.chars = append(res.chars, measured)
+		previous = char
+	}
+	res.width = pen
+
+	return res
+}
+
+func printMeasurement(b *BFFNT, text string, measurement textMeasurement, breakdown bool) {
+	fmt.Printf("%s: %d px, %d px without kerning, ink %d px\n", strconv.Quote(text), measurement.width, measurement.unkernedWidth, measurement.inkWidth)
+	if !breakdown {
+		return
+	}
+
+	unmapped := 0
+	for _, measured := range measurement.chars {
+		note := ""
+		if !measured.mapped {
+			note = "  not mapped, drawn as the alter char"
+			unmapped++
+		}
+		fmt.Printf("  %-16s glyph %-5d at %4d  left %3d  glyph %3d  char %3d  kerning %3d%s\n",
+			formatChar(uint16(measured.char)), measured.index, measured.pen,
+			measured.glyph.LeftWidth, measured.glyph.GlyphWidth, measured.glyph.CharWidth, measured.kerning, note)
+	}
+	if unmapped > 0 {
+		fmt.Printf("  %d character(s) not mapped\n", unmapped)
+	}
+}
+
+// Prints how wide strings are with a font's current metrics, e.g. to check
+// a translated line still fits its text box
+func measureCommand(args []string) {
+	flags := newCommandFlagSet("measure", "[flags] font.bffnt [text ...]")
+	textFile := flags.String("f", "", "measure every line of this file too")
+	summary := flags.Bool("summary", false, "only print the widths, not every character")
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 1 || (flags.NArg() == 1 && *textFile == "") {
+		exitWithUsage(flags)
+	}
+
+	bffnt := flags.readBffnt(flags.Arg(0))
+	texts := flags.Args()[1:]
+	if *textFile != "" {
+		file, err := os.Open(*textFile)
+		handleErr(err)
+		defer file.Close()
+
+		lines := bufio.NewScanner(file)
+		for lines.Scan() {
+			if lines.Text() != "" {
+				texts = append(texts, lines.Text())
+			}
+		}
+		handleErr(lines.Err())
+	}
+
+	widest := 0
+	for _, text := range texts {
+		measurement := bffnt.MeasureText(text)
+		printMeasurement(&bffnt, text, measurement, !*summary)
+		if measurement.width > widest {
+			widest = measurement.width
+		}
+	}
+	if len(texts) > 1 {
+		fmt.Printf("widest of %d string(s): %d px\n", len(texts), widest)
+	}
+}
@@ -0,0 +1,142 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the heap is sampled while -mem-stats is on. Short stages can
+// still be missed in between, every stage is sampled when it begins and ends
+// as well.
+const MEMORY_SAMPLE_INTERVAL = 5 * time.Millisecond
+
+const (
+	METRIC_HEAP_OBJECTS = "/memory/classes/heap/objects:bytes" // live heap plus garbage that wasn't swept yet
+	METRIC_HEAP_ALLOCS  = "/gc/heap/allocs:bytes"              // everything ever allocated on the heap
+	METRIC_TOTAL_MEMORY = "/memory/classes/total:bytes"        // everything the runtime got from the OS
+)
+
+// Memory used while one stage of an upscale ran. Stages can be nested, e.g.
+// writing the png happens while rendering.
+type memoryStage struct {
+	name        string
+	depth       int
+	peakHeap    uint64 // most heap in use at any point of the stage
+	allocated   uint64 // bytes allocated during the stage, whether they were freed or not
+	startAllocs uint64
+}
+
+// Tracks the peak heap of every stage of an upscale. The heap is sampled in
+// the background so memory that is only held for a moment inside a stage
+// still shows up, which is what runs out on machines with little RAM.
+type memoryTracker struct {
+	mu        sync.Mutex
+	samples   []metrics.Sample
+	stages    []*memoryStage
+	open      []*memoryStage
+	peakHeap  uint64
+	peakTotal uint64
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// nil unless -mem-stats is set, every method does nothing on nil
+var memoryStats *memoryTracker
+
+func startMemoryStats() *memoryTracker {
+	tracker := &memoryTracker{
+		samples: []metrics.Sample{{Name: METRIC_HEAP_OBJECTS}, {Name: METRIC_HEAP_ALLOCS}, {Name: METRIC_TOTAL_MEMORY}},
+		stop:    make(chan struct{}),
+	}
+
+	tracker.stopped.Add(1)
+	go func() {
+		defer tracker.stopped.Done()
+		ticker := time.NewTicker(MEMORY_SAMPLE_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-tracker.stop:
+				return
+			case <-ticker.C:
+				tracker.mu.Lock()
+				tracker.sample()
+				tracker.mu.Unlock()
+			}
+		}
+	}()
+
+	return tracker
+}
+
+// Reads the heap and updates the peaks of every open stage. mu has to be
+// held. Returns the bytes allocated so far.
+func (tracker *memoryTracker) sample() (allocs uint64) {
+	metrics.Read(tracker.samples)
+	heap := tracker.samples[0].Value.Uint64()
+	allocs = tracker.samples[1].Value.Uint64()
+	total := tracker.samples[2].Value.Uint64()
+
+	for _, stage := range tracker.open {
+		if heap > stage.peakHeap {
+			stage.peakHeap = heap
+		}
+	}
+	if heap > tracker.peakHeap {
+		tracker.peakHeap = heap
+	}
+	if total > tracker.peakTotal {
+		tracker.peakTotal = total
+	}
+
+	return allocs
+}
+
+// Begins a stage, call the returned func when it's done. Stages have to end
+// in the reverse order they began, like deferred calls.
+func (tracker *memoryTracker) stage(name string) (end func()) {
+	if tracker == nil {
+		return func() {}
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	stage := &memoryStage{name: name, depth: len(tracker.open)}
+	tracker.stages = append(tracker.stages, stage)
+	tracker.open = append(tracker.open, stage)
+	stage.startAllocs = tracker.sample()
+
+	return func() {
+		tracker.mu.Lock()
+		defer tracker.mu.Unlock()
+		stage.allocated = tracker.sample() - stage.startAllocs
+		tracker.open = tracker.open[:len(tracker.open)-1]
+	}
+}
+
+// Stops sampling and returns the report
+func (tracker *memoryTracker) finish() string {
+	if tracker == nil {
+		return ""
+	}
+	close(tracker.stop)
+	tracker.stopped.Wait()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "memory per stage:\n  %-28s %10s %10s\n", "stage", "peak heap", "allocated")
+	for _, stage := range tracker.stages {
+		name := strings.Repeat("  ", stage.depth) + stage.name
+		fmt.Fprintf(&sb, "  %-28s %10s %10s\n", name, formatMegabytes(stage.peakHeap), formatMegabytes(stage.allocated))
+	}
+	fmt.Fprintf(&sb, "peak heap %s, peak memory from the OS %s", formatMegabytes(tracker.peakHeap), formatMegabytes(tracker.peakTotal))
+
+	return sb.String()
+}
+
+func formatMegabytes(bytes uint64) string {
+	return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+}
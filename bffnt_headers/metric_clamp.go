@@ -0,0 +1,89 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"math"
+)
+
+// Widths and kerning are stored in one or two bytes. A value computed while
+// scaling or fitting that doesn't fit used to wrap around, a LeftWidth of 130
+// turned into -126 and threw the glyph across the line. These clamp to the
+// field's range instead and return whether the value fit, so callers can warn
+// about the ones that didn't, see warnClampedMetrics.
+
+func clampToLeftWidth(value int) (int8, bool) {
+	clamped := clampInt(value, math.MinInt8, math.MaxInt8)
+	return int8(clamped), clamped == value
+}
+
+func clampToGlyphWidth(value int) (uint8, bool) {
+	clamped := clampInt(value, 0, MAX_GLYPH_WIDTH)
+	return uint8(clamped), clamped == value
+}
+
+func clampToCharWidth(value int) (uint8, bool) {
+	clamped := clampInt(value, 0, MAX_GLYPH_WIDTH)
+	return uint8(clamped), clamped == value
+}
+
+func clampToKerning(value int) (int16, bool) {
+	clamped := clampInt(value, math.MinInt16, math.MaxInt16)
+	return int16(clamped), clamped == value
+}
+
+// Metrics that didn't fit, e.g. "glyph 12 LeftWidth 130"
+type clampedMetrics []string
+
+func (clamped *clampedMetrics) add(format string, args ...interface{}) {
+	*clamped = append(*clamped, fmt.Sprintf(format, args...))
+}
+
+// The first few are listed, a font scaled too far has hundreds
+const MAX_LISTED_CLAMPS = 10
+
+func warnClampedMetrics(context string, clamped clampedMetrics) {
+	if len(clamped) == 0 {
+		return
+	}
+	fmt.Printf("warning: %d metric(s) don't fit their field %s and were clamped:\n", len(clamped), context)
+	for i, metric := range clamped {
+		if i == MAX_LISTED_CLAMPS {
+			fmt.Printf("  and %d more\n", len(clamped)-i)
+			break
+		}
+		fmt.Println(" ", metric)
+	}
+}
+
+// What happens to scaled LeftWidths that don't fit, see upscaleOptions
+const (
+	LEFT_WIDTH_CLAMP      = "clamp"
+	LEFT_WIDTH_COMPENSATE = "compensate"
+)
+
+// The scaled LeftWidths of the original glyphs that don't fit a signed byte,
+// by glyph index. Scaling clamps them, rendering needs what they should be.
+func overflowingLeftWidths(glyphs []glyphInfo, scale float64) map[int]int {
+	overflowing := make(map[int]int)
+	for i, glyph := range glyphs {
+		scaled := int(scaleMetric(float64(glyph.LeftWidth), scale))
+		if _, fits := clampToLeftWidth(scaled); !fits {
+			overflowing[i] = scaled
+		}
+	}
+
+	return overflowing
+}
+
+// Glyphs whose LeftWidth is at the limit of its byte were most likely clamped
+func lintLeftWidths(b *BFFNT) []LintIssue {
+	issues := make([]LintIssue, 0)
+	for i, glyph := range b.allGlyphInfo() {
+		if glyph.LeftWidth == math.MaxInt8 || glyph.LeftWidth == math.MinInt8 {
+			issues = append(issues, LintIssue{LINT_WARNING, "CWDH",
+				fmt.Sprintf("%s LeftWidth %d is at the limit of a signed byte, a larger one would have been clamped or wrapped around", b.glyphLabel(i), glyph.LeftWidth)})
+		}
+	}
+
+	return issues
+}
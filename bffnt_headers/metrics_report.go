@@ -0,0 +1,70 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// One glyph metric before and after an upscale. Expected is the original value
+// times the scale, written is what actually ended up in the CWDH after auto
+// fitting, manual adjustments and tracking.
+type metricScaling struct {
+	index    int
+	char     string
+	metric   string
+	original int
+	expected float64
+	written  int
+}
+
+func (row metricScaling) deviation() float64 {
+	return float64(row.written) - row.expected
+}
+
+// Compares every glyph's widths to the original font's. The glyphs have to be
+// in glyph index order, see allGlyphInfo.
+func metricScalings(originalGlyphs []glyphInfo, b *BFFNT, scale float64) []metricScaling {
+	rows := make([]metricScaling, 0, len(originalGlyphs)*3)
+	for i, glyph := range b.allGlyphInfo() {
+		if i >= len(originalGlyphs) {
+			break
+		}
+		char := "unmapped"
+		if runes := b.RunesForIndex(uint16(i)); len(runes) > 0 {
+			char = formatChar(uint16(runes[0]))
+		}
+
+		original := originalGlyphs[i]
+		rows = append(rows,
+			metricScaling{i, char, "LeftWidth", int(original.LeftWidth), float64(original.LeftWidth) * scale, int(glyph.LeftWidth)},
+			metricScaling{i, char, "GlyphWidth", int(original.GlyphWidth), float64(original.GlyphWidth) * scale, int(glyph.GlyphWidth)},
+			metricScaling{i, char, "CharWidth", int(original.CharWidth), float64(original.CharWidth) * scale, int(glyph.CharWidth)},
+		)
+	}
+
+	return rows
+}
+
+// Writes the table of every glyph metric. Rows that are further than threshold
+// pixels from original × scale are marked with a ! so they are easy to grep.
+// Returns the number of marked rows.
+func writeMetricReport(filename string, rows []metricScaling, threshold float64) int {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "  %-6s %-24s %-10s %8s %8s %8s %8s\n", "index", "char", "metric", "original", "expected", "written", "diff")
+
+	deviating := 0
+	for _, row := range rows {
+		marker := " "
+		if math.Abs(row.deviation()) > threshold {
+			marker = "!"
+			deviating++
+		}
+		fmt.Fprintf(&sb, "%s %-6d %-24s %-10s %8d %8.2f %8d %+8.2f\n",
+			marker, row.index, row.char, row.metric, row.original, row.expected, row.written, row.deviation())
+	}
+
+	handleErr(os.WriteFile(filename, []byte(sb.String()), 0644))
+	return deviating
+}
@@ -0,0 +1,334 @@
+package bffnt_headers
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Interactive tuning of single glyphs. Every line typed is a list of nudges
+// for the picked glyph, after it the font is rendered again the way an
+// upscale renders it and the glyph's cell and a sample text are shown. The
+// adjustments end up in the file -adjustments reads, every change is also
+// logged to its history, see AdjustmentEdit.
+const NUDGE_HELP = `  h l    move the glyph a pixel left / right in its cell
+  k j    move the glyph a pixel up / down
+  [ ]    LeftWidth -1 / +1
+  - +    CharWidth -1 / +1
+  0      reset the glyph's adjustment
+  u r    undo / redo the last change, across sessions too
+  c X    pick another character, e.g. c A or c U+00E9
+  t ...  sample text
+  s      save the adjustments
+  q      quit
+  nudges can be repeated on one line, e.g. lll]`
+
+// Shades of the ASCII previews, from no ink to full
+const NUDGE_SHADES = " .:-=+*#%@"
+
+type nudgeSession struct {
+	fontName string
+	fontFile string
+	scale    float64
+	raw      []byte // the original bffnt, every render starts from it
+	settings BFFNT  // empty font with the leftover policy every render decodes with
+	autoFit  bool
+	rules    []AdjustmentRule
+
+	filename    string
+	adjustments GlyphAdjustmentsFile
+	history     *adjustmentHistory
+	unsaved     bool
+
+	char   rune
+	sample string
+
+	bffnt *BFFNT
+	sheet *image.Alpha
+}
+
+func (session *nudgeSession) set() *GlyphAdjustmentSet {
+	return session.adjustments.set(session.fontName, session.scale)
+}
+
+func (session *nudgeSession) adjustment() GlyphAdjustment {
+	adjustments, err := session.set().byChar()
+	handleErr(err)
+	return adjustments[session.char]
+}
+
+// Renders the font with the current adjustments like upscaleBffnt does
+func (session *nudgeSession) render() {
+	bffnt := session.settings
+	bffnt.Decode(session.raw)
+	opts := upscaleOptions{autoFit: session.autoFit, workers: 1, baseline: bffnt.glyphBaseline(session.scale)}
+	opts.outline, _ = bffnt.detectOutline()
+	if session.autoFit {
+		opts.customSpacing = bffnt.customSpacing()
+	}
+	adjustments, err := session.set().byChar()
+	handleErr(err)
+	opts.adjustments = adjustments
+
+	layout := bffnt.TGLP.scaledLayout(session.scale).withRowsFor(bffnt.glyphCount())
+	bffnt.Upscale(session.scale)
+	bffnt.TGLP.applyLayout(layout)
+	sheet, _ := bffnt.renderGlyphSheet(session.fontName, session.fontFile, session.scale, opts)
+	bffnt.manuallyAdjustWidths(session.fontName, session.scale)
+	bffnt.applyAdjustmentRules(session.rules, session.fontName, session.scale)
+	bffnt.applyGlyphAdjustments(adjustments)
+
+	if session.sheet != nil {
+		alphaBuffers.put(session.sheet)
+	}
+	session.bffnt, session.sheet = &bffnt, sheet
+}
+
+func (session *nudgeSession) cell(index uint16) *image.Alpha {
+	_, rect := session.bffnt.TGLP.CellRect(int(index))
+	cell := image.NewAlpha(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(cell, cell.Bounds(), session.sheet, rect.Min, draw.Src)
+	return cell
+}
+
+// Draws the sample text the way the game lays it out, see MeasureText
+func (session *nudgeSession) sampleImage() *image.Alpha {
+	measurement := session.bffnt.MeasureText(session.sample)
+	left, right := 0, measurement.width
+	for _, measured := range measurement.chars {
+		inkLeft := measured.pen + int(measured.glyph.LeftWidth)
+		if inkLeft < left {
+			left = inkLeft
+		}
+		if inkRight := inkLeft + int(measured.glyph.GlyphWidth); inkRight > right {
+			right = inkRight
+		}
+	}
+
+	img := image.NewAlpha(image.Rect(left, 0, right, int(session.bffnt.TGLP.CellHeight)))
+	for _, measured := range measurement.chars {
+		cell := session.cell(measured.index)
+		x := measured.pen + int(measured.glyph.LeftWidth)
+		glyphRect := image.Rect(x, 0, x+int(measured.glyph.GlyphWidth), cell.Bounds().Dy())
+		draw.Draw(img, glyphRect, cell, image.Point{}, draw.Over)
+	}
+	return img
+}
+
+// One character per pixel, wider images are scaled down to maxWidth
+func asciiPreview(img *image.Alpha, maxWidth int) string {
+	if img.Bounds().Dx() > maxWidth {
+		height := img.Bounds().Dy() * maxWidth / img.Bounds().Dx()
+		scaled := imaging.Resize(img, maxWidth, height, imaging.Box)
+		img = image.NewAlpha(scaled.Bounds())
+		draw.Draw(img, img.Bounds(), scaled, scaled.Bounds().Min, draw.Src)
+	}
+
+	var sb strings.Builder
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			shade := int(img.AlphaAt(x, y).A) * len(NUDGE_SHADES) / 256
+			sb.WriteByte(NUDGE_SHADES[shade])
+		}
+		sb.WriteString("|\n")
+	}
+	return sb.String()
+}
+
+func (session *nudgeSession) show() {
+	index, found := session.bffnt.CharIndex(session.char)
+	if !found {
+		fmt.Println(formatChar(uint16(session.char)), "isn't mapped, pick another character with c")
+		return
+	}
+	glyph, _ := session.bffnt.glyphInfoAt(int(index))
+	adjustment := session.adjustment()
+
+	fmt.Print(asciiPreview(session.cell(index), 1<<16))
+	fmt.Printf("%s  x %d  y %d  left %+d  width %+d  ->  LeftWidth %d  GlyphWidth %d  CharWidth %d\n",
+		session.bffnt.glyphLabel(int(index)), adjustment.X, adjustment.Y, adjustment.Left, adjustment.Width,
+		glyph.LeftWidth, glyph.GlyphWidth, glyph.CharWidth)
+	if session.sample != "" {
+		fmt.Print(asciiPreview(session.sampleImage(), 160))
+		fmt.Printf("%q is %d px wide\n", session.sample, session.bffnt.MeasureText(session.sample).width)
+	}
+}
+
+// Applies a line of input, returns false when the session is over
+func (session *nudgeSession) command(line string) bool {
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "":
+		return true
+	case line == "q":
+		if session.unsaved {
+			session.unsaved = false
+			fmt.Println("the adjustments aren't saved, s saves them, q again quits")
+			return true
+		}
+		return false
+	case line == "s":
+		session.adjustments.write(session.filename)
+		session.unsaved = false
+		fmt.Println("wrote", session.filename)
+		return true
+	case line == "?":
+		fmt.Println(NUDGE_HELP)
+		return true
+	case line == "u" || line == "r":
+		action, step := "undo", session.history.undo
+		if line == "r" {
+			action, step = "redo", session.history.redo
+		}
+		char, adjustment, ok := step()
+		if !ok {
+			fmt.Println("nothing to", action)
+			return true
+		}
+		session.char = char
+		session.set().put(char, adjustment)
+		session.unsaved = true
+		session.render()
+		session.show()
+		return true
+	case strings.HasPrefix(line, "c "):
+		char, err := parseCharFlag(strings.TrimSpace(line[2:]))
+		if err != nil {
+			fmt.Println(err)
+			return true
+		}
+		session.char = char
+		session.show()
+		return true
+	case strings.HasPrefix(line, "t "):
+		session.sample = strings.TrimSpace(line[2:])
+		session.show()
+		return true
+	}
+
+	before := session.adjustment()
+	adjustment := before
+	for _, key := range line {
+		switch key {
+		case 'h':
+			adjustment.X--
+		case 'l':
+			adjustment.X++
+		case 'k':
+			adjustment.Y--
+		case 'j':
+			adjustment.Y++
+		case '[':
+			adjustment.Left--
+		case ']':
+			adjustment.Left++
+		case '-':
+			adjustment.Width--
+		case '+', '=':
+			adjustment.Width++
+		case '0':
+			adjustment = GlyphAdjustment{}
+		case ' ':
+		default:
+			fmt.Printf("unknown nudge %q, ? lists them\n", key)
+			return true
+		}
+	}
+	if adjustment == before {
+		return true
+	}
+	session.set().put(session.char, adjustment)
+	session.history.edit(session.char, before, adjustment)
+	session.unsaved = true
+	session.render()
+	session.show()
+	return true
+}
+
+func (session *nudgeSession) run(input io.Reader) {
+	lines := bufio.NewScanner(input)
+	fmt.Print("> ")
+	for lines.Scan() {
+		if !session.command(lines.Text()) {
+			return
+		}
+		fmt.Print("> ")
+	}
+	handleErr(lines.Err())
+}
+
+// Replaces editing adjustBotwCaptionWidth and rebuilding: nudges a glyph a
+// pixel at a time while showing it rendered, then saves the adjustments for
+// upscale runs to read with -adjustments
+func nudgeCommand(args []string) {
+	flags := newCommandFlagSet("nudge", "[flags]")
+	botwFontName := flags.String("font", "Caption", "botw font to tune: Ancient, Caption, Normal, NormalS or External")
+	fontFile := flags.String("ttf", "", "replacement font file. Defaults to the font picked for the botw font")
+	bffntFile := flags.String("bffnt", "", "font to upscale, defaults to the botw font in WiiU_fonts")
+	scale := flags.Float64("scale", 2, "scale the adjustments are for")
+	adjustmentsFile := flags.String("adjustments", "adjustments.yaml", "adjustments file to edit, made if it doesn't exist")
+	charFlag := flags.String("char", "A", "character to start with")
+	sample := flags.String("sample", "Adventure Log", "sample text shown with the glyph")
+	autoFit := flags.Bool("autofit", false, "render like an upscale with -autofit")
+	rulesFile := flags.String("rules", "", "width rules applied before the adjustments, like an upscale with -rules")
+	replay := flags.Bool("replay", false, "replay the adjustments' history into the adjustments file and quit, e.g. after quitting without saving")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		exitWithUsage(flags)
+	}
+	historyFile := adjustmentHistoryFile(*adjustmentsFile)
+	if *replay {
+		var adjustments GlyphAdjustmentsFile
+		if _, err := os.Stat(*adjustmentsFile); err == nil {
+			adjustments = readGlyphAdjustments(*adjustmentsFile)
+		}
+		edits := readAdjustmentHistory(historyFile)
+		adjustments.replay(edits)
+		adjustments.write(*adjustmentsFile)
+		fmt.Printf("replayed %d change(s) of %s into %s\n", len(edits), historyFile, *adjustmentsFile)
+		return
+	}
+
+	char, err := parseCharFlag(*charFlag)
+	handleErr(err)
+	initializeGlyphMaps()
+
+	if *bffntFile == "" {
+		*bffntFile = fmt.Sprintf("./WiiU_fonts/botw/%[1]s/%[1]s_00.bffnt", *botwFontName)
+	}
+	raw, err := ioutil.ReadFile(*bffntFile)
+	handleErr(err)
+
+	session := &nudgeSession{
+		fontName: *botwFontName,
+		fontFile: resolveFontFile(*botwFontName, *fontFile),
+		scale:    *scale,
+		raw:      raw,
+		settings: flags.newBffnt(),
+		autoFit:  *autoFit,
+		filename: *adjustmentsFile,
+		history:  newAdjustmentHistory(historyFile, *botwFontName, *scale),
+		char:     char,
+		sample:   *sample,
+	}
+	if *rulesFile != "" {
+		session.rules = readAdjustmentRules(*rulesFile)
+	}
+	if _, err := os.Stat(*adjustmentsFile); err == nil {
+		session.adjustments = readGlyphAdjustments(*adjustmentsFile)
+	}
+
+	fmt.Println(NUDGE_HELP)
+	session.render()
+	session.show()
+	session.run(os.Stdin)
+}
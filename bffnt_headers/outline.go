@@ -0,0 +1,144 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// NormalS draws its glyphs with a half transparent outline around the solid
+// ink so they stay readable on the map. The outline is measured from the
+// original glyphs and drawn around the rendered ones, see outlineAlpha.
+type glyphOutline struct {
+	width   int   // pixels at the original size, 0 for fonts without an outline
+	opacity uint8 // alpha of the outline
+}
+
+// Alpha between BC4 noise and solid ink, the outline and antialiased edges
+const OUTLINE_MIN_ALPHA = 32
+
+// How far outline pixels may be from its opacity to count as the outline
+const OUTLINE_ALPHA_TOLERANCE = 16
+
+// Finds the outline of the original glyphs. The opacity is the most common
+// alpha between the faint edges and the solid ink, the width the median run
+// of pixels at least half as opaque going out from an edge of the solid ink.
+// Antialiased edges are 1 pixel wide at most and spread over every alpha, a
+// glyph only has an outline when the runs are wider and most of their pixels
+// have the outline's opacity. False for fonts without one or whose sheets
+// can't be decoded.
+func (b *BFFNT) detectOutline() (glyphOutline, bool) {
+	if !knownImageFormat(b.TGLP.SheetImageFormat) {
+		return glyphOutline{}, false
+	}
+	tglp := b.TGLP
+	tglp.DecodeSheets()
+	glyphCount := b.glyphCount()
+
+	var histogram [256]int
+	for i := 0; i < glyphCount; i++ {
+		sheet, cell := tglp.CellRect(i)
+		if sheet >= len(tglp.SheetData) {
+			break
+		}
+		for y := cell.Min.Y; y < cell.Max.Y; y++ {
+			for x := cell.Min.X; x < cell.Max.X; x++ {
+				histogram[tglp.SheetData[sheet].NRGBAAt(x, y).A]++
+			}
+		}
+	}
+	opacity := OUTLINE_MIN_ALPHA
+	for alpha := OUTLINE_MIN_ALPHA; alpha < SOLID_INK_ALPHA; alpha++ {
+		if histogram[alpha] > histogram[opacity] {
+			opacity = alpha
+		}
+	}
+
+	widths := make([]int, 0)
+	runPixels, plateauPixels := 0, 0
+	for i := 0; i < glyphCount; i++ {
+		sheet, cell := tglp.CellRect(i)
+		if sheet >= len(tglp.SheetData) {
+			break
+		}
+		img := &tglp.SheetData[sheet]
+		solid := func(x int, y int) bool { return img.NRGBAAt(x, y).A >= SOLID_INK_ALPHA }
+		for y := cell.Min.Y; y < cell.Max.Y; y++ {
+			for x := cell.Min.X; x < cell.Max.X; x++ {
+				if !solid(x, y) {
+					continue
+				}
+				for _, step := range []int{-1, 1} {
+					if x+step < cell.Min.X || x+step >= cell.Max.X || solid(x+step, y) {
+						continue
+					}
+					width := 0
+					for runX := x + step; runX >= cell.Min.X && runX < cell.Max.X; runX += step {
+						alpha := int(img.NRGBAAt(runX, y).A)
+						if alpha >= SOLID_INK_ALPHA || alpha < opacity/2 {
+							break
+						}
+						if math.Abs(float64(alpha-opacity)) <= OUTLINE_ALPHA_TOLERANCE {
+							plateauPixels++
+						}
+						runPixels++
+						width++
+					}
+					widths = append(widths, width)
+				}
+			}
+		}
+	}
+	if len(widths) == 0 {
+		return glyphOutline{}, false
+	}
+	sort.Ints(widths)
+	width := widths[len(widths)/2]
+	if width < 2 || plateauPixels*2 < runPixels {
+		return glyphOutline{}, false
+	}
+
+	return glyphOutline{width: width, opacity: uint8(opacity)}, true
+}
+
+// The outline's width in the upscaled cells
+func (outline glyphOutline) scaledWidth(scale float64) int {
+	return int(math.Round(float64(outline.width) * scale))
+}
+
+func (outline glyphOutline) String() string {
+	return fmt.Sprintf("%d px outline at %d%% opacity", outline.width, int(math.Round(float64(outline.opacity)*100/255)))
+}
+
+// Draws an outline of width pixels around the ink of img. Every pixel gets
+// the most opaque ink within width pixels, the distance measured from pixel
+// centres so the corners come out round, times the outline's opacity. The ink
+// itself is kept on top.
+func outlineAlpha(img *image.Alpha, width int, opacity uint8) *image.Alpha {
+	bounds := img.Bounds()
+	res := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var coverage float64
+			for dy := -width; dy <= width; dy++ {
+				for dx := -width; dx <= width; dx++ {
+					if !(image.Point{x + dx, y + dy}).In(bounds) {
+						continue
+					}
+					// pixels right at the edge of the outline are partly covered
+					weight := math.Min(1, float64(width)+0.5-math.Hypot(float64(dx), float64(dy)))
+					if weight <= 0 {
+						continue
+					}
+					coverage = math.Max(coverage, weight*float64(img.AlphaAt(x+dx, y+dy).A))
+				}
+			}
+			alpha := math.Max(coverage*float64(opacity)/255, float64(img.AlphaAt(x, y).A))
+			res.SetAlpha(x, y, color.Alpha{uint8(math.Round(alpha))})
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,128 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// What happened while decoding a possibly damaged file. Sections are decoded
+// in file order and decoding stops at the first one that fails, everything
+// after it is missing.
+type DecodeReport struct {
+	Decoded   []string
+	Missing   []string
+	StoppedAt int   // offset of the section that failed, -1 if nothing failed
+	FileSize  int   // size of the decoded file
+	Err       error // why decoding stopped
+}
+
+func (report DecodeReport) Complete() bool {
+	return report.Err == nil
+}
+
+func (report DecodeReport) String() string {
+	if report.Complete() {
+		return fmt.Sprintf("decoded %s", strings.Join(report.Decoded, ", "))
+	}
+
+	return fmt.Sprintf("decoding stopped at offset %d of %d: %v\n  decoded: %s\n  missing: %s",
+		report.StoppedAt, report.FileSize, report.Err, strings.Join(report.Decoded, ", "), strings.Join(report.Missing, ", "))
+}
+
+// Decodes as many sections as possible instead of panicking on the first
+// problem. Useful for files that were cut short by a bad download or an
+// interrupted copy. Sections that could not be decoded are left empty.
+func (b *BFFNT) DecodePartial(raw []byte) DecodeReport {
+	report := b.decodeSections(raw, SectionAll)
+	// whatever cmaps were decoded can still be used
+	b.buildCWDHIndexMap()
+	b.buildCharIndex()
+
+	return report
+}
+
+// Walks the sections of raw in file order, see DecodePartial and
+// DecodeSections. Sections that aren't wanted are decoded lazily.
+func (b *BFFNT) decodeSections(raw []byte, wanted SectionMask) DecodeReport {
+	report := DecodeReport{
+		Decoded:   make([]string, 0),
+		Missing:   make([]string, 0),
+		StoppedAt: -1,
+		FileSize:  len(raw),
+	}
+	b.validation = ValidationResult{}
+	b.TGLP.validation = ValidationResult{}
+	b.FFNT.log, b.FINF.log, b.TGLP.log, b.KRNG.log = b.Log, b.Log, b.Log, b.Log
+	b.KRNG.leftoverPolicy = b.LeftoverPolicy
+	b.TGLP.threads = b.Threads
+
+	// Runs a single decode step. Returns false when decoding has to stop.
+	decodeSection := func(name string, offset int, decode func()) (ok bool) {
+		defer func() {
+			if r := recover(); r != nil {
+				report.StoppedAt = offset
+				report.Err = fmt.Errorf("decoding %s at offset %d failed: %v", name, offset, r)
+				report.Missing = append(report.Missing, name)
+				ok = false
+			}
+		}()
+
+		if offset >= len(raw) {
+			panic(fmt.Sprintf("file ends at offset %d", len(raw)))
+		}
+		decode()
+		report.Decoded = append(report.Decoded, name)
+		return true
+	}
+
+	// missing marks every section after the failed one
+	missing := func(names ...string) DecodeReport {
+		report.Missing = append(report.Missing, names...)
+		return report
+	}
+
+	if !decodeSection("FFNT", 0, func() { b.FFNT.Decode(raw) }) {
+		return missing(sectionOrder...)
+	}
+
+	b.CWDHs = make([]CWDH, 0)
+	b.CMAPs = make([]CMAP, 0)
+	b.ExtraSections = nil
+	b.invalidateCharIndex()
+	starts := make(map[string][]int)
+	lastMagic := FFNT_MAGIC_HEADER
+	sections := newSectionIterator(raw)
+	for sections.Next() {
+		header := sections.Section()
+		name := sectionName(header.MagicHeader, len(starts[header.MagicHeader]))
+		parse, known := sectionParsers[header.MagicHeader]
+		if !known && isCustomSection(header.MagicHeader) {
+			parse, known = parseCustomSection, true
+		}
+		if !known {
+			parse = func(b *BFFNT, raw []byte, header sectionHeader, lazy bool) int {
+				b.ExtraSections = append(b.ExtraSections, decodeUnknownSection(raw, header))
+				return header.Size
+			}
+		}
+		lazy := wanted&sectionMasks[header.MagicHeader] == 0
+		if !decodeSection(name, header.Start, func() { sections.resize(parse(b, raw, header, lazy)) }) {
+			return missing(sectionsAfter(header.MagicHeader)...)
+		}
+		starts[header.MagicHeader] = append(starts[header.MagicHeader], header.Start)
+		lastMagic = header.MagicHeader
+	}
+	if err := sections.Err(); err != nil {
+		report.StoppedAt = sections.pos
+		report.Err = err
+		return missing(sectionsAfter(lastMagic)...)
+	}
+
+	if err := b.checkSectionChains(starts); err != nil {
+		report.StoppedAt = len(raw)
+		report.Err = err
+		return missing(sectionsAfter(lastMagic)...)
+	}
+
+	return report
+}
@@ -0,0 +1,76 @@
+package bffnt_headers
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Compression of every png this tool writes, set with -png-compression. A 4K
+// sheet takes a noticeable time to compress at the default level, preview and
+// debug runs can trade file size for speed. The pixels are the same at every
+// level.
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+	"none":    png.NoCompression,
+}
+
+var pngEncoder = png.Encoder{
+	CompressionLevel: png.DefaultCompression,
+	BufferPool:       &pngBufferPool{},
+}
+
+// Registers the -png-compression flag which sets the level of pngEncoder
+func pngCompressionFlag(flags *flag.FlagSet) {
+	names := make([]string, 0, len(pngCompressionLevels))
+	for name := range pngCompressionLevels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	usage := fmt.Sprintf("compression of written pngs: %s. fast is a lot quicker for previews, none is quickest but the files are huge", strings.Join(names, ", "))
+	flags.Func("png-compression", usage, func(name string) error {
+		level, exists := pngCompressionLevels[name]
+		if !exists {
+			return fmt.Errorf("unknown png compression %q", name)
+		}
+		pngEncoder.CompressionLevel = level
+		return nil
+	})
+}
+
+// Keeps the encoder's buffers between pngs, e.g. when every sheet of a font is
+// exported. Safe for use by several encodes at once.
+type pngBufferPool struct {
+	pool sync.Pool
+}
+
+func (p *pngBufferPool) Get() *png.EncoderBuffer {
+	buffer, _ := p.pool.Get().(*png.EncoderBuffer)
+	return buffer
+}
+
+func (p *pngBufferPool) Put(buffer *png.EncoderBuffer) {
+	p.pool.Put(buffer)
+}
+
+func writePng(filename string, img image.Image) {
+	defer memoryStats.stage("format conversion")()
+	_ = os.Remove(filename)
+
+	textureFile, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	handleErr(err)
+	defer textureFile.Close()
+
+	w := bufio.NewWriter(textureFile)
+	handleErr(pngEncoder.Encode(w, img))
+	handleErr(w.Flush())
+}
@@ -0,0 +1,107 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BotW renders at 1280x720 natively. A target resolution maps to the scale
+// the fonts need to look sharp at that resolution.
+var resolutionTargets = map[string]float64{
+	"720p":  1,   // 1280 x 720 (original)
+	"1080p": 1.5, // 1920 x 1080
+	"1440p": 2,   // 2560 x 1440
+	"4k":    3,   // 3840 x 2160
+}
+
+// Manual settings for each botw font to closely resemble the original
+type fontPreset struct {
+	fontFile string  // replacement font used when no font file is given
+	fontSize float64 // size the replacement font is drawn at for scale 1
+}
+
+// "./nintendo_system_ui/DSi-Wii-3DS-Wii_U/FOT-RodinBokutoh-Pro-B.otf" also
+// works well for NormalS.
+var botwFontPresets = map[string]fontPreset{
+	"Ancient": {"./nintendo_system_ui/botw-sheikah.ttf", 5.5},
+	"Caption": {"./nintendo_system_ui/DSi-Wii-3DS-Wii_U/FOT-RodinBokutoh-Pro-M.otf", 8},
+	"Normal":  {"./nintendo_system_ui/DSi-Wii-3DS-Wii_U/FOT-RodinBokutoh-Pro-B.otf", 15},
+	// This is what should be the proper setting for botw NormalS. However,
+	// there is a bug that stretches the words on the mini map if the
+	// textures are not the same width as the original.
+	// The outline is measured from the original glyphs, see detectOutline.
+	"NormalS":  {"./nintendo_system_ui/DSi-Wii-3DS-Wii_U/CafeStd.ttf", 10},
+	"External": {"./nintendo_system_ui/nintendo_ext_003.ttf", 15},
+}
+
+// An explicit scale always wins over the target resolution
+func resolveScale(target string, scale float64) float64 {
+	if scale > 0 {
+		return scale
+	}
+
+	targetScale, exists := resolutionTargets[strings.ToLower(target)]
+	if !exists {
+		handleErr(fmt.Errorf("unknown target %q. Known targets: %s", target, strings.Join(targetNames(), ", ")))
+	}
+
+	return targetScale
+}
+
+// A comma separated list of scales or target resolutions, e.g. 2,3 or
+// 1080p,4k, in the order given
+func parseScales(spec string) ([]float64, error) {
+	scales := make([]float64, 0)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if targetScale, exists := resolutionTargets[strings.ToLower(part)]; exists {
+			scales = append(scales, targetScale)
+			continue
+		}
+		scale, err := strconv.ParseFloat(part, 64)
+		if err != nil || scale <= 0 {
+			return nil, fmt.Errorf("%q is neither a scale nor a target. Known targets: %s", part, strings.Join(targetNames(), ", "))
+		}
+		scales = append(scales, scale)
+	}
+	return scales, nil
+}
+
+// Font file given on the command line, or found by its name, or the default
+// one for the botw font
+func resolveFontFile(botwFontName string, fontFile string) string {
+	if fontFile != "" {
+		return findFontFile(fontFile)
+	}
+
+	preset, exists := botwFontPresets[botwFontName]
+	if !exists {
+		handleErr(fmt.Errorf("no default font file for %q. Use -ttf to pick one", botwFontName))
+	}
+
+	return preset.fontFile
+}
+
+// Size the replacement font is drawn at for a botw font at scale
+func getBotwFontSettings(fontName string, scale float64) (fontSize float64) {
+	preset, exists := botwFontPresets[fontName]
+	if !exists {
+		panic("file texture generation settings unknown")
+	}
+
+	return preset.fontSize * scale
+}
+
+func targetNames() []string {
+	names := make([]string, 0, len(resolutionTargets))
+	for name := range resolutionTargets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return resolutionTargets[names[i]] < resolutionTargets[names[j]]
+	})
+
+	return names
+}
@@ -0,0 +1,64 @@
+package bffnt_headers
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // registers /debug/pprof/ on the default mux
+	"os"
+	"runtime/pprof"
+)
+
+// CPU profile being written, see the -pprof flag
+var cpuProfile *os.File
+
+// Registers -pprof and -pprof-http. Profiling starts as soon as the flag is
+// parsed so it covers the whole run, analyze the profile with
+// `go tool pprof cpu.out`.
+func profilingFlags(flags *flag.FlagSet) {
+	flags.Func("pprof", "write a cpu profile of the whole run to this file", func(filename string) error {
+		if cpuProfile != nil {
+			return fmt.Errorf("already writing a cpu profile to %s", cpuProfile.Name())
+		}
+		file, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return err
+		}
+		cpuProfile = file
+		return nil
+	})
+	flags.Func("pprof-http", "serve live profiles at this address while running, e.g. :6060", func(address string) error {
+		// listen right away so a taken port fails the flag instead of the
+		// goroutine
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("serving profiles at http://%s/debug/pprof/\n", listener.Addr())
+		go http.Serve(listener, nil)
+		return nil
+	})
+}
+
+// Finishes the cpu profile, the file is only usable once this ran
+func stopProfiling() {
+	if cpuProfile == nil {
+		return
+	}
+	pprof.StopCPUProfile()
+	handleErr(cpuProfile.Close())
+	fmt.Println("wrote cpu profile", cpuProfile.Name())
+	cpuProfile = nil
+}
+
+// os.Exit skips deferred calls, commands that fail have to exit through here
+// so the profile still gets written
+func exit(code int) {
+	stopProfiling()
+	os.Exit(code)
+}
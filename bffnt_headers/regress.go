@@ -0,0 +1,258 @@
+package bffnt_headers
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A corpus is a set of fonts that every change should be checked against. All
+// paths are relative to the corpus file so the same file works on every
+// machine, e.g.
+//
+//	root: WiiU_fonts
+//	baselines: regress_baselines.yaml
+//	scales: [2, 3]
+//	fonts:
+//	  - botw/*/*_00.bffnt
+//	  - "*/Normal_00.bffnt"
+type Corpus struct {
+	Root      string    `yaml:"root"`
+	Baselines string    `yaml:"baselines"`
+	Scales    []float64 `yaml:"scales"`
+	Fonts     []string  `yaml:"fonts"` // glob patterns relative to root
+}
+
+// What a font looked like when the baseline was recorded. Encoded fonts are
+// compared by hash. Re-encoded fonts keep their sheets, upscaled ones get
+// blank sheets so their hashes only cover the headers and glyph information.
+type RegressResult struct {
+	File       string            `yaml:"file"`
+	Validation []string          `yaml:"validation,omitempty"`
+	Reencoded  string            `yaml:"reencoded"`
+	Upscaled   map[string]string `yaml:"upscaled,omitempty"` // scale -> hash, or the error message
+}
+
+func readCorpus(filename string) Corpus {
+	raw, err := ioutil.ReadFile(filename)
+	handleErr(err)
+
+	var corpus Corpus
+	handleErr(yaml.Unmarshal(raw, &corpus))
+	if corpus.Baselines == "" {
+		corpus.Baselines = "regress_baselines.yaml"
+	}
+
+	// make paths relative to the corpus file
+	dir := filepath.Dir(filename)
+	corpus.Root = filepath.Join(dir, corpus.Root)
+	corpus.Baselines = filepath.Join(dir, corpus.Baselines)
+
+	return corpus
+}
+
+// Font paths relative to the corpus root, sorted so runs are comparable
+func (corpus Corpus) fontFiles() []string {
+	files := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, pattern := range corpus.Fonts {
+		matches, err := filepath.Glob(filepath.Join(corpus.Root, pattern))
+		handleErr(err)
+		if len(matches) == 0 {
+			fmt.Printf("warning: %q doesn't match any font in %s\n", pattern, corpus.Root)
+		}
+		for _, match := range matches {
+			relative, err := filepath.Rel(corpus.Root, match)
+			handleErr(err)
+			relative = filepath.ToSlash(relative)
+			if !seen[relative] {
+				seen[relative] = true
+				files = append(files, relative)
+			}
+		}
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+// Decodes, re-encodes and upscales a single font. Problems are recorded in the
+// result instead of stopping the run. settings is an empty font with the
+// leftover policy and threads every decode uses.
+func regressFont(settings BFFNT, raw []byte, scales []float64) RegressResult {
+	result := RegressResult{
+		File:     md5String(raw),
+		Upscaled: make(map[string]string),
+	}
+
+	bffnt := settings
+	if err := catchPanic(func() { bffnt.Decode(raw) }); err != nil {
+		result.Reencoded = err.Error()
+		return result
+	}
+	result.Validation = bffnt.Validation().Failures
+	result.Reencoded = encodedHash(&bffnt)
+
+	for _, scale := range scales {
+		upscaled := settings
+		upscaled.Decode(raw)
+		key := fmt.Sprintf("%.2f", scale)
+		err := catchPanic(func() {
+			layout := upscaled.TGLP.scaledLayout(scale)
+			handleErr(checkSheetLimits(layout, upscaled.glyphCount(), scale, "wiiu"))
+			upscaled.Upscale(scale)
+		})
+		if err != nil {
+			result.Upscaled[key] = err.Error()
+			continue
+		}
+		result.Upscaled[key] = encodedHash(&upscaled)
+	}
+
+	return result
+}
+
+func encodedHash(b *BFFNT) string {
+	var encoded []byte
+	if err := catchPanic(func() { encoded = b.Encode() }); err != nil {
+		return err.Error()
+	}
+	return md5String(encoded)
+}
+
+func md5String(raw []byte) string {
+	hash := md5.Sum(raw)
+	return hex.EncodeToString(hash[:])
+}
+
+func catchPanic(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("error: %v", r)
+		}
+	}()
+
+	f()
+	return nil
+}
+
+// Lists everything that changed between the baseline and the current result
+func compareRegressResults(font string, baseline RegressResult, result RegressResult) []string {
+	changes := make([]string, 0)
+	changed := func(what string, expected string, actual string) {
+		if expected != actual {
+			changes = append(changes, fmt.Sprintf("%s: %s changed from %q to %q", font, what, expected, actual))
+		}
+	}
+
+	changed("file hash", baseline.File, result.File)
+	changed("re-encoded hash", baseline.Reencoded, result.Reencoded)
+	changed("validation", fmt.Sprint(baseline.Validation), fmt.Sprint(result.Validation))
+	for _, scale := range sortedStringKeys(baseline.Upscaled, result.Upscaled) {
+		changed(scale+"x upscale", baseline.Upscaled[scale], result.Upscaled[scale])
+	}
+
+	return changes
+}
+
+func sortedStringKeys(a map[string]string, b map[string]string) []string {
+	keys := make([]string, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, exists := a[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func regressCommand(args []string) {
+	flags := newCommandFlagSet("regress", "[flags] corpus.yaml")
+	update := flags.Bool("update", false, "record the current results as the new baselines")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		exitWithUsage(flags)
+	}
+
+	corpus := readCorpus(flags.Arg(0))
+	fonts := corpus.fontFiles()
+	fontResults := make([]RegressResult, len(fonts))
+	// fonts share -threads with the sheet pools they run
+	fontWorkers, sheetThreads := splitWorkers(flags.threads, len(fonts))
+	settings := flags.newBffnt()
+	settings.Threads = sheetThreads
+	parallelEach(len(fonts), fontWorkers, func(i int) {
+		raw, err := ioutil.ReadFile(filepath.Join(corpus.Root, fonts[i]))
+		handleErr(err)
+		fontResults[i] = regressFont(settings, raw, corpus.Scales)
+	})
+	results := make(map[string]RegressResult)
+	for i, font := range fonts {
+		results[font] = fontResults[i]
+	}
+
+	if *update {
+		raw, err := yaml.Marshal(results)
+		handleErr(err)
+		handleErr(os.WriteFile(corpus.Baselines, raw, 0644))
+		fmt.Printf("recorded baselines of %d font(s) to %s\n", len(results), corpus.Baselines)
+		return
+	}
+
+	baselinesRaw, err := ioutil.ReadFile(corpus.Baselines)
+	if os.IsNotExist(err) {
+		handleErr(fmt.Errorf("no baselines at %s, record them with -update first", corpus.Baselines))
+	}
+	handleErr(err)
+	baselines := make(map[string]RegressResult)
+	handleErr(yaml.Unmarshal(baselinesRaw, &baselines))
+
+	changes := make([]string, 0)
+	for _, font := range sortedResultKeys(baselines, results) {
+		baseline, hasBaseline := baselines[font]
+		result, hasResult := results[font]
+		switch {
+		case !hasBaseline:
+			changes = append(changes, fmt.Sprintf("%s: no baseline recorded", font))
+		case !hasResult:
+			changes = append(changes, fmt.Sprintf("%s: font is missing from the corpus", font))
+		default:
+			changes = append(changes, compareRegressResults(font, baseline, result)...)
+		}
+	}
+
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if len(changes) > 0 {
+		fmt.Printf("%d change(s) against the baselines. Run with -update if they are intended\n", len(changes))
+		exit(1)
+	}
+	fmt.Printf("all %d font(s) match their baselines\n", len(results))
+}
+
+func sortedResultKeys(a map[string]RegressResult, b map[string]RegressResult) []string {
+	keys := make([]string, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, exists := a[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,293 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// A direct CMAP is a 20 byte header and a 2 byte offset, 24 bytes with the
+// padding. The same characters cost 4 bytes each in the scan map, so a direct
+// map pays off from 6 characters on. A table map costs 2 bytes per character
+// on top of the header and pays off from about 12.
+const (
+	CMAP_DIRECT_MIN_RUN = 6
+	CMAP_TABLE_MIN_RUN  = 12
+)
+
+// Replaces the CMAPs with the smallest set that maps the same characters to
+// the same glyphs. Runs of characters whose glyphs follow each other become
+// direct maps, other runs of consecutive characters table maps and whatever is
+// left goes into a single scan map at the end. None of the maps overlap so it
+// doesn't matter which one the game looks at first. Returns the amount of
+// CMAPs before and after.
+func (b *BFFNT) RebuildCMAPs() (before int, after int) {
+	b.loadCMAPs()
+	if b.charIndexes == nil {
+		b.buildCharIndex()
+	}
+	entries := b.charIndexes
+	before = len(b.CMAPs)
+
+	cmaps := make([]CMAP, 0)
+	newCMAP := func(method uint16, first charIndexEntry, last charIndexEntry) CMAP {
+		return CMAP{MagicHeader: CMAP_MAGIC_HEADER, CodeBegin: first.char, CodeEnd: last.char, MappingMethod: method, log: b.Log}
+	}
+	scan := newCMAP(2, charIndexEntry{}, charIndexEntry{})
+	scan.CodeBegin = 0xFFFF
+
+	for start := 0; start < len(entries); {
+		// characters follow each other
+		codeRun := start + 1
+		for codeRun < len(entries) && entries[codeRun].char == entries[codeRun-1].char+1 {
+			codeRun++
+		}
+		// and so do their glyphs
+		directRun := start + 1
+		for directRun < codeRun && entries[directRun].index == entries[directRun-1].index+1 {
+			directRun++
+		}
+
+		switch {
+		case directRun-start >= CMAP_DIRECT_MIN_RUN:
+			cmap := newCMAP(0, entries[start], entries[directRun-1])
+			cmap.CharacterOffset = entries[start].index
+			for _, entry := range entries[start:directRun] {
+				cmap.CharAscii = append(cmap.CharAscii, entry.char)
+				cmap.CharIndex = append(cmap.CharIndex, entry.index)
+			}
+			cmaps = append(cmaps, cmap)
+			start = directRun
+		case codeRun-start >= CMAP_TABLE_MIN_RUN:
+			// stop the table where a long enough direct run starts
+			end := start + 1
+			for ; end < codeRun; end++ {
+				run := end + 1
+				for run < codeRun && entries[run].index == entries[run-1].index+1 {
+					run++
+				}
+				if run-end >= CMAP_DIRECT_MIN_RUN {
+					break
+				}
+			}
+			if end-start < CMAP_TABLE_MIN_RUN {
+				end = codeRun
+			}
+			cmap := newCMAP(1, entries[start], entries[end-1])
+			for _, entry := range entries[start:end] {
+				cmap.CharAscii = append(cmap.CharAscii, entry.char)
+				cmap.CharIndex = append(cmap.CharIndex, entry.index)
+			}
+			cmaps = append(cmaps, cmap)
+			start = end
+		default:
+			entry := entries[start]
+			scan.CharAscii = append(scan.CharAscii, entry.char)
+			scan.CharIndex = append(scan.CharIndex, entry.index)
+			if entry.char < scan.CodeBegin {
+				scan.CodeBegin = entry.char
+			}
+			scan.CodeEnd = entry.char
+			start++
+		}
+	}
+
+	if len(scan.CharAscii) > 0 {
+		scan.CharacterCount = checkedUint16("CMAP scan entry count", int64(len(scan.CharAscii)))
+		cmaps = append(cmaps, scan)
+	}
+	b.CMAPs = cmaps
+	b.invalidateCharIndex()
+
+	return before, len(cmaps)
+}
+
+// Makes room for every glyph by adding blank sheets of the same size after
+// the existing ones. The original sheets are decoded so the existing glyphs
+// keep their pixels, they are encoded again from SheetData. Returns the
+// amount of sheets added.
+func (tglp *TGLP) repackSheets(glyphCount int) int {
+	cellsPerSheet := int(tglp.NumOfColumns) * int(tglp.NumOfRows)
+	if cellsPerSheet == 0 {
+		handleErr(fmt.Errorf("the TGLP has no cells to put glyphs in"))
+	}
+	sheetCount := (glyphCount + cellsPerSheet - 1) / cellsPerSheet
+	if sheetCount < int(tglp.NumOfSheets) {
+		sheetCount = int(tglp.NumOfSheets)
+	}
+
+	if len(tglp.SheetData) != int(tglp.NumOfSheets) {
+		tglp.DecodeSheets()
+	}
+	added := sheetCount - int(tglp.NumOfSheets)
+	for i := 0; i < added; i++ {
+		tglp.SheetData = append(tglp.SheetData, *image.NewNRGBA(image.Rect(0, 0, int(tglp.SheetWidth), int(tglp.SheetHeight))))
+	}
+
+	tglp.NumOfSheets = checkedUint8("TGLP sheet count", int64(sheetCount))
+	tglp.SectionSize = checkedUint32("TGLP section size", int64(TGLP_HEADER_SIZE)+int64(tglp.computePredataPadding())+int64(tglp.SheetSize)*int64(sheetCount))
+	// the sheets live in SheetData now, the decoded ones can't be copied
+	tglp.AllSheetData = nil
+	tglp.raw = nil
+
+	return added
+}
+
+// Font size in pixels that puts the replacement font's ascent on the font's
+// baseline, or smaller if its descent wouldn't fit below the baseline. Some
+// descenders reach further than the font's descent, they're cut off rather
+// than shrinking every glyph for them.
+func baselineFontSize(fontFile string, baseline int, cellHeight int) float64 {
+	const size = 100
+	face, err := opentype.NewFace(parseFontFile(fontFile), &opentype.FaceOptions{Size: size, DPI: 72})
+	handleErr(err)
+	defer face.Close()
+
+	metrics := face.Metrics()
+	fontSize := float64(baseline)
+	if ascent := float64(metrics.Ascent) / 64; ascent > 0 {
+		fontSize = float64(baseline) * size / ascent
+	}
+	if descent := float64(metrics.Descent) / 64; descent > 0 {
+		fontSize = math.Min(fontSize, float64(cellHeight-baseline)*size/descent)
+	}
+
+	return fontSize
+}
+
+// Draws the glyphs of chars from the replacement font into their cells of the
+// decoded sheets at fontSize pixels and takes their widths from the font.
+// Ink that doesn't fit the cell is cut off, those characters are returned.
+func (b *BFFNT) drawCharsIntoCells(fontFile string, fontSize float64, chars []rune) (clipped []rune) {
+	face, err := opentype.NewFace(parseFontFile(fontFile), &opentype.FaceOptions{
+		Size:    fontSize,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	handleErr(err)
+	defer face.Close()
+
+	baseline := int(b.TGLP.BaselinePosition)
+	for _, char := range chars {
+		index, found := b.CharIndex(char)
+		if !found {
+			continue
+		}
+		sheet, cell := b.TGLP.CellRect(int(index))
+		if sheet >= len(b.TGLP.SheetData) {
+			handleErr(fmt.Errorf("%s has glyph index %d but the sheets only have room for %d glyphs", formatChar(uint16(char)), index, len(b.TGLP.SheetData)*int(b.TGLP.NumOfColumns)*int(b.TGLP.NumOfRows)))
+		}
+
+		bounds, advance := font.BoundString(face, string(char))
+		leftAlignOffset := bounds.Min.X.Floor()
+		glyphWidth := bounds.Max.X.Ceil() - leftAlignOffset
+		if glyphWidth > cell.Dx() || bounds.Min.Y.Floor()+baseline < 0 || bounds.Max.Y.Ceil()+baseline > cell.Dy() {
+			clipped = append(clipped, char)
+		}
+
+		// cleared so a glyph can be drawn again
+		dst := b.TGLP.SheetData[sheet].SubImage(cell).(*image.NRGBA)
+		draw.Draw(dst, cell, image.Transparent, image.Point{}, draw.Src)
+		drawer := font.Drawer{
+			Dst:  dst,
+			Src:  image.White,
+			Face: face,
+			Dot:  fixed.P(cell.Min.X-leftAlignOffset, cell.Min.Y+baseline),
+		}
+		drawer.DrawString(string(char))
+
+		glyph := &b.CWDHs[0].Glyphs[index]
+		glyph.GlyphWidth = checkedUint8("glyph width", int64(clampInt(glyphWidth, 0, cell.Dx())))
+		if isCombiningMark(char) {
+			glyph.LeftWidth, glyph.CharWidth = markWidths(leftAlignOffset, advance.Round())
+		} else {
+			glyph.LeftWidth, _ = clampToLeftWidth(leftAlignOffset)
+			glyph.CharWidth, _ = clampToCharWidth(advance.Round())
+		}
+	}
+
+	return clipped
+}
+
+func clampInt(value int, min int, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+// Adds the characters of a charset that a font is missing in one go: new glyph
+// indexes, cells in the existing sheets or new sheets when they're full, the
+// glyphs drawn from a replacement font and every CMAP rebuilt. Unlike extend
+// nothing is upscaled and the original glyphs keep their pixels.
+func addCharsCommand(args []string) {
+	flags := newCommandFlagSet("addchars", "[flags] font.bffnt charset.txt|messages.msbt|text-dump-dir ...")
+	fontFile := flags.String("ttf", "", "replacement font the added glyphs are drawn with")
+	fontSize := flags.Float64("size", 0, "font size in pixels. 0 puts the replacement font's ascent on the baseline")
+	output := flags.String("o", "", "output bffnt. Defaults to <font>_extended.bffnt")
+	rebuild := flags.Bool("rebuild-cmap", true, "rebuild the CMAPs into the smallest set of direct, table and scan maps")
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 2 || *fontFile == "" {
+		exitWithUsage(flags)
+	}
+
+	*fontFile = findFontFile(*fontFile)
+	bffntFile := flags.Arg(0)
+	bffnt := flags.readBffnt(bffntFile)
+	charset := readCharset(charsetFiles(flags.Args()[1:]))
+	for _, warning := range charset.Warnings {
+		fmt.Println("warning:", warning)
+	}
+
+	missing := bffnt.missingChars(charset)
+	covered, uncovered := fontCoverage(*fontFile, "", missing)
+	if len(uncovered) > 0 {
+		fmt.Printf("warning: %s has no glyph for %d character(s), they are skipped: %s\n", *fontFile, len(uncovered), string(uncovered))
+	}
+	if len(covered) == 0 {
+		fmt.Printf("%s already has all %d character(s) the replacement font can draw\n", bffntFile, len(charset.Chars))
+		return
+	}
+
+	// the sheets are decoded before any glyph is added, new cells start out
+	// blank
+	bffnt.TGLP.DecodeSheets()
+	added := bffnt.AddChars(covered)
+	sheetsAdded := bffnt.TGLP.repackSheets(bffnt.glyphCount())
+	if *fontSize == 0 {
+		*fontSize = baselineFontSize(*fontFile, int(bffnt.TGLP.BaselinePosition), int(bffnt.TGLP.CellHeight))
+	}
+	clipped := bffnt.drawCharsIntoCells(*fontFile, *fontSize, added)
+	if len(clipped) > 0 {
+		fmt.Printf("warning: %d glyph(s) don't fit their %dx%d cell and are cut off, try a smaller -size: %s\n", len(clipped), bffnt.TGLP.CellWidth, bffnt.TGLP.CellHeight, string(clipped))
+	}
+	if *rebuild {
+		before, after := bffnt.RebuildCMAPs()
+		fmt.Printf("rebuilt %d CMAP(s) into %d\n", before, after)
+	}
+	fmt.Printf("added %d of %d missing character(s) at %.1f pixels, %d new sheet(s)\n", len(added), len(missing), *fontSize, sheetsAdded)
+
+	outputFile := *output
+	if outputFile == "" {
+		outputFile = strings.TrimSuffix(filepath.Base(bffntFile), filepath.Ext(bffntFile)) + "_extended.bffnt"
+	}
+	encoded := bffnt.Encode()
+	for _, failure := range bffnt.Validation().Failures {
+		fmt.Println("warning:", failure)
+	}
+	handleErr(bffnt.VerifyEncoded(encoded))
+	handleErr(os.WriteFile(outputFile, encoded, 0644))
+	fmt.Println("wrote", outputFile)
+}
@@ -0,0 +1,199 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Byte offsets of the header fields repair touches
+const (
+	FFNT_TOTAL_FILE_SIZE_POS = 0x0C
+	FINF_TGLP_OFFSET_POS     = FFNT_HEADER_SIZE + 0x14
+	FINF_CWDH_OFFSET_POS     = FFNT_HEADER_SIZE + 0x18
+	FINF_CMAP_OFFSET_POS     = FFNT_HEADER_SIZE + 0x1C
+	TGLP_START               = FFNT_HEADER_SIZE + FINF_HEADER_SIZE
+)
+
+// Hex editing a font usually leaves the section sizes, the section offsets
+// and the total file size out of date. Repair walks the sections in file order
+// and recomputes all of them from the content that is actually there. Only
+// header fields are patched, sheet data and glyph information are left alone.
+// Returns the repaired file and a description of every fix.
+func Repair(raw []byte) (repaired []byte, fixes []string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("can't repair: %v", r)
+		}
+	}()
+
+	repaired = append([]byte{}, raw...)
+	fixes = make([]string, 0)
+	patch := func(field string, pos int, value uint32) {
+		old := binary.BigEndian.Uint32(repaired[pos : pos+4])
+		if old != value {
+			binary.BigEndian.PutUint32(repaired[pos:pos+4], value)
+			fixes = append(fixes, fmt.Sprintf("%s: %d -> %d", field, old, value))
+		}
+	}
+
+	// The sheet data size can be calculated from the sheet dimensions for
+	// the formats we know
+	var tglp TGLP
+	tglp.DecodeHeader(repaired[TGLP_START : TGLP_START+TGLP_HEADER_SIZE])
+	if tglp.MagicHeader != TGLP_MAGIC_HEADER {
+		panic(fmt.Sprintf("no TGLP at offset %d", TGLP_START))
+	}
+	if tglp.SheetImageFormat == IMAGE_FORMAT_A8 || tglp.SheetImageFormat == IMAGE_FORMAT_BC4 {
+		tglp.SheetSize = tglp.computeSheetSize()
+		patch("TGLP sheet size", TGLP_START+12, tglp.SheetSize)
+	}
+	tglpSize := int(tglp.SheetDataOffset) - TGLP_START + int(tglp.SheetSize)*int(tglp.NumOfSheets)
+	patch("TGLP section size", TGLP_START+4, uint32(tglpSize))
+	patch("FINF TGLP offset", FINF_TGLP_OFFSET_POS, uint32(TGLP_START+8))
+
+	// Every section after the sheets follows the previous one directly
+	pos := TGLP_START + tglpSize
+	counts := make(map[string]int)
+	for pos < len(repaired) {
+		magic := string(repaired[pos : pos+4])
+		name := fmt.Sprintf("%s %d", magic, counts[magic])
+		var size int
+		switch magic {
+		case CWDH_MAGIC_HEADER:
+			size = CWDH_HEADER_SIZE + cwdhDataSize(repaired[pos:])
+		case CMAP_MAGIC_HEADER:
+			size = CMAP_HEADER_SIZE + cmapDataSize(repaired[pos:])
+		case KRNG_MAGIC_HEADER:
+			size = KRNG_HEADER_SIZE + krngDataSize(repaired[pos:])
+		default:
+			if allZeroBytes(repaired[pos:]) {
+				break
+			}
+			panic(fmt.Sprintf("unknown section %q at offset %d", magic, pos))
+		}
+		if size == 0 {
+			break
+		}
+		size += paddingToNext4ByteBoundary(pos + size)
+		patch(name+" section size", pos+4, uint32(size))
+
+		if counts[magic] == 0 {
+			switch magic {
+			case CWDH_MAGIC_HEADER:
+				patch("FINF CWDH offset", FINF_CWDH_OFFSET_POS, uint32(pos+8))
+			case CMAP_MAGIC_HEADER:
+				patch("FINF CMAP offset", FINF_CMAP_OFFSET_POS, uint32(pos+8))
+			}
+		}
+
+		// chained sections point at the next section of the same kind
+		next := pos + size
+		nextOffset := uint32(0)
+		if next+4 <= len(repaired) && string(repaired[next:next+4]) == magic {
+			nextOffset = uint32(next + 8)
+		}
+		switch magic {
+		case CWDH_MAGIC_HEADER:
+			patch(name+" next offset", pos+12, nextOffset)
+		case CMAP_MAGIC_HEADER:
+			patch(name+" next offset", pos+16, nextOffset)
+		}
+
+		counts[magic]++
+		pos = next
+	}
+
+	if counts[CWDH_MAGIC_HEADER] == 0 || counts[CMAP_MAGIC_HEADER] == 0 {
+		panic("the font needs at least one CWDH and one CMAP")
+	}
+
+	if pos < len(repaired) {
+		fixes = append(fixes, fmt.Sprintf("removed %d trailing bytes after offset %d", len(repaired)-pos, pos))
+		repaired = repaired[:pos]
+	}
+	patch("FFNT total file size", FFNT_TOTAL_FILE_SIZE_POS, uint32(len(repaired)))
+
+	return repaired, fixes, nil
+}
+
+// glyph widths are 3 bytes for every index in the range
+func cwdhDataSize(section []byte) int {
+	startIndex := binary.BigEndian.Uint16(section[8:10])
+	endIndex := binary.BigEndian.Uint16(section[10:12])
+	return (int(endIndex) - int(startIndex) + 1) * 3
+}
+
+// See CMAP.Decode for the mapping methods
+func cmapDataSize(section []byte) int {
+	codeBegin := binary.BigEndian.Uint16(section[8:10])
+	codeEnd := binary.BigEndian.Uint16(section[10:12])
+	switch binary.BigEndian.Uint16(section[12:14]) {
+	case 0:
+		return 2
+	case 1:
+		return (int(codeEnd) - int(codeBegin) + 1) * 2
+	case 2:
+		characterCount := binary.BigEndian.Uint16(section[CMAP_HEADER_SIZE : CMAP_HEADER_SIZE+2])
+		return 2 + int(characterCount)*4
+	}
+
+	panic(fmt.Sprintf("unknown mapping method %d", binary.BigEndian.Uint16(section[12:14])))
+}
+
+// The kerning data ends after the pair array that reaches the furthest
+func krngDataSize(section []byte) int {
+	data := section[KRNG_HEADER_SIZE:]
+	firstCharCount := int(binary.BigEndian.Uint16(data[0:2]))
+	size := 2 + firstCharCount*4
+	for i := 0; i < firstCharCount; i++ {
+		pairArrayOffset := int(binary.BigEndian.Uint16(data[2+i*4+2:2+i*4+4])) * 2
+		pairCount := int(binary.BigEndian.Uint16(data[pairArrayOffset : pairArrayOffset+2]))
+		if end := pairArrayOffset + 2 + pairCount*4; end > size {
+			size = end
+		}
+	}
+
+	return size
+}
+
+func repairCommand(args []string) {
+	flags := newCommandFlagSet("repair", "[flags] font.bffnt")
+	outputFile := flags.String("o", "", "output file. Defaults to <name>_repaired.bffnt next to the input")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		exitWithUsage(flags)
+	}
+
+	bffntFile := flags.Arg(0)
+	raw, err := ioutil.ReadFile(bffntFile)
+	handleErr(err)
+
+	repaired, fixes, err := Repair(raw)
+	handleErr(err)
+	if len(fixes) == 0 {
+		fmt.Println("nothing to repair in", bffntFile)
+		return
+	}
+	for _, fix := range fixes {
+		fmt.Println("fixed", fix)
+	}
+
+	// make sure the result actually decodes before writing it
+	bffnt := flags.newBffnt()
+	report := bffnt.DecodePartial(repaired)
+	if !report.Complete() {
+		handleErr(fmt.Errorf("repaired font still doesn't decode: %s", report))
+	}
+
+	if *outputFile == "" {
+		name := strings.TrimSuffix(bffntFile, filepath.Ext(bffntFile))
+		*outputFile = name + "_repaired" + filepath.Ext(bffntFile)
+	}
+	handleErr(os.WriteFile(*outputFile, repaired, 0644))
+	fmt.Printf("wrote repaired font with %d fix(es) to %s\n", len(fixes), *outputFile)
+}
@@ -0,0 +1,217 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// BotW keeps its fonts in Font_*.sbfarc, a Yaz0 compressed SARC archive. To
+// swap a font in it the archive is decompressed, the file replaced and the
+// archive written again. Wii U archives are big endian, Switch ones little
+// endian, the byte order mark tells them apart. Yaz0 headers are big endian on
+// both.
+
+const (
+	YAZ0_HEADER_SIZE = 16
+	SARC_HEADER_SIZE = 0x14
+	SFAT_HEADER_SIZE = 0x0C
+	SFAT_NODE_SIZE   = 0x10
+)
+
+func decodeYaz0(raw []byte) ([]byte, error) {
+	if len(raw) < YAZ0_HEADER_SIZE || string(raw[0:4]) != "Yaz0" {
+		return nil, fmt.Errorf("not Yaz0 compressed")
+	}
+	size := int(binary.BigEndian.Uint32(raw[4:8]))
+	res := make([]byte, 0, size)
+
+	pos := YAZ0_HEADER_SIZE
+	truncated := fmt.Errorf("Yaz0 data ends after %d of %d bytes", len(res), size)
+	for len(res) < size {
+		if pos >= len(raw) {
+			return nil, truncated
+		}
+		code := raw[pos]
+		pos++
+		for bit := 0; bit < 8 && len(res) < size; bit++ {
+			if code&(0x80>>bit) != 0 {
+				if pos >= len(raw) {
+					return nil, truncated
+				}
+				res = append(res, raw[pos])
+				pos++
+				continue
+			}
+
+			// a back reference: 4 bits of length and 12 bits of distance, a
+			// length of 0 means the length is in the next byte
+			if pos+2 > len(raw) {
+				return nil, truncated
+			}
+			distance := (int(raw[pos]&0x0F)<<8 | int(raw[pos+1])) + 1
+			length := int(raw[pos] >> 4)
+			pos += 2
+			if length == 0 {
+				if pos >= len(raw) {
+					return nil, truncated
+				}
+				length = int(raw[pos]) + 0x12
+				pos++
+			} else {
+				length += 2
+			}
+			if distance > len(res) {
+				return nil, fmt.Errorf("Yaz0 back reference %d bytes before the start of the data", distance)
+			}
+			for i := 0; i < length && len(res) < size; i++ {
+				res = append(res, res[len(res)-distance])
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// Yaz0 without any back references, every byte is stored as it is. Bigger
+// than the original by an eighth but the game decompresses it all the same.
+func encodeYaz0Stored(data []byte) []byte {
+	res := make([]byte, YAZ0_HEADER_SIZE, YAZ0_HEADER_SIZE+len(data)+(len(data)+7)/8)
+	copy(res, "Yaz0")
+	binary.BigEndian.PutUint32(res[4:8], uint32(len(data)))
+	for start := 0; start < len(data); start += 8 {
+		end := start + 8
+		if end > len(data) {
+			end = len(data)
+		}
+		res = append(res, 0xFF)
+		res = append(res, data[start:end]...)
+	}
+
+	return res
+}
+
+type sarcFile struct {
+	Name      string
+	Data      []byte
+	alignment int // of the file's data in the original archive
+}
+
+// The header and the file tables are kept as they are, only the file data
+// and the offsets to it change when the archive is written again
+type SARC struct {
+	Files []sarcFile
+
+	tables     []byte // SARC header, SFAT and SFNT up to the data
+	nodeOrder  []int  // Files index of every SFAT node
+	dataOffset uint32
+	order      binary.ByteOrder // of the archive's tables, see DecodeSARC
+}
+
+func DecodeSARC(raw []byte) (SARC, error) {
+	var sarc SARC
+	if len(raw) < SARC_HEADER_SIZE+SFAT_HEADER_SIZE || string(raw[0:4]) != "SARC" {
+		return sarc, fmt.Errorf("not a SARC archive")
+	}
+	switch binary.BigEndian.Uint16(raw[6:8]) {
+	case 0xFEFF:
+		sarc.order = binary.BigEndian
+	case 0xFFFE:
+		sarc.order = binary.LittleEndian
+	default:
+		return sarc, fmt.Errorf("SARC byte order mark 0x%X is neither big nor little endian", raw[6:8])
+	}
+	sarc.dataOffset = sarc.order.Uint32(raw[0x0C:0x10])
+	if int(sarc.dataOffset) > len(raw) {
+		return sarc, fmt.Errorf("SARC data offset 0x%X is past the end of the archive", sarc.dataOffset)
+	}
+	sarc.tables = append([]byte(nil), raw[:sarc.dataOffset]...)
+
+	sfat := SARC_HEADER_SIZE
+	if string(raw[sfat:sfat+4]) != "SFAT" {
+		return sarc, fmt.Errorf("SFAT missing")
+	}
+	nodeCount := int(sarc.order.Uint16(raw[sfat+6 : sfat+8]))
+	sfnt := sfat + SFAT_HEADER_SIZE + nodeCount*SFAT_NODE_SIZE
+	if sfnt+8 > int(sarc.dataOffset) || string(raw[sfnt:sfnt+4]) != "SFNT" {
+		return sarc, fmt.Errorf("SFNT missing")
+	}
+	names := raw[sfnt+8 : sarc.dataOffset]
+
+	for i := 0; i < nodeCount; i++ {
+		node := raw[sfat+SFAT_HEADER_SIZE+i*SFAT_NODE_SIZE:]
+		attributes := sarc.order.Uint32(node[4:8])
+		start := sarc.order.Uint32(node[8:12])
+		end := sarc.order.Uint32(node[12:16])
+		if start > end || int(sarc.dataOffset)+int(end) > len(raw) {
+			return sarc, fmt.Errorf("SFAT node %d points past the end of the archive", i)
+		}
+
+		name := fmt.Sprintf("0x%08X", sarc.order.Uint32(node[0:4]))
+		if attributes&0x01000000 != 0 {
+			nameStart := int(attributes&0xFFFF) * 4
+			nameEnd := nameStart
+			for nameEnd < len(names) && names[nameEnd] != 0 {
+				nameEnd++
+			}
+			name = string(names[nameStart:nameEnd])
+		}
+
+		dataStart := int(sarc.dataOffset) + int(start)
+		alignment := 0x2000
+		for alignment > 1 && dataStart%alignment != 0 {
+			alignment /= 2
+		}
+		sarc.Files = append(sarc.Files, sarcFile{
+			Name:      name,
+			Data:      append([]byte(nil), raw[dataStart:int(sarc.dataOffset)+int(end)]...),
+			alignment: alignment,
+		})
+		sarc.nodeOrder = append(sarc.nodeOrder, i)
+	}
+
+	return sarc, nil
+}
+
+func (sarc *SARC) file(name string) *sarcFile {
+	for i := range sarc.Files {
+		if sarc.Files[i].Name == name {
+			return &sarc.Files[i]
+		}
+	}
+	return nil
+}
+
+// Replaces the data of a file already in the archive
+func (sarc *SARC) Replace(name string, data []byte) error {
+	file := sarc.file(name)
+	if file == nil {
+		names := make([]string, 0, len(sarc.Files))
+		for _, file := range sarc.Files {
+			names = append(names, file.Name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("the archive has no %s, it has %v", name, names)
+	}
+	file.Data = data
+
+	return nil
+}
+
+func (sarc *SARC) Encode() []byte {
+	res := append([]byte(nil), sarc.tables...)
+	for i, file := range sarc.Files {
+		for (len(res))%file.alignment != 0 {
+			res = append(res, 0)
+		}
+		start := uint32(len(res)) - sarc.dataOffset
+		res = append(res, file.Data...)
+
+		node := res[SARC_HEADER_SIZE+SFAT_HEADER_SIZE+sarc.nodeOrder[i]*SFAT_NODE_SIZE:]
+		sarc.order.PutUint32(node[8:12], start)
+		sarc.order.PutUint32(node[12:16], start+uint32(len(file.Data)))
+	}
+	sarc.order.PutUint32(res[8:12], uint32(len(res)))
+
+	return res
+}
@@ -0,0 +1,261 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Every section after the FFNT header starts with a 4 byte magic and a 4 byte
+// section size, and the sizes include any padding. That's enough to walk a
+// file section by section without following FINF's offsets or the CWDH and
+// CMAP chains.
+type sectionHeader struct {
+	MagicHeader string
+	Start       int // offset of the magic. Decoders take Start+8, like FINF's offsets
+	Size        int
+}
+
+func (header sectionHeader) end() int {
+	return header.Start + header.Size
+}
+
+// Reads section headers in file order, same idea as a bufio.Scanner:
+//
+//	sections := newSectionIterator(raw)
+//	for sections.Next() {
+//		header := sections.Section()
+//	}
+//	err := sections.Err()
+type sectionIterator struct {
+	raw     []byte
+	pos     int
+	current sectionHeader
+	err     error
+}
+
+func newSectionIterator(raw []byte) *sectionIterator {
+	return &sectionIterator{raw: raw, pos: FFNT_HEADER_SIZE}
+}
+
+// Moves to the next section. Returns false at the end of the file or when a
+// header can't be read, a cut off header or a size under 8 bytes that would
+// never get past the header.
+func (it *sectionIterator) Next() bool {
+	if it.err != nil || it.pos >= len(it.raw) {
+		return false
+	}
+	if it.pos+8 > len(it.raw) {
+		it.err = fmt.Errorf("section header at offset %d is cut off, file ends at offset %d", it.pos, len(it.raw))
+		return false
+	}
+
+	header := sectionHeader{
+		MagicHeader: string(it.raw[it.pos : it.pos+4]),
+		Start:       it.pos,
+		Size:        int(binary.BigEndian.Uint32(it.raw[it.pos+4 : it.pos+8])),
+	}
+	if header.Size < 8 {
+		it.err = fmt.Errorf("%q section at offset %d has a size of %d", header.MagicHeader, it.pos, header.Size)
+		return false
+	}
+	it.current = header
+	it.pos += header.Size
+
+	return true
+}
+
+func (it *sectionIterator) Section() sectionHeader {
+	return it.current
+}
+
+// Continues after size bytes of the current section instead of its recorded
+// size. Sizes that don't get past the header are ignored, the walk has to keep
+// moving forward.
+func (it *sectionIterator) resize(size int) {
+	if size >= 8 {
+		it.pos = it.current.Start + size
+	}
+}
+
+// Offset of the header that couldn't be read
+func (it *sectionIterator) Err() error {
+	return it.err
+}
+
+// Sections DecodeSections decodes completely, e.g. SectionCWDH|SectionKRNG
+type SectionMask uint
+
+const (
+	SectionFINF SectionMask = 1 << iota
+	SectionTGLP
+	SectionCWDH
+	SectionCMAP
+	SectionKRNG
+
+	SectionAll = SectionFINF | SectionTGLP | SectionCWDH | SectionCMAP | SectionKRNG
+)
+
+var sectionMasks = map[string]SectionMask{
+	FINF_MAGIC_HEADER: SectionFINF,
+	TGLP_MAGIC_HEADER: SectionTGLP,
+	CWDH_MAGIC_HEADER: SectionCWDH,
+	CMAP_MAGIC_HEADER: SectionCMAP,
+	KRNG_MAGIC_HEADER: SectionKRNG,
+}
+
+// Decodes a single section into the font. raw is the whole file. Lazy parsers
+// only decode the header and leave the data for later, see DecodeLazy. Returns
+// the size the section really takes up, usually header.Size. TGLP's size
+// follows from its sheets so a wrong size there is only a validation failure
+// and the walk carries on after the sheets.
+type sectionParser func(b *BFFNT, raw []byte, header sectionHeader, lazy bool) (size int)
+
+// Parsers of the sections we understand, by magic. Anything else is kept
+// as an UnknownSection and written back unchanged, unless a decoder was
+// registered for it, see RegisterSection.
+var sectionParsers = map[string]sectionParser{
+	FINF_MAGIC_HEADER: func(b *BFFNT, raw []byte, header sectionHeader, lazy bool) int {
+		expectSectionAt(header, FFNT_HEADER_SIZE)
+		b.FINF.Decode(raw)
+		return header.Size
+	},
+	// the sheet data is a slice of raw either way, sheets are only decoded
+	// into images when they're needed
+	TGLP_MAGIC_HEADER: func(b *BFFNT, raw []byte, header sectionHeader, lazy bool) int {
+		expectSectionAt(header, FFNT_HEADER_SIZE+FINF_HEADER_SIZE)
+		b.TGLP.Decode(raw)
+		return int(b.TGLP.SheetDataOffset) + len(b.TGLP.AllSheetData) - header.Start
+	},
+	CWDH_MAGIC_HEADER: func(b *BFFNT, raw []byte, header sectionHeader, lazy bool) int {
+		cwdh := CWDH{log: b.Log, leftoverPolicy: b.LeftoverPolicy}
+		if lazy {
+			cwdh.DecodeHeader(raw[header.Start : header.Start+CWDH_HEADER_SIZE])
+			cwdh.lazy = lazySection{raw, uint32(header.Start + 8)}
+		} else {
+			cwdh.Decode(raw, uint32(header.Start+8))
+		}
+		b.CWDHs = append(b.CWDHs, cwdh)
+		return header.Size
+	},
+	CMAP_MAGIC_HEADER: func(b *BFFNT, raw []byte, header sectionHeader, lazy bool) int {
+		cmap := CMAP{log: b.Log, leftoverPolicy: b.LeftoverPolicy}
+		if lazy {
+			cmap.DecodeHeader(raw[header.Start : header.Start+CMAP_HEADER_SIZE])
+			cmap.lazy = lazySection{raw, uint32(header.Start + 8)}
+		} else {
+			cmap.Decode(raw, uint32(header.Start+8))
+		}
+		b.CMAPs = append(b.CMAPs, cmap)
+		return header.Size
+	},
+	KRNG_MAGIC_HEADER: func(b *BFFNT, raw []byte, header sectionHeader, lazy bool) int {
+		if lazy {
+			b.KRNG.MagicHeader = header.MagicHeader
+			b.KRNG.SectionSize = uint32(header.Size)
+			b.KRNG.lazy = lazySection{raw, uint32(header.Start + 8)}
+		} else {
+			b.KRNG.Decode(raw, uint32(header.Start+8))
+		}
+		return header.Size
+	},
+}
+
+// The order sections are written in, used to tell what's missing when decoding
+// stops early. Unknown sections come last.
+var sectionOrder = []string{FINF_MAGIC_HEADER, TGLP_MAGIC_HEADER, CWDH_MAGIC_HEADER, CMAP_MAGIC_HEADER, KRNG_MAGIC_HEADER}
+
+// CWDHs and CMAPs are numbered like everywhere else, e.g. "CMAP 2"
+func sectionName(magic string, index int) string {
+	switch magic {
+	case CWDH_MAGIC_HEADER, CMAP_MAGIC_HEADER:
+		return fmt.Sprintf("%s %d", magic, index)
+	}
+	if _, known := sectionParsers[magic]; !known && !isCustomSection(magic) {
+		return fmt.Sprintf("unknown section %q", magic)
+	}
+	return magic
+}
+
+// The sections that usually come after the last one that was decoded
+func sectionsAfter(lastMagic string) []string {
+	res := make([]string, 0)
+	found := lastMagic == FFNT_MAGIC_HEADER
+	for _, magic := range sectionOrder {
+		switch {
+		case magic == lastMagic && (magic == CWDH_MAGIC_HEADER || magic == CMAP_MAGIC_HEADER):
+			res = append(res, fmt.Sprintf("later %ss", magic))
+		case found:
+			res = append(res, magic)
+		}
+		found = found || magic == lastMagic
+	}
+
+	return res
+}
+
+// FINF and TGLP are always decoded from fixed offsets
+func expectSectionAt(header sectionHeader, start int) {
+	if header.Start != start {
+		panic(fmt.Sprintf("%s section has to be at offset %d, found it at %d", header.MagicHeader, start, header.Start))
+	}
+}
+
+// A section this tool doesn't know about, e.g. from a newer version of the
+// format. Nothing in the known sections points to it, it's written back after
+// the kerning table where it was among the other extra sections.
+type UnknownSection struct {
+	MagicHeader string
+	Raw         []byte // the whole section including its header
+}
+
+func (section *UnknownSection) Magic() string {
+	return section.MagicHeader
+}
+
+func (section *UnknownSection) Present() bool {
+	return true
+}
+
+func (section *UnknownSection) Encode(startOffset uint32) []byte {
+	return section.Raw
+}
+
+func decodeUnknownSection(raw []byte, header sectionHeader) *UnknownSection {
+	sectionRaw := make([]byte, header.Size)
+	copy(sectionRaw, raw[header.Start:header.end()])
+
+	return &UnknownSection{MagicHeader: header.MagicHeader, Raw: sectionRaw}
+}
+
+// The walk decodes sections in file order, the offsets in FINF and the chains
+// of CWDHs and CMAPs should still lead to the same sections in the same order.
+// Offsets that disagree are validation failures. A chain that goes on after
+// the last section of its kind means sections are missing, e.g. a file that
+// was cut off right between two sections.
+func (b *BFFNT) checkSectionChains(starts map[string][]int) error {
+	checkChain := func(name string, firstOffset uint32, nextOffsets []uint32) error {
+		offset := firstOffset
+		for i, start := range starts[name] {
+			b.validation.assertEqual(fmt.Sprintf("%s %d offset", name, i), int(offset), start+8)
+			offset = nextOffsets[i]
+		}
+		if offset != 0 {
+			return fmt.Errorf("%s chain continues at offset %d but there are no more %s sections", name, offset, name)
+		}
+		return nil
+	}
+
+	cwdhNext := make([]uint32, 0, len(b.CWDHs))
+	for _, cwdh := range b.CWDHs {
+		cwdhNext = append(cwdhNext, cwdh.NextCWDHOffset)
+	}
+	cmapNext := make([]uint32, 0, len(b.CMAPs))
+	for _, cmap := range b.CMAPs {
+		cmapNext = append(cmapNext, cmap.NextCMAPOffset)
+	}
+
+	if err := checkChain(CWDH_MAGIC_HEADER, b.FINF.CWDHOffset, cwdhNext); err != nil {
+		return err
+	}
+	return checkChain(CMAP_MAGIC_HEADER, b.FINF.CMAPOffset, cmapNext)
+}
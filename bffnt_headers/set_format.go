@@ -0,0 +1,96 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Sheet formats that can be decoded and encoded. A8 keeps every alpha value
+// at a byte per pixel, BC4 packs 4x4 blocks into 8 bytes, a quarter of the
+// memory, but its blocks only have 8 alpha levels each.
+var imageFormats = map[string]uint16{
+	"A8":  IMAGE_FORMAT_A8,
+	"BC4": IMAGE_FORMAT_BC4,
+}
+
+func imageFormatNames() []string {
+	names := make([]string, 0, len(imageFormats))
+	for name := range imageFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func imageFormatName(format uint16) string {
+	for name, known := range imageFormats {
+		if known == format {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("format %d", format)
+}
+
+func knownImageFormat(format uint16) bool {
+	return format == IMAGE_FORMAT_A8 || format == IMAGE_FORMAT_BC4
+}
+
+// Re-encodes the sheets in another format. The sheets are decoded first,
+// from then on they're encoded from SheetData.
+func (tglp *TGLP) SetImageFormat(format uint16) error {
+	if !knownImageFormat(format) {
+		return fmt.Errorf("sheet format %d can't be encoded. Known formats: %s", format, strings.Join(imageFormatNames(), ", "))
+	}
+	if !knownImageFormat(tglp.SheetImageFormat) {
+		return fmt.Errorf("sheet format %d can't be decoded", tglp.SheetImageFormat)
+	}
+	if len(tglp.SheetData) != int(tglp.NumOfSheets) {
+		tglp.DecodeSheets()
+	}
+
+	tglp.SheetImageFormat = format
+	tglp.SheetSize = tglp.computeSheetSize()
+	tglp.SectionSize = checkedUint32("TGLP section size", int64(TGLP_HEADER_SIZE)+int64(tglp.computePredataPadding())+int64(tglp.SheetSize)*int64(tglp.NumOfSheets))
+	// the raw sheets are in the old format
+	tglp.AllSheetData = nil
+	tglp.raw = nil
+
+	return nil
+}
+
+func setFormatCommand(args []string) {
+	flags := newCommandFlagSet("set-format", "[flags] font.bffnt")
+	formatName := flags.String("format", "", "sheet format to re-encode the sheets in: "+strings.Join(imageFormatNames(), ", "))
+	output := flags.String("o", "", "output file. Defaults to <name>_<format>.bffnt next to the input")
+	_ = flags.Parse(args)
+
+	format, known := imageFormats[strings.ToUpper(*formatName)]
+	if flags.NArg() != 1 || !known {
+		exitWithUsage(flags)
+	}
+
+	bffntFile := flags.Arg(0)
+	bffnt := flags.readBffnt(bffntFile)
+	from, fromSize := imageFormatName(bffnt.TGLP.SheetImageFormat), bffnt.TGLP.SheetSize
+	if bffnt.TGLP.SheetImageFormat == format {
+		fmt.Println(bffntFile, "is already", from)
+		return
+	}
+	handleErr(bffnt.TGLP.SetImageFormat(format))
+	fmt.Printf("re-encoded %d sheet(s) from %s to %s, %d bytes each instead of %d\n",
+		bffnt.TGLP.NumOfSheets, from, imageFormatName(format), bffnt.TGLP.SheetSize, fromSize)
+
+	if *output == "" {
+		name := strings.TrimSuffix(bffntFile, filepath.Ext(bffntFile))
+		*output = name + "_" + strings.ToLower(imageFormatName(format)) + filepath.Ext(bffntFile)
+	}
+	encoded := bffnt.Encode()
+	handleErr(bffnt.VerifyEncoded(encoded))
+	handleErr(os.WriteFile(*output, encoded, 0644))
+	fmt.Println("wrote", *output)
+}
@@ -0,0 +1,153 @@
+package bffnt_headers
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Where deswizzled sheets are kept between runs, empty turns the cache off.
+// Defaults to bffnt/sheets in the user's cache directory, e.g.
+// ~/.cache/bffnt/sheets on Linux. Set with -sheet-cache.
+var SheetCacheDir = defaultSheetCacheDir()
+
+// The least recently used sheets are removed once the cache is bigger than
+// this. A 4096x4096 sheet is 16 MiB, most BotW sheets are 1 MiB.
+var SheetCacheMaxSize int64 = 128 << 20
+
+func defaultSheetCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "bffnt", "sheets")
+}
+
+// Registers the -sheet-cache flag which sets SheetCacheDir
+func sheetCacheFlag(flags *flag.FlagSet) {
+	flags.StringVar(&SheetCacheDir, "sheet-cache", SheetCacheDir, "directory deswizzled sheets are cached in between runs, empty disables the cache")
+	flags.Int64Var(&SheetCacheMaxSize, "sheet-cache-size", SheetCacheMaxSize, "bytes the sheet cache may take up before the least recently used sheets are removed")
+}
+
+// Deswizzling a sheet and decoding BC4 takes most of the time of a preview,
+// diff or export of a big font, and the same fonts get opened over and over.
+// The decoded alpha of every sheet is cached by a hash of its raw bytes and
+// everything that affects how they're decoded, so an edited sheet or layout
+// just misses the cache.
+func (tglp *TGLP) sheetCacheKey(sheetData []byte, sheet int) string {
+	sw, sh, pitch, bpp := tglp.sheetSurface()
+	hash := sha256.New()
+	for _, value := range []uint64{uint64(tglp.SheetImageFormat), uint64(tglp.SheetWidth), uint64(tglp.SheetHeight), uint64(sw), uint64(sh), uint64(pitch), uint64(bpp), uint64(sheet)} {
+		_ = binary.Write(hash, binary.BigEndian, value)
+	}
+	hash.Write(sheetData)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func sheetCachePath(key string) string {
+	// a level of subdirectories like git objects, so a cache of a lot of
+	// fonts doesn't end up as one huge directory
+	return filepath.Join(SheetCacheDir, key[:2], key[2:]+".alpha")
+}
+
+// The cached alpha of a sheet, nil on a miss. A cache entry of the wrong size
+// is treated as a miss, it gets overwritten after decoding.
+func (tglp *TGLP) cachedSheet(key string) []byte {
+	if SheetCacheDir == "" {
+		return nil
+	}
+	path := sheetCachePath(key)
+	pixels, err := os.ReadFile(path)
+	if err != nil || len(pixels) != int(tglp.SheetWidth)*int(tglp.SheetHeight) {
+		return nil
+	}
+	// the modification time is when the sheet was last used, see pruneSheetCache
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return pixels
+}
+
+// The cache only makes things faster, failing to write it isn't an error
+func cacheSheet(log *Logger, key string, pixels []byte) {
+	if SheetCacheDir == "" {
+		return
+	}
+
+	path := sheetCachePath(key)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		// write then rename so a run that's interrupted or racing another one
+		// never leaves half a sheet behind
+		var tmp *os.File
+		tmp, err = os.CreateTemp(filepath.Dir(path), "*.tmp")
+		if err == nil {
+			_, err = tmp.Write(pixels)
+			if closeErr := tmp.Close(); err == nil {
+				err = closeErr
+			}
+			if err == nil {
+				err = os.Rename(tmp.Name(), path)
+			}
+			if err != nil {
+				_ = os.Remove(tmp.Name())
+			}
+		}
+	}
+
+	if err == nil {
+		err = pruneSheetCache(SheetCacheMaxSize)
+	}
+	if err != nil {
+		log.debugln("could not cache sheet:", err)
+	}
+}
+
+// Removes the least recently used sheets until the cache takes up at most
+// maxSize bytes. Only runs after a sheet was written, reading never grows the
+// cache.
+func pruneSheetCache(maxSize int64) error {
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	entries := make([]entry, 0)
+	var total int64
+	err := filepath.WalkDir(SheetCacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".alpha") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed by another run in the meantime
+		}
+		entries = append(entries, entry{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil || total <= maxSize {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	for _, entry := range entries {
+		if total <= maxSize {
+			break
+		}
+		if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= entry.size
+	}
+
+	return nil
+}
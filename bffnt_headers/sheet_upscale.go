@@ -0,0 +1,228 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// A filter for rescaling the original sheets instead of rendering glyphs from
+// a font file. Pixel art scalers only double the cell, they're applied until
+// the cell is at least as big as the target and the result is resampled to
+// the exact size.
+type sheetFilter struct {
+	resample imaging.ResampleFilter
+	pixelArt func(src *image.NRGBA) *image.NRGBA // nil to only resample
+}
+
+var sheetFilters = map[string]sheetFilter{
+	"nearest":  {imaging.NearestNeighbor, nil},
+	"bilinear": {imaging.Linear, nil},
+	"lanczos":  {imaging.Lanczos, nil},
+	"hqx":      {imaging.Lanczos, hq2x},
+}
+
+func sheetFilterNames() []string {
+	names := make([]string, 0, len(sheetFilters))
+	for name := range sheetFilters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Every cell is rescaled on its own. Scaling the whole sheet would scale the 1
+// pixel padding between cells too and the cells would drift away from the
+// grid that the upscaled TGLP describes.
+func (b *BFFNT) upscaleSheets(original TGLP, fontName string, scale float64, filterName string, overrides string, keepSheet bool) {
+	filter, exists := sheetFilters[filterName]
+	if !exists {
+		handleErr(fmt.Errorf("unknown filter %q", filterName))
+	}
+
+	original.DecodeSheets()
+	dst := alphaBuffers.get(image.Rect(0, 0, int(b.TGLP.SheetWidth), int(b.TGLP.SheetHeight)))
+
+	for glyphIndex := 0; glyphIndex < b.glyphCount(); glyphIndex++ {
+		srcSheet, srcRect := original.CellRect(glyphIndex)
+		_, dstRect := b.TGLP.CellRect(glyphIndex)
+		if srcSheet >= len(original.SheetData) {
+			break
+		}
+
+		scaledCell := filter.rescale(imaging.Crop(&original.SheetData[srcSheet], srcRect), dstRect.Dx(), dstRect.Dy())
+		draw.Draw(dst, dstRect, scaledCell, image.Point{}, draw.Src)
+	}
+
+	if overrides != "" {
+		fmt.Println("replaced", b.drawOverrides(dst, overrides), "glyph(s) with the artwork in", overrides)
+	}
+
+	filename := fmt.Sprintf("%s_00_%.2fx.png", fontName, scale)
+	writePng(filename, dst)
+	if keepSheet {
+		b.TGLP.keepRenderedSheet(dst)
+	}
+	alphaBuffers.put(dst)
+	fmt.Println("wrote rescaled sheet to", filename)
+}
+
+func (filter sheetFilter) rescale(cell *image.NRGBA, width int, height int) *image.NRGBA {
+	if filter.pixelArt != nil {
+		for cell.Bounds().Dx() < width || cell.Bounds().Dy() < height {
+			cell = filter.pixelArt(cell)
+		}
+	}
+
+	return imaging.Resize(cell, width, height, filter.resample)
+}
+
+// Amount of glyphs described by the CWDHs
+func (b *BFFNT) glyphCount() int {
+	count := 0
+	for _, cwdh := range b.CWDHs {
+		count += len(cwdh.Glyphs)
+	}
+
+	return count
+}
+
+// Pixels in hq2x are compared in YUV, they're different when any channel
+// differs by more than its threshold. Alpha is compared like the brightness,
+// sheets are mostly alpha.
+const (
+	HQX_Y_THRESHOLD = 0x30
+	HQX_U_THRESHOLD = 0x07
+	HQX_V_THRESHOLD = 0x06
+)
+
+// A premultiplied pixel, blends of it don't pick up the color of transparent
+// pixels
+type hqxPixel struct {
+	r, g, b, a float64
+}
+
+func (p hqxPixel) yuv() (float64, float64, float64) {
+	return 0.299*p.r + 0.587*p.g + 0.114*p.b,
+		-0.169*p.r - 0.331*p.g + 0.5*p.b,
+		0.5*p.r - 0.419*p.g - 0.081*p.b
+}
+
+func (p hqxPixel) differs(q hqxPixel) bool {
+	py, pu, pv := p.yuv()
+	qy, qu, qv := q.yuv()
+	return math.Abs(py-qy) > HQX_Y_THRESHOLD || math.Abs(pu-qu) > HQX_U_THRESHOLD ||
+		math.Abs(pv-qv) > HQX_V_THRESHOLD || math.Abs(p.a-q.a) > HQX_Y_THRESHOLD
+}
+
+// Weighted average of p and the pixels of others, weights has the weight of p
+// followed by theirs
+func (p hqxPixel) blend(weights []float64, others ...hqxPixel) hqxPixel {
+	sum := weights[0]
+	mixed := hqxPixel{p.r * sum, p.g * sum, p.b * sum, p.a * sum}
+	for i, other := range others {
+		weight := weights[i+1]
+		mixed.r += other.r * weight
+		mixed.g += other.g * weight
+		mixed.b += other.b * weight
+		mixed.a += other.a * weight
+		sum += weight
+	}
+	return hqxPixel{mixed.r / sum, mixed.g / sum, mixed.b / sum, mixed.a / sum}
+}
+
+// The quarter of the doubled pixel p that faces its neighbour corner c.
+// Along the way to c, edge is the neighbour in the same column and side the
+// one in the same row, edgeBehind and sideBehind are the ones opposite of
+// them. Like in hq2x p is blended with the neighbours that are like it, and
+// towards a diagonal edge when the two neighbours next to the corner are the
+// same color but unlike p: 2:1:1, 6:1:1 or 14:1:1 the thinner p's shape is,
+// so lone pixels keep their color.
+func hq2xCorner(p, c, edge, side, edgeBehind, sideBehind hqxPixel) hqxPixel {
+	edgeDiffers, sideDiffers, cornerDiffers := p.differs(edge), p.differs(side), p.differs(c)
+	switch {
+	case !edgeDiffers && !sideDiffers:
+		return p.blend([]float64{2, 1, 1}, edge, side)
+	case edgeDiffers && !sideDiffers:
+		if cornerDiffers {
+			return p.blend([]float64{3, 1}, side)
+		}
+		return p.blend([]float64{2, 1, 1}, c, side)
+	case !edgeDiffers && sideDiffers:
+		if cornerDiffers {
+			return p.blend([]float64{3, 1}, edge)
+		}
+		return p.blend([]float64{2, 1, 1}, c, edge)
+	case edge.differs(side):
+		if cornerDiffers {
+			return p
+		}
+		return p.blend([]float64{3, 1}, c)
+	}
+
+	thin := 0
+	if p.differs(edgeBehind) {
+		thin++
+	}
+	if p.differs(sideBehind) {
+		thin++
+	}
+	return p.blend([]float64{[]float64{2, 6, 14}[thin], 1, 1}, edge, side)
+}
+
+// Maxim Stepin's hq2x, https://en.wikipedia.org/wiki/Hqx. It uses hq2x's
+// thresholds and blends but picks them for each quarter with hq2xCorner
+// instead of hq2x's table of 256 neighbour patterns, so some patterns can be
+// blended a little differently than in the reference implementation.
+func hq2x(src *image.NRGBA) *image.NRGBA {
+	w := src.Bounds().Dx()
+	h := src.Bounds().Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w*2, h*2))
+
+	at := func(x, y int) hqxPixel {
+		if x < 0 {
+			x = 0
+		}
+		if y < 0 {
+			y = 0
+		}
+		if x >= w {
+			x = w - 1
+		}
+		if y >= h {
+			y = h - 1
+		}
+		c := src.NRGBAAt(src.Rect.Min.X+x, src.Rect.Min.Y+y)
+		a := float64(c.A) / 255
+		return hqxPixel{float64(c.R) * a, float64(c.G) * a, float64(c.B) * a, float64(c.A)}
+	}
+	set := func(x, y int, p hqxPixel) {
+		c := color.NRGBA{A: uint8(math.Round(p.a))}
+		if c.A > 0 {
+			a := p.a / 255
+			c.R = uint8(math.Min(255, math.Round(p.r/a)))
+			c.G = uint8(math.Min(255, math.Round(p.g/a)))
+			c.B = uint8(math.Min(255, math.Round(p.b/a)))
+		}
+		dst.SetNRGBA(x, y, c)
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := at(x, y)
+			for _, quarter := range [4][2]int{{-1, -1}, {1, -1}, {-1, 1}, {1, 1}} {
+				dx, dy := quarter[0], quarter[1]
+				corner := hq2xCorner(p, at(x+dx, y+dy), at(x, y+dy), at(x+dx, y), at(x, y-dy), at(x-dx, y))
+				set(2*x+(dx+1)/2, 2*y+(dy+1)/2, corner)
+			}
+		}
+	}
+
+	return dst
+}
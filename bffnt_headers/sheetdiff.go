@@ -0,0 +1,240 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Sheets are compared window by window for SSIM, 8x8 with a stride of 4
+// pixels is plenty for glyphs
+const (
+	SSIM_WINDOW = 8
+	SSIM_STRIDE = 4
+)
+
+// How close two sheets are. Only the alpha channel is compared, that is the
+// only channel the game uses.
+type SheetDifference struct {
+	Sheet         int
+	PSNR          float64 // in dB, +Inf for identical sheets
+	SSIM          float64 // 1 for identical sheets
+	ChangedPixels int
+	Heatmap       *image.NRGBA
+}
+
+func (difference SheetDifference) String() string {
+	return fmt.Sprintf("sheet %d: PSNR %.2f dB, SSIM %.4f, %d pixel(s) differ",
+		difference.Sheet, difference.PSNR, difference.SSIM, difference.ChangedPixels)
+}
+
+// Compares the sheets of two fonts, e.g. Nintendo's original and a
+// regenerated one. Sheets of a different size are resized to the size of the
+// b sheets first, so an original can be compared to its upscaled version.
+// Upscaled fonts have all sheets stacked into one, when the sheet counts don't
+// match both sides are stacked before comparing.
+func CompareSheets(a []image.NRGBA, b []image.NRGBA) []SheetDifference {
+	if len(a) != len(b) {
+		a = []image.NRGBA{*stackSheets(a)}
+		b = []image.NRGBA{*stackSheets(b)}
+	}
+
+	differences := make([]SheetDifference, 0, len(a))
+	for i := range a {
+		sheetA := &a[i]
+		sheetB := &b[i]
+		if sheetA.Rect.Size() != sheetB.Rect.Size() {
+			sheetA = imaging.Resize(sheetA, sheetB.Rect.Dx(), sheetB.Rect.Dy(), imaging.Lanczos)
+		}
+
+		alphaA := alphaChannel(sheetA)
+		alphaB := alphaChannel(sheetB)
+		width := sheetB.Rect.Dx()
+		differences = append(differences, SheetDifference{
+			Sheet:         i,
+			PSNR:          psnr(alphaA, alphaB),
+			SSIM:          ssim(alphaA, alphaB, width),
+			ChangedPixels: changedPixels(alphaA, alphaB),
+			Heatmap:       differenceHeatmap(alphaA, alphaB, width),
+		})
+	}
+
+	return differences
+}
+
+// Stacks the sheets vertically, the same way upscaling lays them out
+func stackSheets(sheets []image.NRGBA) *image.NRGBA {
+	width, height := 0, 0
+	for _, sheet := range sheets {
+		if sheet.Rect.Dx() > width {
+			width = sheet.Rect.Dx()
+		}
+		height += sheet.Rect.Dy()
+	}
+
+	res := image.NewNRGBA(image.Rect(0, 0, width, height))
+	y := 0
+	for i := range sheets {
+		bounds := sheets[i].Rect
+		draw.Draw(res, image.Rect(0, y, bounds.Dx(), y+bounds.Dy()), &sheets[i], bounds.Min, draw.Src)
+		y += bounds.Dy()
+	}
+
+	return res
+}
+
+func alphaChannel(img *image.NRGBA) []float64 {
+	bounds := img.Rect
+	res := make([]float64, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			res = append(res, float64(img.NRGBAAt(x, y).A))
+		}
+	}
+
+	return res
+}
+
+func psnr(a []float64, b []float64) float64 {
+	mse := 0.0
+	for i := range a {
+		mse += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	mse /= float64(len(a))
+	if mse == 0 {
+		return math.Inf(1)
+	}
+
+	return 10 * math.Log10(255*255/mse)
+}
+
+// Mean SSIM over all windows, see Wang et al. 2004 for the constants
+func ssim(a []float64, b []float64, width int) float64 {
+	const c1 = (0.01 * 255) * (0.01 * 255)
+	const c2 = (0.03 * 255) * (0.03 * 255)
+	height := len(a) / width
+
+	total := 0.0
+	windows := 0
+	for y := 0; y+SSIM_WINDOW <= height; y += SSIM_STRIDE {
+		for x := 0; x+SSIM_WINDOW <= width; x += SSIM_STRIDE {
+			var meanA, meanB float64
+			for wy := y; wy < y+SSIM_WINDOW; wy++ {
+				for wx := x; wx < x+SSIM_WINDOW; wx++ {
+					meanA += a[wy*width+wx]
+					meanB += b[wy*width+wx]
+				}
+			}
+			n := float64(SSIM_WINDOW * SSIM_WINDOW)
+			meanA /= n
+			meanB /= n
+
+			var varA, varB, covariance float64
+			for wy := y; wy < y+SSIM_WINDOW; wy++ {
+				for wx := x; wx < x+SSIM_WINDOW; wx++ {
+					da := a[wy*width+wx] - meanA
+					db := b[wy*width+wx] - meanB
+					varA += da * da
+					varB += db * db
+					covariance += da * db
+				}
+			}
+			varA /= n - 1
+			varB /= n - 1
+			covariance /= n - 1
+
+			total += ((2*meanA*meanB + c1) * (2*covariance + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 1
+	}
+
+	return total / float64(windows)
+}
+
+func changedPixels(a []float64, b []float64) int {
+	count := 0
+	for i := range a {
+		if a[i] != b[i] {
+			count++
+		}
+	}
+
+	return count
+}
+
+// The b sheet is drawn in dark grey so the differences can be matched to
+// glyphs. Red is where b has more coverage than a, blue where it has less.
+func differenceHeatmap(a []float64, b []float64, width int) *image.NRGBA {
+	height := len(a) / width
+	res := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			background := uint8(b[i] / 4)
+			pixel := color.NRGBA{background, background, background, 255}
+			if difference := b[i] - a[i]; difference > 0 {
+				pixel.R = uint8(math.Max(difference, float64(background)))
+			} else if difference < 0 {
+				pixel.B = uint8(math.Max(-difference, float64(background)))
+			}
+			res.SetNRGBA(x, y, pixel)
+		}
+	}
+
+	return res
+}
+
+// Sheets come either from a png, e.g. the texture written by an upscale, or
+// are decoded from a bffnt
+func loadSheets(flags *commandFlagSet, filename string) []image.NRGBA {
+	if strings.EqualFold(filepath.Ext(filename), ".png") {
+		img, err := imaging.Open(filename)
+		handleErr(err)
+		return []image.NRGBA{*imaging.Clone(img)}
+	}
+
+	bffnt := flags.readBffnt(filename)
+	bffnt.TGLP.DecodeSheets()
+	return bffnt.TGLP.SheetData
+}
+
+func sheetDiffCommand(args []string) {
+	flags := newCommandFlagSet("sheetdiff", "[flags] a.bffnt|a.png b.bffnt|b.png")
+	outputDir := flags.String("o", ".", "output directory for the heatmaps")
+	heatmap := flags.Bool("heatmap", true, "write a heatmap png of the pixel differences for every sheet")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		exitWithUsage(flags)
+	}
+
+	a := loadSheets(flags, flags.Arg(0))
+	b := loadSheets(flags, flags.Arg(1))
+	if len(a) != len(b) {
+		fmt.Printf("comparing %d sheet(s) to %d sheet(s), both are stacked into one\n", len(a), len(b))
+	}
+
+	name := strings.TrimSuffix(filepath.Base(flags.Arg(1)), filepath.Ext(flags.Arg(1)))
+	if *heatmap {
+		handleErr(os.MkdirAll(*outputDir, 0755))
+	}
+	for _, difference := range CompareSheets(a, b) {
+		fmt.Println(difference)
+		if *heatmap {
+			filename := filepath.Join(*outputDir, fmt.Sprintf("%s_diff_%d.png", name, difference.Sheet))
+			writePng(filename, difference.Heatmap)
+			fmt.Println("  wrote heatmap to", filename)
+		}
+	}
+}
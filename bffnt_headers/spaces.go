@@ -0,0 +1,136 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Spaces have no ink, their CharWidth is all there is to them. It's also what
+// most "text looks cramped/loose" complaints about an upscaled font come down
+// to, so the space widths can be set without knowing their glyph indexes.
+// The no-break space follows the regular space.
+var whitespaceChars = map[string][]rune{
+	"space":       {' ', 0x00A0},
+	"ideographic": {0x3000},
+}
+
+// keep the width the font already has. A space without an advance is of no
+// use so 0 doubles as the default of upscaleOptions.
+const KEEP_SPACE_WIDTH = 0
+
+// The widths a glyph index has, no matter which CWDH it is in
+func (b *BFFNT) glyphInfoAt(index int) (*glyphInfo, bool) {
+	b.loadCWDHs()
+	for i := range b.CWDHs {
+		if index < len(b.CWDHs[i].Glyphs) {
+			return &b.CWDHs[i].Glyphs[index], true
+		}
+		index -= len(b.CWDHs[i].Glyphs)
+	}
+
+	return nil, false
+}
+
+// Sets the advance of every mapped char of a whitespace kind ("space" or
+// "ideographic") to width pixels. Chars sharing a glyph are only counted once.
+// Returns the amount of glyphs changed, fonts without the characters are left
+// alone.
+func (b *BFFNT) SetSpaceWidth(kind string, width int) (int, error) {
+	chars, known := whitespaceChars[kind]
+	if !known {
+		return 0, fmt.Errorf("unknown whitespace %q", kind)
+	}
+	if width < 0 || width > MAX_GLYPH_WIDTH {
+		return 0, fmt.Errorf("%s width %d is out of range, it has to be between 0 and %d", kind, width, MAX_GLYPH_WIDTH)
+	}
+
+	changed := make(map[uint16]bool)
+	for _, char := range chars {
+		index, found := b.CharIndex(char)
+		if !found || changed[index] {
+			continue
+		}
+		glyph, found := b.glyphInfoAt(int(index))
+		if !found {
+			return len(changed), fmt.Errorf("%s is mapped to glyph %d which has no widths", formatChar(uint16(char)), index)
+		}
+		glyph.CharWidth = uint8(width)
+		changed[index] = true
+	}
+
+	return len(changed), nil
+}
+
+// The advance of a whitespace kind's first mapped char
+func (b *BFFNT) spaceWidth(kind string) (int, bool) {
+	for _, char := range whitespaceChars[kind] {
+		index, found := b.CharIndex(char)
+		if !found {
+			continue
+		}
+		if glyph, found := b.glyphInfoAt(int(index)); found {
+			return int(glyph.CharWidth), true
+		}
+	}
+
+	return 0, false
+}
+
+// Applies the space widths of an upscale run, KEEP_SPACE_WIDTH leaves one
+// alone
+func (b *BFFNT) applySpaceWidths(spaceWidth int, ideographicWidth int) {
+	for _, setting := range []struct {
+		kind  string
+		width int
+	}{{"space", spaceWidth}, {"ideographic", ideographicWidth}} {
+		if setting.width == KEEP_SPACE_WIDTH {
+			continue
+		}
+		changed, err := b.SetSpaceWidth(setting.kind, setting.width)
+		handleErr(err)
+		if changed == 0 {
+			fmt.Printf("warning: the font has no %s character, its width isn't set\n", setting.kind)
+			continue
+		}
+		fmt.Printf("set the %s width to %d\n", setting.kind, setting.width)
+	}
+}
+
+// Shows or sets the space widths of an existing bffnt. Nothing else is
+// touched so the sheets are copied as they are.
+func spacesCommand(args []string) {
+	flags := newCommandFlagSet("spaces", "[flags] font.bffnt")
+	spaceWidth := flags.Int("space", KEEP_SPACE_WIDTH, "advance of the space and no-break space in pixels. 0 keeps it")
+	ideographicWidth := flags.Int("ideographic", KEEP_SPACE_WIDTH, "advance of the ideographic (full width) space in pixels. 0 keeps it")
+	output := flags.String("o", "", "output file. Defaults to <name>_spaces.bffnt next to the input")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		exitWithUsage(flags)
+	}
+
+	bffntFile := flags.Arg(0)
+	bffnt := flags.readBffnt(bffntFile)
+	for _, kind := range []string{"space", "ideographic"} {
+		if width, found := bffnt.spaceWidth(kind); found {
+			fmt.Printf("%s width: %d\n", kind, width)
+		} else {
+			fmt.Printf("%s width: not mapped\n", kind)
+		}
+	}
+	if *spaceWidth == KEEP_SPACE_WIDTH && *ideographicWidth == KEEP_SPACE_WIDTH {
+		return
+	}
+
+	bffnt.applySpaceWidths(*spaceWidth, *ideographicWidth)
+	if *output == "" {
+		name := strings.TrimSuffix(bffntFile, filepath.Ext(bffntFile))
+		*output = name + "_spaces" + filepath.Ext(bffntFile)
+	}
+	encoded := bffnt.Encode()
+	handleErr(bffnt.VerifyEncoded(encoded))
+	handleErr(os.WriteFile(*output, encoded, 0644))
+	fmt.Println("wrote", *output)
+}
@@ -0,0 +1,131 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// Nintendo hand tuned the spacing of some glyphs, e.g. punctuation that hugs
+// the previous character. Their CWDH doesn't follow from the ink in the sheet
+// so auto fitting them to a replacement font would undo the tuning.
+type spacingOutlier struct {
+	index        int
+	leftBearing  int // blank pixels before the ink, LeftWidth plus blank columns in the cell
+	rightBearing int // blank pixels after the ink up to the advance
+	reason       string
+}
+
+// Finds the glyphs whose spacing deviates from the rest of the font. The
+// bearings of every glyph are measured from the ink in the original sheets, see
+// measureInkWidths, and compared to the median bearings, anything more than
+// tolerance pixels away is treated as custom spacing. The sheets have to be
+// decoded already.
+func findSpacingOutliers(tglp *TGLP, glyphs []glyphInfo) map[int]spacingOutlier {
+	tolerance := int(math.Max(2, float64(tglp.CellHeight)/10))
+	measured := make([]spacingOutlier, 0, len(glyphs))
+	for _, ink := range measureInkWidths(tglp, glyphs) {
+		if !ink.hasInk {
+			continue
+		}
+
+		glyph := ink.glyph
+		outlier := spacingOutlier{
+			index:        ink.index,
+			leftBearing:  int(glyph.LeftWidth) + ink.left,
+			rightBearing: int(glyph.CharWidth) - int(glyph.LeftWidth) - ink.left - ink.width,
+		}
+		if difference := ink.left + ink.width - int(glyph.GlyphWidth); difference > tolerance {
+			outlier.reason = fmt.Sprintf("ink is %d pixels wider than the glyph width %d", difference, glyph.GlyphWidth)
+		}
+		measured = append(measured, outlier)
+	}
+
+	leftMedian := medianBearing(measured, func(o spacingOutlier) int { return o.leftBearing })
+	rightMedian := medianBearing(measured, func(o spacingOutlier) int { return o.rightBearing })
+	outliers := make(map[int]spacingOutlier)
+	for _, outlier := range measured {
+		if outlier.reason == "" && abs(outlier.leftBearing-leftMedian) > tolerance {
+			outlier.reason = fmt.Sprintf("left bearing %d, most glyphs have %d", outlier.leftBearing, leftMedian)
+		}
+		if outlier.reason == "" && abs(outlier.rightBearing-rightMedian) > tolerance {
+			outlier.reason = fmt.Sprintf("right bearing %d, most glyphs have %d", outlier.rightBearing, rightMedian)
+		}
+		if outlier.reason != "" {
+			outliers[outlier.index] = outlier
+		}
+	}
+
+	return outliers
+}
+
+// First column with ink in the cell and how many columns the ink spans
+func inkColumns(sheet *image.NRGBA, cell image.Rectangle) (left int, width int, hasInk bool) {
+	return inkColumnsAbove(sheet, cell, 1)
+}
+
+// Same as inkColumns, only pixels with at least minAlpha count as ink
+func inkColumnsAbove(sheet *image.NRGBA, cell image.Rectangle, minAlpha uint8) (left int, width int, hasInk bool) {
+	first, last := -1, -1
+	for x := cell.Min.X; x < cell.Max.X; x++ {
+		for y := cell.Min.Y; y < cell.Max.Y; y++ {
+			if sheet.NRGBAAt(x, y).A >= minAlpha {
+				if first < 0 {
+					first = x
+				}
+				last = x
+				break
+			}
+		}
+	}
+	if first < 0 {
+		return 0, 0, false
+	}
+
+	return first - cell.Min.X, last - first + 1, true
+}
+
+func medianBearing(outliers []spacingOutlier, bearing func(spacingOutlier) int) int {
+	if len(outliers) == 0 {
+		return 0
+	}
+	values := make([]int, 0, len(outliers))
+	for _, outlier := range outliers {
+		values = append(values, bearing(outlier))
+	}
+	sort.Ints(values)
+
+	return values[len(values)/2]
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Measures the original font's spacing before anything gets scaled. Fonts with
+// sheets we can't decode have no outliers, auto fit then treats every glyph the
+// same.
+func (b *BFFNT) customSpacing() map[int]spacingOutlier {
+	if b.TGLP.SheetImageFormat != IMAGE_FORMAT_A8 && b.TGLP.SheetImageFormat != IMAGE_FORMAT_BC4 {
+		fmt.Printf("sheet image format %d can not be decoded, custom spacing is not detected\n", b.TGLP.SheetImageFormat)
+		return map[int]spacingOutlier{}
+	}
+
+	tglp := b.TGLP
+	tglp.DecodeSheets()
+	return findSpacingOutliers(&tglp, b.allGlyphInfo())
+}
+
+func sortedOutliers(outliers map[int]spacingOutlier) []spacingOutlier {
+	res := make([]spacingOutlier, 0, len(outliers))
+	for _, outlier := range outliers {
+		res = append(res, outlier)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].index < res[j].index })
+
+	return res
+}
@@ -0,0 +1,773 @@
+package bffnt_headers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/vector"
+)
+
+// Icons like BotW's button symbols rarely exist in a font file but often as
+// SVG. This draws the filled shapes of an SVG: path, rect, circle, ellipse,
+// polygon and polyline, in g elements with transforms, with the nonzero or
+// evenodd fill rule. Gradients are drawn solid. Strokes, text and clones (use
+// and symbol) are an error rather than silently missing from the glyph, they
+// have to be converted to paths first. Glyphs only need the alpha channel so
+// every fill is opaque.
+
+// Affine transform mapping (x, y) to (a*x + c*y + e, b*x + d*y + f) like the
+// SVG matrix()
+type svgMatrix [6]float64
+
+var svgIdentity = svgMatrix{1, 0, 0, 1, 0, 0}
+
+func (m svgMatrix) apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]
+}
+
+// m after n: points go through n first
+func (m svgMatrix) multiply(n svgMatrix) svgMatrix {
+	return svgMatrix{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func parseSVGNumbers(s string) ([]float64, error) {
+	var numbers []float64
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r' }) {
+		n, err := strconv.ParseFloat(strings.TrimSuffix(field, "px"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("svg: %q isn't a number", field)
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, nil
+}
+
+func parseSVGTransform(s string) (svgMatrix, error) {
+	m := svgIdentity
+	for _, part := range strings.Split(s, ")") {
+		part = strings.TrimLeft(strings.TrimSpace(part), ",")
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		open := strings.Index(part, "(")
+		if open < 0 {
+			return m, fmt.Errorf("svg: transform %q can't be read", s)
+		}
+		name := strings.TrimSpace(part[:open])
+		args, err := parseSVGNumbers(part[open+1:])
+		if err != nil {
+			return m, err
+		}
+		arg := func(i int, fallback float64) float64 {
+			if i < len(args) {
+				return args[i]
+			}
+			return fallback
+		}
+
+		var t svgMatrix
+		switch name {
+		case "matrix":
+			if len(args) != 6 {
+				return m, fmt.Errorf("svg: matrix needs 6 numbers, got %q", part)
+			}
+			copy(t[:], args)
+		case "translate":
+			t = svgMatrix{1, 0, 0, 1, arg(0, 0), arg(1, 0)}
+		case "scale":
+			t = svgMatrix{arg(0, 1), 0, 0, arg(1, arg(0, 1)), 0, 0}
+		case "rotate":
+			angle := arg(0, 0) * math.Pi / 180
+			cx, cy := arg(1, 0), arg(2, 0)
+			sin, cos := math.Sin(angle), math.Cos(angle)
+			t = svgMatrix{1, 0, 0, 1, cx, cy}.multiply(svgMatrix{cos, sin, -sin, cos, 0, 0}).multiply(svgMatrix{1, 0, 0, 1, -cx, -cy})
+		case "skewX":
+			t = svgMatrix{1, 0, math.Tan(arg(0, 0) * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			t = svgMatrix{1, math.Tan(arg(0, 0) * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			return m, fmt.Errorf("svg: unknown transform %q", name)
+		}
+		m = m.multiply(t)
+	}
+	return m, nil
+}
+
+// Fills outlines into the glyph, by the nonzero rule or the evenodd one
+type svgRasterizer interface {
+	MoveTo(ax, ay float32)
+	LineTo(bx, by float32)
+	QuadTo(bx, by, cx, cy float32)
+	CubeTo(bx, by, cx, cy, dx, dy float32)
+	ClosePath()
+	fill(dst *image.Alpha)
+}
+
+func newSVGRasterizer(evenOdd bool, width int, height int) svgRasterizer {
+	if evenOdd {
+		return &evenOddRasterizer{width: width, height: height}
+	}
+	rast := vector.NewRasterizer(width, height)
+	rast.DrawOp = draw.Over
+	return nonZeroRasterizer{rast}
+}
+
+type nonZeroRasterizer struct {
+	*vector.Rasterizer
+}
+
+func (rast nonZeroRasterizer) fill(dst *image.Alpha) {
+	rast.Draw(dst, dst.Bounds(), image.Opaque, image.Point{})
+}
+
+// Sub-scanlines per pixel row of an evenodd fill
+const SVG_EVENODD_SAMPLES = 16
+
+// The vector rasterizer only knows the nonzero rule. Evenodd outlines are
+// flattened into lines and filled by counting the edges each of
+// SVG_EVENODD_SAMPLES scanlines per pixel row crosses, a pixel is covered by
+// as much of it as lies between odd and even crossings.
+type evenOddRasterizer struct {
+	width, height  int
+	edges          [][4]float64 // x0, y0, x1, y1, horizontal ones are left out
+	x, y           float64
+	startX, startY float64
+}
+
+func (rast *evenOddRasterizer) MoveTo(ax, ay float32) {
+	rast.ClosePath()
+	rast.x, rast.y = float64(ax), float64(ay)
+	rast.startX, rast.startY = rast.x, rast.y
+}
+
+func (rast *evenOddRasterizer) LineTo(bx, by float32) {
+	rast.line(float64(bx), float64(by))
+}
+
+func (rast *evenOddRasterizer) line(x, y float64) {
+	if y != rast.y {
+		rast.edges = append(rast.edges, [4]float64{rast.x, rast.y, x, y})
+	}
+	rast.x, rast.y = x, y
+}
+
+// Curves are split into lines no more than about a tenth of a pixel off
+func (rast *evenOddRasterizer) QuadTo(bx, by, cx, cy float32) {
+	x0, y0 := rast.x, rast.y
+	x1, y1, x2, y2 := float64(bx), float64(by), float64(cx), float64(cy)
+	deviation := math.Hypot(x0-2*x1+x2, y0-2*y1+y2)
+	segments := int(math.Ceil(math.Sqrt(deviation * 1.25)))
+	for i := 1; i <= segments; i++ {
+		t := float64(i) / float64(segments)
+		u := 1 - t
+		rast.line(u*u*x0+2*u*t*x1+t*t*x2, u*u*y0+2*u*t*y1+t*t*y2)
+	}
+	rast.line(x2, y2)
+}
+
+func (rast *evenOddRasterizer) CubeTo(bx, by, cx, cy, dx, dy float32) {
+	x0, y0 := rast.x, rast.y
+	x1, y1, x2, y2, x3, y3 := float64(bx), float64(by), float64(cx), float64(cy), float64(dx), float64(dy)
+	deviation := math.Max(math.Hypot(x0-2*x1+x2, y0-2*y1+y2), math.Hypot(x1-2*x2+x3, y1-2*y2+y3))
+	segments := int(math.Ceil(math.Sqrt(deviation * 7.5)))
+	for i := 1; i <= segments; i++ {
+		t := float64(i) / float64(segments)
+		u := 1 - t
+		rast.line(u*u*u*x0+3*u*u*t*x1+3*u*t*t*x2+t*t*t*x3, u*u*u*y0+3*u*u*t*y1+3*u*t*t*y2+t*t*t*y3)
+	}
+	rast.line(x3, y3)
+}
+
+// Subpaths are closed for filling whether they were closed or not
+func (rast *evenOddRasterizer) ClosePath() {
+	rast.line(rast.startX, rast.startY)
+}
+
+func (rast *evenOddRasterizer) fill(dst *image.Alpha) {
+	rast.ClosePath()
+	coverage := make([]float64, rast.width)
+	var crossings []float64
+	for py := 0; py < rast.height; py++ {
+		for i := range coverage {
+			coverage[i] = 0
+		}
+		for sample := 0; sample < SVG_EVENODD_SAMPLES; sample++ {
+			y := float64(py) + (float64(sample)+0.5)/SVG_EVENODD_SAMPLES
+			crossings = crossings[:0]
+			for _, edge := range rast.edges {
+				// half open, an edge's end is crossed by it or the next edge,
+				// not both
+				if (edge[1] <= y) != (edge[3] <= y) {
+					crossings = append(crossings, edge[0]+(y-edge[1])*(edge[2]-edge[0])/(edge[3]-edge[1]))
+				}
+			}
+			sort.Float64s(crossings)
+			for i := 0; i+1 < len(crossings); i += 2 {
+				addSpanCoverage(coverage, crossings[i], crossings[i+1])
+			}
+		}
+
+		for px, covered := range coverage {
+			if covered <= 0 {
+				continue
+			}
+			alpha := math.Min(covered/SVG_EVENODD_SAMPLES, 1)
+			offset := dst.PixOffset(px, py)
+			below := float64(dst.Pix[offset]) / 255
+			dst.Pix[offset] = uint8(math.Round((alpha + below*(1-alpha)) * 255))
+		}
+	}
+}
+
+// Adds how much of each pixel the span from x0 to x1 covers
+func addSpanCoverage(coverage []float64, x0 float64, x1 float64) {
+	x0 = math.Max(x0, 0)
+	x1 = math.Min(x1, float64(len(coverage)))
+	for x0 < x1 {
+		px := int(x0)
+		end := math.Min(x1, float64(px+1))
+		coverage[px] += end - x0
+		x0 = end
+	}
+}
+
+// Outline of a shape, already transformed to pixels
+type svgPath struct {
+	m      svgMatrix
+	rast   svgRasterizer
+	x, y   float64 // current point in user space
+	startX float64 // start of the current subpath
+	startY float64
+	open   bool
+}
+
+func (p *svgPath) moveTo(x, y float64) {
+	p.closePath()
+	p.x, p.y, p.startX, p.startY = x, y, x, y
+	tx, ty := p.m.apply(x, y)
+	p.rast.MoveTo(float32(tx), float32(ty))
+	p.open = true
+}
+
+func (p *svgPath) lineTo(x, y float64) {
+	p.x, p.y = x, y
+	tx, ty := p.m.apply(x, y)
+	p.rast.LineTo(float32(tx), float32(ty))
+}
+
+func (p *svgPath) cubeTo(x1, y1, x2, y2, x, y float64) {
+	p.x, p.y = x, y
+	tx1, ty1 := p.m.apply(x1, y1)
+	tx2, ty2 := p.m.apply(x2, y2)
+	tx, ty := p.m.apply(x, y)
+	p.rast.CubeTo(float32(tx1), float32(ty1), float32(tx2), float32(ty2), float32(tx), float32(ty))
+}
+
+func (p *svgPath) quadTo(x1, y1, x, y float64) {
+	p.x, p.y = x, y
+	tx1, ty1 := p.m.apply(x1, y1)
+	tx, ty := p.m.apply(x, y)
+	p.rast.QuadTo(float32(tx1), float32(ty1), float32(tx), float32(ty))
+}
+
+func (p *svgPath) closePath() {
+	if p.open {
+		p.rast.ClosePath()
+		p.x, p.y = p.startX, p.startY
+		p.open = false
+	}
+}
+
+// Elliptical arc from the current point, split into cubics of at most 90°.
+// https://www.w3.org/TR/SVG/implnote.html#ArcImplementationNotes
+func (p *svgPath) arcTo(rx, ry, rotation float64, largeArc, sweep bool, x, y float64) {
+	x0, y0 := p.x, p.y
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || (x0 == x && y0 == y) {
+		p.lineTo(x, y)
+		return
+	}
+
+	phi := rotation * math.Pi / 180
+	sin, cos := math.Sin(phi), math.Cos(phi)
+	dx, dy := (x0-x)/2, (y0-y)/2
+	x1 := cos*dx + sin*dy
+	y1 := -sin*dx + cos*dy
+	if lambda := x1*x1/(rx*rx) + y1*y1/(ry*ry); lambda > 1 {
+		rx, ry = rx*math.Sqrt(lambda), ry*math.Sqrt(lambda)
+	}
+	numerator := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	factor := math.Sqrt(math.Max(0, numerator) / (rx*rx*y1*y1 + ry*ry*x1*x1))
+	if largeArc == sweep {
+		factor = -factor
+	}
+	cx1, cy1 := factor*rx*y1/ry, -factor*ry*x1/rx
+	cx := cos*cx1 - sin*cy1 + (x0+x)/2
+	cy := sin*cx1 + cos*cy1 + (y0+y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	theta := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	point := func(t float64) (float64, float64) {
+		ex, ey := rx*math.Cos(t), ry*math.Sin(t)
+		return cos*ex - sin*ey + cx, sin*ex + cos*ey + cy
+	}
+	derivative := func(t float64) (float64, float64) {
+		ex, ey := -rx*math.Sin(t), ry*math.Cos(t)
+		return cos*ex - sin*ey, sin*ex + cos*ey
+	}
+	segments := int(math.Ceil(math.Abs(delta) / (math.Pi / 2)))
+	step := delta / float64(segments)
+	k := 4.0 / 3 * math.Tan(step/4)
+	for i := 0; i < segments; i++ {
+		t0, t1 := theta+float64(i)*step, theta+float64(i+1)*step
+		px0, py0 := point(t0)
+		px1, py1 := point(t1)
+		dx0, dy0 := derivative(t0)
+		dx1, dy1 := derivative(t1)
+		p.cubeTo(px0+k*dx0, py0+k*dy0, px1-k*dx1, py1-k*dy1, px1, py1)
+	}
+	p.x, p.y = x, y
+}
+
+// Reads the numbers and flags of path data
+type svgPathScanner struct {
+	d   string
+	pos int
+}
+
+func (s *svgPathScanner) skipSeparators() {
+	for s.pos < len(s.d) && strings.ContainsRune(" \t\r\n,", rune(s.d[s.pos])) {
+		s.pos++
+	}
+}
+
+func (s *svgPathScanner) command() (byte, bool) {
+	s.skipSeparators()
+	if s.pos < len(s.d) && strings.ContainsRune("MmLlHhVvCcSsQqTtAaZz", rune(s.d[s.pos])) {
+		s.pos++
+		return s.d[s.pos-1], true
+	}
+	return 0, false
+}
+
+func (s *svgPathScanner) hasNumber() bool {
+	s.skipSeparators()
+	return s.pos < len(s.d) && strings.ContainsRune("+-.0123456789", rune(s.d[s.pos]))
+}
+
+func (s *svgPathScanner) number() (float64, error) {
+	s.skipSeparators()
+	start := s.pos
+	if s.pos < len(s.d) && (s.d[s.pos] == '+' || s.d[s.pos] == '-') {
+		s.pos++
+	}
+	dot, exponent := false, false
+	for s.pos < len(s.d) {
+		c := s.d[s.pos]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' && !dot && !exponent:
+			dot = true
+		case (c == 'e' || c == 'E') && !exponent && s.pos > start:
+			exponent = true
+			if s.pos+1 < len(s.d) && (s.d[s.pos+1] == '+' || s.d[s.pos+1] == '-') {
+				s.pos++
+			}
+		default:
+			return s.parse(start)
+		}
+		s.pos++
+	}
+	return s.parse(start)
+}
+
+func (s *svgPathScanner) parse(start int) (float64, error) {
+	n, err := strconv.ParseFloat(s.d[start:s.pos], 64)
+	if err != nil {
+		return 0, fmt.Errorf("svg: path data %q has no number at %d", s.d, start)
+	}
+	return n, nil
+}
+
+// Arc flags are a single 0 or 1 that may be followed by the next number
+// without a separator
+func (s *svgPathScanner) flag() (bool, error) {
+	s.skipSeparators()
+	if s.pos < len(s.d) && (s.d[s.pos] == '0' || s.d[s.pos] == '1') {
+		s.pos++
+		return s.d[s.pos-1] == '1', nil
+	}
+	return false, fmt.Errorf("svg: path data %q has no arc flag at %d", s.d, s.pos)
+}
+
+func (p *svgPath) drawPathData(d string) error {
+	s := &svgPathScanner{d: d}
+	var command byte
+	// control point of the last curve for the smooth S and T commands
+	var lastControlX, lastControlY float64
+	var lastCommand byte
+
+	for {
+		if next, isCommand := s.command(); isCommand {
+			command = next
+		} else if !s.hasNumber() {
+			s.skipSeparators()
+			if s.pos < len(s.d) {
+				return fmt.Errorf("svg: path data %q can't be read at %d", d, s.pos)
+			}
+			p.closePath()
+			return nil
+		} else if command == 0 || command&^0x20 == 'Z' {
+			return fmt.Errorf("svg: path data %q has numbers without a command at %d", d, s.pos)
+		}
+
+		relative := command >= 'a'
+		ox, oy := 0.0, 0.0
+		if relative {
+			ox, oy = p.x, p.y
+		}
+		numbers := func(count int) ([]float64, error) {
+			values := make([]float64, count)
+			for i := range values {
+				n, err := s.number()
+				if err != nil {
+					return nil, err
+				}
+				values[i] = n
+			}
+			return values, nil
+		}
+
+		upper := command &^ 0x20
+		var values []float64
+		var err error
+		switch upper {
+		case 'M', 'L', 'T':
+			values, err = numbers(2)
+		case 'H', 'V':
+			values, err = numbers(1)
+		case 'C':
+			values, err = numbers(6)
+		case 'S', 'Q':
+			values, err = numbers(4)
+		case 'A':
+			values, err = numbers(3)
+			if err == nil {
+				var largeArc, sweep bool
+				if largeArc, err = s.flag(); err == nil {
+					if sweep, err = s.flag(); err == nil {
+						var end []float64
+						if end, err = numbers(2); err == nil {
+							p.arcTo(values[0], values[1], values[2], largeArc, sweep, end[0]+ox, end[1]+oy)
+						}
+					}
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		// reflection of the last control point, or the current point
+		smoothX, smoothY := p.x, p.y
+		switch upper {
+		case 'M':
+			p.moveTo(values[0]+ox, values[1]+oy)
+			// more pairs after a move are lines
+			if relative {
+				command = 'l'
+			} else {
+				command = 'L'
+			}
+		case 'L':
+			p.lineTo(values[0]+ox, values[1]+oy)
+		case 'H':
+			p.lineTo(values[0]+ox, p.y)
+		case 'V':
+			p.lineTo(p.x, values[0]+oy)
+		case 'C':
+			lastControlX, lastControlY = values[2]+ox, values[3]+oy
+			p.cubeTo(values[0]+ox, values[1]+oy, lastControlX, lastControlY, values[4]+ox, values[5]+oy)
+		case 'S':
+			if lastCommand == 'C' || lastCommand == 'S' {
+				smoothX, smoothY = 2*p.x-lastControlX, 2*p.y-lastControlY
+			}
+			lastControlX, lastControlY = values[0]+ox, values[1]+oy
+			p.cubeTo(smoothX, smoothY, lastControlX, lastControlY, values[2]+ox, values[3]+oy)
+		case 'Q':
+			lastControlX, lastControlY = values[0]+ox, values[1]+oy
+			p.quadTo(lastControlX, lastControlY, values[2]+ox, values[3]+oy)
+		case 'T':
+			if lastCommand == 'Q' || lastCommand == 'T' {
+				smoothX, smoothY = 2*p.x-lastControlX, 2*p.y-lastControlY
+			}
+			lastControlX, lastControlY = smoothX, smoothY
+			p.quadTo(smoothX, smoothY, values[0]+ox, values[1]+oy)
+		case 'Z':
+			p.closePath()
+		}
+		lastCommand = upper
+	}
+}
+
+// The attributes of a shape, with the ones it inherits from its g elements
+type svgState struct {
+	m       svgMatrix
+	filled  bool
+	evenOdd bool // fill-rule="evenodd"
+	stroked bool
+}
+
+// fill="none" turns filling off, fill-rule picks the rule and a stroke that
+// isn't none is an error when a shape is drawn with it. The properties can
+// also be in a style.
+func (state svgState) inherit(attrs []xml.Attr) (svgState, error) {
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "transform":
+			t, err := parseSVGTransform(attr.Value)
+			if err != nil {
+				return state, err
+			}
+			state.m = state.m.multiply(t)
+		case "style":
+			for _, declaration := range strings.Split(attr.Value, ";") {
+				property := strings.SplitN(declaration, ":", 2)
+				if len(property) == 2 {
+					if err := state.setProperty(strings.TrimSpace(property[0]), property[1]); err != nil {
+						return state, err
+					}
+				}
+			}
+		default:
+			if err := state.setProperty(attr.Name.Local, attr.Value); err != nil {
+				return state, err
+			}
+		}
+	}
+	return state, nil
+}
+
+func (state *svgState) setProperty(name string, value string) error {
+	value = strings.TrimSpace(value)
+	switch name {
+	case "fill":
+		state.filled = value != "none"
+	case "fill-rule":
+		switch value {
+		case "nonzero":
+			state.evenOdd = false
+		case "evenodd":
+			state.evenOdd = true
+		case "inherit":
+		default:
+			return fmt.Errorf("svg: unknown fill-rule %q", value)
+		}
+	case "stroke":
+		state.stroked = value != "none" && value != ""
+	}
+	return nil
+}
+
+func svgAttr(attrs []xml.Attr, name string) string {
+	for _, attr := range attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func svgLength(attrs []xml.Attr, name string) (float64, error) {
+	value := strings.TrimSpace(svgAttr(attrs, name))
+	if value == "" {
+		return 0, nil
+	}
+	numbers, err := parseSVGNumbers(value)
+	if err != nil || len(numbers) != 1 {
+		return 0, fmt.Errorf("svg: %s=%q isn't a length in pixels", name, value)
+	}
+	return numbers[0], nil
+}
+
+func svgLengths(attrs []xml.Attr, names ...string) ([]float64, error) {
+	values := make([]float64, len(names))
+	for i, name := range names {
+		value, err := svgLength(attrs, name)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// Outline of a shape element, false if the element isn't a shape
+func (p *svgPath) drawShape(element xml.StartElement) (bool, error) {
+	attrs := element.Attr
+	switch element.Name.Local {
+	case "path":
+		return true, p.drawPathData(svgAttr(attrs, "d"))
+	case "rect":
+		v, err := svgLengths(attrs, "x", "y", "width", "height")
+		if err != nil {
+			return true, err
+		}
+		x, y, w, h := v[0], v[1], v[2], v[3]
+		p.moveTo(x, y)
+		p.lineTo(x+w, y)
+		p.lineTo(x+w, y+h)
+		p.lineTo(x, y+h)
+		p.closePath()
+	case "circle", "ellipse":
+		v, err := svgLengths(attrs, "cx", "cy", "r", "rx", "ry")
+		if err != nil {
+			return true, err
+		}
+		cx, cy, rx, ry := v[0], v[1], v[3], v[4]
+		if element.Name.Local == "circle" {
+			rx, ry = v[2], v[2]
+		}
+		p.moveTo(cx+rx, cy)
+		p.arcTo(rx, ry, 0, false, true, cx-rx, cy)
+		p.arcTo(rx, ry, 0, false, true, cx+rx, cy)
+		p.closePath()
+	case "polygon", "polyline":
+		points, err := parseSVGNumbers(svgAttr(attrs, "points"))
+		if err != nil {
+			return true, err
+		}
+		for i := 0; i+1 < len(points); i += 2 {
+			if i == 0 {
+				p.moveTo(points[i], points[i+1])
+			} else {
+				p.lineTo(points[i], points[i+1])
+			}
+		}
+		p.closePath()
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// Elements that would draw something that isn't, with how to turn it into
+// paths in Inkscape
+var svgUnsupported = map[string]string{
+	"use":    "Unlink the clones first, Edit > Clone > Unlink Clone",
+	"symbol": "Unlink the clones of the symbol first, Edit > Clone > Unlink Clone",
+	"text":   "Convert the text to paths first, Path > Object to Path",
+}
+
+// Draws an SVG scaled so its view box is height pixels tall. The image is as
+// wide as the view box at that scale.
+func rasterizeSVG(r io.Reader, height int) (*image.Alpha, error) {
+	decoder := xml.NewDecoder(r)
+	var dst *image.Alpha
+	var stack []svgState
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("svg: %v", err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if dst == nil {
+				if token.Name.Local != "svg" {
+					return nil, fmt.Errorf("svg: the document starts with <%s>, not <svg>", token.Name.Local)
+				}
+				viewBox, err := parseSVGNumbers(svgAttr(token.Attr, "viewBox"))
+				if err != nil {
+					return nil, err
+				}
+				if len(viewBox) != 4 {
+					size, err := svgLengths(token.Attr, "width", "height")
+					if err != nil {
+						return nil, err
+					}
+					viewBox = []float64{0, 0, size[0], size[1]}
+				}
+				if viewBox[2] <= 0 || viewBox[3] <= 0 {
+					return nil, fmt.Errorf("svg: needs a viewBox or a width and height")
+				}
+				scale := float64(height) / viewBox[3]
+				dst = image.NewAlpha(image.Rect(0, 0, int(math.Ceil(viewBox[2]*scale)), height))
+				root := svgState{m: svgMatrix{scale, 0, 0, scale, -viewBox[0] * scale, -viewBox[1] * scale}, filled: true}
+				state, err := root.inherit(token.Attr)
+				if err != nil {
+					return nil, err
+				}
+				stack = append(stack, state)
+				continue
+			}
+
+			state, err := stack[len(stack)-1].inherit(token.Attr)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, state)
+			// definitions are only drawn where they're used, which isn't
+			// supported
+			if token.Name.Local == "defs" || token.Name.Local == "clipPath" || token.Name.Local == "mask" {
+				if err := decoder.Skip(); err != nil {
+					return nil, fmt.Errorf("svg: %v", err)
+				}
+				stack = stack[:len(stack)-1]
+				continue
+			}
+
+			if advice, unsupported := svgUnsupported[token.Name.Local]; unsupported {
+				return nil, fmt.Errorf("svg: <%s> isn't drawn. %s", token.Name.Local, advice)
+			}
+
+			rast := newSVGRasterizer(state.evenOdd, dst.Rect.Dx(), dst.Rect.Dy())
+			path := &svgPath{m: state.m, rast: rast}
+			shape, err := path.drawShape(token)
+			if err != nil {
+				return nil, err
+			}
+			if shape && state.stroked {
+				return nil, fmt.Errorf("svg: <%s> has a stroke, strokes aren't drawn. Convert them to paths first, e.g. with Inkscape's Stroke to Path", token.Name.Local)
+			}
+			if shape && state.filled {
+				rast.fill(dst)
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	if dst == nil {
+		return nil, fmt.Errorf("svg: no <svg> element")
+	}
+	return dst, nil
+}
@@ -0,0 +1,262 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Switch fonts are laid out like the Wii U ones but little endian, and their
+// sheets are swizzled into the Tegra X1's block linear layout instead of
+// GX2's tiling. A Switch font is made from the encoded Wii U font: the sheets
+// are untiled and swizzled again and every field of the known sections is
+// byte swapped. Bigger or smaller sheets move the sections after TGLP, their
+// offsets are moved along.
+//
+// There's no Switch font in the repo to compare against, the conversion is
+// only checked against itself, see TestSwitchFont. The version, the image
+// format numbers and the upside down sheets are kept as they are on the Wii U.
+
+// Atmosphère loads files from atmosphere/contents/<title id>/romfs on the SD
+// card in place of the game's own, the romfs is laid out like the Wii U's
+// content folder.
+const ATMOSPHERE_CONTENTS_DIR = "atmosphere/contents"
+
+// Block linear surfaces are made of GOBs (groups of bytes), 64 bytes by 8
+// rows each, stacked into blocks of up to 16 GOBs
+const (
+	GOB_WIDTH          = 64
+	GOB_HEIGHT         = 8
+	GOB_SIZE           = GOB_WIDTH * GOB_HEIGHT
+	MAX_GOBS_PER_BLOCK = 16
+)
+
+// Where the font goes in a LayeredFS mod for the game with titleID
+func (destination fontDestination) onSwitch(titleID string) fontDestination {
+	romfsPath := strings.TrimPrefix(destination.archive, "content/")
+	destination.archive = path.Join(ATMOSPHERE_CONTENTS_DIR, titleID, "romfs", romfsPath)
+	return destination
+}
+
+// Writes the font into an Atmosphère LayeredFS mod, laid out like the SD card
+// so the contents of modDir can be copied straight onto it. The font is
+// converted to the Switch, sbfarcFile has to be the Switch's archive. The
+// conversion is experimental and says so every time, see encodeSwitchFont.
+func writeAtmosphereMod(modDir string, sbfarcFile string, titleID string, destination fontDestination, encoded []byte) {
+	fmt.Println("warning: -atmosphere is experimental. The Switch font hasn't been checked against one dumped from a Switch, its version, image format and upside down sheets are kept from the Wii U. Try the mod on a Switch before sharing it")
+	switchFont, err := encodeSwitchFont(encoded)
+	handleErr(err)
+	replaceFontInArchive(modDir, sbfarcFile, destination.onSwitch(titleID), switchFont)
+}
+
+// Byte order of an encoded font, from its byte order mark
+func fontByteOrder(raw []byte) binary.ByteOrder {
+	if len(raw) >= 6 && binary.BigEndian.Uint16(raw[4:6]) == 0xFFFE {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+func byteOrderPlatform(order binary.ByteOrder) string {
+	if order == binary.LittleEndian {
+		return "Switch"
+	}
+	return "Wii U"
+}
+
+// Converts an encoded Wii U font into a Switch font. Sections other than the
+// built-in ones can't be converted, which of their bytes are numbers isn't
+// known.
+func encodeSwitchFont(raw []byte) ([]byte, error) {
+	if len(raw) < FFNT_HEADER_SIZE+FINF_HEADER_SIZE+TGLP_HEADER_SIZE || string(raw[:4]) != FFNT_MAGIC_HEADER {
+		return nil, fmt.Errorf("not a BFFNT font")
+	}
+	if fontByteOrder(raw) != binary.BigEndian {
+		return nil, fmt.Errorf("the font is already little endian")
+	}
+
+	tglpStart := FFNT_HEADER_SIZE + FINF_HEADER_SIZE
+	var tglp TGLP
+	tglp.DecodeHeader(raw[tglpStart : tglpStart+TGLP_HEADER_SIZE])
+	sheets, err := tglp.switchSheets(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// offsets past the sheets move by however much the sheets grew
+	tglpEnd := uint32(tglpStart) + tglp.SectionSize
+	shift := int64(len(sheets)) - int64(tglp.sheetsSize())
+	relocate := func(b []byte, pos int) {
+		offset := binary.BigEndian.Uint32(b[pos : pos+4])
+		if offset >= tglpEnd {
+			offset = checkedUint32("Switch section offset", int64(offset)+shift)
+		}
+		binary.LittleEndian.PutUint32(b[pos:pos+4], offset)
+	}
+
+	res := make([]byte, 0, int64(len(raw))+shift)
+	ffnt := append([]byte(nil), raw[:FFNT_HEADER_SIZE]...)
+	swap16(ffnt, 4, 6, 16, 18)
+	swap32(ffnt, 8)
+	res = append(res, ffnt...)
+
+	sections := newSectionIterator(raw)
+	for sections.Next() {
+		header := sections.Section()
+		if header.end() > len(raw) {
+			return nil, fmt.Errorf("%s section at offset %d runs past the end of the font", header.MagicHeader, header.Start)
+		}
+		section := append([]byte(nil), raw[header.Start:header.end()]...)
+
+		switch header.MagicHeader {
+		case FINF_MAGIC_HEADER:
+			swap32(section, 4, 20)
+			swap16(section, 12, 14)
+			relocate(section, 24)
+			relocate(section, 28)
+		case TGLP_MAGIC_HEADER:
+			if header.Start != tglpStart {
+				return nil, fmt.Errorf("TGLP section is at offset %d instead of right after FINF", header.Start)
+			}
+			tglp.SectionSize = checkedUint32("Switch TGLP section size", int64(tglp.SectionSize)+shift)
+			tglp.SheetSize = checkedUint32("Switch TGLP sheet size", int64(len(sheets)/int(tglp.NumOfSheets)))
+			tglpHeader := tglp.EncodeHeader()
+			swap32(tglpHeader, 4, 12, 28)
+			swap16(tglpHeader, 16, 18, 20, 22, 24, 26)
+			section = append(tglpHeader, raw[header.Start+TGLP_HEADER_SIZE:tglp.SheetDataOffset]...)
+			section = append(section, sheets...)
+		case CWDH_MAGIC_HEADER:
+			// the widths are single bytes
+			swap32(section, 4)
+			swap16(section, 8, 10)
+			relocate(section, 12)
+		case CMAP_MAGIC_HEADER:
+			// every mapping method is made of uint16s, as is the padding
+			swap32(section, 4)
+			swap16(section, 8, 10, 12, 14)
+			relocate(section, 16)
+			swap16s(section[CMAP_HEADER_SIZE:])
+		case KRNG_MAGIC_HEADER:
+			swap32(section, 4)
+			swap16s(section[KRNG_HEADER_SIZE:])
+		default:
+			return nil, fmt.Errorf("%s sections can't be converted to the Switch, which of their bytes are numbers isn't known", header.MagicHeader)
+		}
+		res = append(res, section...)
+	}
+	if err := sections.Err(); err != nil {
+		return nil, err
+	}
+
+	binary.LittleEndian.PutUint32(res[12:16], checkedUint32("Switch file size", int64(len(res))))
+	return res, nil
+}
+
+// The sheets of raw swizzled for the Tegra, one after the other
+func (tglp *TGLP) switchSheets(raw []byte) ([]byte, error) {
+	if tglp.SheetImageFormat != IMAGE_FORMAT_A8 && tglp.SheetImageFormat != IMAGE_FORMAT_BC4 {
+		return nil, fmt.Errorf("sheets in image format %d can't be converted to the Switch", tglp.SheetImageFormat)
+	}
+	dataStart := int64(tglp.SheetDataOffset)
+	dataEnd := dataStart + int64(tglp.sheetsSize())
+	if dataStart < FFNT_HEADER_SIZE+FINF_HEADER_SIZE+TGLP_HEADER_SIZE || dataEnd > int64(len(raw)) {
+		return nil, fmt.Errorf("TGLP sheet data would be at offsets %d to %d but the font is %d bytes", dataStart, dataEnd, len(raw))
+	}
+
+	width, height, _, bpp := tglp.sheetSurface()
+	bytesPerElement := int(bpp / 8)
+	res := make([]byte, 0, int(tglp.NumOfSheets)*tegraSurfaceSize(int(width), int(height), bytesPerElement))
+	for i := 0; i < int(tglp.NumOfSheets); i++ {
+		sheetStart := int(dataStart) + i*int(tglp.SheetSize)
+		linear := tglp.untileSheet(raw[sheetStart:sheetStart+int(tglp.SheetSize)], i, tglp.threads)
+		res = append(res, tegraSwizzle(linear, int(width), int(height), bytesPerElement, true)...)
+	}
+
+	return res, nil
+}
+
+// GOBs stacked in a block. The sheet's height in GOBs rounded up to a power of
+// two, at most 16, like the Switch's texture tools pick it.
+func tegraBlockHeight(height int) int {
+	blockHeight := nextPowerOfTwo((height + GOB_HEIGHT - 1) / GOB_HEIGHT)
+	if blockHeight > MAX_GOBS_PER_BLOCK {
+		blockHeight = MAX_GOBS_PER_BLOCK
+	}
+
+	return blockHeight
+}
+
+func tegraWidthInGobs(width int, bytesPerElement int) int {
+	return (width*bytesPerElement + GOB_WIDTH - 1) / GOB_WIDTH
+}
+
+// Size in bytes of a block linear surface of width x height elements, padded
+// to whole GOBs across and whole blocks down
+func tegraSurfaceSize(width int, height int, bytesPerElement int) int {
+	rowsPerBlock := GOB_HEIGHT * tegraBlockHeight(height)
+	rows := (height + rowsPerBlock - 1) / rowsPerBlock * rowsPerBlock
+	return tegraWidthInGobs(width, bytesPerElement) * GOB_WIDTH * rows
+}
+
+// Offset of the byte x bytes into row y. Blocks go left to right, the GOBs of
+// a block top to bottom, and a GOB is made of 16x2 byte pieces in a fixed
+// order.
+func tegraAddress(x int, y int, widthInGobs int, blockHeight int) int {
+	rowsPerBlock := GOB_HEIGHT * blockHeight
+	gob := (y/rowsPerBlock)*GOB_SIZE*blockHeight*widthInGobs +
+		(x/GOB_WIDTH)*GOB_SIZE*blockHeight +
+		(y%rowsPerBlock/GOB_HEIGHT)*GOB_SIZE
+
+	return gob + (x%64/32)*256 + (y%8/2)*64 + (x%32/16)*32 + (y%2)*16 + x%16
+}
+
+// Swizzles rows of width x height elements into the block linear layout, or
+// back into rows. Elements are at most 16 bytes and never straddle a 16 byte
+// piece of a GOB, so they're copied whole.
+func tegraSwizzle(data []byte, width int, height int, bytesPerElement int, swizzle bool) []byte {
+	blockHeight := tegraBlockHeight(height)
+	widthInGobs := tegraWidthInGobs(width, bytesPerElement)
+	rowSize := width * bytesPerElement
+
+	var res []byte
+	if swizzle {
+		res = make([]byte, tegraSurfaceSize(width, height, bytesPerElement))
+	} else {
+		res = make([]byte, rowSize*height)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < rowSize; x += bytesPerElement {
+			linear := y*rowSize + x
+			swizzled := tegraAddress(x, y, widthInGobs, blockHeight)
+			if swizzle {
+				copy(res[swizzled:swizzled+bytesPerElement], data[linear:linear+bytesPerElement])
+			} else {
+				copy(res[linear:linear+bytesPerElement], data[swizzled:swizzled+bytesPerElement])
+			}
+		}
+	}
+
+	return res
+}
+
+// Reverses the bytes of the uint16 at each offset
+func swap16(b []byte, offsets ...int) {
+	for _, pos := range offsets {
+		b[pos], b[pos+1] = b[pos+1], b[pos]
+	}
+}
+
+// Reverses the bytes of the uint32 at each offset
+func swap32(b []byte, offsets ...int) {
+	for _, pos := range offsets {
+		b[pos], b[pos+1], b[pos+2], b[pos+3] = b[pos+3], b[pos+2], b[pos+1], b[pos]
+	}
+}
+
+// Reverses the bytes of every uint16 in b
+func swap16s(b []byte) {
+	for pos := 0; pos+1 < len(b); pos += 2 {
+		swap16(b, pos)
+	}
+}
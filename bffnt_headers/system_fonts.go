@@ -0,0 +1,300 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+)
+
+// Fonts that come with the repo, searched after the system's
+const BUNDLED_FONT_DIR = "./nintendo_system_ui"
+
+var fontFileExtensions = map[string]bool{".ttf": true, ".otf": true, ".ttc": true, ".otc": true}
+
+// Styles a family name alone picks
+var regularStyles = map[string]bool{"regular": true, "book": true, "normal": true, "roman": true, "r": true}
+
+func isFontFile(name string) bool {
+	path, _ := splitFaceIndex(name)
+	return fontFileExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// The usual font directories of the OS. Fonts installed anywhere else are
+// only found by fontconfig or by their file.
+func systemFontDirs() []string {
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "windows":
+		return []string{
+			filepath.Join(os.Getenv("WINDIR"), "Fonts"),
+			filepath.Join(os.Getenv("LOCALAPPDATA"), "Microsoft", "Windows", "Fonts"),
+		}
+	case "darwin":
+		return []string{
+			filepath.Join(home, "Library", "Fonts"),
+			"/Library/Fonts",
+			"/System/Library/Fonts",
+		}
+	default:
+		return []string{
+			filepath.Join(home, ".local", "share", "fonts"),
+			filepath.Join(home, ".fonts"),
+			"/usr/local/share/fonts",
+			"/usr/share/fonts",
+		}
+	}
+}
+
+// e.g. "FOT-RodinBokutoh Pro B" -> "fotrodinbokutohprob"
+func normalizeFontName(name string) string {
+	var sb strings.Builder
+	for _, char := range strings.ToLower(name) {
+		if unicode.IsLetter(char) || unicode.IsDigit(char) {
+			sb.WriteRune(char)
+		}
+	}
+	return sb.String()
+}
+
+// The names a face can be looked up by, normalized
+type faceNames struct {
+	families   [][2]string // family and style, the name table has two pairs of them
+	full       string
+	postScript string
+}
+
+func readFaceNames(f *sfnt.Font) faceNames {
+	faceName := func(id sfnt.NameID) string {
+		value, err := f.Name(nil, id)
+		if err != nil {
+			return ""
+		}
+		return normalizeFontName(value)
+	}
+
+	names := faceNames{full: faceName(sfnt.NameIDFull), postScript: faceName(sfnt.NameIDPostScript)}
+	for _, ids := range [][2]sfnt.NameID{{sfnt.NameIDFamily, sfnt.NameIDSubfamily}, {sfnt.NameIDTypographicFamily, sfnt.NameIDTypographicSubfamily}} {
+		if family := faceName(ids[0]); family != "" {
+			names.families = append(names.families, [2]string{family, faceName(ids[1])})
+		}
+	}
+	return names
+}
+
+// How well a face matches a normalized name: 2 for its full or PostScript
+// name or family and style, 1 for its family if it's the regular face
+func (names faceNames) match(name string) int {
+	match := 0
+	for _, family := range names.families {
+		if family[0]+family[1] == name {
+			return 2
+		}
+		if family[0] == name && regularStyles[family[1]] {
+			match = 1
+		}
+	}
+	if names.full == name || names.postScript == name {
+		return 2
+	}
+	return match
+}
+
+// A face in a font directory, the file has the face index of collections,
+// see withFaceIndex
+type dirFace struct {
+	file  string
+	names faceNames
+}
+
+// The faces found in each directory. Only their names are kept, a run that
+// looks up several fonts reads the files of a directory once.
+var fontDirFaces = struct {
+	sync.Mutex
+	dirs map[string][]dirFace
+}{dirs: make(map[string][]dirFace)}
+
+func facesIn(dir string) []dirFace {
+	fontDirFaces.Lock()
+	defer fontDirFaces.Unlock()
+	if faces, scanned := fontDirFaces.dirs[dir]; scanned {
+		return faces
+	}
+
+	var faces []dirFace
+	walkFontDir(dir, make(map[string]bool), func(path string) {
+		dat, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		collection, err := opentype.ParseCollection(dat)
+		if err != nil {
+			return
+		}
+		for i := 0; i < collection.NumFonts(); i++ {
+			if f, err := collection.Font(i); err == nil {
+				faces = append(faces, dirFace{file: withFaceIndex(path, i), names: readFaceNames(f)})
+			}
+		}
+	})
+	fontDirFaces.dirs[dir] = faces
+
+	return faces
+}
+
+// Calls found with the font files in dir and its subdirectories. Unlike
+// filepath.Walk symlinks are followed, font directories like
+// ~/.local/share/fonts often are one or link in others. visited has the real
+// path of the directories read, a link back to one of them is skipped.
+func walkFontDir(dir string, visited map[string]bool, found func(path string)) {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil || visited[real] {
+		return
+	}
+	visited[real] = true
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue // dangling
+			}
+			isDir = info.IsDir()
+		}
+		if isDir {
+			walkFontDir(path, visited, found)
+		} else if isFontFile(path) {
+			found(path)
+		}
+	}
+}
+
+// Best matching face in the font files of dirs, the first one found wins a
+// tie. Collections return the face with its index, see withFaceIndex.
+func findFontIn(name string, dirs []string) (string, bool) {
+	name = normalizeFontName(name)
+	best, bestMatch := "", 0
+	for _, dir := range dirs {
+		for _, face := range facesIn(dir) {
+			if match := face.names.match(name); match > bestMatch {
+				best, bestMatch = face.file, match
+			}
+			if bestMatch == 2 {
+				return best, true
+			}
+		}
+	}
+	return best, bestMatch > 0
+}
+
+// Asks fontconfig for a face when fc-match is installed. It's tried as a
+// family, full name and PostScript name. fc-match always answers with some
+// font, the face it picks only counts when it has the name.
+func fontconfigMatch(name string) (string, bool) {
+	fcMatch, err := exec.LookPath("fc-match")
+	if err != nil {
+		return "", false
+	}
+
+	escaped := escapeFontconfig(name)
+	for _, pattern := range []string{escaped, ":fullname=" + escaped, ":postscriptname=" + escaped} {
+		out, err := exec.Command(fcMatch, "--format=%{file}\t%{index}", pattern).Output()
+		if err != nil {
+			continue
+		}
+		fontFile, found := parseFontconfigMatch(string(out))
+		if !found {
+			continue
+		}
+		if names, err := fontFileNames(fontFile); err == nil && names.match(normalizeFontName(name)) > 0 {
+			return fontFile, true
+		}
+	}
+	return "", false
+}
+
+// fontconfig patterns use - : , and \ as separators
+func escapeFontconfig(name string) string {
+	var sb strings.Builder
+	for _, char := range name {
+		if strings.ContainsRune(`-:,\`, char) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(char)
+	}
+	return sb.String()
+}
+
+// fc-match's "file<tab>index" as a font file with its face index
+func parseFontconfigMatch(out string) (string, bool) {
+	fields := strings.Split(strings.TrimSpace(out), "\t")
+	if len(fields) != 2 || fields[0] == "" {
+		return "", false
+	}
+	faceIndex, err := strconv.Atoi(fields[1])
+	if err != nil || faceIndex < 0 {
+		return "", false
+	}
+	return withFaceIndex(fields[0], faceIndex), true
+}
+
+func fontFileNames(fontFile string) (faceNames, error) {
+	path, faceIndex := splitFaceIndex(fontFile)
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return faceNames{}, err
+	}
+	collection, err := opentype.ParseCollection(dat)
+	if err != nil {
+		return faceNames{}, err
+	}
+	f, err := collection.Font(faceIndex)
+	if err != nil {
+		return faceNames{}, err
+	}
+	return readFaceNames(f), nil
+}
+
+// The font file for a -ttf value. Font files are used as they are, anything
+// without a font file extension is a name, e.g. -ttf "Rodin Bokutoh Pro B" or
+// -ttf "DejaVu Sans". A face matches when its full name, PostScript name or
+// family and style is the name, ignoring case, spaces and punctuation. A
+// family name alone picks its regular face.
+//
+// fontconfig's fc-match is asked first where it's installed, usually on
+// Linux. Otherwise, or when it doesn't know the name, the font files in the
+// usual font directories of the OS and the bundled fonts are read. DirectWrite
+// and Core Text aren't asked, on Windows and macOS a font installed outside
+// those directories has to be given by its file.
+func findFontFile(name string) string {
+	if isFontFile(name) {
+		return name
+	}
+
+	if fontFile, found := fontconfigMatch(name); found {
+		fmt.Printf("using %s for %q, found by fontconfig\n", fontFile, name)
+		return fontFile
+	}
+	dirs := append(systemFontDirs(), BUNDLED_FONT_DIR)
+	fontFile, found := findFontIn(name, dirs)
+	if !found {
+		handleErr(fmt.Errorf("no font named %q in %s. Give the font file instead", name, strings.Join(dirs, ", ")))
+	}
+	fmt.Printf("using %s for %q\n", fontFile, name)
+
+	return fontFile
+}
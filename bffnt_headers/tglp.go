@@ -1,11 +1,11 @@
 package bffnt_headers
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
 	"image"
+	"io"
 	"math"
 
 	"github.com/disintegration/imaging"
@@ -45,7 +45,7 @@ type TGLP struct { //    Offset  Size  Description
 	MaxCharWidth     uint8         // 0x0B    0x01  Max Character Width
 	SheetSize        uint32        // 0x0C    0x04  Sheet Size
 	BaselinePosition uint16        // 0x10    0x02  Baseline Position
-	SheetImageFormat uint16        // 0x12    0x02  Sheet Image Format 0-13: (RGBA8, RGB8, RGBA5551, RGB565, RGBA4, LA8, HILO8, L8, A8, LA4, L4, A4, ETC1, ETC1A4)
+	SheetImageFormat uint16        // 0x12    0x02  Sheet Image Format (Wii U GX2 formats, see IMAGE_FORMAT_*)
 	NumOfColumns     uint16        // 0x14    0x02  Number of Sheet columns
 	NumOfRows        uint16        // 0x16    0x02  Number of Sheet rows
 	SheetWidth       uint16        // 0x18    0x02  Sheet Width
@@ -53,30 +53,123 @@ type TGLP struct { //    Offset  Size  Description
 	SheetDataOffset  uint32        // 0x1C    0x04  Sheet Data Offset
 	AllSheetData     []byte        // raw bytes of all data sheets. Used for decoding.
 	SheetData        []image.NRGBA // separated unswizzled images. Used for encoding.
+
+	raw        []byte // the whole section as it was decoded, see sheetsUntouched
+	validation ValidationResult
+	log        *Logger // the font's logger, see BFFNT.Log
+	threads    int     // see BFFNT.Threads
 }
 
 func (tglp *TGLP) Upscale(scale float64) {
-	tglp.SheetWidth = uint16(math.Ceil(float64(tglp.SheetWidth) * scale))
-	tglp.SheetHeight = uint16(math.Ceil(float64(tglp.SheetHeight*uint16(tglp.NumOfSheets)) * scale))
-	tglp.SheetSize = uint32(tglp.SheetWidth) * uint32(tglp.SheetHeight)
-	// tglp.SheetImageFormat = uint16(12)
-	if tglp.SheetImageFormat == 12 {
-		tglp.SheetSize = uint32(math.Ceil(float64(tglp.SheetSize) / float64(2)))
+	tglp.MaxCharWidth = uint8(math.Ceil(float64(tglp.MaxCharWidth) * scale))
+	tglp.BaselinePosition = uint16(scaleMetric(float64(tglp.BaselinePosition), scale))
+	tglp.applyLayout(tglp.scaledLayout(scale))
+}
+
+// Puts back the sheets and the layout of the original section while keeping
+// the metrics that were changed since, so scaled widths and baselines can be
+// tried out in game without rendering new sheets.
+func (tglp *TGLP) keepSheets(original TGLP) {
+	maxCharWidth, baselinePosition := tglp.MaxCharWidth, tglp.BaselinePosition
+	*tglp = original
+	tglp.MaxCharWidth, tglp.BaselinePosition = maxCharWidth, baselinePosition
+}
+
+// Cell grid and sheet dimensions. Kept as ints so a layout can be checked
+// before it is squeezed into the uint8/uint16 header fields.
+type sheetLayout struct {
+	cellWidth   int
+	cellHeight  int
+	columns     int
+	rows        int
+	sheetWidth  int
+	sheetHeight int
+	imageFormat uint16
+}
+
+// Layout after scaling. All sheets are stacked into a single tall sheet, its
+// just easier not to deal with multiple pages.
+func (tglp *TGLP) scaledLayout(scale float64) sheetLayout {
+	layout := sheetLayout{
+		cellWidth:   int(math.Ceil(float64(tglp.CellWidth) * scale)),
+		cellHeight:  int(math.Ceil(float64(tglp.CellHeight) * scale)),
+		columns:     int(tglp.NumOfColumns),
+		rows:        int(tglp.NumOfRows) * int(tglp.NumOfSheets),
+		sheetWidth:  int(math.Ceil(float64(tglp.SheetWidth) * scale)),
+		sheetHeight: int(math.Ceil(float64(int(tglp.SheetHeight)*int(tglp.NumOfSheets)) * scale)),
+		imageFormat: tglp.SheetImageFormat,
 	}
+	layout.fitGrid()
 
-	tglp.SectionSize = TGLP_HEADER_SIZE + uint32(tglp.computePredataPadding()) + tglp.SheetSize
-	tglp.CellWidth = uint8(math.Ceil(float64(tglp.CellWidth) * scale))
-	tglp.CellHeight = uint8(math.Ceil(float64(tglp.CellHeight) * scale))
-	tglp.MaxCharWidth = uint8(math.Ceil(float64(tglp.MaxCharWidth) * scale))
-	tglp.BaselinePosition = uint16(math.Ceil(float64(tglp.BaselinePosition) * scale))
+	return layout
+}
+
+// Rearranges the cells into the given amount of columns with just enough rows
+// for glyphCount cells. The sheet shrinks to the size of the grid.
+func (layout sheetLayout) withColumns(columns int, glyphCount int) sheetLayout {
+	layout.columns = columns
+	layout.rows = (glyphCount + columns - 1) / columns
+	if layout.rows == 0 {
+		layout.rows = 1 // a sheet needs at least one cell, even without glyphs
+	}
+	layout.sheetWidth = 0
+	layout.sheetHeight = 0
+	layout.fitGrid()
+
+	return layout
+}
 
-	// manual changes
-	// tglp.SheetWidth = uint16(tglp.SheetWidth * scale)
-	// tglp.SheetHeight = uint16(1024 * scale)
-	// tglp.NumOfColumns /= uint16(scale)
-	tglp.NumOfRows = tglp.NumOfRows * uint16(tglp.NumOfSheets)
+// Adds rows until there is a cell for every glyph, e.g. after characters were
+// added. The columns and the sheet width stay the same.
+func (layout sheetLayout) withRowsFor(glyphCount int) sheetLayout {
+	if layout.columns == 0 || layout.columns*layout.rows >= glyphCount {
+		return layout
+	}
+	layout.rows = (glyphCount + layout.columns - 1) / layout.columns
+	layout.fitGrid()
 
-	tglp.NumOfSheets = uint8(1) // its just easier not to deal with multiple pages
+	return layout
+}
+
+// The 1 pixel padding between cells does not shrink with the cells. When
+// downscaling the scaled sheet can be too small for the cell grid.
+func (layout *sheetLayout) fitGrid() {
+	gridWidth := layout.columns * (layout.cellWidth + 1)
+	gridHeight := layout.rows * (layout.cellHeight + 1)
+	if layout.sheetWidth < gridWidth {
+		layout.sheetWidth = gridWidth
+	}
+	if layout.sheetHeight < gridHeight {
+		layout.sheetHeight = gridHeight
+	}
+
+	// BC4 is stored in blocks of 4x4 pixels
+	if layout.imageFormat == IMAGE_FORMAT_BC4 {
+		layout.sheetWidth += paddingToNext4ByteBoundary(layout.sheetWidth)
+		layout.sheetHeight += paddingToNext4ByteBoundary(layout.sheetHeight)
+	}
+}
+
+// checkSheetLimits explains why a layout doesn't fit, this only makes sure
+// nothing wraps when it's applied anyway
+func (tglp *TGLP) applyLayout(layout sheetLayout) {
+	tglp.CellWidth = checkedUint8("TGLP cell width", int64(layout.cellWidth))
+	tglp.CellHeight = checkedUint8("TGLP cell height", int64(layout.cellHeight))
+	tglp.NumOfColumns = checkedUint16("TGLP column count", int64(layout.columns))
+	tglp.NumOfRows = checkedUint16("TGLP row count", int64(layout.rows))
+	tglp.SheetWidth = checkedUint16("TGLP sheet width", int64(layout.sheetWidth))
+	tglp.SheetHeight = checkedUint16("TGLP sheet height", int64(layout.sheetHeight))
+	tglp.NumOfSheets = uint8(1)
+
+	tglp.SheetSize = tglp.computeSheetSize()
+	tglp.SectionSize = checkedUint32("TGLP section size", int64(TGLP_HEADER_SIZE)+int64(tglp.computePredataPadding())+int64(tglp.SheetSize))
+}
+
+// Size in bytes of a single swizzled sheet, including the padding up to the
+// surface pitch.
+func (tglp *TGLP) computeSheetSize() uint32 {
+	_, height, pitch, bpp := tglp.sheetSurface()
+	return checkedUint32("TGLP sheet size", int64(pitch)*int64(height)*int64(bpp)/8)
 }
 
 // Version 4 (BFFNT)
@@ -89,16 +182,21 @@ func (tglp *TGLP) Decode(raw []byte) {
 	assertEqual(TGLP_HEADER_SIZE, len(headerRaw))
 	tglp.DecodeHeader(headerRaw)
 
-	totalSheetDataSize := int(tglp.SheetSize) * int(tglp.NumOfSheets)
+	// a broken header can point anywhere, check before slicing
 	dataStart := int(tglp.SheetDataOffset)
-	dataEnd := dataStart + totalSheetDataSize
+	dataEnd64 := int64(dataStart) + int64(tglp.SheetSize)*int64(tglp.NumOfSheets)
+	if dataStart < headerEnd || dataEnd64 > int64(len(raw)) {
+		panic(fmt.Sprintf("TGLP sheet data would be at offsets %d to %d but the file is %d bytes", dataStart, dataEnd64, len(raw)))
+	}
+	dataEnd := int(dataEnd64)
 	tglp.AllSheetData = raw[dataStart:dataEnd]
+	tglp.raw = raw[headerStart:dataEnd]
 
 	calculatedTGLPSectionSize := TGLP_HEADER_SIZE + tglp.computePredataPadding() + len(tglp.AllSheetData)
-	assertEqual(int(tglp.SectionSize), calculatedTGLPSectionSize)
+	tglp.validation.assertEqual("TGLP section size", int(tglp.SectionSize), calculatedTGLPSectionSize)
 
 	// tglp.DecodeSheets()
-	if Debug {
+	if tglp.log.debugging() {
 		tglp.Print()
 		// fmt.Println("MagicHeader     ", tglp.MagicHeader)
 		// fmt.Println("SectionSize     ", tglp.SectionSize)
@@ -115,31 +213,33 @@ func (tglp *TGLP) Decode(raw []byte) {
 		// fmt.Println("SheetHeight     ", tglp.SheetHeight)
 		// fmt.Println("SheetDataOffset ", tglp.SheetDataOffset)
 
-		fmt.Printf("Read section total of %d bytes\n", dataEnd-headerStart)
-		fmt.Println("Byte offsets start(inclusive) to end(exclusive)================")
-		fmt.Printf("header      %-8d to  %d\n", headerStart, headerEnd)
-		fmt.Printf("padding     %-8d to  %d\n", headerEnd, dataStart)
-		fmt.Printf("image data  %-8d to  %d\n", dataStart, dataEnd)
-		fmt.Println()
+		tglp.log.debugf("Read section total of %d bytes\n", dataEnd-headerStart)
+		tglp.log.debugln("Byte offsets start(inclusive) to end(exclusive)================")
+		tglp.log.debugf("header      %-8d to  %d\n", headerStart, headerEnd)
+		tglp.log.debugf("padding     %-8d to  %d\n", headerEnd, dataStart)
+		tglp.log.debugf("image data  %-8d to  %d\n", dataStart, dataEnd)
+		tglp.log.debugln()
 	}
 }
 
+// Prints the header to the section's log output, stdout unless it was set
 func (tglp *TGLP) Print() {
-	fmt.Println("MagicHeader     ", tglp.MagicHeader)
-	fmt.Println("SectionSize     ", tglp.SectionSize)
-	fmt.Println("CellWidth       ", tglp.CellWidth)
-	fmt.Println("CellHeight      ", tglp.CellHeight)
-	fmt.Println("NumOfSheets     ", tglp.NumOfSheets)
-	fmt.Println("MaxCharWidth    ", tglp.MaxCharWidth)
-	fmt.Println("SheetSize       ", tglp.SheetSize)
-	fmt.Println("BaselinePosition", tglp.BaselinePosition)
-	fmt.Println("SheetImageFormat", tglp.SheetImageFormat)
-	fmt.Println("NumOfColumns    ", tglp.NumOfColumns)
-	fmt.Println("NumOfRows       ", tglp.NumOfRows)
-	fmt.Println("SheetWidth      ", tglp.SheetWidth)
-	fmt.Println("SheetHeight     ", tglp.SheetHeight)
-	fmt.Println("SheetDataOffset ", tglp.SheetDataOffset)
-	fmt.Println()
+	out := tglp.log.out()
+	fmt.Fprintln(out, "MagicHeader     ", tglp.MagicHeader)
+	fmt.Fprintln(out, "SectionSize     ", tglp.SectionSize)
+	fmt.Fprintln(out, "CellWidth       ", tglp.CellWidth)
+	fmt.Fprintln(out, "CellHeight      ", tglp.CellHeight)
+	fmt.Fprintln(out, "NumOfSheets     ", tglp.NumOfSheets)
+	fmt.Fprintln(out, "MaxCharWidth    ", tglp.MaxCharWidth)
+	fmt.Fprintln(out, "SheetSize       ", tglp.SheetSize)
+	fmt.Fprintln(out, "BaselinePosition", tglp.BaselinePosition)
+	fmt.Fprintln(out, "SheetImageFormat", tglp.SheetImageFormat)
+	fmt.Fprintln(out, "NumOfColumns    ", tglp.NumOfColumns)
+	fmt.Fprintln(out, "NumOfRows       ", tglp.NumOfRows)
+	fmt.Fprintln(out, "SheetWidth      ", tglp.SheetWidth)
+	fmt.Fprintln(out, "SheetHeight     ", tglp.SheetHeight)
+	fmt.Fprintln(out, "SheetDataOffset ", tglp.SheetDataOffset)
+	fmt.Fprintln(out)
 }
 
 func (tglp *TGLP) DecodeHeader(raw []byte) {
@@ -158,86 +258,285 @@ func (tglp *TGLP) DecodeHeader(raw []byte) {
 	tglp.SheetHeight = binary.BigEndian.Uint16(raw[26:28])
 	tglp.SheetDataOffset = binary.BigEndian.Uint32(raw[28:TGLP_HEADER_SIZE])
 
-	if Debug {
-		// pprint(tglp)
+	if tglp.log.debugging() {
+		// tglp.log.debugJSON(tglp)
 	}
 }
 
-// TODO: decode multiple sheets
-// TODO: have swizzle take in RGBA
-func (tglp *TGLP) DecodeSheets() {
-	totalSheetBytes := int(tglp.NumOfSheets) * int(tglp.SheetSize)
-	assertEqual(totalSheetBytes, len(tglp.AllSheetData))
+// Dimensions of a sheet in surface elements. BC4 stores every 4x4 block of
+// pixels as a single 64 bit element. Tiled surfaces are at least one macro tile
+// (32 elements) wide, Ancient_00's 32 pixel wide sheet is padded because of
+// this.
+func (tglp *TGLP) sheetSurface() (width uint, height uint, pitch uint, bpp uint) {
+	width = uint(tglp.SheetWidth)
+	height = uint(tglp.SheetHeight)
+	bpp = 8
+
+	if tglp.SheetImageFormat == IMAGE_FORMAT_BC4 {
+		width = (width + 3) / 4
+		height = (height + 3) / 4
+		bpp = 64
+	}
+
+	pitch = width
+	if pitch < 32 {
+		pitch = 32
+	}
 
-	sheetData := tglp.AllSheetData
+	return width, height, pitch, bpp
+}
+
+// Alpha of a single sheet, still upside down
+func (tglp *TGLP) deswizzleSheet(sheetData []byte, sheet int, workers int) []byte {
+	deswizzledImage := tglp.untileSheet(sheetData, sheet, workers)
+	if tglp.SheetImageFormat == IMAGE_FORMAT_BC4 {
+		sw, sh, _, _ := tglp.sheetSurface()
+		deswizzledImage = decodeBC4(deswizzledImage, int(sw), int(sh))
+	}
+
+	return deswizzledImage
+}
+
+// The surface elements of a single sheet in rows, a byte of alpha or a BC4
+// block each. The sheets are slices of a texture array, the slice rotates the
+// banks and pipes of the tiling. workers untile the tiles, see swizzleSurface.
+func (tglp *TGLP) untileSheet(sheetData []byte, sheet int, workers int) []byte {
 	depth := uint(1)
-	sw := uint(tglp.SheetWidth)
-	sh := uint(tglp.SheetHeight)
+	sw, sh, pitch, bpp := tglp.sheetSurface()
 	format_ := uint(1)
 	aa := uint(0)
 	use := uint(2)
 	tileMode := uint(4)
 	swizzle_ := uint(0)
-	bpp := uint(8)
-	slice := uint(0)
+	slice := uint(sheet)
 	sample := uint(0)
-	deswizzledImage := deswizzle(sw, sh, depth, sh, format_, aa, use, tileMode, swizzle_, sw, bpp, slice, sample, sheetData)
+	return deswizzle(sw, sh, depth, sh, format_, aa, use, tileMode, swizzle_, pitch, bpp, slice, sample, sheetData, workers)
+}
 
-	alphaImg := image.Alpha{
-		Pix:    deswizzledImage,
-		Stride: int(tglp.SheetWidth),
-		Rect:   image.Rect(0, 0, int(tglp.SheetWidth), int(tglp.SheetHeight)),
+// Decoded sheets are cached on disk, see sheetCacheKey
+// TODO: have swizzle take in RGBA
+func (tglp *TGLP) DecodeSheets() {
+	totalSheetBytes := int(tglp.NumOfSheets) * int(tglp.SheetSize)
+	assertEqual(totalSheetBytes, len(tglp.AllSheetData))
+	if tglp.SheetImageFormat != IMAGE_FORMAT_A8 && tglp.SheetImageFormat != IMAGE_FORMAT_BC4 {
+		panic(fmt.Sprintf("Unsupported image decoding for image format: %d", tglp.SheetImageFormat))
 	}
 
-	// imaging.FlipV returns an NRGBA image
-	img := imaging.FlipV(alphaImg.SubImage(alphaImg.Rect))
+	// sheets are deswizzled on their own, one per worker. The threads are
+	// split between the sheets and their tiles so the pools together don't
+	// run more than threads goroutines.
+	sheetWorkers, tileWorkers := splitWorkers(tglp.threads, int(tglp.NumOfSheets))
+	tglp.SheetData = make([]image.NRGBA, tglp.NumOfSheets)
+	parallelEach(int(tglp.NumOfSheets), sheetWorkers, func(i int) {
+		sheetStart := i * int(tglp.SheetSize)
+		sheetEnd := sheetStart + int(tglp.SheetSize)
+		sheetData := tglp.AllSheetData[sheetStart:sheetEnd]
+
+		cacheKey := tglp.sheetCacheKey(sheetData, i)
+		deswizzledImage := tglp.cachedSheet(cacheKey)
+		if deswizzledImage == nil {
+			deswizzledImage = tglp.deswizzleSheet(sheetData, i, tileWorkers)
+			cacheSheet(tglp.log, cacheKey, deswizzledImage)
+		}
+
+		alphaImg := image.Alpha{
+			Pix:    deswizzledImage,
+			Stride: int(tglp.SheetWidth),
+			Rect:   image.Rect(0, 0, int(tglp.SheetWidth), int(tglp.SheetHeight)),
+		}
+
+		// imaging.FlipV returns an NRGBA image
+		img := imaging.FlipV(alphaImg.SubImage(alphaImg.Rect))
 
-	tglp.SheetData = append(tglp.SheetData, *img)
+		tglp.SheetData[i] = *img
+	})
 }
 
-func (tglp *TGLP) Encode() []byte {
-	var res []byte
+// Header bytes that describe glyph metrics rather than the sheets, the
+// original sheets still fit when only these changed
+const (
+	TGLP_MAX_CHAR_WIDTH_POS = 0x0B
+	TGLP_BASELINE_POS       = 0x10
+)
 
-	// pprint(tglp)
+// Whether the sheets can be written back as they were decoded: the sheet
+// layout in the header is unchanged and AllSheetData is still the sheet data
+// that was decoded, not a replacement. Tuning widths, kerning or the baseline
+// leaves the sheets alone so re-encoding the font copies them instead of
+// blanking them, upscaling changes the layout and gets blank template sheets.
+func (tglp *TGLP) sheetsUntouched() bool {
+	if len(tglp.raw) < TGLP_HEADER_SIZE || len(tglp.raw) != tglp.encodedSize() {
+		return false
+	}
+	sheetsRaw := tglp.raw[len(tglp.raw)-tglp.sheetsSize():]
+	if len(tglp.AllSheetData) != len(sheetsRaw) {
+		return false
+	}
+	if len(sheetsRaw) > 0 && &tglp.AllSheetData[0] != &sheetsRaw[0] {
+		return false
+	}
 
 	header := tglp.EncodeHeader()
-	// pprint(tglp)
-	padding := make([]byte, tglp.computePredataPadding())
-	allSheetData := tglp.EncodeBlankSheets()
-	// fmt.Println("data len:", len(allSheetData))
-
-	res = append(res, header...)
-	res = append(res, padding...)
-	res = append(res, allSheetData...)
-	// fmt.Println("tglp size:", len(res))
-
-	assertEqual(int(tglp.SheetDataOffset), FFNT_HEADER_SIZE+FINF_HEADER_SIZE+TGLP_HEADER_SIZE+len(padding))
-	assertEqual(int(tglp.SectionSize), len(res))
+	originalHeader := append([]byte(nil), tglp.raw[:TGLP_HEADER_SIZE]...)
+	for _, metrics := range [][]byte{header, originalHeader} {
+		metrics[TGLP_MAX_CHAR_WIDTH_POS] = 0
+		metrics[TGLP_BASELINE_POS] = 0
+		metrics[TGLP_BASELINE_POS+1] = 0
+	}
+
+	return bytes.Equal(header, originalHeader)
+}
+
+// Whether SheetData holds a sheet image for every sheet of the current
+// layout, e.g. a rendered sheet put into an upscaled font. Injected sheets are
+// converted one at a time while encoding, so only a single converted sheet is
+// in memory on top of the output.
+func (tglp *TGLP) sheetsInjected() bool {
+	if len(tglp.SheetData) == 0 || len(tglp.SheetData) != int(tglp.NumOfSheets) {
+		return false
+	}
+	for _, sheet := range tglp.SheetData {
+		if sheet.Rect.Dx() != int(tglp.SheetWidth) || sheet.Rect.Dy() != int(tglp.SheetHeight) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The sheet data Encode writes, see sheetsUntouched and sheetsInjected
+func (tglp *TGLP) encodedSheetData() []byte {
+	switch {
+	case tglp.sheetsUntouched():
+		return tglp.AllSheetData
+	case tglp.sheetsInjected():
+		return tglp.EncodeSheetData()
+	}
+	return tglp.EncodeBlankSheets()
+}
+
+func (tglp *TGLP) Encode() []byte {
+	return tglp.appendEncoded(make([]byte, 0, tglp.encodedSize()))
+}
+
+// Same as appendEncoded but writes the section to w. The blank sheets are
+// written a chunk at a time, injected ones a sheet at a time.
+func (tglp *TGLP) writeEncoded(w io.Writer) (int, error) {
+	if tglp.sheetsUntouched() {
+		written, err := w.Write(tglp.EncodeHeader())
+		if err == nil {
+			var n int
+			n, err = w.Write(tglp.raw[TGLP_HEADER_SIZE:])
+			written += n
+		}
+		if err == nil {
+			tglp.validateEncodedSize(written)
+		}
+		return written, err
+	}
+
+	written, err := w.Write(tglp.appendPredata(nil))
+	if err != nil {
+		return written, err
+	}
+
+	if tglp.sheetsInjected() {
+		for i := range tglp.SheetData {
+			n, err := w.Write(tglp.encodeSheet(i))
+			written += n
+			if err != nil {
+				return written, err
+			}
+		}
+		tglp.validateEncodedSize(written)
+		return written, nil
+	}
+
+	zeros := make([]byte, 64*1024)
+	for remaining := tglp.sheetsSize(); remaining > 0; {
+		chunk := zeros
+		if remaining < len(chunk) {
+			chunk = chunk[:remaining]
+		}
+		n, err := w.Write(chunk)
+		written += n
+		remaining -= n
+		if err != nil {
+			return written, err
+		}
+	}
+	tglp.validateEncodedSize(written)
+
+	return written, nil
+}
+
+// Size of the encoded section, header, padding and sheets
+func (tglp *TGLP) encodedSize() int {
+	return int(checkedUint32("TGLP section size", int64(TGLP_HEADER_SIZE)+int64(tglp.computePredataPadding())+int64(tglp.sheetsSize())))
+}
+
+// Encodes the section at the end of res. The sheets are by far the biggest
+// part of a font, they're zeroed in place instead of being built separately
+// and copied over. Untouched sheets are copied along with the padding before
+// them as they were decoded, injected sheets are converted one at a time.
+func (tglp *TGLP) appendEncoded(res []byte) []byte {
+	start := len(res)
+	if tglp.sheetsUntouched() {
+		res = append(res, tglp.EncodeHeader()...)
+		res = append(res, tglp.raw[TGLP_HEADER_SIZE:]...)
+		tglp.validateEncodedSize(len(res) - start)
+		return res
+	}
+
+	// tglp.log.debugJSON(tglp)
+
+	res = tglp.appendPredata(res)
+	if tglp.sheetsInjected() {
+		// straight into res, which Encode sized for the whole file
+		for i := range tglp.SheetData {
+			res = append(res, tglp.encodeSheet(i)...)
+		}
+	} else {
+		res = appendZeros(res, tglp.sheetsSize())
+	}
+	// fmt.Println("tglp size:", len(res)-start)
+
+	tglp.validateEncodedSize(len(res) - start)
 	return res
 }
 
-func (tglp *TGLP) EncodeHeader() []byte {
+// The header and the padding before the sheet data
+func (tglp *TGLP) appendPredata(res []byte) []byte {
+	res = append(res, tglp.EncodeHeader()...)
+	return appendZeros(res, tglp.computePredataPadding())
+}
 
-	var buf bytes.Buffer
-	w := bufio.NewWriter(&buf)
+func (tglp *TGLP) sheetsSize() int {
+	return int(checkedUint32("TGLP sheet data size", int64(tglp.SheetSize)*int64(tglp.NumOfSheets)))
+}
 
-	_, _ = w.Write([]byte(tglp.MagicHeader))
-	binaryWrite(w, tglp.SectionSize)
-	binaryWrite(w, tglp.CellWidth)
-	binaryWrite(w, tglp.CellHeight)
-	binaryWrite(w, tglp.NumOfSheets)
-	binaryWrite(w, tglp.MaxCharWidth)
-	binaryWrite(w, tglp.SheetSize)
-	binaryWrite(w, tglp.BaselinePosition)
-	binaryWrite(w, tglp.SheetImageFormat)
-	binaryWrite(w, tglp.NumOfColumns)
-	binaryWrite(w, tglp.NumOfRows)
-	binaryWrite(w, tglp.SheetWidth)
-	binaryWrite(w, tglp.SheetHeight)
-	binaryWrite(w, tglp.SheetDataOffset)
+func (tglp *TGLP) validateEncodedSize(size int) {
+	tglp.validation.assertEqual("encoded TGLP sheet data offset", int(tglp.SheetDataOffset), FFNT_HEADER_SIZE+FINF_HEADER_SIZE+TGLP_HEADER_SIZE+tglp.computePredataPadding())
+	tglp.validation.assertEqual("encoded TGLP section size", int(tglp.SectionSize), size)
+}
 
-	assertEqual(TGLP_HEADER_SIZE, len(buf.Bytes()))
-	return buf.Bytes()
+func (tglp *TGLP) EncodeHeader() []byte {
+	w := newSectionWriter("TGLP", TGLP_HEADER_SIZE)
+	w.magic(tglp.MagicHeader)
+	w.u32(tglp.SectionSize)
+	w.u8(tglp.CellWidth)
+	w.u8(tglp.CellHeight)
+	w.u8(tglp.NumOfSheets)
+	w.u8(tglp.MaxCharWidth)
+	w.u32(tglp.SheetSize)
+	w.u16(tglp.BaselinePosition)
+	w.u16(tglp.SheetImageFormat)
+	w.u16(tglp.NumOfColumns)
+	w.u16(tglp.NumOfRows)
+	w.u16(tglp.SheetWidth)
+	w.u16(tglp.SheetHeight)
+	w.u32(tglp.SheetDataOffset)
+	return w.mustBytes()
 }
 
 func (tglp *TGLP) computePredataPadding() int {
@@ -261,61 +560,85 @@ func (tglp *TGLP) EncodeBlankSheets() []byte {
 }
 
 func (tglp *TGLP) EncodeSheetData() []byte {
-	encodedSheetData := make([]byte, 0)
+	encodedSheetData := make([]byte, 0, len(tglp.SheetData)*int(tglp.SheetSize))
+	for i := range tglp.SheetData {
+		encodedSheetData = append(encodedSheetData, tglp.encodeSheet(i)...)
+	}
 
-	// looping through every sheet to swizzle
-	for i := 0; i < len(tglp.SheetData); i++ {
-		currentSheet := tglp.SheetData[i]
+	return encodedSheetData
+}
 
-		// Wii U stores image data upside down
-		img := imaging.FlipV(currentSheet.SubImage(currentSheet.Rect))
+// Converts and swizzles a single sheet of SheetData
+func (tglp *TGLP) encodeSheet(sheet int) []byte {
+	currentSheet := tglp.SheetData[sheet]
 
-		sheetData := make([]byte, tglp.SheetSize)
-		switch tglp.SheetImageFormat {
-		case 8:
-			// convert RGBA into alpha only image, discard unused bytes
-			for i := 0; i < len(sheetData); i++ {
-				sheetData[i] = img.Pix[4*i+3]
+	// Wii U stores image data upside down
+	img := imaging.FlipV(currentSheet.SubImage(currentSheet.Rect))
+
+	var sheetData []byte
+	switch tglp.SheetImageFormat {
+	case IMAGE_FORMAT_A8:
+		// convert RGBA into alpha only image, discard unused bytes
+		sheetData = make([]byte, tglp.SheetSize)
+		for i := 0; i < len(sheetData); i++ {
+			sheetData[i] = img.Pix[4*i+3]
+		}
+		break
+	case IMAGE_FORMAT_BC4:
+		// whole blocks of alpha, a sheet that isn't a multiple of 4
+		// pixels is padded with transparent pixels
+		blocksWide, blocksHigh, _, _ := tglp.sheetSurface()
+		alphaWidth := int(blocksWide) * 4
+		alpha := make([]byte, alphaWidth*int(blocksHigh)*4)
+		for y := 0; y < int(tglp.SheetHeight); y++ {
+			for x := 0; x < int(tglp.SheetWidth); x++ {
+				alpha[y*alphaWidth+x] = img.Pix[img.PixOffset(x, y)+3]
 			}
-			break
-		default:
-			panic(fmt.Sprintf("Unsupported image encoding for image format: %d", tglp.SheetImageFormat))
 		}
-
-		// swizzle the image
-		depth := uint(1)
-		sw := uint(tglp.SheetWidth)
-		sh := uint(tglp.SheetHeight)
-		format_ := uint(1)
-		aa := uint(0)
-		use := uint(2)
-		tileMode := uint(4)
-		swizzle_ := uint(0)
-		bpp := uint(8)
-		slice := uint(0)
-		sample := uint(0)
-		swizzledData := swizzle(sw, sh, depth, sh, format_, aa, use, tileMode, swizzle_, sw, bpp, slice, sample, sheetData)
-
-		// write swizzled sheet
-		encodedSheetData = append(encodedSheetData, swizzledData...)
+		sheetData = encodeBC4(alpha, int(blocksWide), int(blocksHigh), tglp.threads)
+	default:
+		panic(fmt.Sprintf("Unsupported image encoding for image format: %d", tglp.SheetImageFormat))
 	}
 
-	return encodedSheetData
+	// swizzle the image
+	depth := uint(1)
+	sw, sh, pitch, bpp := tglp.sheetSurface()
+	format_ := uint(1)
+	aa := uint(0)
+	use := uint(2)
+	tileMode := uint(4)
+	swizzle_ := uint(0)
+	slice := uint(sheet)
+	sample := uint(0)
+	return swizzle(sw, sh, depth, sh, format_, aa, use, tileMode, swizzle_, pitch, bpp, slice, sample, sheetData, tglp.threads)
 }
 
-func deswizzle(width uint, height uint, depth uint, height_ uint, format uint, aa uint, use uint, tileMode uint, swizzle_ uint, pitch uint, bpp uint, slice uint, sample uint, data []byte) []byte {
-	return swizzleSurface(width, height, depth, format, aa, use, tileMode, swizzle_, pitch, bpp, slice, sample, data, false)
+func deswizzle(width uint, height uint, depth uint, height_ uint, format uint, aa uint, use uint, tileMode uint, swizzle_ uint, pitch uint, bpp uint, slice uint, sample uint, data []byte, workers int) []byte {
+	return swizzleSurface(width, height, depth, format, aa, use, tileMode, swizzle_, pitch, bpp, slice, sample, data, false, workers)
 }
 
-func swizzle(width uint, height uint, depth uint, height_ uint, format uint, aa uint, use uint, tileMode uint, swizzle_ uint, pitch uint, bpp uint, slice uint, sample uint, data []byte) []byte {
-	return swizzleSurface(width, height, depth, format, aa, use, tileMode, swizzle_, pitch, bpp, slice, sample, data, true)
+func swizzle(width uint, height uint, depth uint, height_ uint, format uint, aa uint, use uint, tileMode uint, swizzle_ uint, pitch uint, bpp uint, slice uint, sample uint, data []byte, workers int) []byte {
+	return swizzleSurface(width, height, depth, format, aa, use, tileMode, swizzle_, pitch, bpp, slice, sample, data, true, workers)
 }
 
+// Rows of pixels one worker swizzles at a time. A 4096x4096 sheet is 64
+// tiles, a single core would otherwise be the slowest part of writing it.
+const SWIZZLE_TILE_ROWS = 64
+
 // Copied from KillzXGaming/Switch-Toolbox
 // KillzXGaming/Switch-Toolbox credits ____________
-func swizzleSurface(width uint, height uint, depth uint, format uint, aa uint, use uint, tileMode uint, swizzle_ uint, pitch uint, bpp uint, slice uint, sample uint, data []byte, swizzle bool) []byte {
+func swizzleSurface(width uint, height uint, depth uint, format uint, aa uint, use uint, tileMode uint, swizzle_ uint, pitch uint, bpp uint, slice uint, sample uint, data []byte, swizzle bool, workers int) []byte {
 	var bytesPerPixel uint = bpp / 8
-	result := make([]byte, len(data))
+
+	// The swizzled surface is padded to the pitch, the linear one is not.
+	linearSize := width * height * bytesPerPixel
+	swizzledSize := pitch * height * bytesPerPixel
+	var result []byte
+	if swizzle {
+		result = make([]byte, swizzledSize)
+	} else {
+		result = make([]byte, linearSize)
+	}
 
 	// uint pipeSwizzle, bankSwizzle, pos_;
 	// ulong pos;
@@ -340,33 +663,43 @@ func swizzleSurface(width uint, height uint, depth uint, format uint, aa uint, u
 	isDepth := false
 	// var numSamples uint = (uint)(1 << (int)(aa))
 
-	var swizzledPixelIndex uint
-
-	for y := uint(0); y < height; y++ {
-		for x := uint(0); x < width; x++ {
-			// if tileMode == 0 || tileMode == 1 {
-			// 	pos = computeSurfaceAddrFromCoordLinear((uint)x, (uint)y, slice, sample, bytesPerPixel, pitch, height, depth);
-			// 	panic("unsupported tile mode")
-			// } else if tileMode == 2 || tileMode == 3 {
-			// 	pos = computeSurfaceAddrFromCoordMicroTiled((uint)x, (uint)y, slice, bpp, pitch, height, (AddrTileMode)tileMode, IsDepth);
-			// 	panic("unsupported tile mode")
-			// } else {
-			// 	pos = computeSurfaceAddrFromCoordMacroTiled((uint)x, (uint)y, slice, sample, bpp, pitch, height, numSamples, (AddrTileMode)tileMode, IsDepth, pipeSwizzle, bankSwizzle);
-			swizzledPixelIndex = computeSwizzledPixelIndex(x, y, bpp, pitch, height, ADDR_TM_2D_TILED_THIN1, isDepth)
-			// }
-			var pixelIndex uint = (y*width + x) * bytesPerPixel
-			dataLen := (uint)(len(data))
-			if pixelIndex+bytesPerPixel <= dataLen && swizzledPixelIndex+bytesPerPixel <= dataLen {
+	dataLen := uint(len(data))
+
+	// every pixel's address only depends on its own coordinates, strips of
+	// rows are swizzled at the same time and never touch the same bytes
+	tiles := (height + SWIZZLE_TILE_ROWS - 1) / SWIZZLE_TILE_ROWS
+	parallelEach(int(tiles), workers, func(tile int) {
+		endRow := uint(tile+1) * SWIZZLE_TILE_ROWS
+		if endRow > height {
+			endRow = height
+		}
+		for y := uint(tile) * SWIZZLE_TILE_ROWS; y < endRow; y++ {
+			for x := uint(0); x < width; x++ {
+				// if tileMode == 0 || tileMode == 1 {
+				// 	pos = computeSurfaceAddrFromCoordLinear((uint)x, (uint)y, slice, sample, bytesPerPixel, pitch, height, depth);
+				// 	panic("unsupported tile mode")
+				// } else if tileMode == 2 || tileMode == 3 {
+				// 	pos = computeSurfaceAddrFromCoordMicroTiled((uint)x, (uint)y, slice, bpp, pitch, height, (AddrTileMode)tileMode, IsDepth);
+				// 	panic("unsupported tile mode")
+				// } else {
+				// 	pos = computeSurfaceAddrFromCoordMacroTiled((uint)x, (uint)y, slice, sample, bpp, pitch, height, numSamples, (AddrTileMode)tileMode, IsDepth, pipeSwizzle, bankSwizzle);
+				swizzledPixelIndex := computeSwizzledPixelIndex(x, y, slice, bpp, pitch, height, ADDR_TM_2D_TILED_THIN1, isDepth)
+				// }
+				var pixelIndex uint = (y*width + x) * bytesPerPixel
 				if swizzle {
 					// swizzle
-					result[swizzledPixelIndex] = data[pixelIndex]
+					if pixelIndex+bytesPerPixel <= dataLen && swizzledPixelIndex+bytesPerPixel <= swizzledSize {
+						copy(result[swizzledPixelIndex:swizzledPixelIndex+bytesPerPixel], data[pixelIndex:pixelIndex+bytesPerPixel])
+					}
 				} else {
 					// deswizzle
-					result[pixelIndex] = data[swizzledPixelIndex]
+					if swizzledPixelIndex+bytesPerPixel <= dataLen {
+						copy(result[pixelIndex:pixelIndex+bytesPerPixel], data[swizzledPixelIndex:swizzledPixelIndex+bytesPerPixel])
+					}
 				}
 			}
 		}
-	}
+	})
 
 	return result
 }
@@ -374,18 +707,17 @@ func swizzleSurface(width uint, height uint, depth uint, format uint, aa uint, u
 // computeSurfaceAddrFromCoordMacroTiled(uint x, uint y, uint slice, uint
 // sample, uint bpp, uint pitch, uint height, uint numSamples, AddrTileMode
 // tileMode, bool IsDepth, uint pipeSwizzle, uint bankSwizzle)
-func computeSwizzledPixelIndex(x uint, y uint, bpp uint, pitch uint, height uint, tileMode AddrTileMode, isDepth bool) uint {
+func computeSwizzledPixelIndex(x uint, y uint, slice uint, bpp uint, pitch uint, height uint, tileMode AddrTileMode, isDepth bool) uint {
 	var pipeSwizzle uint = 0
 	var bankSwizzle uint = 0
 	var numSamples uint = 1
 	var sample uint = 0
-	var slice uint = 0
 	var microTileThickness uint = computeSurfaceThickness(tileMode)
 
 	var microTileBits uint = numSamples * bpp * (microTileThickness * 64)
 	var microTileBytes uint = (microTileBits + 7) / 8
 
-	var pixelIndex uint = computePixelIndexWithinMicroTile(x, y, slice, bpp, tileMode, isDepth)
+	var pixelIndex uint = computePixelIndexWithinMicroTile(x, y, 0, bpp, tileMode, isDepth)
 	var bytesPerSample uint = microTileBytes / numSamples
 	var sampleOffset uint = 0
 	var pixelOffset uint = 0
@@ -438,7 +770,8 @@ func computeSwizzledPixelIndex(x uint, y uint, bpp uint, pitch uint, height uint
 	bank = bankPipe / 2
 
 	var sliceBytes uint = (height*pitch*microTileThickness*bpp*numSamples + 7) / 8
-	var sliceOffset uint = sliceBytes * (sampleSlice + numSampleSplits*slice) / microTileThickness
+	// every sheet is swizzled on its own, its data starts at its slice
+	var sliceOffset uint = sliceBytes * sampleSlice / microTileThickness
 
 	macroTilePitch, macroTileHeight := computeMacroPitchAndHeight(tileMode)
 
@@ -642,3 +975,23 @@ func computeMacroPitchAndHeight(tileMode AddrTileMode) (pitch uint, height uint)
 
 	return macroTilePitch, macroTileHeight
 }
+
+// Every cell in a sheet is separated by 1 pixel of padding on the left and
+// top. Returns the sheet a glyph index is drawn on and the rectangle of its
+// cell (padding excluded) within that sheet.
+func (tglp *TGLP) CellRect(glyphIndex int) (sheet int, rect image.Rectangle) {
+	realCellWidth := int(tglp.CellWidth) + 1
+	realCellHeight := int(tglp.CellHeight) + 1
+	cellsPerSheet := int(tglp.NumOfColumns) * int(tglp.NumOfRows)
+
+	sheet = glyphIndex / cellsPerSheet
+	cellIndex := glyphIndex % cellsPerSheet
+	column := cellIndex % int(tglp.NumOfColumns)
+	row := cellIndex / int(tglp.NumOfColumns)
+
+	x := realCellWidth*column + 1
+	y := realCellHeight*row + 1
+	rect = image.Rect(x, y, x+int(tglp.CellWidth), y+int(tglp.CellHeight))
+
+	return sheet, rect
+}
@@ -0,0 +1,399 @@
+package bffnt_headers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// One decoded field of a raw bffnt file and where it was read from
+type TraceLine struct {
+	Offset int
+	Field  string // unique name, e.g. "CWDH 0.glyph 12.CharWidth"
+	Value  string
+}
+
+func (line TraceLine) String() string {
+	return fmt.Sprintf("0x%06X  %-36s %s", line.Offset, line.Field, line.Value)
+}
+
+// Walks the raw bytes of a file and writes down every field with its offset.
+// It reads the bytes itself instead of going through Decode so that the trace
+// shows what is actually in the file, even when the sizes or offsets are off.
+// Sheet data is only traced by size, the encoder writes blank template sheets
+// so comparing the pixels of a re-encoded file is pointless.
+func Trace(raw []byte) (lines []TraceLine, err error) {
+	t := tracer{raw: raw, lines: make([]TraceLine, 0)}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("trace stopped: %v", r)
+		}
+		lines = t.lines
+	}()
+
+	t.traceFFNT()
+	cwdhOffset, cmapOffset := t.traceFINF()
+	t.traceTGLP()
+
+	// Kerning follows the section that ends last
+	sectionsEnd := TGLP_START + int(binary.BigEndian.Uint32(raw[TGLP_START+4:TGLP_START+8]))
+	reached := func(start int) {
+		if end := start + int(binary.BigEndian.Uint32(raw[start+4:start+8])); end > sectionsEnd {
+			sectionsEnd = end
+		}
+	}
+	for i := 0; cwdhOffset != 0; i++ {
+		start := int(cwdhOffset) - 8
+		cwdhOffset = t.traceCWDH(fmt.Sprintf("CWDH %d", i), start)
+		reached(start)
+	}
+	cmapCount := 0
+	for ; cmapOffset != 0; cmapCount++ {
+		start := int(cmapOffset) - 8
+		cmapOffset = t.traceCMAP(fmt.Sprintf("CMAP %d", cmapCount), start)
+		reached(start)
+	}
+	if cmapCount > 0 && sectionsEnd < len(raw) {
+		t.traceKRNG(sectionsEnd)
+	}
+
+	return t.lines, nil
+}
+
+type tracer struct {
+	raw   []byte
+	lines []TraceLine
+}
+
+func (t *tracer) add(offset int, field string, value interface{}) {
+	t.lines = append(t.lines, TraceLine{offset, field, fmt.Sprint(value)})
+}
+
+func (t *tracer) magic(offset int, field string) string {
+	value := string(t.raw[offset : offset+4])
+	t.add(offset, field, fmt.Sprintf("%q", value))
+	return value
+}
+
+func (t *tracer) u8(offset int, field string) uint8 {
+	value := t.raw[offset]
+	t.add(offset, field, value)
+	return value
+}
+
+func (t *tracer) i8(offset int, field string) int8 {
+	value := int8(t.raw[offset])
+	t.add(offset, field, value)
+	return value
+}
+
+func (t *tracer) u16(offset int, field string) uint16 {
+	value := binary.BigEndian.Uint16(t.raw[offset : offset+2])
+	t.add(offset, field, value)
+	return value
+}
+
+func (t *tracer) i16(offset int, field string) int16 {
+	value := int16(binary.BigEndian.Uint16(t.raw[offset : offset+2]))
+	t.add(offset, field, value)
+	return value
+}
+
+func (t *tracer) u32(offset int, field string) uint32 {
+	value := binary.BigEndian.Uint32(t.raw[offset : offset+4])
+	t.add(offset, field, value)
+	return value
+}
+
+// Bytes between the end of a section's data and the start of the next one.
+// Only non zero padding is interesting enough to show the bytes.
+func (t *tracer) padding(offset int, end int, field string) {
+	if end <= offset {
+		return
+	}
+	if allZeroBytes(t.raw[offset:end]) {
+		t.add(offset, field, fmt.Sprintf("%d zero bytes", end-offset))
+		return
+	}
+	t.add(offset, field, fmt.Sprintf("% X", t.raw[offset:end]))
+}
+
+func (t *tracer) traceFFNT() {
+	t.magic(0x00, "FFNT.MagicHeader")
+	t.u16(0x04, "FFNT.Endianness")
+	t.u16(0x06, "FFNT.SectionSize")
+	t.u32(0x08, "FFNT.Version")
+	t.u32(0x0C, "FFNT.TotalFileSize")
+	t.u32(0x10, "FFNT.BlockReadNum")
+}
+
+func (t *tracer) traceFINF() (cwdhOffset uint32, cmapOffset uint32) {
+	start := FFNT_HEADER_SIZE
+	t.magic(start+0x00, "FINF.MagicHeader")
+	t.u32(start+0x04, "FINF.SectionSize")
+	t.u8(start+0x08, "FINF.FontType")
+	t.u8(start+0x09, "FINF.Height")
+	t.u8(start+0x0A, "FINF.Width")
+	t.u8(start+0x0B, "FINF.Ascent")
+	t.u16(start+0x0C, "FINF.LineFeed")
+	t.u16(start+0x0E, "FINF.AlterCharIndex")
+	t.u8(start+0x10, "FINF.DefaultLeftWidth")
+	t.u8(start+0x11, "FINF.DefaultGlyphWidth")
+	t.u8(start+0x12, "FINF.DefaultCharWidth")
+	t.u8(start+0x13, "FINF.Encoding")
+	t.u32(start+0x14, "FINF.TGLPOffset")
+	cwdhOffset = t.u32(start+0x18, "FINF.CWDHOffset")
+	cmapOffset = t.u32(start+0x1C, "FINF.CMAPOffset")
+
+	return cwdhOffset, cmapOffset
+}
+
+func (t *tracer) traceTGLP() {
+	start := TGLP_START
+	t.magic(start+0x00, "TGLP.MagicHeader")
+	t.u32(start+0x04, "TGLP.SectionSize")
+	t.u8(start+0x08, "TGLP.CellWidth")
+	t.u8(start+0x09, "TGLP.CellHeight")
+	numOfSheets := t.u8(start+0x0A, "TGLP.NumOfSheets")
+	t.u8(start+0x0B, "TGLP.MaxCharWidth")
+	sheetSize := t.u32(start+0x0C, "TGLP.SheetSize")
+	t.u16(start+0x10, "TGLP.BaselinePosition")
+	t.u16(start+0x12, "TGLP.SheetImageFormat")
+	t.u16(start+0x14, "TGLP.NumOfColumns")
+	t.u16(start+0x16, "TGLP.NumOfRows")
+	t.u16(start+0x18, "TGLP.SheetWidth")
+	t.u16(start+0x1A, "TGLP.SheetHeight")
+	sheetDataOffset := int(t.u32(start+0x1C, "TGLP.SheetDataOffset"))
+
+	t.padding(start+TGLP_HEADER_SIZE, sheetDataOffset, "TGLP.padding")
+	for i := 0; i < int(numOfSheets); i++ {
+		offset := sheetDataOffset + i*int(sheetSize)
+		if offset+int(sheetSize) > len(t.raw) {
+			panic(fmt.Sprintf("sheet %d ends past the end of the file", i))
+		}
+		t.add(offset, fmt.Sprintf("TGLP.sheet %d", i), fmt.Sprintf("%d bytes", sheetSize))
+	}
+}
+
+func (t *tracer) traceCWDH(name string, start int) (nextOffset uint32) {
+	t.magic(start+0x00, name+".MagicHeader")
+	sectionSize := t.u32(start+0x04, name+".SectionSize")
+	startIndex := t.u16(start+0x08, name+".StartIndex")
+	endIndex := t.u16(start+0x0A, name+".EndIndex")
+	nextOffset = t.u32(start+0x0C, name+".NextCWDHOffset")
+
+	pos := start + CWDH_HEADER_SIZE
+	for index := int(startIndex); index <= int(endIndex); index++ {
+		glyph := fmt.Sprintf("%s.glyph %d", name, index)
+		t.i8(pos, glyph+".LeftWidth")
+		t.u8(pos+1, glyph+".GlyphWidth")
+		t.u8(pos+2, glyph+".CharWidth")
+		pos += 3
+	}
+	t.padding(pos, start+int(sectionSize), name+".padding")
+
+	return nextOffset
+}
+
+func (t *tracer) traceCMAP(name string, start int) (nextOffset uint32) {
+	t.magic(start+0x00, name+".MagicHeader")
+	sectionSize := t.u32(start+0x04, name+".SectionSize")
+	codeBegin := t.u16(start+0x08, name+".CodeBegin")
+	codeEnd := t.u16(start+0x0A, name+".CodeEnd")
+	mappingMethod := t.u16(start+0x0C, name+".MappingMethod")
+	t.u16(start+0x0E, name+".Reserved")
+	nextOffset = t.u32(start+0x10, name+".NextCMAPOffset")
+
+	// See CMAP.Decode for the mapping methods
+	pos := start + CMAP_HEADER_SIZE
+	switch mappingMethod {
+	case 0:
+		t.u16(pos, name+".CharacterOffset")
+		pos += 2
+	case 1:
+		for code := int(codeBegin); code <= int(codeEnd); code++ {
+			t.u16(pos, fmt.Sprintf("%s.%s", name, formatChar(uint16(code))))
+			pos += 2
+		}
+	case 2:
+		characterCount := t.u16(pos, name+".CharacterCount")
+		pos += 2
+		for i := 0; i < int(characterCount); i++ {
+			code := binary.BigEndian.Uint16(t.raw[pos : pos+2])
+			t.u16(pos+2, fmt.Sprintf("%s.%s", name, formatChar(code)))
+			pos += 4
+		}
+	default:
+		panic(fmt.Sprintf("unknown mapping method %d in %s", mappingMethod, name))
+	}
+	t.padding(pos, start+int(sectionSize), name+".padding")
+
+	return nextOffset
+}
+
+func (t *tracer) traceKRNG(start int) {
+	if string(t.raw[start:start+4]) != KRNG_MAGIC_HEADER {
+		t.padding(start, len(t.raw), "trailing bytes")
+		return
+	}
+	t.magic(start+0x00, "KRNG.MagicHeader")
+	sectionSize := t.u32(start+0x04, "KRNG.SectionSize")
+
+	data := start + KRNG_HEADER_SIZE
+	firstCharCount := t.u16(data, "KRNG.FirstCharCount")
+	end := data + 2 + int(firstCharCount)*4
+	for i := 0; i < int(firstCharCount); i++ {
+		entry := data + 2 + i*4
+		firstChar := binary.BigEndian.Uint16(t.raw[entry : entry+2])
+		pairArrayOffset := int(t.u16(entry+2, fmt.Sprintf("KRNG.%s.PairArrayOffset", formatChar(firstChar)))) * 2
+
+		pos := data + pairArrayOffset
+		pairCount := t.u16(pos, fmt.Sprintf("KRNG.%s.PairCount", formatChar(firstChar)))
+		pos += 2
+		for j := 0; j < int(pairCount); j++ {
+			secondChar := binary.BigEndian.Uint16(t.raw[pos : pos+2])
+			t.i16(pos+2, fmt.Sprintf("KRNG.%s %s", formatChar(firstChar), formatChar(secondChar)))
+			pos += 4
+		}
+		if pos > end {
+			end = pos
+		}
+	}
+	t.padding(end, start+int(sectionSize), "KRNG.padding")
+}
+
+// How two traces differ. Fields are matched by name, so a section that grew
+// or moved doesn't turn every later field into a difference.
+type TraceDiff struct {
+	Changed []string // fields with a different value
+	Missing []string // fields only in one of the traces
+	Shift   string   // first field that ended up at a different offset
+}
+
+func (diff TraceDiff) Empty() bool {
+	return len(diff.Changed) == 0 && len(diff.Missing) == 0 && diff.Shift == ""
+}
+
+func DiffTraces(a []TraceLine, b []TraceLine) TraceDiff {
+	var diff TraceDiff
+	bLines := make(map[string]TraceLine)
+	for _, line := range b {
+		bLines[line.Field] = line
+	}
+
+	aFields := make(map[string]bool)
+	for _, lineA := range a {
+		aFields[lineA.Field] = true
+		lineB, exists := bLines[lineA.Field]
+		if !exists {
+			diff.Missing = append(diff.Missing, fmt.Sprintf("only in a: %s", lineA))
+			continue
+		}
+		if lineA.Value != lineB.Value {
+			diff.Changed = append(diff.Changed, fmt.Sprintf("%s: %s (0x%06X) vs %s (0x%06X)",
+				lineA.Field, lineA.Value, lineA.Offset, lineB.Value, lineB.Offset))
+		}
+		if diff.Shift == "" && lineA.Offset != lineB.Offset {
+			diff.Shift = fmt.Sprintf("%s moved from 0x%06X to 0x%06X (%+d bytes)",
+				lineA.Field, lineA.Offset, lineB.Offset, lineB.Offset-lineA.Offset)
+		}
+	}
+	for _, lineB := range b {
+		if !aFields[lineB.Field] {
+			diff.Missing = append(diff.Missing, fmt.Sprintf("only in b: %s", lineB))
+		}
+	}
+
+	return diff
+}
+
+func writeTrace(filename string, lines []TraceLine, traceErr error) {
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString(line.String())
+		sb.WriteString("\n")
+	}
+	if traceErr != nil {
+		sb.WriteString(traceErr.Error())
+		sb.WriteString("\n")
+	}
+	handleErr(ioutil.WriteFile(filename, []byte(sb.String()), 0644))
+}
+
+// Decodes a font, encodes it again and compares the two files field by field.
+// Both traces are written next to the input so they can be attached to a bug
+// report or diffed with any other tool.
+func roundtripCommand(args []string) {
+	flags := newCommandFlagSet("roundtrip", "[flags] font.bffnt")
+	maxChanges := flags.Int("n", 20, "number of changed fields to print, 0 prints all")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		exitWithUsage(flags)
+	}
+
+	bffntFile := flags.Arg(0)
+	raw, err := ioutil.ReadFile(bffntFile)
+	handleErr(err)
+
+	bffnt := flags.newBffnt()
+	bffnt.Decode(raw)
+	encoded := bffnt.Encode()
+
+	originalTrace, originalErr := Trace(raw)
+	encodedTrace, encodedErr := Trace(encoded)
+	name := strings.TrimSuffix(bffntFile, filepath.Ext(bffntFile))
+	writeTrace(name+"_trace.txt", originalTrace, originalErr)
+	writeTrace(name+"_reencoded_trace.txt", encodedTrace, encodedErr)
+	fmt.Printf("wrote %s and %s\n", name+"_trace.txt", name+"_reencoded_trace.txt")
+	handleErr(originalErr)
+	handleErr(encodedErr)
+
+	diff := DiffTraces(originalTrace, encodedTrace)
+	if diff.Empty() && bytes.Equal(raw, encoded) {
+		fmt.Println("re-encoded font is byte identical")
+		return
+	}
+	identical := bytes.Equal(withoutSheetData(raw, bffnt.TGLP), withoutSheetData(encoded, bffnt.TGLP))
+	if diff.Empty() && identical {
+		fmt.Println("re-encoded font is byte identical apart from the sheet data")
+		return
+	}
+
+	if diff.Shift != "" {
+		fmt.Println("first shift:", diff.Shift)
+	}
+	for i, change := range diff.Changed {
+		if *maxChanges > 0 && i == *maxChanges {
+			fmt.Printf("... %d more\n", len(diff.Changed)-i)
+			break
+		}
+		fmt.Println("changed", change)
+	}
+	for _, missing := range diff.Missing {
+		fmt.Println(missing)
+	}
+	if diff.Empty() {
+		fmt.Println("all traced fields match but the bytes differ, check the padding of the sheet data")
+	}
+	exit(1)
+}
+
+// The encoder writes blank sheets, zero them in the original as well before
+// comparing bytes
+func withoutSheetData(raw []byte, tglp TGLP) []byte {
+	res := append([]byte{}, raw...)
+	start := int(tglp.SheetDataOffset)
+	end := start + int(tglp.SheetSize)*int(tglp.NumOfSheets)
+	if end > len(res) {
+		end = len(res)
+	}
+	for i := start; i < end; i++ {
+		res[i] = 0
+	}
+
+	return res
+}
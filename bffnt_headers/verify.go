@@ -0,0 +1,36 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Re-decodes freshly encoded bytes and compares them to the font they were
+// encoded from. Cheap insurance against encoder bugs ending up in a mod
+// release.
+func (b *BFFNT) VerifyEncoded(encodedRaw []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("encoded font could not be decoded again: %v", r)
+		}
+	}()
+
+	var decoded BFFNT
+	decoded.Decode(encodedRaw)
+
+	// The encoder only keeps the sheets of an untouched TGLP, otherwise it
+	// writes blank template sheets
+	expected := *b
+	expected.TGLP.AllSheetData = b.TGLP.encodedSheetData()
+
+	equal, differences := EqualFonts(&expected, &decoded)
+	if !equal {
+		lines := make([]string, 0, len(differences))
+		for _, difference := range differences {
+			lines = append(lines, difference.String())
+		}
+		return fmt.Errorf("encoded font does not match:\n  %s", strings.Join(lines, "\n  "))
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package bffnt_headers
+
+import (
+	"flag"
+	"runtime"
+	"sync"
+)
+
+// Registers the -threads flag which sets threads
+func threadsFlag(flags *flag.FlagSet, threads *int) {
+	flags.IntVar(threads, "threads", 0, "goroutines the worker pools may use together (batches, glyph rendering, swizzling). 0 uses GOMAXPROCS")
+}
+
+// Workers a pool gets. A pool's own setting, e.g. -workers for rendering,
+// wins over threads (-threads). 0 or less uses threads, and 0 threads uses
+// GOMAXPROCS.
+func workerCount(workers int, threads int) int {
+	if workers > 0 {
+		return workers
+	}
+	if threads > 0 {
+		return threads
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Splits a budget of workers between a pool of count items and the pools every
+// item runs on its own, so nesting them stays within the budget. e.g. 8
+// threads for 2 sheets gives 2 sheet workers with 4 tile workers each. 0 or
+// less budget uses GOMAXPROCS, both are at least 1.
+func splitWorkers(budget int, count int) (outer int, inner int) {
+	budget = workerCount(budget, 0)
+	outer = budget
+	if outer > count {
+		outer = count
+	}
+	if outer < 1 {
+		outer = 1
+	}
+	inner = budget / outer
+	if inner < 1 {
+		inner = 1
+	}
+	return outer, inner
+}
+
+// Calls work for every index in [0, count) with a pool of workers and waits
+// for all of them. Calls for different indexes run at the same time so work
+// may only write to state of its own index, results are put together in index
+// order afterwards so they don't depend on scheduling. A panic in work is
+// passed on to the caller once the other workers are done, like it was a plain
+// loop, so catchPanic and friends keep working. When several indexes panic
+// it's the lowest one, which is the one a plain loop would have stopped at.
+// 0 or less workers uses GOMAXPROCS, see workerCount for the settings.
+func parallelEach(count int, workers int, work func(i int)) {
+	workers = workerCount(workers, 0)
+	if workers > count {
+		workers = count
+	}
+
+	indexes := make(chan int, count)
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	var wg sync.WaitGroup
+	var panicMu sync.Mutex
+	panicIndex := -1
+	var panicValue interface{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			current := -1
+			defer func() {
+				if r := recover(); r != nil {
+					// indexes are handed out in order so every index below
+					// this one was already taken and runs to the end
+					panicMu.Lock()
+					if panicIndex < 0 || current < panicIndex {
+						panicIndex, panicValue = current, r
+					}
+					panicMu.Unlock()
+					// drain the queue so the other workers stop early
+					for range indexes {
+					}
+				}
+			}()
+			for current = range indexes {
+				work(current)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if panicIndex >= 0 {
+		panic(panicValue)
+	}
+}
@@ -0,0 +1,104 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Encodes a section straight into a buffer allocated for the size the section
+// will end up with. Values are written big endian by hand instead of through
+// binary.Write, which goes through reflection for every single value.
+//
+// The first thing that goes wrong is kept and every write after it is
+// skipped, so encoders write the whole section and check once with bytes.
+type sectionWriter struct {
+	section string // used in errors, e.g. "CWDH"
+	size    int
+	buf     []byte
+	err     error
+}
+
+func newSectionWriter(section string, size int) *sectionWriter {
+	return &sectionWriter{section: section, size: size, buf: make([]byte, 0, size)}
+}
+
+func (w *sectionWriter) fail(format string, args ...interface{}) {
+	if w.err == nil {
+		w.err = fmt.Errorf("encoding %s: %s", w.section, fmt.Sprintf(format, args...))
+	}
+}
+
+// Magic headers are always 4 bytes, a shorter one would shift every offset
+// after it.
+func (w *sectionWriter) magic(magic string) {
+	if len(magic) != 4 {
+		w.fail("magic header %q isn't 4 bytes", magic)
+	}
+	w.raw([]byte(magic))
+}
+
+func (w *sectionWriter) raw(data []byte) {
+	if w.err != nil {
+		return
+	}
+	if len(w.buf)+len(data) > w.size {
+		w.fail("writing %d byte(s) at %d overflows the %d byte section", len(data), len(w.buf), w.size)
+		return
+	}
+	w.buf = append(w.buf, data...)
+}
+
+func (w *sectionWriter) u8(v uint8) {
+	w.raw([]byte{v})
+}
+
+func (w *sectionWriter) i8(v int8) {
+	w.u8(uint8(v))
+}
+
+func (w *sectionWriter) u16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	w.raw(b[:])
+}
+
+func (w *sectionWriter) i16(v int16) {
+	w.u16(uint16(v))
+}
+
+func (w *sectionWriter) u32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.raw(b[:])
+}
+
+func (w *sectionWriter) zeros(n int) {
+	if w.err != nil {
+		return
+	}
+	if len(w.buf)+n > w.size {
+		w.fail("padding %d byte(s) at %d overflows the %d byte section", n, len(w.buf), w.size)
+		return
+	}
+	w.buf = appendZeros(w.buf, n)
+}
+
+// The encoded section, or the first error. A section that came out shorter
+// than it was sized for is an error too, the section size in its header
+// would be wrong.
+func (w *sectionWriter) bytes() ([]byte, error) {
+	if w.err == nil && len(w.buf) != w.size {
+		w.fail("wrote %d byte(s) of the %d byte section", len(w.buf), w.size)
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf, nil
+}
+
+// Same as bytes but panics on errors, like the rest of the encoders
+func (w *sectionWriter) mustBytes() []byte {
+	raw, err := w.bytes()
+	handleErr(err)
+	return raw
+}